@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// adminServer serves the operator's commands, on localhost or with a token, see AdminConfig.
+type adminServer struct {
+	l   *Listener
+	mux *http.ServeMux
+}
+
+func newAdminServer(l *Listener) *adminServer {
+	s := &adminServer{l: l, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/maintenance", s.handleMaintenance)
+	s.mux.HandleFunc("/operator", s.handleOperator)
+	s.mux.HandleFunc("/healthz", s.handleHealth)
+	s.mux.HandleFunc("/events", s.handleEvents)
+	return s
+}
+
+// serveAdmin serves the admin commands on admin.Addr until ctx is done.
+func serveAdmin(ctx context.Context, admin AdminConfig, l *Listener) {
+	srv := &http.Server{Addr: admin.Addr, Handler: admin.handler(newAdminServer(l).mux)}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Printf("%s admin listening on %s\n", ts(), admin.Addr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		fmt.Printf("%s ERR: admin: %v\n", ts(), err)
+	}
+}
+
+type maintenanceStatus struct {
+	On bool `json:"on"`
+	Maintenance
+}
+
+// handleMaintenance shows maintenance mode on GET. POST on=true|false turns it on or off,
+// reason and message override the -maintenance-reason and -maintenance-message defaults.
+func (s *adminServer) handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		on, err := strconv.ParseBool(r.FormValue("on"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("on: '%s' is not true or false", r.FormValue("on")), http.StatusBadRequest)
+			return
+		}
+
+		if !on {
+			s.l.SetMaintenance(nil)
+			break
+		}
+
+		m := s.l.maintenanceDefaults
+		if reason := r.FormValue("reason"); reason != "" {
+			m.Reason = reason
+		}
+		if _, ok := r.Form["message"]; ok {
+			m.Message = r.FormValue("message")
+		}
+		if !validDeclineReason(m.Reason) {
+			http.Error(w, fmt.Sprintf("reason: '%s' is not one of %v", m.Reason, declineReasons), http.StatusBadRequest)
+			return
+		}
+		s.l.SetMaintenance(&m)
+	default:
+		http.Error(w, "GET or POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	m, on := s.l.Maintenance()
+	writeJSON(w, maintenanceStatus{On: on, Maintenance: m})
+}
+
+type operatorStatus struct {
+	Game  string `json:"game"`
+	Move  string `json:"move,omitempty"`
+	Think string `json:"think,omitempty"`
+	FEN   string `json:"fen,omitempty"` // while it's our turn
+}
+
+// handleOperator shows the override of the game in progress on GET. POST move=SAN|UCI plays
+// the move next instead of the bot's, think=duration makes the engine search that long,
+// without either the override is cleared.
+func (s *adminServer) handleOperator(w http.ResponseWriter, r *http.Request) {
+	game := s.l.ActiveGame()
+	if game == nil {
+		http.Error(w, errNoActiveGame.Error(), http.StatusConflict)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var op Operator
+		op.Move = r.FormValue("move")
+		if text := r.FormValue("think"); text != "" {
+			think, err := time.ParseDuration(text)
+			if err != nil || think < 0 {
+				http.Error(w, fmt.Sprintf("think: '%s' is not a duration, e.g. 30s", text), http.StatusBadRequest)
+				return
+			}
+			op.Think = think
+		}
+		if err := game.SetOperator(op); err != nil {
+			http.Error(w, fmt.Sprintf("move: %v", err), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "GET or POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	op, turnFEN := game.Operator()
+	status := operatorStatus{Game: game.gameID, Move: op.Move, FEN: turnFEN}
+	if op.Think != 0 {
+		status.Think = op.Think.String()
+	}
+	writeJSON(w, status)
+}
+
+// handleHealth reports the streams, engine and active game, with a 503 while the bot isn't
+// OK so it can be used as a liveness check.
+func (s *adminServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	h := s.l.Health()
+	w.Header().Set("Content-Type", "application/json")
+	if !h.OK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	writeJSON(w, h)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Printf("%s ERR: admin: %v\n", ts(), err)
+	}
+}
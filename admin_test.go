@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"trollfish-lichess/eventbus"
+	"trollfish-lichess/fen"
+	"trollfish-lichess/storage"
+	"trollfish-lichess/yamlbook"
+)
+
+func TestAdmin_Maintenance(t *testing.T) {
+	// arrange
+	l := &Listener{maintenanceDefaults: Maintenance{Reason: "later", Message: "back soon"}}
+	srv := httptest.NewServer(newAdminServer(l).mux)
+	defer srv.Close()
+
+	post := func(values url.Values) (int, maintenanceStatus) {
+		resp, err := http.Post(srv.URL+"/maintenance", "application/x-www-form-urlencoded", strings.NewReader(values.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var status maintenanceStatus
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode, status
+	}
+
+	// act
+	code, on := post(url.Values{"on": {"true"}, "reason": {"generic"}})
+	badCode, _ := post(url.Values{"on": {"true"}, "reason": {"gone fishing"}})
+	_, off := post(url.Values{"on": {"false"}})
+
+	// assert
+	if code != http.StatusOK {
+		t.Fatalf("on: got status %d, want %d", code, http.StatusOK)
+	}
+	want := maintenanceStatus{On: true, Maintenance: Maintenance{Reason: "generic", Message: "back soon"}}
+	if on != want {
+		t.Errorf("on: got %+v, want %+v", on, want)
+	}
+	if badCode != http.StatusBadRequest {
+		t.Errorf("bad reason: got status %d, want %d", badCode, http.StatusBadRequest)
+	}
+	if off.On || l.inMaintenance() {
+		t.Errorf("off: got %+v, want off", off)
+	}
+}
+
+func TestAdmin_Events(t *testing.T) {
+	// arrange
+	l := &Listener{gameOpts: GameOptions{Bus: eventbus.New()}}
+	srv := httptest.NewServer(newAdminServer(l).mux)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// act
+	eventbus.Publish(l.gameOpts.Bus, ChallengeDeclined{ID: "abcd1234", User: "someone", Reason: "tooSlow"})
+	var line struct {
+		Type  string
+		Event ChallengeDeclined
+	}
+	err = json.NewDecoder(resp.Body).Decode(&line)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ChallengeDeclined{ID: "abcd1234", User: "someone", Reason: "tooSlow"}
+	if line.Type != "ChallengeDeclined" || line.Event != want {
+		t.Errorf("got %+v, want ChallengeDeclined %+v", line, want)
+	}
+}
+
+func TestAdmin_Operator(t *testing.T) {
+	// arrange
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	l := &Listener{}
+	srv := httptest.NewServer(newAdminServer(l).mux)
+	defer srv.Close()
+
+	post := func(values url.Values) (int, operatorStatus) {
+		resp, err := http.Post(srv.URL+"/operator", "application/x-www-form-urlencoded", strings.NewReader(values.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var status operatorStatus
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode, status
+	}
+
+	// act
+	noGameCode, _ := post(url.Values{"move": {"e4"}})
+
+	g := NewGame(ctx, "test", storage.NewMemory(), fakeEngine(ctx, nil), &yamlbook.Book{}, nil, GameOptions{}, nil)
+	defer g.Finish()
+	l.activeGame = g
+	g.setTurnFEN(startPosFEN)
+
+	code, status := post(url.Values{"move": {"e4"}, "think": {"20s"}})
+	illegalCode, _ := post(url.Values{"move": {"e5"}})
+	move := g.operatorMove(fen.FENtoBoard(startPosFEN), "d2d4")
+
+	// assert
+	if noGameCode != http.StatusConflict {
+		t.Errorf("no game: got status %d, want %d", noGameCode, http.StatusConflict)
+	}
+	if code != http.StatusOK {
+		t.Fatalf("e4: got status %d, want %d", code, http.StatusOK)
+	}
+	want := operatorStatus{Game: "test", Move: "e4", Think: "20s", FEN: startPosFEN}
+	if status != want {
+		t.Errorf("e4: got %+v, want %+v", status, want)
+	}
+	if illegalCode != http.StatusBadRequest {
+		t.Errorf("e5: got status %d, want %d", illegalCode, http.StatusBadRequest)
+	}
+	if move != "e2e4" {
+		t.Errorf("operator move: got %s, want e2e4", move)
+	}
+	if think := g.takeOperatorThink(); think != 20*time.Second {
+		t.Errorf("think: got %v, want 20s", think)
+	}
+}
+
+func TestAdmin_Health(t *testing.T) {
+	// arrange
+	l := &Listener{}
+	srv := httptest.NewServer(newAdminServer(l).mux)
+	defer srv.Close()
+
+	get := func() (int, healthStatus) {
+		resp, err := http.Get(srv.URL + "/healthz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var status healthStatus
+		if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, status
+	}
+
+	// act
+	downCode, down := get()
+	l.eventStream.connected()
+	upCode, up := get()
+	l.setDegraded(nil)
+	degradedCode, _ := get()
+
+	// assert
+	if downCode != http.StatusServiceUnavailable || down.OK || down.Events.Connected {
+		t.Errorf("disconnected: got status %d, %+v", downCode, down)
+	}
+	if upCode != http.StatusOK || !up.OK || !up.Engine.Alive || up.Game != nil {
+		t.Errorf("connected: got status %d, %+v", upCode, up)
+	}
+	if degradedCode != http.StatusServiceUnavailable {
+		t.Errorf("degraded: got status %d, want %d", degradedCode, http.StatusServiceUnavailable)
+	}
+}
+
+func TestAdminConfig_Check(t *testing.T) {
+	cases := []struct {
+		name    string
+		config  AdminConfig
+		want    string
+		wantErr bool
+	}{
+		{name: "off", config: AdminConfig{}, want: ""},
+		{name: "localhost", config: AdminConfig{Addr: "localhost:8089"}, want: "localhost:8089"},
+		{name: "port", config: AdminConfig{Addr: "8089"}, want: "localhost:8089"},
+		{name: "no host", config: AdminConfig{Addr: ":8089"}, want: "localhost:8089"},
+		{name: "loopback ip", config: AdminConfig{Addr: "127.0.0.1:8089"}, want: "127.0.0.1:8089"},
+		{name: "all interfaces", config: AdminConfig{Addr: "0.0.0.0:8089"}, wantErr: true},
+		{name: "lan", config: AdminConfig{Addr: "192.168.1.5:8089"}, wantErr: true},
+		{name: "lan with token", config: AdminConfig{Addr: "192.168.1.5:8089", Token: "secret"}, want: "192.168.1.5:8089"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// act
+			got, err := c.config.check()
+
+			// assert
+			if (err != nil) != c.wantErr {
+				t.Fatalf("err: got %v, want error %v", err, c.wantErr)
+			}
+			if err == nil && got.Addr != c.want {
+				t.Errorf("got '%s', want '%s'", got.Addr, c.want)
+			}
+		})
+	}
+}
+
+func TestAdminConfig_Handler(t *testing.T) {
+	cases := []struct {
+		name          string
+		token         string
+		authorization string
+		want          int
+	}{
+		{name: "no token", want: http.StatusOK},
+		{name: "token", token: "secret", authorization: "Bearer secret", want: http.StatusOK},
+		{name: "missing", token: "secret", want: http.StatusUnauthorized},
+		{name: "wrong", token: "secret", authorization: "Bearer guess", want: http.StatusUnauthorized},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			l := &Listener{maintenanceDefaults: Maintenance{Reason: "later"}}
+			h := AdminConfig{Addr: "localhost:8089", Token: c.token}.handler(newAdminServer(l).mux)
+			req := httptest.NewRequest(http.MethodGet, "/maintenance", nil)
+			if c.authorization != "" {
+				req.Header.Set("Authorization", c.authorization)
+			}
+			rec := httptest.NewRecorder()
+
+			// act
+			h.ServeHTTP(rec, req)
+
+			// assert
+			if rec.Code != c.want {
+				t.Errorf("got %d, want %d", rec.Code, c.want)
+			}
+		})
+	}
+}
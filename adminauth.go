@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// AdminConfig is where the admin servers listen, see serveAdmin and watchAnalysis, and the
+// token their requests need. They can move, resign and change settings, so without a token
+// they only listen on loopback.
+type AdminConfig struct {
+	Addr  string // host:port, a port alone listens on localhost, empty = off
+	Token string // 'Authorization: Bearer <token>' on every request, empty = none
+}
+
+// check returns c with the default host, localhost, and an error when it would listen on
+// other interfaces without a token.
+func (c AdminConfig) check() (AdminConfig, error) {
+	if c.Addr == "" {
+		return c, nil
+	}
+	host, port, err := net.SplitHostPort(c.Addr)
+	if err != nil {
+		// a port alone, e.g. 8089
+		host, port = "", c.Addr
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	c.Addr = net.JoinHostPort(host, port)
+
+	if c.Token == "" && !isLoopback(host) {
+		return c, fmt.Errorf("-admin '%s': listening beyond loopback needs -admin-token", c.Addr)
+	}
+	return c, nil
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// handler returns h, which with a token rejects requests that don't have it.
+func (c AdminConfig) handler(h http.Handler) http.Handler {
+	if c.Token == "" {
+		return h
+	}
+	want := []byte("Bearer " + c.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
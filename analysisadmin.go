@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"trollfish-lichess/analyze"
+	"trollfish-lichess/eventbus"
+)
+
+// analysisAdmin serves the control of a batch analysis, -update-book or -analyze-pgn: the
+// search's progress, and skipping, postponing or stopping after the position being searched.
+// Like adminServer it listens on localhost or needs a token, see AdminConfig.
+type analysisAdmin struct {
+	a   *analyze.Analyzer
+	mux *http.ServeMux
+}
+
+func newAnalysisAdmin(a *analyze.Analyzer) *analysisAdmin {
+	s := &analysisAdmin{a: a, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/events", s.handleEvents)
+	s.mux.HandleFunc("/position", s.handlePosition)
+	s.mux.HandleFunc("/abort", s.handleAbort)
+	s.mux.HandleFunc("/later", s.handleAbort)
+	s.mux.HandleFunc("/stop", s.handleStop)
+	return s
+}
+
+// analysisKeys are the lines read from a terminal's stdin during a batch analysis.
+const analysisKeys = "s = skip the position keeping its depth, l = analyze it later, q = stop after it"
+
+// watchAnalysis reads analysisKeys from stdin if it's a terminal, and publishes a's progress
+// on a new bus served on admin.Addr until ctx is done, unless it's empty.
+func watchAnalysis(ctx context.Context, admin AdminConfig, a *analyze.Analyzer) {
+	if fi, err := os.Stdin.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		fmt.Printf("%s keys (then enter): %s\n", ts(), analysisKeys)
+		go readAnalysisKeys(os.Stdin, a)
+	}
+	if admin.Addr == "" {
+		return
+	}
+	a.Bus = eventbus.New()
+
+	srv := &http.Server{Addr: admin.Addr, Handler: admin.handler(newAnalysisAdmin(a).mux)}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	go func() {
+		fmt.Printf("%s analysis admin listening on %s\n", ts(), admin.Addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			fmt.Printf("%s ERR: analysis admin: %v\n", ts(), err)
+		}
+	}()
+}
+
+// handleEvents streams the SearchProgress and SearchAborted of each position as ndjson.
+func (s *analysisAdmin) handleEvents(w http.ResponseWriter, r *http.Request) {
+	serveEvents(w, r, s.a.Bus)
+}
+
+// handlePosition shows the position being searched, fen is empty between positions.
+func (s *analysisAdmin) handlePosition(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, map[string]string{"fen": s.a.Current()})
+}
+
+// handleAbort stops the position being searched on POST, keeping the depths it finished,
+// or on /later dropping them to analyze it another time, see analyze.Analyzer.Postpone.
+// fen, if given, only aborts that position. It's 409 when there's nothing to abort.
+func (s *analysisAdmin) handleAbort(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST", http.StatusMethodNotAllowed)
+		return
+	}
+	fenPos := r.FormValue("fen")
+	later := r.URL.Path == "/later"
+	if !iif(later, s.a.Postpone, s.a.Abort)(fenPos) {
+		http.Error(w, "not searching that position", http.StatusConflict)
+		return
+	}
+	fmt.Printf("%s analysis admin: %s %s\n", ts(), iif(later, "postponing", "aborting"), iif(fenPos != "", fenPos, "the current position"))
+	writeJSON(w, map[string]bool{"aborted": true, "later": later})
+}
+
+// handleStop stops the batch after the current position on POST.
+func (s *analysisAdmin) handleStop(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST", http.StatusMethodNotAllowed)
+		return
+	}
+	s.a.StopAfter()
+	fmt.Printf("%s analysis admin: stopping after the current position\n", ts())
+	writeJSON(w, map[string]bool{"stopping": true})
+}
+
+// readAnalysisKeys applies the analysisKeys read from r until it's closed.
+func readAnalysisKeys(r io.Reader, a *analyze.Analyzer) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		switch key := strings.ToLower(strings.TrimSpace(scanner.Text())); key {
+		case "s":
+			fmt.Printf("%s %s\n", ts(), iif(a.Abort(""), "skipping the position", "no position to skip"))
+		case "l":
+			fmt.Printf("%s %s\n", ts(), iif(a.Postpone(""), "postponing the position", "no position to postpone"))
+		case "q":
+			a.StopAfter()
+			fmt.Printf("%s stopping after the current position\n", ts())
+		case "":
+		default:
+			fmt.Printf("%s unknown key '%s': %s\n", ts(), key, analysisKeys)
+		}
+	}
+}
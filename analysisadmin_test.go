@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"trollfish-lichess/analyze"
+)
+
+func TestAnalysisAdmin(t *testing.T) {
+	// arrange
+	a := analyze.New()
+	s := newAnalysisAdmin(a)
+
+	cases := []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodGet, "/position", http.StatusOK},
+		{http.MethodPost, "/abort", http.StatusConflict},
+		{http.MethodPost, "/later", http.StatusConflict},
+		{http.MethodGet, "/stop", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/stop", http.StatusOK},
+	}
+
+	for _, c := range cases {
+		// act
+		rec := httptest.NewRecorder()
+		s.mux.ServeHTTP(rec, httptest.NewRequest(c.method, c.path, nil))
+
+		// assert
+		if rec.Code != c.want {
+			t.Errorf("%s %s: got %d, want %d", c.method, c.path, rec.Code, c.want)
+		}
+	}
+	if !a.Stopping() {
+		t.Error("POST /stop: want the batch stopping")
+	}
+}
+
+func TestReadAnalysisKeys(t *testing.T) {
+	// arrange
+	a := analyze.New()
+
+	// act
+	readAnalysisKeys(strings.NewReader("s\nx\nQ\n"), a)
+
+	// assert
+	if !a.Stopping() {
+		t.Error("q: want the batch stopping")
+	}
+}
@@ -2,29 +2,43 @@ package analyze
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
+	"os"
 	"strings"
 	"sync"
 	"time"
 
+	"trollfish-lichess/eventbus"
 	"trollfish-lichess/fen"
+	"trollfish-lichess/progress"
+	"trollfish-lichess/uciproc"
+	"trollfish-lichess/wdl"
 	"trollfish-lichess/yamlbook"
 )
 
 const useFullResources = true
 const logEngineOutput = false
 
-const SyzygyPath = "/home/jud/projects/tablebases/3-4-5:/home/jud/projects/tablebases/wdl6:/home/jud/projects/tablebases/dtz6:/home/jud/projects/tablebases/7:/home/jud/projects/tablebases/dtz7" // TODO: get path from config file
+// SyzygyPath is passed to engines as the SyzygyPath option, unless empty.
+var SyzygyPath string
+
+// StockfishPath overrides where the analysis engine is found (see uciproc.Find).
+var StockfishPath string
 
 const startPosFEN = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
-const threads = 28
-const hashMemory = 98304
 
-// TODO: put in config
-const stockfishBinary = "/home/jud/projects/trollfish/stockfish/stockfish"
-const stockfishDir = "/home/jud/projects/trollfish/stockfish"
+// Resources are the engine's Threads and Hash, used when useFullResources is set.
+var Resources = uciproc.Auto(uciproc.Analysis)
+
+// legacyStockfish is where the analysis engine lived before it could be found on PATH.
+const legacyStockfish = "/home/jud/projects/trollfish/stockfish/stockfish"
+
+const (
+	engineStartTimeout = 2 * time.Minute  // uciok and hash/tablebase setup
+	engineReadyTimeout = 30 * time.Second // isready -> readyok
+)
 
 type AnalysisOptions struct {
 	MinDepth   int
@@ -34,6 +48,13 @@ type AnalysisOptions struct {
 	DepthDelta int
 	MultiPV    int
 	MinNodes   int
+
+	// ScoreDelta, if set, also requires the best move's score to stay within this many
+	// centipawns of the latest across the DepthDelta depths.
+	ScoreDelta int
+	// ClearMargin, if set, stops a stable search before MinTime and MinNodes when the best
+	// move is this many centipawns better than the second best.
+	ClearMargin int
 }
 
 // const Engine_Stockfish_15_NN_6e0680e = 1
@@ -47,6 +68,8 @@ func New() *Analyzer {
 		input:           make(chan string, 512),
 		output:          make(chan string, 512),
 		logEngineOutput: logEngineOutput,
+		Resources:       Resources,
+		Thresholds:      DefaultThresholds,
 	}
 }
 
@@ -56,6 +79,50 @@ type Analyzer struct {
 	output           chan string
 	stockfishStarted int64
 	logEngineOutput  bool
+
+	// Progress, if set, is told the engine's depth on each position analyzed.
+	Progress *progress.Progress
+
+	// Resources are the engine's Threads and Hash, defaulting to the package's Resources.
+	Resources uciproc.Resources
+
+	// EvalPGN, if set, is the file analyzed games are appended to instead of eval<unix time>.pgn.
+	EvalPGN string
+
+	// CriticalEPD, if set, is the file each analyzed game's critical positions are appended
+	// to, with the best move as bm.
+	CriticalEPD string
+
+	// CriticalReview queues each analyzed game's critical positions for book review.
+	CriticalReview bool
+
+	// Games selects the games AnalyzePGNFile analyzes, the zero Query is every game.
+	Games fen.Query
+
+	// Thresholds annotate analyzed games, defaulting to DefaultThresholds.
+	Thresholds Thresholds
+
+	// FromPly and ToPly, if set, are the first and last plies of each game AnalyzeGame
+	// analyzes, numbered from 1. The other moves are written to the eval PGN as they were.
+	FromPly int
+	ToPly   int
+
+	// Reannotate has AnalyzePGNFile keep the games' [%eval]s, see ReannotateGame.
+	Reannotate bool
+	// ReannotateSwing, if set, is the winning chances a move may lose by the PGN's evals
+	// before ReannotateGame re-analyzes it, defaulting to reannotateSwing.
+	ReannotateSwing float64
+
+	// Bus, if set, is where each position's SearchProgress is published, see Abort.
+	Bus *eventbus.Bus
+
+	watch searchWatch
+}
+
+// gameThresholds returns the thresholds for pgn's players' ratings.
+func (a *Analyzer) gameThresholds(pgn *fen.PGNGame) Thresholds {
+	t, _ := a.Thresholds.ForGame(pgn.WhiteElo, pgn.BlackElo)
+	return t
 }
 
 func (a *Analyzer) AnalyzePGNFile(ctx context.Context, opts AnalysisOptions, pgnFilename string, book *yamlbook.Book) error {
@@ -64,10 +131,30 @@ func (a *Analyzer) AnalyzePGNFile(ctx context.Context, opts AnalysisOptions, pgn
 		return err
 	}
 
-	for _, game := range db.Games {
-		if err := a.AnalyzeGame(ctx, opts, game, book); err != nil {
+	games := db.Select(a.Games)
+	if !a.Games.IsZero() {
+		logInfo(fmt.Sprintf("selected %d of %d games in %s", len(games), len(db.Games), pgnFilename))
+	}
+
+	a.Progress = progress.New("games", len(games))
+	defer func() { a.Progress = nil }()
+
+	for _, game := range games {
+		a.Progress.Start()
+		analyzeGame := a.AnalyzeGame
+		if a.Reannotate {
+			analyzeGame = a.ReannotateGame
+		}
+		if err := analyzeGame(ctx, opts, game, book); errors.Is(err, ErrAborted) || errors.Is(err, ErrPostponed) {
+			logInfo(fmt.Sprintf("game %d: %v, skipping the game", game.Index, err))
+		} else if err != nil {
 			return err
 		}
+		a.Progress.Done()
+		if a.Stopping() {
+			logInfo(fmt.Sprintf("stopping after game %d as asked", game.Index))
+			break
+		}
 	}
 
 	return nil
@@ -100,6 +187,12 @@ func (a *Analyzer) AnalyzeGame(ctx context.Context, opts AnalysisOptions, pgn *f
 		playerMoveUCI := pgn.Moves[i].UCI
 		playerMoveSAN := board.UCItoSAN(playerMoveUCI)
 
+		if !a.analyzesPly(i + 1) {
+			movesEval = append(movesEval, unanalyzedMove(i, playerMoveSAN, pgn.Moves[i], board.ActiveColor))
+			board.Moves(playerMoveUCI)
+			continue
+		}
+
 		player := board.ActiveColor
 		legalMoveCount := len(board.AllLegalMoves())
 
@@ -120,17 +213,19 @@ func (a *Analyzer) AnalyzeGame(ctx context.Context, opts AnalysisOptions, pgn *f
 
 		// per-ply debug output
 		if len(movesEval) > 0 {
-			pgn := evalToPGN(pgn, movesEval)
+			thresholds := a.gameThresholds(pgn)
+			pgn := evalToPGN(pgn, movesEval, a.Thresholds)
 			logMultiline(pgn)
-			//if err := ioutil.WriteFile("eval.pgn", []byte(pgn), 0644); err != nil {
+			//if err := os.WriteFile("eval.pgn", []byte(pgn), 0644); err != nil {
 			//	return err
 			//}
 
-			tbl := debugEvalTable(startPosFEN, movesEval)
+			tbl := debugEvalTable(startPosFEN, movesEval, thresholds)
 			logMultiline(tbl)
 		}
 
 		bookMoves, _ := book.Get(boardFEN)
+		inBook := bookMoves.ContainsSAN(playerMoveSAN)
 
 		diffTS := bookMoves.HaveDifferentTimestamps()
 		tooFewMoves := len(bookMoves) < 3 && len(bookMoves) != legalMoveCount
@@ -180,7 +275,8 @@ func (a *Analyzer) AnalyzeGame(ctx context.Context, opts AnalysisOptions, pgn *f
 			}
 
 			for _, eval := range evals {
-				logInfo(fmt.Sprintf("depth: %d move: %s global_cp: %4d global_mate: %4d", eval.Depth, eval.UCIMove, eval.GlobalCP(player), eval.GlobalMate(player)))
+				global := eval.POV(player).Global()
+				logInfo(fmt.Sprintf("depth: %d move: %s global_cp: %4d global_mate: %4d", eval.Depth, eval.UCIMove, global.CP, global.Mate))
 			}
 
 			if err := a.SaveEvalsToBook(book, boardFEN, evals); err != nil {
@@ -217,21 +313,17 @@ func (a *Analyzer) AnalyzeGame(ctx context.Context, opts AnalysisOptions, pgn *f
 		if playerMove == nil {
 			logInfo(fmt.Sprintf("playerMoveSAN: '%s' bestMove.Move: '%s'", playerMoveSAN, bestMove.Move))
 
-			// TODO: extract playerMove, bestMove from evals. may have gotten bestMove from analysis or book. handle both.
-			// TODO: make this work:
-			// evals, err = a.AnalyzePosition(ctx, opts, boardFEN, bestMove.UCIMove, playerMoveUCI)
+			// the book moves already have evals, only the played move needs one
 			logInfo(fmt.Sprintf("played move %s wasn't the best (best was %s) and eval not found in book. running engine to find player's move...", playerMoveSAN, bestMove.Move))
 
-			bookMovesPlusPlayerMoves := []string{playerMoveUCI}
-			bookMovesPlusPlayerMoves = append(bookMovesPlusPlayerMoves, bookMoves.UCIs()...)
-
-			evals, err := a.AnalyzePosition(ctx, opts, boardFEN, bookMovesPlusPlayerMoves...)
+			evals, err := a.AnalyzePosition(ctx, opts, boardFEN, playerMoveUCI)
 			if err != nil {
 				return err
 			}
 
 			for _, eval := range evals {
-				logInfo(fmt.Sprintf("depth: %d move: %s global_cp: %4d global_mate: %4d", eval.Depth, eval.UCIMove, eval.GlobalCP(player), eval.GlobalMate(player)))
+				global := eval.POV(player).Global()
+				logInfo(fmt.Sprintf("depth: %d move: %s global_cp: %4d global_mate: %4d", eval.Depth, eval.UCIMove, global.CP, global.Mate))
 			}
 
 			if err := a.SaveEvalsToBook(book, boardFEN, evals); err != nil {
@@ -262,6 +354,10 @@ func (a *Analyzer) AnalyzeGame(ctx context.Context, opts AnalysisOptions, pgn *f
 			SAN:      playerMoveSAN,
 			Eval:     bookMoveToEval(playerMove),
 			BestMove: bookMoveToEval(bestMove),
+			InBook:   inBook,
+		}
+		if second := secondBestMove(bookMoves, bestMove); second != nil {
+			newMove.SecondBest = &Eval{UCIMove: second.UCI(), CP: second.CP, Mate: second.Mate}
 		}
 
 		movesEval = append(movesEval, newMove)
@@ -277,22 +373,83 @@ func (a *Analyzer) AnalyzeGame(ctx context.Context, opts AnalysisOptions, pgn *f
 		board.Moves(playerMoveUCI)
 	}
 
-	evalPGN := evalToPGN(pgn, movesEval)
+	if err := a.finishGame(pgn, movesEval, book); err != nil {
+		return err
+	}
+
+	if wg != nil {
+		a.input <- "quit"
+
+		cancel()
+		wg.Wait()
+	}
+
+	return nil
+}
+
+// finishGame writes an analyzed game to the eval PGN, and its critical positions to
+// CriticalEPD or the book's review queue as set.
+func (a *Analyzer) finishGame(pgn *fen.PGNGame, movesEval Moves, book *yamlbook.Book) error {
+	evalPGN := evalToPGN(pgn, movesEval, a.Thresholds)
 	logMultiline(evalPGN)
 
-	tbl := debugEvalTable(startPosFEN, movesEval)
+	tbl := debugEvalTable(startPosFEN, movesEval, a.gameThresholds(pgn))
 	logMultiline(tbl)
 
-	if err := ioutil.WriteFile(fmt.Sprintf("eval%d.pgn", time.Now().Unix()), []byte(evalPGN), 0644); err != nil {
+	if err := a.saveEvalPGN(evalPGN); err != nil {
 		logMultiline(evalPGN)
 		log.Fatal(err)
 	}
 
-	if wg != nil {
-		a.input <- "quit"
+	if a.CriticalEPD != "" || a.CriticalReview {
+		critical := criticalMoments(pgn.SetupFEN, movesEval, a.gameThresholds(pgn).model())
+		logInfo(fmt.Sprintf("%d critical positions", len(critical)))
+		if a.CriticalEPD != "" {
+			if err := a.saveCritical(critical); err != nil {
+				return err
+			}
+		}
+		if a.CriticalReview && book != nil && len(critical) != 0 {
+			for _, c := range critical {
+				book.MarkForReview(c.FEN)
+			}
+			if err := book.Save(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
 
-		cancel()
-		wg.Wait()
+// analyzesPly reports whether ply, numbered from 1, is in the FromPly to ToPly range.
+func (a *Analyzer) analyzesPly(ply int) bool {
+	return ply >= a.FromPly && (a.ToPly == 0 || ply <= a.ToPly)
+}
+
+// unanalyzedMove returns the move at ply, from 0, of a game as the PGN has it.
+func unanalyzedMove(ply int, san string, move fen.PGNMove, color fen.Color) Move {
+	m := Move{Ply: ply, UCI: move.UCI, SAN: san, Unanalyzed: true}
+	if move.HasEval {
+		// the PGN's evals are white's, ours the mover's
+		score := fen.Global(move.CP, move.Mate).For(color)
+		m.Eval = Eval{UCIMove: move.UCI, CP: score.CP, Mate: score.Mate}
+	}
+	return m
+}
+
+func (a *Analyzer) saveEvalPGN(evalPGN string) error {
+	if a.EvalPGN == "" {
+		return os.WriteFile(fmt.Sprintf("eval%d.pgn", time.Now().Unix()), []byte(evalPGN), 0644)
+	}
+
+	fp, err := os.OpenFile(a.EvalPGN, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	if _, err := fmt.Fprintf(fp, "%s\n\n", strings.TrimSpace(evalPGN)); err != nil {
+		return fmt.Errorf("write file '%s': %v", a.EvalPGN, err)
 	}
 
 	return nil
@@ -307,15 +464,14 @@ func (a *Analyzer) AnalyzePosition(ctx context.Context, opts AnalysisOptions, fe
 		return nil, err
 	}
 
-	a.waitReady()
+	if err := a.waitReady(ctx); err != nil {
+		return nil, err
+	}
 	a.input <- fmt.Sprintf("position fen %s", fenPos)
 
-	var searchMoves []string
-	var evals Evals
-
-	evals, err = a.analyzePosition(ctx, opts, fenPos, moves)
+	evals, err := a.analyzePosition(ctx, opts, fenPos, moves)
 	if err != nil {
-		return nil, fmt.Errorf("searchmoves '%v': %v", searchMoves, err)
+		return nil, fmt.Errorf("searchmoves '%v': %w", moves, err)
 	}
 
 	if wg != nil {
@@ -336,11 +492,13 @@ func (a *Analyzer) analyzePosition(ctx context.Context, opts AnalysisOptions, fe
 		return nil, fmt.Errorf("TODO: position '%s' is already game over", fenPos)
 	}
 
+	moves, err := searchMoves(board, moves)
+	if err != nil {
+		return nil, err
+	}
+
 	var moveCount int
 	if len(moves) != 0 {
-		if len(moves) == 1 {
-			panic(fmt.Errorf("len(moves) = %d; most likely not intended. moves: %v", len(moves), moves))
-		}
 		moveCount = len(moves)
 		a.input <- fmt.Sprintf("setoption name MultiPV value %d", len(moves))
 		a.input <- fmt.Sprintf("go depth %d movetime %d searchmoves %s", opts.MaxDepth, opts.MaxTime.Milliseconds(), strings.Join(moves, " "))
@@ -350,7 +508,10 @@ func (a *Analyzer) analyzePosition(ctx context.Context, opts AnalysisOptions, fe
 		a.input <- fmt.Sprintf("go depth %d movetime %d", opts.MaxDepth, opts.MaxTime.Milliseconds())
 	}
 
-	evals := a.engineEvals(ctx, opts, fenPos, moveCount)
+	evals, aborted := a.engineEvals(ctx, opts, fenPos, moveCount)
+	if aborted == ErrPostponed || (aborted != nil && len(evals) == 0) {
+		return nil, fmt.Errorf("fen '%s': %w", fenPos, aborted)
+	}
 	if len(evals) == 0 {
 		return nil, fmt.Errorf("no evaluations returned for fen '%s'", fenPos)
 	}
@@ -370,40 +531,43 @@ func (a *Analyzer) analyzePosition(ctx context.Context, opts AnalysisOptions, fe
 	}
 	logInfo("")
 
-	for _, move := range moves {
-		var count int
-
-		maxDepth := 0
-		for i := 0; i < len(evals); i++ {
-			eval := evals[i]
+	logInfo("")
+	logInfo(fmt.Sprintf("%3d/%3d %3d. top_move: %-7s top_cp: %6d top_mate: %3d",
+		1, 1, 1, board.UCItoSAN(best.UCIMove), best.CP, best.Mate))
 
-			if eval.UCIMove != move {
-				continue
-			}
+	return evals, nil
+}
 
-			if eval.Depth > maxDepth {
-				maxDepth = eval.Depth
-			}
+// searchMoves returns moves without duplicates, checking each is legal on board. An engine
+// given an illegal searchmoves move searches every move instead, which would pass off the
+// best move's eval as the eval of the move asked about.
+func searchMoves(board fen.Board, moves []string) ([]string, error) {
+	if len(moves) == 0 {
+		return nil, nil
+	}
 
-			// keep only the last 5 depths
-			if count >= 5 {
-				evals = append(evals[:i], evals[i+1:]...)
-				i--
-				continue
-			}
+	legal := make(map[string]struct{})
+	for _, move := range board.AllLegalMoves() {
+		legal[move.UCI] = struct{}{}
+	}
 
-			count++
+	seen := make(map[string]struct{})
+	var result []string
+	for _, move := range moves {
+		if _, ok := legal[move]; !ok {
+			return nil, fmt.Errorf("searchmoves: '%s' is not a legal move in '%s'", move, board.FEN())
+		}
+		if _, ok := seen[move]; ok {
+			continue
 		}
+		seen[move] = struct{}{}
+		result = append(result, move)
 	}
 
-	logInfo("")
-	logInfo(fmt.Sprintf("%3d/%3d %3d. top_move: %-7s top_cp: %6d top_mate: %3d",
-		1, 1, 1, board.UCItoSAN(best.UCIMove), best.CP, best.Mate))
-
-	return evals, nil
+	return result, nil
 }
 
-func debugEvalTable(startFEN string, movesEval Moves) string {
+func debugEvalTable(startFEN string, movesEval Moves, thresholds Thresholds) string {
 	var sb strings.Builder
 	dbgBoard := fen.FENtoBoard(startFEN)
 
@@ -432,20 +596,13 @@ func debugEvalTable(startFEN string, movesEval Moves) string {
 		e2 := move.Eval
 
 		var annotation string
-		if !move.IsMate {
-			diff := diffWC(e2, e1)
-			if diff <= -0.3 {
-				annotation = "??" // $4
-			} else if diff <= -0.2 {
-				annotation = "?" // $2
-			} else if diff <= -0.1 {
-				annotation = "?!" // $6
-			}
+		if !move.IsMate && !move.Unanalyzed {
+			annotation, _ = thresholds.annotate(diffWC(thresholds.model(), e2, e1, wdl.Material(dbgBoard)))
 		}
 
 		sb.WriteString(fmt.Sprintf("%-7s%-2s %7s", move.SAN, annotation, move.Eval.String(color)))
 
-		if move.UCI != move.BestMove.UCIMove {
+		if !move.Unanalyzed && move.UCI != move.BestMove.UCIMove {
 			bestMoveSAN := dbgBoard.UCItoSAN(move.BestMove.UCIMove)
 			sb.WriteString(fmt.Sprintf(" / top: %-7s %7s", bestMoveSAN, move.BestMove.String(color)))
 		} else {
@@ -491,12 +648,14 @@ func (a *Analyzer) SaveEvalsToBook(book *yamlbook.Book, boardFEN string, evals [
 func evalsToBookMove(boardFEN string, engineID string, moveEval Eval, evals []Eval) *yamlbook.Move {
 	board := fen.FENtoBoard(boardFEN)
 
+	now := time.Now().Unix()
 	move := yamlbook.NewMove(boardFEN, yamlbook.Move{
 		Move:   board.UCItoSAN(moveEval.UCIMove),
 		CP:     moveEval.CP,
 		Mate:   moveEval.Mate,
-		TS:     time.Now().Unix(),
+		TS:     now,
 		Engine: &yamlbook.Engine{ID: engineID},
+		Source: &yamlbook.Source{Type: yamlbook.SourceEngine, AddedBy: engineID, Date: now},
 	})
 
 	for _, eval := range evals {
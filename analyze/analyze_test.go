@@ -0,0 +1,289 @@
+package analyze
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"trollfish-lichess/fen"
+	"trollfish-lichess/wdl"
+	"trollfish-lichess/yamlbook"
+)
+
+func TestSearchMoves(t *testing.T) {
+	// arrange
+	board := fen.FENtoBoard(startPosFEN)
+
+	// act
+	single, err := searchMoves(board, []string{"e2e4"})
+	deduped, dedupErr := searchMoves(board, []string{"e2e4", "d2d4", "e2e4"})
+	_, illegalErr := searchMoves(board, []string{"e2e5"})
+
+	// assert
+	if err != nil || len(single) != 1 || single[0] != "e2e4" {
+		t.Errorf("single: got %v %v", single, err)
+	}
+	if dedupErr != nil || len(deduped) != 2 {
+		t.Errorf("deduped: got %v %v", deduped, dedupErr)
+	}
+	if illegalErr == nil {
+		t.Error("illegal: want error")
+	}
+}
+
+func TestEvalToPGN_Phases(t *testing.T) {
+	// arrange
+	pgn := &fen.PGNGame{SetupFEN: "r1bqk3/8/7n/8/8/8/8/RNB1K3 w - - 0 1", Tags: fen.Tags{}}
+	move := func(ply int, uci, san string, inBook bool) Move {
+		eval := Eval{UCIMove: uci}
+		return Move{Ply: ply, UCI: uci, SAN: san, Eval: eval, BestMove: eval, InBook: inBook}
+	}
+	moves := Moves{
+		move(0, "c1h6", "Bxh6", true),
+		move(1, "d8d2", "Qd2+", false),
+		move(2, "b1d2", "Nxd2", false),
+	}
+
+	// act
+	got := evalToPGN(pgn, moves, DefaultThresholds)
+
+	// assert
+	want := []string{"Bxh6\n", "{ Endgame. }\n", "{ Out of book. }\n", "1. ... Qd2+\n", "Nxd2\n", "{ Tablebase position: 7 pieces. }\n"}
+	at := 0
+	for _, w := range want {
+		i := strings.Index(got[at:], w)
+		if i == -1 {
+			t.Fatalf("missing %q after offset %d:\n%s", w, at, got)
+		}
+		at += i + len(w)
+	}
+	if strings.Contains(got, "Middlegame") {
+		t.Errorf("the game starts in the middlegame, want no marker:\n%s", got)
+	}
+}
+
+func TestEvalToPGN_Unanalyzed(t *testing.T) {
+	// arrange
+	pgn := &fen.PGNGame{Tags: fen.Tags{}}
+	a := &Analyzer{FromPly: 2, ToPly: 2}
+	e5 := Eval{UCIMove: "e7e5", CP: 20}
+	moves := Moves{
+		unanalyzedMove(0, "e4", fen.PGNMove{UCI: "e2e4", CP: 30, HasEval: true}, fen.WhitePieces),
+		{Ply: 1, UCI: "e7e5", SAN: "e5", Eval: e5, BestMove: e5, InBook: true},
+		unanalyzedMove(2, "Nf3", fen.PGNMove{UCI: "g1f3"}, fen.WhitePieces),
+	}
+
+	// act
+	got := evalToPGN(pgn, moves, DefaultThresholds)
+
+	// assert
+	if a.analyzesPly(1) || !a.analyzesPly(2) || a.analyzesPly(3) {
+		t.Errorf("plies 1-3: got %v %v %v, want only 2", a.analyzesPly(1), a.analyzesPly(2), a.analyzesPly(3))
+	}
+	want := "1. e4\n    { [%eval 0.30] }\n1. ... e5\n    { [%eval -0.20] }\n2. Nf3\n*\n"
+	if !strings.HasSuffix(got, want) {
+		t.Errorf("got:\n%s\nwant it to end:\n%s", got, want)
+	}
+}
+
+func TestCriticalMoments(t *testing.T) {
+	// arrange
+	moves := Moves{
+		{Ply: 0, UCI: "e2e4", SAN: "e4", Eval: Eval{UCIMove: "e2e4", CP: 30}, BestMove: Eval{UCIMove: "e2e4", CP: 30}},
+		{Ply: 1, UCI: "g7g5", SAN: "g5", Eval: Eval{UCIMove: "g7g5", CP: -400}, BestMove: Eval{UCIMove: "e7e5", CP: -30}},
+		{Ply: 2, UCI: "d2d4", SAN: "d4", Eval: Eval{UCIMove: "d2d4", CP: 380}, BestMove: Eval{UCIMove: "d1h5", CP: 450},
+			SecondBest: &Eval{UCIMove: "d2d4", CP: -200}},
+		{Ply: 3, UCI: "f7f6", SAN: "f6", Eval: Eval{UCIMove: "f7f6", Mate: -1}, BestMove: Eval{UCIMove: "g8f6", CP: -500}},
+		{Ply: 4, UCI: "a2a3", SAN: "a3", Eval: Eval{UCIMove: "a2a3", CP: 500}, BestMove: Eval{UCIMove: "d1h5", Mate: 1}},
+	}
+
+	// act
+	got := criticalMoments(startPosFEN, moves, wdl.Lichess)
+
+	// assert
+	want := []struct {
+		ply    int
+		bm     string
+		reason string
+	}{
+		{1, "e5", "swing"},
+		{2, "Qh5", "only move"},
+		{3, "Nf6", "swing"},
+		{4, "Qh5#", "missed mate"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d positions, want %d: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		if got[i].Ply != w.ply || got[i].BestSAN != w.bm || got[i].Reason != w.reason {
+			t.Errorf("%d: got %+v, want %+v", i, got[i], w)
+		}
+	}
+	if epd := got[0].EPD(); epd != `rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq - bm e5; sm g5; c0 "swing";` {
+		t.Errorf("EPD: got %q", epd)
+	}
+}
+
+func TestPuzzle_Formats(t *testing.T) {
+	// arrange
+	p := Puzzle{
+		ID:      "abcd12343",
+		FEN:     "rnbqkbnr/pppp1ppp/8/4p3/4P3/5Q2/PPPP1PPP/RNB1KBNR b KQkq - 1 2",
+		Moves:   []string{"b8c6", "f1c4", "g8f6", "f3f7"},
+		GameURL: "https://lichess.org/abcd1234#3",
+		Mate:    true,
+	}
+
+	// act
+	csv := p.CSV()
+	pgn := p.PGN()
+
+	// assert
+	if want := "abcd12343," + p.FEN + ",b8c6 f1c4 g8f6 f3f7,,,,,mate,https://lichess.org/abcd1234#3,"; csv != want {
+		t.Errorf("CSV:\ngot  %s\nwant %s", csv, want)
+	}
+	if !strings.Contains(pgn, `[FEN "r1bqkbnr/pppp1ppp/2n5/4p3/4P3/5Q2/PPPP1PPP/RNB1KBNR w KQkq - 2 3"]`) {
+		t.Errorf("PGN FEN tag:\n%s", pgn)
+	}
+	if !strings.Contains(pgn, "3. Bc4 Nf6 4. Qxf7# *") {
+		t.Errorf("PGN moves:\n%s", pgn)
+	}
+}
+
+func TestUniqueMove(t *testing.T) {
+	// arrange
+	const material = 60
+	only := []Eval{{UCIMove: "d1h5", CP: 400}, {UCIMove: "d2d4", CP: 0}}
+	two := []Eval{{UCIMove: "d1h5", CP: 400}, {UCIMove: "d2d4", CP: 350}}
+
+	// act
+	_, onlyOK := uniqueMove(only, material)
+	_, twoOK := uniqueMove(two, material)
+
+	// assert
+	if !onlyOK {
+		t.Error("only: want unique")
+	}
+	if twoOK {
+		t.Error("two: want not unique")
+	}
+}
+
+func TestThresholds_ForGame(t *testing.T) {
+	// arrange
+	scaled := DefaultThresholds
+	scaled.EloScale = true
+
+	// act
+	strong, strongElo := scaled.ForGame(2500, 2300)
+	weak, weakElo := scaled.ForGame(1300, 1100)
+	unrated, _ := scaled.ForGame(1200, 0)
+	annotation, word := weak.annotate(-0.25)
+
+	// assert
+	if strongElo != 0 || strong.Blunder != 0.3 {
+		t.Errorf("2400: got %v scaled for %d, want unscaled", strong, strongElo)
+	}
+	if weakElo != 1200 || weak.String() != "?! 0.12 ? 0.25 ?? 0.38" {
+		t.Errorf("1200: got %v scaled for %d", weak, weakElo)
+	}
+	if unrated.Mistake != 0.2 {
+		t.Errorf("unrated: got %v, want unscaled", unrated)
+	}
+	if annotation != "?" || word != "Mistake" {
+		t.Errorf("-0.25 at 1200: got %s %s, want ? Mistake", annotation, word)
+	}
+	if got := weak.annotator(weakElo); got != "Stockfish 15, ?! 0.12 ? 0.25 ?? 0.38 for 1200 Elo" {
+		t.Errorf("annotator: got '%s'", got)
+	}
+	if got := DefaultThresholds.annotator(0); got != "Stockfish 15" {
+		t.Errorf("default annotator: got '%s'", got)
+	}
+}
+
+func TestIsSwing(t *testing.T) {
+	// arrange
+	const material = 60
+	equal := &fen.Score{Side: fen.WhitePieces}
+	whiteWins := &fen.Score{CP: 1500, Side: fen.WhitePieces}
+
+	cases := []struct {
+		name  string
+		prev  *fen.Score
+		after Eval // the mover's pov
+		color fen.Color
+		want  bool
+	}{
+		{"no previous eval", nil, Eval{CP: -900}, fen.WhitePieces, false},
+		{"small loss", equal, Eval{CP: -20}, fen.WhitePieces, false},
+		{"blunder", equal, Eval{CP: -400}, fen.WhitePieces, true},
+		{"already lost", whiteWins, Eval{CP: -2500}, fen.BlackPieces, false},
+		{"black throws a draw", equal, Eval{CP: -400}, fen.BlackPieces, true},
+		{"improvement", equal, Eval{CP: 300}, fen.BlackPieces, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// act
+			got := isSwing(wdl.Lichess, c.prev, c.after, c.color, material, reannotateSwing)
+
+			// assert
+			if got != c.want {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestThresholds_ForGameModel(t *testing.T) {
+	// arrange
+	defer func(saved wdl.Bands) { wdl.ByRating = saved }(wdl.ByRating)
+	var err error
+	if wdl.ByRating, err = wdl.ParseBands("lichess,2000:stockfish"); err != nil {
+		t.Fatal(err)
+	}
+	explicit := DefaultThresholds
+	explicit.Model = wdl.LichessV2
+
+	// act
+	strong, _ := DefaultThresholds.ForGame(2100, 2300)
+	weak, _ := DefaultThresholds.ForGame(1500, 0)
+	set, _ := explicit.ForGame(2100, 2300)
+
+	// assert
+	if strong.Model != wdl.Stockfish || weak.Model != wdl.Lichess || set.Model != wdl.LichessV2 {
+		t.Errorf("got %s %s %s, want stockfish lichess lichess-v2", strong.Model, weak.Model, set.Model)
+	}
+	if got := strong.annotator(0); got != "Stockfish 15, stockfish winning chances" {
+		t.Errorf("annotator: got '%s'", got)
+	}
+}
+
+func TestEvalsToBookMove_ReanalyzeKeepsStats(t *testing.T) {
+	// arrange
+	const startFEN = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq -"
+	book := yamlbook.New(filepath.Join(t.TempDir(), "book.yamlbook"))
+	explorer := &yamlbook.ExplorerStats{Games: 1000, White: 52, Draws: 30, Black: 18, Popularity: 40, TS: 1}
+	health := &yamlbook.Health{Score: 0.55, Leaves: 12, Playouts: 40, TS: 1}
+	book.Add(startFEN, &yamlbook.Move{Move: "e4", CP: 20, Weight: 3, Explorer: explorer, Health: health})
+	eval := Eval{UCIMove: "e2e4", Depth: 30, CP: 35, PV: []string{"e2e4", "e7e5"}}
+
+	// act
+	book.Add(startFEN, evalsToBookMove(startFEN, "trollfish", eval, []Eval{eval}))
+
+	// assert
+	moves, _ := book.Get(startFEN)
+	e4 := moves.GetSAN("e4")
+	if e4 == nil || e4.CP != 35 {
+		t.Fatalf("got %+v, want e4 with cp 35", e4)
+	}
+	if e4.Explorer != explorer {
+		t.Errorf("explorer: got %+v, want %+v", e4.Explorer, explorer)
+	}
+	if e4.Weight != 3 {
+		t.Errorf("weight: got %d, want 3", e4.Weight)
+	}
+	if e4.Health != health {
+		t.Errorf("health: got %+v, want %+v", e4.Health, health)
+	}
+}
@@ -21,4 +21,12 @@ type Move struct {
 	Eval     Eval   `json:"eval"`
 	BestMove Eval   `json:"best_move"`
 	IsMate   bool   `json:"mate,omitempty"`
+	InBook   bool   `json:"in_book,omitempty"` // the played move was in the book before the analysis
+
+	// Unanalyzed moves are outside the Analyzer's ply range, or kept as they were when
+	// re-annotating. Eval is the PGN's [%eval], if it had one, and BestMove is empty.
+	Unanalyzed bool `json:"unanalyzed,omitempty"`
+
+	// SecondBest is the best book move other than BestMove, if there is one.
+	SecondBest *Eval `json:"second_best,omitempty"`
 }
@@ -0,0 +1,109 @@
+package analyze
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"trollfish-lichess/fen"
+	"trollfish-lichess/wdl"
+	"trollfish-lichess/yamlbook"
+)
+
+const (
+	criticalSwing = 0.2 // winning chances lost by the played move
+	onlyMoveGap   = 0.3 // winning chances between the best and second best move
+)
+
+// Critical is a position from an analyzed game worth training on or reviewing.
+type Critical struct {
+	FEN       string
+	Ply       int
+	BestSAN   string
+	PlayedSAN string
+	Reason    string
+}
+
+// EPD returns the position as an EPD line with the best move as bm and the played move
+// as sm, e.g. '<fen> bm Nf3; sm h3; c0 "swing";'. It's written here rather than with
+// the epd package, which imports analyze.
+func (c Critical) EPD() string {
+	return fmt.Sprintf("%s bm %s; sm %s; c0 \"%s\";", fen.Key(c.FEN), c.BestSAN, c.PlayedSAN, c.Reason)
+}
+
+// criticalMoments returns the positions where the played move lost at least criticalSwing
+// winning chances, missed a mate, or where the best move was the only one that held.
+func criticalMoments(startFEN string, moves Moves, model wdl.Model) []Critical {
+	var list []Critical
+
+	board := fen.FENtoBoard(startFEN)
+	for _, move := range moves {
+		boardFEN := board.FEN()
+		material := wdl.Material(board)
+		played, best := move.Eval, move.BestMove
+
+		if !move.IsMate && best.UCIMove != "" {
+			var reasons []string
+			if best.Mate > 0 && played.Mate <= 0 {
+				reasons = append(reasons, "missed mate")
+			} else if played.UCIMove != best.UCIMove && diffWC(model, played, best, material) <= -criticalSwing {
+				reasons = append(reasons, "swing")
+			}
+			if move.SecondBest != nil && diffWC(model, best, *move.SecondBest, material) >= onlyMoveGap {
+				reasons = append(reasons, "only move")
+			}
+
+			if len(reasons) != 0 {
+				list = append(list, Critical{
+					FEN:       boardFEN,
+					Ply:       move.Ply,
+					BestSAN:   board.UCItoSAN(best.UCIMove),
+					PlayedSAN: move.SAN,
+					Reason:    strings.Join(reasons, ", "),
+				})
+			}
+		}
+
+		board.Moves(move.UCI)
+	}
+
+	return list
+}
+
+// saveCritical appends the critical positions to a.CriticalEPD.
+func (a *Analyzer) saveCritical(list []Critical) error {
+	var sb strings.Builder
+	for _, c := range list {
+		sb.WriteString(c.EPD())
+		sb.WriteByte('\n')
+	}
+
+	fp, err := os.OpenFile(a.CriticalEPD, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("'%s': %v", a.CriticalEPD, err)
+	}
+	defer fp.Close()
+
+	if _, err := fp.WriteString(sb.String()); err != nil {
+		return fmt.Errorf("write file '%s': %v", a.CriticalEPD, err)
+	}
+	return nil
+}
+
+// secondBestMove returns the highest scoring book move other than best, or nil.
+func secondBestMove(bookMoves yamlbook.Moves, best *yamlbook.Move) *yamlbook.Move {
+	var second *yamlbook.Move
+	for _, move := range bookMoves {
+		if move == best || move.Move == best.Move {
+			continue
+		}
+		if second == nil || bookScore(move) > bookScore(second) {
+			second = move
+		}
+	}
+	return second
+}
+
+func bookScore(move *yamlbook.Move) int {
+	return Eval{CP: move.CP, Mate: move.Mate}.Score()
+}
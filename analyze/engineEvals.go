@@ -3,19 +3,20 @@ package analyze
 import (
 	"context"
 	"fmt"
-	"sort"
 	"strings"
 	"time"
 
+	"trollfish-lichess/eventbus"
 	"trollfish-lichess/fen"
 )
 
-func (a *Analyzer) engineEvals(ctx context.Context, opts AnalysisOptions, fenPos string, moveCount int) []Eval {
+// engineEvals reads the engine's search of fenPos until it converges, times out or is
+// aborted, see Abort, and returns the final evals and why it was aborted, nil if it wasn't.
+func (a *Analyzer) engineEvals(ctx context.Context, opts AnalysisOptions, fenPos string, moveCount int) ([]Eval, error) {
 	start := time.Now()
 
-	var moves []Eval
+	evals := newEvalSet()
 
-	var maxDepth int
 	var stopped bool
 	var printEngineOutput bool
 
@@ -31,6 +32,12 @@ func (a *Analyzer) engineEvals(ctx context.Context, opts AnalysisOptions, fenPos
 	numberOfMoves := min(moveCount, len(board.AllLegalMoves()))
 	linesSincePrevDepthSeen := 0
 
+	a.Progress.ResetDepth()
+
+	abort := a.watch.start(fenPos)
+	defer a.watch.stop()
+	var aborted error
+
 loop:
 	for {
 		select {
@@ -55,7 +62,7 @@ loop:
 			logInfo(eval.AsLog(fenPos))
 
 			// annoying (but probably useful to UI) update of old depth
-			if eval.Depth < maxDepth {
+			if eval.Depth < evals.MaxDepth() {
 				if linesSincePrevDepthSeen == moveCount-1 {
 					logInfo("")
 				}
@@ -66,94 +73,57 @@ loop:
 			linesSincePrevDepthSeen++
 
 			minNodes = eval.Nodes
-			if eval.Depth > maxDepth {
-				if printEngineOutput {
-					logInfo(fmt.Sprintf("depth = %d", eval.Depth))
-				}
-				maxDepth = eval.Depth
+			if eval.Depth > evals.MaxDepth() && printEngineOutput {
+				logInfo(fmt.Sprintf("depth = %d", eval.Depth))
 			}
+			evals.Add(eval)
+			maxDepth := evals.MaxDepth()
+			a.Progress.Depth(maxDepth)
 
-			// remove evals at same depth + PV[0] with fewer nodes searched
-			for i := 0; i < len(moves); i++ {
-				if moves[i].Depth == eval.Depth && moves[i].UCIMove == eval.UCIMove {
-					if moves[i].Nodes <= eval.Nodes {
-						moves = append(moves[:i], moves[i+1:]...)
-						i--
-						continue
-					}
-				}
-			}
-
-			moves = append(moves, eval)
-
-			sort.Slice(moves, func(i, j int) bool {
-				if moves[i].Depth != moves[j].Depth {
-					return moves[i].Depth > moves[j].Depth
-				}
-
-				if moves[i].MultiPV != moves[j].MultiPV {
-					return moves[i].MultiPV < moves[j].MultiPV
-				}
-
-				if moves[i].Nodes != moves[j].Nodes {
-					return moves[i].Nodes > moves[j].Nodes
-				}
-
-				return moves[i].Time > moves[j].Time
-			})
-
-			var maxDepthMultiPVCount int
-			for i := 0; i < len(moves); i++ {
-				if moves[i].Depth == maxDepth && moves[i].Nodes == minNodes {
-					maxDepthMultiPVCount++
-				}
-			}
-			depthComplete := maxDepthMultiPVCount == numberOfMoves
+			depthComplete := evals.BatchAt(maxDepth, minNodes) == numberOfMoves
 			if depthComplete {
 				logInfo("") // blank line
+				if top, ok := evals.Top(maxDepth); ok {
+					a.publishProgress(fenPos, top, start)
+				}
 			}
 
 			// see if we've crossed the min-depth threshold
-			if depthComplete && eval.Depth == maxDepth && eval.Depth >= opts.MinDepth && eval.Time >= minTimeMS && eval.Nodes >= opts.MinNodes && len(moves) > 0 {
-				delta := 0
-				move := moves[0].UCIMove
-
-				curDepth := maxDepth
-				for i := 0; i < len(moves); i++ {
-					if moves[i].MultiPV == 1 && moves[i].Depth == curDepth && moves[i].Depth >= floorDepth {
-						if moves[i].UCIMove == move {
-							delta++
-							curDepth--
-							logInfo(fmt.Sprintf("depth_delta: %d/%d %s", delta, opts.DepthDelta, moves[i].AsLog(fenPos)))
-						} else {
-							logInfo(fmt.Sprintf("depth_delta: --- %s", moves[i].AsLog(fenPos)))
-							break
-						}
-
-						if opts.DepthDelta == delta {
-							break
+			if depthComplete && eval.Depth == maxDepth && eval.Depth >= opts.MinDepth {
+				longEnough := eval.Time >= minTimeMS && eval.Nodes >= opts.MinNodes
+				margin, hasMargin := evals.Margin(maxDepth)
+				clear := opts.ClearMargin > 0 && hasMargin && margin >= opts.ClearMargin
+
+				if longEnough || clear {
+					bestMove, _ := evals.Top(maxDepth)
+					delta := evals.Stable(maxDepth, floorDepth, opts.DepthDelta, opts.ScoreDelta)
+
+					if delta >= opts.DepthDelta {
+						if !longEnough {
+							logInfo(fmt.Sprintf("depth_delta: clear by %d cp, stopping early", margin))
 						}
+						logInfo(fmt.Sprintf("depth_delta: *** %s", bestMove.AsLog(fenPos)))
+						ignoreDepthsGreaterThan = bestMove.Depth
+						a.input <- "stop"
+					} else {
+						logInfo(fmt.Sprintf("depth_delta: %d<%d %s", delta, opts.DepthDelta, bestMove.AsLog(fenPos)))
 					}
+					logInfo(fmt.Sprintf("time: %v / %v", time.Since(start).Round(time.Second), opts.MaxTime))
+					logInfo("")
 				}
-
-				bestMove := moves[0]
-
-				if delta >= opts.DepthDelta {
-					logInfo(fmt.Sprintf("depth_delta: *** %s", bestMove.AsLog(fenPos)))
-					ignoreDepthsGreaterThan = bestMove.Depth
-					a.input <- "stop"
-				} else {
-					logInfo(fmt.Sprintf("depth_delta: %d<%d %s", delta, opts.DepthDelta, bestMove.AsLog(fenPos)))
-				}
-				logInfo(fmt.Sprintf("time: %v / %v", time.Since(start).Round(time.Second), opts.MaxTime))
-				logInfo("")
 			}
 
+		case <-abort:
+			abort, aborted = nil, a.watch.abortReason()
+			logInfo(fmt.Sprintf("%v at depth %d", aborted, evals.MaxDepth()))
+			eventbus.Publish(a.Bus, SearchAborted{FEN: fenPos, Depth: evals.MaxDepth(), Postponed: aborted == ErrPostponed})
+			ignoreDepthsGreaterThan = evals.MaxDepth()
+			a.input <- "stop"
 		case <-timeout.C:
-			if maxDepth == 0 {
-				return nil
+			if evals.MaxDepth() == 0 {
+				return nil, aborted
 			}
-			logInfo(fmt.Sprintf("per-move timeout expired (%v), using what we have at depth %d", opts.MaxTime, maxDepth))
+			logInfo(fmt.Sprintf("per-move timeout expired (%v), using what we have at depth %d", opts.MaxTime, evals.MaxDepth()))
 			a.input <- "stop"
 			stopped = true
 		}
@@ -166,30 +136,5 @@ loop:
 		}
 	}
 
-	// only take depths that have full results for when multipv > 1
-	depth1Count, depth2Count := 0, 0
-
-	for i := 0; i < len(moves); i++ {
-		if moves[i].Depth == maxDepth {
-			depth1Count++
-		}
-		if moves[i].Depth == maxDepth-1 {
-			depth2Count++
-		}
-	}
-
-	if depth1Count < depth2Count {
-		logInfo(fmt.Sprintf("depth: %d depth1Count (%d) < depth2Count (%d), using depth: %d", maxDepth, depth1Count, depth2Count, maxDepth-1))
-		maxDepth--
-	}
-
-	for i := 0; i < len(moves); i++ {
-		if moves[i].Depth > maxDepth {
-			moves = append(moves[:i], moves[i+1:]...)
-			i--
-			continue
-		}
-	}
-
-	return moves
+	return evals.Final(), aborted
 }
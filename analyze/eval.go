@@ -45,12 +45,9 @@ func (e Eval) Empty() bool {
 	return e.UCIMove == ""
 }
 
-func (e Eval) GlobalCP(color fen.Color) int {
-	return e.CP * int(color)
-}
-
-func (e Eval) GlobalMate(color fen.Color) int {
-	return e.Mate * int(color)
+// POV returns the eval's score, from the pov of the side to move in the position searched.
+func (e Eval) POV(toMove fen.Color) fen.Score {
+	return fen.POV(e.CP, e.Mate, toMove)
 }
 
 func (e Eval) AsLog(fenPos string) string {
@@ -89,11 +86,12 @@ func (e Eval) String(color fen.Color) string {
 		return ""
 	}
 
-	if e.Mate != 0 {
-		return fmt.Sprintf("#%d", e.GlobalMate(color))
+	score := e.POV(color).Global()
+	if score.Mate != 0 {
+		return fmt.Sprintf("#%d", score.Mate)
 	}
 
-	s := fmt.Sprintf("%.2f", float64(e.GlobalCP(color))/100)
+	s := fmt.Sprintf("%.2f", float64(score.CP)/100)
 
 	if s == "+0.00" || s == "-0.00" {
 		return "0.00"
@@ -102,7 +100,9 @@ func (e Eval) String(color fen.Color) string {
 	return s
 }
 
-func parseEval(line string) Eval {
+// ParseEval parses an 'info ... score' line. Unlike the analyzer's own parsing it returns an
+// error rather than exiting on tokens it doesn't know, so it's safe to use during a game.
+func ParseEval(line string) (Eval, error) {
 	eval := Eval{Raw: line}
 
 	parts := strings.Split(line, " ")
@@ -128,7 +128,7 @@ scoreLoop:
 				eval.Mate = atoi(parts[i+2])
 				inc++
 			default:
-				log.Fatalf("unhandled: 'info ... score %s'", p2)
+				return eval, fmt.Errorf("unhandled: 'info ... score %s'", p2)
 			}
 		case "upperbound":
 			eval.UpperBound = true
@@ -152,11 +152,19 @@ scoreLoop:
 			eval.UCIMove = pvMoves[0]
 			break scoreLoop
 		default:
-			log.Fatalf("unhandled: 'info ... %s'", p)
+			return eval, fmt.Errorf("unhandled: 'info ... %s'", p)
 		}
 
 		i += inc
 	}
 
+	return eval, nil
+}
+
+func parseEval(line string) Eval {
+	eval, err := ParseEval(line)
+	if err != nil {
+		log.Fatal(err)
+	}
 	return eval
 }
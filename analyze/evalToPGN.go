@@ -5,11 +5,14 @@ import (
 	"strings"
 
 	"trollfish-lichess/fen"
+	"trollfish-lichess/wdl"
 )
 
-func evalToPGN(pgn *fen.PGNGame, movesEval Moves) string {
+func evalToPGN(pgn *fen.PGNGame, movesEval Moves, thresholds Thresholds) string {
 	var sb strings.Builder
 
+	thresholds, scaledElo := thresholds.ForGame(pgn.WhiteElo, pgn.BlackElo)
+
 	sb.WriteString(fmt.Sprintf("[Event \"%s\"]\n", pgn.Tags["Event"]))
 	sb.WriteString(fmt.Sprintf("[Site \"%s\"]\n", pgn.Tags["Site"]))
 	sb.WriteString(fmt.Sprintf("[Date \"%s\"]\n", pgn.Tags["Date"]))
@@ -25,15 +28,31 @@ func evalToPGN(pgn *fen.PGNGame, movesEval Moves) string {
 		sb.WriteString("[Setup \"1\"]\n")
 	}
 
-	sb.WriteString(fmt.Sprintf("[Annotator \"Stockfish 15\"]\n"))
+	sb.WriteString(fmt.Sprintf("[Annotator \"%s\"]\n", thresholds.annotator(scaledElo)))
 	sb.WriteString("\n")
 
 	board := fen.FENtoBoard(pgn.SetupFEN)
 	prevEval := "0.24"
+	curPhase := gamePhase(board)
+	wasInBook := false
+	inTablebase := board.PieceCount() <= tablebasePieces
 	for _, move := range movesEval {
 		moveNumber := board.FullMove
 		color := board.ActiveColor
 
+		// phase changes and leaving book are marked before the move, entering a tablebase
+		// position after it
+		if p := gamePhase(board); p > curPhase {
+			curPhase = p
+			sb.WriteString(fmt.Sprintf("{ %s. }\n", p))
+		}
+		if !move.Unanalyzed {
+			if wasInBook && !move.InBook {
+				sb.WriteString("{ Out of book. }\n")
+			}
+			wasInBook = move.InBook
+		}
+
 		var englishColor string
 		if color == fen.WhitePieces {
 			sb.WriteString(fmt.Sprintf("%d. ", moveNumber))
@@ -43,6 +62,18 @@ func evalToPGN(pgn *fen.PGNGame, movesEval Moves) string {
 			englishColor = "Black"
 		}
 
+		if move.Unanalyzed {
+			// as the PGN had it
+			sb.WriteString(move.SAN + "\n")
+			if !move.Eval.Empty() {
+				sb.WriteString(fmt.Sprintf("    { [%%eval %s] }\n", move.Eval.String(color)))
+				prevEval = move.Eval.String(color)
+			}
+			board.Moves(move.UCI)
+			inTablebase = inTablebase || board.PieceCount() <= tablebasePieces
+			continue
+		}
+
 		bestMove := move.BestMove
 		playedMove := move.Eval
 
@@ -53,21 +84,14 @@ func evalToPGN(pgn *fen.PGNGame, movesEval Moves) string {
 		var annotation, annotationWord string
 		var showVariations bool
 		if !move.IsMate && bestMove.UCIMove != "" {
-			diff := diffWC(playedMove, bestMove)
-			if diff <= -0.3 {
-				annotation = "??" // $4
-				annotationWord = "Blunder"
+			diff := diffWC(thresholds.model(), playedMove, bestMove, wdl.Material(board))
+			annotation, annotationWord = thresholds.annotate(diff)
+			if annotation == "??" {
 				if bestMove.Mate > 0 && playedMove.Mate <= 0 {
 					annotationWord = "Lost forced checkmate sequence"
 				} else if bestMove.Mate == 0 && playedMove.Mate < 0 {
 					annotationWord = "Checkmate is now unavoidable"
 				}
-			} else if diff <= -0.2 {
-				annotation = "?" // $2
-				annotationWord = "Mistake"
-			} else if diff <= -0.1 {
-				annotation = "?!" // $6
-				annotationWord = "Inaccuracy"
 			}
 
 			showVariations = diff <= -0.02
@@ -103,6 +127,11 @@ func evalToPGN(pgn *fen.PGNGame, movesEval Moves) string {
 		}
 		board.Moves(move.UCI)
 
+		if !inTablebase && board.PieceCount() <= tablebasePieces {
+			inTablebase = true
+			sb.WriteString(fmt.Sprintf("    { Tablebase position: %d pieces. }\n", board.PieceCount()))
+		}
+
 		prevEval = move.Eval.String(color)
 	}
 	sb.WriteString(fmt.Sprintf("%s\n", pgn.Result))
@@ -0,0 +1,209 @@
+package analyze
+
+import "sort"
+
+// keepDepths is the number of depths kept per move in the evals returned by an analysis.
+const keepDepths = 5
+
+// evalSet collects an engine's 'info ... score' lines per move and depth. For each move and
+// depth it keeps the most searched line; a later line at the same depth whose PV is a cut
+// short copy of the one kept (as engines print after 'stop') keeps the longer PV.
+type evalSet struct {
+	moves    map[string]map[int]Eval // move -> depth -> eval
+	maxDepth int
+}
+
+func newEvalSet() *evalSet {
+	return &evalSet{moves: make(map[string]map[int]Eval)}
+}
+
+// Add records eval, replacing the move's line at the same depth if eval searched as many
+// nodes or more.
+func (s *evalSet) Add(eval Eval) {
+	depths, ok := s.moves[eval.UCIMove]
+	if !ok {
+		depths = make(map[int]Eval)
+		s.moves[eval.UCIMove] = depths
+	}
+
+	if prev, ok := depths[eval.Depth]; ok {
+		if prev.Nodes > eval.Nodes {
+			return
+		}
+		if len(eval.PV) < len(prev.PV) && isPrefix(eval.PV, prev.PV) {
+			eval.PV = prev.PV
+		}
+	}
+
+	depths[eval.Depth] = eval
+	if eval.Depth > s.maxDepth {
+		s.maxDepth = eval.Depth
+	}
+}
+
+// MaxDepth is the deepest depth seen.
+func (s *evalSet) MaxDepth() int {
+	return s.maxDepth
+}
+
+// CountAt returns the number of moves with a line at depth.
+func (s *evalSet) CountAt(depth int) int {
+	count := 0
+	for _, depths := range s.moves {
+		if _, ok := depths[depth]; ok {
+			count++
+		}
+	}
+	return count
+}
+
+// BatchAt returns the number of moves whose line at depth searched exactly nodes. The lines
+// of one MultiPV report share a node count, so this counts the lines of that report.
+func (s *evalSet) BatchAt(depth, nodes int) int {
+	count := 0
+	for _, depths := range s.moves {
+		if eval, ok := depths[depth]; ok && eval.Nodes == nodes {
+			count++
+		}
+	}
+	return count
+}
+
+// Top returns the MultiPV 1 line at depth.
+func (s *evalSet) Top(depth int) (Eval, bool) {
+	var top Eval
+	found := false
+	for _, depths := range s.moves {
+		eval, ok := depths[depth]
+		if !ok || eval.MultiPV > 1 {
+			continue
+		}
+		if !found || eval.Nodes > top.Nodes {
+			top, found = eval, true
+		}
+	}
+	return top, found
+}
+
+// Second returns the MultiPV 2 line at depth.
+func (s *evalSet) Second(depth int) (Eval, bool) {
+	var second Eval
+	found := false
+	for _, depths := range s.moves {
+		eval, ok := depths[depth]
+		if !ok || eval.MultiPV != 2 {
+			continue
+		}
+		if !found || eval.Nodes > second.Nodes {
+			second, found = eval, true
+		}
+	}
+	return second, found
+}
+
+// Stable returns the number of consecutive depths, from depth down to floorDepth and at most
+// depthDelta, whose best move is the best move at depth. If scoreDelta is set the best move's
+// score at each of those depths must also be within scoreDelta of its score at depth.
+func (s *evalSet) Stable(depth, floorDepth, depthDelta, scoreDelta int) int {
+	best, ok := s.Top(depth)
+	if !ok {
+		return 0
+	}
+
+	count := 0
+	for d := depth; d >= floorDepth && count < depthDelta; d-- {
+		top, ok := s.Top(d)
+		if !ok || top.UCIMove != best.UCIMove {
+			break
+		}
+		if scoreDelta > 0 && abs(top.Score()-best.Score()) > scoreDelta {
+			break
+		}
+		count++
+	}
+	return count
+}
+
+// Margin returns how much better the best move is than the second best at depth.
+func (s *evalSet) Margin(depth int) (int, bool) {
+	top, ok := s.Top(depth)
+	if !ok {
+		return 0, false
+	}
+	second, ok := s.Second(depth)
+	if !ok {
+		return 0, false
+	}
+	return top.Score() - second.Score(), true
+}
+
+// Evals returns the lines at depth and below, keeping the deepest keepDepths lines of each
+// move, sorted deepest first, then by MultiPV, nodes and time.
+func (s *evalSet) Evals(depth int) []Eval {
+	var evals []Eval
+	for _, depths := range s.moves {
+		var keys []int
+		for d := range depths {
+			if d <= depth {
+				keys = append(keys, d)
+			}
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(keys)))
+		if len(keys) > keepDepths {
+			keys = keys[:keepDepths]
+		}
+		for _, d := range keys {
+			evals = append(evals, depths[d])
+		}
+	}
+
+	sortEvals(evals)
+	return evals
+}
+
+// Final returns the lines of the analysis: below the deepest depth if that depth has fewer
+// moves than the one before it, which happens when a search is stopped part way through.
+func (s *evalSet) Final() []Eval {
+	depth := s.maxDepth
+	if s.CountAt(depth) < s.CountAt(depth-1) {
+		depth--
+	}
+	return s.Evals(depth)
+}
+
+func sortEvals(evals []Eval) {
+	sort.Slice(evals, func(i, j int) bool {
+		if evals[i].Depth != evals[j].Depth {
+			return evals[i].Depth > evals[j].Depth
+		}
+		if evals[i].MultiPV != evals[j].MultiPV {
+			return evals[i].MultiPV < evals[j].MultiPV
+		}
+		if evals[i].Nodes != evals[j].Nodes {
+			return evals[i].Nodes > evals[j].Nodes
+		}
+		if evals[i].Time != evals[j].Time {
+			return evals[i].Time > evals[j].Time
+		}
+		return evals[i].UCIMove < evals[j].UCIMove
+	})
+}
+
+func isPrefix(a, b []string) bool {
+	if len(a) > len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,129 @@
+package analyze
+
+import (
+	"strings"
+	"testing"
+)
+
+// captured from stockfish 15, MultiPV 2, with the search stopped during depth 23
+const testEngineLog = `info depth 20 seldepth 27 multipv 1 score cp 35 nodes 3013213 nps 1391065 hashfull 800 tbhits 0 time 2166 pv e2e4 e7e5 g1f3 b8c6 f1b5 g8f6 e1g1 f6e4
+info depth 20 seldepth 29 multipv 2 score cp 28 nodes 3013213 nps 1391065 hashfull 800 tbhits 0 time 2166 pv d2d4 g8f6 c2c4 e7e6 g1f3 d7d5
+info depth 21 seldepth 30 multipv 1 score cp 31 upperbound nodes 3900213 nps 1399965 hashfull 850 tbhits 0 time 2786 pv e2e4 e7e5
+info depth 21 seldepth 30 multipv 1 score cp 33 nodes 4523118 nps 1401031 hashfull 870 tbhits 0 time 3228 pv e2e4 e7e5 g1f3 b8c6 f1b5 a7a6 b5a4 g8f6
+info depth 21 seldepth 27 multipv 2 score cp 30 nodes 4523118 nps 1401031 hashfull 870 tbhits 0 time 3228 pv d2d4 g8f6 c2c4 e7e6 g1f3 d7d5 b1c3
+info depth 22 seldepth 31 multipv 1 score cp 32 nodes 6684009 nps 1409067 hashfull 920 tbhits 0 time 4743 pv d2d4 g8f6 c2c4 e7e6 g1f3 d7d5 b1c3 f8e7
+info depth 22 seldepth 30 multipv 2 score cp 30 nodes 6684009 nps 1409067 hashfull 920 tbhits 0 time 4743 pv e2e4 e7e5 g1f3 b8c6 f1b5 a7a6
+info depth 22 seldepth 30 multipv 2 score cp 30 nodes 6690000 nps 1409067 hashfull 920 tbhits 0 time 4748 pv e2e4 e7e5
+info depth 23 seldepth 31 multipv 1 score cp 34 nodes 8912337 nps 1411112 hashfull 950 tbhits 0 time 6316 pv d2d4 g8f6 c2c4 e7e6 g1f3 d7d5 b1c3 f8e7 c1g5`
+
+func testEvalSet(t *testing.T, log string) *evalSet {
+	t.Helper()
+
+	s := newEvalSet()
+	for _, line := range strings.Split(log, "\n") {
+		eval, err := ParseEval(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if eval.UpperBound || eval.LowerBound {
+			continue
+		}
+		s.Add(eval)
+	}
+	return s
+}
+
+func TestEvalSet_Final(t *testing.T) {
+	// arrange
+	s := testEvalSet(t, testEngineLog)
+
+	// act
+	evals := s.Final()
+
+	// assert
+	if s.MaxDepth() != 23 {
+		t.Errorf("max depth: got %d, want 23", s.MaxDepth())
+	}
+	if len(evals) == 0 || evals[0].Depth != 22 {
+		t.Fatalf("incomplete depth 23 should be dropped, got %+v", evals)
+	}
+
+	top := evals[0]
+	if top.UCIMove != "d2d4" || top.MultiPV != 1 || top.CP != 32 {
+		t.Errorf("top: got %s multipv %d cp %d", top.UCIMove, top.MultiPV, top.CP)
+	}
+
+	second := evals[1]
+	if second.UCIMove != "e2e4" || second.Nodes != 6690000 {
+		t.Errorf("second: got %s nodes %d", second.UCIMove, second.Nodes)
+	}
+	if len(second.PV) != 6 {
+		t.Errorf("truncated PV replaced the full one: got %v", second.PV)
+	}
+
+	if len(evals) != 6 {
+		t.Errorf("got %d evals, want 6 (3 depths x 2 moves)", len(evals))
+	}
+}
+
+func TestEvalSet_Top(t *testing.T) {
+	// arrange
+	s := testEvalSet(t, testEngineLog)
+
+	// act
+	top21, ok21 := s.Top(21)
+	_, ok19 := s.Top(19)
+
+	// assert
+	if !ok21 || top21.UCIMove != "e2e4" || top21.CP != 33 {
+		t.Errorf("depth 21: got %+v", top21)
+	}
+	if ok19 {
+		t.Error("depth 19: want none")
+	}
+	if got := s.BatchAt(22, 6684009); got != 1 {
+		t.Errorf("batch at 22: got %d, want 1 (e2e4 was updated since)", got)
+	}
+}
+
+func TestEvalSet_KeepDepths(t *testing.T) {
+	// arrange
+	s := newEvalSet()
+	for depth := 1; depth <= keepDepths+3; depth++ {
+		s.Add(Eval{UCIMove: "e2e4", Depth: depth, MultiPV: 1, Nodes: depth * 1000, PV: []string{"e2e4"}})
+	}
+
+	// act
+	evals := s.Final()
+
+	// assert
+	if len(evals) != keepDepths {
+		t.Fatalf("got %d evals, want %d", len(evals), keepDepths)
+	}
+	if evals[0].Depth != keepDepths+3 || evals[len(evals)-1].Depth != 4 {
+		t.Errorf("got depths %d..%d", evals[0].Depth, evals[len(evals)-1].Depth)
+	}
+}
+
+func TestEvalSet_Stable(t *testing.T) {
+	// arrange
+	s := newEvalSet()
+	for depth, cp := range map[int]int{20: 30, 21: 34, 22: 55, 23: 58} {
+		s.Add(Eval{UCIMove: "e2e4", Depth: depth, MultiPV: 1, CP: cp, Nodes: depth})
+		s.Add(Eval{UCIMove: "d2d4", Depth: depth, MultiPV: 2, CP: cp - 80, Nodes: depth})
+	}
+
+	// act / assert
+	if got := s.Stable(23, 1, 3, 0); got != 3 {
+		t.Errorf("moves only: got %d, want 3", got)
+	}
+	if got := s.Stable(23, 1, 4, 10); got != 2 {
+		t.Errorf("score within 10 cp: got %d, want 2", got)
+	}
+	if got := s.Stable(23, 23, 3, 0); got != 1 {
+		t.Errorf("floor depth: got %d, want 1", got)
+	}
+	if margin, ok := s.Margin(23); !ok || margin != 80 {
+		t.Errorf("margin: got %d %v, want 80", margin, ok)
+	}
+}
@@ -0,0 +1,59 @@
+package analyze
+
+import "trollfish-lichess/fen"
+
+// tablebasePieces is the most pieces, kings included, that Syzygy tablebases cover.
+const tablebasePieces = 7
+
+type phase int
+
+const (
+	phaseOpening phase = iota
+	phaseMiddlegame
+	phaseEndgame
+)
+
+func (p phase) String() string {
+	switch p {
+	case phaseMiddlegame:
+		return "Middlegame"
+	case phaseEndgame:
+		return "Endgame"
+	}
+	return "Opening"
+}
+
+// gamePhase is a simplified version of lichess' divider: the middlegame starts when at most
+// 10 queens, rooks and minor pieces are left or a side has moved most pieces off its back
+// rank, and the endgame when at most 6 are left.
+func gamePhase(board fen.Board) phase {
+	pieces := 0
+	for _, p := range board.Pos {
+		switch p {
+		case 'Q', 'R', 'B', 'N', 'q', 'r', 'b', 'n':
+			pieces++
+		}
+	}
+
+	switch {
+	case pieces <= 6:
+		return phaseEndgame
+	case pieces <= 10 || backRankSparse(board):
+		return phaseMiddlegame
+	}
+	return phaseOpening
+}
+
+// backRankSparse reports whether either side has fewer than 4 pieces on its back rank.
+func backRankSparse(board fen.Board) bool {
+	white, black := 0, 0
+	for i := 0; i < 8; i++ {
+		if p := board.Pos[56+i]; p >= 'A' && p <= 'Z' {
+			white++
+		}
+		if p := board.Pos[i]; p >= 'a' && p <= 'z' {
+			black++
+		}
+	}
+	return white < 4 || black < 4
+}
@@ -0,0 +1,253 @@
+package analyze
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"trollfish-lichess/fen"
+	"trollfish-lichess/wdl"
+)
+
+const (
+	puzzleBlunder  = 0.3 // winning chances the opponent's move gave away, solver's pov
+	puzzleWinning  = 0.5 // solver's winning chances after the blunder
+	puzzleGap      = 0.4 // winning chances between the solution and the second best move
+	puzzleMaxMoves = 5   // solver moves in a solution
+)
+
+// Puzzle is a position where a player blundered into a tactic with only one winning move.
+type Puzzle struct {
+	ID      string
+	FEN     string   // the position before the blunder
+	Moves   []string // UCI: the blunder, then the solution and the replies to it
+	Ply     int      // of the blunder
+	GameURL string
+	Mate    bool // the solution ends in mate
+}
+
+// CSV returns the puzzle as a line of the lichess puzzle database:
+// PuzzleId,FEN,Moves,Rating,RatingDeviation,Popularity,NbPlays,Themes,GameUrl,OpeningTags.
+// Rating and the play counts are left empty.
+func (p Puzzle) CSV() string {
+	themes := "advantage"
+	if p.Mate {
+		themes = "mate"
+	}
+	return fmt.Sprintf("%s,%s,%s,,,,,%s,%s,", p.ID, p.FEN, strings.Join(p.Moves, " "), themes, p.GameURL)
+}
+
+// PGN returns the puzzle as a game from the position after the blunder, the solver to move.
+func (p Puzzle) PGN() string {
+	board := fen.FENtoBoard(p.FEN)
+	board.Moves(p.Moves[0])
+	startFEN := board.FEN()
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("[Event \"Puzzle %s\"]\n", p.ID))
+	if p.GameURL != "" {
+		sb.WriteString(fmt.Sprintf("[Site \"%s\"]\n", p.GameURL))
+	}
+	sb.WriteString("[Result \"*\"]\n")
+	sb.WriteString("[SetUp \"1\"]\n")
+	sb.WriteString(fmt.Sprintf("[FEN \"%s\"]\n\n", startFEN))
+
+	for i, move := range p.Moves[1:] {
+		if board.ActiveColor == fen.WhitePieces {
+			sb.WriteString(fmt.Sprintf("%d. ", board.FullMove))
+		} else if i == 0 {
+			sb.WriteString(fmt.Sprintf("%d... ", board.FullMove))
+		}
+		sb.WriteString(board.UCItoSAN(move))
+		sb.WriteByte(' ')
+		board.Moves(move)
+	}
+	sb.WriteString("*\n\n")
+
+	return sb.String()
+}
+
+// FindPuzzles searches the game for moves by the opponent of player that let player win
+// with a single move, checked with a MultiPV 2 search. The solution continues while
+// player's moves stay unique and winning, up to puzzleMaxMoves. An empty player looks for
+// puzzles for both sides.
+func (a *Analyzer) FindPuzzles(ctx context.Context, opts AnalysisOptions, pgn *fen.PGNGame, player string) ([]Puzzle, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	wg, err := a.StartStockfish(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	site := pgn.Tags["Site"]
+	gameID := site[strings.LastIndex(site, "/")+1:]
+
+	var puzzles []Puzzle
+	board := fen.FENtoBoard(pgn.SetupFEN)
+	for i := 0; i < len(pgn.Moves); i++ {
+		blunder := pgn.Moves[i].UCI
+		solver := pgn.White
+		if board.ActiveColor == fen.WhitePieces {
+			solver = pgn.Black
+		}
+		if player != "" && !strings.EqualFold(solver, player) {
+			board.Moves(blunder)
+			continue
+		}
+
+		puzzle, ok, err := a.puzzleAt(ctx, opts, board, blunder)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			puzzle.ID = fmt.Sprintf("%s%d", gameID, i)
+			puzzle.Ply = i
+			if site != "" {
+				puzzle.GameURL = fmt.Sprintf("%s#%d", site, i)
+			}
+			logInfo(fmt.Sprintf("puzzle: %s", puzzle.CSV()))
+			puzzles = append(puzzles, puzzle)
+		}
+
+		board.Moves(blunder)
+	}
+
+	if wg != nil {
+		a.input <- "quit"
+
+		cancel()
+		wg.Wait()
+	}
+
+	return puzzles, nil
+}
+
+func (a *Analyzer) puzzleAt(ctx context.Context, opts AnalysisOptions, board fen.Board, blunder string) (Puzzle, bool, error) {
+	after := board
+	after.Moves(blunder)
+	if after.IsMate() || len(after.AllLegalMoves()) < 2 {
+		return Puzzle{}, false, nil
+	}
+
+	before, err := a.searchPV(ctx, opts, board.FEN(), 1)
+	if err != nil {
+		return Puzzle{}, false, err
+	}
+	evals, err := a.searchPV(ctx, opts, after.FEN(), 2)
+	if err != nil {
+		return Puzzle{}, false, err
+	}
+
+	material := wdl.Material(after)
+	best, ok := uniqueMove(evals, material)
+	if !ok {
+		return Puzzle{}, false, nil
+	}
+
+	chances := evalWinningChances(wdl.Default, best, material)
+	gave := chances + evalWinningChances(wdl.Default, before[0], wdl.Material(board))
+	if chances < puzzleWinning || gave < puzzleBlunder {
+		return Puzzle{}, false, nil
+	}
+
+	puzzle := Puzzle{FEN: board.FEN(), Moves: []string{blunder, best.UCIMove}}
+	pos := after
+	pos.Moves(best.UCIMove)
+
+	for solverMoves := 1; solverMoves < puzzleMaxMoves && len(pos.AllLegalMoves()) != 0; solverMoves++ {
+		replies, err := a.searchPV(ctx, opts, pos.FEN(), 1)
+		if err != nil {
+			return Puzzle{}, false, err
+		}
+		reply := pos
+		reply.Moves(replies[0].UCIMove)
+		if len(reply.AllLegalMoves()) == 0 {
+			break
+		}
+
+		evals, err := a.searchPV(ctx, opts, reply.FEN(), 2)
+		if err != nil {
+			return Puzzle{}, false, err
+		}
+		next, ok := uniqueMove(evals, wdl.Material(reply))
+		if !ok || evalWinningChances(wdl.Default, next, wdl.Material(reply)) < puzzleWinning {
+			break
+		}
+
+		puzzle.Moves = append(puzzle.Moves, replies[0].UCIMove, next.UCIMove)
+		pos = reply
+		pos.Moves(next.UCIMove)
+	}
+
+	puzzle.Mate = pos.IsMate()
+	return puzzle, true, nil
+}
+
+// searchPV returns the deepest line of each of the top multiPV moves, best first.
+func (a *Analyzer) searchPV(ctx context.Context, opts AnalysisOptions, fenPos string, multiPV int) ([]Eval, error) {
+	opts.MultiPV = multiPV
+
+	if err := a.waitReady(ctx); err != nil {
+		return nil, err
+	}
+	a.input <- fmt.Sprintf("position fen %s", fenPos)
+
+	evals, err := a.analyzePosition(ctx, opts, fenPos, nil)
+	if err != nil {
+		return nil, err
+	}
+	return topMoves(evals), nil
+}
+
+// topMoves keeps the deepest line of each move from evals sorted by sortEvals, ordered by
+// MultiPV.
+func topMoves(evals []Eval) []Eval {
+	var top []Eval
+	seen := make(map[string]struct{})
+	for _, eval := range evals {
+		if _, ok := seen[eval.UCIMove]; ok || eval.Depth != evals[0].Depth {
+			continue
+		}
+		seen[eval.UCIMove] = struct{}{}
+		top = append(top, eval)
+	}
+	return top
+}
+
+// uniqueMove returns the best move if the second best is at least puzzleGap winning chances
+// worse. Positions with a single legal move are unique.
+func uniqueMove(evals []Eval, material int) (Eval, bool) {
+	if len(evals) == 0 {
+		return Eval{}, false
+	}
+	if len(evals) == 1 {
+		return evals[0], true
+	}
+	return evals[0], diffWC(wdl.Default, evals[0], evals[1], material) >= puzzleGap
+}
+
+// SavePuzzles appends puzzles to filename, as lichess puzzle CSV or, if pgn is set, as PGN.
+func SavePuzzles(filename string, puzzles []Puzzle, pgn bool) error {
+	var sb strings.Builder
+	for _, p := range puzzles {
+		if pgn {
+			sb.WriteString(p.PGN())
+		} else {
+			sb.WriteString(p.CSV())
+			sb.WriteByte('\n')
+		}
+	}
+
+	fp, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("'%s': %v", filename, err)
+	}
+	defer fp.Close()
+
+	if _, err := fp.WriteString(sb.String()); err != nil {
+		return fmt.Errorf("write file '%s': %v", filename, err)
+	}
+	return nil
+}
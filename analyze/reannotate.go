@@ -0,0 +1,125 @@
+package analyze
+
+import (
+	"context"
+	"fmt"
+
+	"trollfish-lichess/fen"
+	"trollfish-lichess/wdl"
+	"trollfish-lichess/yamlbook"
+)
+
+// reannotateSwing is the default Analyzer.ReannotateSwing.
+const reannotateSwing = 0.1
+
+// ReannotateGame annotates a game that has [%eval]s, e.g. a lichess export, keeping the
+// evals of the moves that don't lose more than ReannotateSwing winning chances by them. The
+// moves without an eval and the swings, where the annotations go, are analyzed again.
+// The kept moves are written as the PGN had them, like the moves outside FromPly to ToPly.
+func (a *Analyzer) ReannotateGame(ctx context.Context, opts AnalysisOptions, pgn *fen.PGNGame, book *yamlbook.Book) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	wg, err := a.StartStockfish(ctx)
+	if err != nil {
+		return err
+	}
+
+	swing := a.ReannotateSwing
+	if swing <= 0 {
+		swing = reannotateSwing
+	}
+
+	model := a.gameThresholds(pgn).model()
+
+	var (
+		movesEval Moves
+		prev      *fen.Score // before the move
+		analyzed  int
+	)
+
+	board := fen.FENtoBoard(pgn.SetupFEN)
+	for i, pgnMove := range pgn.Moves {
+		color := board.ActiveColor
+		san := board.UCItoSAN(pgnMove.UCI)
+
+		nextBoard := fen.FENtoBoard(board.FEN())
+		nextBoard.Moves(pgnMove.UCI)
+
+		kept := unanalyzedMove(i, san, pgnMove, color)
+		move := kept
+		switch {
+		case !a.analyzesPly(i + 1):
+		case nextBoard.IsMate():
+			mated := Eval{UCIMove: pgnMove.UCI, Mated: true}
+			move = Move{Ply: i, UCI: pgnMove.UCI, SAN: san, IsMate: true, Eval: mated, BestMove: mated}
+		case pgnMove.HasEval && !isSwing(model, prev, kept.Eval, color, wdl.Material(board), swing):
+		default:
+			if move, err = a.analyzeMove(ctx, opts, board, i, san, pgnMove.UCI); err != nil {
+				return err
+			}
+			analyzed++
+		}
+		movesEval = append(movesEval, move)
+
+		if !move.IsMate && !move.Eval.Empty() {
+			score := move.Eval.POV(color)
+			prev = &score
+		} else {
+			prev = nil
+		}
+		board = nextBoard
+	}
+	logInfo(fmt.Sprintf("re-annotated game %d: %d of %d plies analyzed", pgn.Index, analyzed, len(pgn.Moves)))
+
+	if err := a.finishGame(pgn, movesEval, book); err != nil {
+		return err
+	}
+
+	if wg != nil {
+		a.input <- "quit"
+
+		cancel()
+		wg.Wait()
+	}
+
+	return nil
+}
+
+// isSwing reports whether the move played by color to an eval after of its pov loses at
+// least swing winning chances, by model, from prev, the score before it. Without prev it's not a swing.
+func isSwing(model wdl.Model, prev *fen.Score, after Eval, color fen.Color, material int, swing float64) bool {
+	if prev == nil {
+		return false
+	}
+	before := prev.For(color)
+	return diffWC(model, after, Eval{CP: before.CP, Mate: before.Mate}, material) <= -swing
+}
+
+// analyzeMove returns the best move of board and the eval of the played move, searched
+// on its own if it isn't one of the best lines.
+func (a *Analyzer) analyzeMove(ctx context.Context, opts AnalysisOptions, board fen.Board, ply int, san, uci string) (Move, error) {
+	boardFEN := board.FEN()
+	evals, err := a.AnalyzePosition(ctx, opts, boardFEN)
+	if err != nil {
+		return Move{}, err
+	}
+
+	move := Move{Ply: ply, UCI: uci, SAN: san, BestMove: evals[0]}
+	if len(evals) > 1 {
+		move.SecondBest = &evals[1]
+	}
+	for _, eval := range evals {
+		if eval.UCIMove == uci {
+			move.Eval = eval
+			return move, nil
+		}
+	}
+
+	played, err := a.AnalyzePosition(ctx, opts, boardFEN, uci)
+	if err != nil {
+		return Move{}, err
+	}
+	move.Eval = played[0]
+	return move, nil
+}
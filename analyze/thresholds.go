@@ -0,0 +1,160 @@
+package analyze
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"trollfish-lichess/wdl"
+)
+
+// Elo scaling of the annotation thresholds, see Thresholds.EloScale
+const (
+	thresholdFullElo  = 2200 // no scaling at or above this average rating
+	thresholdEloRange = 4000 // rating points below thresholdFullElo that would double them
+	thresholdMaxScale = 1.5
+)
+
+// DefaultThresholds are lichess' annotation thresholds.
+var DefaultThresholds = Thresholds{Inaccuracy: 0.1, Mistake: 0.2, Blunder: 0.3}
+
+// Thresholds are the winning chances (see diffWC) a move has to lose to be annotated as an
+// inaccuracy (?!), mistake (?) or blunder (??).
+type Thresholds struct {
+	Inaccuracy float64 `yaml:"inaccuracy" json:"inaccuracy"`
+	Mistake    float64 `yaml:"mistake" json:"mistake"`
+	Blunder    float64 `yaml:"blunder" json:"blunder"`
+
+	// EloScale widens the thresholds in games of lower rated players, up to half again at
+	// 200 average Elo. Games without both ratings aren't scaled.
+	EloScale bool `yaml:"elo_scale" json:"elo_scale"`
+
+	// Model converts evals to winning chances, see wdl.Parse. If it's empty ForGame sets
+	// the model of the players' rating band, see wdl.ForRating.
+	Model wdl.Model `yaml:"model,omitempty" json:"model,omitempty"`
+}
+
+// ParseThresholds reads the inaccuracy, mistake and blunder thresholds, e.g. "0.1,0.2,0.3".
+func ParseThresholds(text string) (Thresholds, error) {
+	parts := strings.Split(text, ",")
+	if len(parts) != 3 {
+		return Thresholds{}, fmt.Errorf("thresholds '%s': want inaccuracy,mistake,blunder e.g. 0.1,0.2,0.3", text)
+	}
+
+	var values [3]float64
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return Thresholds{}, fmt.Errorf("thresholds '%s': %v", text, err)
+		}
+		values[i] = v
+	}
+
+	t := Thresholds{Inaccuracy: values[0], Mistake: values[1], Blunder: values[2]}
+	return t, t.Validate()
+}
+
+// Validate checks the thresholds are in order.
+func (t Thresholds) Validate() error {
+	if t.Inaccuracy <= 0 || t.Inaccuracy > t.Mistake || t.Mistake > t.Blunder || t.Blunder > 2 {
+		return fmt.Errorf("thresholds %s: want 0 < inaccuracy <= mistake <= blunder <= 2", t)
+	}
+	if t.Model != "" {
+		if _, err := wdl.Parse(string(t.Model)); err != nil {
+			return fmt.Errorf("thresholds %s: %v", t, err)
+		}
+	}
+	return nil
+}
+
+// WithDefaults fills thresholds left at zero from DefaultThresholds.
+func (t Thresholds) WithDefaults() Thresholds {
+	if t.Inaccuracy == 0 {
+		t.Inaccuracy = DefaultThresholds.Inaccuracy
+	}
+	if t.Mistake == 0 {
+		t.Mistake = DefaultThresholds.Mistake
+	}
+	if t.Blunder == 0 {
+		t.Blunder = DefaultThresholds.Blunder
+	}
+	return t
+}
+
+// ForGame returns the thresholds for a game between players rated whiteElo and blackElo,
+// and the average rating they were scaled for, 0 if they weren't.
+func (t Thresholds) ForGame(whiteElo, blackElo int) (Thresholds, int) {
+	if t.Model == "" {
+		t.Model = wdl.ForRating(gameRating(whiteElo, blackElo))
+	}
+	if !t.EloScale || whiteElo <= 0 || blackElo <= 0 {
+		return t, 0
+	}
+
+	avg := (whiteElo + blackElo) / 2
+	if avg >= thresholdFullElo {
+		return t, 0
+	}
+
+	scale := 1 + float64(thresholdFullElo-avg)/thresholdEloRange
+	if scale > thresholdMaxScale {
+		scale = thresholdMaxScale
+	}
+	t.Inaccuracy *= scale
+	t.Mistake *= scale
+	t.Blunder *= scale
+	return t, avg
+}
+
+// gameRating is the players' average rating, the rated one's if only one is, else 0.
+func gameRating(whiteElo, blackElo int) int {
+	switch {
+	case whiteElo > 0 && blackElo > 0:
+		return (whiteElo + blackElo) / 2
+	case whiteElo > 0:
+		return whiteElo
+	}
+	return max(blackElo, 0)
+}
+
+// model is the model of the thresholds, wdl.Default if it's not set.
+func (t Thresholds) model() wdl.Model {
+	if t.Model == "" {
+		return wdl.Default
+	}
+	return t.Model
+}
+
+// annotate returns the annotation for a move that changed the winning chances by diff,
+// negative when they were lost.
+func (t Thresholds) annotate(diff float64) (string, string) {
+	switch {
+	case diff <= -t.Blunder:
+		return "??", "Blunder" // $4
+	case diff <= -t.Mistake:
+		return "?", "Mistake" // $2
+	case diff <= -t.Inaccuracy:
+		return "?!", "Inaccuracy" // $6
+	}
+	return "", ""
+}
+
+func (t Thresholds) String() string {
+	return fmt.Sprintf("?! %.2f ? %.2f ?? %.2f", t.Inaccuracy, t.Mistake, t.Blunder)
+}
+
+// annotator is the Annotator tag, the thresholds are only named when they aren't lichess'.
+func (t Thresholds) annotator(scaledElo int) string {
+	engine := "Stockfish 15"
+	if model := t.model(); model != wdl.Lichess {
+		engine += ", " + string(model) + " winning chances"
+	}
+	t.EloScale, t.Model = false, ""
+	switch {
+	case scaledElo != 0:
+		return fmt.Sprintf("%s, %s for %d Elo", engine, t, scaledElo)
+	case t != DefaultThresholds:
+		return fmt.Sprintf("%s, %s", engine, t)
+	}
+	return engine
+}
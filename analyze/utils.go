@@ -1,12 +1,10 @@
 package analyze
 
 import (
-	"bufio"
 	"context"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"strconv"
 	"strings"
 	"sync"
@@ -14,6 +12,7 @@ import (
 	"time"
 
 	"trollfish-lichess/fen"
+	"trollfish-lichess/uciproc"
 )
 
 func (a *Analyzer) StartStockfish(ctx context.Context) (*sync.WaitGroup, error) {
@@ -21,8 +20,12 @@ func (a *Analyzer) StartStockfish(ctx context.Context) (*sync.WaitGroup, error)
 		return nil, nil
 	}
 
-	cmd := exec.CommandContext(ctx, stockfishBinary)
-	cmd.Dir = stockfishDir
+	stockfishBinary, err := uciproc.Find(StockfishPath, "STOCKFISH", []string{"stockfish"}, legacyStockfish)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := uciproc.Command(ctx, stockfishBinary)
 
 	var wg sync.WaitGroup
 
@@ -63,8 +66,10 @@ func (a *Analyzer) StartStockfish(ctx context.Context) (*sync.WaitGroup, error)
 					atomic.StoreInt64(&readyOK, 0)
 				}
 
-				_, err := stdin.Write([]byte(fmt.Sprintf("%s\n", line)))
-				if err != nil {
+				if err := uciproc.WriteLine(stdin, line); err != nil {
+					if ctx.Err() != nil {
+						return
+					}
 					log.Fatalf("stdin.Write ERR: %v", err)
 				}
 
@@ -79,19 +84,16 @@ func (a *Analyzer) StartStockfish(ctx context.Context) (*sync.WaitGroup, error)
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		r := bufio.NewScanner(stderr)
-		for r.Scan() {
-			select {
-			case <-ctx.Done():
+		err := uciproc.ScanLines(stderr, func(line string) bool {
+			if ctx.Err() != nil {
 				logInfo("exiting stderr loop (ctx.Done())")
-				return
-			default:
-				line := r.Text()
-				log.Printf(fmt.Sprintf("SF STDERR: %s\n", line))
+				return false
 			}
-		}
-		if err := r.Err(); err != nil {
-			log.Printf(fmt.Sprintf("SF ERR: stderr: %v\n", err))
+			log.Printf("SF STDERR: %s\n", line)
+			return true
+		})
+		if err != nil {
+			log.Printf("SF ERR: stderr: %v\n", err)
 		}
 		logInfo("exiting stderr loop")
 	}()
@@ -100,17 +102,13 @@ func (a *Analyzer) StartStockfish(ctx context.Context) (*sync.WaitGroup, error)
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		r := bufio.NewScanner(stdout)
 		var sentUCIInit bool
-		for r.Scan() {
-			select {
-			case <-ctx.Done():
+		err := uciproc.ScanLines(stdout, func(line string) bool {
+			if ctx.Err() != nil {
 				logInfo("exiting stdout loop (ctx.Done())")
-				return
-			default:
+				return false
 			}
 
-			line := r.Text()
 			if showEngineOutput(line) {
 				a.LogEngine(line)
 			}
@@ -125,9 +123,10 @@ func (a *Analyzer) StartStockfish(ctx context.Context) (*sync.WaitGroup, error)
 			if line == "readyok" {
 				atomic.StoreInt64(&readyOK, 1)
 			}
-		}
-		if err := r.Err(); err != nil {
-			log.Printf(fmt.Sprintf("ERR: stdout: %v\n", err))
+			return true
+		})
+		if err != nil {
+			log.Printf("ERR: stdout: %v\n", err)
 		}
 		logInfo("exiting stdout loop")
 	}()
@@ -136,8 +135,9 @@ func (a *Analyzer) StartStockfish(ctx context.Context) (*sync.WaitGroup, error)
 	go func() {
 		defer wg.Done()
 		if err := cmd.Wait(); err != nil {
-			if err.Error() != "signal: killed" {
-				log.Fatal(fmt.Sprintf("SF CMD ERR: %v", err))
+			// a killed process reports differently per platform; ctx tells us we killed it
+			if ctx.Err() == nil {
+				log.Fatalf("SF CMD ERR: %v", err)
 			}
 		}
 	}()
@@ -146,14 +146,29 @@ func (a *Analyzer) StartStockfish(ctx context.Context) (*sync.WaitGroup, error)
 
 	var sentNewGame bool
 
+	timeout := time.NewTimer(engineStartTimeout)
+	defer timeout.Stop()
+
 readyOKLoop:
-	for line := range a.output {
+	for {
+		var line string
+		select {
+		case <-ctx.Done():
+			return &wg, ctx.Err()
+		case <-timeout.C:
+			return &wg, fmt.Errorf("'%s' not ready after %v", stockfishBinary, engineStartTimeout)
+		case line = <-a.output:
+		}
+
 		switch line {
 		case "uciok":
 			if useFullResources {
-				a.input <- fmt.Sprintf("setoption name Threads value %d", threads)
-				a.input <- fmt.Sprintf("setoption name Hash value %d", hashMemory)
-				a.input <- fmt.Sprintf("setoption name SyzygyPath value %s", SyzygyPath)
+				for _, opt := range a.Resources.Options() {
+					a.input <- opt
+				}
+				if SyzygyPath != "" {
+					a.input <- fmt.Sprintf("setoption name SyzygyPath value %s", SyzygyPath)
+				}
 			}
 			a.input <- fmt.Sprintf("setoption name UCI_AnalyseMode value true")
 
@@ -193,11 +208,21 @@ func showEngineOutput(line string) bool {
 	return true
 }
 
-func (a *Analyzer) waitReady() {
+func (a *Analyzer) waitReady(ctx context.Context) error {
+	timeout := time.NewTimer(engineReadyTimeout)
+	defer timeout.Stop()
+
 	a.input <- "isready"
-	for line := range a.output {
-		if line == "readyok" {
-			break
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout.C:
+			return fmt.Errorf("no readyok after %v", engineReadyTimeout)
+		case line := <-a.output:
+			if line == "readyok" {
+				return nil
+			}
 		}
 	}
 }
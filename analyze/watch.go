@@ -0,0 +1,136 @@
+package analyze
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"trollfish-lichess/eventbus"
+	"trollfish-lichess/fen"
+)
+
+var (
+	// ErrAborted is returned for a position whose search was aborted before reaching any
+	// depth, see Analyzer.Abort.
+	ErrAborted = errors.New("aborted")
+	// ErrPostponed is returned for a position whose search was aborted to analyze it later,
+	// see Analyzer.Postpone. Its evals are dropped.
+	ErrPostponed = errors.New("postponed")
+)
+
+// SearchProgress is published on the Analyzer's Bus each time the search of a position
+// completes a depth.
+type SearchProgress struct {
+	FEN      string
+	Depth    int
+	Move     string   // the best move at Depth, UCI
+	PV       []string // UCI
+	CP       int      // the side to move's pov
+	Mate     int
+	Nodes    int
+	HashFull int // per mille
+	Elapsed  time.Duration
+}
+
+// SearchAborted is published when Abort or Postpone stops a position's search.
+type SearchAborted struct {
+	FEN       string
+	Depth     int // reached, the evals at it are kept unless Postponed; 0 for none
+	Postponed bool
+}
+
+// searchWatch is the position being searched, for Analyzer.Abort, and the batch's
+// controls.
+type searchWatch struct {
+	mtx       sync.Mutex
+	fen       string
+	abort     chan struct{}
+	reason    error // ErrAborted or ErrPostponed once abort is closed
+	stopAfter bool
+}
+
+func (w *searchWatch) start(fenPos string) <-chan struct{} {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.fen, w.reason = fenPos, nil
+	w.abort = make(chan struct{})
+	return w.abort
+}
+
+func (w *searchWatch) stop() {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.fen, w.abort = "", nil
+}
+
+// abortReason returns why the search was aborted, nil if it wasn't.
+func (w *searchWatch) abortReason() error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.reason
+}
+
+// Current returns the position being searched, or "" between positions.
+func (a *Analyzer) Current() string {
+	a.watch.mtx.Lock()
+	defer a.watch.mtx.Unlock()
+	return a.watch.fen
+}
+
+// Abort stops the search of the current position, keeping the evals of the depths it
+// finished, and reports whether it did. A non-empty fenPos only aborts that position, so
+// a late request doesn't abort the next one.
+func (a *Analyzer) Abort(fenPos string) bool {
+	return a.abort(fenPos, ErrAborted)
+}
+
+// Postpone is Abort dropping the position's evals, to analyze it another time: UpdateFile
+// leaves it queued and AnalyzePGNFile skips its game.
+func (a *Analyzer) Postpone(fenPos string) bool {
+	return a.abort(fenPos, ErrPostponed)
+}
+
+func (a *Analyzer) abort(fenPos string, reason error) bool {
+	a.watch.mtx.Lock()
+	defer a.watch.mtx.Unlock()
+
+	if a.watch.abort == nil || (fenPos != "" && fen.Key(fenPos) != fen.Key(a.watch.fen)) {
+		return false
+	}
+	a.watch.reason = reason
+	close(a.watch.abort)
+	a.watch.abort = nil
+	return true
+}
+
+// StopAfter asks a batch to stop once the current position is done: UpdateFile stops after
+// the position, AnalyzePGNFile after the game.
+func (a *Analyzer) StopAfter() {
+	a.watch.mtx.Lock()
+	defer a.watch.mtx.Unlock()
+	a.watch.stopAfter = true
+}
+
+// Stopping reports whether StopAfter was called.
+func (a *Analyzer) Stopping() bool {
+	a.watch.mtx.Lock()
+	defer a.watch.mtx.Unlock()
+	return a.watch.stopAfter
+}
+
+func (a *Analyzer) publishProgress(fenPos string, top Eval, start time.Time) {
+	if a.Bus == nil {
+		return
+	}
+	eventbus.Publish(a.Bus, SearchProgress{
+		FEN:      fenPos,
+		Depth:    top.Depth,
+		Move:     top.UCIMove,
+		PV:       top.PV,
+		CP:       top.CP,
+		Mate:     top.Mate,
+		Nodes:    top.Nodes,
+		HashFull: top.HashFull,
+		Elapsed:  time.Since(start),
+	})
+}
@@ -0,0 +1,55 @@
+package analyze
+
+import (
+	"testing"
+)
+
+func TestAnalyzer_Abort(t *testing.T) {
+	// arrange
+	const (
+		searching = "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq - 0 1"
+		other     = "rnbqkbnr/pppppppp/8/8/3P4/8/PPP1PPPP/RNBQKBNR b KQkq - 0 1"
+	)
+	a := New()
+	idle := a.Abort("")
+	abort := a.watch.start(searching)
+
+	// act
+	wrong := a.Abort(other)
+	right := a.Abort("rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1")
+	again := a.Abort("")
+
+	// assert
+	if idle || wrong || !right || again {
+		t.Errorf("idle: %v, other position: %v, searching: %v, again: %v; want only the search aborted once", idle, wrong, right, again)
+	}
+	select {
+	case <-abort:
+	default:
+		t.Error("abort channel not closed")
+	}
+	if got := a.Current(); got != searching {
+		t.Errorf("current: got '%s', want '%s'", got, searching)
+	}
+}
+
+func TestAnalyzer_Postpone(t *testing.T) {
+	// arrange
+	a := New()
+	a.watch.start(startPosFEN)
+
+	// act
+	postponed := a.Postpone("")
+	a.StopAfter()
+
+	// assert
+	if !postponed {
+		t.Error("postpone: got false")
+	}
+	if reason := a.watch.abortReason(); reason != ErrPostponed {
+		t.Errorf("reason: got %v, want %v", reason, ErrPostponed)
+	}
+	if !a.Stopping() {
+		t.Error("stopping: got false")
+	}
+}
@@ -1,29 +1,14 @@
 package analyze
 
-import "math"
+import "trollfish-lichess/wdl"
 
-func rawWinningChances(cp float64) float64 {
-	return 2/(1+math.Exp(-0.004*cp)) - 1
-}
-
-func cpWinningChances(cp int) float64 {
-	return rawWinningChances(math.Min(math.Max(-1000, float64(cp)), 1000))
-}
-
-func mateWinningChances(mate int) float64 {
-	cp := (21 - math.Min(10, math.Abs(float64(mate)))) * 100
-	signed := cp
-	if mate < 0 {
-		signed *= -1
-	}
-	return rawWinningChances(signed)
-}
-
-func evalWinningChances(eval Eval) float64 {
+// evalWinningChances converts eval with model. material is the material on the board,
+// see wdl.Material.
+func evalWinningChances(model wdl.Model, eval Eval, material int) float64 {
 	if eval.Mate != 0 {
-		return mateWinningChances(eval.Mate)
+		return model.FromMate(eval.Mate).Chances()
 	}
-	return cpWinningChances(eval.CP)
+	return model.FromCP(eval.CP, material).Chances()
 }
 
 /*// povChances computes winning chances for a color
@@ -49,6 +34,6 @@ func povDiff(color int, e2 Eval, e1 Eval) float64 {
 // diffWC computes the difference, in winning chances, between two evaluations
 // 1  = e1 is infinitely better than e2
 // -1 = e1 is infinitely worse  than e2
-func diffWC(e2 Eval, e1 Eval) float64 {
-	return evalWinningChances(e2) - evalWinningChances(e1)
+func diffWC(model wdl.Model, e2 Eval, e1 Eval, material int) float64 {
+	return evalWinningChances(model, e2, material) - evalWinningChances(model, e1, material)
 }
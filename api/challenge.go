@@ -53,6 +53,7 @@ type Challenge struct {
 	Direction     string               `json:"direction"` // "in", "out"
 	InitialFEN    string               `json:"initialFen"`
 	DeclineReason string               `json:"declineReason"`
+	DeclineKey    string               `json:"declineReasonKey"` // e.g. "rated", "tooFast"
 
 	InternalCreated int64 `json:"-"`
 }
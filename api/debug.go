@@ -0,0 +1,116 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// debugBodyBytes is how much of each request and response body debug mode logs.
+const debugBodyBytes = 500
+
+// client is used for every request, logging them when debug mode is on.
+var client = &http.Client{Transport: &debugTransport{next: http.DefaultTransport}}
+
+var debug int32
+
+// SetDebug turns logging of each request's method, URL, headers, status, latency and
+// truncated bodies on or off. The Authorization header is redacted. It's safe to call
+// while requests are in flight.
+func SetDebug(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&debug, v)
+}
+
+// Debug reports whether debug mode is on.
+func Debug() bool {
+	return atomic.LoadInt32(&debug) == 1
+}
+
+type debugTransport struct {
+	next http.RoundTripper
+}
+
+func (t *debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !Debug() {
+		return t.next.RoundTrip(req)
+	}
+
+	var reqBody []byte
+	if req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		reqBody = b
+		req.Body = io.NopCloser(bytes.NewReader(b))
+	}
+
+	fmt.Printf("%s API: %s %s headers: %s body: '%s'\n", ts(), req.Method, req.URL, redactHeaders(req.Header), truncateBody(reqBody))
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		fmt.Printf("%s API: %s %s failed after %v: %v\n", ts(), req.Method, req.URL, elapsed, err)
+		return resp, err
+	}
+
+	// streams stay open for the whole game or session
+	if strings.Contains(resp.Header.Get("Content-Type"), "ndjson") {
+		fmt.Printf("%s API: %s %s %d %v (stream)\n", ts(), req.Method, req.URL, resp.StatusCode, elapsed)
+		return resp, nil
+	}
+
+	respBody, readErr := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	if contentType := resp.Header.Get("Content-Type"); strings.HasPrefix(contentType, "image/") {
+		fmt.Printf("%s API: %s %s %d %v body: %s, %d bytes\n", ts(), req.Method, req.URL, resp.StatusCode, elapsed, contentType, len(respBody))
+	} else {
+		fmt.Printf("%s API: %s %s %d %v body: '%s'\n", ts(), req.Method, req.URL, resp.StatusCode, elapsed, truncateBody(respBody))
+	}
+	if readErr != nil {
+		return nil, readErr
+	}
+	return resp, nil
+}
+
+func redactHeaders(header http.Header) string {
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for i, key := range keys {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		value := strings.Join(header[key], ",")
+		if strings.EqualFold(key, "Authorization") {
+			value = "<redacted>"
+		}
+		sb.WriteString(fmt.Sprintf("%s: %s", key, value))
+	}
+	return sb.String()
+}
+
+func truncateBody(b []byte) string {
+	s := strings.TrimSpace(string(b))
+	if len(s) > debugBodyBytes {
+		return fmt.Sprintf("%s... (%d bytes)", s[:debugBodyBytes], len(b))
+	}
+	return s
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestDebugTransport(t *testing.T) {
+	// arrange
+	SetDebug(true)
+	defer SetDebug(false)
+
+	var gotReqBody string
+	transport := &debugTransport{next: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		b, _ := io.ReadAll(req.Body)
+		gotReqBody = string(b)
+		return &http.Response{StatusCode: 400, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(`{"error":"bad"}`))}, nil
+	})}
+	req, _ := http.NewRequest("POST", "https://lichess.org/api/x", strings.NewReader("room=player"))
+	req.Header.Add("Authorization", "Bearer secret")
+
+	// act
+	resp, err := transport.RoundTrip(req)
+	headers := redactHeaders(req.Header)
+	long := truncateBody([]byte(strings.Repeat("a", debugBodyBytes+1)))
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(resp.Body); string(b) != `{"error":"bad"}` {
+		t.Errorf("response body: got %q", b)
+	}
+	if gotReqBody != "room=player" {
+		t.Errorf("request body: got %q", gotReqBody)
+	}
+	if strings.Contains(headers, "secret") {
+		t.Errorf("headers not redacted: %s", headers)
+	}
+	if !strings.HasSuffix(long, "... (501 bytes)") {
+		t.Errorf("truncate: got %q", long[len(long)-20:])
+	}
+}
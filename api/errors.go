@@ -0,0 +1,91 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors a StatusError wraps, depending on its status code. Use errors.Is.
+var (
+	ErrNotFound     = errors.New("http 404 error")
+	ErrUnauthorized = errors.New("http 401 error")
+	ErrRateLimited  = errors.New("http 429 error")
+	ErrGameOver     = errors.New("game already over")
+	ErrUnavailable  = errors.New("http 5xx error") // lichess is down or in maintenance
+)
+
+// defaultRetryAfter is how long lichess asks clients to wait after a 429 without a
+// Retry-After header.
+const defaultRetryAfter = time.Minute
+
+// StatusError is a response with a status code other than 200.
+type StatusError struct {
+	StatusCode int
+	Endpoint   string
+	Body       string
+	RetryAfter time.Duration // rate limited responses only
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("http status code %d '%s' body: '%s'", e.StatusCode, e.Endpoint, e.Body)
+}
+
+// Unwrap returns the Err* value for the status code, or nil.
+func (e *StatusError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrUnauthorized
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return ErrUnavailable
+	case http.StatusBadRequest:
+		// '{"error":"Not your turn, or game already over"}'
+		if strings.Contains(e.Body, "game already over") {
+			return ErrGameOver
+		}
+	}
+	return nil
+}
+
+func statusError(resp *http.Response, endpoint string, body []byte) error {
+	err := &StatusError{StatusCode: resp.StatusCode, Endpoint: endpoint, Body: string(body)}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		err.RetryAfter = defaultRetryAfter
+		if secs, convErr := strconv.Atoi(resp.Header.Get("Retry-After")); convErr == nil && secs > 0 {
+			err.RetryAfter = time.Duration(secs) * time.Second
+		}
+	}
+	return err
+}
+
+// RetryAfter returns how long to wait before retrying a rate limited request, and false if
+// err isn't ErrRateLimited.
+func RetryAfter(err error) (time.Duration, bool) {
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+	return statusErr.RetryAfter, true
+}
+
+// Transient reports whether err is lichess being down or unreachable rather than something
+// wrong with the request, so it's worth retrying later. Errors without a status code are
+// network errors, unless the context was cancelled.
+func Transient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return errors.Is(err, ErrUnavailable)
+	}
+	return true
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestStatusError(t *testing.T) {
+	// arrange
+	response := func(code int, header http.Header) *http.Response {
+		return &http.Response{StatusCode: code, Header: header}
+	}
+	limited := statusError(response(429, http.Header{"Retry-After": []string{"30"}}), "e", nil)
+	limitedDefault := statusError(response(429, http.Header{}), "e", nil)
+	notFound := statusError(response(404, http.Header{}), "e", nil)
+	gameOver := statusError(response(400, http.Header{}), "e", []byte(`{"error":"Not your turn, or game already over"}`))
+	badRequest := statusError(response(400, http.Header{}), "e", []byte(`{"error":"bad move"}`))
+
+	// act
+	retryAfter, ok := RetryAfter(limited)
+	retryDefault, _ := RetryAfter(limitedDefault)
+	_, notFoundOK := RetryAfter(notFound)
+
+	// assert
+	if !ok || retryAfter != 30*time.Second || !errors.Is(limited, ErrRateLimited) {
+		t.Errorf("limited: got %v %v", retryAfter, ok)
+	}
+	if retryDefault != defaultRetryAfter {
+		t.Errorf("limited default: got %v, want %v", retryDefault, defaultRetryAfter)
+	}
+	if notFoundOK || !errors.Is(notFound, ErrNotFound) {
+		t.Errorf("not found: got %v", notFound)
+	}
+	if !errors.Is(gameOver, ErrGameOver) {
+		t.Errorf("game over: got %v", gameOver)
+	}
+	if errors.Is(badRequest, ErrGameOver) || errors.Is(badRequest, ErrNotFound) {
+		t.Errorf("bad request: got %v", badRequest)
+	}
+}
+
+func TestTransient(t *testing.T) {
+	// arrange
+	response := func(code int) *http.Response {
+		return &http.Response{StatusCode: code, Header: http.Header{}}
+	}
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"502", statusError(response(502), "e", nil), true},
+		{"503", statusError(response(503), "e", nil), true},
+		{"401", statusError(response(401), "e", nil), false},
+		{"400", statusError(response(400), "e", []byte(`{"error":"bad move"}`)), false},
+		{"network", errors.New("dial tcp: connection refused"), true},
+		{"canceled", fmt.Errorf("read: %w", context.Canceled), false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// act
+			got := Transient(c.err)
+
+			// assert
+			if got != c.want {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
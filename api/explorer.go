@@ -0,0 +1,331 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"trollfish-lichess/fen"
+)
+
+// defaultExplorerTimeout limits each explorer and cloud eval request of DefaultExplorer.
+const defaultExplorerTimeout = 30 * time.Second
+
+type Move struct {
+	UCI           string `json:"uci"`
+	SAN           string `json:"san"`
+	AverageRating int    `json:"averageRating"`
+
+	White      int `json:"white"`
+	Black      int `json:"black"`
+	Draws      int `json:"draws"`
+	TotalGames int `json:"total_games"`
+
+	WhitePercent      float64 `json:"white_pct"`
+	BlackPercent      float64 `json:"black_pct"`
+	DrawsPercent      float64 `json:"draws_pct"`
+	PopularityPercent float64 `json:"popularity_pct"`
+
+	Game *ExplorerGame `json:"game,omitempty"` // the only game with the move, if there's one
+}
+
+type PositionResults struct {
+	White      int    `json:"white"`
+	Draws      int    `json:"draws"`
+	Black      int    `json:"black"`
+	Moves      []Move `json:"moves"`
+	TotalGames int    `json:"total_games"`
+
+	Opening     *ExplorerOpening `json:"opening,omitempty"`
+	TopGames    []ExplorerGame   `json:"topGames,omitempty"`
+	RecentGames []ExplorerGame   `json:"recentGames,omitempty"`
+	History     []ExplorerMonth  `json:"history,omitempty"` // lichess database, LookupOptions.History only
+}
+
+type ExplorerOpening struct {
+	ECO  string `json:"eco"`
+	Name string `json:"name"`
+}
+
+type ExplorerPlayer struct {
+	Name   string `json:"name"`
+	Rating int    `json:"rating"`
+}
+
+// ExplorerGame is a game from the explorer. Winner is "white", "black" or empty for a draw.
+type ExplorerGame struct {
+	UCI    string         `json:"uci"`
+	ID     string         `json:"id"`
+	Winner string         `json:"winner"`
+	Speed  string         `json:"speed,omitempty"`
+	White  ExplorerPlayer `json:"white"`
+	Black  ExplorerPlayer `json:"black"`
+	Year   int            `json:"year"`
+	Month  string         `json:"month,omitempty"`
+}
+
+// ExplorerMonth is the position's results in a month, e.g. "2022-01".
+type ExplorerMonth struct {
+	Month string `json:"month"`
+	White int    `json:"white"`
+	Draws int    `json:"draws"`
+	Black int    `json:"black"`
+}
+
+type LookupDatabase string
+
+const (
+	Masters LookupDatabase = "masters"
+	Lichess LookupDatabase = "lichess"
+)
+
+// LookupOptions are the optional parts of an explorer lookup.
+type LookupOptions struct {
+	Play        []string // UCI moves played from the position
+	TopGames    int
+	RecentGames int   // lichess database only, masters returns none
+	History     bool  // lichess database only
+	Ratings     []int // lichess database only, rating groups e.g. 2000,2200; empty = 1600 and up
+}
+
+// RatingGroups are the lichess database's rating groups. Each is the games with an average
+// rating from it up to the next one.
+var RatingGroups = []int{0, 1000, 1200, 1400, 1600, 1800, 2000, 2200, 2500}
+
+// Explorer is a client for the opening explorer and cloud eval.
+type Explorer struct {
+	// Timeout limits each request, 0 = none.
+	Timeout time.Duration
+
+	// Cache keeps results by URL for the life of the Explorer.
+	Cache bool
+
+	explorerURL  string
+	cloudEvalURL string
+
+	mtx        sync.Mutex
+	lookups    map[string]PositionResults
+	cloudEvals map[string]CloudEvalResults
+}
+
+// DefaultExplorer is used by Lookup and CloudEval.
+var DefaultExplorer = NewExplorer()
+
+func NewExplorer() *Explorer {
+	return &Explorer{
+		Timeout:      defaultExplorerTimeout,
+		explorerURL:  "https://explorer.lichess.ovh",
+		cloudEvalURL: "https://lichess.org/api/cloud-eval",
+		lookups:      make(map[string]PositionResults),
+		cloudEvals:   make(map[string]CloudEvalResults),
+	}
+}
+
+// Lookup gets the explorer results for fen after play, without top or recent games.
+func Lookup(db LookupDatabase, fen string, play ...string) (PositionResults, error) {
+	return DefaultExplorer.Lookup(context.Background(), db, fen, LookupOptions{Play: play})
+}
+
+// CloudEval gets the cloud eval for fenPos.
+func CloudEval(fenPos string, multiPV int) (CloudEvalResults, error) {
+	return DefaultExplorer.CloudEval(context.Background(), fenPos, multiPV)
+}
+
+func (e *Explorer) Lookup(ctx context.Context, db LookupDatabase, fen string, opts LookupOptions) (PositionResults, error) {
+	var result PositionResults
+
+	u, err := url.Parse(fmt.Sprintf("%s/%s", e.explorerURL, db))
+	if err != nil {
+		return result, err
+	}
+	q := u.Query()
+	if fen == "" || fen == "start" || fen == "startpos" {
+		fen = startPosFEN
+	}
+	q.Add("fen", fen)
+	if len(opts.Play) != 0 {
+		q.Add("play", strings.Join(opts.Play, ","))
+	}
+	q.Add("topGames", strconv.Itoa(opts.TopGames))
+	if db == Lichess {
+		q.Add("recentGames", strconv.Itoa(opts.RecentGames))
+		q.Add("speeds", allSpeeds)
+		ratings := allRatings
+		if len(opts.Ratings) != 0 {
+			groups := make([]string, len(opts.Ratings))
+			for i, r := range opts.Ratings {
+				groups[i] = strconv.Itoa(r)
+			}
+			ratings = strings.Join(groups, ",")
+		}
+		q.Add("ratings", ratings)
+		if opts.History {
+			q.Add("history", "true")
+		}
+	}
+	u.RawQuery = q.Encode()
+	endpoint := u.String()
+
+	if hit, ok := cached(e, e.lookups, endpoint); ok {
+		return hit, nil
+	}
+
+	if err := e.get(ctx, endpoint, &result); err != nil {
+		return PositionResults{}, err
+	}
+
+	result.computePercentages()
+
+	store(e, e.lookups, endpoint, result)
+	return result, nil
+}
+
+// computePercentages sets the totals and each move's result and popularity percentages.
+func (r *PositionResults) computePercentages() {
+	total := r.White + r.Black + r.Draws
+	r.TotalGames = total
+
+	for i := 0; i < len(r.Moves); i++ {
+		move := &r.Moves[i]
+
+		moveTotal := move.White + move.Black + move.Draws
+		move.TotalGames = moveTotal
+		if moveTotal == 0 {
+			continue
+		}
+
+		move.WhitePercent = float64(move.White) / float64(moveTotal) * 100
+		move.BlackPercent = float64(move.Black) / float64(moveTotal) * 100
+		move.DrawsPercent = float64(move.Draws) / float64(moveTotal) * 100
+		move.PopularityPercent = float64(moveTotal) / float64(total) * 100
+	}
+}
+
+type CloudEvalResults struct {
+	FEN    string `json:"fen"`
+	KNodes int    `json:"knodes"`
+	Depth  int    `json:"depth"`
+	PVs    []PV   `json:"pvs"`
+}
+
+type PV struct {
+	Moves string `json:"moves"`
+	CP    int    `json:"cp"`
+	Mate  int    `json:"mate"`
+}
+
+// MarshalJSON writes either cp or mate, like lichess does.
+func (pv PV) MarshalJSON() ([]byte, error) {
+	if pv.Mate != 0 {
+		return json.Marshal(struct {
+			Moves string `json:"moves"`
+			Mate  int    `json:"mate"`
+		}{pv.Moves, pv.Mate})
+	}
+
+	return json.Marshal(struct {
+		Moves string `json:"moves"`
+		CP    int    `json:"cp"`
+	}{pv.Moves, pv.CP})
+}
+
+func (e *Explorer) CloudEval(ctx context.Context, fenPos string, multiPV int) (CloudEvalResults, error) {
+	u, err := url.Parse(e.cloudEvalURL)
+	if err != nil {
+		return CloudEvalResults{}, err
+	}
+	q := u.Query()
+	if fenPos == "" || fenPos == "start" || fenPos == "startpos" {
+		fenPos = startPosFEN
+	}
+	q.Add("fen", fenPos)
+	q.Add("multiPv", strconv.Itoa(multiPV))
+	u.RawQuery = q.Encode()
+	endpoint := u.String()
+
+	if hit, ok := cached(e, e.cloudEvals, endpoint); ok {
+		return hit, nil
+	}
+
+	var result CloudEvalResults
+	if err := e.get(ctx, endpoint, &result); err != nil {
+		return CloudEvalResults{}, err
+	}
+
+	// unfortunately, this is the most convenient place to do the castling translation currently
+	for i := 0; i < len(result.PVs); i++ {
+		if len(result.PVs[i].Moves) == 0 {
+			continue
+		}
+
+		board := fen.FENtoBoard(fenPos)
+		moves := strings.Split(result.PVs[i].Moves, " ")
+		sans := board.UCItoSANs(moves...)
+
+		moves, err = board.SANtoUCIs(sans...)
+		if err != nil {
+			return CloudEvalResults{}, err
+		}
+
+		result.PVs[i].Moves = strings.Join(moves, " ")
+	}
+
+	store(e, e.cloudEvals, endpoint, result)
+	return result, nil
+}
+
+// get decodes the JSON response from endpoint into v as it's read.
+func (e *Explorer) get(ctx context.Context, endpoint string, v any) error {
+	if e.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("http.NewRequest: '%s' %v", endpoint, err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("client.Do: '%s' %v", endpoint, err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		b, _ := io.ReadAll(resp.Body)
+		return statusError(resp, endpoint, b)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("decode '%s': %v", endpoint, err)
+	}
+
+	return nil
+}
+
+// cached returns the result for endpoint if caching is on and there is one.
+func cached[T any](e *Explorer, m map[string]T, endpoint string) (T, bool) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	v, ok := m[endpoint]
+	return v, ok && e.Cache
+}
+
+func store[T any](e *Explorer, m map[string]T, endpoint string, v T) {
+	if !e.Cache {
+		return
+	}
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	m[endpoint] = v
+}
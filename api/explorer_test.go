@@ -0,0 +1,58 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExplorer_Lookup(t *testing.T) {
+	// arrange
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path+"?"+r.URL.RawQuery)
+		fmt.Fprint(w, `{"white":6,"draws":2,"black":2,
+			"moves":[{"uci":"e2e4","san":"e4","white":3,"draws":1,"black":0},{"uci":"a2a3","san":"a3","white":0,"draws":0,"black":0}],
+			"recentGames":[{"uci":"e2e4","id":"abcd1234","winner":"white","white":{"name":"a","rating":2000},"black":{"name":"b","rating":1900},"year":2022}],
+			"history":[{"month":"2022-01","white":1,"draws":0,"black":1}]}`)
+	}))
+	defer server.Close()
+
+	e := NewExplorer()
+	e.explorerURL = server.URL
+	e.Cache = true
+	opts := LookupOptions{RecentGames: 1, History: true}
+
+	// act
+	got, err := e.Lookup(context.Background(), Lichess, "", opts)
+	_, cachedErr := e.Lookup(context.Background(), Lichess, "", opts)
+
+	// assert
+	if err != nil || cachedErr != nil {
+		t.Fatalf("got %v %v", err, cachedErr)
+	}
+	if len(requests) != 1 {
+		t.Errorf("requests: got %d, want 1 with caching", len(requests))
+	}
+	if got.TotalGames != 10 || got.Moves[0].TotalGames != 4 || got.Moves[0].WhitePercent != 75 || got.Moves[0].PopularityPercent != 40 {
+		t.Errorf("totals: got %+v", got)
+	}
+	if got.Moves[1].WhitePercent != 0 {
+		t.Errorf("move without games: got %+v", got.Moves[1])
+	}
+	if len(got.RecentGames) != 1 || got.RecentGames[0].White.Rating != 2000 || len(got.History) != 1 {
+		t.Errorf("games: got %+v %+v", got.RecentGames, got.History)
+	}
+
+	// a rating band
+	opts.Ratings = []int{2200, 2500}
+	if _, err := e.Lookup(context.Background(), Lichess, "", opts); err != nil {
+		t.Fatal(err)
+	}
+	if last := requests[len(requests)-1]; !strings.Contains(last, "ratings=2200%2C2500") {
+		t.Errorf("ratings: got request %s", last)
+	}
+}
@@ -17,7 +17,7 @@ type GameEventInfo struct {
 	FullID      string   `json:"fullId"`
 	GameID      string   `json:"gameId"`
 	FEN         string   `json:"fen"`
-	Color       string   `json:"color"`
+	Color       string   `json:"color"` // ours, "white" or "black"
 	LastMove    string   `json:"lastMove"`
 	Source      string   `json:"source"`
 	Variant     Variant  `json:"variant"`
@@ -56,6 +56,8 @@ type GameFull struct {
 	Black      Player  `json:"black"`
 	InitialFEN string  `json:"initialFen"`
 	State      State   `json:"state"`
+
+	TournamentID string `json:"tournamentId,omitempty"` // arena games only
 }
 
 type Clock struct {
@@ -115,9 +117,17 @@ type UserShort struct {
 }
 
 type UserShortRating struct {
-	User       UserShort `json:"user"`
-	Rating     int       `json:"rating"`
-	RatingDiff int       `json:"ratingDiff"`
+	User       UserShort       `json:"user"`
+	Rating     int             `json:"rating"`
+	RatingDiff int             `json:"ratingDiff"`
+	Analysis   *PlayerAnalysis `json:"analysis,omitempty"` // only when the game has a computer analysis
+}
+
+type PlayerAnalysis struct {
+	Inaccuracy int `json:"inaccuracy"`
+	Mistake    int `json:"mistake"`
+	Blunder    int `json:"blunder"`
+	ACPL       int `json:"acpl"`
 }
 
 type Players struct {
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// gifExportURL is lichess' game GIF renderer.
+var gifExportURL = "https://lichess1.org/game/export/gif"
+
+// GIFOptions are the optional parts of a game GIF export.
+type GIFOptions struct {
+	// Color is the side at the bottom, "white" or "black". Empty is white.
+	Color string
+	// Thumbnail is a small animation of the game instead of the full size one.
+	Thumbnail bool
+}
+
+// ExportGIF returns an animated GIF of a finished game, rendered by lichess.
+func ExportGIF(ctx context.Context, gameID string, opts GIFOptions) ([]byte, error) {
+	var endpoint string
+	if opts.Thumbnail {
+		endpoint = fmt.Sprintf("%s/thumbnail/%s.gif", gifExportURL, url.PathEscape(gameID))
+	} else {
+		color := opts.Color
+		if color == "" {
+			color = "white"
+		}
+		endpoint = fmt.Sprintf("%s/%s/%s.gif", gifExportURL, url.PathEscape(color), url.PathEscape(gameID))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("http.NewRequest: '%s' %v", endpoint, err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("client.Do: '%s' %v", endpoint, err)
+	}
+
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read '%s': %v", endpoint, err)
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, statusError(resp, endpoint, b)
+	}
+
+	return b, nil
+}
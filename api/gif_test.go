@@ -0,0 +1,52 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExportGIF(t *testing.T) {
+	// arrange
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if r.URL.Path == "/gif/white/missing.gif" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/gif")
+		_, _ = w.Write([]byte("GIF89a"))
+	}))
+	defer server.Close()
+
+	saved := gifExportURL
+	gifExportURL = server.URL + "/gif"
+	defer func() { gifExportURL = saved }()
+
+	ctx := context.Background()
+
+	// act
+	full, err := ExportGIF(ctx, "abcd1234", GIFOptions{Color: "black"})
+	_, thumbErr := ExportGIF(ctx, "abcd1234", GIFOptions{Thumbnail: true})
+	_, missingErr := ExportGIF(ctx, "missing", GIFOptions{})
+
+	// assert
+	if err != nil || thumbErr != nil {
+		t.Fatalf("got %v %v", err, thumbErr)
+	}
+	if string(full) != "GIF89a" {
+		t.Errorf("got '%s', want the GIF's bytes", full)
+	}
+	want := []string{"/gif/black/abcd1234.gif", "/gif/thumbnail/abcd1234.gif", "/gif/white/missing.gif"}
+	for i, path := range want {
+		if i >= len(paths) || paths[i] != path {
+			t.Errorf("request %d: got %v, want %s", i+1, paths, path)
+		}
+	}
+	if !errors.Is(missingErr, ErrNotFound) {
+		t.Errorf("missing: got %v, want ErrNotFound", missingErr)
+	}
+}
@@ -2,10 +2,10 @@ package api
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
 	"net/http"
 	"net/url"
@@ -13,41 +13,32 @@ import (
 	"strconv"
 	"strings"
 	"time"
-
-	"trollfish-lichess/fen"
 )
 
 const allRatings = "1600,1800,2000,2200,2500"
 const allSpeeds = "bullet,blitz,rapid,classical,correspondence"
 const startPosFEN = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
 
-var ErrNotFound = errors.New("http 404 error")
-
-type Move struct {
-	UCI           string `json:"uci"`
-	SAN           string `json:"san"`
-	AverageRating int    `json:"averageRating"`
-
-	White      int `json:"white"`
-	Black      int `json:"black"`
-	Draws      int `json:"draws"`
-	TotalGames int `json:"total_games"`
+// gamesExportURL and botsOnlineURL are the bulk endpoints, replaced by tests.
+var (
+	gamesExportURL = "https://lichess.org/api/games/user"
+	botsOnlineURL  = "https://lichess.org/api/bot/online"
+)
 
-	WhitePercent      float64 `json:"white_pct"`
-	BlackPercent      float64 `json:"black_pct"`
-	DrawsPercent      float64 `json:"draws_pct"`
-	PopularityPercent float64 `json:"popularity_pct"`
-}
+// gamesPageSize is how many games GetGames asks for a request; each page continues from the
+// last game's creation time, so a long export doesn't depend on a single connection.
+const gamesPageSize = 1000
 
-type PositionResults struct {
-	White      int    `json:"white"`
-	Draws      int    `json:"draws"`
-	Black      int    `json:"black"`
-	Moves      []Move `json:"moves"`
-	TotalGames int    `json:"total_games"`
+// GamesOptions narrow down the games GetGames downloads.
+type GamesOptions struct {
+	Max   int       // 0 for all
+	Evals bool      // analysed games have lichess' server analysis as [%eval] comments
+	Since time.Time // games created at or after, zero for the first
+	Until time.Time // games created before, zero for the last
 }
 
-func GetGames(username string, count int) (string, int, error) {
+// GetGames saves username's rated games to username.pgn, oldest first.
+func GetGames(username string, opts GamesOptions) (string, int, error) {
 	filename := username + ".pgn"
 	fp, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 	if err != nil {
@@ -60,218 +51,115 @@ func GetGames(username string, count int) (string, int, error) {
 		fp.Close()
 	}()
 
-	var downloaded int
-	handler := func(ndjson []byte) bool {
-		var game CompletedGame
-		if err := json.Unmarshal(ndjson, &game); err != nil {
-			log.Fatal(err)
+	var (
+		downloaded int
+		writeErr   error
+	)
+	pages := gamesPaginator(username, opts)
+	pages.Progress = func(read int) {
+		fmt.Printf("%s %s: %d game(s)\n", ts(), username, read)
+	}
+	err = pages.Each(context.Background(), func(game CompletedGame) bool {
+		if _, writeErr = w.WriteString(game.PGN + "\n"); writeErr != nil {
+			return false
 		}
-
-		_, err := w.WriteString(game.PGN + "\n")
-		if err != nil {
-			log.Fatal(err)
-		}
-
 		downloaded++
-
 		return true
-	}
-
-	u, err := url.Parse(fmt.Sprintf("https://lichess.org/api/games/user/%s", url.PathEscape(username)))
+	})
 	if err != nil {
-		return filename, 0, err
+		return filename, downloaded, err
 	}
-	q := u.Query()
-	//q.Add("since", unixMilli(time.Now().Add(-60*24*time.Hour)))
-	//q.Add("analysed", "true") // TODO: may want to turn this off
-	//q.Add("until", unixMilli(until))
-	q.Add("sort", "dateAsc")
-	q.Add("perfType", allSpeeds)
-	//q.Add("evals", "true")
-	q.Add("opening", "true")
-	q.Add("rated", "true")
-	if count > 0 {
-		q.Add("max", itoa(count))
-	}
-	q.Add("pgnInJson", "true")
-	//q.Add("clocks", "true")
-	u.RawQuery = q.Encode()
-
-	endpoint := u.String()
-	if err := ReadStream(endpoint, handler); err != nil {
-		return filename, 0, err
+	if writeErr != nil {
+		return filename, downloaded, fmt.Errorf("'%s': %v", filename, writeErr)
 	}
 
 	return filename, downloaded, nil
 }
 
-type LookupDatabase string
-
-const (
-	Masters LookupDatabase = "masters"
-	Lichess LookupDatabase = "lichess"
-)
-
-func Lookup(db LookupDatabase, fen string, play ...string) (PositionResults, error) {
-	var result PositionResults
-
-	u, err := url.Parse(fmt.Sprintf("https://explorer.lichess.ovh/%s", db))
-	if err != nil {
-		return result, err
-	}
-	q := u.Query()
-	if fen == "" || fen == "start" || fen == "startpos" {
-		fen = startPosFEN
+// gamesPaginator pages through username's rated games, oldest first, see GetGames.
+func gamesPaginator(username string, opts GamesOptions) Paginator[CompletedGame] {
+	var start string
+	if !opts.Since.IsZero() {
+		start = unixMilli(opts.Since)
 	}
-	q.Add("fen", fen)
-	if len(play) != 0 {
-		q.Add("play", strings.Join(play, ","))
-	}
-	q.Add("recentGames", "0")
-	q.Add("topGames", "0")
-	q.Add("speeds", allSpeeds)
-	q.Add("ratings", allRatings)
-	u.RawQuery = q.Encode()
-
-	resp, err := http.Get(u.String())
-	if err != nil {
-		return result, err
-	}
-
-	defer resp.Body.Close()
-
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return result, err
-	}
-
-	if resp.StatusCode != 200 {
-		return result, fmt.Errorf("http status code %d. %s", resp.StatusCode, b)
-	}
-
-	if err := json.Unmarshal(b, &result); err != nil {
-		return result, fmt.Errorf("%v. %s", err, b)
-	}
-
-	total := result.White + result.Black + result.Draws
-	result.TotalGames = total
-
-	for i := 0; i < len(result.Moves); i++ {
-		move := result.Moves[i]
-
-		moveTotal := move.White + move.Black + move.Draws
 
-		popularity := float64(moveTotal) / float64(total) * 100
-		white := float64(move.White) / float64(moveTotal) * 100
-		black := float64(move.Black) / float64(moveTotal) * 100
-		draw := float64(move.Draws) / float64(moveTotal) * 100
-
-		result.Moves[i].WhitePercent = white
-		result.Moves[i].BlackPercent = black
-		result.Moves[i].DrawsPercent = draw
-		result.Moves[i].PopularityPercent = popularity
-		result.Moves[i].TotalGames = moveTotal
+	return Paginator[CompletedGame]{
+		URL: func(cursor string, max int) string {
+			q := url.Values{}
+			//q.Add("analysed", "true") // TODO: may want to turn this off
+			if cursor != "" {
+				q.Add("since", cursor)
+			}
+			if !opts.Until.IsZero() {
+				q.Add("until", unixMilli(opts.Until.Add(-time.Millisecond))) // lichess' until is inclusive
+			}
+			q.Add("sort", "dateAsc")
+			q.Add("perfType", allSpeeds)
+			if opts.Evals {
+				q.Add("evals", "true")
+			}
+			q.Add("opening", "true")
+			q.Add("rated", "true")
+			if max > 0 {
+				q.Add("max", itoa(max))
+			}
+			q.Add("pgnInJson", "true")
+			//q.Add("clocks", "true")
+			return fmt.Sprintf("%s/%s?%s", gamesExportURL, url.PathEscape(username), q.Encode())
+		},
+		Start:    start,
+		Cursor:   func(game CompletedGame) string { return itoa64(game.CreatedAt + 1) },
+		PageSize: gamesPageSize,
+		Max:      opts.Max,
+		Interval: time.Second,
 	}
-
-	return result, nil
 }
 
-type CloudEvalResults struct {
-	FEN    string `json:"fen"`
-	KNodes int    `json:"knodes"`
-	Depth  int    `json:"depth"`
-	PVs    []PV   `json:"pvs"`
+// ReadStream calls handler for each line of an ndjson stream until handler returns false,
+// the stream ends or ctx is done.
+func ReadStream(ctx context.Context, endpoint string, handler func([]byte) bool) error {
+	return ReadStreamNotify(ctx, endpoint, StreamHooks{}, handler)
 }
 
-type PV struct {
-	Moves string `json:"moves"`
-	CP    int    `json:"cp"`
-	Mate  int    `json:"mate"`
+// StreamHooks are called by ReadStreamNotify, either may be nil.
+type StreamHooks struct {
+	Connected func() // once lichess answers with a 200, before the first line
+	Line      func() // for every line, including the empty keep-alive lines
 }
 
-func CloudEval(fenPos string, multiPV int) (CloudEvalResults, error) {
-	u, err := url.Parse("https://lichess.org/api/cloud-eval")
-	if err != nil {
-		return CloudEvalResults{}, err
-	}
-	q := u.Query()
-	if fenPos == "" || fenPos == "start" || fenPos == "startpos" {
-		fenPos = startPosFEN
-	}
-	q.Add("fen", fenPos)
-	q.Add("multiPv", strconv.Itoa(multiPV))
-	u.RawQuery = q.Encode()
-
-	resp, err := http.Get(u.String())
-	if err != nil {
-		return CloudEvalResults{}, err
-	}
-
-	defer resp.Body.Close()
-
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return CloudEvalResults{}, err
-	}
-
-	if resp.StatusCode != 200 {
-		if resp.StatusCode == 404 {
-			return CloudEvalResults{}, ErrNotFound
-		}
-		return CloudEvalResults{}, fmt.Errorf("http status code %d. %s", resp.StatusCode, b)
-	}
-
-	var result CloudEvalResults
-	if err := json.Unmarshal(b, &result); err != nil {
-		return CloudEvalResults{}, fmt.Errorf("%v. %s", err, b)
-	}
-
-	// unfortunately, this is the most convenient place to do the castling translation currently
-	for i := 0; i < len(result.PVs); i++ {
-		if len(result.PVs[i].Moves) == 0 {
-			continue
-		}
-
-		board := fen.FENtoBoard(fenPos)
-		moves := strings.Split(result.PVs[i].Moves, " ")
-		sans := board.UCItoSANs(moves...)
-
-		moves, err = board.SANtoUCIs(sans...)
-		if err != nil {
-			return CloudEvalResults{}, err
-		}
-
-		result.PVs[i].Moves = strings.Join(moves, " ")
-	}
-
-	return result, nil
-}
-
-func ReadStream(endpoint string, handler func([]byte) bool) error {
+// ReadStreamNotify is ReadStream, calling hooks as the stream connects and reads.
+func ReadStreamNotify(ctx context.Context, endpoint string, hooks StreamHooks, handler func([]byte) bool) error {
 	fmt.Printf("%s %s\n", ts(), endpoint)
 
-	req, err := http.NewRequest("GET", endpoint, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return fmt.Errorf("http.NewRequest: '%s' %v", endpoint, err)
 	}
 
 	req.Header.Add("Authorization", AuthToken())
 	req.Header.Add("Accept", "application/x-ndjson")
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("http.DefaultClient.Do: '%s' %v", endpoint, err)
+		return fmt.Errorf("client.Do: '%s' %v", endpoint, err)
 	}
 
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		b, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf("http status code %d '%s' body: '%s'", resp.StatusCode, endpoint, b)
+		b, _ := io.ReadAll(resp.Body)
+		return statusError(resp, endpoint, b)
+	}
+
+	if hooks.Connected != nil {
+		hooks.Connected()
 	}
 
 	r := bufio.NewScanner(resp.Body)
 	for r.Scan() {
 		ndjson := r.Bytes()
+		if hooks.Line != nil {
+			hooks.Line()
+		}
 
 		if len(ndjson) != 0 {
 			continueRead := handler(ndjson)
@@ -302,20 +190,15 @@ type BotInfo struct {
 	Draw        int
 }
 
-func StreamBots() (*BotQueue, error) {
+func StreamBots(ctx context.Context) (*BotQueue, error) {
 	var q BotQueue
 
-	handler := func(ndjson []byte) bool {
-		var user User
-		if err := json.Unmarshal(ndjson, &user); err != nil {
-			log.Fatal(err)
-		}
-
+	pages := Paginator[User]{URL: func(string, int) string { return botsOnlineURL }}
+	err := pages.Each(ctx, func(user User) bool {
 		q.Bots = append(q.Bots, &BotInfo{User: user})
 		return true
-	}
-
-	if err := ReadStream("https://lichess.org/api/bot/online", handler); err != nil {
+	})
+	if err != nil {
 		return nil, err
 	}
 
@@ -341,17 +224,17 @@ func DeclineChallenge(id, reason string) error {
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Add("Content-Length", fmt.Sprintf("%d", len(body)))
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("http.DefaultClient.Do: '%s' %v", endpoint, err)
+		return fmt.Errorf("client.Do: '%s' %v", endpoint, err)
 	}
 
 	defer resp.Body.Close()
 
-	b, _ := ioutil.ReadAll(resp.Body)
+	b, _ := io.ReadAll(resp.Body)
 
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("http status code %d '%s' body: '%s'", resp.StatusCode, endpoint, b)
+		return statusError(resp, endpoint, b)
 	}
 
 	return nil
@@ -385,17 +268,17 @@ func AcceptChallenge(id string) error {
 
 	req.Header.Add("Authorization", AuthToken())
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("http.DefaultClient.Do: '%s' %v", endpoint, err)
+		return fmt.Errorf("client.Do: '%s' %v", endpoint, err)
 	}
 
 	defer resp.Body.Close()
 
-	b, _ := ioutil.ReadAll(resp.Body)
+	b, _ := io.ReadAll(resp.Body)
 
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("http status code %d '%s' body: '%s'", resp.StatusCode, endpoint, b)
+		return statusError(resp, endpoint, b)
 	}
 
 	return nil
@@ -413,17 +296,45 @@ func AddTime(gameID string, seconds int) error {
 
 	req.Header.Add("Authorization", AuthToken())
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("http.DefaultClient.Do: '%s' %v", endpoint, err)
+		return fmt.Errorf("client.Do: '%s' %v", endpoint, err)
 	}
 
 	defer resp.Body.Close()
 
-	b, _ := ioutil.ReadAll(resp.Body)
+	b, _ := io.ReadAll(resp.Body)
 
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("http status code %d '%s' body: '%s'", resp.StatusCode, endpoint, b)
+		return statusError(resp, endpoint, b)
+	}
+
+	return nil
+}
+
+func Resign(gameID string) error {
+	fmt.Printf("%s REQ: %s\n", ts(), "Resign")
+
+	endpoint := fmt.Sprintf("https://lichess.org/api/bot/game/%s/resign", gameID)
+
+	req, err := http.NewRequest("POST", endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("http.NewRequest: '%s' %v", endpoint, err)
+	}
+
+	req.Header.Add("Authorization", AuthToken())
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("client.Do: '%s' %v", endpoint, err)
+	}
+
+	defer resp.Body.Close()
+
+	b, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != 200 {
+		return statusError(resp, endpoint, b)
 	}
 
 	return nil
@@ -449,16 +360,16 @@ func PlayMove(gameID, move string, draw bool) error {
 
 	req.Header.Add("Authorization", AuthToken())
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("http.DefaultClient.Do: '%s' %v", endpoint, err)
+		return fmt.Errorf("client.Do: '%s' %v", endpoint, err)
 	}
 
-	b, _ := ioutil.ReadAll(resp.Body)
+	b, _ := io.ReadAll(resp.Body)
 	resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("http status code %d '%s' body: '%s'", resp.StatusCode, endpoint, b)
+		return statusError(resp, endpoint, b)
 	}
 
 	return nil
@@ -481,22 +392,67 @@ func Chat(gameID, room, text string) error {
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Add("Content-Length", fmt.Sprintf("%d", len(body)))
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("http.DefaultClient.Do: '%s' %v", endpoint, err)
+		return fmt.Errorf("client.Do: '%s' %v", endpoint, err)
 	}
 
 	defer resp.Body.Close()
 
-	b, _ := ioutil.ReadAll(resp.Body)
+	b, _ := io.ReadAll(resp.Body)
 
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("http status code %d '%s' body: '%s'", resp.StatusCode, endpoint, b)
+		return statusError(resp, endpoint, b)
 	}
 
 	return nil
 }
 
+// BroadcastPushGame is lichess' reply for one game of a broadcast push.
+type BroadcastPushGame struct {
+	Tags  map[string]string `json:"tags"`
+	Moves int               `json:"moves"`
+	Error string            `json:"error,omitempty"`
+}
+
+type BroadcastPushResult struct {
+	Games []BroadcastPushGame `json:"games"`
+}
+
+// BroadcastPush sends the games in pgn to a broadcast round, which lichess matches to the
+// round's games by their tags. The token needs the study:write scope.
+func BroadcastPush(roundID, pgn string) (BroadcastPushResult, error) {
+	endpoint := fmt.Sprintf("https://lichess.org/api/broadcast/round/%s/push", roundID)
+
+	req, err := http.NewRequest("POST", endpoint, strings.NewReader(pgn))
+	if err != nil {
+		return BroadcastPushResult{}, fmt.Errorf("http.NewRequest: '%s' %v", endpoint, err)
+	}
+
+	req.Header.Add("Authorization", AuthToken())
+	req.Header.Add("Content-Type", "text/plain")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return BroadcastPushResult{}, fmt.Errorf("client.Do: '%s' %v", endpoint, err)
+	}
+
+	defer resp.Body.Close()
+
+	b, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != 200 {
+		return BroadcastPushResult{}, statusError(resp, endpoint, b)
+	}
+
+	var result BroadcastPushResult
+	if err := json.Unmarshal(b, &result); err != nil {
+		return BroadcastPushResult{}, fmt.Errorf("json.Unmarshal: '%s' %v body: '%s'", endpoint, err, b)
+	}
+
+	return result, nil
+}
+
 func CreateChallenge(id string, rated bool, clockLimit, clockIncrement int, color, variant, fenPos string) (string, error) {
 	fmt.Printf("%s REQ: %s '%s'\n", ts(), "CreateChallenge", id)
 
@@ -522,17 +478,17 @@ func CreateChallenge(id string, rated bool, clockLimit, clockIncrement int, colo
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Add("Content-Length", fmt.Sprintf("%d", len(body)))
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("http.DefaultClient.Do: '%s' %v", endpoint, err)
+		return "", fmt.Errorf("client.Do: '%s' %v", endpoint, err)
 	}
 
 	defer resp.Body.Close()
 
-	b, _ := ioutil.ReadAll(resp.Body)
+	b, _ := io.ReadAll(resp.Body)
 
 	if resp.StatusCode != 200 {
-		return "", fmt.Errorf("http status code %d '%s' body: '%s'", resp.StatusCode, endpoint, b)
+		return "", statusError(resp, endpoint, b)
 	}
 
 	var response struct {
@@ -548,6 +504,39 @@ func CreateChallenge(id string, rated bool, clockLimit, clockIncrement int, colo
 	return response.Challenge.ID, nil
 }
 
+// GetGame exports a single game. Rating diffs are only set once lichess has finished the game.
+func GetGame(gameID string) (CompletedGame, error) {
+	endpoint := fmt.Sprintf("https://lichess.org/game/export/%s", url.PathEscape(gameID))
+
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return CompletedGame{}, fmt.Errorf("http.NewRequest: '%s' %v", endpoint, err)
+	}
+
+	req.Header.Add("Authorization", AuthToken())
+	req.Header.Add("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return CompletedGame{}, fmt.Errorf("client.Do: '%s' %v", endpoint, err)
+	}
+
+	defer resp.Body.Close()
+
+	b, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != 200 {
+		return CompletedGame{}, statusError(resp, endpoint, b)
+	}
+
+	var game CompletedGame
+	if err := json.Unmarshal(b, &game); err != nil {
+		return CompletedGame{}, fmt.Errorf("json.Unmarshal: '%s' %w body: '%s'", endpoint, err, b)
+	}
+
+	return game, nil
+}
+
 func CancelChallenge(id string) error {
 	fmt.Printf("%s REQ: %s\n", ts(), "CancelChallenge")
 
@@ -560,17 +549,17 @@ func CancelChallenge(id string) error {
 
 	req.Header.Add("Authorization", AuthToken())
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("http.DefaultClient.Do: '%s' %v", endpoint, err)
+		return fmt.Errorf("client.Do: '%s' %v", endpoint, err)
 	}
 
 	defer resp.Body.Close()
 
-	b, _ := ioutil.ReadAll(resp.Body)
+	b, _ := io.ReadAll(resp.Body)
 
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("http status code %d '%s' body: '%s'", resp.StatusCode, endpoint, b)
+		return statusError(resp, endpoint, b)
 	}
 
 	return nil
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// maxPageRetries is how many times a rate limited page is retried before giving up.
+const maxPageRetries = 3
+
+// waitPage waits d or until ctx is done, replaced by tests.
+var waitPage = func(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Paginator reads a bulk ndjson endpoint page by page, each page starting at the cursor of
+// the previous page's last item, e.g. 'since' the last game's creation time. Endpoints read
+// in one go leave Cursor nil.
+type Paginator[T any] struct {
+	URL      func(cursor string, max int) string // the page's endpoint, max 0 for no limit
+	Start    string                              // the first page's cursor, may be empty
+	Cursor   func(item T) string                 // where the page after item starts
+	PageSize int                                 // items a page, 0 to read the endpoint in one page
+	Max      int                                 // items in all, 0 for all of them
+	Interval time.Duration                       // between pages, to stay under lichess' rate limits
+	Progress func(read int)                      // after each page, may be nil
+}
+
+// Each calls handler for each item until handler returns false, the items run out, Max items
+// are read or ctx is done. Rate limited pages are retried after the wait lichess asks for.
+func (p Paginator[T]) Each(ctx context.Context, handler func(T) bool) error {
+	cursor := p.Start
+	var read int
+
+	for {
+		max := p.PageSize
+		if p.Max > 0 && (max == 0 || p.Max-read < max) {
+			max = p.Max - read
+		}
+
+		var (
+			pageRead int
+			stopped  bool
+			itemErr  error
+		)
+		lineHandler := func(ndjson []byte) bool {
+			var item T
+			if err := json.Unmarshal(ndjson, &item); err != nil {
+				itemErr = fmt.Errorf("item %d: %v", read+1, err)
+				return false
+			}
+			pageRead++
+			read++
+			if p.Cursor != nil {
+				cursor = p.Cursor(item)
+			}
+			if !handler(item) {
+				stopped = true
+				return false
+			}
+			return max == 0 || pageRead < max
+		}
+
+		endpoint := p.URL(cursor, max)
+		for retry := 0; ; retry++ {
+			err := ReadStream(ctx, endpoint, lineHandler)
+			if err == nil {
+				break
+			}
+			wait, rateLimited := RetryAfter(err)
+			if !rateLimited || pageRead != 0 || retry == maxPageRetries {
+				return err
+			}
+			fmt.Printf("%s rate limited, retrying '%s' in %v\n", ts(), endpoint, wait)
+			if err := waitPage(ctx, wait); err != nil {
+				return err
+			}
+		}
+		if itemErr != nil {
+			return fmt.Errorf("'%s': %v", endpoint, itemErr)
+		}
+
+		if p.Progress != nil {
+			p.Progress(read)
+		}
+
+		if stopped || p.Cursor == nil || p.PageSize == 0 || pageRead < max || (p.Max > 0 && read >= p.Max) {
+			return nil
+		}
+
+		if p.Interval > 0 {
+			if err := waitPage(ctx, p.Interval); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPaginator_Each(t *testing.T) {
+	// arrange
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+		since, _ := strconv.Atoi(r.URL.Query().Get("since"))
+		max, _ := strconv.Atoi(r.URL.Query().Get("max"))
+		for n := 0; n < max && since+n < 5; n++ {
+			fmt.Fprintf(w, "{\"id\":\"game%d\",\"createdAt\":%d,\"pgn\":\"1. e4\"}\n\n", since+n, since+n)
+		}
+	}))
+	defer server.Close()
+
+	savedURL, savedToken, savedWait := gamesExportURL, lichessBotToken, waitPage
+	gamesExportURL, lichessBotToken = server.URL, "Bearer test"
+	waitPage = func(context.Context, time.Duration) error { return nil }
+	defer func() { gamesExportURL, lichessBotToken, waitPage = savedURL, savedToken, savedWait }()
+
+	tests := []struct {
+		name     string
+		pageSize int
+		max      int
+		want     []string
+		progress []int
+	}{
+		{name: "all", pageSize: 2, want: []string{"game0", "game1", "game2", "game3", "game4"}, progress: []int{2, 4, 5}},
+		{name: "max", pageSize: 2, max: 3, want: []string{"game0", "game1", "game2"}, progress: []int{2, 3}},
+		{name: "one page", max: 2, want: []string{"game0", "game1"}, progress: []int{2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queries = nil
+			pages := gamesPaginator("bot", GamesOptions{Max: tt.max})
+			pages.PageSize = tt.pageSize
+			var progress []int
+			pages.Progress = func(read int) { progress = append(progress, read) }
+
+			// act
+			var ids []string
+			err := pages.Each(context.Background(), func(game CompletedGame) bool {
+				ids = append(ids, game.ID)
+				return true
+			})
+
+			// assert
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(ids) != fmt.Sprint(tt.want) {
+				t.Errorf("got %v, want %v (queries %v)", ids, tt.want, queries)
+			}
+			if fmt.Sprint(progress) != fmt.Sprint(tt.progress) {
+				t.Errorf("progress: got %v, want %v", progress, tt.progress)
+			}
+		})
+	}
+}
+
+func TestPaginator_RateLimited(t *testing.T) {
+	// arrange
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.Header().Set("Retry-After", "5")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprintln(w, `{"id":"bot1"}`)
+		fmt.Fprintln(w, `{"id":"bot2"}`)
+	}))
+	defer server.Close()
+
+	savedURL, savedToken, savedWait := botsOnlineURL, lichessBotToken, waitPage
+	botsOnlineURL, lichessBotToken = server.URL, "Bearer test"
+	var waits []time.Duration
+	waitPage = func(_ context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+	defer func() { botsOnlineURL, lichessBotToken, waitPage = savedURL, savedToken, savedWait }()
+
+	// act
+	q, err := StreamBots(context.Background())
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(q.Bots) != 2 || q.Bots[0].User.ID != "bot1" || q.Bots[1].User.ID != "bot2" {
+		t.Errorf("got %+v, want bot1 and bot2", q.Bots)
+	}
+	if len(waits) != 1 || waits[0] != 5*time.Second {
+		t.Errorf("got waits %v, want 5s", waits)
+	}
+}
+
+func TestPaginator_BadItem(t *testing.T) {
+	// arrange
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"id":"bot1"}`)
+		fmt.Fprintln(w, `not json`)
+	}))
+	defer server.Close()
+
+	savedURL, savedToken := botsOnlineURL, lichessBotToken
+	botsOnlineURL, lichessBotToken = server.URL, "Bearer test"
+	defer func() { botsOnlineURL, lichessBotToken = savedURL, savedToken }()
+
+	// act
+	_, err := StreamBots(context.Background())
+
+	// assert
+	if err == nil {
+		t.Error("got nil, want an error for the bad line")
+	}
+}
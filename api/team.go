@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// teamURL and tournamentURL are lichess' team and arena endpoints, replaced by tests.
+var (
+	teamURL       = "https://lichess.org/api/team"
+	tournamentURL = "https://lichess.org/api/tournament"
+)
+
+// Team is a lichess team, or club.
+type Team struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Open        bool   `json:"open"` // anyone can join without a request
+	NbMembers   int    `json:"nbMembers"`
+}
+
+// UserTeams returns the teams username is a member of.
+func UserTeams(ctx context.Context, username string) ([]Team, error) {
+	endpoint := fmt.Sprintf("%s/of/%s", teamURL, url.PathEscape(username))
+
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("http.NewRequest: '%s' %v", endpoint, err)
+	}
+
+	req.Header.Add("Authorization", AuthToken())
+	req.Header.Add("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("client.Do: '%s' %v", endpoint, err)
+	}
+
+	defer resp.Body.Close()
+
+	b, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != 200 {
+		return nil, statusError(resp, endpoint, b)
+	}
+
+	var teams []Team
+	if err := json.Unmarshal(b, &teams); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: '%s' %w body: '%s'", endpoint, err, b)
+	}
+
+	return teams, nil
+}
+
+// TeamMembers calls handler for each member of teamID, most recent first, until handler
+// returns false or the members run out.
+func TeamMembers(ctx context.Context, teamID string, handler func(User) bool) error {
+	pages := Paginator[User]{URL: func(string, int) string {
+		return fmt.Sprintf("%s/%s/users", teamURL, url.PathEscape(teamID))
+	}}
+	return pages.Each(ctx, handler)
+}
+
+// JoinTeam joins teamID, sending message to the team's leaders if it needs a request.
+func JoinTeam(teamID, message string) error {
+	fmt.Printf("%s REQ: %s %s\n", ts(), "JoinTeam", teamID)
+
+	data := url.Values{}
+	if message != "" {
+		data.Set("message", message)
+	}
+	return postForm(fmt.Sprintf("%s/%s/join", teamURL, url.PathEscape(teamID)), data)
+}
+
+// LeaveTeam leaves teamID.
+func LeaveTeam(teamID string) error {
+	fmt.Printf("%s REQ: %s %s\n", ts(), "LeaveTeam", teamID)
+
+	return postForm(fmt.Sprintf("%s/%s/quit", teamURL, url.PathEscape(teamID)), url.Values{})
+}
+
+// JoinArena joins the arena tournamentID. Team battles need the teamID we play for, other
+// arenas an empty one.
+func JoinArena(tournamentID, teamID string) error {
+	fmt.Printf("%s REQ: %s %s\n", ts(), "JoinArena", tournamentID)
+
+	data := url.Values{}
+	if teamID != "" {
+		data.Set("team", teamID)
+	}
+	return postForm(fmt.Sprintf("%s/%s/join", tournamentURL, url.PathEscape(tournamentID)), data)
+}
+
+func postForm(endpoint string, data url.Values) error {
+	body := data.Encode()
+	req, err := http.NewRequest("POST", endpoint, strings.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("http.NewRequest: '%s' %v", endpoint, err)
+	}
+
+	req.Header.Add("Authorization", AuthToken())
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Content-Length", fmt.Sprintf("%d", len(body)))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("client.Do: '%s' %v", endpoint, err)
+	}
+
+	defer resp.Body.Close()
+
+	b, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != 200 {
+		return statusError(resp, endpoint, b)
+	}
+
+	return nil
+}
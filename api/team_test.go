@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTeams(t *testing.T) {
+	// arrange
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+r.PostForm.Encode())
+		switch r.URL.Path {
+		case "/team/of/someone":
+			_, _ = w.Write([]byte(`[{"id":"chess-club","name":"Chess Club","nbMembers":12}]`))
+		case "/team/chess-club/users":
+			_, _ = w.Write([]byte("{\"id\":\"someone\"}\n{\"id\":\"other\"}\n"))
+		case "/team/closed/join":
+			http.Error(w, `{"error":"Not found"}`, http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	savedTeam, savedTournament, savedToken := teamURL, tournamentURL, lichessBotToken
+	teamURL, tournamentURL, lichessBotToken = server.URL+"/team", server.URL+"/tournament", "Bearer test"
+	defer func() { teamURL, tournamentURL, lichessBotToken = savedTeam, savedTournament, savedToken }()
+
+	ctx := context.Background()
+
+	// act
+	teams, teamsErr := UserTeams(ctx, "someone")
+	var members []string
+	membersErr := TeamMembers(ctx, "chess-club", func(user User) bool {
+		members = append(members, user.ID)
+		return true
+	})
+	joinErr := JoinTeam("chess-club", "hi")
+	closedErr := JoinTeam("closed", "")
+	arenaErr := JoinArena("abcd1234", "chess-club")
+
+	// assert
+	if teamsErr != nil || membersErr != nil || joinErr != nil || arenaErr != nil {
+		t.Fatalf("got %v %v %v %v", teamsErr, membersErr, joinErr, arenaErr)
+	}
+	if len(teams) != 1 || teams[0].ID != "chess-club" || teams[0].NbMembers != 12 {
+		t.Errorf("teams: got %+v", teams)
+	}
+	if len(members) != 2 || members[0] != "someone" || members[1] != "other" {
+		t.Errorf("members: got %v", members)
+	}
+	if !errors.Is(closedErr, ErrNotFound) {
+		t.Errorf("closed: got %v, want ErrNotFound", closedErr)
+	}
+	want := []string{
+		"GET /team/of/someone ",
+		"GET /team/chess-club/users ",
+		"POST /team/chess-club/join message=hi",
+		"POST /team/closed/join ",
+		"POST /tournament/abcd1234/join team=chess-club",
+	}
+	for i, request := range want {
+		if i >= len(requests) || requests[i] != request {
+			t.Errorf("request %d: got %q, want %q", i+1, requests, request)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"trollfish-lichess/api"
+)
+
+// toggleAPIDebug turns api debug mode on and off on each SIGUSR1.
+func toggleAPIDebug(ctx context.Context) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR1)
+	defer signal.Stop(sig)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sig:
+			api.SetDebug(!api.Debug())
+			fmt.Printf("%s api debug: %v\n", ts(), api.Debug())
+		}
+	}
+}
@@ -0,0 +1,6 @@
+package main
+
+import "context"
+
+// toggleAPIDebug does nothing: Windows has no SIGUSR1, use -api-debug.
+func toggleAPIDebug(ctx context.Context) {}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"time"
+
+	"trollfish-lichess/storage"
+)
+
+const defaultAuditDir = "audit"
+
+// Audit records every move we play in a game to <dir>/<gameID>.jsonl, one MoveAudit per line,
+// so a bad move can be traced back to the engine output or book decision behind it.
+// A nil *Audit records nothing.
+type Audit struct {
+	store    storage.Storage
+	filename string
+}
+
+// MoveAudit is everything that went into one of our moves.
+type MoveAudit struct {
+	GameID    string         `json:"game_id"`
+	Ply       int            `json:"ply"`
+	FEN       string         `json:"fen"`
+	Received  time.Time      `json:"received"`
+	OurTime   int64          `json:"our_time_ms"`
+	OppTime   int64          `json:"opp_time_ms"`
+	PonderHit bool           `json:"ponder_hit,omitempty"`
+	Book      []BookDecision `json:"book,omitempty"`
+	Searches  []SearchAudit  `json:"searches,omitempty"`
+	Notes     []string       `json:"notes,omitempty"`
+	Move      string         `json:"move"`
+	MoveSAN   string         `json:"move_san"`
+	Source    string         `json:"source"` // book or engine
+	Eval      string         `json:"eval,omitempty"`
+	OfferDraw bool           `json:"offer_draw,omitempty"`
+	ThinkTime int64          `json:"think_time_ms"`
+	Error     string         `json:"error,omitempty"`
+}
+
+// BookDecision is a book lookup made for the move.
+type BookDecision struct {
+	Book    string `json:"book"` // player, yamlbook or polyglot:<index>
+	Move    string `json:"move"`
+	CP      int    `json:"cp,omitempty"`
+	Mate    int    `json:"mate,omitempty"`
+	HasEval bool   `json:"has_eval,omitempty"`
+	Text    string `json:"text,omitempty"`
+}
+
+// SearchAudit is an engine search made for the move.
+type SearchAudit struct {
+	ID       int      `json:"id"`
+	Position string   `json:"position"`
+	Go       string   `json:"go"`
+	Info     []string `json:"info,omitempty"`
+	BestMove string   `json:"bestmove"`
+	Ponder   string   `json:"ponder,omitempty"`
+	Eval     string   `json:"eval,omitempty"`
+	Elapsed  int64    `json:"elapsed_ms"`
+}
+
+// NewAudit returns the audit log for a game kept under dir in store, or nil if dir is empty.
+func NewAudit(store storage.Storage, dir, gameID string) *Audit {
+	if dir == "" {
+		return nil
+	}
+
+	return &Audit{store: store, filename: path.Join(dir, gameID+".jsonl")}
+}
+
+// Begin starts the record for a move. It returns nil if a is nil.
+func (a *Audit) Begin(gameID string, ply int, fenPos string, received time.Time, ourTime, oppTime time.Duration) *MoveAudit {
+	if a == nil {
+		return nil
+	}
+
+	return &MoveAudit{
+		GameID:   gameID,
+		Ply:      ply,
+		FEN:      fenPos,
+		Received: received,
+		OurTime:  ourTime.Milliseconds(),
+		OppTime:  oppTime.Milliseconds(),
+	}
+}
+
+// Write appends the move's record to the game's audit file.
+func (a *Audit) Write(m *MoveAudit) error {
+	if a == nil || m == nil {
+		return nil
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	if err := a.store.AppendFile(a.filename, b); err != nil {
+		return fmt.Errorf("write file '%s': %v", a.filename, err)
+	}
+
+	return nil
+}
+
+func (m *MoveAudit) AddBook(decision BookDecision) {
+	if m == nil {
+		return
+	}
+	m.Book = append(m.Book, decision)
+}
+
+func (m *MoveAudit) AddSearch(search *Search, result BestMove) {
+	if m == nil || search == nil {
+		return
+	}
+
+	m.Searches = append(m.Searches, SearchAudit{
+		ID:       search.ID,
+		Position: search.Position,
+		Go:       search.Go,
+		Info:     result.Info,
+		BestMove: result.Move,
+		Ponder:   result.Ponder,
+		Eval:     result.Eval,
+		Elapsed:  result.Elapsed.Milliseconds(),
+	})
+}
+
+func (m *MoveAudit) Note(format string, a ...any) {
+	if m == nil {
+		return
+	}
+	m.Notes = append(m.Notes, fmt.Sprintf(format, a...))
+}
+
+func (m *MoveAudit) Fail(err error) {
+	if m == nil || err == nil {
+		return
+	}
+	m.Error = err.Error()
+}
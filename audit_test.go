@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"trollfish-lichess/storage"
+	"trollfish-lichess/yamlbook"
+)
+
+func TestAudit_RecordsEngineMove(t *testing.T) {
+	// arrange
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	engine := fakeEngine(ctx, map[string]string{
+		"position startpos": "bestmove e2e4 ponder e7e5 eval 0.30",
+	})
+
+	store := storage.NewMemory()
+	audit := NewAudit(store, defaultAuditDir, "test")
+
+	sent := sentMoves{moves: make(chan string, 1)}
+	g := NewGame(ctx, "test", store, engine, &yamlbook.Book{}, nil, GameOptions{}, audit)
+	g.sendMove = sent.send
+
+	// act
+	g.post([]byte(testGameFull))
+	<-sent.moves
+	waitState(t, g, statePondering)
+	g.Finish()
+
+	// assert
+	b, err := store.ReadFile(defaultAuditDir + "/test.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var moves []MoveAudit
+	r := bufio.NewScanner(bytes.NewReader(b))
+	for r.Scan() {
+		var m MoveAudit
+		if err := json.Unmarshal(r.Bytes(), &m); err != nil {
+			t.Fatal(err)
+		}
+		moves = append(moves, m)
+	}
+
+	if len(moves) != 1 {
+		t.Fatalf("records: got %d, want 1", len(moves))
+	}
+
+	m := moves[0]
+	if m.Move != "e2e4" || m.MoveSAN != "e4" || m.Source != "engine" || m.Ply != 0 {
+		t.Errorf("move: got %s %s %s ply %d", m.Move, m.MoveSAN, m.Source, m.Ply)
+	}
+	if len(m.Searches) != 1 {
+		t.Fatalf("searches: got %d, want 1", len(m.Searches))
+	}
+	if s := m.Searches[0]; s.Position != "position startpos" || s.BestMove != "e2e4" || s.Eval != "0.30" {
+		t.Errorf("search: got %+v", s)
+	}
+}
+
+func TestAudit_Nil(t *testing.T) {
+	// arrange
+	audit := NewAudit(storage.NewMemory(), "", "test")
+
+	// act
+	m := audit.Begin("test", 0, startPosFEN, time.Now(), 0, 0)
+	m.Note("ignored %d", 1)
+	m.AddBook(BookDecision{Move: "e4"})
+
+	// assert
+	if audit != nil || m != nil {
+		t.Fatal("want nil audit and record")
+	}
+	if err := audit.Write(m); err != nil {
+		t.Fatal(err)
+	}
+}
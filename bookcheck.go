@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"trollfish-lichess/api"
+	"trollfish-lichess/fen"
+)
+
+const (
+	bookCheckMoveTime = 200 * time.Millisecond // per verification search
+	bookCheckMinClock = 60 * time.Second       // our clock time needed to verify book moves
+)
+
+// checkBookMove asks the engine, briefly, whether the book move bookMoveUCI is much worse
+// than its own best move. If it's worse by g.opts.BookCheckCP or more the position is
+// queued for book review and false is returned, so the engine picks the move instead.
+// It's a no-op returning true when the check is off or our clock is short.
+func (g *Game) checkBookMove(ctx context.Context, board fen.Board, state api.State, fenKey, bookMoveUCI string, ourTime time.Duration) bool {
+	if g.opts.BookCheckCP <= 0 || ourTime < bookCheckMinClock {
+		return true
+	}
+
+	g.stopPondering()
+	g.ponder = ""
+
+	pos := g.positionCommand(state.Moves)
+	goCmd := fmt.Sprintf("go movetime %d", bookCheckMoveTime.Milliseconds())
+
+	best, ok := g.verifySearch(ctx, pos, goCmd)
+	if !ok || best.Move == bookMoveUCI || best.Eval == "" {
+		return true
+	}
+
+	book, ok := g.verifySearch(ctx, pos, goCmd+" searchmoves "+bookMoveUCI)
+	if !ok || book.Eval == "" {
+		return true
+	}
+
+	bookMoveSAN, bestMoveSAN := board.UCItoSAN(bookMoveUCI), board.UCItoSAN(best.Move)
+	loss := evalToScore(best.Eval, g.playerColor) - evalToScore(book.Eval, g.playerColor)
+	if loss < g.opts.BookCheckCP {
+		return true
+	}
+
+	fmt.Printf("%s *** BOOK CHECK: %s (eval %s) is %d cp worse than %s (eval %s), asking the engine\n",
+		ts(), bookMoveSAN, book.Eval, loss, bestMoveSAN, best.Eval)
+	g.moveAudit.Note("book check: %s (eval %s) is %d cp worse than %s (eval %s)", bookMoveSAN, book.Eval, loss, bestMoveSAN, best.Eval)
+
+	if g.book.MarkForReview(fenKey) {
+		g.reviewQueued++
+		fmt.Printf("%s queued '%s' for book review\n", ts(), fenKey)
+	}
+
+	return false
+}
+
+func (g *Game) verifySearch(ctx context.Context, pos, goCmd string) (BestMove, bool) {
+	search, err := g.engine.Go(pos, goCmd)
+	if err != nil {
+		fmt.Printf("%s *** ERR: go: %v\n", ts(), err)
+		return BestMove{}, false
+	}
+
+	result, err := search.Wait(ctx, bookCheckMoveTime+engineMoveGrace)
+	if err != nil {
+		fmt.Printf("%s *** ERR: waiting for bestmove: %v\n", ts(), err)
+		return BestMove{}, false
+	}
+	if ctx.Err() != nil || result.Move == "" {
+		return BestMove{}, false
+	}
+
+	g.moveAudit.AddSearch(search, result)
+	return result, true
+}
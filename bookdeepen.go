@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"trollfish-lichess/api"
+	"trollfish-lichess/fen"
+	"trollfish-lichess/yamlbook"
+)
+
+// BookDeepening extends a book only along the opponent replies that are popular in the
+// rating band the bot plays in, so analysis isn't spent on lines nobody plays against it.
+type BookDeepening struct {
+	Ratings    []int   // explorer rating groups, see api.RatingGroups
+	Popularity float64 // percent of the band's games in a position a reply needs
+	MinGames   int     // positions with fewer games in the band aren't followed
+	MaxPlies   int     // 0 = no limit
+}
+
+// DeepenResult is what DeepenBook did.
+type DeepenResult struct {
+	Lookups   int      // explorer positions looked up
+	Followed  int      // replies popular enough to follow
+	Unpopular int      // replies below the popularity threshold
+	Added     []string // FEN keys of the positions added for analysis
+}
+
+// explorerLookupFunc returns the explorer's results for a position, in the band.
+type explorerLookupFunc func(ctx context.Context, fenPos string) (api.PositionResults, error)
+
+// ParseRatingGroups reads comma separated explorer rating groups, e.g. "2000,2200".
+func ParseRatingGroups(text string) ([]int, error) {
+	var groups []int
+	for _, part := range strings.Split(text, ",") {
+		r, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || !containsInt(api.RatingGroups, r) {
+			return nil, fmt.Errorf("rating groups '%s': want some of %v", text, api.RatingGroups)
+		}
+		groups = append(groups, r)
+	}
+	return groups, nil
+}
+
+func containsInt(values []int, v int) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// playableBookMoves returns the moves the bot may pick from a book position, the
+// same candidates as yamlbook.Book.BestMoveBiased: the weighted moves if there are any,
+// otherwise the moves tied with the best eval.
+func playableBookMoves(moves yamlbook.Moves) yamlbook.Moves {
+	if len(moves) == 0 {
+		return nil
+	}
+	sort.Stable(moves)
+	i := 1
+	if moves[0].Weight > 0 {
+		for i < len(moves) && moves[i].Weight > 0 {
+			i++
+		}
+	} else {
+		for i < len(moves) && moves[i].CP == moves[0].CP && moves[i].Mate == moves[0].Mate {
+			i++
+		}
+	}
+	return moves[:i]
+}
+
+// DeepenBook walks the book from fenPos for either color: our side plays its book moves
+// and the opponent the replies at least Popularity percent of the band's games chose. Our
+// positions the book has no moves for are added to it for -update-book to analyze, unless
+// they're already waiting for it.
+func DeepenBook(ctx context.Context, book *yamlbook.Book, lookup explorerLookupFunc, fenPos string, d BookDeepening) (DeepenResult, error) {
+	var result DeepenResult
+	for _, us := range []fen.Color{fen.WhitePieces, fen.BlackPieces} {
+		seen := make(map[string]bool)
+		if err := d.deepen(ctx, book, lookup, fen.FENtoBoard(fenPos), us, 0, seen, &result); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+func (d BookDeepening) deepen(ctx context.Context, book *yamlbook.Book, lookup explorerLookupFunc, board fen.Board, us fen.Color, plies int, seen map[string]bool, result *DeepenResult) error {
+	fenKey := board.FENKey()
+	if seen[fenKey] || (d.MaxPlies > 0 && plies >= d.MaxPlies) || len(board.AllLegalMoves()) == 0 {
+		return nil
+	}
+	seen[fenKey] = true
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if board.ActiveColor == us {
+		moves, ok := book.Get(fenKey)
+		if !ok {
+			if _, pending := book.GetAll(fenKey); !pending {
+				book.Add(fenKey)
+				result.Added = append(result.Added, fenKey)
+			}
+			return nil
+		}
+		for _, move := range playableBookMoves(moves) {
+			child := board
+			child.Moves(move.UCI())
+			if err := d.deepen(ctx, book, lookup, child, us, plies+1, seen, result); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	res, err := lookup(ctx, board.FEN())
+	if err != nil {
+		return fmt.Errorf("explorer '%s': %v", fenKey, err)
+	}
+	result.Lookups++
+	if res.TotalGames < d.MinGames {
+		return nil
+	}
+
+	for _, reply := range res.Moves {
+		if reply.PopularityPercent < d.Popularity {
+			result.Unpopular++
+			continue
+		}
+		result.Followed++
+		child := board
+		child.Moves(reply.UCI)
+		if err := d.deepen(ctx, book, lookup, child, us, plies+1, seen, result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeepenBookFile deepens a YAML book with the lichess explorer's games in the band and
+// saves it. The added positions are analyzed by -update-book.
+func DeepenBookFile(ctx context.Context, filename, fenPos string, d BookDeepening) error {
+	book, err := yamlbook.Load(filename)
+	if err != nil {
+		return err
+	}
+
+	explorer := api.NewExplorer()
+	explorer.Cache = true
+	lookup := func(ctx context.Context, fenPos string) (api.PositionResults, error) {
+		return explorer.Lookup(ctx, api.Lichess, fenPos, api.LookupOptions{Ratings: d.Ratings})
+	}
+
+	result, err := DeepenBook(ctx, book, lookup, fenPos, d)
+	if len(result.Added) != 0 {
+		if saveErr := book.Save(); saveErr != nil {
+			return saveErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%d explorer lookup(s) in %v: followed %d repl(ies) with >= %.1f%% of the games, skipped %d\n",
+		result.Lookups, d.Ratings, result.Followed, d.Popularity, result.Unpopular)
+	fmt.Printf("%d position(s) added to '%s' for analysis (see -update-book)\n", len(result.Added), filename)
+	return nil
+}
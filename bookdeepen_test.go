@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"trollfish-lichess/api"
+	"trollfish-lichess/fen"
+	"trollfish-lichess/yamlbook"
+)
+
+func TestDeepenBook(t *testing.T) {
+	// arrange
+	key := func(moves ...string) string {
+		board := fen.FENtoBoard(startPosFEN)
+		board.Moves(moves...)
+		return board.FENKey()
+	}
+
+	filename := filepath.Join(t.TempDir(), "book.yamlbook")
+	data := `- fen: ` + key() + `
+  moves:
+    - move: e4
+      cp: 30
+    - move: d4
+      cp: 20
+`
+	if err := os.WriteFile(filename, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	book, err := yamlbook.Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	explorer := map[string]api.PositionResults{
+		key(): {TotalGames: 1000, Moves: []api.Move{
+			{UCI: "e2e4", PopularityPercent: 60},
+			{UCI: "d2d4", PopularityPercent: 30},
+			{UCI: "c2c4", PopularityPercent: 5},
+		}},
+		key("e2e4"): {TotalGames: 600, Moves: []api.Move{
+			{UCI: "c7c5", PopularityPercent: 50},
+			{UCI: "e7e5", PopularityPercent: 40},
+			{UCI: "a7a6", PopularityPercent: 1},
+		}},
+	}
+	var lookups []string
+	lookup := func(ctx context.Context, fenPos string) (api.PositionResults, error) {
+		fenKey := fen.FENtoBoard(fenPos).FENKey()
+		lookups = append(lookups, fenKey)
+		return explorer[fenKey], nil
+	}
+
+	d := BookDeepening{Popularity: 10, MinGames: 50, MaxPlies: 16}
+
+	// act
+	result, err := DeepenBook(context.Background(), book, lookup, startPosFEN, d)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantAdded := []string{key("e2e4", "c7c5"), key("e2e4", "e7e5"), key("e2e4"), key("d2d4")}
+	if !reflect.DeepEqual(result.Added, wantAdded) {
+		t.Errorf("added\ngot  %v\nwant %v", result.Added, wantAdded)
+	}
+	if !reflect.DeepEqual(lookups, []string{key("e2e4"), key()}) {
+		t.Errorf("looked up %v, want 1.e4 (d4 isn't in the book) and the start position", lookups)
+	}
+	if result.Followed != 4 || result.Unpopular != 2 {
+		t.Errorf("followed %d, unpopular %d, want 4 and 2", result.Followed, result.Unpopular)
+	}
+	if got := book.NeedMoves(); len(got) != len(wantAdded) {
+		t.Errorf("%d position(s) need moves, want %d", len(got), len(wantAdded))
+	}
+
+	// the added positions are waiting for analysis, not added again
+	result, err = DeepenBook(context.Background(), book, lookup, startPosFEN, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Added) != 0 {
+		t.Errorf("second run added %v, want none", result.Added)
+	}
+}
+
+func TestParseRatingGroups(t *testing.T) {
+	cases := []struct {
+		text    string
+		want    []int
+		wantErr bool
+	}{
+		{text: "2200,2500", want: []int{2200, 2500}},
+		{text: "1600, 1800", want: []int{1600, 1800}},
+		{text: "2300", wantErr: true},
+		{text: "", wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.text, func(t *testing.T) {
+			// act
+			got, err := ParseRatingGroups(c.text)
+
+			// assert
+			if (err != nil) != c.wantErr {
+				t.Fatalf("err = %v, want error %v", err, c.wantErr)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
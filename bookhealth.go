@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"trollfish-lichess/fen"
+	"trollfish-lichess/uciproc"
+	"trollfish-lichess/wdl"
+	"trollfish-lichess/yamlbook"
+)
+
+// BookHealth is how BookHealthFile plays out the book's leaves that have no explorer stats.
+type BookHealth struct {
+	Playouts int // games from each leaf, 0 = explorer stats only
+	Nodes    int // the engine searches a move, varied up to half again so the games differ
+	MaxPlies int // a game still going after this many plies is scored by the engine's eval
+}
+
+// BookHealthFile scores the health of every book move reachable from startFEN, the practical
+// score of the line it starts (see yamlbook.Book.ScoreHealth), and saves it. Leaves without
+// enough explorer games (see book-stats) are played out by trollfish against itself.
+func BookHealthFile(ctx context.Context, filename, startFEN, enginePath string, resources uciproc.Resources, opts BookHealth) error {
+	book, err := yamlbook.Load(filename)
+	if err != nil {
+		return err
+	}
+
+	var playout yamlbook.Playout
+	var games int
+	if opts.Playouts > 0 {
+		engine, err := startMatchEngine(ctx, resources, func(input <-chan string, output chan<- string) error {
+			_, err := startTrollFish(ctx, enginePath, input, output)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("trollfish: %v", err)
+		}
+
+		rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+		playout = func(fenKey string) (float64, int, error) {
+			var points float64
+			for i := 0; i < opts.Playouts; i++ {
+				score, err := opts.playout(ctx, engine, fenKey, rng)
+				if err != nil {
+					return 0, i, err
+				}
+				points += score
+			}
+			games += opts.Playouts
+			fmt.Printf("%s %s: %.1f/%d\n", ts(), fenKey, points, opts.Playouts)
+			return points / float64(opts.Playouts), opts.Playouts, nil
+		}
+	}
+
+	fmt.Printf("%s book health: %d playout(s) a leaf, %d nodes a move\n", ts(), opts.Playouts, opts.Nodes)
+	scored, err := book.ScoreHealth(startFEN, playout, time.Now().Unix())
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	if scored != 0 {
+		if err := book.Save(); err != nil {
+			return err
+		}
+	}
+
+	if moves, ok := book.Get(startFEN); ok {
+		fmt.Println(fen.Key(startFEN))
+		for _, move := range moves {
+			if move.Health != nil {
+				fmt.Printf("  %-7s %s\n", move.Move, move.Health)
+			}
+		}
+	}
+	fmt.Printf("\nhealth scored for %d move(s), %d playout(s)\n", scored, games)
+	return nil
+}
+
+// playout plays a game from fenKey and returns the score, 0-1, of its side to move.
+func (o BookHealth) playout(ctx context.Context, engine *Engine, fenKey string, rng *rand.Rand) (float64, error) {
+	_ = engine.Send("ucinewgame")
+	if err := engine.WaitReady(ctx, engineReadyTimeout); err != nil {
+		return 0, err
+	}
+
+	start := fen.FENtoBoard(fenKey)
+	board := start
+	seen := map[string]int{board.FENKey(): 1}
+	var (
+		moves []string
+		eval  string // white's pov
+	)
+	for {
+		if result := matchOutcome(board, seen, len(moves)); result != "" {
+			return resultPoints(result, start.ActiveColor == fen.WhitePieces), nil
+		}
+		if o.MaxPlies > 0 && len(moves) >= o.MaxPlies && eval != "" {
+			return evalWDL(wdl.Default, eval, start.ActiveColor, board).Score(), nil
+		}
+
+		pos := "position fen " + start.FEN()
+		if len(moves) != 0 {
+			pos += " moves " + strings.Join(moves, " ")
+		}
+		search, err := engine.Go(pos, fmt.Sprintf("go nodes %d", o.Nodes+rng.Intn(o.Nodes/2+1)))
+		if err != nil {
+			return 0, err
+		}
+		result, err := search.Wait(ctx, eloMoveTimeout)
+		if err != nil {
+			return 0, err
+		}
+		if result.Move == "" || result.Move == "(none)" {
+			return 0, fmt.Errorf("no move in '%s'", board.FEN())
+		}
+		eval = result.Eval
+		moves = append(moves, result.Move)
+		board.Moves(result.Move)
+		seen[board.FENKey()]++
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"trollfish-lichess/analyze"
+	"trollfish-lichess/api"
+	"trollfish-lichess/epd"
+	"trollfish-lichess/fen"
+	"trollfish-lichess/yamlbook"
+)
+
+// maintainMaxFullMove is the last move of our games whose positions are queued, as for
+// recent.epd (see Game.saveToRecent).
+const maintainMaxFullMove = 25
+
+// MaintainOptions are the steps of -maintain.
+type MaintainOptions struct {
+	Book       string
+	Player     string    // whose games are downloaded
+	Since      time.Time // the games downloaded, created from Since until Until
+	Until      time.Time
+	RecentFile string // out-of-book positions collected by the bot, deduped; empty to skip
+	PruneCP    int    // see yamlbook.Book.PruneDominated, 0 = don't prune
+	Analyze    bool   // analyze the queued positions afterwards, see UpdateFile
+}
+
+// MaintainReport is what maintainBook changed.
+type MaintainReport struct {
+	Games        int
+	NewPositions int // queued for analysis, without moves
+	Repairs      int // by Fsck, which merges duplicates
+	Stale        int // queued for review, see yamlbook.Moves.TooOld
+	Pruned       int
+}
+
+func (r MaintainReport) String() string {
+	return fmt.Sprintf("%d game(s), %d new position(s) queued, %d repair(s), %d stale position(s) queued for review, %d dominated move(s) pruned",
+		r.Games, r.NewPositions, r.Repairs, r.Stale, r.Pruned)
+}
+
+// MaintainBook runs the book maintenance steps in order: download player's games, queue
+// their new positions, dedupe the recent positions and the book, queue stale evals for
+// review and prune dominated moves, then optionally analyze what's queued.
+func MaintainBook(ctx context.Context, opts MaintainOptions) error {
+	start := time.Now()
+
+	filename, count, err := api.GetGames(opts.Player, api.GamesOptions{Since: opts.Since, Until: opts.Until})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s downloaded %d game(s) of %s to %s\n", ts(), count, opts.Player, filename)
+
+	var games []*fen.PGNGame
+	if count > 0 {
+		db, err := fen.LoadPGNDatabase(filename)
+		if err != nil {
+			return err
+		}
+		games = db.Games
+	}
+
+	if opts.RecentFile != "" {
+		if _, err := os.Stat(opts.RecentFile); err == nil {
+			if err := epd.Dedupe(opts.RecentFile); err != nil {
+				return err
+			}
+		}
+	}
+
+	book, err := yamlbook.Load(opts.Book)
+	if err != nil {
+		return err
+	}
+
+	report := maintainBook(book, games, opts.Player, opts.PruneCP)
+	if err := book.Save(); err != nil {
+		return err
+	}
+	fmt.Printf("%s maintained '%s' in %v: %s\n", ts(), opts.Book, time.Since(start).Round(time.Second), report)
+
+	if !opts.Analyze || report.NewPositions+report.Stale == 0 {
+		return nil
+	}
+	return UpdateFile(ctx, analyze.New(), opts.Book, defaultAnalysisOptions, nil, "")
+}
+
+// maintainBook runs the steps of MaintainBook that change the book, printing each change.
+func maintainBook(book *yamlbook.Book, games []*fen.PGNGame, player string, pruneCP int) MaintainReport {
+	report := MaintainReport{Games: len(games)}
+
+	for _, game := range games {
+		var us fen.Color
+		switch {
+		case strings.EqualFold(game.White, player):
+			us = fen.WhitePieces
+		case strings.EqualFold(game.Black, player):
+			us = fen.BlackPieces
+		default:
+			continue
+		}
+
+		board := fen.FENtoBoard(game.SetupFEN)
+		for _, move := range game.Moves {
+			if board.FullMove > maintainMaxFullMove {
+				break
+			}
+			if _, ok := book.GetAll(move.FENKey); !ok && board.ActiveColor == us {
+				book.Add(move.FENKey)
+				report.NewPositions++
+			}
+			board.Moves(move.UCI)
+		}
+	}
+
+	repairs := book.Fsck()
+	for _, msg := range repairs {
+		fmt.Println(msg)
+	}
+	report.Repairs = len(repairs)
+
+	for _, pos := range book.Positions {
+		if len(pos.Moves) != 0 && pos.Moves.TooOld() && book.MarkForReview(pos.FEN) {
+			report.Stale++
+		}
+	}
+
+	if pruneCP > 0 {
+		pruned := book.PruneDominated(pruneCP)
+		for _, msg := range pruned {
+			fmt.Println(msg)
+		}
+		report.Pruned = len(pruned)
+	}
+
+	return report
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"trollfish-lichess/fen"
+	"trollfish-lichess/yamlbook"
+)
+
+func TestMaintainBook(t *testing.T) {
+	// arrange
+	const pgn = `[Event "Rated blitz game"]
+[White "someone"]
+[Black "trollololfish"]
+[Result "1-0"]
+
+1. e4 e5 2. Nf3 Nc6 1-0
+`
+	game, err := fen.ParsePGN(pgn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	afterE4 := fen.FENtoBoard(startPosFEN)
+	afterE4.Moves("e2e4")
+	afterNf3 := fen.FENtoBoard(startPosFEN)
+	afterNf3.Moves("e2e4", "e7e5", "g1f3")
+	start := fen.Key(startPosFEN)
+
+	filename := filepath.Join(t.TempDir(), "book.yamlbook")
+	data := `- fen: ` + start + `
+  moves:
+    - move: e4
+      cp: 30
+      ts: 1700000000
+    - move: d4
+      cp: 25
+      ts: 1700000000
+    - move: c4
+      cp: 20
+      ts: 1700000000
+    - move: f3
+      cp: -300
+      ts: 1700000000
+- fen: ` + afterE4.FENKey() + `
+  moves:
+    - move: e5
+      cp: -30
+      ts: 1600000000
+`
+	if err := os.WriteFile(filename, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	book, err := yamlbook.Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	report := maintainBook(book, []*fen.PGNGame{game}, botID, 200)
+
+	// assert
+	want := MaintainReport{Games: 1, NewPositions: 1, Stale: 1, Pruned: 1}
+	if report != want {
+		t.Errorf("got %+v, want %+v", report, want)
+	}
+	if moves, ok := book.GetAll(afterNf3.FENKey()); !ok || len(moves) != 0 {
+		t.Errorf("after Nf3: got %v %v, want queued without moves", moves, ok)
+	}
+	if review := book.NeedReview(); len(review) != 1 || review[0] != afterE4.FENKey() {
+		t.Errorf("review: got %v, want the position after e4", review)
+	}
+	if moves, _ := book.Get(start); len(moves) != 3 || moves.GetSAN("f3") != nil {
+		t.Errorf("start: got %v, want f3 pruned", moves)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"trollfish-lichess/fen"
+	"trollfish-lichess/yamlbook"
+)
+
+// bookPonderStopWait is how long the book ponder search has to answer 'stop' with its
+// bestmove before its result is given up on.
+const bookPonderStopWait = 500 * time.Millisecond
+
+// bookPonder is the search analyzing the book's most probable line ahead while both sides
+// are in book, see GameOptions.BookPonderPlies.
+type bookPonder struct {
+	fenKey string // position analyzed, at the end of the line
+	search *Search
+}
+
+// bookContinuation returns the book's most probable line from board, at most plies long:
+// the opponent's most popular reply in the explorer's games, or the best by eval without
+// stats, and our best book move. It stops where the book does.
+func bookContinuation(book *yamlbook.Book, board fen.Board, us fen.Color, plies int) []string {
+	var line []string
+	for len(line) < plies {
+		moves, ok := book.Get(board.FENKey())
+		if !ok {
+			break
+		}
+
+		move := moves.GetBestMoveByEval("")
+		if board.ActiveColor != us {
+			var popularity float64
+			for _, m := range moves {
+				if m.Explorer != nil && m.Explorer.Popularity > popularity {
+					move, popularity = m, m.Explorer.Popularity
+				}
+			}
+		}
+
+		line = append(line, move.UCI())
+		board.Moves(move.UCI())
+	}
+	return line
+}
+
+// startBookPonder analyzes the end of the book's most probable line after our book move on
+// the opponent's time, so the search out of book finds the engine's hash warm and the
+// line's eval cached. It returns false when the book has no reply to follow.
+func (g *Game) startBookPonder(movesText, bookMoveUCI string) bool {
+	board := fen.FENtoBoard(g.initialFEN)
+	board.Moves(append(strings.Fields(movesText), bookMoveUCI)...)
+
+	line := bookContinuation(g.book, board, g.playerColor, g.opts.BookPonderPlies)
+	if len(line) == 0 {
+		return false
+	}
+	start := board
+	board.Moves(line...)
+
+	search, err := g.engine.Go(g.positionCommand(append([]string{movesText, bookMoveUCI}, line...)...), "go infinite")
+	if err != nil {
+		fmt.Printf("%s *** ERR: go book ponder: %v\n", ts(), err)
+		return false
+	}
+	fmt.Printf("%s book ponder: %s\n", ts(), strings.Join(start.UCItoSANs(line...), " "))
+
+	// stopPondering stops it like any ponder search, collectBookPonder keeps its result
+	g.ponderSearch = search
+	g.bookPonder = &bookPonder{fenKey: board.FENKey(), search: search}
+	return true
+}
+
+// collectBookPonder stops the book ponder search, if any, and caches its bestmove and eval
+// for the position it analyzed.
+func (g *Game) collectBookPonder() {
+	bp := g.bookPonder
+	if bp == nil {
+		return
+	}
+	g.bookPonder = nil
+	if g.ponderSearch == bp.search {
+		g.stopPondering()
+	}
+
+	result, err := bp.search.Wait(g.ctx, bookPonderStopWait)
+	if err != nil || result.Move == "" || result.Eval == "" {
+		return
+	}
+	if g.bookPonderEvals == nil {
+		g.bookPonderEvals = make(map[string]BestMove)
+	}
+	g.bookPonderEvals[bp.fenKey] = result
+}
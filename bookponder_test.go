@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"trollfish-lichess/fen"
+	"trollfish-lichess/yamlbook"
+)
+
+func TestBookContinuation(t *testing.T) {
+	// arrange
+	afterE4 := fen.FENtoBoard(startPosFEN)
+	afterE4.Moves("e2e4")
+	afterC5 := afterE4
+	afterC5.Moves("c7c5")
+
+	filename := filepath.Join(t.TempDir(), "book.yamlbook")
+	book := `- fen: ` + afterE4.FENKey() + `
+  moves:
+    - move: e5
+      cp: -20
+      explorer: {games: 100, white: 40, draws: 20, black: 40, popularity: 30, ts: 1}
+    - move: c5
+      cp: -35
+      explorer: {games: 200, white: 45, draws: 10, black: 45, popularity: 60, ts: 1}
+- fen: ` + afterC5.FENKey() + `
+  moves:
+    - move: c3
+      cp: 10
+    - move: Nf3
+      cp: 30
+`
+	if err := os.WriteFile(filename, []byte(book), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b, err := yamlbook.Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name  string
+		plies int
+		want  []string
+	}{
+		{name: "most popular reply, our best move", plies: 4, want: []string{"c7c5", "g1f3"}},
+		{name: "plies", plies: 1, want: []string{"c7c5"}},
+		{name: "off", plies: 0, want: nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// act
+			got := bookContinuation(b, afterE4, fen.WhitePieces, c.plies)
+
+			// assert
+			if len(got) != len(c.want) {
+				t.Fatalf("got %v, want %v", got, c.want)
+			}
+			for i := range c.want {
+				if got[i] != c.want[i] {
+					t.Errorf("got %v, want %v", got, c.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+
+	"trollfish-lichess/polyglot"
+	"trollfish-lichess/yamlbook"
+)
+
+// BookPosition is a position to look up in a book.
+type BookPosition struct {
+	FEN    string
+	FENKey string
+	SANs   []string // the game's moves to the position, for the variety bias
+}
+
+// WeightedMove is a move a book suggests.
+type WeightedMove struct {
+	UCI       string
+	SAN       string // empty if the book only knows the UCI move
+	PonderUCI string
+	Weight    int
+	CP        int
+	Mate      int
+	HasEval   bool
+	Text      string
+}
+
+// BookSource is a book consulted for our moves during a game.
+type BookSource interface {
+	// Name identifies the source in the move audit, see BookDecision.
+	Name() string
+	// Lookup returns the source's moves for the position, the one it wants played first.
+	Lookup(pos BookPosition) []WeightedMove
+}
+
+// BookChain asks each source in turn, the first with a move wins.
+type BookChain []BookSource
+
+// Lookup returns the first source's move and the source's name.
+func (c BookChain) Lookup(pos BookPosition) (WeightedMove, string, bool) {
+	for _, source := range c {
+		if moves := source.Lookup(pos); len(moves) != 0 {
+			return moves[0], source.Name(), true
+		}
+	}
+	return WeightedMove{}, "", false
+}
+
+// playerBookSource plays the moves that beat the opponent before, see Busted.
+type playerBookSource struct {
+	moves      map[string]MoveChances
+	repertoire *yamlBookSource // for the eval and ponder move, if it has the same move
+}
+
+func (s *playerBookSource) Name() string { return "player" }
+
+func (s *playerBookSource) Lookup(pos BookPosition) []WeightedMove {
+	best := s.moves[pos.FENKey].BestMove()
+	if best == nil {
+		return nil
+	}
+
+	move := WeightedMove{
+		UCI:       best.MoveUCI,
+		SAN:       best.MoveSAN,
+		PonderUCI: best.PonderUCI,
+		Weight:    best.Win,
+		CP:        55555,
+		Text:      best.GameText,
+	}
+
+	if bookMove, ponder := s.repertoire.bestMove(pos); bookMove != nil && bookMove.Move == best.MoveSAN {
+		move.CP, move.Mate = bookMove.CP, bookMove.Mate
+		move.HasEval = true
+		move.PonderUCI = ponder
+	}
+
+	fmt.Printf("%s %s '%s' %s\n", ts(), best.MoveSAN, pos.FENKey, best.GameText)
+	return []WeightedMove{move}
+}
+
+// yamlBookSource is our repertoire, picked with the game's variety bias and tag filter.
+// In limited strength games it may pick a slightly worse move.
+type yamlBookSource struct {
+	g *Game
+}
+
+func (s *yamlBookSource) Name() string { return "yamlbook" }
+
+func (s *yamlBookSource) bestMove(pos BookPosition) (*yamlbook.Move, string) {
+	g := s.g
+	return g.book.BestMoveBiased(pos.FENKey, g.bookBias(pos.SANs), g.bookFilter())
+}
+
+func (s *yamlBookSource) Lookup(pos BookPosition) []WeightedMove {
+	if pos.FEN == startPosFEN {
+		return nil
+	}
+
+	g := s.g
+	bookMove, ponder := s.bestMove(pos)
+	if bookMove == nil {
+		return nil
+	}
+
+	if g.limited {
+		bookMoves, _ := g.book.Get(pos.FENKey)
+		if weaker := g.opts.Strength.WeakerBookMove(bookMoves, bookMove, g.bookFilter(), g.rand); weaker != nil {
+			fmt.Printf("%s casual game, book move %s instead of %s\n", ts(), weaker.Move, bookMove.Move)
+			bookMove, ponder = weaker, ""
+		}
+	}
+
+	return []WeightedMove{{
+		UCI:       bookMove.UCI(),
+		SAN:       bookMove.Move,
+		PonderUCI: ponder,
+		Weight:    bookMove.Weight,
+		CP:        bookMove.CP,
+		Mate:      bookMove.Mate,
+		HasEval:   true,
+		Text:      strings.Join(bookMove.Tags, ","),
+	}}
+}
+
+// polyglotSource plays a random move of a polyglot book, without a ponder move.
+type polyglotSource struct {
+	book  *polyglot.Book
+	index int
+}
+
+func (s *polyglotSource) Name() string { return fmt.Sprintf("polyglot:%d", s.index) }
+
+func (s *polyglotSource) Lookup(pos BookPosition) []WeightedMove {
+	if pos.FEN == startPosFEN {
+		return nil
+	}
+
+	entries, ok := s.book.Get(pos.FENKey)
+	if !ok || len(entries) == 0 {
+		return nil
+	}
+
+	moves := make([]WeightedMove, 0, len(entries))
+	for _, entry := range entries {
+		moves = append(moves, WeightedMove{UCI: entry.UCIMove, Weight: int(entry.Weight)})
+	}
+	n := rand.Intn(len(moves))
+	moves[0], moves[n] = moves[n], moves[0]
+
+	fmt.Printf("%s !-!-!-!-!-! Polyglot book index %d: move %s\n", ts(), s.index, moves[0].UCI)
+	return moves
+}
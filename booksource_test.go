@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"trollfish-lichess/fen"
+	"trollfish-lichess/polyglot"
+)
+
+type fakeBookSource struct {
+	name  string
+	moves map[string][]WeightedMove
+}
+
+func (s fakeBookSource) Name() string { return s.name }
+
+func (s fakeBookSource) Lookup(pos BookPosition) []WeightedMove { return s.moves[pos.FENKey] }
+
+func TestBookChain_Lookup(t *testing.T) {
+	// arrange
+	board := fen.FENtoBoard(startPosFEN)
+	board.Moves("e2e4")
+	pos := BookPosition{FEN: board.FEN(), FENKey: board.FENKey()}
+
+	pg := polyglot.NewBook()
+	if err := pg.Add(pos.FENKey, "c5", 0, 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	empty := fakeBookSource{name: "empty"}
+	first := fakeBookSource{name: "first", moves: map[string][]WeightedMove{pos.FENKey: {{UCI: "e7e5", SAN: "e5"}}}}
+
+	// act
+	move, source, ok := BookChain{empty, first, &polyglotSource{book: pg}}.Lookup(pos)
+	pgMove, pgSource, pgOK := BookChain{empty, &polyglotSource{book: pg, index: 2}}.Lookup(pos)
+	startMove := (&polyglotSource{book: pg}).Lookup(BookPosition{FEN: startPosFEN, FENKey: fen.Key(startPosFEN)})
+	_, _, noneOK := BookChain{empty}.Lookup(pos)
+
+	// assert
+	if !ok || source != "first" || move.UCI != "e7e5" {
+		t.Errorf("got %s %+v %v, want first's e7e5", source, move, ok)
+	}
+	if !pgOK || pgSource != "polyglot:2" || pgMove.UCI != "c7c5" || pgMove.SAN != "" {
+		t.Errorf("polyglot: got %s %+v %v", pgSource, pgMove, pgOK)
+	}
+	if startMove != nil {
+		t.Errorf("start position: got %+v, want the engine to play", startMove)
+	}
+	if noneOK {
+		t.Error("no moves: want !ok")
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"trollfish-lichess/api"
+	"trollfish-lichess/fen"
+	"trollfish-lichess/yamlbook"
+)
+
+// BookStatsFile fetches the lichess explorer's games in the rating band for the book's
+// positions whose explorer stats are missing or older than maxAge, saves them on the moves
+// and prints every position's moves with their eval, stats and health (see book-health).
+// Among eval-equal moves the bot prefers the one that scores best in these games. It also
+// shows how much smaller the engine output retention makes the file (see book-retain-lines).
+func BookStatsFile(ctx context.Context, filename string, ratings []int, maxAge time.Duration) error {
+	all, kept, err := yamlbook.RetentionSizes(filename, yamlbook.Retain)
+	if err != nil {
+		return err
+	}
+
+	book, err := yamlbook.Load(filename)
+	if err != nil {
+		return err
+	}
+
+	explorer := api.NewExplorer()
+	now := time.Now()
+
+	var fetched, found int
+	var fetchErr error
+	for _, pos := range book.Positions {
+		if _, ok := book.Get(pos.FEN); !ok {
+			continue
+		}
+		if ts := book.ExplorerStatsAge(pos.FEN); ts != 0 && now.Sub(time.Unix(ts, 0)) < maxAge {
+			continue
+		}
+
+		results, err := explorer.Lookup(ctx, api.Lichess, fen.FENtoBoard(pos.FEN).FEN(), api.LookupOptions{Ratings: ratings})
+		if err != nil {
+			fetchErr = fmt.Errorf("explorer '%s': %v", pos.FEN, err)
+			break
+		}
+		found += book.SetExplorerStats(pos.FEN, results, ratings, now.Unix())
+		fetched++
+	}
+
+	if fetched != 0 {
+		if err := book.Save(); err != nil {
+			return err
+		}
+	}
+	if fetchErr != nil {
+		return fetchErr
+	}
+
+	for _, pos := range book.Positions {
+		moves, ok := book.Get(pos.FEN)
+		if !ok {
+			continue
+		}
+		fmt.Println(pos.FEN)
+		for _, move := range moves {
+			stats := "no explorer stats"
+			if move.Explorer != nil {
+				stats = move.Explorer.String()
+			}
+			if move.Health != nil {
+				stats += " " + move.Health.String()
+			}
+			if move.Band != nil {
+				stats += " band: " + move.Band.String()
+			}
+			fmt.Printf("  %-7s cp: %5d mate: %2d weight: %3d %s\n", move.Move, move.CP, move.Mate, move.Weight, stats)
+		}
+	}
+	fmt.Printf("\nexplorer stats in %v fetched for %d position(s), %d move(s) with games\n", ratings, fetched, found)
+
+	fmt.Printf("engine output retention %s: %d -> %d bytes encoded, %.1f%% saved\n", yamlbook.Retain, all, kept, 100*float64(all-kept)/float64(max(all, 1)))
+	return nil
+}
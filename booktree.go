@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"trollfish-lichess/fen"
+	"trollfish-lichess/yamlbook"
+)
+
+// BookTreeNode is a position in the exported opening tree. Moves are the edges to child positions.
+type BookTreeNode struct {
+	FEN   string          `json:"fen"`
+	Moves []*BookTreeEdge `json:"moves,omitempty"`
+}
+
+type BookTreeEdge struct {
+	Move   string `json:"move"`
+	CP     int    `json:"cp"`
+	Mate   int    `json:"mate,omitempty"`
+	Weight int    `json:"weight,omitempty"`
+
+	// Transposition is set when the resulting position was already expanded elsewhere in the tree.
+	Transposition bool          `json:"transposition,omitempty"`
+	Node          *BookTreeNode `json:"node,omitempty"`
+}
+
+// BookTree walks the book from fenPos, following book moves up to maxDepth plies (0 = no limit).
+// Each position is expanded once; later visits are marked as transpositions.
+func BookTree(book *yamlbook.Book, fenPos string, maxDepth int) *BookTreeNode {
+	board := fen.FENtoBoard(fenPos)
+	seen := make(map[string]struct{})
+	return bookTreeNode(book, board, maxDepth, 0, seen)
+}
+
+func bookTreeNode(book *yamlbook.Book, board fen.Board, maxDepth, depth int, seen map[string]struct{}) *BookTreeNode {
+	fenKey := board.FENKey()
+	seen[fenKey] = struct{}{}
+
+	node := BookTreeNode{FEN: fenKey}
+	if maxDepth > 0 && depth >= maxDepth {
+		return &node
+	}
+
+	moves, ok := book.Get(fenKey)
+	if !ok {
+		return &node
+	}
+
+	for _, move := range moves {
+		edge := BookTreeEdge{
+			Move:   move.Move,
+			CP:     move.CP,
+			Mate:   move.Mate,
+			Weight: move.Weight,
+		}
+
+		child := board
+		child.Moves(move.UCI())
+		if _, ok := seen[child.FENKey()]; ok {
+			edge.Transposition = true
+		} else {
+			edge.Node = bookTreeNode(book, child, maxDepth, depth+1, seen)
+		}
+
+		node.Moves = append(node.Moves, &edge)
+	}
+
+	return &node
+}
+
+func (n *BookTreeNode) JSON() (string, error) {
+	b, err := json.MarshalIndent(n, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// DOT returns the tree in graphviz format. Positions are nodes, so transpositions share a node.
+func (n *BookTreeNode) DOT() string {
+	var sb strings.Builder
+
+	sb.WriteString("digraph book {\n")
+	sb.WriteString("  node [shape=box, fontname=\"monospace\"];\n")
+
+	ids := make(map[string]int)
+	id := func(fenKey string) int {
+		if v, ok := ids[fenKey]; ok {
+			return v
+		}
+		ids[fenKey] = len(ids)
+		sb.WriteString(fmt.Sprintf("  n%d [label=%q];\n", ids[fenKey], fenKey))
+		return ids[fenKey]
+	}
+
+	var walk func(node *BookTreeNode)
+	walk = func(node *BookTreeNode) {
+		from := id(node.FEN)
+		for _, edge := range node.Moves {
+			if edge.Node == nil {
+				continue
+			}
+
+			to := id(edge.Node.FEN)
+			sb.WriteString(fmt.Sprintf("  n%d -> n%d [label=%q];\n", from, to, edge.label()))
+			walk(edge.Node)
+		}
+	}
+	walk(n)
+
+	// transpositions point at nodes that are all known by now
+	var transpositions func(node *BookTreeNode)
+	transpositions = func(node *BookTreeNode) {
+		for _, edge := range node.Moves {
+			if edge.Node != nil {
+				transpositions(edge.Node)
+				continue
+			}
+
+			board := fen.FENtoBoard(node.FEN)
+			uci, err := board.SANtoUCI(edge.Move)
+			if err != nil {
+				continue
+			}
+			board.Moves(uci)
+			if to, ok := ids[board.FENKey()]; ok {
+				sb.WriteString(fmt.Sprintf("  n%d -> n%d [label=%q, style=dashed];\n", ids[node.FEN], to, edge.label()))
+			}
+		}
+	}
+	transpositions(n)
+
+	sb.WriteString("}\n")
+
+	return sb.String()
+}
+
+func (e *BookTreeEdge) label() string {
+	var eval string
+	if e.Mate != 0 {
+		eval = fmt.Sprintf("M%d", e.Mate)
+	} else {
+		eval = fmt.Sprintf("%+d", e.CP)
+	}
+
+	if e.Weight != 0 {
+		return fmt.Sprintf("%s %s w=%d", e.Move, eval, e.Weight)
+	}
+	return fmt.Sprintf("%s %s", e.Move, eval)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"trollfish-lichess/fen"
+	"trollfish-lichess/yamlbook"
+)
+
+// treeBook returns a book with 1.e4 e5 2.Nf3 and 1.Nf3 e5 2.e4, which transposes.
+func treeBook(t *testing.T) *yamlbook.Book {
+	t.Helper()
+
+	book := yamlbook.New(filepath.Join(t.TempDir(), "book.yamlbook"))
+	add := func(sans []string, move *yamlbook.Move) {
+		board := fen.FENtoBoard(startPosFEN)
+		ucis, err := board.SANtoUCIs(sans...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		board.Moves(ucis...)
+		book.Add(board.FENKey(), move)
+	}
+	add(nil, &yamlbook.Move{Move: "e4", CP: 30, Weight: 2})
+	add(nil, &yamlbook.Move{Move: "Nf3", CP: 25})
+	add([]string{"e4"}, &yamlbook.Move{Move: "e5", CP: -30})
+	add([]string{"e4", "e5"}, &yamlbook.Move{Move: "Nf3", CP: 35})
+	add([]string{"Nf3"}, &yamlbook.Move{Move: "e5", CP: -60})
+	add([]string{"Nf3", "e5"}, &yamlbook.Move{Move: "e4", CP: 30})
+	return book
+}
+
+func TestBookTree(t *testing.T) {
+	cases := []struct {
+		name           string
+		maxDepth       int
+		wantPositions  int
+		wantTransposed int
+	}{
+		{name: "all", wantPositions: 6, wantTransposed: 1},
+		{name: "depth 1", maxDepth: 1, wantPositions: 3},
+		{name: "depth 2", maxDepth: 2, wantPositions: 5},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			book := treeBook(t)
+
+			// act
+			tree := BookTree(book, startPosFEN, c.maxDepth)
+
+			// assert
+			var positions, transposed int
+			var walk func(node *BookTreeNode)
+			walk = func(node *BookTreeNode) {
+				positions++
+				for _, edge := range node.Moves {
+					if edge.Transposition {
+						transposed++
+					}
+					if edge.Node != nil {
+						walk(edge.Node)
+					}
+				}
+			}
+			walk(tree)
+			if positions != c.wantPositions || transposed != c.wantTransposed {
+				t.Errorf("got %d position(s), %d transposition(s), want %d, %d", positions, transposed, c.wantPositions, c.wantTransposed)
+			}
+		})
+	}
+}
+
+func TestBookTreeNode_DOT(t *testing.T) {
+	// arrange
+	tree := BookTree(treeBook(t), startPosFEN, 0)
+
+	// act
+	got := tree.DOT()
+
+	// assert
+	for _, want := range []string{
+		"digraph book {\n",
+		`n0 -> n1 [label="e4 +30 w=2"];`,
+		`n0 -> n4 [label="Nf3 +25"];`,
+		`[label="e4 +30", style=dashed];`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %s in:\n%s", want, got)
+		}
+	}
+	if n := strings.Count(got, "[label=\"r"); n != 6 {
+		t.Errorf("got %d position nodes, want 6, transpositions share one:\n%s", n, got)
+	}
+}
+
+func TestBookTreeEdge_Label(t *testing.T) {
+	cases := []struct {
+		edge BookTreeEdge
+		want string
+	}{
+		{edge: BookTreeEdge{Move: "e4", CP: 30}, want: "e4 +30"},
+		{edge: BookTreeEdge{Move: "c5", CP: -15, Weight: 3}, want: "c5 -15 w=3"},
+		{edge: BookTreeEdge{Move: "Qxf7#", Mate: 1}, want: "Qxf7# M1"},
+		{edge: BookTreeEdge{Move: "Kh1", Mate: -2, CP: 100}, want: "Kh1 M-2"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.want, func(t *testing.T) {
+			// act
+			got := c.edge.label()
+
+			// assert
+			if got != c.want {
+				t.Errorf("got '%s', want '%s'", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"trollfish-lichess/api"
+)
+
+const (
+	broadcastPushInterval = 3 * time.Second // between pushes, updates in between are combined
+	broadcastMaxGames     = 64              // games lichess keeps in a round
+)
+
+// Broadcaster streams the session's games to a lichess broadcast round. Each push sends
+// every game of the session, so finished games keep their result in the round.
+type Broadcaster struct {
+	roundID string
+	push    func(roundID, pgn string) (api.BroadcastPushResult, error)
+
+	mtx   sync.Mutex
+	games []broadcastGame // in the order they started
+
+	pending chan struct{}
+}
+
+type broadcastGame struct {
+	gameID string
+	pgn    string
+}
+
+// NewBroadcaster starts pushing to the round until ctx is done.
+func NewBroadcaster(ctx context.Context, roundID string) *Broadcaster {
+	b := newBroadcaster(roundID, api.BroadcastPush)
+	go b.run(ctx)
+	return b
+}
+
+func newBroadcaster(roundID string, push func(roundID, pgn string) (api.BroadcastPushResult, error)) *Broadcaster {
+	return &Broadcaster{
+		roundID: roundID,
+		push:    push,
+		pending: make(chan struct{}, 1),
+	}
+}
+
+// Update sets the game's PGN and queues a push. It doesn't block and is a no-op on a nil
+// Broadcaster.
+func (b *Broadcaster) Update(gameID, pgn string) {
+	if b == nil {
+		return
+	}
+
+	b.mtx.Lock()
+	found := false
+	for i := range b.games {
+		if b.games[i].gameID == gameID {
+			b.games[i].pgn = pgn
+			found = true
+			break
+		}
+	}
+	if !found {
+		b.games = append(b.games, broadcastGame{gameID: gameID, pgn: pgn})
+		if len(b.games) > broadcastMaxGames {
+			b.games = b.games[len(b.games)-broadcastMaxGames:]
+		}
+	}
+	b.mtx.Unlock()
+
+	select {
+	case b.pending <- struct{}{}:
+	default:
+	}
+}
+
+// PGN returns the games to push.
+func (b *Broadcaster) PGN() string {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	var sb strings.Builder
+	for _, game := range b.games {
+		sb.WriteString(game.pgn)
+	}
+	return sb.String()
+}
+
+func (b *Broadcaster) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-b.pending:
+		}
+
+		b.pushNow()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(broadcastPushInterval):
+		}
+	}
+}
+
+func (b *Broadcaster) pushNow() {
+	result, err := b.push(b.roundID, b.PGN())
+	if err != nil {
+		fmt.Printf("%s ERR: api.BroadcastPush: %v\n", ts(), err)
+		return
+	}
+	for _, game := range result.Games {
+		if game.Error != "" {
+			fmt.Printf("%s ERR: broadcast %s vs %s: %s\n", ts(), game.Tags["White"], game.Tags["Black"], game.Error)
+		}
+	}
+}
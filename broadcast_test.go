@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	"trollfish-lichess/api"
+)
+
+func TestBroadcaster_Update(t *testing.T) {
+	// arrange
+	var pushed []string
+	b := newBroadcaster("round1", func(roundID, pgn string) (api.BroadcastPushResult, error) {
+		pushed = append(pushed, roundID+":"+pgn)
+		return api.BroadcastPushResult{}, nil
+	})
+
+	// act
+	b.Update("game1", "1. e4 *\n\n")
+	b.Update("game2", "1. d4 *\n\n")
+	b.Update("game1", "1. e4 e5 *\n\n")
+	b.pushNow()
+
+	// assert
+	if want := "round1:1. e4 e5 *\n\n1. d4 *\n\n"; len(pushed) != 1 || pushed[0] != want {
+		t.Errorf("got %q, want %q", pushed, want)
+	}
+	if len(b.pending) != 1 {
+		t.Errorf("pending: got %d, want updates combined into 1", len(b.pending))
+	}
+
+	var nilBroadcaster *Broadcaster
+	nilBroadcaster.Update("game1", "*") // must not panic
+}
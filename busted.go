@@ -3,10 +3,10 @@ package main
 import (
 	"fmt"
 	"os"
-	"path/filepath"
 	"sort"
 
 	"trollfish-lichess/fen"
+	"trollfish-lichess/wdl"
 )
 
 func Busted(filename string, color fen.Color) (map[string]MoveChances, error) {
@@ -14,6 +14,9 @@ func Busted(filename string, color fen.Color) (map[string]MoveChances, error) {
 	if err != nil {
 		return nil, err
 	}
+	if n := db.Dedupe(); n != 0 {
+		fmt.Printf("skipped %d duplicate game(s)\n", n)
+	}
 
 	m1, err := busted(db, color)
 	if err != nil {
@@ -93,6 +96,11 @@ func busted(db fen.Database, color fen.Color) (map[string]MoveChances, error) {
 				moveChance.GameText = fmt.Sprintf("%s vs %s: %s", game.White, game.Black, game.Tags["Result"])
 			}
 
+			if move.HasEval {
+				elo := iif(color == fen.WhitePieces, game.WhiteElo, game.BlackElo)
+				moveChance.addEval(move, color, wdl.ForRating(elo))
+			}
+
 			if game.Result == winResult {
 				moveChance.Win++
 			} else if game.Result == loseResult {
@@ -111,6 +119,9 @@ func busted(db fen.Database, color fen.Color) (map[string]MoveChances, error) {
 			if v[i].Win != v[j].Win {
 				return v[i].Win > v[j].Win
 			}
+			if v[i].Evals != 0 && v[j].Evals != 0 && v[i].Chances != v[j].Chances {
+				return v[i].Chances > v[j].Chances
+			}
 
 			return v[i].WinPercent > v[j].WinPercent
 		})
@@ -142,6 +153,27 @@ type MoveChance struct {
 	LosePercent int
 	DrawPercent int
 	GameText    string
+
+	// Chances are the mover's average winning chances after the move by the [%eval]s of
+	// the games that have one, Evals, with the model of the mover's rating band.
+	Chances float64
+	Evals   int
+}
+
+func (mc *MoveChance) addEval(move fen.PGNMove, color fen.Color, model wdl.Model) {
+	board := fen.FENtoBoard(move.FENKey)
+	board.Moves(move.UCI)
+
+	var chances float64
+	score := fen.Global(move.CP, move.Mate).For(color)
+	if score.Mate != 0 {
+		chances = model.FromMate(score.Mate).Chances()
+	} else {
+		chances = model.FromCP(score.CP, wdl.Material(board)).Chances()
+	}
+
+	mc.Chances = (mc.Chances*float64(mc.Evals) + chances) / float64(mc.Evals+1)
+	mc.Evals++
 }
 
 func (mc *MoveChance) Update() {
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"trollfish-lichess/api"
+	"trollfish-lichess/fen"
+)
+
+// increment-only (0+X) budgeting
+const (
+	incrementOnlyReserve  = 300 * time.Millisecond // kept on the clock for lag
+	incrementOnlyMinThink = 50 * time.Millisecond
+)
+
+// ClockType is how a clock gives time back for each move.
+type ClockType int
+
+const (
+	// ClockFischer adds the increment after each move.
+	ClockFischer ClockType = iota
+	// ClockIncrementOnly is Fischer without base time, lichess' 0+X. The next move's
+	// time is mostly the increment.
+	ClockIncrementOnly
+	// ClockNone is an unlimited or correspondence game, searched as ClocklessSearch says.
+	ClockNone
+)
+
+// games without a clock
+const (
+	clocklessMoveTime   = 10 * time.Second // when ClocklessSearch has neither a movetime nor a depth
+	clocklessDepthClock = 10 * time.Minute // assumed on the clock for depth searches, see ClocklessSearch.clock
+)
+
+func (t ClockType) String() string {
+	switch t {
+	case ClockIncrementOnly:
+		return "increment only"
+	case ClockNone:
+		return "no clock"
+	default:
+		return "Fischer"
+	}
+}
+
+// Clock is a game's time control.
+type Clock struct {
+	Type      ClockType
+	Initial   time.Duration
+	Increment time.Duration
+
+	Clockless ClocklessSearch // for ClockNone
+}
+
+// ClocklessSearch is how unlimited and correspondence games are searched: a fixed movetime,
+// or to Depth when MoveTime is 0.
+type ClocklessSearch struct {
+	Accept   bool // casual challenges without a clock
+	MoveTime time.Duration
+	Depth    int
+}
+
+// goArgs returns the search arguments of a UCI 'go' command.
+func (s ClocklessSearch) goArgs() string {
+	if s.MoveTime <= 0 && s.Depth > 0 {
+		return fmt.Sprintf("depth %d", s.Depth)
+	}
+	return fmt.Sprintf("movetime %d", s.MoveTime.Milliseconds())
+}
+
+// clock returns the time playMove assumes is on both clocks, the most a search waits and
+// enough for the side searches that take a small part of our time (see Game.avoidRepetition).
+func (s ClocklessSearch) clock() time.Duration {
+	if s.MoveTime <= 0 && s.Depth > 0 {
+		return clocklessDepthClock
+	}
+	return 50 * s.MoveTime
+}
+
+// detectClock returns the clock of a game with lichess' initial and increment in ms.
+// Lichess clocks are always Fischer, 0+X has no base time.
+func detectClock(clock api.Clock) Clock {
+	c := Clock{
+		Type:      ClockFischer,
+		Initial:   time.Duration(clock.Initial) * time.Millisecond,
+		Increment: time.Duration(clock.Increment) * time.Millisecond,
+	}
+	if c.Initial == 0 && c.Increment > 0 {
+		c.Type = ClockIncrementOnly
+	}
+	return c
+}
+
+// detectGameClock returns a game's clock, ClockNone for unlimited and correspondence games
+// or when the game has no clock data at all.
+func detectGameClock(game api.GameFull, clockless ClocklessSearch) Clock {
+	noClock := game.Clock == (api.Clock{}) && game.State.WhiteTime == 0 && game.State.BlackTime == 0
+	if game.Speed != "correspondence" && game.Speed != "unlimited" && !noClock {
+		return detectClock(game.Clock)
+	}
+
+	if clockless.MoveTime <= 0 && clockless.Depth <= 0 {
+		clockless.MoveTime = clocklessMoveTime
+	}
+	return Clock{Type: ClockNone, Clockless: clockless}
+}
+
+func (c Clock) String() string {
+	switch c.Type {
+	case ClockNone:
+		return "-"
+	default:
+		return fmt.Sprintf("%v+%v", c.Initial, c.Increment)
+	}
+}
+
+// HasIncrement reports whether every move gets time, so a long game can't be lost by
+// flagging alone.
+func (c Clock) HasIncrement() bool {
+	return c.Increment > 0
+}
+
+// GoTimes returns the time arguments of a UCI 'go' command for the remaining times in ms.
+// 0+X gets a fixed movetime for our side, engines treat the near empty clock as a time
+// scramble and move instantly. Without a clock the times are meaningless and the search is
+// ClocklessSearch's.
+func (c Clock) GoTimes(whiteTime, blackTime int, us fen.Color) string {
+	if c.Type == ClockNone {
+		return c.Clockless.goArgs()
+	}
+	if c.Type == ClockIncrementOnly {
+		ourTime := iif(us == fen.WhitePieces, whiteTime, blackTime)
+		return fmt.Sprintf("movetime %d", c.incrementOnlyBudget(time.Duration(ourTime)*time.Millisecond).Milliseconds())
+	}
+
+	inc := c.Increment.Milliseconds()
+	return fmt.Sprintf("wtime %d winc %d btime %d binc %d", whiteTime, inc, blackTime, inc)
+}
+
+// incrementOnlyBudget spends the increment each move plus a quarter of what's been banked,
+// leaving incrementOnlyReserve on the clock.
+func (c Clock) incrementOnlyBudget(ourTime time.Duration) time.Duration {
+	budget := c.Increment
+	if ourTime > c.Increment {
+		budget += (ourTime - c.Increment) / 4
+	}
+	budget -= incrementOnlyReserve
+	if limit := ourTime - incrementOnlyReserve; budget > limit {
+		budget = limit
+	}
+	if budget < incrementOnlyMinThink {
+		budget = incrementOnlyMinThink
+	}
+	return budget
+}
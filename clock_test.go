@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"trollfish-lichess/api"
+	"trollfish-lichess/fen"
+)
+
+func TestClock_GoTimes(t *testing.T) {
+	cases := []struct {
+		name  string
+		clock Clock
+		us    fen.Color
+		wtime int
+		btime int
+		want  string
+	}{
+		{"fischer", detectClock(api.Clock{Initial: 180000, Increment: 2000}), fen.WhitePieces, 150000, 160000, "wtime 150000 winc 2000 btime 160000 binc 2000"},
+		{"no increment", detectClock(api.Clock{Initial: 60000}), fen.BlackPieces, 30000, 40000, "wtime 30000 winc 0 btime 40000 binc 0"},
+		{"increment only", detectClock(api.Clock{Increment: 2000}), fen.WhitePieces, 2500, 900, "movetime 1825"},
+		{"increment only, low", detectClock(api.Clock{Increment: 1000}), fen.BlackPieces, 5000, 200, "movetime 50"},
+		{"no clock, movetime", detectGameClock(api.GameFull{Speed: "correspondence"}, ClocklessSearch{MoveTime: 5 * time.Second}), fen.WhitePieces, 0, 0, "movetime 5000"},
+		{"no clock, depth", detectGameClock(api.GameFull{Speed: "unlimited"}, ClocklessSearch{Depth: 22}), fen.BlackPieces, 0, 0, "depth 22"},
+		{"no clock, unset", detectGameClock(api.GameFull{}, ClocklessSearch{}), fen.WhitePieces, 0, 0, "movetime 10000"},
+		{"clock data", detectGameClock(api.GameFull{Speed: "blitz", State: api.State{WhiteTime: 60000, BlackTime: 60000}}, ClocklessSearch{}), fen.WhitePieces, 60000, 60000, "wtime 60000 winc 0 btime 60000 binc 0"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// act
+			got := c.clock.GoTimes(c.wtime, c.btime, c.us)
+
+			// assert
+			if got != c.want {
+				t.Errorf("got '%s', want '%s'", got, c.want)
+			}
+		})
+	}
+}
+
+func TestTimeControl_Parse(t *testing.T) {
+	cases := []struct {
+		text    string
+		want    TimeControl
+		wantErr bool
+	}{
+		{text: "3+2", want: TimeControl{Limit: 180, Increment: 2, Type: ClockFischer}},
+		{text: "30+0", want: TimeControl{Limit: 30, Type: ClockFischer}},
+		{text: "0+1", want: TimeControl{Increment: 1, Type: ClockIncrementOnly}},
+		{text: "5d3", wantErr: true},
+		{text: "0+0", wantErr: true},
+		{text: "5x3", wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.text, func(t *testing.T) {
+			// act
+			var got TimeControl
+			err := got.Parse(c.text)
+
+			// assert
+			if (err != nil) != c.wantErr {
+				t.Fatalf("err: %v, want error: %v", err, c.wantErr)
+			}
+			if !c.wantErr && got != c.want {
+				t.Errorf("got %+v, want %+v", got, c.want)
+			}
+		})
+	}
+}
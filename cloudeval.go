@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"trollfish-lichess/api"
+	"trollfish-lichess/fen"
+	"trollfish-lichess/yamlbook"
+)
+
+// ExportCloudEvals writes the analyzed positions in a YAML book to filename in the lichess
+// cloud-eval JSON shape, one position per line. Lichess doesn't accept cloud eval uploads
+// through its API, so this is how analysis gets shared between users of this tool.
+func ExportCloudEvals(bookFilename, filename string) (int, error) {
+	book, err := yamlbook.Load(bookFilename)
+	if err != nil {
+		return 0, err
+	}
+
+	fp, err := os.Create(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer fp.Close()
+
+	w := bufio.NewWriter(fp)
+
+	var count int
+	for _, pos := range book.Positions {
+		result, ok := bookToCloudEval(book, pos.FEN)
+		if !ok {
+			continue
+		}
+
+		b, err := json.Marshal(result)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := fmt.Fprintf(w, "%s\n", b); err != nil {
+			return 0, fmt.Errorf("write file '%s': %v", filename, err)
+		}
+		count++
+	}
+
+	if err := w.Flush(); err != nil {
+		return 0, fmt.Errorf("write file '%s': %v", filename, err)
+	}
+
+	return count, nil
+}
+
+// bookToCloudEval converts the engine evals of a book position. Book evals are from the side to
+// move's pov; cloud evals are from white's. Depth is the shallowest PV's depth, as lichess reports
+// a single depth for all PVs. KNodes is the best PV's: a multi-PV search reports its nodes on
+// every line, so summing them would count the search once per PV.
+func bookToCloudEval(book *yamlbook.Book, fenKey string) (api.CloudEvalResults, bool) {
+	moves, ok := book.Get(fenKey)
+	if !ok {
+		return api.CloudEvalResults{}, false
+	}
+
+	board := fen.FENtoBoard(fenKey)
+
+	type pv struct {
+		api.PV
+		line  yamlbook.LogLine
+		score fen.Score
+	}
+
+	var pvs []pv
+	for _, move := range moves {
+		line := move.GetLastLogLineFor(move.Move)
+		if line.Depth == 0 || line.PV == "" {
+			continue
+		}
+
+		ucis, err := board.SANtoUCIs(strings.Split(line.PV, " ")...)
+		if err != nil {
+			continue
+		}
+
+		score := fen.POV(line.CP, line.Mate, board.ActiveColor)
+		global := score.Global()
+		pvs = append(pvs, pv{
+			PV:    api.PV{Moves: strings.Join(ucis, " "), CP: global.CP, Mate: global.Mate},
+			line:  line,
+			score: score,
+		})
+	}
+
+	if len(pvs) == 0 {
+		return api.CloudEvalResults{}, false
+	}
+
+	sort.SliceStable(pvs, func(i, j int) bool {
+		return pvs[i].score.Order() > pvs[j].score.Order()
+	})
+
+	result := api.CloudEvalResults{FEN: board.FEN(), KNodes: pvs[0].line.Nodes / 1000}
+	for i, pv := range pvs {
+		if i == 0 || pv.line.Depth < result.Depth {
+			result.Depth = pv.line.Depth
+		}
+		result.PVs = append(result.PVs, pv.PV)
+	}
+
+	return result, true
+}
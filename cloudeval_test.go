@@ -0,0 +1,142 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"trollfish-lichess/api"
+	"trollfish-lichess/yamlbook"
+)
+
+// engineMove returns a book move with one engine line.
+func engineMove(san string, depth, cp, mate, nodes int, pv string) *yamlbook.Move {
+	line := yamlbook.LogLine{Depth: depth, CP: cp, Mate: mate, Nodes: nodes, PV: pv}
+	return &yamlbook.Move{Move: san, CP: cp, Mate: mate, Engine: &yamlbook.Engine{Output: []*yamlbook.EngineOutput{{Line: line}}}}
+}
+
+func TestBookToCloudEval(t *testing.T) {
+	const (
+		afterE4 = "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq -"
+		scholar = "r1bqkbnr/pppp1ppp/2n5/4p2Q/2B1P3/8/PPPP1PPP/RNB1K1NR w KQkq -"
+	)
+
+	cases := []struct {
+		name   string
+		fen    string
+		moves  []*yamlbook.Move
+		want   api.CloudEvalResults
+		wantOK bool
+	}{
+		{
+			name: "best first, shallowest depth",
+			fen:  startPosFEN,
+			moves: []*yamlbook.Move{
+				engineMove("e4", 20, 30, 0, 2_000_000, "e4 e5"),
+				engineMove("d4", 18, 40, 0, 1_000_000, "d4 d5"),
+			},
+			want: api.CloudEvalResults{
+				FEN: startPosFEN, KNodes: 1000, Depth: 18,
+				PVs: []api.PV{{Moves: "d2d4 d7d5", CP: 40}, {Moves: "e2e4 e7e5", CP: 30}},
+			},
+			wantOK: true,
+		},
+		{
+			name: "multi-pv nodes counted once",
+			fen:  startPosFEN,
+			moves: []*yamlbook.Move{
+				engineMove("e4", 24, 30, 0, 5_000_000, "e4 e5"),
+				engineMove("d4", 24, 25, 0, 5_000_000, "d4 d5"),
+				engineMove("Nf3", 24, 20, 0, 5_000_000, "Nf3 d5"),
+			},
+			want: api.CloudEvalResults{
+				FEN: startPosFEN, KNodes: 5000, Depth: 24,
+				PVs: []api.PV{{Moves: "e2e4 e7e5", CP: 30}, {Moves: "d2d4 d7d5", CP: 25}, {Moves: "g1f3 d7d5", CP: 20}},
+			},
+			wantOK: true,
+		},
+		{
+			name:   "black to move, white's pov",
+			fen:    afterE4,
+			moves:  []*yamlbook.Move{engineMove("c5", 20, 20, 0, 0, "c5 Nf3")},
+			want:   api.CloudEvalResults{FEN: afterE4 + " 0 1", Depth: 20, PVs: []api.PV{{Moves: "c7c5 g1f3", CP: -20}}},
+			wantOK: true,
+		},
+		{
+			name:   "mate",
+			fen:    scholar,
+			moves:  []*yamlbook.Move{engineMove("Qxf7#", 1, 0, 1, 0, "Qxf7#")},
+			want:   api.CloudEvalResults{FEN: scholar + " 0 1", Depth: 1, PVs: []api.PV{{Moves: "h5f7", Mate: 1}}},
+			wantOK: true,
+		},
+		{
+			name:  "no engine lines",
+			fen:   startPosFEN,
+			moves: []*yamlbook.Move{{Move: "e4", CP: 30}},
+		},
+		{
+			name:  "illegal pv",
+			fen:   startPosFEN,
+			moves: []*yamlbook.Move{engineMove("e4", 20, 30, 0, 0, "e4 e4")},
+		},
+		{
+			name: "not in the book",
+			fen:  afterE4,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			book := yamlbook.New(filepath.Join(t.TempDir(), "book.yamlbook"))
+			if len(c.moves) != 0 {
+				book.Add(c.fen, c.moves...)
+			}
+
+			// act
+			got, ok := bookToCloudEval(book, c.fen)
+
+			// assert
+			if ok != c.wantOK {
+				t.Fatalf("ok: got %v, want %v", ok, c.wantOK)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %+v, want %+v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestExportCloudEvals(t *testing.T) {
+	// arrange
+	dir := t.TempDir()
+	bookFile := filepath.Join(dir, "book.yamlbook")
+	book := yamlbook.New(bookFile)
+	book.Add(startPosFEN, engineMove("e4", 20, 30, 0, 0, "e4 e5"))
+	book.Add("rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq -", &yamlbook.Move{Move: "c5"})
+	if err := book.Save(); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, "evals.ndjson")
+
+	// act
+	count, err := ExportCloudEvals(bookFile, filename)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if count != 1 || len(lines) != 1 {
+		t.Fatalf("got %d position(s), lines %q, want 1", count, lines)
+	}
+	if want := `"pvs":[{"moves":"e2e4 e7e5","cp":30}]`; !strings.Contains(lines[0], want) {
+		t.Errorf("got %s, want it to contain %s", lines[0], want)
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"trollfish-lichess/storage"
+)
+
+// declinesFilename is the log of bots declining our challenges, see Declines.
+const declinesFilename = "declines.json"
+
+const (
+	declineCooldown    = time.Hour          // a bot that declined isn't challenged again for this long
+	declineMaxCooldown = 7 * 24 * time.Hour // doubling with each decline in declineWindow, up to this
+	declineWindow      = 30 * 24 * time.Hour
+)
+
+// lichess' declineReasonKey values we can do something about
+const (
+	declineRated       = "rated"
+	declineCasual      = "casual"
+	declineTooFast     = "toofast"
+	declineTooSlow     = "tooslow"
+	declineTimeControl = "timecontrol"
+)
+
+// challengeLadder is the time controls we fall back to when a bot wants slower, faster or a
+// different time control, fastest first by estimated game duration.
+var challengeLadder = []ChallengeParams{
+	{Rated: true, Limit: 60},
+	{Rated: true, Limit: 60, Increment: 1},
+	{Rated: true, Limit: 120, Increment: 1},
+	{Rated: true, Limit: 180},
+	{Rated: true, Limit: 180, Increment: 2},
+	{Rated: true, Limit: 300},
+	{Rated: true, Limit: 300, Increment: 3},
+	{Rated: true, Limit: 600},
+}
+
+// ChallengeParams are the terms we challenge a bot with.
+type ChallengeParams struct {
+	Rated     bool `json:"rated"`
+	Limit     int  `json:"limit"` // seconds
+	Increment int  `json:"increment"`
+}
+
+// estimate is the expected game duration in seconds, lichess' 40 moves.
+func (p ChallengeParams) estimate() int {
+	return p.Limit + 40*p.Increment
+}
+
+func (p ChallengeParams) String() string {
+	return fmt.Sprintf("%s %d+%d", iif(p.Rated, "rated", "casual"), p.Limit/60, p.Increment)
+}
+
+// Decline is a bot declining one of our challenges.
+type Decline struct {
+	Bot    string          `json:"bot"` // lowercase id
+	TS     int64           `json:"ts"`
+	Key    string          `json:"key"` // lichess' declineReasonKey, lowercase
+	Reason string          `json:"reason"`
+	Params ChallengeParams `json:"params"` // the challenge declined
+}
+
+// Declines tracks why bots decline our challenges. Instead of banning them, the next
+// challenge's terms are adjusted when the reason says what they'd accept, otherwise the bot
+// cools down for a while before it's challenged again.
+type Declines struct {
+	mtx   sync.Mutex
+	store storage.Storage
+
+	Declines []Decline                  `json:"declines"`
+	Adjust   map[string]ChallengeParams `json:"adjust"` // by bot, terms to try next
+}
+
+// LoadDeclines reads the decline log kept in store.
+func LoadDeclines(store storage.Storage) (*Declines, error) {
+	d := Declines{store: store, Adjust: make(map[string]ChallengeParams)}
+	b, err := store.ReadFile(declinesFilename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &d, nil
+		}
+		return nil, fmt.Errorf("'%s': %v", declinesFilename, err)
+	}
+	if err := json.Unmarshal(b, &d); err != nil {
+		return nil, fmt.Errorf("'%s': %v", declinesFilename, err)
+	}
+	if d.Adjust == nil {
+		d.Adjust = make(map[string]ChallengeParams)
+	}
+	return &d, nil
+}
+
+// Params returns the terms to challenge bot with, def unless it declined def before.
+func (d *Declines) Params(bot string, def ChallengeParams) ChallengeParams {
+	if d == nil {
+		return def
+	}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if p, ok := d.Adjust[strings.ToLower(bot)]; ok {
+		return p
+	}
+	return def
+}
+
+// Record logs a decline, works out the terms to try next and saves the log.
+func (d *Declines) Record(bot, key, reason string, params ChallengeParams, now time.Time) error {
+	if d == nil {
+		return nil
+	}
+
+	bot, key = strings.ToLower(bot), strings.ToLower(key)
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.Declines = append(d.Declines, Decline{Bot: bot, TS: now.Unix(), Key: key, Reason: reason, Params: params})
+	if next, ok := adjustChallenge(key, params); ok {
+		d.Adjust[bot] = next
+		fmt.Printf("%s %s declined %s (%s), trying %s next\n", ts(), bot, params, key, next)
+	} else {
+		fmt.Printf("%s %s declined %s (%s), cooling down for %v\n", ts(), bot, params, key, d.cooldown(bot, now))
+	}
+
+	b, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := d.store.WriteFile(declinesFilename, b); err != nil {
+		return fmt.Errorf("write file '%s': %v", declinesFilename, err)
+	}
+	return nil
+}
+
+// CoolingDown reports whether bot declined recently for a reason we couldn't adjust to.
+func (d *Declines) CoolingDown(bot string, now time.Time) bool {
+	if d == nil {
+		return false
+	}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	bot = strings.ToLower(bot)
+	for i := len(d.Declines) - 1; i >= 0; i-- {
+		decline := d.Declines[i]
+		if decline.Bot != bot {
+			continue
+		}
+		if _, ok := adjustChallenge(decline.Key, decline.Params); ok {
+			return false // the next challenge is on different terms
+		}
+		return now.Before(time.Unix(decline.TS, 0).Add(d.cooldown(bot, now)))
+	}
+	return false
+}
+
+// cooldown doubles declineCooldown for each decline by bot within declineWindow.
+func (d *Declines) cooldown(bot string, now time.Time) time.Duration {
+	cooldown := declineCooldown
+	var n int
+	for _, decline := range d.Declines {
+		if decline.Bot == bot && now.Sub(time.Unix(decline.TS, 0)) <= declineWindow {
+			n++
+		}
+	}
+	for i := 1; i < n && cooldown < declineMaxCooldown; i++ {
+		cooldown *= 2
+	}
+	if cooldown > declineMaxCooldown {
+		cooldown = declineMaxCooldown
+	}
+	return cooldown
+}
+
+// adjustChallenge returns the terms that answer a decline for key, false if there aren't any.
+func adjustChallenge(key string, p ChallengeParams) (ChallengeParams, bool) {
+	switch key {
+	case declineRated:
+		if p.Rated {
+			p.Rated = false
+			return p, true
+		}
+	case declineCasual:
+		if !p.Rated {
+			p.Rated = true
+			return p, true
+		}
+	case declineTooFast:
+		for _, rung := range challengeLadder {
+			if rung.estimate() > p.estimate() {
+				rung.Rated = p.Rated
+				return rung, true
+			}
+		}
+	case declineTooSlow:
+		for i := len(challengeLadder) - 1; i >= 0; i-- {
+			if rung := challengeLadder[i]; rung.estimate() < p.estimate() {
+				rung.Rated = p.Rated
+				return rung, true
+			}
+		}
+	case declineTimeControl:
+		// the nearest rung with or without increment, whichever p isn't
+		best, bestDiff := ChallengeParams{}, -1
+		for _, rung := range challengeLadder {
+			if (rung.Increment == 0) == (p.Increment == 0) {
+				continue
+			}
+			diff := rung.estimate() - p.estimate()
+			if diff < 0 {
+				diff = -diff
+			}
+			if bestDiff == -1 || diff < bestDiff {
+				best, bestDiff = rung, diff
+			}
+		}
+		if bestDiff != -1 {
+			best.Rated = p.Rated
+			return best, true
+		}
+	}
+	return p, false
+}
+
+// DeclineStat is one bot's declines.
+type DeclineStat struct {
+	Bot    string
+	Total  int
+	ByKey  map[string]int
+	Last   time.Time
+	Adjust *ChallengeParams // the terms we'll try next, nil for the default
+}
+
+// Stats returns each bot's declines, most declines first.
+func (d *Declines) Stats() []DeclineStat {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	index := make(map[string]int)
+	var stats []DeclineStat
+	for _, decline := range d.Declines {
+		i, ok := index[decline.Bot]
+		if !ok {
+			i = len(stats)
+			index[decline.Bot] = i
+			stats = append(stats, DeclineStat{Bot: decline.Bot, ByKey: make(map[string]int)})
+		}
+		s := &stats[i]
+		s.Total++
+		s.ByKey[iif(decline.Key != "", decline.Key, "unknown")]++
+		s.Last = time.Unix(decline.TS, 0)
+	}
+	for i := range stats {
+		if p, ok := d.Adjust[stats[i].Bot]; ok {
+			stats[i].Adjust = &p
+		}
+	}
+
+	sort.SliceStable(stats, func(i, j int) bool {
+		if stats[i].Total != stats[j].Total {
+			return stats[i].Total > stats[j].Total
+		}
+		return stats[i].Bot < stats[j].Bot
+	})
+	return stats
+}
+
+// PrintDeclineReport prints the bots that declined our challenges, why, and what we'll try next.
+func PrintDeclineReport(store storage.Storage) error {
+	d, err := LoadDeclines(store)
+	if err != nil {
+		return err
+	}
+
+	stats := d.Stats()
+	if len(stats) == 0 {
+		fmt.Println("no declines")
+		return nil
+	}
+
+	totals := make(map[string]int)
+	now := time.Now()
+	for _, s := range stats {
+		keys := make([]string, 0, len(s.ByKey))
+		for key, n := range s.ByKey {
+			keys = append(keys, fmt.Sprintf("%s %d", key, n))
+			totals[key] += n
+		}
+		sort.Strings(keys)
+
+		next := "default terms"
+		if s.Adjust != nil {
+			next = s.Adjust.String()
+		}
+		if d.CoolingDown(s.Bot, now) {
+			next += ", cooling down"
+		}
+		fmt.Printf("%-24s %3d declines (%s), last %s, next: %s\n", s.Bot, s.Total, strings.Join(keys, ", "), s.Last.Format("2006-01-02 15:04"), next)
+	}
+
+	keys := make([]string, 0, len(totals))
+	for key := range totals {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return totals[keys[i]] > totals[keys[j]] })
+	fmt.Println()
+	for _, key := range keys {
+		fmt.Printf("%-12s %d\n", key, totals[key])
+	}
+	return nil
+}
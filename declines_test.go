@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"trollfish-lichess/storage"
+)
+
+func TestAdjustChallenge(t *testing.T) {
+	// arrange
+	cases := []struct {
+		key  string
+		p    ChallengeParams
+		want ChallengeParams
+		ok   bool
+	}{
+		{declineRated, ChallengeParams{Rated: true, Limit: 60}, ChallengeParams{Limit: 60}, true},
+		{declineRated, ChallengeParams{Limit: 60}, ChallengeParams{Limit: 60}, false},
+		{declineCasual, ChallengeParams{Limit: 60}, ChallengeParams{Rated: true, Limit: 60}, true},
+		{declineTooFast, ChallengeParams{Rated: true, Limit: 60}, ChallengeParams{Rated: true, Limit: 60, Increment: 1}, true},
+		{declineTooFast, ChallengeParams{Limit: 600}, ChallengeParams{Limit: 600}, false},
+		{declineTooSlow, ChallengeParams{Rated: true, Limit: 180}, ChallengeParams{Rated: true, Limit: 120, Increment: 1}, true},
+		{declineTimeControl, ChallengeParams{Rated: true, Limit: 180}, ChallengeParams{Rated: true, Limit: 120, Increment: 1}, true},
+		{"generic", ChallengeParams{Rated: true, Limit: 60}, ChallengeParams{Rated: true, Limit: 60}, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.key, func(t *testing.T) {
+			// act
+			got, ok := adjustChallenge(c.key, c.p)
+
+			// assert
+			if got != c.want || ok != c.ok {
+				t.Errorf("%s %s: got %s %v, want %s %v", c.key, c.p, got, ok, c.want, c.ok)
+			}
+		})
+	}
+}
+
+func TestDeclines_Record(t *testing.T) {
+	// arrange
+	store := storage.NewMemory()
+	d, err := LoadDeclines(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1_700_000_000, 0)
+	def := ChallengeParams{Rated: true, Limit: 60}
+
+	// act
+	errs := []error{
+		d.Record("PickyBot", "rated", "I'm not accepting rated challenges.", def, now),
+		d.Record("GrumpyBot", "later", "Not now.", def, now),
+		d.Record("grumpybot", "later", "Not now.", def, now.Add(2*time.Hour)),
+	}
+	reloaded, loadErr := LoadDeclines(store)
+
+	// assert
+	for _, err := range append(errs, loadErr) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := reloaded.Params("pickybot", def); got != (ChallengeParams{Limit: 60}) {
+		t.Errorf("picky: got %s, want casual", got)
+	}
+	if reloaded.CoolingDown("pickybot", now) {
+		t.Error("picky: want no cooldown, the terms changed")
+	}
+	if !reloaded.CoolingDown("grumpybot", now.Add(3*time.Hour)) {
+		t.Error("grumpy: second decline, want a 2h cooldown")
+	}
+	if reloaded.CoolingDown("grumpybot", now.Add(5*time.Hour)) {
+		t.Error("grumpy: want the cooldown over")
+	}
+	if stats := reloaded.Stats(); len(stats) != 2 || stats[0].Bot != "grumpybot" || stats[0].ByKey["later"] != 2 {
+		t.Errorf("stats: got %+v", stats)
+	}
+}
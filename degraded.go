@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"trollfish-lichess/api"
+)
+
+// retry backoff while lichess is unavailable, see api.Transient
+const (
+	degradedMinBackoff = time.Second
+	degradedMaxBackoff = time.Minute
+)
+
+// backoff doubles the wait between retries, from degradedMinBackoff up to degradedMaxBackoff.
+type backoff struct {
+	next time.Duration
+}
+
+// wait sleeps before the next retry. It returns false if ctx is done first.
+func (b *backoff) wait(ctx context.Context) bool {
+	d := b.delay()
+	if b.next = 2 * d; b.next > degradedMaxBackoff {
+		b.next = degradedMaxBackoff
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// delay is how long the next wait sleeps.
+func (b *backoff) delay() time.Duration {
+	if b.next == 0 {
+		return degradedMinBackoff
+	}
+	return b.next
+}
+
+func (b *backoff) reset() {
+	b.next = 0
+}
+
+// setDegraded marks lichess as unavailable, which pauses matchmaking and accepting
+// challenges until setRecovered. err is why, nil when a stream ended.
+func (l *Listener) setDegraded(err error) {
+	l.degradedMtx.Lock()
+	defer l.degradedMtx.Unlock()
+
+	if err == nil {
+		err = fmt.Errorf("event stream closed")
+	}
+	if l.degradedSince.IsZero() {
+		l.degradedSince = time.Now()
+		fmt.Printf("%s *** lichess unavailable, pausing matchmaking: %v\n", ts(), err)
+	} else {
+		fmt.Printf("%s *** lichess still unavailable after %v: %v\n", ts(), time.Since(l.degradedSince).Round(time.Second), err)
+	}
+}
+
+func (l *Listener) setRecovered() {
+	l.degradedMtx.Lock()
+	defer l.degradedMtx.Unlock()
+
+	if l.degradedSince.IsZero() {
+		return
+	}
+	fmt.Printf("%s lichess is back after %v, resuming matchmaking\n", ts(), time.Since(l.degradedSince).Round(time.Second))
+	l.degradedSince = time.Time{}
+}
+
+// Degraded returns when lichess became unavailable, and false if it's up.
+func (l *Listener) Degraded() (time.Time, bool) {
+	l.degradedMtx.Lock()
+	defer l.degradedMtx.Unlock()
+	return l.degradedSince, !l.degradedSince.IsZero()
+}
+
+func (l *Listener) isDegraded() bool {
+	_, ok := l.Degraded()
+	return ok
+}
+
+// readStreamRetrying reads a stream like api.ReadStream, reconnecting with backoff when
+// lichess is unavailable or the stream drops, until it ends cleanly, handler returns false
+// or ctx is done.
+// connected is called on every successful connect, unavailable on every failed one or
+// dropped stream; either may be nil. Errors that aren't transient are returned. watch, if
+// not nil, tracks the connection and lets the watchdog restart it.
+func readStreamRetrying(ctx context.Context, endpoint string, watch *streamWatch, connected func(), unavailable func(error), handler func([]byte) bool) error {
+	var b backoff
+	for {
+		var stopped bool
+		connCtx, cancel := context.WithCancel(ctx)
+		watch.connecting(cancel)
+		err := api.ReadStreamNotify(connCtx, endpoint, api.StreamHooks{
+			Connected: func() {
+				b.reset()
+				watch.connected()
+				if connected != nil {
+					connected()
+				}
+			},
+			Line: watch.line,
+		}, func(ndjson []byte) bool {
+			if !handler(ndjson) {
+				stopped = true
+				return false
+			}
+			return true
+		})
+		restarted := connCtx.Err() != nil
+		cancel()
+		watch.disconnected()
+
+		if stopped || ctx.Err() != nil {
+			return nil
+		}
+		if restarted {
+			continue // by the watchdog, reconnect right away
+		}
+		if err == nil {
+			return nil // the stream ended
+		}
+		if !api.Transient(err) {
+			return err
+		}
+
+		if unavailable != nil {
+			unavailable(err)
+		} else {
+			fmt.Printf("%s stream '%s' lost: %v, reconnecting\n", ts(), endpoint, err)
+		}
+		if !b.wait(ctx) {
+			return nil
+		}
+	}
+}
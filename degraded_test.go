@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"trollfish-lichess/api"
+	"trollfish-lichess/storage"
+	"trollfish-lichess/yamlbook"
+)
+
+func TestBackoff(t *testing.T) {
+	// arrange
+	b := backoff{next: 40 * time.Second}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// act
+	waited := b.wait(ctx)
+	afterMax := b.next
+	b.reset()
+
+	// assert
+	if waited {
+		t.Error("wait with a done context: got true")
+	}
+	if afterMax != degradedMaxBackoff {
+		t.Errorf("after 40s: got %v, want %v", afterMax, degradedMaxBackoff)
+	}
+	if b.next != 0 {
+		t.Errorf("reset: got %v", b.next)
+	}
+}
+
+func TestGame_ResumeAfterUnavailable(t *testing.T) {
+	// arrange
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	engine := fakeEngine(ctx, map[string]string{
+		"position startpos moves e2e4": "bestmove e7e5 ponder g1f3 eval -0.30",
+	})
+
+	down := true
+	sent := sentMoves{moves: make(chan string, 4)}
+	attempts := make(chan string, 4)
+	g := NewGame(ctx, "test", storage.NewMemory(), engine, &yamlbook.Book{}, nil, GameOptions{}, nil)
+	g.sendMove = func(gameID, move string, draw bool) error {
+		if down {
+			attempts <- move
+			return &api.StatusError{StatusCode: 502, Endpoint: "move"}
+		}
+		return sent.send(gameID, move, draw)
+	}
+
+	// playing black with less time left than the first backoff, so the move isn't retried
+	gameFull := strings.NewReplacer(
+		`"white":{"id":"`+botID+`","name":"`+botID+`"`, `"black":{"id":"`+botID+`","name":"`+botID+`"`,
+		`"black":{"id":"opp"`, `"white":{"id":"opp"`,
+		`"moves":""`, `"moves":"e2e4"`,
+		`"btime":60000`, `"btime":500`,
+	).Replace(testGameFull)
+
+	// act
+	g.post([]byte(gameFull))
+	if move := <-attempts; move != "e7e5" {
+		t.Fatalf("attempt: got %s, want e7e5", move)
+	}
+	waitState(t, g, stateWaitingOpponent)
+	plies := len(g.moves)
+
+	down = false
+	g.post([]byte(gameFull)) // the game stream reconnected
+
+	// assert
+	if plies != 0 {
+		t.Errorf("moves stored after the failed move: got %d, want 0", plies)
+	}
+	if move := <-sent.moves; move != "e7e5" {
+		t.Fatalf("resumed move: got %s, want e7e5", move)
+	}
+	g.Finish()
+	if !g.IsFinished() {
+		t.Error("not finished")
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"trollfish-lichess/fen"
+	"trollfish-lichess/yamlbook"
+)
+
+// BookDeviations is what's done after a game with the positions where the opponent left our
+// book: a book position with moves for them where they played another move.
+type BookDeviations struct {
+	Queue bool // queue the position after their move for analysis, see yamlbook.Book.NeedMoves
+	Add   bool // add their move and our engine reply to the book, queued for review
+}
+
+// deviation is an opponent's move out of our book.
+type deviation struct {
+	fenKey string // before their move
+	san    string
+	ply    int    // of our reply
+	reply  string // our engine reply in SAN, "" when it wasn't searched
+	eval   string // the engine's eval of the reply, white's pov
+}
+
+// trackDeviation records the opponent's move from board, played at ply, when it leaves a
+// book position with moves for them.
+func (g *Game) trackDeviation(board fen.Board, san string, ply int) {
+	if g.book == nil || (!g.opts.Deviations.Queue && !g.opts.Deviations.Add) {
+		return
+	}
+	moves, ok := g.book.Get(board.FENKey())
+	if !ok || moves.ContainsSAN(san) {
+		return
+	}
+
+	fmt.Printf("%s opponent deviated from book with %s, book: %s\n", ts(), san, strings.Join(bookSANs(moves), " "))
+	g.deviations = append(g.deviations, deviation{fenKey: board.FENKey(), san: san, ply: ply})
+}
+
+// trackDeviationReply records our engine move from board at ply, if it replies to a deviation.
+func (g *Game) trackDeviationReply(board fen.Board, ply int, moveUCI, eval string) {
+	if len(g.deviations) == 0 {
+		return
+	}
+	last := &g.deviations[len(g.deviations)-1]
+	if last.ply != ply || eval == "" {
+		return
+	}
+	last.reply, last.eval = board.UCItoSAN(moveUCI), eval
+}
+
+// queueDeviations applies the Deviations options to the game's deviations and returns how
+// many changed the book.
+func (g *Game) queueDeviations(now time.Time) int {
+	var changed int
+	for _, d := range g.deviations {
+		board := fen.FENtoBoard(d.fenKey)
+		uci, err := board.SANtoUCI(d.san)
+		if err != nil {
+			continue
+		}
+		board.Moves(uci)
+		replyKey := board.FENKey()
+
+		if g.opts.Deviations.Add && d.reply != "" {
+			// the reply's eval scores the deviation from their pov and the reply from ours
+			eval := evalScore(d.eval)
+			theirs := eval.For(iif(g.playerColor == fen.WhitePieces, fen.BlackPieces, fen.WhitePieces))
+			ours := eval.For(g.playerColor)
+			source := &yamlbook.Source{Type: yamlbook.SourceEngine, AddedBy: "game " + g.gameID, Date: now.Unix()}
+			if moves, _ := g.book.GetAll(d.fenKey); !moves.ContainsSAN(d.san) {
+				g.book.Add(d.fenKey, &yamlbook.Move{Move: d.san, CP: theirs.CP, Mate: theirs.Mate, TS: now.Unix(), Source: source})
+			}
+			if moves, ok := g.book.GetAll(replyKey); !ok || !moves.ContainsSAN(d.reply) {
+				g.book.Add(replyKey, &yamlbook.Move{Move: d.reply, CP: ours.CP, Mate: ours.Mate, TS: now.Unix(), Source: source})
+			}
+			g.book.MarkForReview(replyKey)
+			changed++
+			fmt.Printf("%s added the deviation %s and our reply %s to the book, queued '%s' for review\n", ts(), d.san, d.reply, replyKey)
+			continue
+		}
+
+		if _, ok := g.book.GetAll(replyKey); g.opts.Deviations.Queue && !ok {
+			g.book.Add(replyKey)
+			changed++
+			fmt.Printf("%s queued '%s' after the deviation %s for analysis\n", ts(), replyKey, d.san)
+		}
+	}
+	return changed
+}
+
+// saveDeviations queues the game's deviations and saves the book.
+func (g *Game) saveDeviations() {
+	if len(g.deviations) == 0 || g.queueDeviations(time.Now()) == 0 {
+		return
+	}
+	if err := g.book.Save(); err != nil {
+		log.Printf("ERR: saving deviations: %v\n", err)
+	}
+}
+
+// bookSANs returns the moves' SANs.
+func bookSANs(moves yamlbook.Moves) []string {
+	sans := make([]string, 0, len(moves))
+	for _, move := range moves {
+		sans = append(sans, move.Move)
+	}
+	return sans
+}
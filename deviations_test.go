@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"trollfish-lichess/fen"
+	"trollfish-lichess/yamlbook"
+)
+
+func TestGame_QueueDeviations(t *testing.T) {
+	// arrange
+	afterE4 := fen.FENtoBoard(startPosFEN)
+	afterE4.Moves("e2e4")
+	afterC5 := afterE4
+	afterC5.Moves("c7c5")
+
+	book := `- fen: ` + fen.Key(startPosFEN) + `
+  moves:
+    - move: e4
+      cp: 30
+- fen: ` + afterE4.FENKey() + `
+  moves:
+    - move: e5
+      cp: -30
+`
+
+	cases := []struct {
+		name       string
+		deviations BookDeviations
+		wantMoves  []string // needing analysis
+		wantReview []string
+	}{
+		{name: "queue", deviations: BookDeviations{Queue: true}, wantMoves: []string{afterC5.FENKey()}},
+		{name: "add", deviations: BookDeviations{Queue: true, Add: true}, wantReview: []string{afterC5.FENKey()}},
+		{name: "off", deviations: BookDeviations{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "book.yamlbook")
+			if err := os.WriteFile(filename, []byte(book), 0644); err != nil {
+				t.Fatal(err)
+			}
+			b, err := yamlbook.Load(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			g := &Game{gameID: "abcd1234", book: b, playerColor: fen.WhitePieces, opts: GameOptions{Deviations: c.deviations}}
+
+			// act
+			g.trackDeviation(afterE4, "e5", 2) // a book move
+			g.trackDeviation(afterE4, "c5", 2)
+			g.trackDeviationReply(afterC5, 2, "g1f3", "0.40")
+			g.queueDeviations(time.Now())
+
+			// assert
+			assertFENs(t, "need moves", b.NeedMoves(), c.wantMoves)
+			assertFENs(t, "need review", b.NeedReview(), c.wantReview)
+
+			if !c.deviations.Add {
+				return
+			}
+			theirs, _ := b.Get(afterE4.FENKey())
+			ours, _ := b.Get(afterC5.FENKey())
+			if c5 := theirs.GetSAN("c5"); c5 == nil || c5.CP != -40 {
+				t.Errorf("c5: got %+v, want cp -40", c5)
+			}
+			if nf3 := ours.GetSAN("Nf3"); nf3 == nil || nf3.CP != 40 || nf3.Source == nil || nf3.Source.AddedBy != "game abcd1234" {
+				t.Errorf("Nf3: got %+v, want cp 40 from the game", nf3)
+			}
+		})
+	}
+}
+
+func assertFENs(t *testing.T, name string, got, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s: got %v, want %v", name, got, want)
+	}
+	for i := range want {
+		if fen.Key(got[i]) != want[i] {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Package eco names openings from their moves, using tables in the format of lichess'
+// chess-openings repository: tab separated eco, name and pgn columns with a header line.
+package eco
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"strings"
+
+	"trollfish-lichess/fen"
+)
+
+const startPosFEN = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
+
+// openings are the common openings the classifier knows without loading a table.
+//
+//go:embed openings.tsv
+var openings string
+
+// Default is the classifier used during play. Load lichess' a.tsv to e.tsv into it for
+// every named variation.
+var Default = New()
+
+type Opening struct {
+	ECO   string
+	Name  string
+	Plies int
+}
+
+func (o Opening) String() string {
+	return fmt.Sprintf("%s %s", o.ECO, o.Name)
+}
+
+// Classifier finds openings by position, so transpositions get the same name.
+type Classifier struct {
+	byKey map[string]Opening
+}
+
+// New returns a classifier with the built-in openings.
+func New() *Classifier {
+	c := &Classifier{byKey: make(map[string]Opening)}
+	if err := c.parse(openings); err != nil {
+		panic(fmt.Errorf("built-in openings: %v", err))
+	}
+	return c
+}
+
+// Load adds the openings in a TSV file, replacing ones with the same position.
+func (c *Classifier) Load(filename string) error {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if err := c.parse(string(b)); err != nil {
+		return fmt.Errorf("'%s': %v", filename, err)
+	}
+	return nil
+}
+
+func (c *Classifier) parse(tsv string) error {
+	lines := strings.Split(strings.TrimSpace(tsv), "\n")
+	for i, line := range lines {
+		if i == 0 && strings.HasPrefix(line, "eco\t") {
+			continue
+		}
+		cols := strings.Split(strings.TrimRight(line, "\r"), "\t")
+		if len(cols) < 3 {
+			return fmt.Errorf("line %d: want eco, name and pgn columns", i+1)
+		}
+		if err := c.Add(cols[0], cols[1], cols[2]); err != nil {
+			return fmt.Errorf("line %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// Add names the position after pgn's moves, e.g. "1. e4 c5 2. Nf3".
+func (c *Classifier) Add(eco, name, pgn string) error {
+	board := fen.FENtoBoard(startPosFEN)
+	plies := 0
+	for _, field := range strings.Fields(pgn) {
+		if strings.HasSuffix(field, ".") {
+			continue
+		}
+		uci, err := board.SANtoUCI(field)
+		if err != nil {
+			return fmt.Errorf("'%s': %v", pgn, err)
+		}
+		board.Moves(uci)
+		plies++
+	}
+
+	c.byKey[board.FENKey()] = Opening{ECO: eco, Name: name, Plies: plies}
+	return nil
+}
+
+// Classify returns the opening of the last named position reached by sans, SAN moves from
+// the start position.
+func (c *Classifier) Classify(sans []string) (Opening, bool) {
+	var opening Opening
+	var found bool
+
+	board := fen.FENtoBoard(startPosFEN)
+	for _, san := range sans {
+		uci, err := board.SANtoUCI(san)
+		if err != nil {
+			break
+		}
+		board.Moves(uci)
+		if o, ok := c.byKey[board.FENKey()]; ok {
+			opening, found = o, true
+		}
+	}
+
+	return opening, found
+}
@@ -0,0 +1,50 @@
+package eco
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestClassifier_Classify(t *testing.T) {
+	// arrange
+	c := New()
+	meran := strings.Fields("d4 d5 c4 c6 Nf3 Nf6 Nc3 e6 e3 Nbd7 Bd3 dxc4 Bxc4 b5 Bd3")
+	nimzo := strings.Fields("d4 e6 c4 Nf6 Nc3 Bb4") // transposes to 1. d4 Nf6 2. c4 e6 3. Nc3 Bb4
+
+	// act
+	gotMeran, meranOK := c.Classify(meran)
+	gotNimzo, nimzoOK := c.Classify(nimzo)
+	_, noneOK := c.Classify(strings.Fields("h3"))
+
+	// assert
+	if !meranOK || gotMeran.ECO != "D47" || gotMeran.Name != "Semi-Slav Defense: Meran Variation" {
+		t.Errorf("meran: got %v", gotMeran)
+	}
+	if !nimzoOK || gotNimzo.ECO != "E20" {
+		t.Errorf("nimzo: got %v", gotNimzo)
+	}
+	if noneOK {
+		t.Error("1. h3: want no opening")
+	}
+}
+
+func TestClassifier_Load(t *testing.T) {
+	// arrange
+	filename := filepath.Join(t.TempDir(), "a.tsv")
+	tsv := "eco\tname\tpgn\nA00\tAmar Opening\t1. Nh3\n"
+	if err := os.WriteFile(filename, []byte(tsv), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := New()
+
+	// act
+	err := c.Load(filename)
+	got, ok := c.Classify([]string{"Nh3", "e5"})
+
+	// assert
+	if err != nil || !ok || got.String() != "A00 Amar Opening" {
+		t.Errorf("got %v %v %v", got, ok, err)
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"trollfish-lichess/analyze"
+	"trollfish-lichess/fen"
+	"trollfish-lichess/storage"
+	"trollfish-lichess/uciproc"
+	"trollfish-lichess/yamlbook"
+)
+
+// eloFilename keeps every rating estimate, oldest first, to follow the book and engine
+// settings over time.
+const eloFilename = "elo.json"
+
+const (
+	eloMaxPlies    = 400 // adjudicated a draw
+	eloMoveTimeout = time.Minute
+)
+
+// EloMatch plays trollfish, with its book when there is one, against stockfish limited to
+// each UCI_Elo level. Both search a fixed number of nodes a move, so results don't depend
+// on the machine's speed.
+type EloMatch struct {
+	Levels   []int // UCI_Elo
+	Games    int   // per level, colors alternate
+	Nodes    int
+	Book     *yamlbook.Book
+	BookName string
+}
+
+// EloLevel is trollfish's result against one UCI_Elo level.
+type EloLevel struct {
+	Elo    int     `json:"elo"`
+	Games  int     `json:"games"`
+	Points float64 `json:"points"`
+}
+
+func (l EloLevel) Score() float64 {
+	if l.Games == 0 {
+		return 0
+	}
+	return l.Points / float64(l.Games)
+}
+
+// performance is the rating the level's score is worth. A perfect or zero score is taken
+// as half a point off, so it stays finite.
+func (l EloLevel) performance() float64 {
+	s := l.Score()
+	half := 0.5 / float64(l.Games)
+	s = math.Max(half, math.Min(1-half, s))
+	return float64(l.Elo) + 400*math.Log10(s/(1-s))
+}
+
+// EloEstimate is one run of an EloMatch.
+type EloEstimate struct {
+	Date   int64      `json:"date"`
+	Nodes  int        `json:"nodes"`
+	Book   string     `json:"book,omitempty"`
+	Levels []EloLevel `json:"levels"`
+	Elo    int        `json:"elo"`
+}
+
+// ParseEloLevels reads comma separated UCI_Elo levels, e.g. "1800,2100,2400".
+func ParseEloLevels(text string) ([]int, error) {
+	var levels []int
+	for _, part := range strings.Split(text, ",") {
+		elo, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || elo <= 0 {
+			return nil, fmt.Errorf("elo levels '%s': want ratings e.g. 1800,2100,2400", text)
+		}
+		levels = append(levels, elo)
+	}
+	sort.Ints(levels)
+	return levels, nil
+}
+
+// estimateElo interpolates the rating at which trollfish would score 50%: between the two
+// levels its score crosses 50% at, or from the performance at the nearest level when it
+// scores above or below 50% against all of them. levels are sorted by Elo.
+func estimateElo(levels []EloLevel) int {
+	if len(levels) == 0 {
+		return 0
+	}
+
+	for i := 0; i+1 < len(levels); i++ {
+		lo, hi := levels[i], levels[i+1]
+		if lo.Score() >= 0.5 && hi.Score() < 0.5 {
+			t := (lo.Score() - 0.5) / (lo.Score() - hi.Score())
+			return int(math.Round(float64(lo.Elo) + t*float64(hi.Elo-lo.Elo)))
+		}
+	}
+
+	nearest := levels[len(levels)-1]
+	if levels[0].Score() < 0.5 {
+		nearest = levels[0]
+	}
+	return int(math.Round(nearest.performance()))
+}
+
+// matchOutcome returns a match game's result, "" while it goes on. seen counts how often
+// each position was reached, board's included.
+func matchOutcome(board fen.Board, seen map[string]int, plies int) string {
+	if len(board.AllLegalMoves()) == 0 {
+		if !board.IsCheck() {
+			return "1/2-1/2"
+		}
+		return iif(board.ActiveColor == fen.WhitePieces, "0-1", "1-0")
+	}
+	if board.HalfmoveClock >= 100 || seen[board.FENKey()] >= 3 || board.PieceCount() <= 2 || plies >= eloMaxPlies {
+		return "1/2-1/2"
+	}
+	return ""
+}
+
+// matchPlayer is one side of an EloMatch.
+type matchPlayer struct {
+	engine *Engine
+	nodes  int
+	book   *yamlbook.Book // nil for none
+}
+
+func (p matchPlayer) move(ctx context.Context, board fen.Board, moves []string) (string, error) {
+	if p.book != nil {
+		if bookMove, _ := p.book.BestMoveBiased(board.FEN(), nil, yamlbook.ExcludeTags(yamlbook.TagHumanOnly)); bookMove != nil {
+			return bookMove.UCI(), nil
+		}
+	}
+
+	pos := "position startpos"
+	if len(moves) != 0 {
+		pos += " moves " + strings.Join(moves, " ")
+	}
+	search, err := p.engine.Go(pos, fmt.Sprintf("go nodes %d", p.nodes))
+	if err != nil {
+		return "", err
+	}
+	result, err := search.Wait(ctx, eloMoveTimeout)
+	if err != nil {
+		return "", err
+	}
+	if result.Move == "" || result.Move == "(none)" {
+		return "", fmt.Errorf("no move in '%s'", board.FEN())
+	}
+	return result.Move, nil
+}
+
+// playMatchGame plays a game from the start position and returns its result and moves.
+func playMatchGame(ctx context.Context, white, black matchPlayer) (string, []string, error) {
+	for _, p := range []matchPlayer{white, black} {
+		_ = p.engine.Send("ucinewgame")
+		if err := p.engine.WaitReady(ctx, engineReadyTimeout); err != nil {
+			return "", nil, err
+		}
+	}
+
+	board := fen.FENtoBoard(startPosFEN)
+	seen := map[string]int{board.FENKey(): 1}
+	var moves []string
+	for {
+		if result := matchOutcome(board, seen, len(moves)); result != "" {
+			return result, moves, nil
+		}
+
+		player := iif(board.ActiveColor == fen.WhitePieces, white, black)
+		move, err := player.move(ctx, board, moves)
+		if err != nil {
+			return "", moves, err
+		}
+		moves = append(moves, move)
+		board.Moves(move)
+		seen[board.FENKey()]++
+	}
+}
+
+// resultPoints returns a side's points for a PGN result.
+func resultPoints(result string, white bool) float64 {
+	switch result {
+	case "1-0":
+		return iif(white, 1.0, 0.0)
+	case "0-1":
+		return iif(white, 0.0, 1.0)
+	}
+	return 0.5
+}
+
+// Run plays every level's games and returns the estimate.
+func (m *EloMatch) Run(ctx context.Context, trollfish, stockfish *Engine) (EloEstimate, error) {
+	troll := matchPlayer{engine: trollfish, nodes: m.Nodes, book: m.Book}
+	sf := matchPlayer{engine: stockfish, nodes: m.Nodes}
+	est := EloEstimate{Date: time.Now().Unix(), Nodes: m.Nodes, Book: m.BookName}
+
+	for _, elo := range m.Levels {
+		if err := stockfish.Send("setoption name UCI_LimitStrength value true", fmt.Sprintf("setoption name UCI_Elo value %d", elo)); err != nil {
+			return est, err
+		}
+
+		level := EloLevel{Elo: elo}
+		for i := 0; i < m.Games; i++ {
+			trollWhite := i%2 == 0
+			white, black := iif(trollWhite, troll, sf), iif(trollWhite, sf, troll)
+			result, moves, err := playMatchGame(ctx, white, black)
+			if err != nil {
+				return est, fmt.Errorf("elo %d game %d: %v", elo, i+1, err)
+			}
+			level.Games++
+			level.Points += resultPoints(result, trollWhite)
+			fmt.Printf("%s elo %d game %d/%d: trollfish %s %s in %d plies\n", ts(), elo, i+1, m.Games, iif(trollWhite, "white", "black"), result, len(moves))
+		}
+		fmt.Printf("%s elo %d: %.1f/%d (%.0f%%)\n", ts(), elo, level.Points, level.Games, level.Score()*100)
+		est.Levels = append(est.Levels, level)
+	}
+
+	est.Elo = estimateElo(est.Levels)
+	return est, nil
+}
+
+// LoadEloHistory returns the saved estimates, oldest first.
+func LoadEloHistory(store storage.Storage) ([]EloEstimate, error) {
+	b, err := store.ReadFile(eloFilename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("'%s': %v", eloFilename, err)
+	}
+	var estimates []EloEstimate
+	if err := json.Unmarshal(b, &estimates); err != nil {
+		return nil, fmt.Errorf("'%s': %v", eloFilename, err)
+	}
+	return estimates, nil
+}
+
+func saveEloEstimate(store storage.Storage, est EloEstimate) ([]EloEstimate, error) {
+	estimates, err := LoadEloHistory(store)
+	if err != nil {
+		return nil, err
+	}
+	estimates = append(estimates, est)
+	b, err := json.MarshalIndent(estimates, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := store.WriteFile(eloFilename, b); err != nil {
+		return nil, fmt.Errorf("write file '%s': %v", eloFilename, err)
+	}
+	return estimates, nil
+}
+
+// PrintEloHistory prints the estimates with the change from the one before made with the
+// same nodes and book, the only ones it compares to.
+func PrintEloHistory(estimates []EloEstimate) {
+	last := make(map[string]int)
+	for _, est := range estimates {
+		key := fmt.Sprintf("%d %s", est.Nodes, est.Book)
+		var change string
+		if prev, ok := last[key]; ok {
+			change = fmt.Sprintf(" (%+d)", est.Elo-prev)
+		}
+		last[key] = est.Elo
+
+		levels := make([]string, 0, len(est.Levels))
+		for _, l := range est.Levels {
+			levels = append(levels, fmt.Sprintf("%d: %.1f/%d", l.Elo, l.Points, l.Games))
+		}
+		fmt.Printf("%s  %d nodes, book %s: %d%s  [%s]\n", time.Unix(est.Date, 0).Format("2006-01-02 15:04"),
+			est.Nodes, iif(est.Book != "", est.Book, "none"), est.Elo, change, strings.Join(levels, ", "))
+	}
+}
+
+// startMatchEngine launches an engine for playing games against and waits for it to be ready.
+func startMatchEngine(ctx context.Context, resources uciproc.Resources, launch func(input <-chan string, output chan<- string) error) (*Engine, error) {
+	input, output := make(chan string, 512), make(chan string, 512)
+	if err := launch(input, output); err != nil {
+		return nil, err
+	}
+	e := NewEngine(ctx, input, output)
+	if err := e.Send(append([]string{"uci"}, resources.Options()...)...); err != nil {
+		return nil, err
+	}
+	return e, e.WaitReady(ctx, engineReadyTimeout)
+}
+
+// RunEloEstimate starts trollfish and stockfish, plays the match, saves the estimate and
+// prints the history.
+func RunEloEstimate(ctx context.Context, store storage.Storage, enginePath string, resources uciproc.Resources, m *EloMatch) error {
+	sfBinary, err := uciproc.Find(analyze.StockfishPath, "STOCKFISH", []string{"stockfish"})
+	if err != nil {
+		return err
+	}
+
+	trollfish, err := startMatchEngine(ctx, resources, func(input <-chan string, output chan<- string) error {
+		_, err := startTrollFish(ctx, enginePath, input, output)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("trollfish: %v", err)
+	}
+	stockfish, err := startMatchEngine(ctx, resources, func(input <-chan string, output chan<- string) error {
+		_, err := startUCI(ctx, sfBinary, input, output)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("stockfish: %v", err)
+	}
+
+	fmt.Printf("%s elo estimate: %d game(s) at each of %v, %d nodes a move, book %s\n", ts(), m.Games, m.Levels, m.Nodes, iif(m.BookName != "", m.BookName, "none"))
+	est, err := m.Run(ctx, trollfish, stockfish)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return nil
+		}
+		return err
+	}
+
+	estimates, err := saveEloEstimate(store, est)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nestimated %d Elo\n\n", est.Elo)
+	PrintEloHistory(estimates)
+	return nil
+}
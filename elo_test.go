@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"trollfish-lichess/fen"
+	"trollfish-lichess/storage"
+)
+
+func TestEstimateElo(t *testing.T) {
+	cases := []struct {
+		name   string
+		levels []EloLevel
+		want   int
+	}{
+		{"crosses 50%", []EloLevel{{1800, 10, 8}, {2100, 10, 6}, {2400, 10, 2}}, 2175},
+		{"exactly 50%", []EloLevel{{2000, 10, 5}, {2200, 10, 3}}, 2000},
+		{"above every level", []EloLevel{{1800, 10, 10}, {2000, 10, 7.5}}, 2191},
+		{"below every level", []EloLevel{{2400, 10, 2.5}, {2600, 10, 0}}, 2209},
+		{"none", nil, 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// act
+			got := estimateElo(c.levels)
+
+			// assert
+			if got != c.want {
+				t.Errorf("got %d, want %d", got, c.want)
+			}
+		})
+	}
+}
+
+func TestMatchOutcome(t *testing.T) {
+	// arrange
+	mated := fen.FENtoBoard(startPosFEN)
+	mated.Moves("f2f3", "e7e5", "g2g4", "d8h4")
+	stalemate := fen.FENtoBoard("7k/5Q2/6K1/8/8/8/8/8 b - - 0 1")
+	bareKings := fen.FENtoBoard("7k/8/6K1/8/8/8/8/8 w - - 0 1")
+	start := fen.FENtoBoard(startPosFEN)
+
+	// act
+	got := []string{
+		matchOutcome(mated, nil, 4),
+		matchOutcome(stalemate, nil, 80),
+		matchOutcome(bareKings, nil, 80),
+		matchOutcome(start, map[string]int{start.FENKey(): 3}, 8),
+		matchOutcome(start, nil, eloMaxPlies),
+		matchOutcome(start, map[string]int{start.FENKey(): 2}, 4),
+	}
+
+	// assert
+	want := []string{"0-1", "1/2-1/2", "1/2-1/2", "1/2-1/2", "1/2-1/2", ""}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestEloMatch_Run(t *testing.T) {
+	// arrange
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// fool's mate whichever color trollfish has
+	moves := map[string]string{
+		"position startpos":                      "bestmove f2f3",
+		"position startpos moves f2f3":           "bestmove e7e5",
+		"position startpos moves f2f3 e7e5":      "bestmove g2g4",
+		"position startpos moves f2f3 e7e5 g2g4": "bestmove d8h4",
+	}
+	m := EloMatch{Levels: []int{2000}, Games: 2, Nodes: 1000}
+
+	// act
+	est, err := m.Run(ctx, fakeEngine(ctx, moves), fakeEngine(ctx, moves))
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(est.Levels) != 1 || est.Levels[0] != (EloLevel{Elo: 2000, Games: 2, Points: 1}) {
+		t.Errorf("levels: got %+v, want one win as black and one loss as white", est.Levels)
+	}
+	if est.Elo != 2000 {
+		t.Errorf("elo: got %d, want 2000", est.Elo)
+	}
+}
+
+func TestEloHistory(t *testing.T) {
+	// arrange
+	store := storage.NewMemory()
+
+	// act
+	_, err := saveEloEstimate(store, EloEstimate{Date: 1, Nodes: 1000, Elo: 2100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := saveEloEstimate(store, EloEstimate{Date: 2, Nodes: 1000, Elo: 2150})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, badErr := ParseEloLevels("2000,strong")
+	levels, _ := ParseEloLevels("2400, 1800")
+
+	// assert
+	if len(got) != 2 || got[0].Elo != 2100 || got[1].Elo != 2150 {
+		t.Errorf("history: got %+v", got)
+	}
+	if badErr == nil || !strings.Contains(badErr.Error(), "strong") {
+		t.Errorf("bad levels: got %v", badErr)
+	}
+	if !reflect.DeepEqual(levels, []int{1800, 2400}) {
+		t.Errorf("levels: got %v, want sorted", levels)
+	}
+}
@@ -0,0 +1,415 @@
+// Package endgame knows the technique of common theoretical endings: the rule of the
+// square and key squares in king and pawn endings, the Lucena and Philidor positions in
+// rook endings. Positions are matched by a signature of their material and pawn files,
+// after normalizing them so the stronger side is white and the pawns are on the queen side.
+package endgame
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"trollfish-lichess/fen"
+)
+
+// Verdict is an ending's theoretical result for the side to move.
+type Verdict int
+
+const (
+	Unknown Verdict = iota
+	Loss
+	Draw
+	Win
+)
+
+func (v Verdict) String() string {
+	switch v {
+	case Loss:
+		return "loss"
+	case Draw:
+		return "draw"
+	case Win:
+		return "win"
+	default:
+		return "unknown"
+	}
+}
+
+// opponent returns the verdict for the other side.
+func (v Verdict) opponent() Verdict {
+	switch v {
+	case Loss:
+		return Win
+	case Win:
+		return Loss
+	default:
+		return v
+	}
+}
+
+// Probe is what's known about a position.
+type Probe struct {
+	Ending  string
+	Verdict Verdict  // for the side to move
+	Moves   []string // the technique's moves in UCI, none when it doesn't prefer any
+}
+
+// outcome is an ending's result in the normalized position, where the stronger side is white.
+type outcome int
+
+const (
+	unknown outcome = iota
+	drawn
+	won // by white
+)
+
+// ending is a known theoretical ending. rule returns its outcome in a normalized position
+// with one of signatures, and the technique's moves for the side to move, if any.
+type ending struct {
+	name       string
+	signatures []string
+	rule       func(p *position) (outcome, []string)
+}
+
+var (
+	kpk       = []string{"KPvK:a", "KPvK:b", "KPvK:c", "KPvK:d"}
+	kpkNoRook = []string{"KPvK:b", "KPvK:c", "KPvK:d"}
+	krpkr     = []string{"KRPvKR:b", "KRPvKR:c", "KRPvKR:d"}
+)
+
+// endings are tried in order, the first with an outcome wins.
+var endings = []*ending{
+	{name: "insufficient material", signatures: []string{"KvK", "KNvK", "KBvK", "KNNvK"}, rule: insufficientMaterial},
+	{name: "queen or rook against king", signatures: []string{"KQvK", "KRvK"}, rule: majorPieceMate},
+	{name: "king and pawn: the pawn falls", signatures: kpk, rule: pawnFalls},
+	{name: "king and pawn: rule of the square", signatures: kpk, rule: ruleOfTheSquare},
+	{name: "king and pawn: rook pawn", signatures: []string{"KPvK:a"}, rule: rookPawnCorner},
+	{name: "king and pawn: king in front of the pawn", signatures: kpkNoRook, rule: kingInFront},
+	{name: "king and pawn: key squares", signatures: kpkNoRook, rule: keySquares},
+	{name: "Lucena position", signatures: krpkr, rule: lucena},
+	{name: "Philidor position", signatures: krpkr, rule: philidor},
+}
+
+var bySignature = make(map[string][]*ending)
+
+func init() {
+	for _, e := range endings {
+		for _, sig := range e.signatures {
+			bySignature[sig] = append(bySignature[sig], e)
+		}
+	}
+}
+
+// Lookup returns what's known about board, false if it isn't a known ending.
+func Lookup(board fen.Board) (Probe, bool) {
+	p, ok := normalize(board)
+	if !ok {
+		return Probe{}, false
+	}
+	candidates := bySignature[p.sig]
+	if len(candidates) == 0 {
+		return Probe{}, false
+	}
+
+	if len(board.AllLegalMoves()) == 0 {
+		if board.IsCheck() {
+			return Probe{Ending: "checkmate", Verdict: Loss}, true
+		}
+		return Probe{Ending: "stalemate", Verdict: Draw}, true
+	}
+
+	for _, e := range candidates {
+		result, moves := e.rule(p)
+		if result == unknown {
+			continue
+		}
+		verdict := Draw
+		if result == won {
+			verdict = Loss
+			if p.whiteToMove() {
+				verdict = Win
+			}
+		}
+
+		probe := Probe{Ending: e.name, Verdict: verdict}
+		for _, move := range moves {
+			probe.Moves = append(probe.Moves, p.denormalize(move))
+		}
+		return probe, true
+	}
+	return Probe{}, false
+}
+
+// Moves returns the moves to play in a known ending: its technique's, or else the moves that
+// don't spoil its result, leaving out those reaching a known ending with a worse verdict. ok
+// is false when board isn't a known ending or any of its moves will do.
+func Moves(board fen.Board) (Probe, []string, bool) {
+	probe, ok := Lookup(board)
+	if !ok {
+		return Probe{}, nil, false
+	}
+	if len(probe.Moves) != 0 {
+		return probe, probe.Moves, true
+	}
+
+	var keep []string
+	var spoiled bool
+	for _, move := range board.AllLegalMoves() {
+		next := board
+		next.Moves(move.UCI)
+		if after, ok := Lookup(next); ok && after.Verdict.opponent() < probe.Verdict {
+			spoiled = true
+			continue
+		}
+		keep = append(keep, move.UCI)
+	}
+	if !spoiled || len(keep) == 0 {
+		return probe, nil, false
+	}
+	return probe, keep, true
+}
+
+// square is a square of the board, file 0-7 for a-h and rank 1-8.
+type square struct {
+	file, rank int
+}
+
+func squareOf(index int) square {
+	return square{file: index % 8, rank: 8 - index/8}
+}
+
+func parseSquare(s string) square {
+	return square{file: int(s[0] - 'a'), rank: int(s[1] - '0')}
+}
+
+func (s square) index() int {
+	return (8-s.rank)*8 + s.file
+}
+
+func (s square) String() string {
+	return fmt.Sprintf("%c%d", 'a'+s.file, s.rank)
+}
+
+// dist is the number of king moves between a and b.
+func dist(a, b square) int {
+	return max(abs(a.file-b.file), abs(a.rank-b.rank))
+}
+
+// position is a board normalized so the stronger side is white and its pawns are on the
+// queen side.
+type position struct {
+	board    fen.Board
+	flipped  bool // the colors swapped and the ranks mirrored
+	mirrored bool // the files mirrored
+	sig      string
+}
+
+var pieceValues = map[byte]int{'P': 1, 'N': 3, 'B': 3, 'R': 5, 'Q': 9}
+
+// normalize returns board's normalized position and its signature, false for positions
+// with castling rights.
+func normalize(board fen.Board) (*position, bool) {
+	for _, castling := range board.Castling {
+		if castling {
+			return nil, false
+		}
+	}
+
+	var white, black, whitePawns, blackPawns int
+	for _, c := range board.Pos {
+		switch {
+		case c >= 'A' && c <= 'Z':
+			white += pieceValues[c]
+			whitePawns += iif(c == 'P', 1, 0)
+		case c >= 'a' && c <= 'z':
+			black += pieceValues[c-32]
+			blackPawns += iif(c == 'p', 1, 0)
+		}
+	}
+
+	p := &position{flipped: black > white || black == white && blackPawns > whitePawns}
+
+	transform := func(s square) square {
+		if p.flipped {
+			s.rank = 9 - s.rank
+		}
+		if p.mirrored {
+			s.file = 7 - s.file
+		}
+		return s
+	}
+	pawnFiles := func() string {
+		var files []byte
+		for i, c := range board.Pos {
+			if c == iif[byte](p.flipped, 'p', 'P') {
+				files = append(files, byte('a'+transform(squareOf(i)).file))
+			}
+		}
+		sort.Slice(files, func(i, j int) bool { return files[i] < files[j] })
+		return string(files)
+	}
+	files := pawnFiles()
+	p.mirrored = true
+	if mirrored := pawnFiles(); mirrored >= files {
+		p.mirrored = false
+	}
+
+	var b fen.Board
+	for i := range b.Pos {
+		b.Pos[i] = ' '
+	}
+	for i, c := range board.Pos {
+		if c == ' ' {
+			continue
+		}
+		if p.flipped {
+			c = swapCase(c)
+		}
+		b.Pos[transform(squareOf(i)).index()] = c
+	}
+	b.ActiveColor = board.ActiveColor
+	if p.flipped {
+		b.ActiveColor = -b.ActiveColor
+	}
+	b.EnPassantSquare = -1
+	if board.EnPassantSquare != -1 {
+		b.EnPassantSquare = transform(squareOf(board.EnPassantSquare)).index()
+	}
+	b.HalfmoveClock, b.FullMove = board.HalfmoveClock, board.FullMove
+	p.board = fen.FENtoBoard(b.FEN())
+
+	p.sig = material(p.board)
+	if whitePawns+blackPawns != 0 {
+		p.sig += ":" + p.pawnFiles('P')
+		if blacks := p.pawnFiles('p'); blacks != "" {
+			p.sig += "/" + blacks
+		}
+	}
+	return p, true
+}
+
+// material is the white and black pieces, e.g. KRPvKR.
+func material(board fen.Board) string {
+	const order = "KQRBNP"
+	var white, black []byte
+	for _, c := range board.Pos {
+		if c >= 'A' && c <= 'Z' {
+			white = append(white, c)
+		} else if c >= 'a' && c <= 'z' {
+			black = append(black, c-32)
+		}
+	}
+	for _, pieces := range [][]byte{white, black} {
+		pieces := pieces
+		sort.Slice(pieces, func(i, j int) bool { return strings.IndexByte(order, pieces[i]) < strings.IndexByte(order, pieces[j]) })
+	}
+	return string(white) + "v" + string(black)
+}
+
+func (p *position) pawnFiles(pawn byte) string {
+	var files []byte
+	for i, c := range p.board.Pos {
+		if c == pawn {
+			files = append(files, byte('a'+squareOf(i).file))
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i] < files[j] })
+	return string(files)
+}
+
+// denormalize returns a normalized move in the original board's squares.
+func (p *position) denormalize(uci string) string {
+	convert := func(s square) square {
+		if p.mirrored {
+			s.file = 7 - s.file
+		}
+		if p.flipped {
+			s.rank = 9 - s.rank
+		}
+		return s
+	}
+	return convert(parseSquare(uci[0:2])).String() + convert(parseSquare(uci[2:4])).String() + uci[4:]
+}
+
+func (p *position) whiteToMove() bool {
+	return p.board.ActiveColor == fen.WhitePieces
+}
+
+// find returns the square of the first of piece, false if there's none.
+func (p *position) find(piece byte) (square, bool) {
+	return findOn(p.board, piece)
+}
+
+func findOn(board fen.Board, piece byte) (square, bool) {
+	for i, c := range board.Pos {
+		if c == piece {
+			return squareOf(i), true
+		}
+	}
+	return square{}, false
+}
+
+// rookLine reports whether a rook on a attacks b, the squares between them being empty.
+func rookLine(board fen.Board, a, b square) bool {
+	if a == b || a.file != b.file && a.rank != b.rank {
+		return false
+	}
+	df, dr := sign(b.file-a.file), sign(b.rank-a.rank)
+	for s := (square{a.file + df, a.rank + dr}); s != b; s = (square{s.file + df, s.rank + dr}) {
+		if board.Pos[s.index()] != ' ' {
+			return false
+		}
+	}
+	return true
+}
+
+// movesWhere returns the legal moves after which keep holds in the normalized position.
+func (p *position) movesWhere(keep func(next *position) bool) []string {
+	var moves []string
+	for _, move := range p.board.AllLegalMoves() {
+		next := *p
+		next.board.Moves(move.UCI)
+		if keep(&next) {
+			moves = append(moves, move.UCI)
+		}
+	}
+	return moves
+}
+
+func swapCase(c byte) byte {
+	if c >= 'a' && c <= 'z' {
+		return c - 32
+	}
+	return c + 32
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+func iif[T any](condition bool, ifTrue, ifFalse T) T {
+	if condition {
+		return ifTrue
+	}
+	return ifFalse
+}
@@ -0,0 +1,100 @@
+package endgame
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"trollfish-lichess/fen"
+)
+
+func TestLookup(t *testing.T) {
+	cases := []struct {
+		name    string
+		fen     string
+		ok      bool
+		ending  string
+		verdict Verdict
+		moves   []string
+	}{
+		{name: "key square", fen: "4k3/8/3K4/8/4P3/8/8/8 w - - 0 1", ok: true, ending: "king and pawn: key squares", verdict: Win},
+		{name: "key square, black pawn", fen: "8/8/8/4p3/8/3k4/8/4K3 b - - 0 1", ok: true, ending: "king and pawn: key squares", verdict: Win},
+		{name: "key square, black pawn, white to move", fen: "8/8/8/4p3/8/3k4/8/4K3 w - - 0 1", ok: true, ending: "king and pawn: key squares", verdict: Loss},
+		{name: "king in front", fen: "8/8/8/4k3/4P3/8/8/4K3 w - - 0 1", ok: true, ending: "king and pawn: king in front of the pawn", verdict: Draw},
+		{name: "pawn falls", fen: "8/8/8/8/3kP3/8/8/K7 b - - 0 1", ok: true, ending: "king and pawn: the pawn falls", verdict: Draw},
+		{name: "rule of the square", fen: "8/k7/8/8/7P/8/8/K7 w - - 0 1", ok: true, ending: "king and pawn: rule of the square", verdict: Win},
+		{name: "rook pawn corner", fen: "k7/8/8/8/P7/8/8/7K w - - 0 1", ok: true, ending: "king and pawn: rook pawn", verdict: Draw},
+		{name: "stalemate", fen: "k7/P7/K7/8/8/8/8/8 b - - 0 1", ok: true, ending: "stalemate", verdict: Draw},
+		{name: "insufficient material", fen: "8/8/4k3/8/8/2N5/8/4K3 w - - 0 1", ok: true, ending: "insufficient material", verdict: Draw},
+		{
+			name: "Lucena, bridge", fen: "3K4/3P2k1/8/8/8/8/r7/4R3 w - - 0 1",
+			ok: true, ending: "Lucena position", verdict: Win, moves: []string{"e1e4"},
+		},
+		{
+			name: "Lucena, bridge, black pawn", fen: "4r3/R7/8/8/8/8/3p2K1/3k4 b - - 0 1",
+			ok: true, ending: "Lucena position", verdict: Win, moves: []string{"e8e5"},
+		},
+		{
+			name: "Lucena, check first", fen: "3K4/3P1k2/8/8/8/8/r7/4R3 w - - 0 1",
+			ok: true, ending: "Lucena position", verdict: Win, moves: []string{"e1f1"},
+		},
+		{
+			name: "Lucena, king steps out", fen: "3K4/3P2k1/8/8/4R3/8/r7/8 w - - 0 1",
+			ok: true, ending: "Lucena position", verdict: Win, moves: []string{"d8e7"},
+		},
+		{
+			name: "Philidor, rook on the sixth", fen: "4k3/7R/r7/4P3/4K3/8/8/8 b - - 0 1",
+			ok: true, ending: "Philidor position", verdict: Draw,
+			moves: []string{"a6b6", "a6c6", "a6e6", "a6g6", "e8d8", "e8f8"},
+		},
+		{
+			name: "Philidor, the pawn reached the sixth", fen: "4k3/7R/r3P3/8/4K3/8/8/8 b - - 0 1",
+			ok: true, ending: "Philidor position", verdict: Draw,
+			moves: []string{"a6a1", "a6a2", "a6e6"}, // or take the pawn
+		},
+		{name: "start position", fen: "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"},
+		{name: "unknown rook ending", fen: "8/8/3k4/8/3P4/8/r7/3K3R w - - 0 1"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// act
+			probe, ok := Lookup(fen.FENtoBoard(c.fen))
+
+			// assert
+			if ok != c.ok {
+				t.Fatalf("ok: got %v, want %v (%+v)", ok, c.ok, probe)
+			}
+			if probe.Ending != c.ending || probe.Verdict != c.verdict {
+				t.Errorf("got %s %v, want %s %v", probe.Ending, probe.Verdict, c.ending, c.verdict)
+			}
+			sort.Strings(probe.Moves)
+			if !reflect.DeepEqual(probe.Moves, c.moves) {
+				t.Errorf("moves: got %v, want %v", probe.Moves, c.moves)
+			}
+		})
+	}
+}
+
+func TestMoves(t *testing.T) {
+	// arrange
+	board := fen.FENtoBoard("8/P7/8/8/8/8/6k1/K7 w - - 0 1")
+
+	// act
+	probe, moves, ok := Moves(board)
+
+	// assert
+	if !ok || probe.Verdict != Win {
+		t.Fatalf("got %+v %v, want a win", probe, ok)
+	}
+	want := []string{"a1a2", "a1b1", "a1b2", "a7a8q", "a7a8r"}
+	sort.Strings(moves)
+	if !reflect.DeepEqual(moves, want) {
+		t.Errorf("got %v, want %v (no underpromotion to a knight or bishop)", moves, want)
+	}
+
+	// any move keeps the draw
+	if _, moves, ok := Moves(fen.FENtoBoard("k7/8/8/8/P7/8/8/7K w - - 0 1")); ok {
+		t.Errorf("got %v, want no restriction", moves)
+	}
+}
@@ -0,0 +1,211 @@
+package endgame
+
+// The rules see the normalized position: white is the stronger side, its pawn is on files
+// a-d and ranks count from white's side.
+
+func insufficientMaterial(p *position) (outcome, []string) {
+	return drawn, nil
+}
+
+// majorPieceMate is won unless black takes the undefended queen or rook.
+func majorPieceMate(p *position) (outcome, []string) {
+	piece, ok := p.find('Q')
+	if !ok {
+		piece, _ = p.find('R')
+	}
+	wk, _ := p.find('K')
+	bk, _ := p.find('k')
+	if !p.whiteToMove() && dist(bk, piece) == 1 && dist(wk, piece) > 1 {
+		return drawn, nil
+	}
+	return won, nil
+}
+
+// kpkSquares returns the pawn and kings of a king and pawn ending.
+func kpkSquares(p *position) (pawn, wk, bk square) {
+	pawn, _ = p.find('P')
+	wk, _ = p.find('K')
+	bk, _ = p.find('k')
+	return pawn, wk, bk
+}
+
+// pawnFalls: black to move takes the undefended pawn.
+func pawnFalls(p *position) (outcome, []string) {
+	pawn, wk, bk := kpkSquares(p)
+	if !p.whiteToMove() && dist(bk, pawn) == 1 && dist(wk, pawn) > 1 {
+		return drawn, nil
+	}
+	return unknown, nil
+}
+
+// ruleOfTheSquare: the pawn queens before the black king can catch it or the new queen.
+// The pawn's double step isn't counted, so a pawn on its second rank is only given the
+// moves it needs from the third.
+func ruleOfTheSquare(p *position) (outcome, []string) {
+	pawn, wk, bk := kpkSquares(p)
+	if wk.file == pawn.file && wk.rank > pawn.rank {
+		return unknown, nil // in the pawn's way
+	}
+
+	moves := 8 - pawn.rank
+	blackMoves := iif(p.whiteToMove(), moves-1, moves) // before the pawn queens
+	if dist(bk, square{file: pawn.file, rank: 8})-blackMoves >= 2 {
+		return won, nil
+	}
+	return unknown, nil
+}
+
+// rookPawnCorner: the black king in the corner ahead of a rook pawn can't be driven out.
+func rookPawnCorner(p *position) (outcome, []string) {
+	pawn, _, bk := kpkSquares(p)
+	if bk.file <= 1 && bk.rank >= 7 && bk.rank > pawn.rank {
+		return drawn, nil
+	}
+	return unknown, nil
+}
+
+// kingInFront: the black king right in front of the pawn holds the draw.
+func kingInFront(p *position) (outcome, []string) {
+	pawn, _, bk := kpkSquares(p)
+	if bk == (square{file: pawn.file, rank: pawn.rank + 1}) {
+		return drawn, nil
+	}
+	return unknown, nil
+}
+
+// keySquares: the white king on a key square wins whoever is to move. They're the three
+// squares two ranks ahead of the pawn, and once it's crossed to the fifth rank the three one
+// rank ahead as well.
+func keySquares(p *position) (outcome, []string) {
+	pawn, wk, bk := kpkSquares(p)
+	if pawn.rank < 2 || pawn.rank > 6 {
+		return unknown, nil
+	}
+	if dist(bk, pawn) == 1 && dist(wk, pawn) > 1 {
+		return unknown, nil
+	}
+
+	minRank := pawn.rank + 2
+	if pawn.rank >= 5 {
+		minRank = pawn.rank + 1
+	}
+	if abs(wk.file-pawn.file) <= 1 && wk.rank >= minRank && wk.rank <= pawn.rank+2 {
+		return won, nil
+	}
+	return unknown, nil
+}
+
+// krpkrSquares returns the pieces of a rook and pawn against rook ending.
+func krpkrSquares(p *position) (pawn, wk, wr, bk, br square) {
+	pawn, _ = p.find('P')
+	wk, _ = p.find('K')
+	wr, _ = p.find('R')
+	bk, _ = p.find('k')
+	br, _ = p.find('r')
+	return pawn, wk, wr, bk, br
+}
+
+// lucena is won with white to move: the pawn on the seventh, the king on the queening square
+// and the black king cut off by the rook. If the black king is only two files from the pawn
+// the rook checks it a file further away, then the rook goes to the fourth rank and the king
+// steps out towards it, to block the checks from the side with the rook (building a bridge).
+func lucena(p *position) (outcome, []string) {
+	pawn, wk, wr, bk, _ := krpkrSquares(p)
+	if !p.whiteToMove() || p.board.IsCheck() || pawn.rank != 7 || wk != (square{file: pawn.file, rank: 8}) {
+		return unknown, nil
+	}
+	side := sign(bk.file - pawn.file)
+	files := abs(bk.file - pawn.file)
+	if files < 2 || sign(wr.file-pawn.file) != side || abs(wr.file-pawn.file) >= files {
+		return unknown, nil
+	}
+
+	var moves []string
+	switch {
+	case files == 2:
+		moves = p.movesWhere(func(next *position) bool {
+			to, ok := next.find('R')
+			return ok && to.file == bk.file && dist(to, bk) > 1 && rookLine(next.board, to, bk)
+		})
+	case wr.rank != 4:
+		to := square{file: wr.file, rank: 4}
+		moves = p.movesWhere(func(next *position) bool {
+			r, ok := next.find('R')
+			return ok && r == to && dist(to, bk) > 1 && !rookAttacked(next)
+		})
+	default:
+		to := square{file: pawn.file + side, rank: 7}
+		moves = p.movesWhere(func(next *position) bool {
+			k, _ := next.find('K')
+			return k == to
+		})
+	}
+	return won, moves
+}
+
+// rookAttacked reports whether black attacks the white rook where nothing defends it.
+func rookAttacked(p *position) bool {
+	wr, _ := p.find('R')
+	wk, _ := p.find('K')
+	bk, _ := p.find('k')
+	br, _ := p.find('r')
+	return (dist(bk, wr) == 1 || rookLine(p.board, br, wr)) && dist(wk, wr) > 1
+}
+
+// blackRookFree reports whether white to move can win the black rook: it's attacked and its
+// king doesn't defend it.
+func blackRookFree(p *position) bool {
+	br, _ := p.find('r')
+	bk, _ := p.find('k')
+	wk, _ := p.find('K')
+	wr, hasRook := p.find('R')
+	pawn, hasPawn := p.find('P')
+
+	attacked := dist(wk, br) == 1 ||
+		hasRook && rookLine(p.board, wr, br) ||
+		hasPawn && br.rank == pawn.rank+1 && abs(br.file-pawn.file) == 1
+	return attacked && dist(bk, br) > 1
+}
+
+// philidorSetup reports whether black holds the Philidor position: its king in front of
+// the pawn, the rook on the sixth rank keeping the white king out until the pawn advances,
+// then checking from behind on the first ranks, where the white king can't hide.
+func philidorSetup(p *position) bool {
+	pawn, wk, _, bk, br := krpkrSquares(p)
+	if abs(bk.file-pawn.file) > 1 || bk.rank < 7 || bk.rank <= pawn.rank {
+		return false
+	}
+	if p.whiteToMove() && blackRookFree(p) {
+		return false
+	}
+	switch {
+	case pawn.rank <= 5:
+		return wk.rank <= 5 && br.rank == 6
+	case pawn.rank == 6:
+		return wk.rank <= 6 && br.rank <= 2
+	}
+	return false
+}
+
+// philidor is drawn while black keeps the Philidor position. Black to move keeps it, drops
+// the rook to the first ranks once the pawn reaches the sixth, or takes the pawn.
+func philidor(p *position) (outcome, []string) {
+	pawn, wk, _, bk, br := krpkrSquares(p)
+	holds := philidorSetup(p)
+	// the pawn just reached the sixth with the rook still on it
+	advanced := !p.whiteToMove() && pawn.rank == 6 && br.rank == 6 && wk.rank <= 5 &&
+		abs(bk.file-pawn.file) <= 1 && bk.rank >= 7
+	if !holds && !advanced {
+		return unknown, nil
+	}
+	if p.whiteToMove() {
+		return drawn, nil
+	}
+
+	return drawn, p.movesWhere(func(next *position) bool {
+		if _, ok := next.find('P'); !ok {
+			return !blackRookFree(next)
+		}
+		return philidorSetup(next)
+	})
+}
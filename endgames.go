@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"trollfish-lichess/analyze"
+	"trollfish-lichess/api"
+	"trollfish-lichess/endgame"
+	"trollfish-lichess/fen"
+)
+
+// Endgames plays the technique of known theoretical endings (see package endgame) in fast
+// games, where a short search wobbles in positions a human knows by heart. Endings the
+// Syzygy tablebases cover are left to the engine.
+type Endgames struct {
+	Speeds          []string // lichess speeds, e.g. bullet and blitz; none = off
+	TablebasePieces int      // the most pieces the tablebases cover, when there's a SyzygyPath
+}
+
+func (e Endgames) applies(speed string, board fen.Board) bool {
+	if analyze.SyzygyPath != "" && board.PieceCount() <= e.TablebasePieces {
+		return false
+	}
+	for _, s := range e.Speeds {
+		if s == speed {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSpeeds reads comma separated lichess speeds, e.g. "bullet,blitz".
+func parseSpeeds(text string) []string {
+	var speeds []string
+	for _, speed := range strings.Split(text, ",") {
+		if speed = strings.TrimSpace(speed); speed != "" {
+			speeds = append(speeds, speed)
+		}
+	}
+	return speeds
+}
+
+// endgameTechnique checks bestMove against a known ending's technique. If it strays from it,
+// or spoils the ending's result, the engine is asked for its best move among the
+// technique's, or that one is played if it's the only one.
+func (g *Game) endgameTechnique(ctx context.Context, state api.State, board fen.Board, bestMove string, ourTime time.Duration) string {
+	if bestMove == "" || !g.opts.Endgames.applies(g.perf, board) {
+		return bestMove
+	}
+	probe, moves, ok := endgame.Moves(board)
+	if !ok {
+		return bestMove
+	}
+	for _, move := range moves {
+		if move == bestMove {
+			return bestMove
+		}
+	}
+
+	bestMoveSAN := board.UCItoSAN(bestMove)
+	fmt.Printf("%s %s (%s): %s strays from the technique, %d move(s) follow it\n", ts(), probe.Ending, probe.Verdict, bestMoveSAN, len(moves))
+
+	g.stopPondering()
+	g.ponder = ""
+
+	move := moves[0]
+	if len(moves) > 1 {
+		moveTime := ourTime / 50
+		if moveTime > 2*time.Second {
+			moveTime = 2 * time.Second
+		} else if moveTime < 100*time.Millisecond {
+			moveTime = 100 * time.Millisecond
+		}
+
+		search, err := g.engine.Go(g.positionCommand(state.Moves), fmt.Sprintf("go movetime %d searchmoves %s", moveTime.Milliseconds(), strings.Join(moves, " ")))
+		if err != nil {
+			fmt.Printf("%s *** ERR: go: %v\n", ts(), err)
+			return move
+		}
+		result, err := search.Wait(ctx, moveTime+engineMoveGrace)
+		if err != nil {
+			fmt.Printf("%s *** ERR: waiting for bestmove: %v\n", ts(), err)
+			return move
+		}
+		if ctx.Err() != nil || result.Move == "" || result.Move == "(none)" {
+			return move
+		}
+		g.moveAudit.AddSearch(search, result)
+		g.searched = &result
+
+		move = result.Move
+		if result.Eval != "" {
+			g.humanEval = result.Eval
+		}
+		if result.Ponder != "" {
+			g.ponderMove(result.Ponder, state, move)
+		}
+	}
+
+	fmt.Printf("%s %s: %s instead of %s\n", ts(), probe.Ending, board.UCItoSAN(move), bestMoveSAN)
+	g.moveAudit.Note("endgame technique (%s, %s): %s instead of %s", probe.Ending, probe.Verdict, board.UCItoSAN(move), bestMoveSAN)
+	return move
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"trollfish-lichess/api"
+	"trollfish-lichess/fen"
+	"trollfish-lichess/storage"
+	"trollfish-lichess/yamlbook"
+)
+
+func TestGame_EndgameTechnique(t *testing.T) {
+	const (
+		lucena   = "3K4/3P2k1/8/8/8/8/r7/4R3 w - - 0 1"
+		philidor = "4k3/7R/r7/4P3/4K3/8/8/8 b - - 0 1"
+	)
+
+	cases := []struct {
+		name     string
+		fen      string
+		speed    string
+		bestMove string
+		want     string
+	}{
+		{name: "only technique move", fen: lucena, speed: "blitz", bestMove: "e1e2", want: "e1e4"},
+		{name: "engine picks a technique move", fen: philidor, speed: "blitz", bestMove: "a6a1", want: "a6c6"},
+		{name: "already the technique", fen: philidor, speed: "blitz", bestMove: "e8d8", want: "e8d8"},
+		{name: "not a fast game", fen: lucena, speed: "classical", bestMove: "e1e2", want: "e1e2"},
+		{name: "not a known ending", fen: "8/8/3k4/8/3P4/8/r7/3K3R w - - 0 1", speed: "blitz", bestMove: "h1h6", want: "h1h6"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			engine := fakeEngine(ctx, map[string]string{
+				"position fen " + philidor: "bestmove a6c6 eval 0.00",
+			})
+			opts := GameOptions{Endgames: Endgames{Speeds: []string{"bullet", "blitz"}}}
+			g := NewGame(ctx, "test", storage.NewMemory(), engine, &yamlbook.Book{}, nil, opts, nil)
+			g.initialFEN, g.perf = c.fen, c.speed
+
+			// act
+			got := g.endgameTechnique(ctx, api.State{}, fen.FENtoBoard(c.fen), c.bestMove, time.Minute)
+
+			// assert
+			if got != c.want {
+				t.Errorf("got %s, want %s", got, c.want)
+			}
+		})
+	}
+}
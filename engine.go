@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"trollfish-lichess/eventbus"
+)
+
+// Engine serializes UCI commands to the engine and correlates its replies. Every 'go' starts
+// a Search and the engine answers each 'go' with exactly one 'bestmove', in order, so bestmove
+// lines are matched to searches first-in first-out and can't be attributed to the wrong search.
+// 'isready' is matched to 'readyok' the same way.
+type Engine struct {
+	ctx    context.Context
+	input  chan<- string
+	output <-chan string
+
+	mtx      sync.Mutex
+	searchID int
+	searches []*Search
+	ready    []chan struct{}
+	idle     []chan struct{} // closed when the last search gets its bestmove
+	bus      *eventbus.Bus   // EngineLine events, see SetBus
+
+	lastOutput time.Time
+}
+
+// maxSearchInfo is the number of 'info ... score' lines kept per search, most recent last.
+const maxSearchInfo = 200
+
+// Search is a 'go' command waiting for its 'bestmove'.
+type Search struct {
+	ID       int
+	Position string
+	Go       string
+	Started  time.Time
+
+	info   []string
+	hints  []Hint
+	result chan BestMove
+}
+
+// BestMove is the engine's reply to a search. Eval is trollfish's eval from white's pov, e.g.
+// "0.35" or "M5".
+type BestMove struct {
+	SearchID int
+	Move     string
+	Ponder   string
+	Eval     string
+	Info     []string
+	Hints    []Hint // 'info string hint' lines sent during the search
+	Elapsed  time.Duration
+}
+
+// NewEngine starts reading output. It must be the only reader of output.
+func NewEngine(ctx context.Context, input chan<- string, output <-chan string) *Engine {
+	e := Engine{
+		ctx:    ctx,
+		input:  input,
+		output: output,
+	}
+
+	go e.readLoop()
+
+	return &e
+}
+
+// SetBus publishes the running search's 'info ... score' lines on bus.
+func (e *Engine) SetBus(bus *eventbus.Bus) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	e.bus = bus
+}
+
+func (e *Engine) readLoop() {
+	for {
+		select {
+		case <-e.ctx.Done():
+			return
+		case line, ok := <-e.output:
+			if !ok {
+				return
+			}
+			e.mtx.Lock()
+			e.lastOutput = time.Now()
+			e.mtx.Unlock()
+			e.handle(line)
+		}
+	}
+}
+
+func (e *Engine) handle(line string) {
+	switch {
+	case strings.HasPrefix(line, "bestmove"):
+		bestMove := parseBestMove(line)
+
+		e.mtx.Lock()
+		if len(e.searches) == 0 {
+			e.mtx.Unlock()
+			fmt.Printf("%s *** ERR: '%s' without a search\n", ts(), line)
+			return
+		}
+		search := e.searches[0]
+		e.searches = e.searches[1:]
+		if len(e.searches) == 0 {
+			for _, idle := range e.idle {
+				close(idle)
+			}
+			e.idle = nil
+		}
+		e.mtx.Unlock()
+
+		bestMove.SearchID = search.ID
+		bestMove.Info = search.info
+		bestMove.Hints = search.hints
+		bestMove.Elapsed = time.Since(search.Started)
+		search.result <- bestMove // buffered, never blocks
+	case strings.HasPrefix(line, "info") && strings.Contains(line, " score "):
+		e.mtx.Lock()
+		bus, searchID := e.bus, 0
+		if len(e.searches) > 0 {
+			// the oldest search without a bestmove is the one running
+			search := e.searches[0]
+			search.info = append(search.info, line)
+			if len(search.info) > maxSearchInfo {
+				search.info = search.info[len(search.info)-maxSearchInfo:]
+			}
+			searchID = search.ID
+		}
+		e.mtx.Unlock()
+		if searchID != 0 {
+			eventbus.Publish(bus, EngineLine{SearchID: searchID, Line: line})
+		}
+	case strings.HasPrefix(line, hintPrefix):
+		hint, ok := parseHint(line)
+		if !ok {
+			return
+		}
+		e.mtx.Lock()
+		if len(e.searches) > 0 {
+			search := e.searches[0]
+			search.hints = append(search.hints, hint)
+		}
+		e.mtx.Unlock()
+	case line == "readyok":
+		e.mtx.Lock()
+		if len(e.ready) == 0 {
+			e.mtx.Unlock()
+			return
+		}
+		ready := e.ready[0]
+		e.ready = e.ready[1:]
+		e.mtx.Unlock()
+
+		close(ready)
+	}
+}
+
+// Send writes a command which has no reply, e.g. setoption, ucinewgame, stop or ponderhit.
+func (e *Engine) Send(cmds ...string) error {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	return e.send(cmds...)
+}
+
+func (e *Engine) send(cmds ...string) error {
+	for _, cmd := range cmds {
+		select {
+		case e.input <- cmd:
+		case <-e.ctx.Done():
+			return e.ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Go sends position and goCmd and returns the Search to wait on.
+func (e *Engine) Go(position, goCmd string) (*Search, error) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	e.searchID++
+	search := Search{
+		ID:       e.searchID,
+		Position: position,
+		Go:       goCmd,
+		Started:  time.Now(),
+		result:   make(chan BestMove, 1),
+	}
+
+	// the search is queued before 'go' is written so its bestmove can't arrive first
+	e.searches = append(e.searches, &search)
+	if err := e.send(position, goCmd); err != nil {
+		e.searches = e.searches[:len(e.searches)-1]
+		return nil, err
+	}
+
+	return &search, nil
+}
+
+// LastOutput returns when the engine last wrote a line, zero if it hasn't.
+func (e *Engine) LastOutput() time.Time {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	return e.lastOutput
+}
+
+// Reset forgets the searches and isready commands waiting for a reply, after the engine
+// process was restarted and they'll never get one. Their waits time out; Drain returns.
+func (e *Engine) Reset() {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	e.searches = nil
+	e.ready = nil
+	for _, idle := range e.idle {
+		close(idle)
+	}
+	e.idle = nil
+}
+
+// PendingSearches returns the number of searches still waiting for a bestmove.
+func (e *Engine) PendingSearches() int {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	return len(e.searches)
+}
+
+// WaitReady sends 'isready' and waits for 'readyok'.
+func (e *Engine) WaitReady(ctx context.Context, timeout time.Duration) error {
+	e.mtx.Lock()
+	ready := make(chan struct{})
+	e.ready = append(e.ready, ready)
+	err := e.send("isready")
+	e.mtx.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return errEngineTimeout
+	}
+}
+
+// Drain stops any searches still running, waits for their bestmoves and then for 'readyok',
+// so the next 'go' can't be answered by an old search. A game that ends while a search is in
+// flight (e.g. gameFinish arriving mid-move) leaves the engine busy until it's drained.
+func (e *Engine) Drain(ctx context.Context, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	e.mtx.Lock()
+	var idle chan struct{}
+	pending := len(e.searches)
+	if pending > 0 {
+		idle = make(chan struct{})
+		e.idle = append(e.idle, idle)
+		if err := e.send("stop"); err != nil {
+			e.mtx.Unlock()
+			return err
+		}
+	}
+	e.mtx.Unlock()
+
+	if idle != nil {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+
+		select {
+		case <-idle:
+			fmt.Printf("%s drained %d search(es)\n", ts(), pending)
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			return fmt.Errorf("%d search(es) still running: %w", e.PendingSearches(), errEngineTimeout)
+		}
+	}
+
+	return e.WaitReady(ctx, time.Until(deadline))
+}
+
+// Wait returns the search's bestmove. Giving up early is safe; a late bestmove is discarded
+// with its search.
+func (s *Search) Wait(ctx context.Context, timeout time.Duration) (BestMove, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case bestMove := <-s.result:
+		return bestMove, nil
+	case <-ctx.Done():
+		return BestMove{}, ctx.Err()
+	case <-timer.C:
+		return BestMove{}, errEngineTimeout
+	}
+}
+
+func parseBestMove(line string) BestMove {
+	var bestMove BestMove
+
+	p := strings.Split(line, " ")
+	if len(p) > 1 {
+		bestMove.Move = p[1]
+	}
+	for i := 2; i < len(p)-1; i++ {
+		switch p[i] {
+		case "ponder":
+			bestMove.Ponder = p[i+1]
+		case "eval":
+			bestMove.Eval = p[i+1]
+		}
+	}
+
+	return bestMove
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestEngine_BestMoveOrder(t *testing.T) {
+	// arrange
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	input := make(chan string, 16)
+	output := make(chan string)
+	e := NewEngine(ctx, input, output)
+
+	ponder, err := e.Go("position startpos moves e2e4", "go ponder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	search, err := e.Go("position startpos moves e2e4 c7c5", "go wtime 1000 btime 1000")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	output <- "info depth 1 score cp 20"
+	output <- "bestmove c7c5 ponder g1f3 eval 0.20"
+	output <- "info string hint resign"
+	output <- "bestmove g1f3 ponder d7d6 eval 0.35"
+
+	// assert
+	got, err := search.Wait(ctx, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SearchID != search.ID || got.Move != "g1f3" || got.Ponder != "d7d6" || got.Eval != "0.35" {
+		t.Errorf("search: got %+v", got)
+	}
+	if len(got.Hints) != 1 || got.Hints[0].Name != "resign" {
+		t.Errorf("search hints: got %+v", got.Hints)
+	}
+
+	got, err = ponder.Wait(ctx, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SearchID != ponder.ID || got.Move != "c7c5" {
+		t.Errorf("ponder: got %+v", got)
+	}
+
+	if n := e.PendingSearches(); n != 0 {
+		t.Errorf("pending searches: got %d, want 0", n)
+	}
+}
+
+func TestEngine_WaitReady(t *testing.T) {
+	// arrange
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	input := make(chan string, 16)
+	output := make(chan string)
+	e := NewEngine(ctx, input, output)
+
+	go func() {
+		for cmd := range input {
+			if cmd == "isready" {
+				output <- "readyok"
+			}
+		}
+	}()
+
+	// act
+	err := e.WaitReady(ctx, time.Second)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSearch_WaitTimeout(t *testing.T) {
+	// arrange
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	input := make(chan string, 16)
+	output := make(chan string)
+	e := NewEngine(ctx, input, output)
+
+	search, err := e.Go("position startpos", "go infinite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	_, err = search.Wait(ctx, 10*time.Millisecond)
+
+	// assert
+	if err != errEngineTimeout {
+		t.Errorf("got %v, want %v", err, errEngineTimeout)
+	}
+}
+
+func TestEngine_Drain(t *testing.T) {
+	// arrange
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	input := make(chan string, 16)
+	output := make(chan string)
+	e := NewEngine(ctx, input, output)
+
+	go func() {
+		for cmd := range input {
+			switch cmd {
+			case "stop":
+				output <- "bestmove e2e4"
+			case "isready":
+				output <- "readyok"
+			}
+		}
+	}()
+
+	// a search abandoned mid-move, e.g. when gameFinish arrives
+	if _, err := e.Go("position startpos", "go wtime 1000 btime 1000"); err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	err := e.Drain(ctx, time.Second)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := e.PendingSearches(); n != 0 {
+		t.Errorf("pending searches: got %d, want 0", n)
+	}
+}
@@ -1,10 +1,8 @@
 package epd
 
 import (
-	"bytes"
 	"context"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
@@ -13,8 +11,6 @@ import (
 	"strings"
 	"time"
 
-	"gopkg.in/yaml.v3"
-
 	"trollfish-lichess/analyze"
 	"trollfish-lichess/fen"
 	"trollfish-lichess/polyglot"
@@ -92,7 +88,7 @@ func (f *File) save(filename string, backup bool, data string) error {
 			return fmt.Errorf("error creating backup file '%s': %v", backupFilename, err)
 		}
 	}
-	if err := ioutil.WriteFile(filename, []byte(data), 0644); err != nil {
+	if err := os.WriteFile(filename, []byte(data), 0644); err != nil {
 		return fmt.Errorf("write file '%s': %v", filename, err)
 	}
 	return nil
@@ -107,8 +103,8 @@ func (f *File) String() string {
 	return sb.String()
 }
 
-func (f *File) AsYAMLBook() yamlbook.Book {
-	var book yamlbook.Book
+func (f *File) AsYAMLBook() *yamlbook.Book {
+	book := &yamlbook.Book{}
 	posMap := make(map[string]*yamlbook.Position)
 	for _, line := range f.Lines {
 		pv := line.GetString("pv")
@@ -116,20 +112,21 @@ func (f *File) AsYAMLBook() yamlbook.Book {
 			pv = line.BestMove()
 		}
 
-		white := strings.Contains(line.FEN, " w ")
-		povMultiplier := iif(white, 1, -1) // we want to turn cp and mate back to their pov representation
-		cp := line.CE() * povMultiplier
-		mate := line.DM() * povMultiplier
+		// EPD evals are white's, book evals the side to move's
+		score := fen.Global(line.CE(), line.DM()).For(fen.FENtoBoard(line.FEN).ActiveColor)
+		cp, mate := score.CP, score.Mate
 
+		now := time.Now().Unix()
 		move := &yamlbook.Move{
-			Move: line.BestMove(),
-			CP:   cp,
-			Mate: mate,
-			TS:   time.Now().Unix(),
+			Move:   line.BestMove(),
+			CP:     cp,
+			Mate:   mate,
+			TS:     now,
+			Source: &yamlbook.Source{Type: yamlbook.SourceEngine, AddedBy: "epd", Date: now},
 			Engine: &yamlbook.Engine{
 				ID: "sf15",
 				Output: []*yamlbook.EngineOutput{{
-					yamlbook.LogLine{
+					Line: yamlbook.LogLine{
 						Depth: line.ACD(),
 						Nodes: line.GetInt("acn"),
 						CP:    cp,
@@ -163,13 +160,12 @@ func (f *File) AsYAMLBook() yamlbook.Book {
 func (f *File) YAML() string {
 	book := f.AsYAMLBook()
 
-	var buf bytes.Buffer
-	enc := yaml.NewEncoder(&buf)
-	if err := enc.Encode(book.Positions); err != nil {
+	b, err := yamlbook.Encode(book.Positions)
+	if err != nil {
 		log.Fatal(err)
 	}
 
-	return buf.String()
+	return string(b)
 }
 
 type LineItem struct {
@@ -334,7 +330,7 @@ func (op Operation) atoi() int {
 }
 
 func LoadFile(filename string) (*File, error) {
-	b, err := ioutil.ReadFile(filename)
+	b, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, fmt.Errorf("file '%s': %v", filename, err)
 	}
@@ -575,11 +571,12 @@ func UpdateFile(ctx context.Context, filename string, opts analyze.AnalysisOptio
 		item.SetInt(OpCodeAnalysisCountNodes, bestMove.Nodes)
 		item.SetInt(OpCodeAnalysisCountSeconds, bestMove.Time/1000)
 
-		if bestMove.Mate == 0 {
-			item.SetInt(OpCodeCentipawnEvaluation, bestMove.GlobalCP(board.ActiveColor))
+		score := bestMove.POV(board.ActiveColor).Global()
+		if score.Mate == 0 {
+			item.SetInt(OpCodeCentipawnEvaluation, score.CP)
 			item.Remove(OpCodeDirectMate)
 		} else {
-			item.SetInt(OpCodeDirectMate, bestMove.GlobalMate(board.ActiveColor))
+			item.SetInt(OpCodeDirectMate, score.Mate)
 			item.Remove(OpCodeCentipawnEvaluation)
 		}
 
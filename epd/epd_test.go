@@ -0,0 +1,25 @@
+package epd
+
+import (
+	"testing"
+
+	"trollfish-lichess/internal/benchdata"
+)
+
+func BenchmarkFile_Contains(b *testing.B) {
+	// every other position is in the file, the misses scan all of it
+	boards := benchdata.Boards(benchdata.Positions)
+	file := New()
+	fens := make([]string, 0, len(boards))
+	for i, board := range boards {
+		if i%2 == 0 {
+			file.Add(board.FENKey())
+		}
+		fens = append(fens, board.FEN())
+	}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_ = file.Contains(fens[i%len(fens)])
+	}
+}
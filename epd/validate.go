@@ -0,0 +1,120 @@
+package epd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"trollfish-lichess/fen"
+)
+
+// Validate checks every line of the EPD file filename without parsing it the way LoadFile
+// does, which panics on a bad FEN. It returns a description of each invalid line: FENs that
+// aren't a legal position (see fen.ValidFEN), bm and sm moves that aren't legal SAN in the
+// position, and dm from the wrong side's pov, e.g. a mating bm without dm 1 for white.
+//
+// With quarantine the invalid lines are moved to <name>.quarantine.epd, the file is backed
+// up first.
+func Validate(filename string, quarantine bool) ([]string, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("file '%s': %v", filename, err)
+	}
+
+	var (
+		report    []string
+		kept, bad File
+	)
+	lines := strings.Split(string(b), "\n")
+	for i, raw := range lines {
+		line := strings.TrimSpace(raw)
+		// skip the last empty line
+		if len(line) == 0 && i == len(lines)-1 {
+			break
+		}
+
+		item := &LineItem{RawText: line}
+		if problem := validateLine(line); problem != "" {
+			report = append(report, fmt.Sprintf("line %d: %s", i+1, problem))
+			bad.Lines = append(bad.Lines, item)
+			continue
+		}
+		kept.Lines = append(kept.Lines, item)
+	}
+
+	if !quarantine || len(bad.Lines) == 0 {
+		return report, nil
+	}
+
+	quarantineFilename := strings.TrimSuffix(filename, filepath.Ext(filename)) + ".quarantine.epd"
+	if err := bad.Save(quarantineFilename, false); err != nil {
+		return nil, err
+	}
+	if err := kept.Save(filename, true); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// validateLine returns what's wrong with an EPD line, or "" if nothing is. Blank lines
+// and lines without a FEN, like comments, are fine.
+func validateLine(text string) string {
+	fields := strings.Fields(text)
+	if len(fields) < 4 {
+		if len(fields) != 0 && strings.Count(fields[0], "/") == 7 {
+			return fmt.Sprintf("'%s': want 4 FEN fields", text)
+		}
+		return ""
+	}
+
+	fenText := strings.Join(fields[:3], " ") + " " + strings.TrimRight(fields[3], ";")
+	if err := fen.ValidFEN(fenText); err != nil {
+		return err.Error()
+	}
+
+	line := LineItem{RawText: text}
+	line.parseRawText()
+	board := fen.FENtoBoard(line.FEN)
+
+	var mates bool
+	for _, opCode := range []string{OpCodeBestMove, OpCodeSuppliedMove} {
+		for _, san := range strings.Fields(line.GetString(opCode)) {
+			uci, err := board.SANtoUCI(san)
+			if err != nil {
+				return fmt.Sprintf("%s '%s' isn't a legal move in '%s'", opCode, san, line.FEN)
+			}
+			if opCode == OpCodeBestMove {
+				next := board
+				if err := next.MovesStrict(uci); err != nil {
+					return fmt.Sprintf("%s '%s': %v", opCode, san, err)
+				}
+				mates = mates || next.IsMate()
+			}
+		}
+	}
+
+	dm := line.GetString(OpCodeDirectMate)
+	if dm == "" {
+		if mates && line.GetString(OpCodeCentipawnEvaluation) != "" {
+			return fmt.Sprintf("bm '%s' mates, but it has ce and no dm", line.BestMove())
+		}
+		return ""
+	}
+	n, err := strconv.Atoi(dm)
+	if err != nil {
+		return fmt.Sprintf("dm '%s' isn't a number", dm)
+	}
+
+	// dm is white's pov
+	mateIn1 := fen.POV(0, 1, board.ActiveColor).Global().Mate
+	switch {
+	case mates && n != mateIn1:
+		return fmt.Sprintf("bm '%s' mates, but dm is %d, want %d", line.BestMove(), n, mateIn1)
+	case !mates && n == mateIn1 && line.BestMove() != "":
+		return fmt.Sprintf("dm %d, but bm '%s' doesn't mate", n, line.BestMove())
+	}
+	return ""
+}
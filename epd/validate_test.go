@@ -0,0 +1,111 @@
+package epd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateLine(t *testing.T) {
+	const scholar = "r1bqkbnr/pppp1ppp/2n5/4p2Q/2B1P3/8/PPPP1PPP/RNB1K1NR w KQkq -"
+	const blackMates = "rnbqkbnr/pppp1ppp/8/4p3/6P1/5P2/PPPPP2P/RNBQKBNR b KQkq -"
+
+	cases := []struct {
+		name string
+		line string
+		want string // a part of the problem, "" for none
+	}{
+		{name: "blank", line: ""},
+		{name: "comment", line: "# openings"},
+		{name: "bm", line: "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - bm e4; ce 30;"},
+		{name: "no ops", line: "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq -"},
+		{name: "3 FEN fields", line: "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq", want: "want 4 FEN fields"},
+		{name: "no white king", line: "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQ1BNR w kq - bm e4;", want: "king"},
+		{name: "illegal bm", line: "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - bm e5;", want: "bm 'e5' isn't a legal move"},
+		{name: "illegal sm", line: "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - sm Nf6;", want: "sm 'Nf6' isn't a legal move"},
+		{name: "white mates", line: scholar + " bm Qxf7#; dm 1;"},
+		{name: "black mates", line: blackMates + " bm Qh4#; dm -1;"},
+		{name: "white mates, black's dm", line: scholar + " bm Qxf7#; dm -1;", want: "dm is -1, want 1"},
+		{name: "black mates, white's dm", line: blackMates + " bm Qh4#; dm 1;", want: "dm is 1, want -1"},
+		{name: "mate with ce", line: scholar + " bm Qxf7#; ce 500;", want: "it has ce and no dm"},
+		{name: "dm 1 without mate", line: scholar + " bm Bxf7+; dm 1;", want: "doesn't mate"},
+		{name: "dm not a number", line: scholar + " bm Qxf7#; dm one;", want: "isn't a number"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// act
+			got := validateLine(c.line)
+
+			// assert
+			if (got == "") != (c.want == "") || !strings.Contains(got, c.want) {
+				t.Errorf("got '%s', want '%s'", got, c.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name       string
+		quarantine bool
+		wantKept   int
+	}{
+		{name: "report only", wantKept: 4},
+		{name: "quarantine", quarantine: true, wantKept: 2},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			dir := t.TempDir()
+			filename := filepath.Join(dir, "test.epd")
+			lines := []string{
+				"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - bm e4;",
+				"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - bm e5;",
+				"rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq - bm Nf3;",
+				"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq",
+			}
+			if err := os.WriteFile(filename, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			// act
+			report, err := Validate(filename, c.quarantine)
+
+			// assert
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(report) != 2 || !strings.HasPrefix(report[0], "line 2: ") || !strings.HasPrefix(report[1], "line 4: ") {
+				t.Errorf("report: got %q, want lines 2 and 4", report)
+			}
+			kept := readLines(t, filename)
+			if len(kept) != c.wantKept {
+				t.Errorf("kept: got %d lines, want %d", len(kept), c.wantKept)
+			}
+			quarantined := readLines(t, filepath.Join(dir, "test.quarantine.epd"))
+			if c.quarantine && (len(quarantined) != 2 || quarantined[0] != lines[1] || quarantined[1] != lines[3]) {
+				t.Errorf("quarantine: got %q, want lines 2 and 4", quarantined)
+			}
+			if !c.quarantine && quarantined != nil {
+				t.Errorf("quarantine: got %q without -quarantine", quarantined)
+			}
+		})
+	}
+}
+
+// readLines returns the lines of filename, nil if it doesn't exist.
+func readLines(t *testing.T, filename string) []string {
+	t.Helper()
+
+	b, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(b)), "\n")
+}
@@ -0,0 +1,137 @@
+// Package eventbus is an in-process publish/subscribe bus for typed events, so components
+// like the admin server, an audit log or a notifier can follow what the bot does without the
+// publishers knowing about them.
+package eventbus
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// Bus delivers published events to the subscribers of their type. Publishing never blocks:
+// an event is dropped for a subscriber whose buffer is full. A nil *Bus is valid and
+// delivers nothing.
+type Bus struct {
+	mtx  sync.RWMutex
+	subs map[reflect.Type][]*subscriber
+	all  []*subscriber
+
+	dropped int64 // atomic
+}
+
+type subscriber struct {
+	send   func(event any) bool // false when the buffer is full
+	close  func()
+	closed bool
+}
+
+// New returns an empty bus.
+func New() *Bus {
+	return &Bus{subs: make(map[reflect.Type][]*subscriber)}
+}
+
+// Subscribe returns a channel receiving the events of type T published on b, buffering up to
+// buffer of them, and a func ending the subscription and closing the channel.
+func Subscribe[T any](b *Bus, buffer int) (<-chan T, func()) {
+	sub, out := newSubscriber[T](buffer)
+	if b == nil {
+		close(out)
+		return out, func() {}
+	}
+	key := typeOf[T]()
+
+	b.mtx.Lock()
+	b.subs[key] = append(b.subs[key], sub)
+	b.mtx.Unlock()
+
+	return out, func() { b.unsubscribe(sub, key) }
+}
+
+// SubscribeAll returns a channel receiving every event published on b, e.g. to log or
+// forward them, and a func ending the subscription.
+func (b *Bus) SubscribeAll(buffer int) (<-chan any, func()) {
+	sub, out := newSubscriber[any](buffer)
+	if b == nil {
+		close(out)
+		return out, func() {}
+	}
+
+	b.mtx.Lock()
+	b.all = append(b.all, sub)
+	b.mtx.Unlock()
+
+	return out, func() { b.unsubscribe(sub, nil) }
+}
+
+func newSubscriber[T any](buffer int) (*subscriber, chan T) {
+	out := make(chan T, buffer)
+	sub := &subscriber{
+		send: func(event any) bool {
+			select {
+			case out <- event.(T):
+				return true
+			default:
+				return false
+			}
+		},
+		close: func() { close(out) },
+	}
+	return sub, out
+}
+
+func (b *Bus) unsubscribe(sub *subscriber, key reflect.Type) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	sub.close()
+
+	if key == nil {
+		b.all = remove(b.all, sub)
+		return
+	}
+	b.subs[key] = remove(b.subs[key], sub)
+}
+
+// Publish sends event to the subscribers of its type and of every event.
+func Publish[T any](b *Bus, event T) {
+	if b == nil {
+		return
+	}
+
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+
+	for _, subs := range [][]*subscriber{b.subs[typeOf[T]()], b.all} {
+		for _, sub := range subs {
+			if !sub.send(event) {
+				atomic.AddInt64(&b.dropped, 1)
+			}
+		}
+	}
+}
+
+// Dropped returns how many events didn't fit a subscriber's buffer.
+func (b *Bus) Dropped() int64 {
+	if b == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&b.dropped)
+}
+
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+func remove(subs []*subscriber, sub *subscriber) []*subscriber {
+	for i, s := range subs {
+		if s == sub {
+			return append(subs[:i:i], subs[i+1:]...)
+		}
+	}
+	return subs
+}
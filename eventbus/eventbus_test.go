@@ -0,0 +1,65 @@
+package eventbus
+
+import (
+	"testing"
+)
+
+type started struct{ ID string }
+type finished struct{ ID string }
+
+func TestBus(t *testing.T) {
+	// arrange
+	b := New()
+	starts, stopStarts := Subscribe[started](b, 1)
+	finishes, stopFinishes := Subscribe[finished](b, 4)
+	all, stopAll := b.SubscribeAll(4)
+	defer stopFinishes()
+	defer stopAll()
+
+	// act
+	Publish(b, started{ID: "a"})
+	Publish(b, started{ID: "b"}) // starts is full
+	Publish(b, finished{ID: "a"})
+	stopStarts()
+	stopStarts()
+	Publish(b, started{ID: "c"})
+
+	// assert
+	if got := <-starts; got.ID != "a" {
+		t.Errorf("starts: got %v, want a", got)
+	}
+	if _, ok := <-starts; ok {
+		t.Error("starts: got an event, want the channel closed")
+	}
+	if got := <-finishes; got.ID != "a" {
+		t.Errorf("finishes: got %v, want a", got)
+	}
+	var events []any
+	for len(all) > 0 {
+		events = append(events, <-all)
+	}
+	if len(events) != 4 || events[2] != (finished{ID: "a"}) || events[3] != (started{ID: "c"}) {
+		t.Errorf("all: got %v, want 4 events in order", events)
+	}
+	if b.Dropped() != 1 {
+		t.Errorf("dropped: got %d, want 1", b.Dropped())
+	}
+}
+
+func TestBus_Nil(t *testing.T) {
+	// arrange
+	var b *Bus
+
+	// act
+	events, stop := Subscribe[started](b, 1)
+	Publish(b, started{ID: "a"})
+	stop()
+
+	// assert
+	if _, ok := <-events; ok {
+		t.Error("got an event from a nil bus")
+	}
+	if b.Dropped() != 0 {
+		t.Errorf("dropped: got %d, want 0", b.Dropped())
+	}
+}
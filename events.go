@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"time"
+
+	"trollfish-lichess/api"
+	"trollfish-lichess/eventbus"
+)
+
+// The events published on GameOptions.Bus. Subscribers get them with eventbus.Subscribe, or
+// all of them with Bus.SubscribeAll, e.g. GET /events on the admin server; publishing never
+// waits for them.
+
+// ChallengeReceived is a challenge someone sent us, before we decide on it.
+type ChallengeReceived struct {
+	Challenge api.Challenge
+}
+
+// ChallengeDeclined is a challenge we declined.
+type ChallengeDeclined struct {
+	ID     string
+	User   string
+	Reason string // lichess' decline reason key, e.g. "tooSlow"
+}
+
+// ChallengeAccepted is a queued challenge we accepted.
+type ChallengeAccepted struct {
+	ID   string
+	User string
+}
+
+// GameStarted is a game lichess started for us.
+type GameStarted struct {
+	Game api.GameEventInfo
+}
+
+// GameFinished is the end of the game we were playing.
+type GameFinished struct {
+	GameID string
+}
+
+// BookDecided is the book move found for our move; it's played unless it repeats a position
+// or the book check rejects it.
+type BookDecided struct {
+	GameID   string
+	Ply      int
+	Decision BookDecision
+}
+
+// MoveSent is a move we sent to lichess.
+type MoveSent struct {
+	GameID    string
+	Ply       int
+	Move      string // uci
+	Source    string // "book" or "engine", as in the move audit
+	OfferDraw bool
+}
+
+// EngineLine is an 'info ... score' line of the engine's running search.
+type EngineLine struct {
+	SearchID int
+	Line     string
+}
+
+// eventsBuffer is how many events an admin /events stream holds for a slow reader.
+const eventsBuffer = 256
+
+// handleEvents streams every event on the bus as ndjson until the client goes away.
+func (s *adminServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	serveEvents(w, r, s.l.gameOpts.Bus)
+}
+
+// serveEvents streams every event on bus as ndjson until the client goes away.
+func serveEvents(w http.ResponseWriter, r *http.Request, bus *eventbus.Bus) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET", http.StatusMethodNotAllowed)
+		return
+	}
+	if bus == nil {
+		http.Error(w, "no event bus", http.StatusServiceUnavailable)
+		return
+	}
+
+	events, stop := bus.SubscribeAll(eventsBuffer)
+	defer stop()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	streamEvents(r.Context(), events, json.NewEncoder(w), flusher)
+}
+
+// eventLine is an event as streamed by handleEvents.
+type eventLine struct {
+	Type  string `json:"type"`
+	TS    int64  `json:"ts"` // unix ms
+	Event any    `json:"event"`
+}
+
+func streamEvents(ctx context.Context, events <-chan any, enc *json.Encoder, flusher http.Flusher) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			line := eventLine{Type: reflect.TypeOf(event).Name(), TS: time.Now().UnixMilli(), Event: event}
+			if err := enc.Encode(line); err != nil {
+				fmt.Printf("%s ERR: admin events: %v\n", ts(), err)
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+
+	"trollfish-lichess/history"
+	"trollfish-lichess/yamlbook"
+)
+
+// Experiment alternates two engine and book configurations across games, tagging each game's
+// history record with its arm, so -experiment-report can compare them. It's YAML:
+//
+//	name: start-agro
+//	arms:
+//	  - {name: agro, options: {StartAgro: "true"}}
+//	  - {name: calm, options: {StartAgro: "false"}, book: calm.yamlbook}
+//
+// Both arms set the same engine options, so a game doesn't inherit the last game's.
+type Experiment struct {
+	Name string          `yaml:"name"`
+	Arms []ExperimentArm `yaml:"arms"`
+
+	mtx    sync.Mutex
+	played []int // games per arm, from the history database and this session
+	last   int   // the last arm played
+}
+
+type ExperimentArm struct {
+	Name    string            `yaml:"name"`
+	Options map[string]string `yaml:"options"` // UCI option name -> value
+	Book    string            `yaml:"book"`    // YAML book played instead of the bot's, optional
+
+	book *yamlbook.Book
+}
+
+// LoadExperiment reads an experiment and its arms' books.
+func LoadExperiment(filename string) (*Experiment, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var e Experiment
+	if err := yaml.Unmarshal(b, &e); err != nil {
+		return nil, fmt.Errorf("'%s': %v", filename, err)
+	}
+	if err := e.validate(); err != nil {
+		return nil, fmt.Errorf("'%s': %v", filename, err)
+	}
+
+	for i := range e.Arms {
+		arm := &e.Arms[i]
+		if arm.Book == "" {
+			continue
+		}
+		if arm.book, err = yamlbook.Load(arm.Book); err != nil {
+			return nil, fmt.Errorf("'%s' arm '%s': %v", filename, arm.Name, err)
+		}
+	}
+
+	e.played = make([]int, len(e.Arms))
+	e.last = -1
+	return &e, nil
+}
+
+func (e *Experiment) validate() error {
+	if e.Name == "" {
+		return fmt.Errorf("experiment needs a name")
+	}
+	if len(e.Arms) != 2 {
+		return fmt.Errorf("experiment '%s': want 2 arms, got %d", e.Name, len(e.Arms))
+	}
+	if e.Arms[0].Name == "" || e.Arms[1].Name == "" || e.Arms[0].Name == e.Arms[1].Name {
+		return fmt.Errorf("experiment '%s': arms need different names", e.Name)
+	}
+	for name := range e.Arms[0].Options {
+		if _, ok := e.Arms[1].Options[name]; !ok {
+			return fmt.Errorf("experiment '%s': option '%s' is only set by arm '%s'", e.Name, name, e.Arms[0].Name)
+		}
+	}
+	for name := range e.Arms[1].Options {
+		if _, ok := e.Arms[0].Options[name]; !ok {
+			return fmt.Errorf("experiment '%s': option '%s' is only set by arm '%s'", e.Name, name, e.Arms[1].Name)
+		}
+	}
+	return nil
+}
+
+func (e *Experiment) armNames() []string {
+	names := make([]string, len(e.Arms))
+	for i, arm := range e.Arms {
+		names[i] = arm.Name
+	}
+	return names
+}
+
+// Count adds the experiment's games already in the history database, so the arms stay balanced
+// across restarts.
+func (e *Experiment) Count(games []history.Game) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	for _, game := range games {
+		if game.Experiment != e.Name {
+			continue
+		}
+		for i, arm := range e.Arms {
+			if game.Arm == arm.Name {
+				e.played[i]++
+			}
+		}
+	}
+}
+
+// Next returns the arm for the next game: the one with fewer games, or the other one than
+// last time when they're even.
+func (e *Experiment) Next() *ExperimentArm {
+	if e == nil {
+		return nil
+	}
+
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	next := 0
+	switch {
+	case e.played[1] < e.played[0]:
+		next = 1
+	case e.played[1] == e.played[0] && e.last == 0:
+		next = 1
+	}
+	e.played[next]++
+	e.last = next
+	return &e.Arms[next]
+}
+
+// SetOptions returns the arm's setoption commands, sorted by option name.
+func (a *ExperimentArm) SetOptions() []string {
+	names := make([]string, 0, len(a.Options))
+	for name := range a.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	cmds := make([]string, len(names))
+	for i, name := range names {
+		cmds[i] = fmt.Sprintf("setoption name %s value %s", name, a.Options[name])
+	}
+	return cmds
+}
+
+// PrintExperimentReport prints each arm's score and ACPL from the history database and
+// whether the difference between them is significant.
+func PrintExperimentReport(db *history.DB, e *Experiment) error {
+	games, err := db.Games()
+	if err != nil {
+		return err
+	}
+
+	stats := history.ExperimentStats(games, e.Name, e.armNames())
+	fmt.Printf("experiment %s\n", e.Name)
+	for _, s := range stats {
+		fmt.Printf("  %-16s %4d games  +%d =%d -%d  score %.3f ± %.3f", s.Arm, s.Games, s.Wins, s.Draws, s.Losses, s.Score, s.ScoreCI())
+		if s.ACPLGames != 0 {
+			fmt.Printf("  acpl %.1f ± %.1f (%d games)", s.ACPL, s.ACPLCI(), s.ACPLGames)
+		}
+		fmt.Println()
+	}
+
+	a, b := stats[0], stats[1]
+	if a.Games < 2 || b.Games < 2 {
+		fmt.Println("  not enough games to compare")
+		return nil
+	}
+
+	diff, ci := history.ScoreDiff(a, b)
+	fmt.Printf("  %s - %s: score %+.3f ± %.3f%s\n", b.Arm, a.Arm, diff, ci, significance(diff, ci))
+	if a.ACPLGames >= 2 && b.ACPLGames >= 2 {
+		diff, ci := history.ACPLDiff(a, b)
+		fmt.Printf("  %s - %s: acpl %+.1f ± %.1f%s\n", b.Arm, a.Arm, diff, ci, significance(diff, ci))
+	}
+	return nil
+}
+
+func significance(diff, ci float64) string {
+	if diff-ci > 0 || diff+ci < 0 {
+		return " (significant at 95%)"
+	}
+	return " (not significant)"
+}
+
+// useExperimentArm plays the game with arm's options and book, before its events are streamed.
+func (g *Game) useExperimentArm(arm *ExperimentArm) {
+	if arm == nil {
+		return
+	}
+	g.arm = arm
+	if arm.book != nil {
+		g.book = arm.book
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"trollfish-lichess/history"
+)
+
+func TestExperiment_Next(t *testing.T) {
+	// arrange
+	filename := filepath.Join(t.TempDir(), "agro.yaml")
+	yaml := "name: agro\narms:\n  - {name: on, options: {StartAgro: \"true\", Contempt: \"20\"}}\n  - {name: off, options: {StartAgro: \"false\", Contempt: \"0\"}}\n"
+	if err := os.WriteFile(filename, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := LoadExperiment(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Count([]history.Game{{Experiment: "agro", Arm: "on"}, {Experiment: "other", Arm: "off"}})
+
+	// act
+	var arms []string
+	for i := 0; i < 4; i++ {
+		arms = append(arms, e.Next().Name)
+	}
+
+	// assert
+	if want := []string{"off", "on", "off", "on"}; !reflect.DeepEqual(arms, want) {
+		t.Errorf("got %v, want %v", arms, want)
+	}
+	wantOptions := []string{"setoption name Contempt value 20", "setoption name StartAgro value true"}
+	if got := e.Arms[0].SetOptions(); !reflect.DeepEqual(got, wantOptions) {
+		t.Errorf("options: got %v, want %v", got, wantOptions)
+	}
+}
+
+func TestLoadExperiment_Invalid(t *testing.T) {
+	// arrange
+	filename := filepath.Join(t.TempDir(), "bad.yaml")
+	yaml := "name: agro\narms:\n  - {name: on, options: {StartAgro: \"true\"}}\n  - {name: off}\n"
+	if err := os.WriteFile(filename, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	_, err := LoadExperiment(filename)
+
+	// assert
+	if err == nil {
+		t.Error("option set by one arm only: got no error")
+	}
+}
@@ -0,0 +1,16 @@
+package fen_test
+
+import (
+	"testing"
+
+	"trollfish-lichess/internal/benchdata"
+)
+
+func BenchmarkBoard_FENKey(b *testing.B) {
+	boards := benchdata.Boards(benchdata.Positions)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_ = boards[i%len(boards)].FENKey()
+	}
+}
@@ -195,6 +195,47 @@ func (b Board) FEN() string {
 	return fmt.Sprintf("%s %d %d", b.FENKey(), b.HalfmoveClock, b.FullMove)
 }
 
+// Flip returns the position seen from the other side: the ranks mirrored, the colors of the
+// pieces, castling rights and side to move swapped. The flipped position's evals are the same
+// for the side to move.
+func (b Board) Flip() Board {
+	if b.Pos[0] == 0 {
+		b = FENtoBoard(startPosFEN)
+	}
+
+	var flipped Board
+	for i, c := range b.Pos {
+		if isUpper(c) {
+			c = lower(c)
+		} else if isLower(c) {
+			c = upper(c)
+		}
+		flipped.Pos[flipIndex(i)] = c
+	}
+	flipped.ActiveColor = -b.ActiveColor
+	flipped.Castling = [4]bool{b.Castling[2], b.Castling[3], b.Castling[0], b.Castling[1]}
+	flipped.EnPassantSquare = -1
+	if b.EnPassantSquare != -1 {
+		flipped.EnPassantSquare = flipIndex(b.EnPassantSquare)
+	}
+	flipped.HalfmoveClock = b.HalfmoveClock
+	flipped.FullMove = b.FullMove
+
+	return FENtoBoard(flipped.FEN())
+}
+
+// FlipUCI returns a UCI move on the flipped board, see Board.Flip.
+func FlipUCI(uci string) string {
+	if len(uci) < 4 {
+		return uci
+	}
+	return string([]byte{uci[0], '1' + '8' - uci[1], uci[2], '1' + '8' - uci[3]}) + uci[4:]
+}
+
+func flipIndex(index int) int {
+	return (7-index/8)*8 + index%8
+}
+
 func Key(fen string) string {
 	b := FENtoBoard(fen)
 	return b.FENKey()
@@ -364,18 +405,30 @@ func (b Board) SANtoUCIs(moves ...string) ([]string, error) {
 			return nil, err
 		}
 		uciMoves = append(uciMoves, uci)
-		b.Moves(uci)
+		if err := b.MovesStrict(uci); err != nil {
+			return nil, err
+		}
 	}
 	return uciMoves, nil
 }
 
 func (b Board) SANtoUCI(san string) (string, error) {
+	return b.sanToUCI(san, false)
+}
+
+// SANtoUCILoose is SANtoUCI, which also matches a move with a missing or extra check mark,
+// as some sites' PGNs and people typing moves have them.
+func (b Board) SANtoUCILoose(san string) (string, error) {
+	return b.sanToUCI(san, true)
+}
+
+// sanToUCI is SANtoUCI, or SANtoUCILoose with looseChecks.
+func (b Board) sanToUCI(san string, looseChecks bool) (string, error) {
 	if b.Pos[0] == 0 {
 		b.LoadFEN(startPosFEN)
 	}
 
 	if len(san) < 2 {
-		panic(fmt.Errorf("'%s' is not a valid move in '%s'", san, b.FEN()))
 		return "", fmt.Errorf("'%s' is not a valid move in '%s'", san, b.FEN())
 	}
 
@@ -399,13 +452,11 @@ func (b Board) SANtoUCI(san string) (string, error) {
 		}
 
 		testSAN := b.UCItoSAN(move.UCI)
-		if testSAN == san {
+		if testSAN == san || looseChecks && strings.TrimRight(testSAN, "+#") == strings.TrimRight(san, "+#") {
 			return move.UCI, nil
 		}
 	}
 
-	panic(fmt.Errorf("'%s' is not a valid move in '%s'", san, b.FEN()))
-
 	return "", fmt.Errorf("'%s' is not a valid move in '%s'", san, b.FEN())
 }
 
@@ -570,6 +621,23 @@ func (b *Board) Moves(moves ...string) *Board {
 	return b
 }
 
+// MovesStrict plays moves that can't be trusted, e.g. from a file, stopping with an error at
+// the first that isn't a legal move in the position it's played from. Moves plays whatever
+// it's given, so corrupt data leaves an illegal position, e.g. a piece moved through others.
+func (b *Board) MovesStrict(moves ...string) error {
+	if b.Pos[0] == 0 {
+		b.LoadFEN(startPosFEN)
+	}
+
+	for i, move := range moves {
+		if !b.IsLegal(move) {
+			return fmt.Errorf("move %d '%s' isn't legal in '%s'", i+1, move, b.FEN())
+		}
+		b.Moves(move)
+	}
+	return nil
+}
+
 func FENtoBoard(fen string) Board {
 	var b Board
 	b.LoadFEN(fen)
@@ -582,7 +650,7 @@ func (b *Board) LoadFEN(fen string) {
 	}
 
 	fen = strings.TrimSpace(fen)
-	parts := strings.Split(fen, " ")
+	parts := strings.Fields(fen) // as ValidFEN reads it
 	ranks := strings.Split(parts[0], "/")
 
 	if len(parts) < 6 {
@@ -651,6 +719,75 @@ func (b *Board) LoadFEN(fen string) {
 	}
 }
 
+// ValidFEN returns an error if fen can't be loaded or isn't a legal position to play from:
+// each side needs one king, pawns can't be on the first or last rank and the side not to
+// move can't be in check.
+func ValidFEN(fen string) error {
+	if fen == "" || fen == "startpos" {
+		return nil
+	}
+
+	parts := strings.Fields(fen)
+	if len(parts) < 4 || len(parts) > 6 {
+		return fmt.Errorf("fen '%s': want 4 to 6 fields, got %d", fen, len(parts))
+	}
+
+	ranks := strings.Split(parts[0], "/")
+	if len(ranks) != 8 {
+		return fmt.Errorf("fen '%s': want 8 ranks, got %d", fen, len(ranks))
+	}
+
+	kings := map[byte]int{}
+	for i, rank := range ranks {
+		squares := 0
+		for j := 0; j < len(rank); j++ {
+			c := rank[j]
+			switch {
+			case c >= '1' && c <= '8':
+				squares += int(c - '0')
+				continue
+			case strings.IndexByte("KQRBNPkqrbnp", c) == -1:
+				return fmt.Errorf("fen '%s': invalid piece '%c'", fen, c)
+			case (c == 'P' || c == 'p') && (i == 0 || i == 7):
+				return fmt.Errorf("fen '%s': pawn on rank %d", fen, 8-i)
+			}
+			if c == 'K' || c == 'k' {
+				kings[c]++
+			}
+			squares++
+		}
+		if squares != 8 {
+			return fmt.Errorf("fen '%s': rank %d has %d squares", fen, 8-i, squares)
+		}
+	}
+	if kings['K'] != 1 || kings['k'] != 1 {
+		return fmt.Errorf("fen '%s': each side needs one king", fen)
+	}
+
+	if parts[1] != "w" && parts[1] != "b" {
+		return fmt.Errorf("fen '%s': active color '%s' is invalid", fen, parts[1])
+	}
+	if strings.Trim(parts[2], "KQkq") != "" && parts[2] != "-" {
+		return fmt.Errorf("fen '%s': castling '%s' is invalid", fen, parts[2])
+	}
+	if ep := parts[3]; ep != "-" && (len(ep) != 2 || ep[0] < 'a' || ep[0] > 'h' || (ep[1] != '3' && ep[1] != '6')) {
+		return fmt.Errorf("fen '%s': en passant square '%s' is invalid", fen, ep)
+	}
+	for _, n := range parts[4:] {
+		if _, err := strconv.Atoi(n); err != nil {
+			return fmt.Errorf("fen '%s': '%s' isn't a number", fen, n)
+		}
+	}
+
+	b := FENtoBoard(fen)
+	b.ActiveColor = -b.ActiveColor
+	if b.IsCheck() {
+		return fmt.Errorf("fen '%s': the side not to move is in check", fen)
+	}
+
+	return nil
+}
+
 func uciToIndex(uci string) int {
 	file := int(uci[0]) - 'a'
 	rank := int(uci[1]) - '0' - 1
@@ -897,6 +1034,29 @@ func (b Board) pieceLegalMoves(piece byte) []legalMove {
 	return moves
 }
 
+// IsLegal reports whether uci is a legal move in the position. Castling may be given as the
+// king taking its rook, e.g. e1h1.
+func (b Board) IsLegal(uci string) bool {
+	if len(uci) != 4 && len(uci) != 5 || !isSquare(uci[0:2]) || !isSquare(uci[2:4]) {
+		return false
+	}
+	if b.Pos[0] == 0 {
+		b.LoadFEN(startPosFEN)
+	}
+
+	uci = translateFRCUCI(b.Pos[uciToIndex(uci)], uci)
+	for _, move := range b.AllLegalMoves() {
+		if move.UCI == uci {
+			return true
+		}
+	}
+	return false
+}
+
+func isSquare(s string) bool {
+	return s[0] >= 'a' && s[0] <= 'h' && s[1] >= '1' && s[1] <= '8'
+}
+
 func (b Board) AllLegalMoves() []LegalMove {
 	return b.PieceLegalMoves(0)
 }
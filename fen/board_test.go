@@ -3,6 +3,7 @@ package fen
 import (
 	"bytes"
 	"fmt"
+	"math/rand"
 	"reflect"
 	"sort"
 	"strings"
@@ -509,3 +510,212 @@ func TestBoard_AllLegalMoves(t *testing.T) {
 		})
 	}
 }
+
+func TestValidFEN(t *testing.T) {
+	cases := []struct {
+		fen   string
+		valid bool
+	}{
+		{"startpos", true},
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", true},
+		{"8/8/8/8/8/7p/6N1/R4K1k b - - 1 1", true},
+		{"4k3/8/8/8/8/8/8/4K3 w - -", true},
+		{"4k3/8/8/8/8/8/8/4K3", false},             // missing fields
+		{"4k3/8/8/8/8/8/4K3 w - - 0 1", false},     // 7 ranks
+		{"4k3/8/8/8/8/8/8/4K2 w - - 0 1", false},   // short rank
+		{"4k3/8/8/8/8/8/8/8 w - - 0 1", false},     // no white king
+		{"4k3/8/8/8/8/8/8/3PK3 w - - 0 1", false},  // pawn on first rank
+		{"4k3/8/8/8/8/8/8/4K3 x - - 0 1", false},   // active color
+		{"4k3/8/8/8/8/8/8/4K3 w X - 0 1", false},   // castling
+		{"4k3/8/8/8/8/8/8/4K3 w - e4 0 1", false},  // en passant
+		{"4k3/8/8/8/8/8/8/4R1K1 w - - 0 1", false}, // black in check with white to move
+		{"4k3/8/8/8/8/8/8/4R1K1 b - - 0 1", true},  // black in check to move
+		{"4k3/8/8/8/8/8/8/4K3 w - - x 1", false},   // halfmove clock
+		{"4k3/8/8/8/8/8/8/4Kz2 w - - 0 1", false},  // piece
+	}
+
+	for _, c := range cases {
+		t.Run(c.fen, func(t *testing.T) {
+			// act
+			err := ValidFEN(c.fen)
+
+			// assert
+			if (err == nil) != c.valid {
+				t.Errorf("valid: want %v got error %v", c.valid, err)
+			}
+		})
+	}
+}
+
+func TestBoard_Flip(t *testing.T) {
+	cases := []struct {
+		fen  string
+		want string
+	}{
+		{
+			fen:  "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1",
+			want: "rnbqkbnr/pppp1ppp/8/4p3/8/8/PPPPPPPP/RNBQKBNR w KQkq -",
+		},
+		{
+			fen:  "r3k2r/8/8/3pP3/8/8/8/4K2R w Kq d6 0 2",
+			want: "4k2r/8/8/8/3Pp3/8/8/R3K2R b Qk d3",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.fen, func(t *testing.T) {
+			// act
+			got := FENtoBoard(c.fen).Flip()
+
+			// assert
+			if got.FENKey() != c.want {
+				t.Errorf("want: %s got: %s", c.want, got.FENKey())
+			}
+			if back := got.Flip().FENKey(); back != Key(c.fen) {
+				t.Errorf("flipped back: want %s got %s", Key(c.fen), back)
+			}
+		})
+	}
+}
+
+func TestFlipUCI(t *testing.T) {
+	for uci, want := range map[string]string{"e2e4": "e7e5", "g8f6": "g1f3", "a2a1q": "a7a8q"} {
+		if got := FlipUCI(uci); got != want {
+			t.Errorf("%s: want %s got %s", uci, want, got)
+		}
+	}
+}
+
+func TestBoard_IsLegal(t *testing.T) {
+	cases := []struct {
+		fen  string
+		uci  string
+		want bool
+	}{
+		{fen: startPosFEN, uci: "e2e4", want: true},
+		{fen: startPosFEN, uci: "e2e5"},
+		{fen: startPosFEN, uci: "e7e5"},
+		{fen: startPosFEN, uci: "e3e4"},
+		{fen: startPosFEN, uci: "e2"},
+		{fen: startPosFEN, uci: "i2i4"},
+		{fen: "r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", uci: "e1g1", want: true},
+		{fen: "r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", uci: "e1h1", want: true},
+		{fen: "r3k2r/8/8/8/8/8/8/R3K2R w Qkq - 0 1", uci: "e1g1"},
+		{fen: "8/P6k/8/8/8/8/8/K7 w - - 0 1", uci: "a7a8q", want: true},
+		{fen: "8/P6k/8/8/8/8/8/K7 w - - 0 1", uci: "a7a8"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.fen+" "+c.uci, func(t *testing.T) {
+			// act
+			got := FENtoBoard(c.fen).IsLegal(c.uci)
+
+			// assert
+			if got != c.want {
+				t.Errorf("want: %v got: %v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestBoard_SANRandomGames(t *testing.T) {
+	// random legal games through SAN and back to the same moves and final position, the
+	// golden SAN is TestUCItoSAN's
+	games, maxPlies := 500, 300
+	if testing.Short() {
+		games = 50
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for game := 0; game < games; game++ {
+		board := FENtoBoard(startPosFEN)
+		var ucis, sans []string
+		for ply := 0; ply < maxPlies; ply++ {
+			moves := board.AllLegalMoves()
+			if len(moves) == 0 || board.HalfmoveClock >= 100 {
+				break
+			}
+			uci := moves[r.Intn(len(moves))].UCI
+
+			san := board.UCItoSAN(uci)
+			if got, err := board.SANtoUCI(san); err != nil || got != uci {
+				t.Fatalf("game %d %s: SANtoUCI(%s) got '%s' %v, want '%s'", game, board.FEN(), san, got, err, uci)
+			}
+
+			ucis, sans = append(ucis, uci), append(sans, san)
+			board.Moves(uci)
+			if fen := board.FEN(); FENtoBoard(fen).FEN() != fen {
+				t.Fatalf("game %d: FEN round trip got '%s', want '%s'", game, FENtoBoard(fen).FEN(), fen)
+			}
+		}
+
+		start := FENtoBoard(startPosFEN)
+		replayed, err := start.SANtoUCIs(sans...)
+		if err != nil {
+			t.Fatalf("game %d: %v", game, err)
+		}
+		final := FENtoBoard(startPosFEN)
+		final.Moves(replayed...)
+		if final.FEN() != board.FEN() {
+			t.Fatalf("game %d: final FEN by SAN got '%s', want '%s'", game, final.FEN(), board.FEN())
+		}
+		if got := start.UCItoSANs(ucis...); !reflect.DeepEqual(got, sans) {
+			t.Fatalf("game %d: UCItoSANs got %v, want %v", game, got, sans)
+		}
+	}
+}
+
+func TestBoard_MovesStrict(t *testing.T) {
+	cases := []struct {
+		name    string
+		fen     string
+		moves   []string
+		wantFEN string
+		wantErr bool
+	}{
+		{name: "legal", moves: []string{"e2e4", "e7e5", "g1f3"}, wantFEN: "rnbqkbnr/pppp1ppp/8/4p3/4P3/5N2/PPPP1PPP/RNBQKB1R b KQkq - 1 2"},
+		{name: "through a piece", moves: []string{"f1c4"}, wantFEN: startPosFEN, wantErr: true},
+		{name: "stops at the illegal move", moves: []string{"e2e4", "e2e4"}, wantFEN: "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq - 0 1", wantErr: true},
+		{name: "wrong side", moves: []string{"e7e5"}, wantFEN: startPosFEN, wantErr: true},
+		{name: "garbage", moves: []string{"e9"}, wantFEN: startPosFEN, wantErr: true},
+		{name: "bad promotion", fen: "8/4P3/8/8/8/8/k7/7K w - - 0 1", moves: []string{"e7e8k"}, wantFEN: "8/4P3/8/8/8/8/k7/7K w - - 0 1", wantErr: true},
+		{name: "castling as king takes rook", fen: "4k3/8/8/8/8/8/8/4K2R w K - 0 1", moves: []string{"e1h1"}, wantFEN: "4k3/8/8/8/8/8/8/5RK1 b - - 1 1"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			board := FENtoBoard(c.fen)
+
+			// act
+			err := board.MovesStrict(c.moves...)
+
+			// assert
+			if (err != nil) != c.wantErr {
+				t.Errorf("err: got %v, want error %v", err, c.wantErr)
+			}
+			if board.FEN() != c.wantFEN {
+				t.Errorf("got '%s', want '%s'", board.FEN(), c.wantFEN)
+			}
+		})
+	}
+}
+
+func FuzzBoard_MovesStrict(f *testing.F) {
+	f.Add(startPosFEN, "e2e4 e7e5 g1f3")
+	f.Add(startPosFEN, "f1c4 e9 a1a1")
+	f.Add("r3k2r/pPpp1ppp/8/3Pp3/8/8/PPP2PPP/R3K2R w KQkq e6 0 1", "d5e6 e8c8 b7a8q e1h1")
+
+	f.Fuzz(func(t *testing.T, fen, moves string) {
+		if ValidFEN(fen) != nil {
+			return
+		}
+		board := FENtoBoard(fen)
+		if err := board.MovesStrict(strings.Fields(moves)...); err != nil {
+			return
+		}
+		if err := ValidFEN(board.FEN()); err != nil {
+			t.Errorf("legal moves from '%s' led to an invalid position: %v", fen, err)
+		}
+	})
+}
@@ -0,0 +1,88 @@
+package fen
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	clkRegex  = regexp.MustCompile(`\[%clk\s+(\d+):(\d+):(\d+(?:\.\d+)?)\]`)
+	evalRegex = regexp.MustCompile(`\[%eval\s+(#?[+-]?\d+(?:\.\d+)?)`)
+)
+
+// parseComment reads the [%clk] and [%eval] commands of a move's comment, as lichess
+// exports them: { [%eval 0.17] [%clk 0:02:58] }.
+func (m *PGNMove) parseComment(comment string) {
+	if match := clkRegex.FindStringSubmatch(comment); match != nil {
+		h, _ := strconv.Atoi(match[1])
+		mins, _ := strconv.Atoi(match[2])
+		sec, _ := strconv.ParseFloat(match[3], 64)
+		m.Clock = time.Duration(h)*time.Hour + time.Duration(mins)*time.Minute + time.Duration(sec*float64(time.Second))
+	}
+
+	if match := evalRegex.FindStringSubmatch(comment); match != nil {
+		value := match[1]
+		if strings.HasPrefix(value, "#") {
+			mate, err := strconv.Atoi(value[1:])
+			if err != nil {
+				return
+			}
+			m.Mate, m.HasEval = mate, true
+			return
+		}
+
+		pawns, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return
+		}
+		m.CP, m.HasEval = int(math.Round(pawns*100)), true
+	}
+}
+
+// TimeControl returns the base and increment of a TimeControl tag like "180+2".
+func (t Tags) TimeControl() (base, increment time.Duration, ok bool) {
+	baseText, incText, _ := strings.Cut(t["TimeControl"], "+")
+	b, err := strconv.Atoi(baseText)
+	if err != nil {
+		return 0, 0, false
+	}
+	var inc int
+	if incText != "" {
+		if inc, err = strconv.Atoi(incText); err != nil {
+			return 0, 0, false
+		}
+	}
+	return time.Duration(b) * time.Second, time.Duration(inc) * time.Second, true
+}
+
+// setThinkTimes sets each move's Think from the clocks before and after it. The clocks
+// are after the increment was added, the increment is taken off the think time.
+func (g *PGNGame) setThinkTimes() {
+	base, increment, hasTC := g.Tags.TimeControl()
+
+	for i := range g.Moves {
+		move := &g.Moves[i]
+		if move.Clock < 0 {
+			continue
+		}
+
+		before := time.Duration(-1)
+		if i >= 2 {
+			before = g.Moves[i-2].Clock
+		} else if hasTC {
+			before = base
+		}
+		if before < 0 {
+			continue
+		}
+
+		think := before - move.Clock + increment
+		if think < 0 {
+			think = 0
+		}
+		move.Think = think
+	}
+}
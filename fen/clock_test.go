@@ -0,0 +1,39 @@
+package fen
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParsePGN_Clocks(t *testing.T) {
+	// arrange
+	pgn := `[White "a"]
+[Black "b"]
+[TimeControl "180+2"]
+
+1. e4 { [%eval 0.17] [%clk 0:03:01] } 1... e5 { [%eval +0.2] [%clk 0:02:58.5] } 2. Nf3 { [%eval #-3] [%clk 0:02:55] } 2... Nc6 { [%clk 0:02:59] } 3. Bb5 *`
+
+	// act
+	game, err := ParsePGN(pgn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// assert
+	want := []PGNMove{
+		{Clock: 3*time.Minute + time.Second, Think: time.Second, CP: 17, HasEval: true},
+		{Clock: 2*time.Minute + 58500*time.Millisecond, Think: 3500 * time.Millisecond, CP: 20, HasEval: true},
+		{Clock: 2*time.Minute + 55*time.Second, Think: 8 * time.Second, Mate: -3, HasEval: true},
+		{Clock: 2*time.Minute + 59*time.Second, Think: 1500 * time.Millisecond},
+		{Clock: -1, Think: -1},
+	}
+	if len(game.Moves) != len(want) {
+		t.Fatalf("got %d moves, want %d", len(game.Moves), len(want))
+	}
+	for i, w := range want {
+		got := game.Moves[i]
+		if got.Clock != w.Clock || got.Think != w.Think || got.CP != w.CP || got.Mate != w.Mate || got.HasEval != w.HasEval {
+			t.Errorf("move %d: got %+v, want %+v", i+1, got, w)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package fen
+
+import (
+	"strings"
+)
+
+const lichessSite = "https://lichess.org/"
+
+// Dedupe removes the games already in db, keeping the first of each, and returns how many
+// were removed. Games are the same if they have the same lichess game ID (the GameId tag,
+// or the Site tag's URL), the same chess.com Link tag or, failing that, the same players,
+// starting position and moves.
+// It's for databases of concatenated exports, which overlap.
+func (db *Database) Dedupe() int {
+	seen := make(map[string]bool, len(db.Games))
+	games := db.Games[:0]
+	for _, game := range db.Games {
+		key := game.dedupeKey()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		games = append(games, game)
+	}
+
+	removed := len(db.Games) - len(games)
+	for i := len(games); i < len(db.Games); i++ {
+		db.Games[i] = nil
+	}
+	db.Games = games
+	return removed
+}
+
+// LichessID returns the game's lichess ID from its GameId or Site tag, or "" if it has none.
+func (g *PGNGame) LichessID() string {
+	if id := g.Tags["GameId"]; id != "" {
+		return id
+	}
+	site := g.Tags["Site"]
+	if !strings.HasPrefix(site, lichessSite) {
+		return ""
+	}
+	// e.g. https://lichess.org/abcdefgh/black
+	id, _, _ := strings.Cut(strings.TrimPrefix(site, lichessSite), "/")
+	return id
+}
+
+func (g *PGNGame) dedupeKey() string {
+	if id := g.LichessID(); id != "" {
+		return "id:" + id
+	}
+	if link := g.Tags["Link"]; link != "" {
+		return "link:" + link
+	}
+
+	var sb strings.Builder
+	sb.WriteString(strings.ToLower(g.White))
+	sb.WriteByte('|')
+	sb.WriteString(strings.ToLower(g.Black))
+	sb.WriteByte('|')
+	sb.WriteString(Key(g.SetupFEN))
+	for _, move := range g.Moves {
+		sb.WriteByte(' ')
+		sb.WriteString(move.UCI)
+	}
+	return sb.String()
+}
@@ -0,0 +1,74 @@
+package fen
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDatabase_Dedupe(t *testing.T) {
+	// arrange
+	const pgn = `[Site "https://lichess.org/abcdefgh"]
+[White "a"]
+[Black "b"]
+[Result "1-0"]
+
+1. e4 e5 1-0
+
+[Site "https://lichess.org/abcdefgh/black"]
+[White "a"]
+[Black "b"]
+[Result "1-0"]
+
+1. e4 e5 1-0
+
+[White "A"]
+[Black "b"]
+[Result "0-1"]
+
+1. d4 d5 0-1
+
+[White "a"]
+[Black "b"]
+[Result "0-1"]
+
+1. d4 d5 0-1
+
+[White "b"]
+[Black "a"]
+[Result "0-1"]
+
+1. d4 d5 0-1
+
+[GameId "ijklmnop"]
+[White "a"]
+[Black "b"]
+[Result "1-0"]
+
+1. e4 e5 1-0
+`
+	filename := filepath.Join(t.TempDir(), "games.pgn")
+	if err := os.WriteFile(filename, []byte(pgn), 0644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := LoadPGNDatabase(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	removed := db.Dedupe()
+
+	// assert
+	if removed != 2 {
+		t.Errorf("removed: got %d, want 2", removed)
+	}
+	var indexes []int
+	for _, game := range db.Games {
+		indexes = append(indexes, game.Index)
+	}
+	if want := []int{1, 3, 5, 6}; !reflect.DeepEqual(indexes, want) {
+		t.Errorf("got games %v, want %v", indexes, want)
+	}
+}
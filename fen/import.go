@@ -0,0 +1,136 @@
+package fen
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ImportOptions are how LoadPGNDatabase reads PGNs exported by sites other than lichess.
+type ImportOptions struct {
+	// ChessCom maps chess.com's tags to lichess', see chessComTags.
+	ChessCom bool
+	// CheckMarks accepts moves with a missing or extra check mark (+ or #), which chess.com
+	// exports have.
+	CheckMarks bool
+	// SkipInvalid skips the games that don't parse, e.g. with an illegal move, instead of
+	// failing the load. Database.Skipped counts them.
+	SkipInvalid bool
+}
+
+// Import is how LoadPGNDatabase reads PGNs, see ParseImportProfile.
+var Import ImportOptions
+
+// ImportProfiles are the profiles of ParseImportProfile.
+const ImportProfiles = "lichess, chesscom"
+
+// ParseImportProfile returns the ImportOptions of a site's exports, "lichess" or
+// "chesscom". Tolerance flags like SkipInvalid are set on top of it.
+func ParseImportProfile(profile string) (ImportOptions, error) {
+	switch strings.ToLower(strings.TrimSpace(profile)) {
+	case "", "lichess":
+		return ImportOptions{}, nil
+	case "chesscom", "chess.com":
+		return ImportOptions{ChessCom: true, CheckMarks: true}, nil
+	}
+	return ImportOptions{}, fmt.Errorf("'%s': want one of %s", profile, ImportProfiles)
+}
+
+// chessComTags rewrites the tags of a chess.com game the way lichess has them: Site is
+// the game's URL (chess.com has it in Link) and Termination is "Normal", "Time forfeit"
+// or "Abandoned" rather than e.g. "someone won on time".
+func (g *PGNGame) chessComTags() {
+	if link := g.Tags["Link"]; link != "" {
+		g.Tags["Site"] = link
+	}
+
+	if termination, ok := g.Tags["Termination"]; ok {
+		lower := strings.ToLower(termination)
+		switch {
+		case strings.Contains(lower, "on time"), strings.Contains(lower, "timeout"):
+			g.Tags["Termination"] = "Time forfeit"
+		case strings.Contains(lower, "abandon"):
+			g.Tags["Termination"] = "Abandoned"
+		default:
+			g.Tags["Termination"] = "Normal"
+		}
+	}
+}
+
+var moveNumberRegex = regexp.MustCompile(`^(\d+)\.+(.*)$`)
+
+// pgnTokens splits movetext into move numbers, moves, results and {comments}. Variations,
+// NAGs ($1), ; comments and annotation glyphs (!?) are dropped, and move numbers written
+// together with the move (1.e4, 12...Nf6) are split off, as some sites export them.
+func pgnTokens(movetext string) []string {
+	var (
+		tokens []string
+		word   strings.Builder
+		depth  int // of variations
+	)
+
+	flush := func() {
+		if word.Len() == 0 {
+			return
+		}
+		w := word.String()
+		word.Reset()
+		if depth > 0 || strings.HasPrefix(w, "$") {
+			return
+		}
+		if match := moveNumberRegex.FindStringSubmatch(w); match != nil {
+			tokens = append(tokens, match[1]+".")
+			if w = match[2]; w == "" {
+				return
+			}
+		}
+		if w = strings.TrimRight(w, "!?"); w != "" {
+			tokens = append(tokens, w)
+		}
+	}
+
+	for i := 0; i < len(movetext); i++ {
+		switch c := movetext[i]; c {
+		case '{':
+			flush()
+			end := strings.IndexByte(movetext[i:], '}')
+			if end == -1 {
+				end = len(movetext) - i - 1
+			}
+			if depth == 0 {
+				tokens = append(tokens, movetext[i:i+end+1])
+			}
+			i += end
+		case ';':
+			flush()
+			end := strings.IndexByte(movetext[i:], '\n')
+			if end == -1 {
+				return tokens
+			}
+			i += end
+		case '(':
+			flush()
+			depth++
+		case ')':
+			flush()
+			if depth > 0 {
+				depth--
+			}
+		case ' ', '\t', '\n', '\r':
+			flush()
+		default:
+			word.WriteByte(c)
+		}
+	}
+	flush()
+	return tokens
+}
+
+// isResult reports whether a movetext token is a game's result.
+func isResult(token string) bool {
+	switch token {
+	case "1-0", "0-1", "1/2-1/2", "½-½", "*":
+		return true
+	}
+	return false
+}
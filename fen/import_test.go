@@ -0,0 +1,180 @@
+package fen
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPGNTokens(t *testing.T) {
+	cases := []struct {
+		movetext string
+		want     []string
+	}{
+		{"1. e4 e5 1-0", []string{"1.", "e4", "e5", "1-0"}},
+		{"1.e4 1...e5 2.Nf3!? $1 Nc6?", []string{"1.", "e4", "1.", "e5", "2.", "Nf3", "Nc6"}},
+		{"1. e4 {[%clk 0:02:59.9]} 1... e5{ok}", []string{"1.", "e4", "{[%clk 0:02:59.9]}", "1.", "e5", "{ok}"}},
+		{"1. e4 (1. d4 {x} (1. c4)) e5 ; rest\n2. Nf3", []string{"1.", "e4", "e5", "2.", "Nf3"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.movetext, func(t *testing.T) {
+			// act
+			got := pgnTokens(c.movetext)
+
+			// assert
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestLoadPGNDatabase_ChessCom(t *testing.T) {
+	// arrange
+	const pgn = "\ufeff" + `[Event "Live Chess"]
+[Site "Chess.com"]
+[White "a"]
+[Black "b"]
+[Result "1/2-1/2"]
+[TimeControl "180"]
+[Termination "b won on time"]
+[Link "https://www.chess.com/game/live/123"]
+
+1. e4 {[%clk 0:02:59.9]} 1... e5 {[%clk 0:02:58.1]} 2. Nf3 {[%clk 0:02:57]} 2... Nc6 {[%clk 0:02:50]} 3. Bb5 a6 1/2-1/2
+[Event "Live Chess"]
+[White "a"]
+[Black "b"]
+[Result "0-1"]
+
+1. e4 e5 2. Ke3 0-1
+
+[Event "Live Chess"]
+[White "c"]
+[Black "d"]
+[Result "1-0"]
+
+1. f3 e5 2. g4 Qh4 1-0
+`
+	filename := filepath.Join(t.TempDir(), "chesscom.pgn")
+	if err := os.WriteFile(filename, []byte(pgn), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(saved ImportOptions) { Import = saved }(Import)
+	var err error
+	if Import, err = ParseImportProfile("chesscom"); err != nil {
+		t.Fatal(err)
+	}
+	Import.SkipInvalid = true
+
+	// act
+	db, err := LoadPGNDatabase(filename)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(db.Games) != 2 || db.Skipped != 1 {
+		t.Fatalf("got %d games, %d skipped, want 2 games, 1 skipped", len(db.Games), db.Skipped)
+	}
+	game := db.Games[0]
+	if len(game.Moves) != 6 || game.Result != Draw {
+		t.Errorf("got %d moves, result %v, want 6 moves, 1/2-1/2", len(game.Moves), game.Result)
+	}
+	if game.Tags["Site"] != "https://www.chess.com/game/live/123" || game.Tags["Termination"] != "Time forfeit" {
+		t.Errorf("tags: got %v", game.Tags)
+	}
+	if want := 2*time.Minute + 58100*time.Millisecond; game.Moves[1].Clock != want {
+		t.Errorf("clock: got %v, want %v", game.Moves[1].Clock, want)
+	}
+	if last := db.Games[1].Moves[3]; last.UCI != "d8h4" {
+		t.Errorf("missing mate mark: got %s, want d8h4", last.UCI)
+	}
+}
+
+func TestParseImportProfile_Unknown(t *testing.T) {
+	// act
+	_, err := ParseImportProfile("fics")
+
+	// assert
+	if err == nil {
+		t.Error("got nil, want an error")
+	}
+}
+
+func TestParsePGN_Import(t *testing.T) {
+	const queenside = `[FEN "4k2r/8/8/8/8/8/8/R3K3 w Qk - 0 1"]
+
+1. 0-0-0 0-0 *`
+
+	cases := []struct {
+		name       string
+		pgn        string
+		checkMarks bool
+		want       []string
+		wantErr    bool
+	}{
+		{name: "zero castling", pgn: queenside, want: []string{"e1c1", "e8g8"}},
+		{name: "check mark", pgn: "1. f3 e5 2. g4 Qh4# 0-1", want: []string{"f2f3", "e7e5", "g2g4", "d8h4"}},
+		{name: "missing check mark", pgn: "1. f3 e5 2. g4 Qh4 0-1", wantErr: true},
+		{name: "missing check mark, loose", pgn: "1. f3 e5 2. g4 Qh4 0-1", checkMarks: true, want: []string{"f2f3", "e7e5", "g2g4", "d8h4"}},
+		{name: "extra check mark, loose", pgn: "1. e4+ e5 *", checkMarks: true, want: []string{"e2e4", "e7e5"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			defer func(saved ImportOptions) { Import = saved }(Import)
+			Import = ImportOptions{CheckMarks: c.checkMarks}
+
+			// act
+			game, err := ParsePGN(c.pgn)
+
+			// assert
+			if (err != nil) != c.wantErr {
+				t.Fatalf("err: got %v, want error %v", err, c.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			var got []string
+			for _, move := range game.Moves {
+				got = append(got, move.UCI)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestLoadPGNDatabase_WrappedComment(t *testing.T) {
+	// arrange
+	const pgn = `[White "a"]
+[Black "b"]
+
+1. e4 {a comment wrapped before its clock
+[%clk 0:02:59]} 1... e5 2. Nf3 *
+`
+	filename := filepath.Join(t.TempDir(), "wrapped.pgn")
+	if err := os.WriteFile(filename, []byte(pgn), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	db, err := LoadPGNDatabase(filename)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(db.Games) != 1 || len(db.Games[0].Moves) != 3 {
+		t.Fatalf("got %d games, want 1 game of 3 moves", len(db.Games))
+	}
+	if db.Games[0].Moves[0].Clock != 2*time.Minute+59*time.Second {
+		t.Errorf("clock: got %v, want 2m59s", db.Games[0].Moves[0].Clock)
+	}
+}
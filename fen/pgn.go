@@ -3,18 +3,21 @@ package fen
 import (
 	"bufio"
 	"fmt"
-	"log"
 	"os"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 type Database struct {
 	Games []*PGNGame
 
 	Positions map[string][]PGNMove
+
+	Skipped int // games that didn't parse, see ImportOptions.SkipInvalid
 }
 
 type GameResult int
@@ -40,6 +43,7 @@ func (gr GameResult) String() string {
 }
 
 type PGNGame struct {
+	Index    int // 1-based position in the file, see LoadPGNDatabase
 	SetupFEN string
 	White    string
 	Black    string
@@ -133,6 +137,7 @@ func LoadPGNDatabase(filename string) (Database, error) {
 		mtx    sync.Mutex
 		wg     sync.WaitGroup
 		isGame bool
+		index  int
 	)
 
 	addGame := func() error {
@@ -141,17 +146,30 @@ func LoadPGNDatabase(filename string) (Database, error) {
 		}
 
 		s := pgn.String()
+		index++
+		n := index
 
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 			game, err := ParsePGN(s)
 			if err != nil {
+				if Import.SkipInvalid {
+					fmt.Printf("skipped game %d: %v\n", n, err)
+					mtx.Lock()
+					db.Skipped++
+					mtx.Unlock()
+					return
+				}
 				fmt.Printf("PGN:\n\n%s\n\n\n", s)
 				panic(err)
 			}
 
-			if len(game.Moves) != 0 {
+			if game != nil && len(game.Moves) != 0 {
+				if Import.ChessCom {
+					game.chessComTags()
+				}
+				game.Index = n
 				game.populatePositions()
 				mtx.Lock()
 				db.Games = append(db.Games, game)
@@ -164,9 +182,21 @@ func LoadPGNDatabase(filename string) (Database, error) {
 		return nil
 	}
 
-	for r.Scan() {
-		line := strings.TrimSpace(r.Text())
-		if !strings.HasPrefix(line, "[") && len(line) != 0 {
+	for first := true; r.Scan(); first = false {
+		line := r.Text()
+		if first {
+			line = strings.TrimPrefix(line, "\ufeff") // byte order mark
+		}
+		line = strings.TrimSpace(line)
+		// a game's tags right after the previous game's moves, without a blank line. A
+		// wrapped comment's line may start with [ too, e.g. [%clk 0:01:00]}
+		tagPair := isTagPair(line)
+		if tagPair && isGame {
+			if err := addGame(); err != nil {
+				return db, err
+			}
+		}
+		if !tagPair && len(line) != 0 {
 			isGame = true
 		}
 
@@ -193,23 +223,35 @@ func LoadPGNDatabase(filename string) (Database, error) {
 
 	wg.Wait()
 
+	// games are parsed concurrently, keep the file's order
+	sort.Slice(db.Games, func(i, j int) bool {
+		return db.Games[i].Index < db.Games[j].Index
+	})
+
 	return db, nil
 }
 
 type Tags map[string]string
 
+var tagPairRegex = regexp.MustCompile(`^\[[A-Za-z0-9_]+\s+".*"\]$`)
+
+// isTagPair reports whether a line is a tag pair, e.g. [White "someone"].
+func isTagPair(line string) bool {
+	return tagPairRegex.MatchString(strings.TrimSpace(line))
+}
+
 func (g *PGNGame) ParseTags(pgn string) string {
 	lines := strings.Split(strings.TrimSpace(pgn), "\n")
 	var sb strings.Builder
 	for _, line := range lines {
-		if strings.HasPrefix(line, "[") {
+		if isTagPair(line) {
 			line = strings.Trim(line, "[]")
 			idx := strings.Index(line, " ")
 			if idx == -1 {
 				continue
 			}
 			key := line[:idx]
-			value := line[idx+2 : len(line)-1]
+			value := strings.Trim(strings.TrimSpace(line[idx:]), `"`)
 
 			g.Tags[key] = value
 
@@ -230,7 +272,7 @@ func (g *PGNGame) ParseTags(pgn string) string {
 					g.Result = WhiteWon
 				case "0-1":
 					g.Result = BlackWon
-				case "1/2-1/2":
+				case "1/2-1/2", "½-½":
 					g.Result = Draw
 				default:
 					g.Result = OtherResult
@@ -248,6 +290,14 @@ func (g *PGNGame) ParseTags(pgn string) string {
 type PGNMove struct {
 	FENKey string
 	UCI    string
+
+	Clock time.Duration // the mover's time left, from [%clk]. -1 if the PGN has none
+	Think time.Duration // time spent on the move, see setThinkTimes. -1 if unknown
+
+	// CP and Mate are the [%eval] after the move, from white's point of view.
+	CP      int
+	Mate    int
+	HasEval bool
 }
 
 func ParsePGN(pgn string) (*PGNGame, error) {
@@ -261,14 +311,15 @@ func ParsePGN(pgn string) (*PGNGame, error) {
 		return nil, nil
 	}
 
-	lines := strings.Split(pgn, "\n")
-	pgn = strings.TrimSpace(strings.Join(lines, " "))
-	parts := strings.Split(pgn, " ")
+	if err := ValidFEN(game.SetupFEN); err != nil {
+		return nil, err
+	}
+	parts := pgnTokens(pgn)
 	b := FENtoBoard(game.SetupFEN)
 	var fullMove int
 	for i := 0; i < len(parts); i++ {
 		part := parts[i]
-		if part == "1-0" || part == "0-1" || part == "1/2-1/2" || part == "*" || part == "" {
+		if isResult(part) {
 			continue
 		}
 
@@ -283,14 +334,16 @@ func ParsePGN(pgn string) (*PGNGame, error) {
 		}
 
 		if strings.HasPrefix(part, "{") {
-			for i = i + 1; i < len(parts); i++ {
-				if strings.HasSuffix(parts[i], "}") {
-					break
-				}
+			if n := len(game.Moves); n != 0 {
+				game.Moves[n-1].parseComment(part)
 			}
 			continue
 		}
 
+		// castling with zeros, 0-0 or 0-0-0
+		if strings.HasPrefix(part, "0-0") {
+			part = strings.ReplaceAll(part, "0", "O")
+		}
 		san := part
 
 		piece := san[0]
@@ -303,11 +356,11 @@ func ParsePGN(pgn string) (*PGNGame, error) {
 			piece = lower(piece)
 		}
 
-		uci, err := b.SANtoUCI(san)
+		uci, err := b.sanToUCI(san, Import.CheckMarks)
 		if err != nil {
-			log.Fatal(err)
+			return nil, fmt.Errorf("full_move: %d: %v", fullMove, err)
 		}
-		move := PGNMove{FENKey: b.FENKey(), UCI: uci}
+		move := PGNMove{FENKey: b.FENKey(), UCI: uci, Clock: -1, Think: -1}
 
 		if san == "" {
 			return nil, fmt.Errorf("FEN: '%s' full_move: %d color: '%s' want: '%s' got: <empty>", b.FEN(), fullMove, b.ActiveColor, part)
@@ -320,7 +373,11 @@ func ParsePGN(pgn string) (*PGNGame, error) {
 		}
 
 		game.Moves = append(game.Moves, move)
-		b.Moves(uci)
+		if err := b.MovesStrict(uci); err != nil {
+			return nil, fmt.Errorf("full_move: %d: %v", fullMove, err)
+		}
 	}
+
+	game.setThinkTimes()
 	return &game, nil
 }
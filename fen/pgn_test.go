@@ -25,6 +25,7 @@ func TestPGNtoMoves(t *testing.T) {
 	cases := pgnMovesTestData(t)
 
 	for i, c := range cases {
+		c := c
 		t.Run(fmt.Sprintf("%04d", i+1), func(t *testing.T) {
 			t.Parallel()
 
@@ -90,23 +91,45 @@ func pgnMovesTestData(tb testing.TB) []PGNMoves {
 	return cases
 }
 
+// fenMovesTestData returns the golden FEN, UCI and SAN cases: positions from games, and
+// san_edge_cases.json for the disambiguation, promotion, en passant, castling and mate
+// suffixes games rarely have. testdata/gen_san.py writes their SAN with python-chess.
 func fenMovesTestData(tb testing.TB) []FENMoves {
-	fp, err := os.Open("testdata/fen_uci_san.json")
-	if err != nil {
-		tb.Fatal(err)
-	}
-	defer fp.Close()
-
 	var cases []FENMoves
+	for _, filename := range []string{"testdata/fen_uci_san.json", "testdata/san_edge_cases.json"} {
+		fp, err := os.Open(filename)
+		if err != nil {
+			tb.Fatal(err)
+		}
 
-	dec := json.NewDecoder(fp)
-	if err := dec.Decode(&cases); err != nil {
-		tb.Fatal(err)
+		var fileCases []FENMoves
+		dec := json.NewDecoder(fp)
+		err = dec.Decode(&fileCases)
+		fp.Close()
+		if err != nil {
+			tb.Fatalf("'%s': %v", filename, err)
+		}
+		cases = append(cases, fileCases...)
 	}
 
 	return cases
 }
 
+func TestUCItoSAN(t *testing.T) {
+	cases := fenMovesTestData(t)
+
+	for _, c := range cases {
+		t.Run(c.FEN+" "+c.UCI, func(t *testing.T) {
+			board := FENtoBoard(c.FEN)
+			san := board.UCItoSAN(c.UCI)
+
+			if c.SAN != san {
+				t.Errorf("want: '%s' got: '%s'", c.SAN, san)
+			}
+		})
+	}
+}
+
 func TestSANtoUCI(t *testing.T) {
 	cases := fenMovesTestData(t)
 
@@ -125,6 +148,39 @@ func TestSANtoUCI(t *testing.T) {
 	}
 }
 
+func TestSANtoUCILoose(t *testing.T) {
+	const scholarsMate = "r1bqkbnr/pppp1ppp/2n5/4p2Q/2B1P3/8/PPPP1PPP/RNB1K1NR w KQkq - 4 4"
+	cases := []struct {
+		san     string
+		want    string
+		wantErr bool
+	}{
+		{san: "Qxf7#", want: "h5f7"},
+		{san: "Qxf7", want: "h5f7"},
+		{san: "Qxf7+", want: "h5f7"},
+		{san: "Nf3+", want: "g1f3"},
+		{san: "Qxf6", wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.san, func(t *testing.T) {
+			// arrange
+			board := FENtoBoard(scholarsMate)
+
+			// act
+			got, err := board.SANtoUCILoose(c.san)
+
+			// assert
+			if (err != nil) != c.wantErr {
+				t.Fatalf("err: %v, want error: %v", err, c.wantErr)
+			}
+			if got != c.want {
+				t.Errorf("got '%s', want '%s'", got, c.want)
+			}
+		})
+	}
+}
+
 func BenchmarkPGNtoMoves(b *testing.B) {
 	const pgn = `1. e4 e5 2. Nf3 Nc6 3. Bb5 Nf6 4. O-O Nxe4 5. d4 Nd6 6. Bxc6 dxc6 7. dxe5
 Nf5 8. Qxd8+ Kxd8 9. Nc3 Be7 10. Bf4 Be6 11. g4 Nh4 12. Nxh4 Bxh4 13. g5
@@ -142,3 +198,22 @@ Ke1 Rh2 38. Rxc7+ Kd8 39. Rc4 Rb2 40. Rd1+ Ke7 41. Rc7+ Ke6 42. Rd6+ Kf5
 		}
 	}
 }
+
+func FuzzParsePGN(f *testing.F) {
+	f.Add("1. e4 e5 2. Nf3 Nc6 3. Bb5 a6 {[%clk 0:03:00]} 1-0")
+	f.Add("[FEN \"4k3/8/8/8/8/8/8/4K2R w K - 0 1\"]\n\n1. O-O Kd7 2. Rf7+ *")
+	f.Add("1. e4 e5 2. Bc4 Nc6 3. Qh5 Nf6?? 4. Qxf7# 1-0")
+
+	f.Fuzz(func(t *testing.T, pgn string) {
+		game, err := ParsePGN(pgn)
+		if err != nil || game == nil {
+			return
+		}
+		board := FENtoBoard(game.SetupFEN)
+		for _, move := range game.Moves {
+			if err := board.MovesStrict(move.UCI); err != nil {
+				t.Fatalf("parsed an illegal move: %v", err)
+			}
+		}
+	})
+}
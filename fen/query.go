@@ -0,0 +1,111 @@
+package fen
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Query selects games from a Database by their tags and position in the file. Zero
+// fields match every game, names and rounds are compared case insensitively.
+type Query struct {
+	Player string // White or Black
+	White  string
+	Black  string
+	Round  string
+	Result string // "1-0", "0-1", "1/2-1/2" or "*"
+	MinElo int    // both players
+	Games  []IndexRange
+}
+
+// IndexRange is an inclusive range of 1-based game numbers, To 0 is the last game.
+type IndexRange struct {
+	From int
+	To   int
+}
+
+// ParseIndexRanges reads comma separated game numbers and ranges, e.g. "1,4,7-9,20-".
+func ParseIndexRanges(text string) ([]IndexRange, error) {
+	var ranges []IndexRange
+	if strings.TrimSpace(text) == "" {
+		return ranges, nil
+	}
+
+	for _, part := range strings.Split(text, ",") {
+		part = strings.TrimSpace(part)
+		from, to, isRange := strings.Cut(part, "-")
+
+		start, err := strconv.Atoi(from)
+		if err != nil || start < 1 {
+			return nil, fmt.Errorf("game index '%s': want a number from 1, or a range like 3-5 or 10-", part)
+		}
+
+		r := IndexRange{From: start, To: start}
+		if isRange {
+			r.To = 0
+			if to != "" {
+				end, err := strconv.Atoi(to)
+				if err != nil || end < start {
+					return nil, fmt.Errorf("game index '%s': want a number from 1, or a range like 3-5 or 10-", part)
+				}
+				r.To = end
+			}
+		}
+		ranges = append(ranges, r)
+	}
+
+	return ranges, nil
+}
+
+func (r IndexRange) contains(index int) bool {
+	return index >= r.From && (r.To == 0 || index <= r.To)
+}
+
+// IsZero reports whether q matches every game.
+func (q Query) IsZero() bool {
+	return q.Player == "" && q.White == "" && q.Black == "" && q.Round == "" &&
+		q.Result == "" && q.MinElo == 0 && len(q.Games) == 0
+}
+
+// Match reports whether g passes every filter of q.
+func (q Query) Match(g *PGNGame) bool {
+	if q.Player != "" && !strings.EqualFold(g.White, q.Player) && !strings.EqualFold(g.Black, q.Player) {
+		return false
+	}
+	if q.White != "" && !strings.EqualFold(g.White, q.White) {
+		return false
+	}
+	if q.Black != "" && !strings.EqualFold(g.Black, q.Black) {
+		return false
+	}
+	if q.Round != "" && !strings.EqualFold(g.Tags["Round"], q.Round) {
+		return false
+	}
+	if q.Result != "" && g.Result.String() != q.Result {
+		return false
+	}
+	if q.MinElo != 0 && (g.WhiteElo < q.MinElo || g.BlackElo < q.MinElo) {
+		return false
+	}
+
+	if len(q.Games) == 0 {
+		return true
+	}
+	for _, r := range q.Games {
+		if r.contains(g.Index) {
+			return true
+		}
+	}
+	return false
+}
+
+// Select returns the games matching q in file order.
+func (db *Database) Select(q Query) []*PGNGame {
+	var games []*PGNGame
+	for _, game := range db.Games {
+		if q.Match(game) {
+			games = append(games, game)
+		}
+	}
+	return games
+}
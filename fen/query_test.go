@@ -0,0 +1,93 @@
+package fen
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDatabase_Select(t *testing.T) {
+	// arrange
+	games := []struct {
+		white, black string
+		whiteElo     int
+		blackElo     int
+		round        string
+		result       string
+	}{
+		{"trollololfish", "Carlsen", 2900, 2850, "1", "1-0"},
+		{"Nakamura", "trollololfish", 2800, 2900, "1", "1/2-1/2"},
+		{"Carlsen", "Nakamura", 2850, 2800, "2", "0-1"},
+		{"Patzer", "trollololfish", 1200, 2900, "2", "0-1"},
+	}
+
+	var sb strings.Builder
+	for _, g := range games {
+		sb.WriteString(fmt.Sprintf("[Round \"%s\"]\n[White \"%s\"]\n[Black \"%s\"]\n[WhiteElo \"%d\"]\n[BlackElo \"%d\"]\n[Result \"%s\"]\n\n1. e4 e5 2. Nf3 Nc6 %s\n\n",
+			g.round, g.white, g.black, g.whiteElo, g.blackElo, g.result, g.result))
+	}
+	filename := filepath.Join(t.TempDir(), "games.pgn")
+	if err := os.WriteFile(filename, []byte(sb.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := LoadPGNDatabase(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ranges, err := ParseIndexRanges("1,3-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name  string
+		query Query
+		want  []int
+	}{
+		{"all", Query{}, []int{1, 2, 3, 4}},
+		{"player", Query{Player: "TROLLOLOLFISH"}, []int{1, 2, 4}},
+		{"white", Query{White: "carlsen"}, []int{3}},
+		{"black", Query{Black: "trollololfish", Result: "0-1"}, []int{4}},
+		{"round", Query{Round: "2"}, []int{3, 4}},
+		{"min elo", Query{MinElo: 2800}, []int{1, 2, 3}},
+		{"games", Query{Games: ranges}, []int{1, 3, 4}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// act
+			var got []int
+			for _, game := range db.Select(c.query) {
+				got = append(got, game.Index)
+			}
+
+			// assert
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestParseIndexRanges(t *testing.T) {
+	// act
+	got, err := ParseIndexRanges("3, 5-7,10-")
+	_, badErr := ParseIndexRanges("7-5")
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []IndexRange{{3, 3}, {5, 7}, {10, 0}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if badErr == nil {
+		t.Error("7-5: got no error")
+	}
+}
@@ -0,0 +1,56 @@
+package fen
+
+import "fmt"
+
+// Score is an eval, in centipawns or moves to mate, from the pov of Side. Book moves and engine
+// evals are from the side to move's pov; EPD ce and dm, lichess cloud evals and PGN [%eval]s
+// are from white's, called global. Side makes the pov explicit, convert with For.
+type Score struct {
+	CP   int
+	Mate int   // moves to mate, negative when getting mated; 0 for none
+	Side Color // whose pov it is
+}
+
+// POV returns the score of the side to move, toMove, e.g. a book move's or the engine's.
+func POV(cp, mate int, toMove Color) Score {
+	return Score{CP: cp, Mate: mate, Side: toMove}
+}
+
+// Global returns a score from white's pov, e.g. EPD ce and dm.
+func Global(cp, mate int) Score {
+	return Score{CP: cp, Mate: mate, Side: WhitePieces}
+}
+
+// For returns s from color's pov.
+func (s Score) For(color Color) Score {
+	if s.Side == color {
+		return s
+	}
+	return Score{CP: -s.CP, Mate: -s.Mate, Side: color}
+}
+
+// Global returns s from white's pov.
+func (s Score) Global() Score {
+	return s.For(WhitePieces)
+}
+
+// Order orders scores of the same pov, mates first: a closer mate is higher, and getting
+// mated later is higher than sooner.
+func (s Score) Order() int {
+	switch {
+	case s.Mate > 0:
+		return 100_000 - s.Mate
+	case s.Mate < 0:
+		return -100_000 - s.Mate
+	default:
+		return s.CP
+	}
+}
+
+// String returns s in pawns, e.g. 0.35, or M3 for a mate.
+func (s Score) String() string {
+	if s.Mate != 0 {
+		return fmt.Sprintf("M%d", s.Mate)
+	}
+	return fmt.Sprintf("%0.2f", float64(s.CP)/100)
+}
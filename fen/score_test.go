@@ -0,0 +1,65 @@
+package fen
+
+import "testing"
+
+func TestScore_For(t *testing.T) {
+	cases := []struct {
+		name  string
+		score Score
+		color Color
+		want  Score
+	}{
+		{"white's pov to white", Global(35, 0), WhitePieces, Score{CP: 35, Side: WhitePieces}},
+		{"white's pov to black", Global(35, 0), BlackPieces, Score{CP: -35, Side: BlackPieces}},
+		{"black to move to global", POV(0, 3, BlackPieces), WhitePieces, Score{Mate: -3, Side: WhitePieces}},
+		{"black to move to black", POV(-120, 0, BlackPieces), BlackPieces, Score{CP: -120, Side: BlackPieces}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// act
+			got := c.score.For(c.color)
+
+			// assert
+			if got != c.want {
+				t.Errorf("got %+v, want %+v", got, c.want)
+			}
+			if back := got.For(c.score.Side); back != c.score {
+				t.Errorf("back: got %+v, want %+v", back, c.score)
+			}
+		})
+	}
+}
+
+func TestScore_Order(t *testing.T) {
+	// arrange
+	ordered := []Score{
+		POV(0, 1, WhitePieces),
+		POV(0, 5, WhitePieces),
+		POV(900, 0, WhitePieces),
+		POV(0, 0, WhitePieces),
+		POV(-900, 0, WhitePieces),
+		POV(0, -5, WhitePieces),
+		POV(0, -1, WhitePieces),
+	}
+
+	for i := 1; i < len(ordered); i++ {
+		// act
+		higher, lower := ordered[i-1].Order(), ordered[i].Order()
+
+		// assert
+		if higher <= lower {
+			t.Errorf("%v (%d) should order above %v (%d)", ordered[i-1], higher, ordered[i], lower)
+		}
+	}
+}
+
+func TestScore_String(t *testing.T) {
+	// act
+	cp, mate := POV(-35, 0, BlackPieces).Global().String(), Global(0, -2).String()
+
+	// assert
+	if cp != "0.35" || mate != "M-2" {
+		t.Errorf("got '%s' '%s', want '0.35' 'M-2'", cp, mate)
+	}
+}
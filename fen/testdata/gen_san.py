@@ -0,0 +1,21 @@
+#!/usr/bin/env python3
+"""Rewrites the san of each fen/uci case in the given golden files with python-chess, so
+UCItoSAN and SANtoUCI are checked against a SAN writer that isn't ours.
+
+    pip install chess
+    python3 fen/testdata/gen_san.py fen/testdata/fen_uci_san.json fen/testdata/san_edge_cases.json
+"""
+import json
+import sys
+
+import chess
+
+for filename in sys.argv[1:]:
+    with open(filename) as fp:
+        cases = json.load(fp)
+    for case in cases:
+        board = chess.Board(case["fen"])
+        case["san"] = board.san(chess.Move.from_uci(case["uci"]))
+    with open(filename, "w") as fp:
+        json.dump(cases, fp, separators=(",", ":"))
+        fp.write("\n")
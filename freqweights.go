@@ -0,0 +1,136 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"trollfish-lichess/epd"
+	"trollfish-lichess/fen"
+)
+
+// freqRatingBase is the opponent rating counted as one game, see FreqWeights.ByRating.
+const freqRatingBase = 2000
+
+// FreqWeights are how GetMostFrequentPGNPositions counts a position's games. The zero
+// value counts every game once.
+type FreqWeights struct {
+	WinsOnly bool          // only count games the side to move won
+	ByRating bool          // weight by the opponent of the side to move's rating over 2000, unrated games count once
+	HalfLife time.Duration // halve a game's weight every HalfLife since it was played, 0 = off
+}
+
+// weight returns how much a game counts for the positions with color to move.
+func (w FreqWeights) weight(game *fen.PGNGame, color fen.Color, now time.Time) float64 {
+	if w.WinsOnly {
+		if won := iif(color == fen.WhitePieces, fen.GameResult(fen.WhiteWon), fen.BlackWon); game.Result != won {
+			return 0
+		}
+	}
+
+	weight := 1.0
+	if w.ByRating {
+		if elo := iif(color == fen.WhitePieces, game.BlackElo, game.WhiteElo); elo > 0 {
+			weight *= float64(elo) / freqRatingBase
+		}
+	}
+	if w.HalfLife > 0 {
+		if played, ok := gameDate(game); ok && played.Before(now) {
+			weight *= math.Pow(0.5, float64(now.Sub(played))/float64(w.HalfLife))
+		}
+	}
+	return weight
+}
+
+// gameDate returns the day a game was played from its UTCDate or Date tag.
+func gameDate(game *fen.PGNGame) (time.Time, bool) {
+	for _, tag := range []string{"UTCDate", "Date"} {
+		if t, err := time.Parse("2006.01.02", game.Tags[tag]); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// weightedPositions returns the weighted count of games of each position, and each
+// position's moves weighted by the games they were played in.
+func weightedPositions(db fen.Database, w FreqWeights, now time.Time) (map[string]float64, map[string]map[string]float64) {
+	pos := make(map[string]float64)
+	moves := make(map[string]map[string]float64)
+
+	for _, game := range db.Games {
+		white := w.weight(game, fen.WhitePieces, now)
+		black := w.weight(game, fen.BlackPieces, now)
+
+		for fenKey, played := range game.Positions {
+			weight := iif(strings.Contains(fenKey, " w "), white, black)
+			if weight == 0 {
+				continue
+			}
+
+			pos[fenKey] += weight
+			if moves[fenKey] == nil {
+				moves[fenKey] = make(map[string]float64)
+			}
+			for _, move := range played {
+				moves[fenKey][move.SAN] += weight
+			}
+		}
+	}
+
+	return pos, moves
+}
+
+type freqMove struct {
+	SAN    string
+	Weight int // percent of the position's plays, at least 1
+}
+
+// frequentMoves returns the moves played at least cutoff times as often as the most played
+// one, most played first then by SAN, or just the most played for a cutoff of 0. It returns
+// "-" if there are no moves.
+func frequentMoves(moves map[string]float64, cutoff float64) []freqMove {
+	var total float64
+	sans := make([]string, 0, len(moves))
+	for san, weight := range moves {
+		total += weight
+		sans = append(sans, san)
+	}
+	if len(sans) == 0 {
+		return []freqMove{{SAN: "-"}}
+	}
+
+	sort.Slice(sans, func(i, j int) bool {
+		if moves[sans[i]] != moves[sans[j]] {
+			return moves[sans[i]] > moves[sans[j]]
+		}
+		return sans[i] < sans[j]
+	})
+
+	top := moves[sans[0]]
+	var list []freqMove
+	for i, san := range sans {
+		if i > 0 && (cutoff <= 0 || moves[san] < top*cutoff) {
+			break
+		}
+		list = append(list, freqMove{SAN: san, Weight: max(1, int(math.Round(100*moves[san]/total)))})
+	}
+	return list
+}
+
+// addFrequentMoves adds a position to f with its frequent moves as 'sm', one line per move
+// with its 'weight' when there are alternatives, see frequentMoves.
+func addFrequentMoves(f *epd.File, fenKey string, moves map[string]float64, cutoff float64) {
+	if cutoff <= 0 {
+		f.Add(fenKey, epd.Operation{OpCode: epd.OpCodeSuppliedMove, Value: frequentMoves(moves, 0)[0].SAN})
+		return
+	}
+	for _, move := range frequentMoves(moves, cutoff) {
+		f.Add(fenKey,
+			epd.Operation{OpCode: epd.OpCodeSuppliedMove, Value: move.SAN},
+			epd.Operation{OpCode: "weight", Value: strconv.Itoa(move.Weight)},
+		)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"trollfish-lichess/epd"
+	"trollfish-lichess/fen"
+)
+
+func TestFreqWeights_weight(t *testing.T) {
+	// arrange
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	game := &fen.PGNGame{
+		Result:   fen.WhiteWon,
+		WhiteElo: 1800,
+		BlackElo: 2200,
+		Tags:     fen.Tags{"UTCDate": "2023.01.01"},
+	}
+
+	cases := []struct {
+		name    string
+		weights FreqWeights
+		color   fen.Color
+		want    float64
+	}{
+		{name: "zero", color: fen.BlackPieces, want: 1},
+		{name: "wins only, winner", weights: FreqWeights{WinsOnly: true}, color: fen.WhitePieces, want: 1},
+		{name: "wins only, loser", weights: FreqWeights{WinsOnly: true}, color: fen.BlackPieces, want: 0},
+		{name: "rating", weights: FreqWeights{ByRating: true}, color: fen.WhitePieces, want: 1.1},
+		{name: "half life", weights: FreqWeights{HalfLife: 365 * 24 * time.Hour}, color: fen.WhitePieces, want: 0.5},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// act
+			got := c.weights.weight(game, c.color, now)
+
+			// assert
+			if math.Abs(got-c.want) > 1e-9 {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestWeightedPositions(t *testing.T) {
+	// arrange
+	const pgn = `[Result "1-0"]
+
+1. e4 e5 2. Nf3 Nc6 1-0
+
+[Result "0-1"]
+
+1. e4 e5 2. Bc4 Nc6 0-1
+
+[Result "0-1"]
+
+1. e4 e5 2. Bc4 Nc6 0-1
+`
+	filename := filepath.Join(t.TempDir(), "games.pgn")
+	if err := os.WriteFile(filename, []byte(pgn), 0644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := fen.LoadPGNDatabase(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	afterE5 := fen.FENtoBoard(startPosFEN)
+	afterE5.Moves("e2e4", "e7e5")
+
+	// act
+	pos, moves := weightedPositions(db, FreqWeights{WinsOnly: true}, time.Now())
+
+	// assert
+	if got := pos[afterE5.FENKey()]; got != 1 {
+		t.Errorf("count: got %v, want 1", got)
+	}
+	if got := frequentMoves(moves[afterE5.FENKey()], 0); len(got) != 1 || got[0].SAN != "Nf3" {
+		t.Errorf("move: got %v, want Nf3", got)
+	}
+}
+
+func TestAddFrequentMoves(t *testing.T) {
+	// arrange
+	startKey := fen.Key(startPosFEN)
+	moves := map[string]float64{"e4": 5, "d4": 4, "c4": 1}
+	cases := []struct {
+		cutoff float64
+		want   string
+	}{
+		{cutoff: 0, want: startKey + " sm e4;\n"},
+		{cutoff: 0.5, want: startKey + " sm e4; weight 50;\n" + startKey + " sm d4; weight 40;\n"},
+		{cutoff: 0.1, want: startKey + " sm e4; weight 50;\n" + startKey + " sm d4; weight 40;\n" + startKey + " sm c4; weight 10;\n"},
+	}
+
+	for _, c := range cases {
+		// act
+		f := epd.New()
+		addFrequentMoves(f, startKey, moves, c.cutoff)
+
+		// assert
+		if got := f.String(); got != c.want {
+			t.Errorf("cutoff %v: got\n%s\nwant\n%s", c.cutoff, got, c.want)
+		}
+	}
+}
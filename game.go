@@ -1,121 +1,235 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"os"
-	"strconv"
+	"math/rand"
 	"strings"
 	"sync"
 	"time"
 
+	"trollfish-lichess/analyze"
 	"trollfish-lichess/api"
+	"trollfish-lichess/commas"
+	"trollfish-lichess/eventbus"
 	"trollfish-lichess/fen"
+	"trollfish-lichess/history"
 	"trollfish-lichess/polyglot"
+	"trollfish-lichess/storage"
 	"trollfish-lichess/yamlbook"
 )
 
+// recentFilename collects out-of-book positions from our games for later analysis.
+const recentFilename = "recent.epd"
+
 type Game struct {
 	sync.Mutex
 
+	ctx    context.Context
+	cancel context.CancelFunc
+	events chan gameEvent
+	done   chan struct{}
+
+	// fields below are set on the event loop and read by other goroutines under the lock
+	state       gameState
 	gameID      string
-	initialFEN  string
 	playerColor fen.Color
 	rated       bool
-	gaveTime    bool
 	opponent    api.Player
-	finished    bool
+	perf        string
+	ourRating   int
+	createdAt   int64
+	status      string
+	result      string
+	canGiveTime bool // cleared by AddTime goroutines
+	moveStats   []history.MoveStats
+	opening     []string // SAN, start position games only
+	openingName string   // ECO code and name when we left book
+	bookPlies   int
+	exitEval    *int
+	operator    Operator // set through the admin server
+	turnFEN     string   // the position while it's our turn, empty otherwise
+
+	// fields below are only used on the event loop
+	initialFEN string
+	clocks     [2]int // white's and black's time left in the last game state, ms
+	gaveTime   bool
+	leftBook   bool // we've run an engine search for one of our moves
 
 	chatPlayerRoomNoTalking    bool
 	chatSpectatorRoomNoTalking bool
 
-	input  chan<- string
-	output <-chan string
+	engine        *Engine
+	engineOptions []string // the game's setoption commands, sent again if the engine restarts
+	sendMove      func(gameID, move string, draw bool) error
+	resignGame    func(gameID string) error
+	fetchGame     func(gameID string) (api.CompletedGame, error)
+	resign        bool // the engine recommended resigning and our eval agrees
+
+	tournamentID   string         // the arena, empty outside of arenas
+	arm            *ExperimentArm // the experiment arm this game plays, nil for none
+	arenaLostMoves int            // see arenaLost
+	opponentClock  opponentClock
+	stream         streamWatch // the game stream, for the watchdog
+
+	store     storage.Storage
+	audit     *Audit
+	moveAudit *MoveAudit // the move being decided, nil between moves
+	searched  *BestMove  // the search behind the move being decided, nil for book moves
 
 	book            *yamlbook.Book
+	variety         *Variety
+	opts            GameOptions
+	limited         bool // strength limits apply to this game
+	clock           Clock
+	rand            *rand.Rand
 	bookMovesPlayed int
+	positionsFed    int // book moves the engine followed without a search, see followBookMove
 	ponder          string
-	pondering       bool
+	ponderSearch    *Search
+	prediction      string              // the ponder move PonderControl didn't ponder, still scored
+	ponderOff       string              // why PonderControl turned pondering off, printed when it changes
+	premove         *premove            // our forced reply to the predicted move, see GameOptions.Premove
+	bookPonder      *bookPonder         // search of the book's line ahead, see startBookPonder
+	bookPonderEvals map[string]BestMove // its results by FEN key
 	ponderHits      int
 	totalPonders    int
 	humanEval       string
 	lastStateEvent  time.Time
 	aboutToMate     bool
-	canGiveTime     bool
 
-	books []*polyglot.Book
+	bookSources BookChain
+
+	bookExit     bookExit
+	reviewQueued int
+	deviations   []deviation // opponent moves out of our book, see BookDeviations
+	lastMoment   *evalMoment // our eval before our last engine move, see checkMoment
 
 	consecutiveFullMovesWithZeroEval int
 
-	moves      []SavedMove
-	seenPos    map[string]int
-	playerBook map[string]MoveChances
+	moves []SavedMove
 }
 
 type SavedMove struct {
 	FEN     string
 	MoveSAN string
+	Think   time.Duration // from the game state to sending it, our moves only
+}
+
+const (
+	engineReadyTimeout = 10 * time.Second // isready -> readyok
+	engineMoveGrace    = 5 * time.Second  // added to our clock time when waiting for a search
+)
+
+var errEngineTimeout = errors.New("timed out waiting for engine")
+
+// GameOptions are the bot's settings for every game.
+type GameOptions struct {
+	Strength    Strength // limits in casual games against humans
+	BookCheckCP int      // cp a book move may lose to the engine's move before it's replaced, 0 = off
+
+	BookPonderPlies int // plies of the book's most probable line analyzed ahead on the opponent's time while in book, 0 = off
+
+	Premove bool // forced replies (the only legal move) to the predicted move sent as the state arrives, off by default
+
+	AnnounceOpening bool // say the opening's name in the spectator chat when we leave book
+
+	BroadcastRound string       // lichess broadcast round the session's games are pushed to
+	Broadcast      *Broadcaster // started for BroadcastRound by runLichessBot
+
+	GIF string // GIF saved of each finished game: gifThumbnail, gifFull or empty for none
+
+	ArenaResign ArenaResign // resigning lost arena games early, off by default
+	Swindle     Swindle     // trickier lines when lost and the opponent is short of time, off by default
+
+	OpponentClock OpponentClock // steering by the opponent's time usage, off by default
+
+	Endgames Endgames // known theoretical endings' technique in fast games, off by default
+
+	ExperimentFile string      // engine option and book arms alternated across games
+	Experiment     *Experiment // loaded from ExperimentFile by runLichessBot
+
+	Bus *eventbus.Bus // challenge, game, book and move events, see events.go; nil for none
+
+	Clockless ClocklessSearch // unlimited and correspondence games
+
+	Deviations BookDeviations // positions where the opponent left our book, queued after the game
+
+	Panic PanicTime // a fallback move when the engine hangs instead of flagging
+
+	Scramble Scramble // instant moves when both flags are low
+
+	PonderControl *PonderControl // pondering off by hit rate and load, nil to always ponder
+
+	SummaryJSON bool // print the game's summary as JSON when it finishes, see Game.Summary
 }
 
-func NewGame(gameID string, input chan<- string, output <-chan string, book *yamlbook.Book) *Game {
-	return &Game{
+// NewGame returns a game and starts its event loop, which runs until the game finishes,
+// ctx is done or Finish is called.
+func NewGame(ctx context.Context, gameID string, store storage.Storage, engine *Engine, book *yamlbook.Book, variety *Variety, opts GameOptions, audit *Audit) *Game {
+	ctx, cancel := context.WithCancel(ctx)
+	g := Game{
+		ctx:         ctx,
+		cancel:      cancel,
+		events:      make(chan gameEvent, 16),
+		done:        make(chan struct{}),
 		gameID:      gameID,
 		playerColor: -999,
-		input:       input,
-		output:      output,
+		engine:      engine,
+		sendMove:    api.PlayMove,
+		resignGame:  api.Resign,
+		fetchGame:   api.GetGame,
 		book:        book,
-		seenPos:     make(map[string]int),
+		variety:     variety,
+		opts:        opts,
+		rand:        rand.New(rand.NewSource(time.Now().UnixNano())),
+		store:       store,
+		audit:       audit,
 		canGiveTime: true,
+
+		opponentClock: newOpponentClock(),
 	}
+
+	go g.run()
+
+	return &g
 }
 
 func (g *Game) StreamGameEvents() {
 	endpoint := fmt.Sprintf("https://lichess.org/api/bot/game/stream/%s", g.gameID)
 
-	handler := func(ndjson []byte) bool {
-		var event api.Event
-		if err := json.Unmarshal(ndjson, &event); err != nil {
-			log.Fatal(err)
-		}
-
-		switch event.Type {
-		case "gameFull":
-			g.handleGameFull(ndjson)
-		case "gameState":
-			g.handleGameState(ndjson)
-		case "chatLine":
-			g.handleChat(ndjson)
-		default:
-			fmt.Printf("%s *** unhandled event type: '%s'\n", ts(), event.Type)
-		}
-
-		return true
-	}
-
 	fmt.Printf("%s start game stream '%s'\n", ts(), g.gameID)
-	if err := api.ReadStream(endpoint, handler); err != nil {
+	if err := readStreamRetrying(g.ctx, endpoint, &g.stream, nil, nil, g.post); err != nil {
 		log.Printf("ERR: StreamGame: %v\n", err)
 	}
 }
 
-func (g *Game) IsFinished() bool {
-	g.Lock()
-	defer g.Unlock()
-	return g.finished
-}
-
-func (g *Game) Finish() {
-	g.Lock()
-	defer g.Unlock()
-
-	if g.finished {
+// finish stops pondering, drains the engine, saves the game and prints a summary. Called on
+// the event loop.
+func (g *Game) finish() {
+	if g.IsFinished() {
 		return
 	}
 	g.stopPondering()
-	g.finished = true
+	g.setState(stateFinished)
+
+	// the game's context may be done already; the engine must still be left idle
+	if err := g.engine.Drain(context.Background(), engineReadyTimeout); err != nil {
+		fmt.Printf("%s *** ERR: engine not idle after game %s: %v\n", ts(), g.gameID, err)
+	}
 
 	g.saveToRecent()
+	g.saveToPGN()
+	g.saveGIF()
+	if g.opts.Broadcast != nil {
+		g.opts.Broadcast.Update(g.gameID, g.PGN())
+	}
+	g.saveToVariety()
+	g.saveReviewQueue()
+	g.saveDeviations()
 
 	var sb strings.Builder
 	for _, move := range g.moves {
@@ -129,34 +243,71 @@ func (g *Game) Finish() {
 	}
 	sb.WriteByte('\n')
 
-	sb.WriteString(fmt.Sprintf("%d book move(s) played\n", g.bookMovesPlayed))
+	sb.WriteString(fmt.Sprintf("%d book move(s) played, %d position(s) fed to the engine without a search\n", g.bookMovesPlayed, g.positionsFed))
 	sb.WriteString(fmt.Sprintf("%d/%d predictions played\n", g.ponderHits, g.totalPonders))
 
 	fmt.Print(sb.String())
-}
 
-func (g *Game) saveToRecent() {
-	fp, err := os.OpenFile("recent.epd", os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
-		log.Fatal(err)
+	if g.opts.SummaryJSON {
+		b, err := json.Marshal(g.Summary(g.HistoryRecord()))
+		if err != nil {
+			log.Printf("ERR: game summary: %v\n", err)
+			return
+		}
+		fmt.Printf("%s game summary: %s\n", ts(), b)
 	}
-	defer func() {
-		_ = fp.Sync()
-		_ = fp.Close()
-	}()
+}
 
+func (g *Game) saveToRecent() {
+	var sb strings.Builder
 	for _, move := range g.moves {
 		b := fen.FENtoBoard(move.FEN)
 
 		ourMove := b.ActiveColor == g.playerColor
 		_, found := g.book.GetAll(move.FEN)
 		if !found && ourMove && b.FullMove <= 25 {
-			_, err = fmt.Fprintf(fp, "- fen: %s\n", fen.Key(move.FEN))
-			if err != nil {
-				log.Fatal(err)
-			}
+			sb.WriteString(fmt.Sprintf("- fen: %s\n", fen.Key(move.FEN)))
 		}
 	}
+
+	if sb.Len() == 0 {
+		return
+	}
+
+	if err := g.store.AppendFile(recentFilename, []byte(sb.String())); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (g *Game) saveToVariety() {
+	if g.initialFEN != "startpos" {
+		return
+	}
+
+	sans := make([]string, 0, len(g.moves))
+	for _, move := range g.moves {
+		sans = append(sans, move.MoveSAN)
+	}
+
+	if err := g.variety.Record(g.gameID, g.playerColor, sans); err != nil {
+		log.Printf("ERR: variety: %v\n", err)
+	}
+}
+
+func (g *Game) varietyBias(sans []string) yamlbook.MoveBias {
+	if g.initialFEN != "startpos" {
+		return nil
+	}
+	return g.variety.Bias(g.playerColor, sans)
+}
+
+// bookFilter is the tag and rating band policy for book moves in this game.
+func (g *Game) bookFilter() yamlbook.MoveFilter {
+	band := yamlbook.ForRating(g.opponent.Rating)
+	if g.opponent.Title == "BOT" {
+		return yamlbook.AllOf(yamlbook.ExcludeTags(yamlbook.TagHumanOnly), band)
+	}
+	return band
 }
 
 func (g *Game) handleChat(ndjson []byte) {
@@ -196,7 +347,7 @@ func (g *Game) handleChat(ndjson []byte) {
 	}*/
 }
 
-func (g *Game) handleGameFull(ndjson []byte) {
+func (g *Game) handleGameFull(ctx context.Context, ndjson []byte) {
 	var game api.GameFull
 	if err := json.Unmarshal(ndjson, &game); err != nil {
 		log.Fatal(err)
@@ -204,23 +355,36 @@ func (g *Game) handleGameFull(ndjson []byte) {
 
 	state := game.State
 
+	if g.initialFEN != "" {
+		// the stream reconnected, lichess sends the game again
+		g.resume(ctx, ndjson, state)
+		return
+	}
+
 	if state.Status != "started" {
 		return
 	}
 
+	g.Lock()
 	if game.White.ID == botID {
 		g.playerColor = fen.WhitePieces
 		g.opponent = game.Black
+		g.ourRating = game.White.Rating
 	} else if game.Black.ID == botID {
 		g.playerColor = fen.BlackPieces
 		g.opponent = game.White
+		g.ourRating = game.Black.Rating
 	} else {
 		log.Fatalf("not your game %s vs %s\n", game.White.ID, game.Black.ID)
 	}
 
 	g.rated = game.Rated
+	g.tournamentID = game.TournamentID
+	g.perf = game.Speed
+	g.createdAt = game.CreatedAt
+	g.Unlock()
 	g.initialFEN = game.InitialFEN
-	if g.initialFEN == "" {
+	if g.initialFEN == "" || g.initialFEN == startPosFEN {
 		g.initialFEN = "startpos"
 	}
 
@@ -233,9 +397,11 @@ func (g *Game) handleGameFull(ndjson []byte) {
 		rated = "Unrated"
 	}
 
-	initialTime := time.Duration(game.Clock.Initial) * time.Millisecond
-	increment := time.Duration(game.Clock.Increment) * time.Millisecond
-	timeControl := fmt.Sprintf("%v+%v", initialTime, increment)
+	g.clock = detectGameClock(game, g.opts.Clockless)
+	timeControl := g.clock.String()
+	if g.clock.Type != ClockFischer {
+		timeControl += fmt.Sprintf(" (%s)", g.clock.Type)
+	}
 
 	fmt.Printf("%s *** New game! %s (%d) vs. %s (%d) %s %s\n",
 		ts(),
@@ -245,12 +411,15 @@ func (g *Game) handleGameFull(ndjson []byte) {
 		timeControl,
 	)
 
+	repertoire := &yamlBookSource{g: g}
+	var player *playerBookSource
+	var polyglots []BookSource
 	if g.opponent.Title != "BOT" {
 		m, err := Busted(strings.ToLower(g.opponent.ID)+".pgn", g.playerColor)
 		if err != nil {
 			fmt.Printf("%s ?-?-?-?-? %v\n", ts(), err)
 		} else {
-			g.playerBook = m
+			player = &playerBookSource{moves: m, repertoire: repertoire}
 		}
 	}
 
@@ -276,34 +445,70 @@ func (g *Game) handleGameFull(ndjson []byte) {
 			panic(err)
 		}
 
-		g.books = append(g.books, gm2600, elo2400, performance, varied, cerebellum3Merge)
+		for i, book := range []*polyglot.Book{gm2600, elo2400, performance, varied, cerebellum3Merge} {
+			book.Extract = g.store
+			polyglots = append(polyglots, &polyglotSource{book: book, index: i})
+		}
 	}
 
-	g.waitReady()
+	// player book -> repertoire -> polyglot books
+	g.bookSources = nil
+	if player != nil {
+		g.bookSources = append(g.bookSources, player)
+	}
+	g.bookSources = append(g.bookSources, repertoire)
+	g.bookSources = append(g.bookSources, polyglots...)
 
-	if game.Rated || g.opponent.Title != "BOT" {
-		g.input <- "setoption name PlayBad value false"
-	} else {
-		g.input <- "setoption name PlayBad value true"
+	if n := g.engine.PendingSearches(); n != 0 {
+		fmt.Printf("%s *** %d search(es) left from the last game, draining\n", ts(), n)
+	}
+	if err := g.engine.Drain(ctx, engineReadyTimeout); err != nil {
+		fmt.Printf("%s *** ERR: %v\n", ts(), err)
+		return
+	}
+
+	g.limited = g.opts.Strength.Enabled() && !game.Rated && g.opponent.Title != "BOT"
+	options := g.opts.Strength.Options(g.limited)
+	if g.limited {
+		fmt.Printf("%s casual game, playing at %s\n", ts(), g.opts.Strength)
+		if g.opts.Strength.Announce {
+			msg := fmt.Sprintf("Casual game, so I'm taking it easy: playing at %s.", g.opts.Strength)
+			if err := api.Chat(g.gameID, "player", msg); err != nil {
+				fmt.Printf("%s ERR: api.Chat: %v\n", ts(), err)
+			}
+		}
 	}
 
 	if game.Rated && g.opponent.Title == "BOT" {
-		g.input <- "setoption name StartAgro value true"
+		options = append(options, "setoption name StartAgro value true")
 	} else {
-		g.input <- "setoption name StartAgro value false"
+		options = append(options, "setoption name StartAgro value false")
 		if err := api.AddTime(g.gameID, 300+180); err != nil {
 			log.Printf("AddTime: %v\n", err)
 		}
 	}
 
-	g.input <- "ucinewgame"
+	if g.arm != nil {
+		fmt.Printf("%s experiment %s: playing arm %s\n", ts(), g.opts.Experiment.Name, g.arm.Name)
+		options = append(options, g.arm.SetOptions()...)
+	}
+
+	g.Lock()
+	g.engineOptions = options
+	g.Unlock()
+	_ = g.engine.Send(options...)
+
+	_ = g.engine.Send("ucinewgame")
 
-	g.waitReady()
+	if err := g.engine.WaitReady(ctx, engineReadyTimeout); err != nil {
+		fmt.Printf("%s *** ERR: %v\n", ts(), err)
+		return
+	}
 
-	g.playMove(ndjson, state)
+	g.playMove(ctx, ndjson, state)
 }
 
-func (g *Game) handleGameState(ndjson []byte) {
+func (g *Game) handleGameState(ctx context.Context, ndjson []byte) {
 	g.lastStateEvent = time.Now()
 
 	var state api.State
@@ -312,13 +517,11 @@ func (g *Game) handleGameState(ndjson []byte) {
 	}
 	state.MessageReceived = time.Now()
 
+	g.setResult(state)
+	g.clocks = [2]int{state.WhiteTime, state.BlackTime}
+
 	if state.Winner != "" {
-		var color string
-		if g.playerColor == fen.WhitePieces {
-			color = "white"
-		} else if g.playerColor == fen.BlackPieces {
-			color = "black"
-		}
+		color := g.colorName()
 
 		fmt.Printf("winner: %s rated: %v our_color: %s\n", state.Winner, g.rated, color)
 		if !g.rated && state.Winner != color && state.Winner != "" {
@@ -336,19 +539,81 @@ func (g *Game) handleGameState(ndjson []byte) {
 		fmt.Printf("%s state.Status: '%s'\n", ts(), state.Status)
 	}
 
-	g.playMove(ndjson, state)
+	g.playMove(ctx, ndjson, state)
 }
 
-func (g *Game) playMove(ndjson []byte, state api.State) {
-	start := time.Now()
+func (g *Game) colorName() string {
+	if g.playerColor == fen.WhitePieces {
+		return "white"
+	} else if g.playerColor == fen.BlackPieces {
+		return "black"
+	}
+	return ""
+}
+
+func (g *Game) setResult(state api.State) {
+	if state.Status == "" || state.Status == "started" || state.Status == "created" {
+		return
+	}
 
 	g.Lock()
-	if g.finished {
-		g.Unlock()
+	defer g.Unlock()
+
+	g.status = state.Status
+
+	switch {
+	case state.Status == "aborted" || state.Status == "noStart":
+		g.result = ""
+	case state.Winner == "":
+		g.result = history.Draw
+	case state.Winner == g.colorName():
+		g.result = history.Win
+	default:
+		g.result = history.Loss
+	}
+}
+
+// HistoryRecord returns the finished game for the history database. RatingAfter is 0 for
+// rated games, the caller fills it in once lichess reports the diff.
+func (g *Game) HistoryRecord() history.Game {
+	g.Lock()
+	defer g.Unlock()
+
+	var experiment, arm string
+	if g.arm != nil {
+		experiment, arm = g.opts.Experiment.Name, g.arm.Name
+	}
+
+	return history.Game{
+		ID:             g.gameID,
+		TS:             g.createdAt / 1000,
+		Rated:          g.rated,
+		Perf:           g.perf,
+		Color:          g.colorName(),
+		Opponent:       g.opponent.Name,
+		OpponentTitle:  g.opponent.Title,
+		OpponentRating: g.opponent.Rating,
+		RatingBefore:   g.ourRating,
+		RatingAfter:    iif(g.rated, 0, g.ourRating),
+		Result:         g.result,
+		Status:         g.status,
+		Moves:          append([]history.MoveStats(nil), g.moveStats...),
+		Opening:        append([]string(nil), g.opening...),
+		BookPlies:      g.bookPlies,
+		ExitEval:       g.exitEval,
+		OpeningName:    g.openingName,
+		Experiment:     experiment,
+		Arm:            arm,
+	}
+}
+
+func (g *Game) playMove(ctx context.Context, ndjson []byte, state api.State) {
+	start := time.Now()
+
+	if g.IsFinished() {
 		fmt.Printf("GAME FINISHED: %s\n", string(ndjson))
 		return
 	}
-	g.Unlock()
 
 	var opponentTime, ourTime time.Duration
 	if g.playerColor == fen.WhitePieces {
@@ -358,6 +623,11 @@ func (g *Game) playMove(ndjson []byte, state api.State) {
 		ourTime = time.Duration(state.BlackTime) * time.Millisecond
 		opponentTime = time.Duration(state.WhiteTime) * time.Millisecond
 	}
+	if g.clock.Type == ClockNone {
+		// no clock data, the clock-based policies get ClocklessSearch's nominal time
+		ourTime, opponentTime = g.clock.Clockless.clock(), g.clock.Clockless.clock()
+		state.WhiteTime, state.BlackTime = int(ourTime.Milliseconds()), int(ourTime.Milliseconds())
+	}
 
 	moves := strings.Split(state.Moves, " ")
 	if len(moves) == 1 && len(moves[0]) == 0 {
@@ -365,6 +635,14 @@ func (g *Game) playMove(ndjson []byte, state api.State) {
 	}
 
 	board := fen.FENtoBoard(g.initialFEN)
+	if err := checkMoves(board, moves); err != nil {
+		fmt.Printf("%s *** ERR: game state: %v, fetching the game\n", ts(), err)
+		var fetchErr error
+		if moves, fetchErr = g.fetchMoves(); fetchErr != nil {
+			fmt.Printf("%s *** ERR: resync: %v\n", ts(), fetchErr)
+			return
+		}
+	}
 	sans := board.UCItoSANs(moves...)
 	moves, _ = board.SANtoUCIs(sans...)
 	state.Moves = strings.Join(moves, " ")
@@ -372,6 +650,9 @@ func (g *Game) playMove(ndjson []byte, state api.State) {
 	board2 := board
 	board2.Moves(moves...)
 	if board2.ActiveColor != g.playerColor {
+		if g.ponderSearch == nil {
+			g.setState(stateWaitingOpponent)
+		}
 		fmt.Printf("%s waiting for opponent...\n", ts())
 		return
 	}
@@ -380,6 +661,8 @@ func (g *Game) playMove(ndjson []byte, state api.State) {
 		return
 	}
 
+	g.setState(stateThinking)
+
 	var ponderHit bool
 
 	if len(moves) > 1 {
@@ -388,14 +671,20 @@ func (g *Game) playMove(ndjson []byte, state api.State) {
 		playedSAN := board.UCItoSAN(opponentMoveUCI)
 
 		g.storeMove(board.FEN(), playedSAN)
+		g.trackDeviation(board, playedSAN, len(moves))
 
-		if g.ponder != "" && g.pondering {
+		if g.ponder != "" && g.ponderSearch != nil {
 			predictedSAN := board.UCItoSAN(g.ponder)
 			fmt.Printf("%s their move: %s predicted: %s\n", ts(), playedSAN, predictedSAN)
 			if g.ponder == opponentMoveUCI {
 				g.ponderHits++
 				ponderHit = true
 			}
+			g.opts.PonderControl.record(g.perf, ponderHit)
+		} else if g.prediction != "" {
+			predictedSAN := board.UCItoSAN(g.prediction)
+			fmt.Printf("%s their move: %s predicted (not pondered): %s\n", ts(), playedSAN, predictedSAN)
+			g.opts.PonderControl.record(g.perf, g.prediction == opponentMoveUCI)
 		} else {
 			fmt.Printf("%s their move: %s\n", ts(), playedSAN)
 		}
@@ -411,239 +700,456 @@ func (g *Game) playMove(ndjson []byte, state api.State) {
 	}
 
 	g.ponder = ""
+	g.prediction = ""
+	g.collectBookPonder()
+
+	var opponentMoveUCI string
+	if len(moves) > 0 {
+		opponentMoveUCI = moves[len(moves)-1]
+	}
+	premove := g.takePremove(board, opponentMoveUCI)
+
+	rec := g.audit.Begin(g.gameID, len(moves), board.FEN(), state.MessageReceived, ourTime, opponentTime)
+	if rec != nil {
+		rec.PonderHit = ponderHit
+	}
+	g.moveAudit = rec
+	g.searched = nil
+	defer func() {
+		g.moveAudit = nil
+		if err := g.audit.Write(rec); err != nil {
+			log.Printf("ERR: audit: %v\n", err)
+		}
+	}()
 
 	var bestMove string
+	var afterSend []func() // a scramble's bookkeeping, once its move is out
 
-	// check book
-	fenKey := board.FENKey()
-	var bookMoveUCI, bookPonderUCI string
-	var bookMoveCP, bookMoveMate int
-	if g.playerBook != nil {
-		moves, ok := g.playerBook[fenKey]
-		if ok {
-			bestMove := moves.BestMove()
-			if bestMove != nil {
-				bookMoveUCI = bestMove.MoveUCI
-				bookPonderUCI = bestMove.PonderUCI
-				bookMoveCP, bookMoveMate = 55555, 0
-
-				// check book to get eval
-				var bookMove2 *yamlbook.Move
-				bookMove2, bookPonderUCI2 := g.book.BestMove(fenKey)
-				if bookMove2 != nil && bookMove2.Move == bestMove.MoveSAN {
-					bookMoveCP, bookMoveMate = bookMove2.CP, bookMove2.Mate
-					bookPonderUCI = bookPonderUCI2
-				}
+	g.setTurnFEN(board.FEN())
+	defer g.setTurnFEN("")
 
-				fmt.Printf("%s %s '%s' %s\n", ts(), bestMove.MoveSAN, fenKey, bestMove.GameText)
-			}
+	bookSources := g.bookSources
+	if len(moves) > 0 {
+		faced := fen.FENtoBoard(g.moves[len(g.moves)-1].FEN)
+		if g.watchOpponentClock(len(moves), faced, opponentTime) {
+			bookSources = nil
 		}
 	}
 
-	// check yaml book
-	if board.FEN() != startPosFEN && bookMoveUCI == "" {
-		var bookMove *yamlbook.Move
-		bookMove, bookPonderUCI = g.book.BestMove(fenKey)
-		if bookMove != nil {
-			bookMoveUCI = bookMove.UCI()
-			bookMoveCP, bookMoveMate = bookMove.CP, bookMove.Mate
-		}
+	turn := turnInput{
+		Color:         g.playerColor,
+		WhiteTime:     state.WhiteTime,
+		BlackTime:     state.BlackTime,
+		Clock:         g.clock,
+		GoLimits:      iif(g.limited, g.opts.Strength.GoLimits(), ""),
+		PonderHit:     ponderHit,
+		OperatorThink: g.takeOperatorThink(),
+	}
+	if g.opts.Scramble.applies(g.clock, ourTime, opponentTime) {
+		turn.Scramble = g.opts.Scramble.Depth
 	}
+	if turn.OperatorThink > 0 || premove != nil || turn.Scramble > 0 {
+		bookSources = nil
+	}
+	turn.Premove = premove
 
-	// check polyglot books
-	if board.FEN() != startPosFEN && bookMoveUCI == "" {
-		for bookIndex, book := range g.books {
-			bookMoveUCI, _ = book.BestMove(fenKey)
-			if bookMoveUCI != "" {
-				fmt.Printf("%s !-!-!-!-!-! Polyglot book index %d: move %s\n", ts(), bookIndex, bookMoveUCI)
-				break
-			}
-		}
+	// check book
+	fenKey := board.FENKey()
+	bookPos := BookPosition{FEN: board.FEN(), FENKey: fenKey, SANs: sans}
+	if bookMove, source, ok := bookSources.Lookup(bookPos); ok {
+		turn.Book = &bookMove
+		decision := BookDecision{Book: source, Move: iif(bookMove.SAN != "", bookMove.SAN, bookMove.UCI), CP: bookMove.CP, Mate: bookMove.Mate, HasEval: bookMove.HasEval, Text: bookMove.Text}
+		rec.AddBook(decision)
+		eventbus.Publish(g.opts.Bus, BookDecided{GameID: g.gameID, Ply: len(moves), Decision: decision})
 	}
 
-	_, repetition := g.seenPos[fenKey]
-	g.seenPos[fenKey] += 1
-	if repetition {
-		fmt.Printf("%s %s - REPETITONS: %d\n", ts(), fenKey, g.seenPos[fenKey])
-		g.input <- "setoption name StartAgro value true"
+	reps := newRepetitions(g.initialFEN, moves)
+	turn.BookRepeats = turn.Book != nil && reps.Repeats(turn.Book.UCI)
+
+	plan := planTurn(turn)
+	if plan.Action == actionBook && !g.checkBookMove(ctx, board, state, fenKey, plan.Book.UCI, ourTime) {
+		turn.BookRejected = true
+		plan = planTurn(turn)
+	}
+	for _, note := range plan.Notes {
+		fmt.Printf("%s %s\n", ts(), note)
+		rec.Note("%s", note)
 	}
 
-	if bookMoveUCI != "" && !repetition {
-		bestMove = bookMoveUCI
-		povMultiplier := iif(g.playerColor == fen.WhitePieces, 1, -1)
-		g.humanEval = iif(bookMoveMate == 0, fmt.Sprintf("%0.2f", float64(bookMoveCP*povMultiplier)/100), fmt.Sprintf("M%d", bookMoveMate*povMultiplier))
+	if plan.Action == actionPremove {
+		bestMove = plan.Move
+		fmt.Printf("%s %s - PREMOVE: %s (%s)\n", ts(), board.FEN(), board.UCItoSAN(bestMove), bestMove)
+		if rec != nil {
+			rec.Source = "premove"
+		}
+
+		// the engine follows it like a book move
+		g.followBookMove(state, bestMove, "")
+	} else if plan.Action == actionBook {
+		bookMove := plan.Book
+		bestMove = bookMove.UCI
+		g.humanEval = fen.POV(bookMove.CP, bookMove.Mate, g.playerColor).Global().String()
 
 		fmt.Printf("%s %s - BOOK MOVE: %s (%s), eval %s\n", ts(), board.FEN(), board.UCItoSAN(bestMove), bestMove, g.humanEval)
 		g.bookMovesPlayed++
-
-		if ponderHit {
-			g.ponderHit()
-			g.consumeBestMove()
-		} else {
-			g.stopPondering()
+		g.trackBookExit(fenKey, bookMove.HasEval)
+		if rec != nil {
+			rec.Source = "book"
 		}
 
-		if bookPonderUCI != "" {
-			g.ponderMove(bookPonderUCI, state, bestMove)
-		}
+		g.followBookMove(state, bestMove, bookMove.PonderUCI)
 	} else {
-		if ponderHit {
-			g.ponderHit()
+		var search *Search
+		if plan.Action == actionPonderHit {
+			search = g.ponderHit()
+			if plan.Scramble {
+				_ = g.engine.Send("stop")
+			}
 		} else {
 			g.stopPondering()
 
-			var pos string
-			addPosFen := iif(g.initialFEN == "startpos", "", "fen ")
-			if state.Moves == "" {
-				pos = fmt.Sprintf("position %s%s", addPosFen, g.initialFEN)
-			} else {
-				pos = fmt.Sprintf("position %s%s moves %s", addPosFen, g.initialFEN, state.Moves)
+			var err error
+			if search, err = g.engine.Go(g.positionCommand(state.Moves), plan.GoCmd); err != nil {
+				fmt.Printf("%s *** ERR: go: %v\n", ts(), err)
+				rec.Fail(err)
+				return
 			}
+		}
 
-			goCmd := fmt.Sprintf("go wtime %d winc %d btime %d binc %d",
-				state.WhiteTime, state.WhiteInc,
-				state.BlackTime, state.BlackInc,
-			)
+		fmt.Printf("%s thinking...\n", ts())
 
-			g.input <- pos
-			g.input <- goCmd
+		var fallback func() string
+		if !plan.Scramble {
+			fallback = func() string { return panicFallback(board, turn.Book) }
+		}
+		result, panicked, err := g.waitSearch(ctx, search, plan.Wait, ourTime, fallback)
+		if err != nil {
+			fmt.Printf("%s *** ERR: waiting for bestmove: %v\n", ts(), err)
+			rec.Fail(err)
+			return
+		}
+		if ctx.Err() != nil {
+			rec.Fail(ctx.Err())
+			return
 		}
 
-		fmt.Printf("%s thinking...\n", ts())
+		g.searched = &result
+		if rec != nil {
+			rec.Source = iif(panicked, "panic", iif(plan.Scramble, "scramble", "engine"))
+		}
+		record := func() {
+			rec.AddSearch(search, result)
+			if panicked {
+				rec.Note("no bestmove in time, played the fallback move %s", board.UCItoSAN(result.Move))
+			}
+			g.trackDeviationReply(board, len(moves), result.Move, result.Eval)
+			g.checkMoment(board, len(moves), result.Eval)
+		}
 
-		for item := range g.output {
-			if g.IsFinished() {
+		bestMove = result.Move
+		if result.Ponder != "" {
+			g.ponderMove(result.Ponder, state, bestMove)
+		}
+		if result.Eval != "" {
+			g.setEval(result.Eval)
+		}
+		if plan.Scramble {
+			afterSend = append(afterSend, record)
+		} else {
+			record()
+		}
+		g.handleHints(board, result.Hints)
+		resignNote := "resigned on the engine's hint at eval %s"
+		if !g.resign && g.arenaLost(board, ourTime, opponentTime) {
+			g.resign = true
+			resignNote = "resigned the lost arena game at eval %s"
+		}
+		if g.resign {
+			fmt.Printf("%s resigning at eval %s\n", ts(), g.humanEval)
+			rec.Note(resignNote, g.humanEval)
+			g.stopPondering()
+			err := g.resignGame(g.gameID)
+			if err == nil {
 				return
 			}
+			fmt.Printf("%s *** ERR: api.Resign: %v\n", ts(), err)
+			g.resign = false
+		}
+		if !g.leftBook {
+			g.leftBook = true
+			g.Lock()
+			g.bookPlies = len(moves)
+			if result.Eval != "" {
+				score := evalToScore(g.humanEval, g.playerColor)
+				g.exitEval = &score
+			}
+			g.Unlock()
 
-			// bestmove and ponder
-			if strings.HasPrefix(item, "bestmove") {
-				p := strings.Split(item, " ")
-				bestMove = p[1]
-				for i := 2; i < len(p)-1; i++ {
-					if p[i] == "ponder" {
-						g.ponderMove(p[i+1], state, bestMove)
-					} else if p[i] == "eval" {
-						g.humanEval = p[i+1]
-						if g.humanEval == "0.00" {
-							g.consecutiveFullMovesWithZeroEval++
-						} else {
-							g.consecutiveFullMovesWithZeroEval = 0
-						}
-
-						g.aboutToMate = false
-
-						if strings.HasPrefix(g.humanEval, "M") {
-							mateText := g.humanEval[1:]
-							mate, _ := strconv.Atoi(mateText)
-							if g.playerColor == fen.WhitePieces && mate > 0 {
-								g.aboutToMate = true
-							} else if g.playerColor == fen.BlackPieces && mate < 0 {
-								g.aboutToMate = true
-							}
-						} else {
-							cp, _ := strconv.ParseFloat(g.humanEval, 64)
-							if g.playerColor == fen.WhitePieces && cp >= 150 {
-								g.aboutToMate = true
-							} else if g.playerColor == fen.BlackPieces && cp <= -150 {
-								g.aboutToMate = true
-							}
-						}
-					}
+			leaveBook := func() {
+				if warm, ok := g.bookPonderEvals[fenKey]; ok {
+					fmt.Printf("%s out of book in the position analyzed ahead: %s eval %s, now %s eval %s\n", ts(), board.UCItoSAN(warm.Move), warm.Eval, board.UCItoSAN(result.Move), result.Eval)
+					rec.Note("out of book in the position analyzed ahead: %s eval %s", board.UCItoSAN(warm.Move), warm.Eval)
 				}
-				break
+				g.announceOpening()
+			}
+			if plan.Scramble {
+				afterSend = append(afterSend, leaveBook)
+			} else {
+				leaveBook()
 			}
 		}
+
+		// their searches would wait on the hung engine too, or cost the scramble time
+		if !panicked && !plan.Scramble {
+			bestMove = g.swindle(ctx, state, board, bestMove, ourTime, opponentTime)
+			bestMove = g.avoidRepetition(ctx, reps, state, bestMove, ourTime)
+			bestMove = g.endgameTechnique(ctx, state, board, bestMove, ourTime)
+		}
+		g.checkEvalSwing(board)
 	}
 
-	goForDirtyFlag := ourTime > opponentTime && opponentTime < 5*time.Second || ourTime > opponentTime*3/2
-	tcHasIncrement := state.WhiteInc > 0 && state.BlackInc > 0
-	gameIsEqual := g.consecutiveFullMovesWithZeroEval > 12 && board.FullMove > 40 && board.HalfmoveClock > 4
-	offerDraw := gameIsEqual && tcHasIncrement && !goForDirtyFlag
+	bestMove = g.operatorMove(board, bestMove)
 
-	if tcHasIncrement && ourTime >= 30*time.Second {
-		elapsed := time.Since(start)
-		delta := 400*time.Millisecond - elapsed
-		if delta > 0 {
-			time.Sleep(delta)
-		}
+	g.Lock()
+	canGiveTime := g.canGiveTime
+	g.Unlock()
+
+	send := planSend(sendInput{
+		Board:         board,
+		OurTime:       ourTime,
+		OpponentTime:  opponentTime,
+		Clock:         g.clock,
+		ZeroEvalMoves: g.consecutiveFullMovesWithZeroEval,
+		AboutToMate:   g.aboutToMate,
+		CanGiveTime:   canGiveTime,
+		Elapsed:       time.Since(start),
+		Premove:       plan.Action == actionPremove,
+	})
+	offerDraw := send.OfferDraw
+
+	if send.Delay > 0 {
+		time.Sleep(send.Delay)
 	}
 
-	if g.IsFinished() {
+	if ctx.Err() != nil {
 		return
 	}
 
-	if ourTime > opponentTime && opponentTime < 1*time.Second && g.aboutToMate && g.canGiveTime {
+	if give := send.GiveTime; give > 0 {
 		go func() {
-			give := int(ourTime-opponentTime) / 2 / 1e9
-			if give > 0 {
-				fmt.Printf("%s giving opponent %d second(s)\n", ts(), give)
-				if err := api.AddTime(g.gameID, give); err != nil {
-					g.canGiveTime = false
-					log.Printf("AddTime: %v\n", err)
-				}
+			fmt.Printf("%s giving opponent %d second(s)\n", ts(), give)
+			if err := api.AddTime(g.gameID, give); err != nil {
+				g.Lock()
+				g.canGiveTime = false
+				g.Unlock()
+				log.Printf("AddTime: %v\n", err)
 			}
 		}()
 	}
 
-	if err := g.sendMoveToServer(bestMove, offerDraw); err != nil {
-		// '{"error":"Not your turn, or game already over"}'
+	if err := g.sendMoveToServer(ctx, bestMove, offerDraw, ourTime); errors.Is(err, api.ErrGameOver) {
 		// TODO: we should handle the opponent resigning, flagging or aborting while we're thinking
+		fmt.Printf("%s game over before our move %s was sent\n", ts(), bestMove)
+		rec.Fail(err)
+
+		g.finish()
+		return
+	} else if api.Transient(err) {
+		fmt.Printf("%s *** lichess unavailable, move %s not sent, waiting for the game stream: %v\n", ts(), bestMove, err)
+		rec.Fail(err)
+
+		g.unstoreOpponentMove(len(moves))
+		g.setState(stateWaitingOpponent)
+		return
+	} else if err != nil {
 		fmt.Printf("%s *** ERR: api.PlayMove: %v: %s initialFEN: '%s' len(moves): %d board: '%s'\n", ts(), err, string(ndjson), g.initialFEN, len(moves), board.FEN())
+		rec.Fail(err)
 
-		g.Finish()
+		g.finish()
 		return
 	}
 
+	g.setState(iif(g.ponderSearch != nil, statePondering, stateWaitingOpponent))
+	for _, f := range afterSend {
+		f()
+	}
+	eventbus.Publish(g.opts.Bus, MoveSent{GameID: g.gameID, Ply: len(moves), Move: bestMove, Source: iif(plan.Action == actionBook || plan.Action == actionPremove, plan.Action.String(), "engine"), OfferDraw: offerDraw})
+
 	g.maybeGiveTime(ourTime, opponentTime)
 
 	bestMoveSAN := board.UCItoSAN(bestMove)
+	if rec != nil {
+		rec.Move, rec.MoveSAN, rec.Eval, rec.OfferDraw = bestMove, bestMoveSAN, g.humanEval, offerDraw
+		rec.ThinkTime = time.Since(start).Milliseconds()
+	}
+
+	var telemetry string
+	if stats, ok := searchStats(g.searched); ok {
+		stats.Ply, stats.Move = len(moves), bestMoveSAN
+		g.Lock()
+		g.moveStats = append(g.moveStats, stats)
+		g.Unlock()
+		telemetry = fmt.Sprintf(" | depth: %d/%d nodes: %s nps: %s hashfull: %.1f%%",
+			stats.Depth, stats.SelDepth, commas.Int(stats.Nodes), commas.Int(stats.NPS), float64(stats.HashFull)/10)
+	}
+
 	tslbl := ts()
 	fullFEN := board.FEN()
-	fmt.Printf("%s game: %s (%d) | our_time: %6v opp_time: %6v | our_move: %s (%s) | eval: %s\n%s fen: %s\n",
-		tslbl, g.opponent.Name, g.opponent.Rating, ourTime, opponentTime, bestMoveSAN, bestMove, g.humanEval,
+	fmt.Printf("%s game: %s (%d) | our_time: %6v opp_time: %6v | our_move: %s (%s) | eval: %s%s\n%s fen: %s\n",
+		tslbl, g.opponent.Name, g.opponent.Rating, ourTime, opponentTime, bestMoveSAN, bestMove, g.humanEval, telemetry,
 		tslbl, fullFEN)
 
 	g.storeMove(fullFEN, bestMoveSAN)
+	g.moves[len(g.moves)-1].Think = time.Since(start)
+}
+
+// searchStats returns the depth, nodes, nps and hashfull from the last info line of a search.
+func searchStats(result *BestMove) (history.MoveStats, bool) {
+	if result == nil {
+		return history.MoveStats{}, false
+	}
+
+	for i := len(result.Info) - 1; i >= 0; i-- {
+		eval, err := analyze.ParseEval(result.Info[i])
+		if err != nil {
+			continue
+		}
+		return history.MoveStats{
+			Depth:    eval.Depth,
+			SelDepth: eval.SelDepth,
+			Nodes:    eval.Nodes,
+			NPS:      eval.NPS,
+			HashFull: eval.HashFull,
+			Time:     eval.Time,
+		}, true
+	}
+
+	return history.MoveStats{}, false
+}
+
+// setEval records the engine's eval after a search and whether we're close to winning.
+func (g *Game) setEval(eval string) {
+	g.humanEval = eval
+	if g.humanEval == "0.00" {
+		g.consecutiveFullMovesWithZeroEval++
+	} else {
+		g.consecutiveFullMovesWithZeroEval = 0
+	}
+
+	score := evalScore(eval).For(g.playerColor)
+	g.aboutToMate = score.Mate > 0 || (score.Mate == 0 && score.CP >= 150)
 }
 
 func (g *Game) storeMove(fenPOS, moveSAN string) {
 	g.moves = append(g.moves, SavedMove{FEN: fenPOS, MoveSAN: moveSAN})
+
+	if g.initialFEN == "startpos" && len(g.moves) <= history.OpeningPlies {
+		g.Lock()
+		g.opening = append(g.opening, moveSAN)
+		g.Unlock()
+	}
+
+	if g.opts.Broadcast != nil {
+		g.opts.Broadcast.Update(g.gameID, g.PGN())
+	}
 }
 
-func (g *Game) ponderHit() {
-	g.input <- "ponderhit"
-	g.pondering = false
+// unstoreOpponentMove forgets the opponent's move before ply plies when ours couldn't be
+// sent, so the position is played again when the game stream resends it.
+func (g *Game) unstoreOpponentMove(plies int) {
+	if g.ponderSearch != nil {
+		g.stopPondering()
+	}
+	if plies == 0 || len(g.moves) < plies {
+		return
+	}
+	g.moves = g.moves[:plies-1]
+
+	g.Lock()
+	if len(g.opening) > len(g.moves) {
+		g.opening = g.opening[:len(g.moves)]
+	}
+	g.Unlock()
 }
 
-func (g *Game) stopPondering() {
-	g.input <- "stop"
-	if g.pondering {
-		g.pondering = false
-		g.consumeBestMove()
+// resume picks the game up after the game stream reconnected and lichess sent the game
+// again: an ended game is finished, otherwise the position is played like a gameState.
+func (g *Game) resume(ctx context.Context, ndjson []byte, state api.State) {
+	fmt.Printf("%s game stream '%s' reconnected, status: %s\n", ts(), g.gameID, state.Status)
+	g.lastStateEvent = time.Now()
+	state.MessageReceived = time.Now()
+
+	g.setResult(state)
+	g.clocks = [2]int{state.WhiteTime, state.BlackTime}
+	if state.Status != "started" {
+		g.finish()
+		return
 	}
+
+	g.playMove(ctx, ndjson, state)
 }
 
-func (g *Game) consumeBestMove() {
-	// consume 'bestmove' from pondering, so we don't accidentally consume it later
-	for line := range g.output {
-		if strings.HasPrefix(line, "bestmove") {
-			break
-		}
+// ponderHit tells the engine the opponent played the predicted move. The ponder search
+// becomes the search for our move.
+func (g *Game) ponderHit() *Search {
+	_ = g.engine.Send("ponderhit")
+	search := g.ponderSearch
+	g.ponderSearch = nil
+	return search
+}
+
+// stopPondering stops the engine. The ponder search's bestmove is discarded when it arrives.
+func (g *Game) stopPondering() {
+	_ = g.engine.Send("stop")
+	g.ponderSearch = nil
+}
+
+// followBookMove keeps the engine on the game after a book move: it analyzes the book's line
+// ahead with BookPonderPlies, else ponders the book's reply, or without one is given the
+// position with no go, so the game's moves reach the engine in order and the first search
+// out of book follows on from the last position it saw.
+func (g *Game) followBookMove(state api.State, bookMoveUCI, ponderUCI string) {
+	// a ponder hit doesn't matter, the search is no longer needed
+	if g.ponderSearch != nil {
+		g.stopPondering()
+	}
+
+	if g.opts.BookPonderPlies > 0 && g.book != nil && g.startBookPonder(state.Moves, bookMoveUCI) {
+		return
+	}
+
+	if ponderUCI != "" {
+		g.ponderMove(ponderUCI, state, bookMoveUCI)
+		return
+	}
+
+	if err := g.engine.Send(g.positionCommand(state.Moves, bookMoveUCI)); err != nil {
+		fmt.Printf("%s *** ERR: position: %v\n", ts(), err)
+		return
 	}
+	g.positionsFed++
 }
 
 func (g *Game) ponderMove(ponderMoveUCI string, state api.State, playedMoveUCI string) {
+	if g.opts.Premove {
+		board := fen.FENtoBoard(g.initialFEN)
+		board.Moves(append(strings.Fields(state.Moves), playedMoveUCI)...)
+		g.premove = preparePremove(board, ponderMoveUCI)
+	}
+
+	g.prediction = ""
+	if !g.allowPonder() {
+		g.prediction = ponderMoveUCI
+		if err := g.engine.Send(g.positionCommand(state.Moves, playedMoveUCI)); err != nil {
+			fmt.Printf("%s *** ERR: position: %v\n", ts(), err)
+		}
+		return
+	}
+
 	g.ponder = ponderMoveUCI
 	g.totalPonders++
 
-	var pos string
-	addPosFen := iif(g.initialFEN == "startpos", "", "fen ")
-	if state.Moves == "" {
-		pos = fmt.Sprintf("position %s%s moves %s %s", addPosFen, g.initialFEN, playedMoveUCI, g.ponder)
-	} else {
-		pos = fmt.Sprintf("position %s%s moves %s %s %s", addPosFen, g.initialFEN, state.Moves, playedMoveUCI, g.ponder)
-	}
+	pos := g.positionCommand(state.Moves, playedMoveUCI, g.ponder)
 
 	var goCmd string
 	elapsed := int(time.Since(state.MessageReceived).Milliseconds())
@@ -652,27 +1158,79 @@ func (g *Game) ponderMove(ponderMoveUCI string, state api.State, playedMoveUCI s
 	blackTime := state.BlackTime - iif(g.playerColor == fen.WhitePieces, 0, elapsed)
 	blackTime = max(blackTime, 50)
 
-	goCmd = fmt.Sprintf("go ponder wtime %d winc %d btime %d binc %d",
-		whiteTime, state.WhiteInc,
-		blackTime, state.BlackInc,
-	)
+	goCmd = "go ponder " + g.clock.GoTimes(whiteTime, blackTime, g.playerColor)
+	if g.limited {
+		goCmd += g.opts.Strength.GoLimits()
+	}
 
-	g.input <- pos
-	g.input <- goCmd
+	search, err := g.engine.Go(pos, goCmd)
+	if err != nil {
+		fmt.Printf("%s *** ERR: go ponder: %v\n", ts(), err)
+		return
+	}
+	g.ponderSearch = search
+}
 
-	g.pondering = true
+// allowPonder asks PonderControl whether to ponder, printing when its answer changes.
+func (g *Game) allowPonder() bool {
+	ok, why := g.opts.PonderControl.allows(g.perf)
+	if why != g.ponderOff && (why == "" || g.ponderOff == "") {
+		if ok {
+			fmt.Printf("%s pondering back on\n", ts())
+		} else {
+			fmt.Printf("%s pondering off: %s\n", ts(), why)
+		}
+	}
+	g.ponderOff = why
+	return ok
 }
 
-func (g *Game) sendMoveToServer(bestMove string, offerDraw bool) error {
+// positionCommand returns the UCI 'position' command for the game's initial position
+// followed by moves. Empty moves are skipped.
+func (g *Game) positionCommand(moves ...string) string {
+	var sb strings.Builder
+	if g.initialFEN == "startpos" {
+		sb.WriteString("position startpos")
+	} else {
+		sb.WriteString("position fen ")
+		sb.WriteString(g.initialFEN)
+	}
+
+	var wroteMoves bool
+	for _, move := range moves {
+		if move == "" {
+			continue
+		}
+		if !wroteMoves {
+			sb.WriteString(" moves")
+			wroteMoves = true
+		}
+		sb.WriteByte(' ')
+		sb.WriteString(move)
+	}
+
+	return sb.String()
+}
+
+// sendMoveToServer plays bestMove, retrying with backoff while lichess is unavailable for
+// as long as ourTime lasts.
+func (g *Game) sendMoveToServer(ctx context.Context, bestMove string, offerDraw bool, ourTime time.Duration) error {
 	if bestMove == "" {
 		return nil
 	}
 
-	if err := api.PlayMove(g.gameID, bestMove, offerDraw); err != nil {
-		return err
+	deadline := time.Now().Add(ourTime)
+	var b backoff
+	for {
+		err := g.sendMove(g.gameID, bestMove, offerDraw)
+		if !api.Transient(err) || time.Now().Add(b.delay()).After(deadline) {
+			return err
+		}
+		fmt.Printf("%s *** lichess unavailable sending %s, retrying: %v\n", ts(), bestMove, err)
+		if !b.wait(ctx) {
+			return err
+		}
 	}
-
-	return nil
 }
 
 func (g *Game) maybeGiveTime(ourTime, opponentTime time.Duration) {
@@ -699,13 +1257,14 @@ func (l *Listener) Playing() bool {
 	return !l.activeGame.IsFinished()
 }
 
-func (g *Game) waitReady() {
-	g.input <- "isready"
-	for line := range g.output {
-		if line == "readyok" {
-			break
-		}
+// ActiveGame returns the game in progress, nil if there isn't one.
+func (l *Listener) ActiveGame() *Game {
+	l.activeGameMtx.Lock()
+	defer l.activeGameMtx.Unlock()
+	if l.activeGame == nil || l.activeGame.IsFinished() {
+		return nil
 	}
+	return l.activeGame
 }
 
 func iif[T any](condition bool, ifTrue, ifFalse T) T {
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"trollfish-lichess/api"
+	"trollfish-lichess/fen"
+	"trollfish-lichess/storage"
+	"trollfish-lichess/yamlbook"
+)
+
+// fakeEngine answers isready and go commands like trollfish. Ponder searches reply after
+// ponderhit or stop. A search restricted with searchmoves is looked up as the position
+// followed by " searchmoves ...", then as the position.
+func fakeEngine(ctx context.Context, bestMoves map[string]string) *Engine {
+	input := make(chan string, 64)
+	output := make(chan string, 64)
+
+	bestMove := func(position string) string {
+		if line, ok := bestMoves[position]; ok {
+			return line
+		}
+		return "bestmove (none)"
+	}
+
+	go func() {
+		var position string
+		var pondering bool
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case cmd := <-input:
+				switch {
+				case cmd == "isready":
+					output <- "readyok"
+				case strings.HasPrefix(cmd, "position"):
+					position = cmd
+				case strings.HasPrefix(cmd, "go ponder"):
+					pondering = true
+				case strings.HasPrefix(cmd, "go"):
+					if i := strings.Index(cmd, " searchmoves "); i != -1 {
+						if line, ok := bestMoves[position+cmd[i:]]; ok {
+							output <- line
+							continue
+						}
+					}
+					output <- bestMove(position)
+				case cmd == "ponderhit" || cmd == "stop":
+					if pondering {
+						pondering = false
+						output <- bestMove(position)
+					}
+				}
+			}
+		}
+	}()
+
+	return NewEngine(ctx, input, output)
+}
+
+type sentMoves struct {
+	moves chan string
+}
+
+func (s *sentMoves) send(gameID, move string, draw bool) error {
+	s.moves <- move
+	return nil
+}
+
+func waitState(t *testing.T, g *Game, want gameState) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if g.State() == want {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("state: got %v, want %v", g.State(), want)
+}
+
+const testGameFull = `{"type":"gameFull","id":"test","rated":true,"speed":"blitz","createdAt":1,` +
+	`"white":{"id":"` + botID + `","name":"` + botID + `","rating":2000},` +
+	`"black":{"id":"opp","name":"opp","rating":2100,"title":"BOT"},` +
+	`"initialFen":"startpos",` +
+	`"state":{"type":"gameState","moves":"","wtime":60000,"btime":60000,"winc":0,"binc":0,"status":"started"}}`
+
+func TestGame_Transitions(t *testing.T) {
+	// arrange
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	engine := fakeEngine(ctx, map[string]string{
+		"position startpos":                 "bestmove e2e4 ponder e7e5 eval 0.30",
+		"position startpos moves e2e4 e7e5": "bestmove g1f3 ponder b8c6 eval 0.35",
+	})
+
+	sent := sentMoves{moves: make(chan string, 4)}
+	g := NewGame(ctx, "test", storage.NewMemory(), engine, &yamlbook.Book{}, nil, GameOptions{}, nil)
+	g.sendMove = sent.send
+
+	// also exercise concurrent readers for -race
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				_ = g.State()
+				_ = g.IsFinished()
+				_ = g.HistoryRecord()
+			}
+		}
+	}()
+
+	if got := g.State(); got != stateStarting {
+		t.Fatalf("initial state: got %v", got)
+	}
+
+	// act / assert: our move from the start position, then ponder
+	g.post([]byte(testGameFull))
+	if move := <-sent.moves; move != "e2e4" {
+		t.Fatalf("first move: got %s, want e2e4", move)
+	}
+	waitState(t, g, statePondering)
+
+	// our own move echoed back doesn't change anything
+	g.post([]byte(`{"type":"gameState","moves":"e2e4","wtime":60000,"btime":60000,"status":"started"}`))
+
+	// ponder hit
+	g.post([]byte(`{"type":"gameState","moves":"e2e4 e7e5","wtime":59000,"btime":60000,"status":"started"}`))
+	if move := <-sent.moves; move != "g1f3" {
+		t.Fatalf("second move: got %s, want g1f3", move)
+	}
+	waitState(t, g, statePondering)
+
+	g.Finish()
+
+	close(stop)
+	wg.Wait()
+
+	if got := g.State(); got != stateFinished {
+		t.Errorf("final state: got %v, want %v", got, stateFinished)
+	}
+	if g.post([]byte(`{"type":"chatLine"}`)) {
+		t.Error("post after finish: want false")
+	}
+}
+
+func TestGameState_CanTransition(t *testing.T) {
+	cases := []struct {
+		from, to gameState
+		want     bool
+	}{
+		{stateStarting, stateThinking, true},
+		{stateStarting, statePondering, false},
+		{stateWaitingOpponent, stateThinking, true},
+		{stateThinking, statePondering, true},
+		{stateThinking, stateWaitingOpponent, true},
+		{statePondering, stateThinking, true},
+		{stateWaitingOpponent, statePondering, false},
+		{stateFinished, stateThinking, false},
+	}
+
+	for _, c := range cases {
+		if got := c.from.canTransition(c.to); got != c.want {
+			t.Errorf("%v -> %v: got %v, want %v", c.from, c.to, got, c.want)
+		}
+	}
+}
+
+func TestSearchStats(t *testing.T) {
+	// arrange
+	result := &BestMove{Info: []string{
+		"info depth 19 seldepth 25 multipv 1 score cp 31 nodes 900000 nps 1000000 hashfull 120 tbhits 0 time 900 pv e2e4 e7e5",
+		"info depth 20 seldepth 28 multipv 1 score cp 30 nodes 1500000 nps 1100000 hashfull 345 tbhits 0 time 1363 pv e2e4 e7e5 g1f3",
+	}}
+
+	// act
+	stats, ok := searchStats(result)
+	_, bookOK := searchStats(nil)
+
+	// assert
+	if !ok {
+		t.Fatal("want stats")
+	}
+	if stats.Depth != 20 || stats.SelDepth != 28 || stats.Nodes != 1500000 || stats.NPS != 1100000 || stats.HashFull != 345 || stats.Time != 1363 {
+		t.Errorf("got %+v", stats)
+	}
+	if bookOK {
+		t.Error("book move: want no stats")
+	}
+}
+
+func TestGame_CheckBookMove(t *testing.T) {
+	// arrange
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	engine := fakeEngine(ctx, map[string]string{
+		"position startpos":                  "bestmove e2e4 ponder e7e5 eval 0.30",
+		"position startpos searchmoves d2d4": "bestmove d2d4 ponder d7d5 eval 0.25",
+		"position startpos searchmoves f2f3": "bestmove f2f3 ponder e7e5 eval -1.50",
+	})
+
+	g := NewGame(ctx, "test", storage.NewMemory(), engine, &yamlbook.Book{}, nil, GameOptions{BookCheckCP: 100}, nil)
+	defer g.Finish()
+	g.initialFEN = "startpos"
+	g.playerColor = fen.WhitePieces
+	board := fen.FENtoBoard(startPosFEN)
+	state := api.State{}
+
+	// act
+	same := g.checkBookMove(ctx, board, state, board.FENKey(), "e2e4", time.Minute)
+	near := g.checkBookMove(ctx, board, state, board.FENKey(), "d2d4", time.Minute)
+	bad := g.checkBookMove(ctx, board, state, board.FENKey(), "f2f3", time.Minute)
+	shortClock := g.checkBookMove(ctx, board, state, board.FENKey(), "f2f3", bookCheckMinClock-time.Second)
+
+	// assert
+	if !same || !near {
+		t.Errorf("engine's move: %v, 5 cp worse: %v, want both kept", same, near)
+	}
+	if bad {
+		t.Error("180 cp worse: kept, want replaced")
+	}
+	if !shortClock {
+		t.Error("short clock: replaced, want unchecked")
+	}
+}
+
+func TestGame_SetupFEN(t *testing.T) {
+	// arrange
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const setupFEN = "4k3/8/8/8/8/8/8/4K2R w K - 0 1"
+	engine := fakeEngine(ctx, map[string]string{
+		"position fen " + setupFEN: "bestmove h1h7 ponder e8d8 eval 5.00",
+	})
+
+	store := storage.NewMemory()
+	sent := sentMoves{moves: make(chan string, 4)}
+	g := NewGame(ctx, "test", store, engine, &yamlbook.Book{}, nil, GameOptions{}, nil)
+	g.sendMove = sent.send
+
+	gameFull := strings.Replace(testGameFull, `"initialFen":"startpos"`, `"initialFen":"`+setupFEN+`"`, 1)
+
+	// act
+	g.post([]byte(gameFull))
+	move := <-sent.moves
+	g.post([]byte(`{"type":"gameState","moves":"h1h7","wtime":60000,"btime":60000,"status":"resign","winner":"white"}`))
+	for deadline := time.Now().Add(2 * time.Second); g.HistoryRecord().Result == "" && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	g.Finish()
+
+	// assert
+	if move != "h1h7" {
+		t.Fatalf("move: got %s, want h1h7", move)
+	}
+	b, err := store.ReadFile(gamesPGNFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pgn := string(b)
+	for _, want := range []string{`[SetUp "1"]`, `[FEN "` + setupFEN + `"]`, `[Result "1-0"]`, "\n1. Rh7 1-0\n"} {
+		if !strings.Contains(pgn, want) {
+			t.Errorf("pgn missing %q:\n%s", want, pgn)
+		}
+	}
+}
+
+func TestGame_FollowBookMove(t *testing.T) {
+	// arrange
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	input := make(chan string, 64)
+	output := make(chan string, 8)
+	g := NewGame(ctx, "test", storage.NewMemory(), NewEngine(ctx, input, output), &yamlbook.Book{}, nil, GameOptions{}, nil)
+	defer func() {
+		// answer the drain at the end of the game
+		go func() {
+			for cmd := range input {
+				switch cmd {
+				case "stop":
+					output <- "bestmove g1f3"
+				case "isready":
+					output <- "readyok"
+				}
+			}
+		}()
+		g.Finish()
+	}()
+	g.initialFEN = "startpos"
+	g.playerColor = fen.BlackPieces
+	state := api.State{Moves: "e2e4", WhiteTime: 60000, BlackTime: 60000, MessageReceived: time.Now()}
+
+	sent := func() []string {
+		var cmds []string
+		for len(input) > 0 {
+			cmds = append(cmds, <-input)
+		}
+		return cmds
+	}
+
+	// act
+	g.followBookMove(state, "e7e5", "")
+	fed := sent()
+	g.followBookMove(state, "e7e5", "g1f3")
+	pondered := sent()
+	g.followBookMove(state, "e7e5", "")
+	afterPonder := sent()
+
+	// assert
+	if len(fed) != 1 || fed[0] != "position startpos moves e2e4 e7e5" {
+		t.Errorf("without a ponder move: got %q, want only the position", fed)
+	}
+	if len(pondered) != 2 || pondered[0] != "position startpos moves e2e4 e7e5 g1f3" || !strings.HasPrefix(pondered[1], "go ponder") {
+		t.Errorf("with a ponder move: got %q", pondered)
+	}
+	if len(afterPonder) != 2 || afterPonder[0] != "stop" {
+		t.Errorf("after pondering: got %q, want stop then the position", afterPonder)
+	}
+	if g.positionsFed != 2 {
+		t.Errorf("positions fed: got %d, want 2", g.positionsFed)
+	}
+}
+
+func TestGame_SetEval(t *testing.T) {
+	cases := []struct {
+		eval        string
+		color       fen.Color
+		wantScore   int
+		wantMating  bool
+		wantZeroRun int
+	}{
+		{eval: "0.00", color: fen.WhitePieces, wantZeroRun: 1},
+		{eval: "-0.35", color: fen.WhitePieces, wantScore: -35},
+		{eval: "-0.35", color: fen.BlackPieces, wantScore: 35},
+		{eval: "1.50", color: fen.WhitePieces, wantScore: 150, wantMating: true},
+		{eval: "-1.50", color: fen.BlackPieces, wantScore: 150, wantMating: true},
+		{eval: "1.50", color: fen.BlackPieces, wantScore: -150},
+		{eval: "M3", color: fen.WhitePieces, wantScore: 100_000 - 3, wantMating: true},
+		{eval: "M3", color: fen.BlackPieces, wantScore: -100_000 + 3},
+		{eval: "M-2", color: fen.BlackPieces, wantScore: 100_000 - 2, wantMating: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.eval+" "+c.color.String(), func(t *testing.T) {
+			// arrange
+			g := &Game{playerColor: c.color}
+
+			// act
+			g.setEval(c.eval)
+
+			// assert
+			if got := evalToScore(g.humanEval, c.color); got != c.wantScore {
+				t.Errorf("score: got %d, want %d", got, c.wantScore)
+			}
+			if g.aboutToMate != c.wantMating {
+				t.Errorf("aboutToMate: got %v, want %v", g.aboutToMate, c.wantMating)
+			}
+			if g.consecutiveFullMovesWithZeroEval != c.wantZeroRun {
+				t.Errorf("drawn evals: got %d, want %d", g.consecutiveFullMovesWithZeroEval, c.wantZeroRun)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"trollfish-lichess/api"
+)
+
+// gameState is where a Game is in its lifecycle. Transitions happen only on the game's event
+// loop (see run); other goroutines read the state under the Game's lock.
+type gameState int
+
+const (
+	stateStarting        gameState = iota // waiting for gameFull
+	stateWaitingOpponent                  // opponent's turn, not pondering
+	stateThinking                         // searching for our move
+	statePondering                        // opponent's turn, pondering their predicted move
+	stateFinished
+)
+
+func (s gameState) String() string {
+	switch s {
+	case stateStarting:
+		return "Starting"
+	case stateWaitingOpponent:
+		return "WaitingOpponent"
+	case stateThinking:
+		return "Thinking"
+	case statePondering:
+		return "Pondering"
+	case stateFinished:
+		return "Finished"
+	default:
+		return fmt.Sprintf("gameState(%d)", int(s))
+	}
+}
+
+var gameTransitions = map[gameState][]gameState{
+	stateStarting:        {stateWaitingOpponent, stateThinking, stateFinished},
+	stateWaitingOpponent: {stateThinking, stateFinished},
+	stateThinking:        {stateWaitingOpponent, statePondering, stateFinished},
+	statePondering:       {stateThinking, stateWaitingOpponent, stateFinished},
+	stateFinished:        nil,
+}
+
+func (s gameState) canTransition(to gameState) bool {
+	for _, valid := range gameTransitions[s] {
+		if valid == to {
+			return true
+		}
+	}
+	return false
+}
+
+// gameEvent is a message from the game stream for the event loop.
+type gameEvent struct {
+	Type   string
+	ndjson []byte
+}
+
+func (g *Game) State() gameState {
+	g.Lock()
+	defer g.Unlock()
+	return g.state
+}
+
+func (g *Game) IsFinished() bool {
+	return g.State() == stateFinished
+}
+
+func (g *Game) setState(to gameState) {
+	g.Lock()
+	defer g.Unlock()
+
+	if g.state == to {
+		return
+	}
+	if !g.state.canTransition(to) {
+		log.Printf("ERR: game %s: invalid state transition %v -> %v\n", g.gameID, g.state, to)
+		return
+	}
+	g.state = to
+}
+
+// run is the game's event loop. Every handler, and so every unsynchronized Game field,
+// runs on this goroutine. The loop ends when the game finishes or its context is done.
+func (g *Game) run() {
+	defer close(g.done)
+
+	for {
+		select {
+		case <-g.ctx.Done():
+			g.finish()
+			return
+		case event := <-g.events:
+			g.handleEvent(event)
+			if g.IsFinished() {
+				return
+			}
+		}
+	}
+}
+
+func (g *Game) handleEvent(event gameEvent) {
+	switch event.Type {
+	case "gameFull":
+		g.handleGameFull(g.ctx, event.ndjson)
+	case "gameState":
+		g.handleGameState(g.ctx, event.ndjson)
+	case "chatLine":
+		g.handleChat(event.ndjson)
+	default:
+		fmt.Printf("%s *** unhandled event type: '%s'\n", ts(), event.Type)
+	}
+}
+
+// post queues a stream message for the event loop. It returns false once the loop has ended.
+func (g *Game) post(ndjson []byte) bool {
+	var event api.Event
+	if err := json.Unmarshal(ndjson, &event); err != nil {
+		log.Fatal(err)
+	}
+
+	// the stream reuses its buffer
+	b := make([]byte, len(ndjson))
+	copy(b, ndjson)
+
+	select {
+	case <-g.done:
+		return false
+	default:
+	}
+
+	select {
+	case g.events <- gameEvent{Type: event.Type, ndjson: b}:
+		return true
+	case <-g.done:
+		return false
+	}
+}
+
+// Finish ends the game: a search in progress is abandoned, pondering stops and the game
+// is saved. It returns once the event loop has finished.
+func (g *Game) Finish() {
+	g.cancel()
+	<-g.done
+}
+
+// EngineOptions returns the setoption commands the game sent the engine.
+func (g *Game) EngineOptions() []string {
+	g.Lock()
+	defer g.Unlock()
+	return g.engineOptions
+}
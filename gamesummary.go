@@ -0,0 +1,42 @@
+package main
+
+import (
+	"trollfish-lichess/fen"
+	"trollfish-lichess/history"
+)
+
+// Summary returns the game's summary for the history database and external tooling, with
+// record as its game. Call it on the event loop or once the game has finished.
+func (g *Game) Summary(record history.Game) history.GameSummary {
+	summary := history.GameSummary{
+		Game:         record,
+		Moves:        make([]history.SummaryMove, 0, len(g.moves)),
+		BookMoves:    g.bookMovesPlayed,
+		PositionsFed: g.positionsFed,
+		Ponders:      g.totalPonders,
+		PonderHits:   g.ponderHits,
+		Clock: history.SummaryClock{
+			Initial:   g.clock.Initial.Milliseconds(),
+			Increment: g.clock.Increment.Milliseconds(),
+		},
+	}
+
+	for i, move := range g.moves {
+		ours := fen.FENtoBoard(move.FEN).ActiveColor == g.playerColor
+		summary.Moves = append(summary.Moves, history.SummaryMove{
+			Ply:   i + 1,
+			FEN:   move.FEN,
+			SAN:   move.MoveSAN,
+			Ours:  ours,
+			Think: move.Think.Milliseconds(),
+		})
+		summary.Clock.Think += move.Think.Milliseconds()
+	}
+
+	ours, theirs := g.clocks[0], g.clocks[1]
+	if g.playerColor == fen.BlackPieces {
+		ours, theirs = theirs, ours
+	}
+	summary.Clock.OurTime, summary.Clock.OpponentTime = int64(ours), int64(theirs)
+	return summary
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"trollfish-lichess/fen"
+	"trollfish-lichess/history"
+)
+
+func TestGame_Summary(t *testing.T) {
+	// arrange
+	board := fen.FENtoBoard(startPosFEN)
+	afterE4 := board
+	afterE4.Moves("e2e4")
+
+	g := &Game{
+		playerColor:     fen.BlackPieces,
+		clock:           Clock{Type: ClockFischer, Initial: 3 * time.Minute, Increment: 2 * time.Second},
+		clocks:          [2]int{170000, 175000},
+		bookMovesPlayed: 1,
+		totalPonders:    1,
+		moves: []SavedMove{
+			{FEN: board.FEN(), MoveSAN: "e4"},
+			{FEN: afterE4.FEN(), MoveSAN: "c5", Think: 250 * time.Millisecond},
+		},
+	}
+
+	// act
+	summary := g.Summary(history.Game{ID: "abcd1234", Opponent: "someone", Result: history.Draw})
+
+	// assert
+	if summary.Game.ID != "abcd1234" || summary.BookMoves != 1 || summary.Ponders != 1 {
+		t.Errorf("got %+v", summary)
+	}
+	if len(summary.Moves) != 2 || summary.Moves[0].Ours || !summary.Moves[1].Ours || summary.Moves[1].SAN != "c5" || summary.Moves[1].Ply != 2 {
+		t.Errorf("moves: got %+v", summary.Moves)
+	}
+	want := history.SummaryClock{Initial: 180000, Increment: 2000, OurTime: 175000, OpponentTime: 170000, Think: 250}
+	if summary.Clock != want {
+		t.Errorf("clock: got %+v, want %+v", summary.Clock, want)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"trollfish-lichess/fen"
+)
+
+// hintPrefix starts the 'info string' lines trollfish uses to suggest actions to the bot:
+//
+//	info string hint <name> [key=value ...] [text=<rest of line>]
+//
+// e.g. 'info string hint resign' or 'info string hint book move=g1f3'. Hints arrive with
+// the search they were sent during and are handled once its bestmove is in.
+const hintPrefix = "info string hint "
+
+// bookHintsFilename collects the engine's book suggestions for review.
+const bookHintsFilename = "book-hints.yaml"
+
+// resignHintChances are the winning chances (our pov) at or below which a resign hint is
+// followed, about -5 with the lichess model.
+const resignHintChances = -0.75
+
+// Hint is an action suggested by the engine.
+type Hint struct {
+	Name string
+	Args map[string]string
+	Line string
+}
+
+// hintHandler acts on a hint for the position the search was for.
+type hintHandler func(g *Game, board fen.Board, hint Hint)
+
+// hintHandlers maps hint names to handlers. Hints without a handler are logged and
+// recorded in the move audit, so the engine can send new ones before the bot acts on them.
+var hintHandlers = map[string]hintHandler{}
+
+func registerHint(name string, handler hintHandler) {
+	if _, ok := hintHandlers[name]; ok {
+		panic(fmt.Errorf("hint '%s' registered twice", name))
+	}
+	hintHandlers[name] = handler
+}
+
+func init() {
+	registerHint("eval", evalHint)
+	registerHint("book", bookHint)
+	registerHint("resign", resignHint)
+}
+
+func parseHint(line string) (Hint, bool) {
+	rest := strings.TrimSpace(strings.TrimPrefix(line, hintPrefix))
+	if rest == "" {
+		return Hint{}, false
+	}
+
+	hint := Hint{Args: make(map[string]string), Line: line}
+	fields := strings.Fields(rest)
+	hint.Name = fields[0]
+
+	for i := 1; i < len(fields); i++ {
+		key, value, _ := strings.Cut(fields[i], "=")
+		if key == "text" {
+			value = strings.Join(append([]string{value}, fields[i+1:]...), " ")
+			hint.Args[key] = value
+			break
+		}
+		hint.Args[key] = value
+	}
+
+	return hint, true
+}
+
+func (h Hint) String() string {
+	keys := make([]string, 0, len(h.Args))
+	for key := range h.Args {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	sb.WriteString(h.Name)
+	for _, key := range keys {
+		sb.WriteString(fmt.Sprintf(" %s=%s", key, h.Args[key]))
+	}
+	return sb.String()
+}
+
+// handleHints runs the handler of each hint. Called on the event loop.
+func (g *Game) handleHints(board fen.Board, hints []Hint) {
+	for _, hint := range hints {
+		fmt.Printf("%s engine hint: %s\n", ts(), hint)
+
+		handler, ok := hintHandlers[hint.Name]
+		if !ok {
+			g.moveAudit.Note("unhandled engine hint: %s", hint)
+			continue
+		}
+		g.moveAudit.Note("engine hint: %s", hint)
+		handler(g, board, hint)
+	}
+}
+
+// evalHint replaces the bestmove eval: 'eval cp=<n>' or 'eval mate=<n>', white's pov.
+func evalHint(g *Game, board fen.Board, hint Hint) {
+	if mate, err := strconv.Atoi(hint.Args["mate"]); err == nil {
+		g.setEval(fmt.Sprintf("M%d", mate))
+		return
+	}
+	if cp, err := strconv.Atoi(hint.Args["cp"]); err == nil {
+		g.setEval(fmt.Sprintf("%0.2f", float64(cp)/100))
+	}
+}
+
+// bookHint saves a suggested book move, 'book move=<uci>', for review.
+func bookHint(g *Game, board fen.Board, hint Hint) {
+	move := hint.Args["move"]
+	if !board.IsLegal(move) {
+		fmt.Printf("%s ignoring book hint: '%s' isn't legal in %s\n", ts(), move, board.FEN())
+		return
+	}
+
+	entry := fmt.Sprintf("- fen: %s\n  move: %s\n", board.FENKey(), board.UCItoSAN(move))
+	if text := hint.Args["text"]; text != "" {
+		entry += fmt.Sprintf("  text: %q\n", text)
+	}
+	if err := g.store.AppendFile(bookHintsFilename, []byte(entry)); err != nil {
+		log.Printf("ERR: write file '%s': %v\n", bookHintsFilename, err)
+	}
+}
+
+// resignHint resigns instead of playing the move, if our eval agrees we're lost.
+func resignHint(g *Game, board fen.Board, hint Hint) {
+	if g.humanEval == "" {
+		return
+	}
+	if chances := evalWDL(g.wdlModel(), g.humanEval, g.playerColor, board).Chances(); chances > resignHintChances {
+		fmt.Printf("%s ignoring resign hint at eval %s\n", ts(), g.humanEval)
+		return
+	}
+	g.resign = true
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"trollfish-lichess/fen"
+	"trollfish-lichess/storage"
+	"trollfish-lichess/yamlbook"
+)
+
+func TestParseHint(t *testing.T) {
+	// act
+	hint, ok := parseHint("info string hint book move=g1f3 weight=3 text=main line, per the engine")
+	_, empty := parseHint("info string hint ")
+
+	// assert
+	if !ok || hint.Name != "book" {
+		t.Fatalf("got %+v %v", hint, ok)
+	}
+	if hint.Args["move"] != "g1f3" || hint.Args["weight"] != "3" || hint.Args["text"] != "main line, per the engine" {
+		t.Errorf("args: got %v", hint.Args)
+	}
+	if empty {
+		t.Error("empty hint: want not ok")
+	}
+}
+
+func TestGame_HandleHints(t *testing.T) {
+	// arrange
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := storage.NewMemory()
+	g := NewGame(ctx, "test", store, fakeEngine(ctx, nil), &yamlbook.Book{}, nil, GameOptions{}, nil)
+	defer g.Finish()
+	g.playerColor = fen.WhitePieces
+	board := fen.FENtoBoard(startPosFEN)
+
+	hint := func(line string) Hint {
+		h, _ := parseHint(line)
+		return h
+	}
+
+	// act
+	g.handleHints(board, []Hint{
+		hint("info string hint eval cp=-620"),
+		hint("info string hint book move=g1f3"),
+		hint("info string hint book move=g1"),
+		hint("info string hint book move=e2e5"),
+		hint("info string hint resign"),
+		hint("info string hint somethingnew x=1"),
+	})
+
+	// assert
+	if g.humanEval != "-6.20" {
+		t.Errorf("eval: got %q, want -6.20", g.humanEval)
+	}
+	if !g.resign {
+		t.Error("resign: want true at -6.20")
+	}
+	b, err := store.ReadFile(bookHintsFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "move: Nf3") {
+		t.Errorf("book hints: got %q", b)
+	}
+	if strings.Count(string(b), "- fen:") != 1 {
+		t.Errorf("book hints: got %q, want only the legal move", b)
+	}
+
+	g.resign = false
+	g.handleHints(board, []Hint{hint("info string hint eval cp=50"), hint("info string hint resign")})
+	if g.resign {
+		t.Error("resign: want false at 0.50")
+	}
+}
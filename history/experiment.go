@@ -0,0 +1,111 @@
+package history
+
+import "math"
+
+// z95 is the normal quantile for 95% confidence intervals.
+const z95 = 1.96
+
+// ArmStats is our record in an experiment arm's games. The intervals are 95%, +/- the mean.
+type ArmStats struct {
+	Arm     string  `json:"arm"`
+	Games   int     `json:"games"`
+	Wins    int     `json:"wins"`
+	Draws   int     `json:"draws"`
+	Losses  int     `json:"losses"`
+	Score   float64 `json:"score"` // points per game, 0-1
+	ScoreSD float64 `json:"score_sd"`
+
+	ACPLGames int     `json:"acpl_games"` // games with lichess' computer analysis
+	ACPL      float64 `json:"acpl"`
+	ACPLSD    float64 `json:"acpl_sd"`
+}
+
+// ScoreCI is the confidence interval of Score.
+func (s ArmStats) ScoreCI() float64 {
+	return ci(s.ScoreSD, s.Games)
+}
+
+// ACPLCI is the confidence interval of ACPL.
+func (s ArmStats) ACPLCI() float64 {
+	return ci(s.ACPLSD, s.ACPLGames)
+}
+
+// ScoreDiff returns b's score minus a's and its confidence interval.
+func ScoreDiff(a, b ArmStats) (float64, float64) {
+	return b.Score - a.Score, diffCI(a.ScoreSD, a.Games, b.ScoreSD, b.Games)
+}
+
+// ACPLDiff returns b's ACPL minus a's and its confidence interval.
+func ACPLDiff(a, b ArmStats) (float64, float64) {
+	return b.ACPL - a.ACPL, diffCI(a.ACPLSD, a.ACPLGames, b.ACPLSD, b.ACPLGames)
+}
+
+// ExperimentStats returns the stats of each of an experiment's arms, in the order given.
+func ExperimentStats(games []Game, experiment string, arms []string) []ArmStats {
+	stats := make([]ArmStats, len(arms))
+	for i, arm := range arms {
+		var scores, acpls []float64
+		s := ArmStats{Arm: arm}
+		for _, game := range games {
+			if game.Experiment != experiment || game.Arm != arm {
+				continue
+			}
+
+			s.Games++
+			switch game.Result {
+			case Win:
+				s.Wins++
+			case Draw:
+				s.Draws++
+			case Loss:
+				s.Losses++
+			}
+			scores = append(scores, game.Score())
+			if game.ACPL != nil {
+				acpls = append(acpls, float64(*game.ACPL))
+			}
+		}
+
+		s.Score, s.ScoreSD = meanSD(scores)
+		s.ACPLGames = len(acpls)
+		s.ACPL, s.ACPLSD = meanSD(acpls)
+		stats[i] = s
+	}
+	return stats
+}
+
+// meanSD returns the mean and sample standard deviation of values.
+func meanSD(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	if len(values) == 1 {
+		return mean, 0
+	}
+
+	var squares float64
+	for _, v := range values {
+		squares += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(squares / float64(len(values)-1))
+}
+
+func ci(sd float64, n int) float64 {
+	if n == 0 {
+		return 0
+	}
+	return z95 * sd / math.Sqrt(float64(n))
+}
+
+func diffCI(sdA float64, nA int, sdB float64, nB int) float64 {
+	if nA == 0 || nB == 0 {
+		return 0
+	}
+	return z95 * math.Sqrt(sdA*sdA/float64(nA)+sdB*sdB/float64(nB))
+}
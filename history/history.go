@@ -0,0 +1,291 @@
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"trollfish-lichess/storage"
+)
+
+const DefaultFilename = "history.jsonl"
+
+// DB is an append-only store of finished games, their summaries and session summaries, one
+// JSON record per line.
+type DB struct {
+	mtx      sync.Mutex
+	store    storage.Storage
+	filename string
+}
+
+type Record struct {
+	Type    string       `json:"type"`
+	Game    *Game        `json:"game,omitempty"`
+	Summary *GameSummary `json:"summary,omitempty"`
+	Session *Session     `json:"session,omitempty"`
+}
+
+const (
+	RecordGame    = "game"
+	RecordSummary = "summary"
+	RecordSession = "session"
+)
+
+const (
+	Win  = "win"
+	Loss = "loss"
+	Draw = "draw"
+)
+
+type Game struct {
+	ID             string      `json:"id"`
+	TS             int64       `json:"ts"`
+	Rated          bool        `json:"rated"`
+	Perf           string      `json:"perf"`
+	Color          string      `json:"color"`
+	Opponent       string      `json:"opponent"`
+	OpponentTitle  string      `json:"opponent_title,omitempty"`
+	OpponentRating int         `json:"opponent_rating"`
+	RatingBefore   int         `json:"rating_before"`
+	RatingAfter    int         `json:"rating_after"` // 0 when lichess didn't report the rated game's diff
+	Result         string      `json:"result"`
+	Status         string      `json:"status,omitempty"`
+	Moves          []MoveStats `json:"moves,omitempty"`
+	Opening        []string    `json:"opening,omitempty"`      // first OpeningPlies SAN moves, start position games only
+	BookPlies      int         `json:"book_plies,omitempty"`   // plies played before our first engine search
+	ExitEval       *int        `json:"exit_eval,omitempty"`    // cp, our POV, of that search; mates are +/-100000
+	OpeningName    string      `json:"opening_name,omitempty"` // ECO code and name when we left book
+	Experiment     string      `json:"experiment,omitempty"`   // see ExperimentStats
+	Arm            string      `json:"arm,omitempty"`
+	ACPL           *int        `json:"acpl,omitempty"` // ours, from lichess' computer analysis if the game has one
+}
+
+// MoveStats is the engine's final search info for one of our moves.
+type MoveStats struct {
+	Ply      int    `json:"ply"`
+	Move     string `json:"move"`
+	Depth    int    `json:"depth"`
+	SelDepth int    `json:"seldepth"`
+	Nodes    int    `json:"nodes"`
+	NPS      int    `json:"nps"`
+	HashFull int    `json:"hashfull"`
+	Time     int    `json:"time"` // ms
+}
+
+// GameSummary is a finished game in full for external tooling: every move with its position,
+// how we found ours and the clocks, next to the game's record.
+type GameSummary struct {
+	Game         Game          `json:"game"`
+	Moves        []SummaryMove `json:"moves"`
+	BookMoves    int           `json:"book_moves"`
+	PositionsFed int           `json:"positions_fed"` // book moves the engine followed without a search
+	Ponders      int           `json:"ponders"`
+	PonderHits   int           `json:"ponder_hits"`
+	Clock        SummaryClock  `json:"clock"`
+}
+
+// SummaryMove is a move of a GameSummary.
+type SummaryMove struct {
+	Ply   int    `json:"ply"`
+	FEN   string `json:"fen"` // before the move
+	SAN   string `json:"san"`
+	Ours  bool   `json:"ours"`
+	Think int64  `json:"think,omitempty"` // ms, ours
+}
+
+// SummaryClock is the time control and the clocks at the end of a GameSummary's game, in ms.
+type SummaryClock struct {
+	Initial      int64 `json:"initial"`
+	Increment    int64 `json:"increment"`
+	OurTime      int64 `json:"our_time"`
+	OpponentTime int64 `json:"opponent_time"`
+	Think        int64 `json:"think"` // our moves' in total
+}
+
+func (g Game) Score() float64 {
+	switch g.Result {
+	case Win:
+		return 1
+	case Draw:
+		return 0.5
+	default:
+		return 0
+	}
+}
+
+// RatingKnown reports whether RatingAfter is known.
+func (g Game) RatingKnown() bool {
+	return g.RatingAfter != 0
+}
+
+// RatingDiff returns our rating change, 0 when it isn't known.
+func (g Game) RatingDiff() int {
+	if !g.RatingKnown() {
+		return 0
+	}
+	return g.RatingAfter - g.RatingBefore
+}
+
+type Session struct {
+	Start     int64                `json:"start"`
+	End       int64                `json:"end"`
+	Games     int                  `json:"games"`
+	Wins      int                  `json:"wins"`
+	Losses    int                  `json:"losses"`
+	Draws     int                  `json:"draws"`
+	Score     float64              `json:"score"`
+	Perfs     map[string]PerfDelta `json:"perfs,omitempty"`
+	BestWin   *Game                `json:"best_win,omitempty"`
+	WorstLoss *Game                `json:"worst_loss,omitempty"`
+}
+
+type PerfDelta struct {
+	Games  int `json:"games"`
+	Before int `json:"before"`
+	After  int `json:"after"`
+}
+
+func (p PerfDelta) Diff() int {
+	return p.After - p.Before
+}
+
+// Open returns the database kept in filename within store.
+func Open(store storage.Storage, filename string) *DB {
+	return &DB{store: store, filename: filename}
+}
+
+func (db *DB) AddGame(game Game) error {
+	return db.append(Record{Type: RecordGame, Game: &game})
+}
+
+func (db *DB) AddSummary(summary GameSummary) error {
+	return db.append(Record{Type: RecordSummary, Summary: &summary})
+}
+
+func (db *DB) AddSession(session Session) error {
+	return db.append(Record{Type: RecordSession, Session: &session})
+}
+
+func (db *DB) append(record Record) error {
+	if db == nil {
+		return nil
+	}
+
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	if err := db.store.AppendFile(db.filename, b); err != nil {
+		return fmt.Errorf("write file '%s': %v", db.filename, err)
+	}
+
+	return nil
+}
+
+// Records returns every record in the database, oldest first.
+func (db *DB) Records() ([]Record, error) {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	b, err := db.store.ReadFile(db.filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var records []Record
+	r := bufio.NewScanner(bytes.NewReader(b))
+	r.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; r.Scan(); line++ {
+		if len(bytes.TrimSpace(r.Bytes())) == 0 {
+			continue
+		}
+
+		var record Record
+		if err := json.Unmarshal(r.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("'%s' line %d: %v", db.filename, line, err)
+		}
+		records = append(records, record)
+	}
+
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// Games returns every finished game in the database, oldest first.
+func (db *DB) Games() ([]Game, error) {
+	records, err := db.Records()
+	if err != nil {
+		return nil, err
+	}
+
+	var games []Game
+	for _, record := range records {
+		if record.Type == RecordGame && record.Game != nil {
+			games = append(games, *record.Game)
+		}
+	}
+
+	return games, nil
+}
+
+// Summarize builds a session summary from games, in the order they were played.
+func Summarize(start, end int64, games []Game) Session {
+	s := Session{
+		Start: start,
+		End:   end,
+		Perfs: make(map[string]PerfDelta),
+	}
+
+	for i := range games {
+		game := games[i]
+
+		s.Games++
+		s.Score += game.Score()
+
+		switch game.Result {
+		case Win:
+			s.Wins++
+			if s.BestWin == nil || game.OpponentRating > s.BestWin.OpponentRating {
+				s.BestWin = &games[i]
+			}
+		case Loss:
+			s.Losses++
+			if s.WorstLoss == nil || game.OpponentRating < s.WorstLoss.OpponentRating {
+				s.WorstLoss = &games[i]
+			}
+		case Draw:
+			s.Draws++
+		}
+
+		if !game.Rated {
+			continue
+		}
+
+		perf, ok := s.Perfs[game.Perf]
+		if !ok {
+			perf.Before = game.RatingBefore
+		}
+		perf.Games++
+		perf.After = game.RatingAfter
+		if !game.RatingKnown() {
+			perf.After = game.RatingBefore // the last rating we know
+		}
+		s.Perfs[game.Perf] = perf
+	}
+
+	return s
+}
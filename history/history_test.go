@@ -0,0 +1,130 @@
+package history
+
+import (
+	"testing"
+
+	"trollfish-lichess/storage"
+)
+
+func TestSummarize(t *testing.T) {
+	// arrange
+	games := []Game{
+		{ID: "a", Rated: true, Perf: "blitz", Opponent: "x", OpponentRating: 2000, RatingBefore: 2100, RatingAfter: 2105, Result: Win},
+		{ID: "b", Rated: true, Perf: "blitz", Opponent: "y", OpponentRating: 2300, RatingBefore: 2105, RatingAfter: 2114, Result: Win},
+		{ID: "c", Rated: true, Perf: "bullet", Opponent: "z", OpponentRating: 1900, RatingBefore: 2000, RatingAfter: 1988, Result: Loss},
+		{ID: "e", Rated: true, Perf: "bullet", Opponent: "v", OpponentRating: 1950, RatingBefore: 1988, Result: Win}, // diff not reported
+		{ID: "d", Rated: false, Perf: "blitz", Opponent: "w", OpponentRating: 1500, RatingBefore: 2114, RatingAfter: 2114, Result: Draw},
+	}
+
+	// act
+	s := Summarize(1, 2, games)
+
+	// assert
+	if s.Games != 5 || s.Wins != 3 || s.Losses != 1 || s.Draws != 1 || s.Score != 3.5 {
+		t.Errorf("got %d games +%d -%d =%d score %v", s.Games, s.Wins, s.Losses, s.Draws, s.Score)
+	}
+	if blitz := s.Perfs["blitz"]; blitz.Games != 2 || blitz.Diff() != 14 {
+		t.Errorf("blitz: got %+v", blitz)
+	}
+	if bullet := s.Perfs["bullet"]; bullet.Games != 2 || bullet.Diff() != -12 {
+		t.Errorf("bullet: got %+v", bullet)
+	}
+	if s.BestWin == nil || s.BestWin.ID != "b" {
+		t.Errorf("best win: got %+v", s.BestWin)
+	}
+	if s.WorstLoss == nil || s.WorstLoss.ID != "c" {
+		t.Errorf("worst loss: got %+v", s.WorstLoss)
+	}
+}
+
+func TestDB_Games(t *testing.T) {
+	// arrange
+	db := Open(storage.NewMemory(), DefaultFilename)
+
+	// act
+	if err := db.AddGame(Game{ID: "a", Result: Win}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddSummary(GameSummary{Game: Game{ID: "a", Result: Win}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddSession(Session{Games: 1}); err != nil {
+		t.Fatal(err)
+	}
+	games, err := db.Games()
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(games) != 1 || games[0].ID != "a" {
+		t.Errorf("got %+v", games)
+	}
+}
+
+func TestOpeningStats(t *testing.T) {
+	// arrange
+	eval := func(cp int) *int { return &cp }
+	sicilian := []string{"e4", "c5", "Nf3", "d6", "d4"}
+	games := []Game{
+		{ID: "a", Color: "white", Result: Win, Opening: sicilian, BookPlies: 4, ExitEval: eval(30)},
+		{ID: "b", Color: "white", Result: Loss, Opening: sicilian, BookPlies: 4, ExitEval: eval(-100_000 + 5)},
+		{ID: "c", Color: "white", Result: Draw, Opening: []string{"e4", "e5", "Nf3"}, BookPlies: 2},
+		{ID: "d", Color: "black", Result: Win, Opening: []string{"e4", "c5", "Nf3"}, BookPlies: 1},
+		{ID: "e", Color: "white", Result: Win}, // not from the start position
+	}
+
+	// act
+	stats := OpeningStats(games, 2)
+
+	// assert
+	if len(stats) != 3 {
+		t.Fatalf("got %d openings, want 3: %+v", len(stats), stats)
+	}
+	worst := stats[0]
+	if worst.Line() != "1. e4 c5" || worst.Color != "white" || worst.Games != 2 || worst.Score != 0.5 {
+		t.Errorf("worst: got %s %s %d games score %v", worst.Color, worst.Line(), worst.Games, worst.Score)
+	}
+	if worst.ExitEvals != 2 || worst.ExitEval != (30-maxExitEval)/2.0 {
+		t.Errorf("exit eval: got %v over %d", worst.ExitEval, worst.ExitEvals)
+	}
+	if got := worst.TopDeviations(3); len(got) != 1 || got[0] != "2...d6" || worst.Deviations["2...d6"] != 2 {
+		t.Errorf("deviations: got %v", worst.Deviations)
+	}
+	if stats[2].Color != "black" || stats[2].TopDeviations(1)[0] != "1.e4" {
+		t.Errorf("black: got %+v", stats[2])
+	}
+}
+
+func TestExperimentStats(t *testing.T) {
+	// arrange
+	acpl := func(v int) *int { return &v }
+	games := []Game{
+		{Experiment: "agro", Arm: "a", Result: Win, ACPL: acpl(20)},
+		{Experiment: "agro", Arm: "a", Result: Win, ACPL: acpl(30)},
+		{Experiment: "agro", Arm: "a", Result: Draw},
+		{Experiment: "agro", Arm: "b", Result: Loss, ACPL: acpl(40)},
+		{Experiment: "agro", Arm: "b", Result: Draw, ACPL: acpl(50)},
+		{Experiment: "other", Arm: "a", Result: Loss},
+		{Result: Loss},
+	}
+
+	// act
+	stats := ExperimentStats(games, "agro", []string{"a", "b"})
+	diff, ci := ScoreDiff(stats[0], stats[1])
+
+	// assert
+	a, b := stats[0], stats[1]
+	if a.Games != 3 || a.Wins != 2 || a.Draws != 1 || a.ACPLGames != 2 || a.ACPL != 25 {
+		t.Errorf("a: got %+v", a)
+	}
+	if b.Games != 2 || b.Losses != 1 || b.Score != 0.25 || b.ACPL != 45 {
+		t.Errorf("b: got %+v", b)
+	}
+	if want := 0.25 - 5.0/6; diff < want-1e-9 || diff > want+1e-9 {
+		t.Errorf("score diff: got %v, want %v", diff, want)
+	}
+	if ci <= 0 {
+		t.Errorf("score diff ci: got %v, want > 0", ci)
+	}
+}
@@ -0,0 +1,150 @@
+package history
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// OpeningPlies is the number of plies of each game kept in Game.Opening.
+const OpeningPlies = 24
+
+// maxExitEval caps the evals averaged by OpeningStats, so a mate doesn't swamp the rest.
+const maxExitEval = 1000
+
+// OpeningStat is our record in the games sharing an opening line and color.
+type OpeningStat struct {
+	Color      string         `json:"color"`
+	Moves      []string       `json:"moves"`
+	Games      int            `json:"games"`
+	Wins       int            `json:"wins"`
+	Draws      int            `json:"draws"`
+	Losses     int            `json:"losses"`
+	Score      float64        `json:"score"` // points per game, 0-1
+	ExitEval   float64        `json:"exit_eval"`
+	ExitEvals  int            `json:"exit_evals"` // games ExitEval is averaged over
+	Deviations map[string]int `json:"deviations,omitempty"`
+}
+
+// Line returns the opening's moves with move numbers, e.g. "1. e4 c5 2. Nf3".
+func (s OpeningStat) Line() string {
+	if len(s.Moves) == 0 {
+		return "(start)"
+	}
+	var sb strings.Builder
+	for i, move := range s.Moves {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		if i%2 == 0 {
+			sb.WriteString(fmt.Sprintf("%d. ", i/2+1))
+		}
+		sb.WriteString(move)
+	}
+	return sb.String()
+}
+
+// TopDeviations returns the n most common opponent moves that took us out of book.
+func (s OpeningStat) TopDeviations(n int) []string {
+	moves := make([]string, 0, len(s.Deviations))
+	for move := range s.Deviations {
+		moves = append(moves, move)
+	}
+	sort.Slice(moves, func(i, j int) bool {
+		if s.Deviations[moves[i]] != s.Deviations[moves[j]] {
+			return s.Deviations[moves[i]] > s.Deviations[moves[j]]
+		}
+		return moves[i] < moves[j]
+	})
+	if len(moves) > n {
+		moves = moves[:n]
+	}
+	return moves
+}
+
+// OpeningStats groups games played from the start position by color and their first
+// plies moves, and returns the groups worst score first. Games shorter than plies are
+// grouped by all of their moves.
+func OpeningStats(games []Game, plies int) []OpeningStat {
+	index := make(map[string]int)
+	var stats []OpeningStat
+
+	for _, game := range games {
+		if game.Opening == nil {
+			continue
+		}
+
+		moves := game.Opening
+		if len(moves) > plies {
+			moves = moves[:plies]
+		}
+		key := game.Color + " " + strings.Join(moves, " ")
+
+		i, ok := index[key]
+		if !ok {
+			i = len(stats)
+			index[key] = i
+			stats = append(stats, OpeningStat{
+				Color:      game.Color,
+				Moves:      moves,
+				Deviations: make(map[string]int),
+			})
+		}
+		s := &stats[i]
+
+		s.Games++
+		s.Score += game.Score()
+		switch game.Result {
+		case Win:
+			s.Wins++
+		case Draw:
+			s.Draws++
+		case Loss:
+			s.Losses++
+		}
+
+		if game.ExitEval != nil {
+			eval := *game.ExitEval
+			if eval > maxExitEval {
+				eval = maxExitEval
+			} else if eval < -maxExitEval {
+				eval = -maxExitEval
+			}
+			s.ExitEval += float64(eval)
+			s.ExitEvals++
+		}
+
+		if deviation := game.Deviation(); deviation != "" {
+			s.Deviations[deviation]++
+		}
+	}
+
+	for i := range stats {
+		stats[i].Score /= float64(stats[i].Games)
+		if stats[i].ExitEvals > 0 {
+			stats[i].ExitEval /= float64(stats[i].ExitEvals)
+		}
+	}
+
+	sort.SliceStable(stats, func(i, j int) bool {
+		if stats[i].Score != stats[j].Score {
+			return stats[i].Score < stats[j].Score
+		}
+		return stats[i].Games > stats[j].Games
+	})
+
+	return stats
+}
+
+// Deviation returns the opponent's move that took us out of book, with its move number,
+// e.g. "3...a6". It's empty if we never had a book move or the move isn't in Opening.
+func (g Game) Deviation() string {
+	ply := g.BookPlies - 1
+	if g.BookPlies == 0 || ply >= len(g.Opening) {
+		return ""
+	}
+	if ply%2 == 0 {
+		return fmt.Sprintf("%d.%s", ply/2+1, g.Opening[ply])
+	}
+	return fmt.Sprintf("%d...%s", ply/2+1, g.Opening[ply])
+}
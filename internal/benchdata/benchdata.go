@@ -0,0 +1,30 @@
+// Package benchdata has the positions the board and book benchmarks look up.
+package benchdata
+
+import (
+	"math/rand"
+
+	"trollfish-lichess/fen"
+)
+
+// Positions is about the size of the bot's books and EPD files.
+const Positions = 10000
+
+// Boards returns n positions with legal moves from random games of up to 40 plies, the same
+// every run.
+func Boards(n int) []fen.Board {
+	r := rand.New(rand.NewSource(1))
+	start := fen.FENtoBoard("rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1")
+	boards := make([]fen.Board, 0, n)
+	board := start
+	for len(boards) < n {
+		moves := board.AllLegalMoves()
+		if len(moves) == 0 || board.FullMove > 20 {
+			board = start
+			continue
+		}
+		boards = append(boards, board)
+		board.Moves(moves[r.Intn(len(moves))].UCI)
+	}
+	return boards
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"trollfish-lichess/analyze"
+	"trollfish-lichess/uciproc"
+	"trollfish-lichess/yamlbook"
+)
+
+const (
+	JobUpdateBook      = "update-book"
+	JobAnalyzePGN      = "analyze-pgn"
+	JobBookFsck        = "book-fsck"
+	JobExportCloudEval = "export-cloud-eval"
+	JobEPDToYAMLBook   = "epd-to-yamlbook"
+)
+
+// JobFile is a batch of analysis tasks run by -run-jobs. It's YAML, so JSON works too:
+//
+//	parallel: 2
+//	report: nightly.json
+//	options: {max_time: 30m}
+//	jobs:
+//	  - {name: review, type: update-book, book: book.yamlbook}
+//	  - {type: analyze-pgn, pgn: games.pgn, book: book.yamlbook, output: games.eval.pgn, thresholds: {elo_scale: true}}
+//	  - {type: export-cloud-eval, book: book.yamlbook, output: book.cloudeval.jsonl}
+//
+// Jobs start in file order. Engine jobs running at the same time split the engine's
+// threads and hash between them, and jobs using the same book never run at the same time.
+type JobFile struct {
+	Parallel int         `yaml:"parallel" json:"parallel"`
+	Report   string      `yaml:"report" json:"report"` // JSON file the results are written to
+	Options  *JobOptions `yaml:"options" json:"options"`
+	Jobs     []Job       `yaml:"jobs" json:"jobs"`
+}
+
+type Job struct {
+	Name        string              `yaml:"name" json:"name"`
+	Type        string              `yaml:"type" json:"type"`
+	Book        string              `yaml:"book" json:"book"`
+	PGN         string              `yaml:"pgn" json:"pgn"`
+	EPD         string              `yaml:"epd" json:"epd"`
+	FENs        []string            `yaml:"fens" json:"fens"` // FENs or files of FENs, see -fen
+	SearchMoves string              `yaml:"search_moves" json:"search_moves"`
+	Output      string              `yaml:"output" json:"output"`         // eval PGN, cloud eval or YAML book, depending on type
+	Critical    string              `yaml:"critical" json:"critical"`     // analyze-pgn: EPD file critical positions are appended to
+	Review      bool                `yaml:"review" json:"review"`         // analyze-pgn: queue critical positions for book review
+	Thresholds  *analyze.Thresholds `yaml:"thresholds" json:"thresholds"` // analyze-pgn: annotation thresholds, unset ones are lichess'
+	Options     *JobOptions         `yaml:"options" json:"options"`       // overrides the file's options
+}
+
+// JobOptions override fields of the default analysis options. Durations use Go syntax, e.g. 90s.
+type JobOptions struct {
+	MinDepth    int    `yaml:"min_depth" json:"min_depth"`
+	MaxDepth    int    `yaml:"max_depth" json:"max_depth"`
+	MinTime     string `yaml:"min_time" json:"min_time"`
+	MaxTime     string `yaml:"max_time" json:"max_time"`
+	DepthDelta  int    `yaml:"depth_delta" json:"depth_delta"`
+	MultiPV     int    `yaml:"multipv" json:"multipv"`
+	MinNodes    int    `yaml:"min_nodes" json:"min_nodes"`
+	ScoreDelta  int    `yaml:"score_delta" json:"score_delta"`
+	ClearMargin int    `yaml:"clear_margin" json:"clear_margin"`
+}
+
+type JobResult struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	OK      bool   `json:"ok"`
+	Error   string `json:"error,omitempty"`
+	Started int64  `json:"started"`
+	Elapsed string `json:"elapsed"`
+}
+
+func LoadJobFile(filename string) (*JobFile, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs JobFile
+	if err := yaml.Unmarshal(b, &jobs); err != nil {
+		return nil, fmt.Errorf("'%s': %v", filename, err)
+	}
+
+	if jobs.Parallel < 1 {
+		jobs.Parallel = 1
+	}
+
+	for i := range jobs.Jobs {
+		job := &jobs.Jobs[i]
+		if job.Name == "" {
+			job.Name = fmt.Sprintf("%d-%s", i+1, job.Type)
+		}
+		if err := job.validate(); err != nil {
+			return nil, fmt.Errorf("'%s' job '%s': %v", filename, job.Name, err)
+		}
+		if _, err := job.options(jobs.Options); err != nil {
+			return nil, fmt.Errorf("'%s' job '%s': %v", filename, job.Name, err)
+		}
+	}
+
+	return &jobs, nil
+}
+
+func (j Job) validate() error {
+	need := func(field, value string) error {
+		if value == "" {
+			return fmt.Errorf("%s needs '%s'", j.Type, field)
+		}
+		return nil
+	}
+
+	switch j.Type {
+	case JobUpdateBook, JobBookFsck, JobExportCloudEval:
+		return need("book", j.Book)
+	case JobAnalyzePGN:
+		if j.Thresholds != nil {
+			if err := j.Thresholds.WithDefaults().Validate(); err != nil {
+				return err
+			}
+		}
+		return need("pgn", j.PGN)
+	case JobEPDToYAMLBook:
+		return need("epd", j.EPD)
+	case "":
+		return fmt.Errorf("missing type")
+	default:
+		return fmt.Errorf("unknown type '%s'", j.Type)
+	}
+}
+
+// usesEngine reports whether the job runs the analysis engine.
+func (j Job) usesEngine() bool {
+	return j.Type == JobUpdateBook || j.Type == JobAnalyzePGN
+}
+
+// bookKey is the file the job writes, so jobs on the same book can be kept apart.
+func (j Job) bookKey() string {
+	switch j.Type {
+	case JobEPDToYAMLBook:
+		if j.Output == "" {
+			return ""
+		}
+		return filepath.Clean(j.Output)
+	case JobAnalyzePGN:
+		if j.Book == "" {
+			return ""
+		}
+	}
+	return filepath.Clean(j.Book)
+}
+
+func (j Job) options(defaults *JobOptions) (analyze.AnalysisOptions, error) {
+	opts := defaultAnalysisOptions
+	for _, o := range []*JobOptions{defaults, j.Options} {
+		if o == nil {
+			continue
+		}
+		if o.MinDepth > 0 {
+			opts.MinDepth = o.MinDepth
+		}
+		if o.MaxDepth > 0 {
+			opts.MaxDepth = o.MaxDepth
+		}
+		if o.DepthDelta > 0 {
+			opts.DepthDelta = o.DepthDelta
+		}
+		if o.MultiPV > 0 {
+			opts.MultiPV = o.MultiPV
+		}
+		if o.MinNodes > 0 {
+			opts.MinNodes = o.MinNodes
+		}
+		if o.ScoreDelta > 0 {
+			opts.ScoreDelta = o.ScoreDelta
+		}
+		if o.ClearMargin > 0 {
+			opts.ClearMargin = o.ClearMargin
+		}
+		for _, d := range []struct {
+			s   string
+			dst *time.Duration
+		}{{o.MinTime, &opts.MinTime}, {o.MaxTime, &opts.MaxTime}} {
+			if d.s == "" {
+				continue
+			}
+			v, err := time.ParseDuration(d.s)
+			if err != nil {
+				return opts, err
+			}
+			*d.dst = v
+		}
+	}
+	return opts, nil
+}
+
+// RunJobs runs every job in jobs, at most jobs.Parallel at a time, and returns the results in
+// file order. A failed job doesn't stop the others.
+func RunJobs(ctx context.Context, jobs *JobFile) []JobResult {
+	results := make([]JobResult, len(jobs.Jobs))
+
+	engineJobs := 0
+	for _, job := range jobs.Jobs {
+		if job.usesEngine() {
+			engineJobs++
+		}
+	}
+	shares := jobs.Parallel
+	if engineJobs < shares {
+		shares = engineJobs
+	}
+	resources := analyze.Resources
+	if shares > 1 {
+		resources = resources.Override(max(1, resources.Threads/shares), max(16, resources.HashMB/shares))
+	}
+
+	var booksMtx sync.Mutex
+	books := make(map[string]*sync.Mutex)
+	lockBook := func(key string) func() {
+		if key == "" {
+			return func() {}
+		}
+		booksMtx.Lock()
+		mtx, ok := books[key]
+		if !ok {
+			mtx = &sync.Mutex{}
+			books[key] = mtx
+		}
+		booksMtx.Unlock()
+
+		mtx.Lock()
+		return mtx.Unlock
+	}
+
+	sem := make(chan struct{}, jobs.Parallel)
+	var wg sync.WaitGroup
+	for i := range jobs.Jobs {
+		job := jobs.Jobs[i]
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			unlock := lockBook(job.bookKey())
+			defer unlock()
+
+			start := time.Now()
+			fmt.Printf("%s job %s: starting %s\n", ts(), job.Name, job.Type)
+
+			err := ctx.Err()
+			if err == nil {
+				err = job.run(ctx, jobs.Options, resources)
+			}
+
+			results[i] = JobResult{
+				Name:    job.Name,
+				Type:    job.Type,
+				OK:      err == nil,
+				Started: start.Unix(),
+				Elapsed: time.Since(start).Round(time.Second).String(),
+			}
+			if err != nil {
+				results[i].Error = err.Error()
+				fmt.Printf("%s *** ERR: job %s: %v\n", ts(), job.Name, err)
+				return
+			}
+			fmt.Printf("%s job %s: done in %s\n", ts(), job.Name, results[i].Elapsed)
+		}(i)
+	}
+	wg.Wait()
+
+	return results
+}
+
+func (j Job) run(ctx context.Context, defaults *JobOptions, resources uciproc.Resources) error {
+	opts, err := j.options(defaults)
+	if err != nil {
+		return err
+	}
+
+	newAnalyzer := func() *analyze.Analyzer {
+		a := analyze.New()
+		a.Resources = resources
+		a.EvalPGN = j.Output
+		a.CriticalEPD = j.Critical
+		a.CriticalReview = j.Review
+		if j.Thresholds != nil {
+			a.Thresholds = j.Thresholds.WithDefaults()
+		}
+		return a
+	}
+
+	switch j.Type {
+	case JobUpdateBook:
+		var fens []string
+		for _, f := range j.FENs {
+			more, err := loadFENs(f)
+			if err != nil {
+				return err
+			}
+			fens = append(fens, more...)
+		}
+		return UpdateFile(ctx, newAnalyzer(), j.Book, opts, fens, j.SearchMoves)
+	case JobAnalyzePGN:
+		var book *yamlbook.Book
+		if j.Book != "" {
+			if book, err = yamlbook.Load(j.Book); err != nil {
+				return err
+			}
+		}
+		return newAnalyzer().AnalyzePGNFile(ctx, opts, j.PGN, book)
+	case JobBookFsck:
+		return FsckBook(j.Book)
+	case JobExportCloudEval:
+		output := j.Output
+		if output == "" {
+			output = strings.TrimSuffix(j.Book, filepath.Ext(j.Book)) + ".cloudeval.jsonl"
+		}
+		count, err := ExportCloudEvals(j.Book, output)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("saved %s with %d position(s)\n", output, count)
+		return nil
+	case JobEPDToYAMLBook:
+		return EPDToYAMLBook(j.EPD, j.Output)
+	}
+
+	return fmt.Errorf("unknown type '%s'", j.Type)
+}
+
+// SaveJobReport writes results as JSON to filename.
+func SaveJobReport(filename string, results []JobResult) error {
+	b, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(filename, b, 0644); err != nil {
+		return fmt.Errorf("write file '%s': %v", filename, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	filename := filepath.Join(dir, name)
+	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestLoadJobFile(t *testing.T) {
+	// arrange
+	dir := t.TempDir()
+	filename := writeTestFile(t, dir, "jobs.yaml", `
+parallel: 2
+options: {max_time: 30m, multipv: 3}
+jobs:
+  - {name: review, type: update-book, book: book.yamlbook, options: {max_time: 5m}}
+  - {type: analyze-pgn, pgn: games.pgn}
+`)
+	bad := writeTestFile(t, dir, "bad.json", `{"jobs": [{"type": "update-book"}]}`)
+
+	// act
+	jobs, err := LoadJobFile(filename)
+	_, badErr := LoadJobFile(bad)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jobs.Parallel != 2 || len(jobs.Jobs) != 2 {
+		t.Fatalf("got parallel %d jobs %d", jobs.Parallel, len(jobs.Jobs))
+	}
+	if jobs.Jobs[1].Name != "2-analyze-pgn" {
+		t.Errorf("default name: got %s", jobs.Jobs[1].Name)
+	}
+
+	opts, err := jobs.Jobs[0].options(jobs.Options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.MaxTime != 5*time.Minute || opts.MultiPV != 3 || opts.MinDepth != defaultAnalysisOptions.MinDepth {
+		t.Errorf("options: got %+v", opts)
+	}
+
+	if badErr == nil {
+		t.Error("update-book without book: want error")
+	}
+}
+
+func TestRunJobs(t *testing.T) {
+	// arrange
+	dir := t.TempDir()
+	book := writeTestFile(t, dir, "book.yamlbook", "version: 1\npositions: []\n")
+	jobs := &JobFile{
+		Parallel: 2,
+		Jobs: []Job{
+			{Name: "fsck", Type: JobBookFsck, Book: book},
+			{Name: "missing", Type: JobBookFsck, Book: filepath.Join(dir, "missing.yamlbook")},
+		},
+	}
+
+	// act
+	results := RunJobs(context.Background(), jobs)
+
+	// assert
+	if len(results) != 2 {
+		t.Fatalf("got %d results", len(results))
+	}
+	if !results[0].OK || results[0].Name != "fsck" {
+		t.Errorf("fsck: got %+v", results[0])
+	}
+	if results[1].OK || results[1].Error == "" {
+		t.Errorf("missing: got %+v", results[1])
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"trollfish-lichess/fen"
+)
+
+// checkMoves plays the UCI moves of a game state on board, returning an error for the first
+// move that isn't legal. lichess only sends legal moves, but a malformed stream would
+// otherwise panic converting them to SAN.
+func checkMoves(board fen.Board, moves []string) error {
+	for i, move := range moves {
+		if !board.IsLegal(move) {
+			return fmt.Errorf("move %d '%s' is illegal in '%s'", i+1, move, board.FEN())
+		}
+		board.Moves(move)
+	}
+	return nil
+}
+
+// fetchMoves returns the game's moves in UCI from the lichess API, to resync when the stream
+// sent moves that aren't legal.
+func (g *Game) fetchMoves() ([]string, error) {
+	game, err := g.fetchGame(g.gameID)
+	if err != nil {
+		return nil, err
+	}
+
+	moves, err := fen.FENtoBoard(g.initialFEN).SANtoUCIs(strings.Fields(game.Moves)...)
+	if err != nil {
+		return nil, fmt.Errorf("'%s': %v", g.gameID, err)
+	}
+	return moves, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"trollfish-lichess/api"
+	"trollfish-lichess/fen"
+	"trollfish-lichess/storage"
+	"trollfish-lichess/yamlbook"
+)
+
+func TestCheckMoves(t *testing.T) {
+	cases := []struct {
+		moves []string
+		ok    bool
+	}{
+		{moves: nil, ok: true},
+		{moves: []string{"e2e4", "e7e5", "g1f3"}, ok: true},
+		{moves: []string{"e2e4", "e2e4"}},
+		{moves: []string{"e2e4", "e7e4"}},
+		{moves: []string{"e2"}},
+	}
+
+	for _, c := range cases {
+		// act
+		err := checkMoves(fen.FENtoBoard(startPosFEN), c.moves)
+
+		// assert
+		if (err == nil) != c.ok {
+			t.Errorf("%v: got %v, want ok %v", c.moves, err, c.ok)
+		}
+	}
+}
+
+func TestGame_ResyncIllegalMoves(t *testing.T) {
+	// arrange
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	engine := fakeEngine(ctx, map[string]string{
+		"position startpos":                 "bestmove e2e4 eval 0.30",
+		"position startpos moves e2e4 e7e5": "bestmove g1f3 eval 0.35",
+	})
+
+	sent := sentMoves{moves: make(chan string, 4)}
+	g := NewGame(ctx, "test", storage.NewMemory(), engine, &yamlbook.Book{}, nil, GameOptions{}, nil)
+	g.sendMove = sent.send
+	var fetches int // only the event loop fetches
+	g.fetchGame = func(gameID string) (api.CompletedGame, error) {
+		if fetches++; fetches == 1 {
+			return api.CompletedGame{}, errors.New("unavailable")
+		}
+		return api.CompletedGame{ID: gameID, Moves: "e4 e5"}, nil
+	}
+
+	g.post([]byte(testGameFull))
+	if move := <-sent.moves; move != "e2e4" {
+		t.Fatalf("first move: got %s, want e2e4", move)
+	}
+
+	// act: the API is down, the state is ignored, then it's resynced from the API
+	g.post([]byte(`{"type":"gameState","moves":"e2e4 e7e4","wtime":59000,"btime":60000,"status":"started"}`))
+	g.post([]byte(`{"type":"gameState","moves":"e2e4 e7e4","wtime":59000,"btime":60000,"status":"started"}`))
+
+	// assert
+	if move := <-sent.moves; move != "g1f3" {
+		t.Errorf("got %s, want g1f3", move)
+	}
+}
@@ -5,9 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"math/rand"
 	"path/filepath"
 	"sort"
 	"strconv"
@@ -15,14 +13,29 @@ import (
 	"sync"
 	"time"
 
-	"trollfish-lichess/analyze"
 	"trollfish-lichess/api"
+	"trollfish-lichess/eventbus"
+	"trollfish-lichess/fen"
+	"trollfish-lichess/storage"
+	"trollfish-lichess/uciproc"
 	"trollfish-lichess/yamlbook"
 )
 
 const botID = "trollololfish"
 const startPosFEN = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
 
+// variantFromPosition is lichess' variant for games from a custom setup FEN.
+const variantFromPosition = "fromPosition"
+
+// bannedFilename lists bots that refused our challenges, so we stop challenging them, see
+// storage.Bans.
+const bannedFilename = "banned.json"
+
+const (
+	banTimeoutExpiry = 12 * time.Hour // a bot that didn't answer a challenge gets another one after this
+	banNotNowExpiry  = 24 * time.Hour // a bot that isn't accepting challenges for now
+)
+
 const maxRating = 4000
 const minRating = 2500
 
@@ -32,7 +45,12 @@ const minRating = 2500
 type Listener struct {
 	ctx context.Context
 
-	book *yamlbook.Book
+	book     *yamlbook.Book
+	store    storage.Storage
+	variety  *Variety
+	gameOpts GameOptions
+	session  *Session
+	auditDir string
 
 	activeGameMtx sync.Mutex
 	activeGame    *Game
@@ -42,6 +60,9 @@ type Listener struct {
 
 	botQueueMtx sync.Mutex
 	botQueue    *api.BotQueue
+	sparring    []string // lowercase ids of bots challenged before the rest, see challengeTargets
+	declines    *Declines
+	teams       *TeamFilter // nil accepts challenges from anyone
 
 	challengePending bool
 	declined         chan api.Challenge
@@ -49,18 +70,43 @@ type Listener struct {
 	onlyUser         string
 	fenPos           string
 	tc               TimeControl
+	color            ChallengeColor
+
+	lastColorMtx sync.Mutex
+	lastColor    map[string]string
+
+	engine        *Engine
+	resources     uciproc.Resources
+	engineRestart func() error // starts a new engine process on the same channels, see engineProcess
+
+	engineRestartMtx sync.Mutex
+	freshEngineGames int // see freshEngine, 0 = one engine process for the session
+	gamesOnEngine    int
 
-	input  chan<- string
-	output <-chan string
+	maintenanceMtx      sync.Mutex
+	maintenance         *Maintenance // nil when taking challenges
+	maintenanceDefaults Maintenance  // for the admin command
+
+	degradedMtx   sync.Mutex
+	degradedSince time.Time // zero while lichess is up, see setDegraded
+
+	eventStream    streamWatch
+	healthMtx      sync.Mutex
+	engineErr      error // the watchdog's last isready, see watchdogCheck
+	engineChecked  time.Time
+	engineRestarts int
 }
 
+// TimeControl is the clock of the challenges we send, Limit and Increment in seconds.
 type TimeControl struct {
 	Limit     int
 	Increment int
+	Type      ClockType
 }
 
+// Parse reads "mins+secs" for Fischer increment, where 0+secs is increment only.
 func (tc *TimeControl) Parse(text string) error {
-	const tcMsg = "-tc needs to be in the format mins+secs, ex: 3+2, 1+0, 15+10, etc"
+	const tcMsg = "-tc needs to be in the format mins+secs, ex: 3+2, 1+0, 15+10, 0+2"
 
 	parts := strings.Split(text, "+")
 	if len(parts) != 2 {
@@ -77,7 +123,7 @@ func (tc *TimeControl) Parse(text string) error {
 		return errors.New(tcMsg)
 	}
 
-	if tcMins < 0 || tcSecs < 0 {
+	if tcMins < 0 || tcSecs < 0 || (tcMins == 0 && tcSecs == 0) {
 		return errors.New(tcMsg)
 	}
 
@@ -87,24 +133,77 @@ func (tc *TimeControl) Parse(text string) error {
 		tc.Limit = tcMins * 60
 	}
 	tc.Increment = tcSecs
+	tc.Type = iif(tc.Limit == 0, ClockIncrementOnly, ClockFischer)
 
 	return nil
 }
 
-func New(ctx context.Context, input chan<- string, output <-chan string, onlyUser, challenge string, tc TimeControl, fenPos string) *Listener {
+type ChallengeColor struct {
+	Color     string
+	Alternate bool
+}
+
+func (cc *ChallengeColor) Parse(text string, alternate bool) error {
+	switch text {
+	case "white", "black", "random":
+		cc.Color = text
+	case "w":
+		cc.Color = "white"
+	case "b":
+		cc.Color = "black"
+	case "":
+		cc.Color = "random"
+	default:
+		return fmt.Errorf("-color must be white, black or random, got '%s'", text)
+	}
+
+	cc.Alternate = alternate
+
+	return nil
+}
+
+// Next returns the color to request from userID. When alternating, the color we played
+// in the last game against userID is flipped.
+func (cc ChallengeColor) Next(lastColor string) string {
+	if !cc.Alternate {
+		return cc.Color
+	}
+
+	switch lastColor {
+	case "white":
+		return "black"
+	case "black":
+		return "white"
+	default:
+		return cc.Color
+	}
+}
+
+func New(ctx context.Context, store storage.Storage, engine *Engine, resources uciproc.Resources, onlyUser, challenge string, tc TimeControl, color ChallengeColor, fenPos string, variety *Variety, gameOpts GameOptions, session *Session, auditDir string, maintenance Maintenance, sparring []string) *Listener {
 	l := Listener{
-		ctx:      ctx,
-		input:    input,
-		output:   output,
-		declined: make(chan api.Challenge, 512),
-		accepted: make(chan api.GameEventInfo, 512),
-		onlyUser: strings.ToLower(onlyUser),
-		fenPos:   fenPos,
-		tc:       tc,
-	}
-	input <- "uci"
-	input <- "setoption name Ponder value true"
-	input <- fmt.Sprintf("setoption name SyzygyPath value %s", analyze.SyzygyPath)
+		ctx:       ctx,
+		store:     store,
+		variety:   variety,
+		gameOpts:  gameOpts,
+		session:   session,
+		auditDir:  auditDir,
+		engine:    engine,
+		resources: resources,
+		declined:  make(chan api.Challenge, 512),
+		accepted:  make(chan api.GameEventInfo, 512),
+		onlyUser:  strings.ToLower(onlyUser),
+		fenPos:    fenPos,
+		tc:        tc,
+		color:     color,
+		lastColor: make(map[string]string),
+
+		maintenanceDefaults: maintenance,
+		sparring:            sparring,
+	}
+	fmt.Printf("%s engine %v\n", ts(), resources)
+	if err := l.initEngine(); err != nil {
+		log.Fatal(err)
+	}
 
 	if err := l.importBook("book.yamlbook"); err != nil {
 		log.Fatal(err)
@@ -115,7 +214,7 @@ func New(ctx context.Context, input chan<- string, output <-chan string, onlyUse
 
 	if onlyUser == "" {
 		go func() {
-			botQueue, err := api.StreamBots()
+			botQueue, err := api.StreamBots(ctx)
 			if err != nil {
 				log.Printf("ERR: %v", err)
 			}
@@ -124,13 +223,14 @@ func New(ctx context.Context, input chan<- string, output <-chan string, onlyUse
 			l.botQueue = botQueue
 			l.botQueueMtx.Unlock()
 
+			go l.refreshBots()
 			l.challengeBot()
 		}()
 	}
 
 	if challenge != "" {
 		go func() {
-			l.challenge(challenge, false, tc.Limit, tc.Increment, "random", fenPos)
+			l.challenge(challenge, false, tc.Limit, tc.Increment, l.challengeColor(challenge), fenPos)
 		}()
 	}
 
@@ -187,7 +287,8 @@ func (l *Listener) Events() error {
 				log.Fatalf("%v json: '%s' len=%d", err, ndjson, len(ndjson))
 			}
 			g := gameEvent.Game
-			game := NewGame(g.GameID, l.input, l.output, l.book)
+			audit := NewAudit(l.store, l.auditDir, g.GameID)
+			game := NewGame(l.ctx, g.GameID, l.store, l.engine, l.book, l.variety, l.gameOpts, audit)
 
 			l.activeGameMtx.Lock()
 			if l.activeGame != nil {
@@ -201,6 +302,8 @@ func (l *Listener) Events() error {
 			l.activeGame = game
 			l.activeGameMtx.Unlock()
 
+			eventbus.Publish(l.gameOpts.Bus, GameStarted{Game: g})
+			game.useExperimentArm(l.gameOpts.Experiment.Next())
 			go game.StreamGameEvents()
 
 			l.accepted <- g
@@ -212,9 +315,13 @@ func (l *Listener) Events() error {
 
 			l.activeGameMtx.Lock()
 			if l.activeGame != nil && l.activeGame.gameID == gameEvent.Game.ID {
-				l.activeGame.Finish()
+				game := l.activeGame
+				game.Finish()
+				eventbus.Publish(l.gameOpts.Bus, GameFinished{GameID: game.gameID})
+				l.session.Record(game)
 			}
 			l.activeGameMtx.Unlock()
+			l.freshEngine()
 			return !l.Quit()
 		} else if event.Type == "challengeCanceled" {
 			// TODO: remove from queue
@@ -237,11 +344,7 @@ func (l *Listener) Events() error {
 
 	go l.processChallengeQueue()
 
-	if err := api.ReadStream("https://lichess.org/api/stream/event", handler); err != nil {
-		return err
-	}
-
-	return nil
+	return readStreamRetrying(l.ctx, "https://lichess.org/api/stream/event", &l.eventStream, l.setRecovered, l.setDegraded, handler)
 }
 
 func (l *Listener) QueueChallenge(c api.Challenge) error {
@@ -253,36 +356,57 @@ func (l *Listener) QueueChallenge(c api.Challenge) error {
 		return nil
 	}
 
+	eventbus.Publish(l.gameOpts.Bus, ChallengeReceived{Challenge: c})
+
+	if m, ok := l.Maintenance(); ok {
+		return l.declineChallenge(c, m.Reason)
+	}
+
 	if l.onlyUser != "" {
 		if !strings.EqualFold(c.Challenger.Name, l.onlyUser) && !strings.EqualFold(c.Challenger.Name, "bantercode") {
-			if err := api.DeclineChallenge(c.ID, "later"); err != nil {
+			if err := l.declineChallenge(c, "later"); err != nil {
 				return err
 			}
 			return nil
 		}
 	}
 
-	// only use standard initial position
-	if c.InitialFEN != "" && c.InitialFEN != "startpos" && !strings.EqualFold(c.Challenger.Name, l.onlyUser) {
-		if err := api.DeclineChallenge(c.ID, "standard"); err != nil {
+	if allowed, err := l.teams.Allows(l.ctx, opp.ID, time.Now()); err != nil || !allowed {
+		if err != nil {
+			fmt.Printf("%s *** ERR: teams of %s: %v\n", ts(), opp.ID, err)
+		}
+		return l.declineChallenge(c, iif(err != nil, "later", "generic"))
+	}
+
+	tc := c.TimeControl
+
+	// standard, or casual games from a position; no variants e.g. Chess960
+	fromPosition := c.Variant.Key == variantFromPosition
+	if c.Variant.Key != "standard" && !fromPosition {
+		if err := l.declineChallenge(c, "standard"); err != nil {
 			return err
 		}
 		return nil
 	}
 
-	tc := c.TimeControl
+	if fromPosition && c.Rated {
+		if err := l.declineChallenge(c, "casual"); err != nil {
+			return err
+		}
+		return nil
+	}
 
-	// standard; no variants e.g. Chess960
-	if c.Variant.Key != "standard" {
-		if err := api.DeclineChallenge(c.ID, "standard"); err != nil {
+	if err := fen.ValidFEN(c.InitialFEN); err != nil {
+		fmt.Printf("%s declining %s: %v\n", ts(), c.ID, err)
+		if err := l.declineChallenge(c, "standard"); err != nil {
 			return err
 		}
 		return nil
 	}
 
-	// no unlimited, correspondence, etc
-	if tc.Type != "clock" {
-		if err := api.DeclineChallenge(c.ID, "timeControl"); err != nil {
+	// no unlimited, correspondence, etc, unless casual games without a clock are accepted
+	if tc.Type != "clock" && (c.Rated || !l.gameOpts.Clockless.Accept) {
+		if err := l.declineChallenge(c, "timeControl"); err != nil {
 			return err
 		}
 		return nil
@@ -292,7 +416,7 @@ func (l *Listener) QueueChallenge(c api.Challenge) error {
 	// if time is 1 minute or higher, max increment is 5s
 	// below 1 minute we accept higher increments
 	if tc.Limit > 300 || (tc.Increment > 5 && tc.Limit >= 60) {
-		if err := api.DeclineChallenge(c.ID, "tooSlow"); err != nil {
+		if err := l.declineChallenge(c, "tooSlow"); err != nil {
 			return err
 		}
 		return nil
@@ -306,108 +430,46 @@ func (l *Listener) QueueChallenge(c api.Challenge) error {
 	return nil
 }
 
-type BannedBots struct {
-	Banned []BannedBot `json:"banned"`
-}
-
-type BannedBot struct {
-	ID     string `json:"id"`
-	Reason string `json:"reason"`
+// declineChallenge declines c for reason, a lichess decline reason key.
+func (l *Listener) declineChallenge(c api.Challenge, reason string) error {
+	eventbus.Publish(l.gameOpts.Bus, ChallengeDeclined{ID: c.ID, User: c.Challenger.ID, Reason: reason})
+	return api.DeclineChallenge(c.ID, reason)
 }
 
 func (l *Listener) challengeBot() {
-	l.botQueueMtx.Lock()
-
-	q := l.botQueue
-	bots := make([]*api.BotInfo, len(q.Bots))
-	copy(bots, q.Bots)
-
-	// remove ourselves if we're in the list
-	for i := 0; i < len(bots); i++ {
-		if strings.EqualFold(bots[i].User.ID, botID) {
-			bots = append(bots[:i], bots[i+1:]...)
-			break
-		}
-	}
-
-	l.botQueueMtx.Unlock()
-
 	first := true
 
-	var banned BannedBots
-	b, err := ioutil.ReadFile("banned.json")
-	if err == nil {
-		if err := json.Unmarshal(b, &banned); err != nil {
-			log.Fatal(err)
-		}
-	}
-
-	// remove soft-bans
-	for i := 0; i < len(banned.Banned); i++ {
-		ban := banned.Banned[i]
-		if strings.Contains(ban.Reason, "soft-ban") || ban.Reason == "I'm not accepting challenges at the moment." || ban.Reason == "This is not the right time for me, please ask again later." {
-			banned.Banned = append(banned.Banned[:i], banned.Banned[i+1:]...)
-			i--
-			continue
-		}
+	banned, err := storage.LoadBans(l.store, bannedFilename, time.Now())
+	if err != nil {
+		log.Fatal(err)
 	}
-
-	save := func() {
-		b, err := json.MarshalIndent(banned, "", "  ")
-		if err != nil {
-			log.Fatal(err)
-		}
-		if err := ioutil.WriteFile("banned.json", b, 0644); err != nil {
-			log.Fatal(err)
-		}
-
-		for i := 0; i < len(bots); i++ {
-			bot := bots[i].User
-			ban := false
-			for j := 0; j < len(banned.Banned); j++ {
-				badBot := banned.Banned[j]
-				if strings.EqualFold(bot.ID, badBot.ID) {
-					ban = true
-					break
-				}
-			}
-
-			if ban {
-				bots = append(bots[:i], bots[i+1:]...)
-				i--
-				continue
-			}
-		}
+	// keep the migrated and expired bans
+	if err := banned.Save(); err != nil {
+		log.Fatal(err)
 	}
-	save()
 
-	for i := 0; i < len(bots); i++ {
-		bot := bots[i]
-		bullet := bot.User.Perfs["bullet"]
-		bulletRating := bullet.Rating
-		if bulletRating > maxRating || bulletRating < minRating || bullet.Provisional {
-			bots = append(bots[:i], bots[i+1:]...)
-			i--
-			continue
-		}
+	if l.declines, err = LoadDeclines(l.store); err != nil {
+		log.Fatal(err)
 	}
 
-	sort.Slice(bots, func(i, j int) bool {
-		return bots[i].User.Perfs["bullet"].Rating > bots[j].User.Perfs["bullet"].Rating
-	})
-
-	for i := 0; i < len(bots); i++ {
-		fmt.Printf("%3d. %s (%d)\n", i+1, bots[i].User.Username, bots[i].User.Perfs["bullet"].Rating)
-	}
+	bands := ratingBands(minRating, maxRating, ratingBandStep, ratingBandSteps)
+	band := 0
 
 	// wait for any pending games to start
 	time.Sleep(5000 * time.Millisecond)
 
 	for {
-		rand.Shuffle(len(bots), func(i, j int) {
-			bots[i], bots[j] = bots[j], bots[i]
-		})
+		if l.Quit() {
+			return
+		}
 
+		bots := l.challengeTargets(banned, bands[band])
+		fmt.Printf("%s challenging bots rated %s, %d online\n", ts(), bands[band], len(bots))
+		for i := 0; i < len(bots); i++ {
+			fmt.Printf("%3d. %s (%d)\n", i+1, bots[i].User.Username, bots[i].User.Perfs["bullet"].Rating)
+		}
+
+		var played bool
 		for i := 0; i < len(bots); i++ {
 			if l.Quit() {
 				return
@@ -418,10 +480,10 @@ func (l *Listener) challengeBot() {
 			l.activeGameMtx.Lock()
 			l.challengeQueueMtx.Lock()
 
-			isBusy := (l.activeGame != nil && !l.activeGame.finished) || l.challengePending
+			isBusy := (l.activeGame != nil && !l.activeGame.IsFinished()) || l.challengePending
 			hasChallenges := len(l.challengeQueue) != 0
 
-			if isBusy || hasChallenges {
+			if isBusy || hasChallenges || l.inMaintenance() || l.isDegraded() {
 				l.activeGameMtx.Unlock()
 				l.challengeQueueMtx.Unlock()
 
@@ -455,7 +517,8 @@ func (l *Listener) challengeBot() {
 				tcLimit, tcIncrement = 0, 1
 			}
 
-			resp := l.challenge(bot.User.ID, true, tcLimit, tcIncrement, "random", "")
+			params := l.declines.Params(bot.User.ID, ChallengeParams{Rated: true, Limit: tcLimit, Increment: tcIncrement})
+			resp := l.challenge(bot.User.ID, params.Rated, params.Limit, params.Increment, l.challengeColor(bot.User.ID), "")
 			if l.Quit() {
 				return
 			}
@@ -469,28 +532,61 @@ func (l *Listener) challengeBot() {
 				return
 			}
 
+			if errors.Is(resp.CreateChallengeErr, api.ErrUnauthorized) {
+				// our token, not the bot
+				return
+			}
+
 			if resp.CreateChallengeErr != nil {
-				banned.Banned = append(banned.Banned, BannedBot{ID: bot.User.ID, Reason: resp.CreateChallengeErr.Error()})
-				save()
-				i--
+				reason, ban := challengeBan(resp.CreateChallengeErr)
+				if !ban {
+					fmt.Printf("%s not banning %s over: %v\n", ts(), bot.User.ID, resp.CreateChallengeErr)
+					continue
+				}
+				now, message := time.Now(), resp.CreateChallengeErr.Error()
+				expires := iif(reason == storage.BanNotNow, now.Add(banNotNowExpiry), time.Time{})
+				if err := banned.Add(bot.User.ID, reason, message, now, expires); err != nil {
+					fmt.Printf("%s *** ERR: %v\n", ts(), err)
+				}
 				continue
 			}
 
 			if resp.DeclineReason != "" {
 				bot.LastDecline = time.Now()
-				banned.Banned = append(banned.Banned, BannedBot{ID: bot.User.ID, Reason: resp.DeclineReason})
-				save()
-				i--
+				if err := l.declines.Record(bot.User.ID, resp.DeclineKey, resp.DeclineReason, params, bot.LastDecline); err != nil {
+					log.Printf("ERR: declines: %v\n", err)
+				}
 				continue
 			}
 
 			if resp.Timeout {
 				bot.LastTimeout = time.Now()
-				banned.Banned = append(banned.Banned, BannedBot{ID: bot.User.ID, Reason: "soft-ban; timeout"})
-				save()
-				i--
+				if err := banned.Add(bot.User.ID, storage.BanTimeout, "", bot.LastTimeout, bot.LastTimeout.Add(banTimeoutExpiry)); err != nil {
+					fmt.Printf("%s *** ERR: %v\n", ts(), err)
+				}
 				continue
 			}
+
+			if resp.Accepted {
+				bot.LastAccept = time.Now()
+				played = true
+			}
+		}
+
+		switch {
+		case played:
+			band = 0
+		case band+1 < len(bands):
+			band++
+			fmt.Printf("%s no games from a full pass, widening to bots rated %s\n", ts(), bands[band])
+		default:
+			fmt.Printf("%s no games in any rating band, waiting %v for new bots\n", ts(), matchmakingIdleWait)
+			band = 0
+			select {
+			case <-time.After(matchmakingIdleWait):
+			case <-l.ctx.Done():
+				return
+			}
 		}
 	}
 }
@@ -500,6 +596,7 @@ type TryChallengeResponse struct {
 	DailyLimit         bool
 	CreateChallengeErr error
 	DeclineReason      string
+	DeclineKey         string
 	Timeout            bool
 	Accepted           bool
 }
@@ -507,7 +604,7 @@ type TryChallengeResponse struct {
 func (l *Listener) challenge(userID string, rated bool, limit, increment int, color, fenPos string) TryChallengeResponse {
 	l.activeGameMtx.Lock()
 	l.challengeQueueMtx.Lock()
-	isBusy := (l.activeGame != nil && !l.activeGame.finished) || l.challengePending
+	isBusy := (l.activeGame != nil && !l.activeGame.IsFinished()) || l.challengePending || l.inMaintenance() || l.isDegraded()
 	hasChallenges := len(l.challengeQueue) != 0
 
 	if isBusy || hasChallenges {
@@ -528,13 +625,13 @@ func (l *Listener) challenge(userID string, rated bool, limit, increment int, co
 		l.challengeQueueMtx.Unlock()
 	}()
 
-	fmt.Printf("%s sending challenge to %s...\n", ts(), userID)
+	fmt.Printf("%s sending challenge to %s (color: %s)...\n", ts(), userID, color)
 	//return TryChallengeResponse{DailyLimit: true}
 
 	challengeID, err := api.CreateChallenge(userID, rated, limit, increment, color, "standard", fenPos)
 	if err != nil {
-		if strings.Contains(err.Error(), "429") {
-			fmt.Printf("%s outgoing challenge limit exceeded for the day\n", ts())
+		if retryAfter, ok := api.RetryAfter(err); ok {
+			fmt.Printf("%s outgoing challenge limit exceeded for the day (retry after %v)\n", ts(), retryAfter)
 			return TryChallengeResponse{DailyLimit: true}
 		}
 
@@ -553,7 +650,7 @@ func (l *Listener) challenge(userID string, rated bool, limit, increment int, co
 				if !timer.Stop() {
 					<-timer.C
 				}
-				return TryChallengeResponse{DeclineReason: c.DeclineReason}
+				return TryChallengeResponse{DeclineReason: c.DeclineReason, DeclineKey: c.DeclineKey}
 			}
 		case c := <-l.accepted:
 			fmt.Printf("%s %s accepted challenge (id: %s, pending_id: %s)\n", ts(), c.Opponent.ID, c.ID, challengeID)
@@ -561,6 +658,7 @@ func (l *Listener) challenge(userID string, rated bool, limit, increment int, co
 				if !timer.Stop() {
 					<-timer.C
 				}
+				l.recordColor(userID, c.Color)
 				return TryChallengeResponse{Accepted: true}
 			}
 		case <-timer.C:
@@ -578,6 +676,24 @@ func (l *Listener) challenge(userID string, rated bool, limit, increment int, co
 	}
 }
 
+// recordColor remembers the color we played against userID, from the gameStart event, for
+// ChallengeColor.Next. Anything but white or black, e.g. the random we asked for, leaves
+// the last one.
+func (l *Listener) recordColor(userID, color string) {
+	if color != "white" && color != "black" {
+		return
+	}
+	l.lastColorMtx.Lock()
+	defer l.lastColorMtx.Unlock()
+	l.lastColor[strings.ToLower(userID)] = color
+}
+
+func (l *Listener) challengeColor(userID string) string {
+	l.lastColorMtx.Lock()
+	defer l.lastColorMtx.Unlock()
+	return l.color.Next(l.lastColor[strings.ToLower(userID)])
+}
+
 func (l *Listener) processChallengeQueue() {
 	var lastWaitingPrint time.Time
 	for {
@@ -587,14 +703,14 @@ func (l *Listener) processChallengeQueue() {
 
 		l.activeGameMtx.Lock()
 		l.challengeQueueMtx.Lock()
-		isBusy := (l.activeGame != nil && !l.activeGame.finished) || l.challengePending
+		isBusy := (l.activeGame != nil && !l.activeGame.IsFinished()) || l.challengePending || l.isDegraded()
 		hasChallenges := len(l.challengeQueue) != 0
 		l.activeGameMtx.Unlock()
 		l.challengeQueueMtx.Unlock()
 
 		if isBusy || !hasChallenges {
 			if !isBusy && time.Since(lastWaitingPrint) >= 30*time.Second {
-				fmt.Printf("%s accepting challenges\n", ts())
+				fmt.Printf("%s %s\n", ts(), iif(l.inMaintenance(), "maintenance mode, declining challenges", "accepting challenges"))
 				lastWaitingPrint = time.Now()
 			}
 			time.Sleep(1000 * time.Millisecond)
@@ -605,7 +721,7 @@ func (l *Listener) processChallengeQueue() {
 
 		l.activeGameMtx.Lock()
 		l.challengeQueueMtx.Lock()
-		if l.challengePending || (l.activeGame != nil && !l.activeGame.finished) {
+		if l.challengePending || (l.activeGame != nil && !l.activeGame.IsFinished()) {
 			l.activeGameMtx.Unlock()
 			l.challengeQueueMtx.Unlock()
 			continue
@@ -619,6 +735,7 @@ func (l *Listener) processChallengeQueue() {
 				i--
 				continue
 			}
+			eventbus.Publish(l.gameOpts.Bus, ChallengeAccepted{ID: c.ID, User: c.Challenger.ID})
 			l.challengeQueue = append(l.challengeQueue[:i], l.challengeQueue[i+1:]...)
 			break
 		}
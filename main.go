@@ -1,26 +1,33 @@
 package main
 
 import (
-	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
 	"math/rand"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"sort"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 
 	"trollfish-lichess/analyze"
 	"trollfish-lichess/api"
+	"trollfish-lichess/eco"
 	"trollfish-lichess/epd"
+	"trollfish-lichess/eventbus"
 	"trollfish-lichess/fen"
+	"trollfish-lichess/history"
+	"trollfish-lichess/progress"
+	"trollfish-lichess/storage"
+	"trollfish-lichess/uciproc"
+	"trollfish-lichess/wdl"
 	"trollfish-lichess/yamlbook"
 )
 
@@ -44,48 +51,253 @@ func main() {
 		dedupeEPDFilename    string
 		freqPGNFilename      string
 		freqMergeEPDFilename string
-		freqCount            int
+		freqCount            float64
+		freqWeights          FreqWeights
+		freqHalfLifeDays     int
+		freqSMCutoff         float64
 		freqMaxPly           int
 		lichessUser          string
+		queryText            string
+		queryFiles           string
+		queryOut             string
+		nnueFiles            string
+		nnueOut              string
+		nnueMinDepth         int
+		nnueUnknownDraws     bool
+		maintainFile         string
+		maintainOpts         MaintainOptions
+		maintainDays         int
+		lichessUserEvals     bool
+		serverEvalsPGN       string
+		serverEvalsBook      string
+		serverEvalsPlies     int
 		onlyUser             string
 		challenge            string
 		analyzePGN           string
+		analyzeGames         fen.Query
+		analyzeGameIndex     string
+		analyzeFromPly       int
+		reannotate           bool
+		reannotateSwing      float64
+		analyzeToPly         int
+		annotateThresholds   string
+		annotateEloScale     bool
 		analyzeUseBook       string
+		analyzeCriticalEPD   string
+		analyzeCriticalQueue bool
+		puzzlesPGN           string
+		puzzlesPlayer        string
+		puzzlesOut           string
+		puzzlesAsPGN         bool
 		extractEPD           string
 		extractEPDPlies      int
 		tc                   string
 		epdToYAMLBook        string
+		exportCloudEval      string
+		bookExportTree       string
+		bookExportFormat     string
+		bookExportDepth      int
+		bookFsck             string
+		validateEPD          string
+		validateBook         string
+		validateQuarantine   bool
+		bookCanonical        string
+		bookDeepen           string
+		bookDeepenRatings    string
+		bookDeepening        BookDeepening
+		bookStats            string
+		bookStatsRatings     string
+		bookStatsAge         time.Duration
+		bookRetainLines      string
+		bookHealth           string
+		bookHealthOpts       BookHealth
+		quiet                bool
+		pprofPrefix          string
 		bustedPGNFile        string
 		bustedPlayer         string
 		bustedColor          string
 		searchMoves          string
+		varietyPlies         int
+		varietyGames         int
+		color                string
+		colorAlternate       bool
+		auditDir             string
+		endgameSpeeds        string
+		dataDir              string
+		enginePath           string
+		stockfishPath        string
+		syzygyPath           string
+		threads              int
+		hashMB               int
+		runJobs              string
+		gameOpts             GameOptions
+		ponderWindow         int
+		ponderRates          string
+		ponderMaxLoad        float64
+		openingStats         int
+		experimentReport     bool
+		declineReport        bool
+		wdlModel             string
+		ecoFiles             string
+		pgnProfile           string
+		pgnSkipInvalid       bool
+		openingStatsMinGames int
+		timeReportPGN        string
+		replFlag             bool
+		replBook             string
+		timeReportPlayer     string
+		timeTrouble          time.Duration
+		timeSimulatePGN      string
+		timeSimulatePlayer   string
+		timeSimulateBook     string
+		timeModel            TimeModel
+		eloLevels            string
+		eloGames             int
+		eloNodes             int
+		eloBook              string
+		apiDebug             bool
+		admin                AdminConfig
+		sparring             string
+		teams                string
+		arena                string
+		maintenance          Maintenance
+		watchdog             Watchdog
+		freshEngineGames     int
 	)
 
 	var flags flag.FlagSet
 
+	flags.BoolVar(&quiet, "quiet", false, "don't show progress and ETA for long-running commands")
+	flags.StringVar(&pprofPrefix, "pprof", "", "write CPU and heap profiles of the run, bot play or a batch command, to <prefix>.cpu.pprof and <prefix>.heap.pprof")
+	flags.BoolVar(&apiDebug, "api-debug", false, "log every lichess API request and response, token redacted (toggle with SIGUSR1 while the bot runs)")
+	flags.StringVar(&dataDir, "data-dir", ".", "directory for files the bot writes: history, game PGNs, variety, banned bots, recent/extracted positions and audit logs")
+
+	// engines
+	flags.StringVar(&enginePath, "engine", "", "trollfish binary used by the bot (default: $TROLLFISH_ENGINE, then trollfish on PATH)")
+	flags.StringVar(&stockfishPath, "stockfish", "", "stockfish binary used for analysis (default: $STOCKFISH, then stockfish on PATH)")
+	flags.IntVar(&threads, "threads", 0, "engine Threads option, 0 = auto (half the CPUs for the bot, all but one for analysis)")
+	flags.IntVar(&hashMB, "hash", 0, "engine Hash option in MB, 0 = auto (sized from available memory)")
+	flags.IntVar(&freshEngineGames, "engine-fresh-games", 0, "start a new bot engine process after every this many games, for an empty hash and no memory growth, 0 = one process for the session")
+	flags.StringVar(&ecoFiles, "eco", "", "comma separated opening tables in lichess chess-openings TSV format (a.tsv ... e.tsv), added to the built-in common openings")
+	flags.StringVar(&pgnProfile, "pgn-profile", "lichess", "site the PGN files were exported from, for its tags and quirks: "+fen.ImportProfiles)
+	flags.BoolVar(&pgnSkipInvalid, "pgn-skip-invalid", false, "skip the games of PGN files that don't parse, e.g. with an illegal move, instead of stopping")
+	flags.StringVar(&wdlModel, "wdl-model", string(wdl.Lichess), "eval to win/draw/loss model for annotations, draw offers, resigning and busted lines: "+wdl.Models+", or by rating band, e.g. lichess,2000:stockfish (the opponent's rating for the bot, the players' average for annotations)")
+	flags.StringVar(&syzygyPath, "syzygy-path", os.Getenv("SYZYGY_PATH"), "Syzygy tablebase directories, separated by "+string(os.PathListSeparator)+" (default: $SYZYGY_PATH)")
+
 	// bot
 	flags.BoolVar(&botFlag, "bot", false, "runs the bot")
-	flags.StringVar(&tc, "tc", "1+1", "time control minutes+secs")
+	flags.StringVar(&tc, "tc", "1+1", "time control minutes+secs, 0+secs is increment only")
 	flags.StringVar(&onlyUser, "only-user", "", "only accept challenges from this user")
 	flags.StringVar(&challenge, "challenge", "", "challenge lichess user")
+	flags.StringVar(&color, "color", "random", "color to request in outgoing challenges: white, black or random")
+	flags.BoolVar(&colorAlternate, "color-alternate", false, "alternate colors against the same opponent in outgoing challenges (see color)")
+	flags.IntVar(&varietyPlies, "variety-plies", 8, "number of opening plies remembered per game for book variety, 0 = off")
+	flags.IntVar(&varietyGames, "variety-games", 10, "number of recent games the book tries not to repeat, 0 = off")
+	flags.IntVar(&gameOpts.Strength.Elo, "casual-elo", 0, "UCI_Elo in casual games against humans, 0 = full strength")
+	flags.IntVar(&gameOpts.Strength.Depth, "casual-depth", 0, "max search depth in casual games against humans, 0 = no limit")
+	flags.IntVar(&gameOpts.Strength.Nodes, "casual-nodes", 0, "max nodes per search in casual games against humans, 0 = no limit")
+	flags.Float64Var(&gameOpts.Strength.BookRandom, "casual-book-random", 0, "chance (0-1) of a slightly worse book move in casual games against humans")
+	flags.BoolVar(&gameOpts.Strength.Announce, "casual-announce", true, "say in chat when playing at reduced strength (see casual-elo, casual-depth, casual-nodes)")
+	flags.IntVar(&gameOpts.BookCheckCP, "book-check", 0, "verify book moves with a short engine search when our clock allows, replacing ones this many cp worse than the engine's move, 0 = off")
+	flags.IntVar(&gameOpts.BookPonderPlies, "book-ponder-plies", 0, "while both sides are in book, analyze the end of the book's most probable line this many plies ahead on the opponent's time, so the search out of book starts warm, 0 = off")
+	flags.BoolVar(&gameOpts.Premove, "premove", false, "when our reply to the predicted move is forced (the only legal move), send it as soon as the opponent plays it, without the engine")
+	flags.Float64Var(&gameOpts.Panic.Fraction, "panic-time", 0.9, "fraction of our time left after which a search without a bestmove is stopped and, if the engine still doesn't answer, a fallback move played (the book move or a quick pick of our own), 0 = off")
+	flags.DurationVar(&gameOpts.Panic.StopWait, "panic-stop-wait", time.Second, "how long to wait for the bestmove after stopping the search (see panic-time)")
+	flags.DurationVar(&gameOpts.Scramble.Below, "scramble-time", 0, "when both clocks are under this, move by reflex: a ponder hit at once, else a search to scramble-depth, skipping the book and the searches after ours, 0 = off")
+	flags.IntVar(&gameOpts.Scramble.Depth, "scramble-depth", 3, "search depth in a time scramble (see scramble-time)")
+	flags.IntVar(&ponderWindow, "ponder-window", 0, "stop pondering in a time control while too few of the session's last this many predictions in it are played (see ponder-min-hit-rate), 0 = always ponder")
+	flags.StringVar(&ponderRates, "ponder-min-hit-rate", "0.3", "share (0-1) of predictions played that pondering needs, or by time control, e.g. 0.3,bullet:0.4,correspondence:0 (see ponder-window)")
+	flags.Float64Var(&ponderMaxLoad, "ponder-max-load", 0, "don't ponder while the machine's 1-minute load average per CPU, without our own engine's threads, is over this, e.g. 1 when other bots or engines keep every CPU busy (Linux only), 0 = ignore load")
+	flags.BoolVar(&gameOpts.SummaryJSON, "game-summary-json", false, "print each finished game's summary (moves, book and ponder stats, result, clocks, opponent) as JSON, as saved to "+history.DefaultFilename+" in data-dir")
+	flags.BoolVar(&gameOpts.Deviations.Queue, "book-deviations", false, "after a game, queue the positions where the opponent left our book for analysis (see update-book)")
+	flags.BoolVar(&gameOpts.Deviations.Add, "book-deviations-add", false, "after a game, add the opponent's moves out of our book with our engine reply to the book, queued for review")
+	flags.BoolVar(&gameOpts.AnnounceOpening, "announce-opening", false, "say the opening's name in the spectator chat when we leave book (see eco)")
+	flags.StringVar(&gameOpts.BroadcastRound, "broadcast-round", "", "lichess broadcast round id to stream the session's games to (token needs study:write)")
+	flags.StringVar(&admin.Addr, "admin", "", "address for operator commands over HTTP, e.g. localhost:8089 or 8089 for localhost, empty = off; other interfaces need admin-token. POST /maintenance on=true to stop taking challenges after the current game. with update-book and analyze-pgn, GET /events streams the search's progress, POST /abort skips the position being searched, /later postpones it and /stop stops after it")
+	flags.StringVar(&admin.Token, "admin-token", "", "token every admin request needs as 'Authorization: Bearer <token>', required to listen beyond loopback (see admin)")
+	flags.DurationVar(&watchdog.Events, "watchdog-events", time.Minute, "reconnect the event stream after this long without a line (lichess sends keep-alives), 0 = off")
+	flags.DurationVar(&watchdog.Game, "watchdog-game", time.Minute, "reconnect the game stream after this long without a line, 0 = off")
+	flags.DurationVar(&watchdog.Engine, "watchdog-engine", 0, "restart the engine when it doesn't answer isready within this, 0 = off. GET /healthz on the admin address shows what the watchdog sees")
+	flags.StringVar(&maintenance.Reason, "maintenance-reason", "later", "challenge decline reason in maintenance mode: "+strings.Join(declineReasons, ", "))
+	flags.StringVar(&sparring, "sparring", "", "comma separated bots challenged first whenever they're online, whatever their rating")
+	flags.StringVar(&teams, "teams", "", "comma separated lichess team ids; only accept challenges from members of one of them, e.g. to be a club's training partner")
+	flags.StringVar(&arena, "arena", "", "arena tournament id to join at startup, as id:team for a team battle")
+	flags.StringVar(&maintenance.Message, "maintenance-message", "I'm going down for maintenance after this game, back soon!", "posted in the current game's chat when maintenance mode is turned on, empty = none")
+	flags.IntVar(&gameOpts.ArenaResign.Moves, "arena-resign-moves", 0, "in arena games, resign after this many of our moves in a row are lost (see arena-resign-score), 0 = off")
+	flags.Float64Var(&gameOpts.ArenaResign.Score, "arena-resign-score", 0.02, "expected score (0-1, per wdl-model) at or below which an arena game is lost, never while the opponent could flag")
+	flags.IntVar(&gameOpts.Swindle.Lines, "swindle-lines", 0, "when lost (see swindle-score) and the opponent is short of time, play the trickiest of this many engine lines, 0 = off")
+	flags.Float64Var(&gameOpts.Swindle.Score, "swindle-score", 0.1, "expected score (0-1, per wdl-model) at or below which we look for a swindle")
+	flags.DurationVar(&gameOpts.Swindle.OpponentTime, "swindle-time", 30*time.Second, "opponent's time below which we look for a swindle")
+	flags.BoolVar(&gameOpts.Clockless.Accept, "clockless", false, "accept casual unlimited and correspondence challenges (see clockless-movetime)")
+	flags.DurationVar(&gameOpts.Clockless.MoveTime, "clockless-movetime", 10*time.Second, "search time per move in games without a clock, 0 = search to clockless-depth")
+	flags.IntVar(&gameOpts.Clockless.Depth, "clockless-depth", 0, "search depth per move in games without a clock when clockless-movetime is 0")
+	flags.IntVar(&gameOpts.OpponentClock.PremoveMoves, "opponent-premove-moves", 0, "leave book after the opponent premoved this many opening moves in a row, 0 = off")
+	flags.BoolVar(&gameOpts.OpponentClock.SteerSlow, "opponent-steer-slow", false, "prefer equal book moves reaching a pawn structure the opponent burned time in")
+	flags.StringVar(&gameOpts.GIF, "game-gif", "", "save a GIF of each finished game to gifs/ in data-dir: "+gifThumbnail+" or "+gifFull+", empty = off")
+	flags.StringVar(&endgameSpeeds, "endgame-speeds", "", "comma separated lichess speeds, e.g. bullet,blitz, in which known theoretical endings (Lucena, Philidor, key squares) are played by their technique instead of a short search, empty = off")
+	flags.IntVar(&gameOpts.Endgames.TablebasePieces, "endgame-tablebase-pieces", 5, "with syzygy-path, endings of at most this many pieces are left to the tablebases (see endgame-speeds)")
+	flags.StringVar(&auditDir, "audit-dir", defaultAuditDir, "directory for per-game move audit logs, relative to data-dir, empty = off")
 
 	// update yaml book
 	flags.StringVar(&updateBookFilename, "update-book", "", "run analysis and update a book")
 	flags.StringVar(&startingFEN, "fen", "", "run analysis and update a book on a specific FEN. when used with -bot instead of -update-book creates a challenge with this starting FEN")
 	flags.StringVar(&searchMoves, "search-moves", "", "run analysis only on these moves. use SAN and separate with commas")
+	flags.IntVar(&defaultAnalysisOptions.ScoreDelta, "score-delta", 0, "analysis converges only if the best move's score moves at most this many cp over the depth delta, 0 = off")
+	flags.IntVar(&defaultAnalysisOptions.ClearMargin, "clear-margin", 0, "stop analysis before the minimum time/nodes when the best move leads the second best by this many cp, 0 = off")
 
 	// frequency counts
 	flags.StringVar(&freqPGNFilename, "freq-pgn", "", "show most common positions from a PGN file in EPD format (see also freq-count)")
 	flags.StringVar(&freqMergeEPDFilename, "freq-merge-epd", "", "merge positions with an EPD file. only new positions are added.")
-	flags.IntVar(&freqCount, "freq-count", 3, "minimum times a position must occur (see freq-pgn)")
+	flags.Float64Var(&freqCount, "freq-count", 3, "minimum times a position must occur, counted with the freq weights (see freq-pgn)")
+	flags.BoolVar(&freqWeights.WinsOnly, "freq-wins", false, "only count games won by the side to move (see freq-pgn)")
+	flags.BoolVar(&freqWeights.ByRating, "freq-rating", false, "weight games by the rating of the side to move's opponent, 2000 = 1 (see freq-pgn)")
+	flags.Float64Var(&freqSMCutoff, "freq-alternatives", 0, "also supply the moves played at least this fraction of the most played move's times, e.g. 0.5, one line per move with its 'weight' in percent, 0 = only the most played move (see freq-pgn)")
+	flags.IntVar(&freqHalfLifeDays, "freq-half-life", 0, "halve the weight of games every this many days since they were played, 0 = off (see freq-pgn)")
 	flags.IntVar(&freqMaxPly, "freq-max-ply", 0, "max ply to analyze, 0 = all (see freq-pgn)")
 
 	// download lichess user's games
 	flags.StringVar(&lichessUser, "lichess-user", "", "get all rated games for a lichess user")
+	flags.StringVar(&queryText, "query", "", "print the positions matching a query as EPD, e.g. 'eval < -100 and tomove = black'. fields: eval, mate, depth, moves, tomove, castling, material (e.g. KRPvKR), pieces, balance, piece counts (Q = 0) and squares (e4 = P)")
+	flags.StringVar(&queryFiles, "query-in", "book.yamlbook", "comma separated YAML books, EPD and PGN files to query (see query)")
+	flags.StringVar(&queryOut, "query-out", "", "EPD file to write the matches to instead of printing them (see query)")
+	flags.StringVar(&nnueFiles, "nnue-export", "", "comma separated YAML books, EPD and PGN files to export as NNUE training data in Stockfish's plain format (see nnue-out)")
+	flags.StringVar(&nnueOut, "nnue-out", "training.plain", "file the training data is written to, convert it with 'stockfish convert training.plain training.binpack' (see nnue-export)")
+	flags.IntVar(&nnueMinDepth, "nnue-min-depth", 0, "skip book and EPD positions analyzed below this depth (see nnue-export)")
+	flags.BoolVar(&nnueUnknownDraws, "nnue-unknown-draws", false, "export book and EPD positions, whose result is unknown, as draws for training on the eval alone; without it only PGN games with a result are exported (see nnue-export)")
+	flags.StringVar(&maintainFile, "maintain", "", "YAML book to maintain, e.g. nightly: download the last days' games, queue their new positions for analysis, dedupe, queue stale evals for review, prune dominated moves and report")
+	flags.StringVar(&maintainOpts.Player, "maintain-player", botID, "the player whose games are downloaded (see maintain)")
+	flags.IntVar(&maintainDays, "maintain-days", 1, "download the games of this many days before today (see maintain)")
+	flags.IntVar(&maintainOpts.PruneCP, "maintain-prune-cp", 200, "prune moves this many cp worse than their position's best, keeping the 3 best, moves with weights or tags and lines as healthy as the best (see book-health), 0 = off (see maintain)")
+	flags.BoolVar(&maintainOpts.Analyze, "maintain-analyze", false, "analyze the queued positions afterwards, as update-book does (see maintain)")
+	flags.BoolVar(&lichessUserEvals, "lichess-user-evals", false, "include the server analysis evals of analysed games (see lichess-user, server-evals)")
+	flags.StringVar(&serverEvalsPGN, "server-evals", "", "PGN file downloaded with lichess-user-evals: add its server analysis evals to a YAML book where it has none, for the analyzer to refine")
+	flags.StringVar(&serverEvalsBook, "server-evals-book", "server-evals.yamlbook", "YAML book the evals are added to, created if it doesn't exist; not the bot's book, the evals are too shallow to play until analyzed (see server-evals)")
+	flags.IntVar(&serverEvalsPlies, "server-evals-plies", 30, "only add evals of the first this many plies, 0 = all (see server-evals)")
 
 	// analyze a PGN file
 	flags.StringVar(&analyzePGN, "analyze-pgn", "", "analyze pgn file")
+	flags.StringVar(&analyzeGames.Player, "analyze-player", "", "only analyze games with this player as white or black")
+	flags.StringVar(&analyzeGames.White, "analyze-white", "", "only analyze games with this white player")
+	flags.StringVar(&analyzeGames.Black, "analyze-black", "", "only analyze games with this black player")
+	flags.StringVar(&analyzeGames.Round, "analyze-round", "", "only analyze games of this Round tag")
+	flags.StringVar(&analyzeGames.Result, "analyze-result", "", "only analyze games with this result: 1-0, 0-1, 1/2-1/2 or *")
+	flags.IntVar(&analyzeGames.MinElo, "analyze-min-elo", 0, "only analyze games where both players are rated at least this")
+	flags.StringVar(&analyzeGameIndex, "analyze-games", "", "only analyze these games, numbered from 1 in file order, ex: 3 or 1,4,7-9,20-")
+	flags.IntVar(&analyzeFromPly, "analyze-from-ply", 0, "only analyze from this ply of each game, numbered from 1 (move 12 for black is ply 24), the other moves are written as they were (see analyze-pgn)")
+	flags.IntVar(&analyzeToPly, "analyze-to-ply", 0, "only analyze up to this ply of each game, 0 = the last (see analyze-from-ply)")
+	flags.BoolVar(&reannotate, "reannotate", false, "keep the [%eval]s of the games and only analyze the moves without one or losing reannotate-swing by them (see analyze-pgn)")
+	flags.Float64Var(&reannotateSwing, "reannotate-swing", 0.1, "winning chances, 0 to 1, a move may lose by the PGN's evals before it's analyzed again (see reannotate)")
+	flags.StringVar(&annotateThresholds, "annotate-thresholds", "", "winning chances lost for ?!, ? and ?? in analyzed games, ex: 0.1,0.2,0.3 (default lichess')")
+	flags.BoolVar(&annotateEloScale, "annotate-elo-scale", false, "widen the annotate-thresholds in games between lower rated players")
 	flags.StringVar(&analyzeUseBook, "analyze-use-book", "", "use saved position eval in YAML book")
+	flags.StringVar(&analyzeCriticalEPD, "analyze-critical-epd", "", "append critical positions (swings, missed mates, only moves) to EPD file")
+	flags.BoolVar(&analyzeCriticalQueue, "analyze-critical-review", false, "queue critical positions for review in the analyze-use-book book")
+
+	// puzzles from our games
+	flags.StringVar(&puzzlesPGN, "puzzles", "", "pgn file to find puzzles in, where the opponent of puzzles-player blundered into a unique win")
+	flags.StringVar(&puzzlesPlayer, "puzzles-player", botID, "player to find puzzles for, empty = both sides (see puzzles)")
+	flags.StringVar(&puzzlesOut, "puzzles-out", "puzzles.csv", "file puzzles are appended to, in lichess puzzle CSV (see puzzles)")
+	flags.BoolVar(&puzzlesAsPGN, "puzzles-pgn", false, "write puzzles as PGN with a FEN tag instead of CSV (see puzzles)")
 
 	// EPD stuff
 	flags.StringVar(&dedupeEPDFilename, "dedupe-epd", "", "show duplicates in EPD file")
@@ -93,6 +305,69 @@ func main() {
 	flags.IntVar(&extractEPDPlies, "extract-epd-plies", 0, "number of plies to extract")
 	flags.StringVar(&epdToYAMLBook, "epd-to-yamlbook", "", "EPD file name to convert (new file will be <file>.yamlbook)")
 
+	// share analysis
+	flags.StringVar(&exportCloudEval, "export-cloud-eval", "", "YAML book to export in lichess cloud-eval JSON format (new file will be <file>.cloudeval.jsonl)")
+
+	// batch jobs
+	flags.StringVar(&runJobs, "run-jobs", "", "run the analysis jobs in a YAML or JSON job file")
+
+	// book maintenance
+	flags.StringVar(&bookFsck, "book-fsck", "", "validate a YAML book and repair structural issues")
+	flags.StringVar(&validateBook, "validate-book", "", "check a YAML book without loading it: legal FENs and moves, and evals from the side to move's pov")
+	flags.StringVar(&validateEPD, "validate-epd", "", "check an EPD file: legal FENs, bm and sm moves, and dm from white's pov")
+	flags.BoolVar(&validateQuarantine, "validate-quarantine", false, "move the invalid entries to <name>.quarantine.yamlbook or .epd, backing up the file (see validate-book, validate-epd)")
+	flags.StringVar(&bookCanonical, "book-canonical", "", "YAML book to make canonical: positions with black to move are stored flipped, sharing the knowledge of mirrored openings")
+	flags.StringVar(&bookDeepen, "book-deepen", "", "YAML book to extend along the replies popular in the lichess explorer's rating band from the start position (or -fen), analyze with -update-book")
+	flags.StringVar(&bookDeepenRatings, "book-deepen-ratings", "2000,2200,2500", "comma separated explorer rating groups of the band (see book-deepen)")
+	flags.Float64Var(&bookDeepening.Popularity, "book-deepen-popularity", 10, "percent of the band's games in a position a reply needs to be followed (see book-deepen)")
+	flags.IntVar(&bookDeepening.MinGames, "book-deepen-min-games", 50, "don't follow positions with fewer games in the band (see book-deepen)")
+	flags.IntVar(&bookDeepening.MaxPlies, "book-deepen-plies", 16, "max plies to follow, 0 = all (see book-deepen)")
+	flags.StringVar(&bookStats, "book-stats", "", "YAML book to annotate with the lichess explorer's games, score and popularity for each move, and show them. the bot prefers the practically stronger of eval-equal moves")
+	flags.StringVar(&bookStatsRatings, "book-stats-ratings", "2000,2200,2500", "comma separated explorer rating groups of the band (see book-stats)")
+	flags.DurationVar(&bookStatsAge, "book-stats-age", 30*24*time.Hour, "fetch the stats again when they're older than this (see book-stats)")
+	flags.StringVar(&bookRetainLines, "book-retain-lines", "all", "engine output lines kept on each book move when a book is loaded or saved: all, deepest (the deepest line per move), a number (the latest lines) or both, e.g. deepest,3. book-stats shows the savings")
+	flags.StringVar(&bookHealth, "book-health", "", "YAML book to score the health of each line from the start position (or -fen): the practical score of its leaves, from their explorer stats (see book-stats) or quick engine playouts, shown by book-stats and kept by pruning")
+	flags.IntVar(&bookHealthOpts.Playouts, "book-health-playouts", 4, "games trollfish plays against itself from each leaf without enough explorer games, 0 = explorer stats only (see book-health)")
+	flags.IntVar(&bookHealthOpts.Nodes, "book-health-nodes", 20000, "nodes a playout move (see book-health)")
+	flags.IntVar(&bookHealthOpts.MaxPlies, "book-health-plies", 60, "plies after which a playout is scored by the engine's eval, 0 = play it out (see book-health)")
+
+	// opening tree
+	flags.StringVar(&bookExportTree, "book-export-tree", "", "YAML book to print as a tree rooted at the start position (or -fen)")
+	flags.StringVar(&bookExportFormat, "book-export-format", "dot", "tree format: dot (graphviz) or json (see book-export-tree)")
+	flags.IntVar(&bookExportDepth, "book-export-depth", 12, "max plies to follow, 0 = all (see book-export-tree)")
+
+	// our results by opening
+	flags.IntVar(&openingStats, "opening-stats", 0, "show our score by the first N plies of our games, worst first (from the history in data-dir)")
+	flags.IntVar(&openingStatsMinGames, "opening-stats-min-games", 2, "only show openings with at least this many games (see opening-stats)")
+
+	flags.BoolVar(&declineReport, "decline-report", false, "show which bots declined our challenges, why, and the terms we'll challenge them with next (from data-dir)")
+
+	// A/B testing engine options and books
+	flags.StringVar(&gameOpts.ExperimentFile, "experiment", "", "YAML file of two engine option and book arms to alternate across games, see Experiment")
+	flags.BoolVar(&experimentReport, "experiment-report", false, "show each arm's score and ACPL in the history in data-dir with 95% confidence intervals (see experiment)")
+
+	// interactive analysis
+	flags.BoolVar(&replFlag, "repl", false, "interactive analysis: step through a FEN (see fen) or PGN, ask the engine, book and explorer, save positions. type help for commands")
+	flags.StringVar(&replBook, "repl-book", "", "YAML book the repl shows and saves evals to (see repl)")
+
+	// time usage from [%clk] comments
+	flags.StringVar(&timeReportPGN, "time-report", "", "PGN file with [%clk] comments: list moves played in time trouble and compare their mistakes ([%eval] comments) to other moves")
+	flags.StringVar(&timeReportPlayer, "time-report-player", "", "only report this player's moves, e.g. "+botID+" to tune our time usage (see time-report)")
+	flags.DurationVar(&timeTrouble, "time-trouble", 10*time.Second, "time left on the clock below which a move is in time trouble (see time-report)")
+	flags.StringVar(&timeSimulatePGN, "time-simulate", "", "PGN file of our games: replay our moves through the time policies with a synthetic clock and report how often we'd flag or overspend")
+	flags.StringVar(&timeSimulatePlayer, "time-simulate-player", botID, "the player whose moves are replayed (see time-simulate)")
+	flags.StringVar(&timeSimulateBook, "time-simulate-book", "", "YAML book whose positions are played as book moves (see time-simulate)")
+	flags.IntVar(&timeModel.MovesToGo, "time-simulate-moves-to-go", 40, "a search spends the time left over this many moves (see time-simulate)")
+	flags.Float64Var(&timeModel.IncrementShare, "time-simulate-increment-share", 0.8, "share of the increment a search spends on top (see time-simulate)")
+	flags.DurationVar(&timeModel.Lag, "time-simulate-lag", 300*time.Millisecond, "network lag added to each move (see time-simulate)")
+	flags.Float64Var(&timeModel.Overspend, "time-simulate-overspend", 0.2, "a move taking more than this share of the time left is overspent (see time-simulate)")
+
+	// rating estimate against stockfish
+	flags.StringVar(&eloLevels, "elo-estimate", "", "comma separated stockfish UCI_Elo levels, e.g. 1800,2100,2400: play trollfish with its book against each and estimate its rating, saved to "+eloFilename+" in data-dir")
+	flags.IntVar(&eloGames, "elo-games", 10, "games per UCI_Elo level, colors alternate (see elo-estimate)")
+	flags.IntVar(&eloNodes, "elo-nodes", 100000, "nodes both engines search per move (see elo-estimate)")
+	flags.StringVar(&eloBook, "elo-book", "book.yamlbook", "YAML book trollfish plays from, empty = none (see elo-estimate)")
+
 	// busted lines from pgn database; work in progress
 	flags.StringVar(&bustedPGNFile, "busted-pgn", "", "find busted lines in a PGN file")
 	flags.StringVar(&bustedPlayer, "busted-player", "", "player name")
@@ -106,6 +381,46 @@ func main() {
 		log.Fatal(err)
 	}
 
+	progress.Quiet = quiet
+	if pprofPrefix != "" {
+		stopProfile, err := startProfile(pprofPrefix)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer func() {
+			if err := stopProfile(); err != nil {
+				log.Print(err)
+			}
+		}()
+	}
+	api.SetDebug(apiDebug)
+	var err error
+	if wdl.ByRating, err = wdl.ParseBands(wdlModel); err != nil {
+		log.Fatal(err)
+	}
+	wdl.Default = wdl.ByRating.For(0)
+	if fen.Import, err = fen.ParseImportProfile(pgnProfile); err != nil {
+		log.Fatal(err)
+	}
+	fen.Import.SkipInvalid = pgnSkipInvalid
+	if yamlbook.Retain, err = yamlbook.ParseRetention(bookRetainLines); err != nil {
+		log.Fatal(err)
+	}
+	if admin, err = admin.check(); err != nil {
+		log.Fatal(err)
+	}
+	if ecoFiles != "" {
+		for _, filename := range strings.Split(ecoFiles, ",") {
+			if err := eco.Default.Load(filename); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	analyze.StockfishPath = stockfishPath
+	analyze.SyzygyPath = syzygyPath
+	analyze.Resources = uciproc.Auto(uciproc.Analysis).Override(threads, hashMB)
+	data := storage.NewDir(dataDir)
+
 	if challenge != "" {
 		onlyUser = challenge
 	}
@@ -115,31 +430,44 @@ func main() {
 		if err := timeControl.Parse(tc); err != nil {
 			log.Fatal(err)
 		}
+		gameOpts.Endgames.Speeds = parseSpeeds(endgameSpeeds)
+		if ponderWindow > 0 || ponderMaxLoad > 0 {
+			if gameOpts.PonderControl, err = NewPonderControl(ponderWindow, ponderRates, ponderMaxLoad); err != nil {
+				log.Fatalf("-ponder-min-hit-rate: %v", err)
+			}
+		}
+		switch gameOpts.GIF {
+		case "", gifThumbnail, gifFull:
+		default:
+			log.Fatalf("-game-gif '%s': want %s or %s", gameOpts.GIF, gifThumbnail, gifFull)
+		}
+
+		if !validDeclineReason(maintenance.Reason) {
+			log.Fatalf("-maintenance-reason '%s' is not one of %s", maintenance.Reason, strings.Join(declineReasons, ", "))
+		}
+
+		var challengeColor ChallengeColor
+		if err := challengeColor.Parse(color, colorAlternate); err != nil {
+			log.Fatal(err)
+		}
 
-		runLichessBot(onlyUser, challenge, timeControl, startingFEN)
+		variety, err := LoadVariety(data, varietyFilename, varietyPlies, varietyGames)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		runLichessBot(data, enginePath, uciproc.Auto(uciproc.Bot).Override(threads, hashMB), onlyUser, challenge, timeControl, challengeColor, startingFEN, variety, gameOpts, auditDir, admin, maintenance, parseSparring(sparring), NewTeamFilter(teams), arena, watchdog, freshEngineGames)
 		return
 	}
 
 	if updateBookFilename != "" {
-		var fens []string
-		if startingFEN != "" {
-			if strings.Contains(startingFEN, "/") && strings.Contains(startingFEN, " ") {
-				fens = append(fens, startingFEN)
-			} else {
-				b, err := ioutil.ReadFile(startingFEN)
-				if err != nil {
-					log.Fatal(err)
-				}
-				lines := strings.Split(string(b), "\n")
-				for _, line := range lines {
-					line = strings.TrimSpace(line)
-					if line != "" && !strings.HasPrefix(line, "#") {
-						fens = append(fens, line)
-					}
-				}
-			}
+		fens, err := loadFENs(startingFEN)
+		if err != nil {
+			log.Fatal(err)
 		}
-		if err := UpdateFile(context.Background(), updateBookFilename, defaultAnalysisOptions, fens, searchMoves); err != nil {
+		a := analyze.New()
+		watchAnalysis(context.Background(), admin, a)
+		if err := UpdateFile(context.Background(), a, updateBookFilename, defaultAnalysisOptions, fens, searchMoves); err != nil {
 			log.Fatal(err)
 		}
 		return
@@ -167,23 +495,288 @@ func main() {
 	}
 
 	if epdToYAMLBook != "" {
-		file, err := epd.LoadFile(epdToYAMLBook)
+		if err := EPDToYAMLBook(epdToYAMLBook, ""); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if runJobs != "" {
+		jobs, err := LoadJobFile(runJobs)
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		ext := filepath.Ext(epdToYAMLBook)
-		yamlBookFilename := strings.TrimSuffix(epdToYAMLBook, ext) + ".yamlbook"
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		results := RunJobs(ctx, jobs)
+		cancel()
+
+		failed := 0
+		for _, result := range results {
+			if !result.OK {
+				failed++
+			}
+		}
+		fmt.Printf("%d/%d job(s) ok\n", len(results)-failed, len(results))
+
+		if jobs.Report != "" {
+			if err := SaveJobReport(jobs.Report, results); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if failed > 0 {
+			os.Exit(1)
+		}
+		return
+	}
 
-		if err := file.SaveAsYAMLBook(yamlBookFilename, true); err != nil {
+	if bookFsck != "" {
+		if err := FsckBook(bookFsck); err != nil {
 			log.Fatal(err)
 		}
+		return
+	}
 
+	if validateBook != "" || validateEPD != "" {
+		if validateBook != "" {
+			if err := ValidateFile(validateBook, yamlbook.Validate, validateQuarantine); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if validateEPD != "" {
+			if err := ValidateFile(validateEPD, epd.Validate, validateQuarantine); err != nil {
+				log.Fatal(err)
+			}
+		}
+		return
+	}
+
+	if bookCanonical != "" {
+		if err := CanonicalBook(bookCanonical); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if bookStats != "" {
+		ratings, err := ParseRatingGroups(bookStatsRatings)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := BookStatsFile(context.Background(), bookStats, ratings, bookStatsAge); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if bookHealth != "" {
+		if bookHealthOpts.Playouts < 0 || bookHealthOpts.Nodes <= 0 {
+			log.Fatal("-book-health-playouts can't be negative and -book-health-nodes must be positive")
+		}
+
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer cancel()
+
+		if err := BookHealthFile(ctx, bookHealth, iif(startingFEN != "", startingFEN, startPosFEN), enginePath, uciproc.Auto(uciproc.Bot).Override(threads, hashMB), bookHealthOpts); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if bookDeepen != "" {
+		ratings, err := ParseRatingGroups(bookDeepenRatings)
+		if err != nil {
+			log.Fatal(err)
+		}
+		bookDeepening.Ratings = ratings
+		if err := DeepenBookFile(context.Background(), bookDeepen, iif(startingFEN != "", startingFEN, startPosFEN), bookDeepening); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if declineReport {
+		if err := PrintDeclineReport(data); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if experimentReport {
+		if gameOpts.ExperimentFile == "" {
+			log.Fatal("-experiment-report needs -experiment")
+		}
+		e, err := LoadExperiment(gameOpts.ExperimentFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := PrintExperimentReport(history.Open(data, history.DefaultFilename), e); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if openingStats > 0 {
+		if err := PrintOpeningStats(history.Open(data, history.DefaultFilename), openingStats, openingStatsMinGames); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if replFlag {
+		var book *yamlbook.Book
+		if replBook != "" {
+			var err error
+			if book, err = yamlbook.Load(replBook); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer cancel()
+
+		repl := NewREPL(os.Stdout, book)
+		if startingFEN != "" {
+			if err := repl.Exec(ctx, "fen "+startingFEN); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err := repl.Run(ctx, os.Stdin); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if eloLevels != "" {
+		levels, err := ParseEloLevels(eloLevels)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if eloGames <= 0 || eloNodes <= 0 {
+			log.Fatal("-elo-games and -elo-nodes must be positive")
+		}
+		match := EloMatch{Levels: levels, Games: eloGames, Nodes: eloNodes, BookName: eloBook}
+		if eloBook != "" {
+			if match.Book, err = yamlbook.Load(eloBook); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer cancel()
+
+		if err := RunEloEstimate(ctx, data, enginePath, uciproc.Auto(uciproc.Bot).Override(threads, hashMB), &match); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if timeReportPGN != "" {
+		if err := PrintTimeReport(timeReportPGN, timeReportPlayer, timeTrouble); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if timeSimulatePGN != "" {
+		if err := PrintTimeSimulation(timeSimulatePGN, timeSimulatePlayer, timeSimulateBook, timeModel); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if bookExportTree != "" {
+		book, err := yamlbook.Load(bookExportTree)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		tree := BookTree(book, iif(startingFEN != "", startingFEN, startPosFEN), bookExportDepth)
+
+		switch bookExportFormat {
+		case "dot":
+			fmt.Print(tree.DOT())
+		case "json":
+			s, err := tree.JSON()
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println(s)
+		default:
+			log.Fatalf("unknown -book-export-format '%s'", bookExportFormat)
+		}
+		return
+	}
+
+	if exportCloudEval != "" {
+		ext := filepath.Ext(exportCloudEval)
+		filename := strings.TrimSuffix(exportCloudEval, ext) + ".cloudeval.jsonl"
+
+		count, err := ExportCloudEvals(exportCloudEval, filename)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("saved %s with %d position(s)\n", filename, count)
 		return
 	}
 
 	if freqPGNFilename != "" && freqCount > 0 {
-		if err := GetMostFrequentPGNPositions(freqPGNFilename, freqCount, freqMergeEPDFilename); err != nil {
+		freqWeights.HalfLife = time.Duration(freqHalfLifeDays) * 24 * time.Hour
+		if err := GetMostFrequentPGNPositions(freqPGNFilename, freqCount, freqWeights, freqSMCutoff, freqMergeEPDFilename); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if queryText != "" {
+		w := io.Writer(os.Stdout)
+		if queryOut != "" {
+			fp, err := os.Create(queryOut)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer fp.Close()
+			w = fp
+		}
+		count, err := QueryPositions(queryFiles, queryText, w)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Fprintf(os.Stderr, "%d position(s) matched\n", count)
+		return
+	}
+
+	if nnueFiles != "" {
+		fp, err := os.Create(nnueOut)
+		if err != nil {
+			log.Fatal(err)
+		}
+		count, err := ExportTrainingData(nnueFiles, nnueMinDepth, nnueUnknownDraws, fp)
+		if closeErr := fp.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("saved %s with %d position(s)\n", nnueOut, count)
+		return
+	}
+
+	if maintainFile != "" {
+		now := time.Now()
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		maintainOpts.Book = maintainFile
+		maintainOpts.Since, maintainOpts.Until = today.AddDate(0, 0, -maintainDays), today
+		maintainOpts.RecentFile = filepath.Join(dataDir, recentFilename)
+		if err := MaintainBook(context.Background(), maintainOpts); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if serverEvalsPGN != "" {
+		if err := BackfillServerEvals(serverEvalsPGN, serverEvalsBook, serverEvalsPlies); err != nil {
 			log.Fatal(err)
 		}
 		return
@@ -192,7 +785,7 @@ func main() {
 	if lichessUser != "" {
 		start := time.Now()
 
-		fn, count, err := api.GetGames(lichessUser, 0)
+		fn, count, err := api.GetGames(lichessUser, api.GamesOptions{Evals: lichessUserEvals})
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -214,20 +807,67 @@ func main() {
 			}
 		}
 
+		switch analyzeGames.Result {
+		case "", "1-0", "0-1", "1/2-1/2", "*":
+		default:
+			log.Fatalf("-analyze-result '%s': want 1-0, 0-1, 1/2-1/2 or *", analyzeGames.Result)
+		}
+
+		if analyzeGames.Games, err = fen.ParseIndexRanges(analyzeGameIndex); err != nil {
+			log.Fatal(err)
+		}
+
 		a := analyze.New()
+		if annotateThresholds != "" {
+			if a.Thresholds, err = analyze.ParseThresholds(annotateThresholds); err != nil {
+				log.Fatal(err)
+			}
+		}
+		a.Thresholds.EloScale = annotateEloScale
+		a.Games = analyzeGames
+		a.CriticalEPD = analyzeCriticalEPD
+		a.CriticalReview = analyzeCriticalQueue
+		a.FromPly, a.ToPly = analyzeFromPly, analyzeToPly
+		a.Reannotate, a.ReannotateSwing = reannotate, reannotateSwing
+		watchAnalysis(context.Background(), admin, a)
 		if err := a.AnalyzePGNFile(context.Background(), defaultAnalysisOptions, analyzePGN, book); err != nil {
 			log.Fatal(err)
 		}
 		return
 	}
 
+	if puzzlesPGN != "" {
+		db, err := fen.LoadPGNDatabase(puzzlesPGN)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		opts := defaultAnalysisOptions
+		opts.MultiPV = 2
+
+		var count int
+		for _, game := range db.Games {
+			// each game quits its engine
+			puzzles, err := analyze.New().FindPuzzles(context.Background(), opts, game, puzzlesPlayer)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err := analyze.SavePuzzles(puzzlesOut, puzzles, puzzlesAsPGN); err != nil {
+				log.Fatal(err)
+			}
+			count += len(puzzles)
+		}
+		fmt.Printf("%d puzzles from %d games written to %s\n", count, len(db.Games), puzzlesOut)
+		return
+	}
+
 	if extractEPD != "" && extractEPDPlies > 0 {
 		db, err := fen.LoadPGNDatabase(extractEPD)
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		file, err := epd.LoadFile("book.epd")
+		file, err := epd.LoadFile(data.Path("book.epd"))
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -263,22 +903,20 @@ func main() {
 	os.Exit(1)
 }
 
-func GetMostFrequentPGNPositions(filename string, minCount int, epdFilename string) error {
+// GetMostFrequentPGNPositions prints the positions of a PGN file played in at least minCount
+// games, counted with weights, as EPD with the most played moves (see frequentMoves), or merges
+// the new ones into epdFilename.
+func GetMostFrequentPGNPositions(filename string, minCount float64, weights FreqWeights, smCutoff float64, epdFilename string) error {
 	db, err := fen.LoadPGNDatabase(filename)
 	if err != nil {
 		return err
 	}
-
-	var moves int
-	pos := make(map[string]int)
-
-	for _, game := range db.Games {
-		moves += len(game.Moves)
-		for k := range game.Positions {
-			pos[k] += 1
-		}
+	if n := db.Dedupe(); n != 0 {
+		fmt.Printf("skipped %d duplicate game(s)\n", n)
 	}
 
+	pos, moves := weightedPositions(db, weights, time.Now())
+
 	for fenKey, freq := range pos {
 		if freq < minCount {
 			delete(pos, fenKey)
@@ -294,8 +932,7 @@ func GetMostFrequentPGNPositions(filename string, minCount int, epdFilename stri
 		var newPositions int
 		for fenKey := range pos {
 			if !epdFile.Contains(fenKey) {
-				san := db.MostFrequentMove(fenKey)
-				epdFile.Add(fenKey, epd.Operation{OpCode: epd.OpCodeSuppliedMove, Value: san})
+				addFrequentMoves(epdFile, fenKey, moves[fenKey], smCutoff)
 				newPositions++
 			}
 		}
@@ -311,8 +948,7 @@ func GetMostFrequentPGNPositions(filename string, minCount int, epdFilename stri
 	} else {
 		epdFile := epd.New()
 		for fenKey := range pos {
-			san := db.MostFrequentMove(fenKey)
-			epdFile.Add(fenKey, epd.Operation{OpCode: epd.OpCodeSuppliedMove, Value: san})
+			addFrequentMoves(epdFile, fenKey, moves[fenKey], smCutoff)
 		}
 
 		fmt.Print(epdFile.String())
@@ -333,62 +969,131 @@ func positionLookup() {
 	fmt.Printf("%s\n", b)
 }
 
-func runLichessBot(onlyUser, challenge string, tc TimeControl, fenPos string) {
-	ctx, cancel := context.WithCancel(context.Background())
+func runLichessBot(data storage.Storage, enginePath string, resources uciproc.Resources, onlyUser, challenge string, tc TimeControl, color ChallengeColor, fenPos string, variety *Variety, gameOpts GameOptions, auditDir string, admin AdminConfig, maintenance Maintenance, sparring []string, teams *TeamFilter, arena string, watchdog Watchdog, freshEngineGames int) {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
+	session := NewSession(history.Open(data, history.DefaultFilename))
+
+	go toggleAPIDebug(ctx)
+
+	gameOpts.Bus = eventbus.New()
+
+	if gameOpts.BroadcastRound != "" {
+		gameOpts.Broadcast = NewBroadcaster(ctx, gameOpts.BroadcastRound)
+	}
+
+	if gameOpts.ExperimentFile != "" {
+		e, err := LoadExperiment(gameOpts.ExperimentFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		games, err := session.db.Games()
+		if err != nil {
+			log.Fatal(err)
+		}
+		e.Count(games)
+		gameOpts.Experiment = e
+		fmt.Printf("%s experiment %s: %s vs %s\n", ts(), e.Name, e.Arms[0].Name, e.Arms[1].Name)
+	}
+
 	input := make(chan string, 512)
 	output := make(chan string, 512)
 
-	if err := startTrollFish(ctx, input, output); err != nil {
+	proc := &engineProcess{ctx: ctx, path: enginePath, input: input, output: output}
+	if err := proc.start(); err != nil {
 		log.Fatal(err)
 	}
 
-	listener := New(ctx, input, output, onlyUser, challenge, tc, fenPos)
+	engine := NewEngine(ctx, input, output)
+	engine.SetBus(gameOpts.Bus)
 
-	if err := listener.Events(); err != nil {
-		log.Fatal(err)
+	listener := New(ctx, data, engine, resources, onlyUser, challenge, tc, color, fenPos, variety, gameOpts, session, auditDir, maintenance, sparring)
+	listener.engineRestart = proc.restart
+	if gameOpts.PonderControl != nil {
+		gameOpts.PonderControl.ownCPU = proc.cpu
+	}
+	listener.freshEngineGames = freshEngineGames
+	listener.teams = teams
+	if len(teams.Teams()) != 0 {
+		fmt.Printf("%s only accepting challenges from members of %s\n", ts(), strings.Join(teams.Teams(), ", "))
+	}
+	if arena != "" {
+		tournamentID, teamID, _ := strings.Cut(arena, ":")
+		if err := api.JoinArena(tournamentID, teamID); err != nil {
+			fmt.Printf("%s *** ERR: join arena '%s': %v\n", ts(), arena, err)
+		}
+	}
+	if admin.Addr != "" {
+		go serveAdmin(ctx, admin, listener)
 	}
+	if watchdog.Enabled() {
+		go listener.runWatchdog(watchdog)
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- listener.Events()
+	}()
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			session.Close()
+			log.Fatal(err)
+		}
+	case <-ctx.Done():
+		fmt.Printf("%s shutting down...\n", ts())
+	}
+
+	session.Close()
 }
 
-func startTrollFish(ctx context.Context, input <-chan string, output chan<- string) error {
-	// TODO: put in config
-	binary := "/home/jud/projects/trollfish/trollfish"
-	dir := "/home/jud/projects/trollfish"
+// legacyEngine is where the engine lived before it could be found on PATH.
+const legacyEngine = "/home/jud/projects/trollfish/trollfish"
+
+func startTrollFish(ctx context.Context, enginePath string, input <-chan string, output chan<- string) (*os.Process, error) {
+	binary, err := uciproc.Find(enginePath, "TROLLFISH_ENGINE", []string{"trollfish"}, legacyEngine)
+	if err != nil {
+		return nil, err
+	}
+
+	return startUCI(ctx, binary, input, output)
+}
 
-	cmd := exec.CommandContext(ctx, binary)
-	cmd.Dir = dir
+// startUCI runs a UCI engine, writing input to it and its output lines to output, until ctx
+// is done.
+func startUCI(ctx context.Context, binary string, input <-chan string, output chan<- string) (*os.Process, error) {
+	cmd := uciproc.Command(ctx, binary)
 
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	stderr, err := cmd.StderrPipe()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if err := cmd.Start(); err != nil {
-		log.Fatalf("cmd.Start: %v\n", err)
+		return nil, fmt.Errorf("start '%s': %v", binary, err)
 	}
 
-	var wg sync.WaitGroup
-	wg.Add(3)
-
 	go func() {
-		defer wg.Done()
 		for {
 			select {
 			case line := <-input:
 				//fmt.Printf("-> %s\n", line)
-				_, err := stdin.Write([]byte(fmt.Sprintf("%s\n", line)))
-				if err != nil {
+				if err := uciproc.WriteLine(stdin, line); err != nil {
+					if ctx.Err() != nil {
+						return
+					}
 					log.Fatalf("stdin.Write ERR: %v", err)
 				}
 			case <-ctx.Done():
@@ -399,50 +1104,195 @@ func startTrollFish(ctx context.Context, input <-chan string, output chan<- stri
 
 	// stderr loop
 	go func() {
-		defer wg.Done()
-		r := bufio.NewScanner(stderr)
-		for r.Scan() {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				line := r.Text()
-				log.Printf(fmt.Sprintf("SF STDERR: %s\n", line))
+		err := uciproc.ScanLines(stderr, func(line string) bool {
+			if ctx.Err() != nil {
+				return false
 			}
-		}
-		if err := r.Err(); err != nil {
-			log.Printf(fmt.Sprintf("SF ERR: stderr: %v\n", err))
+			log.Printf("SF STDERR: %s\n", line)
+			return true
+		})
+		if err != nil {
+			log.Printf("SF ERR: stderr: %v\n", err)
 		}
 	}()
 
 	// stdout loop
 	go func() {
-		defer wg.Done()
-		r := bufio.NewScanner(stdout)
-		for r.Scan() {
-			select {
-			case <-ctx.Done():
-				return
-			default:
+		err := uciproc.ScanLines(stdout, func(line string) bool {
+			if ctx.Err() != nil {
+				return false
 			}
-
-			line := r.Text()
 			if strings.HasPrefix(line, "info string") {
 				fmt.Printf("%s <- %s\n", ts(), line)
 			}
 			output <- line
-		}
-		if err := r.Err(); err != nil {
-			log.Printf(fmt.Sprintf("ERR: stdout: %v\n", err))
+			return true
+		})
+		if err != nil {
+			log.Printf("ERR: stdout: %v\n", err)
 		}
 	}()
 
 	go func() {
 		if err := cmd.Wait(); err != nil {
-			log.Fatal(fmt.Sprintf("ERR: %v\n", err))
+			if ctx.Err() != nil {
+				return
+			}
+			log.Fatalf("ERR: %v\n", err)
 		}
 	}()
 
+	return cmd.Process, nil
+}
+
+// loadFENs returns fenPos as a list, or if it isn't a FEN the FENs in the file it names,
+// one per line, skipping blanks and # comments.
+func loadFENs(fenPos string) ([]string, error) {
+	if fenPos == "" {
+		return nil, nil
+	}
+	if strings.Contains(fenPos, "/") && strings.Contains(fenPos, " ") {
+		return []string{fenPos}, nil
+	}
+
+	b, err := os.ReadFile(fenPos)
+	if err != nil {
+		return nil, err
+	}
+
+	var fens []string
+	for i, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := fen.ValidFEN(line); err != nil {
+			return nil, fmt.Errorf("'%s' line %d: %v", fenPos, i+1, err)
+		}
+		fens = append(fens, line)
+	}
+	return fens, nil
+}
+
+// FsckBook validates a YAML book, saving it if anything was repaired.
+func FsckBook(filename string) error {
+	book, err := yamlbook.Load(filename)
+	if err != nil {
+		return err
+	}
+
+	report := book.Fsck()
+	for _, msg := range report {
+		fmt.Println(msg)
+	}
+
+	if len(report) == 0 {
+		fmt.Printf("'%s' ok, %d position(s)\n", filename, book.PosCount())
+		return nil
+	}
+
+	if err := book.Save(); err != nil {
+		return err
+	}
+	fmt.Printf("'%s' saved, %d repair(s)\n", filename, len(report))
+	return nil
+}
+
+// ValidateFile checks a YAML book or EPD file with validate, yamlbook.Validate or epd.Validate,
+// printing each invalid entry. Invalid entries are an error unless they're quarantined.
+func ValidateFile(filename string, validate func(string, bool) ([]string, error), quarantine bool) error {
+	report, err := validate(filename, quarantine)
+	if err != nil {
+		return err
+	}
+	for _, msg := range report {
+		fmt.Println(msg)
+	}
+
+	switch {
+	case len(report) == 0:
+		fmt.Printf("'%s' ok\n", filename)
+	case quarantine:
+		fmt.Printf("'%s': quarantined %d invalid entr(ies)\n", filename, len(report))
+	default:
+		return fmt.Errorf("'%s': %d invalid entr(ies), see validate-quarantine", filename, len(report))
+	}
+	return nil
+}
+
+// CanonicalBook makes a YAML book canonical, see yamlbook.Book.SetCanonical.
+func CanonicalBook(filename string) error {
+	book, err := yamlbook.Load(filename)
+	if err != nil {
+		return err
+	}
+	if book.Canonical() {
+		fmt.Printf("'%s' is already canonical, %d position(s)\n", filename, book.PosCount())
+		return nil
+	}
+
+	report := book.SetCanonical()
+	for _, msg := range report {
+		fmt.Println(msg)
+	}
+
+	if err := book.Save(); err != nil {
+		return err
+	}
+	fmt.Printf("'%s' saved, %d change(s), %d position(s)\n", filename, len(report), book.PosCount())
+	return nil
+}
+
+// EPDToYAMLBook converts an EPD file to a YAML book. If yamlBookFilename is empty the book
+// is saved next to the EPD file as <file>.yamlbook.
+func EPDToYAMLBook(epdFilename, yamlBookFilename string) error {
+	file, err := epd.LoadFile(epdFilename)
+	if err != nil {
+		return err
+	}
+
+	if yamlBookFilename == "" {
+		ext := filepath.Ext(epdFilename)
+		yamlBookFilename = strings.TrimSuffix(epdFilename, ext) + ".yamlbook"
+	}
+
+	return file.SaveAsYAMLBook(yamlBookFilename, true)
+}
+
+// PrintOpeningStats prints our score, average eval after leaving book and the opponent
+// moves that most often took us out of book, grouped by the first plies of our games.
+func PrintOpeningStats(db *history.DB, plies, minGames int) error {
+	games, err := db.Games()
+	if err != nil {
+		return err
+	}
+
+	shown := 0
+	for _, s := range history.OpeningStats(games, plies) {
+		if s.Games < minGames {
+			continue
+		}
+		shown++
+
+		exitEval := "-"
+		if s.ExitEvals > 0 {
+			exitEval = fmt.Sprintf("%+.2f", s.ExitEval/100)
+		}
+		fmt.Printf("%-5s %5.1f%% %3d games (+%d =%d -%d) exit eval: %6s | %s\n",
+			s.Color, s.Score*100, s.Games, s.Wins, s.Draws, s.Losses, exitEval, s.Line())
+		if deviations := s.TopDeviations(3); len(deviations) > 0 {
+			var counts []string
+			for _, move := range deviations {
+				counts = append(counts, fmt.Sprintf("%s (%d)", move, s.Deviations[move]))
+			}
+			fmt.Printf("      out of book after: %s\n", strings.Join(counts, ", "))
+		}
+	}
+
+	if shown == 0 {
+		fmt.Printf("no openings with %d or more games\n", minGames)
+	}
+
 	return nil
 }
 
@@ -450,7 +1300,7 @@ func ts() string {
 	return fmt.Sprintf("[%s]", time.Now().Format("2006-01-02 15:04:05.000"))
 }
 
-func UpdateFile(ctx context.Context, filename string, opts analyze.AnalysisOptions, fens []string, searchMoves string) error {
+func UpdateFile(ctx context.Context, a *analyze.Analyzer, filename string, opts analyze.AnalysisOptions, fens []string, searchMoves string) error {
 	if len(fens) != 1 && searchMoves != "" {
 		return fmt.Errorf("-search-moves can only be used with -fen")
 	}
@@ -502,15 +1352,17 @@ func UpdateFile(ctx context.Context, filename string, opts analyze.AnalysisOptio
 		return err
 	}
 
-	a := analyze.New()
-
 	wg, err := a.StartStockfish(ctx)
 	if err != nil {
 		return err
 	}
 
 	if len(fens) == 0 {
-		fens = file.NeedMoves()
+		review := file.NeedReview()
+		if len(review) > 0 {
+			fmt.Printf("%d position(s) queued for review\n", len(review))
+		}
+		fens = append(review, file.NeedMoves()...)
 	}
 
 	fmt.Printf("%d positions to analyze\n", len(fens))
@@ -529,17 +1381,30 @@ func UpdateFile(ctx context.Context, filename string, opts analyze.AnalysisOptio
 		fmt.Printf("%2d pieces: %5d\n", i, posCount)
 	}
 
+	a.Progress = progress.New("positions", len(fens))
+
 	for i := 0; i < len(fens); i++ {
 		start := time.Now()
+		a.Progress.Start()
 		boardFEN := fens[i]
 		fmt.Printf("%s FEN: %s  piece_count: %d\n%s\n", ts(), boardFEN, fen.FENtoBoard(boardFEN).PieceCount(), ts())
 
 		fenKey := fen.Key(boardFEN)
 		evals, err := a.AnalyzePosition(ctx, opts, fenKey, searchMovesUCI...)
-		if err != nil {
+		if errors.Is(err, analyze.ErrAborted) || errors.Is(err, analyze.ErrPostponed) {
+			fmt.Printf("%s FEN: %s %v, it stays queued\n", ts(), boardFEN, iif(errors.Is(err, analyze.ErrPostponed), analyze.ErrPostponed, analyze.ErrAborted))
+			a.Progress.Done()
+			if a.Stopping() {
+				fmt.Printf("%s stopping as asked, %d position(s) left\n", ts(), len(fens)-i-1)
+				break
+			}
+			continue
+		} else if err != nil {
 			return err
 		}
 
+		file.ClearReview(fenKey)
+
 		if err := a.SaveEvalsToBook(file, fenKey, evals); err != nil {
 			return err
 		}
@@ -548,7 +1413,13 @@ func UpdateFile(ctx context.Context, filename string, opts analyze.AnalysisOptio
 			return err
 		}
 
-		fmt.Printf("%s\n%s FEN: %s complete in %v\n%s -----\n%s\n", ts(), ts(), boardFEN, time.Since(start).Round(time.Second), ts(), ts())
+		fmt.Printf("%s\n%s FEN: %s complete in %v\n", ts(), ts(), boardFEN, time.Since(start).Round(time.Second))
+		a.Progress.Done()
+		fmt.Printf("%s -----\n%s\n", ts(), ts())
+		if a.Stopping() {
+			fmt.Printf("%s stopping as asked, %d position(s) left\n", ts(), len(fens)-i-1)
+			break
+		}
 	}
 
 	cancel()
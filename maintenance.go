@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+
+	"trollfish-lichess/api"
+)
+
+// declineReasons are lichess' challenge decline reason keys.
+var declineReasons = []string{
+	"generic", "later", "tooFast", "tooSlow", "timeControl", "rated", "casual",
+	"standard", "variant", "noBot", "onlyBot",
+}
+
+// Maintenance is what the bot tells players while it's down for maintenance.
+type Maintenance struct {
+	Reason  string `json:"reason"`  // the decline reason key for new challenges
+	Message string `json:"message"` // posted in the current game's chat, empty for none
+}
+
+func validDeclineReason(reason string) bool {
+	for _, r := range declineReasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
+
+// SetMaintenance turns maintenance mode on with m, or off when m is nil. While it's on new
+// and queued challenges are declined, we don't challenge bots and the current game is
+// played to the end. Turning it on posts m.Message in the current game's chat.
+func (l *Listener) SetMaintenance(m *Maintenance) {
+	l.maintenanceMtx.Lock()
+	was := l.maintenance != nil
+	if m != nil {
+		copied := *m
+		l.maintenance = &copied
+	} else {
+		l.maintenance = nil
+	}
+	l.maintenanceMtx.Unlock()
+
+	if m == nil {
+		if was {
+			fmt.Printf("%s maintenance mode off\n", ts())
+		}
+		return
+	}
+
+	fmt.Printf("%s maintenance mode on, declining challenges with '%s'\n", ts(), m.Reason)
+
+	l.challengeQueueMtx.Lock()
+	queued := l.challengeQueue
+	l.challengeQueue = nil
+	l.challengeQueueMtx.Unlock()
+
+	for _, c := range queued {
+		if err := l.declineChallenge(c, m.Reason); err != nil {
+			fmt.Printf("%s ERR: decline %s: %v\n", ts(), c.ID, err)
+		}
+	}
+
+	if was || m.Message == "" {
+		return
+	}
+
+	l.activeGameMtx.Lock()
+	game := l.activeGame
+	l.activeGameMtx.Unlock()
+	if game == nil || game.IsFinished() {
+		return
+	}
+
+	for _, room := range []string{"player", "spectator"} {
+		if err := api.Chat(game.gameID, room, m.Message); err != nil {
+			fmt.Printf("%s ERR: api.Chat: %v\n", ts(), err)
+		}
+	}
+}
+
+// Maintenance returns the maintenance mode settings, and false if it's off.
+func (l *Listener) Maintenance() (Maintenance, bool) {
+	l.maintenanceMtx.Lock()
+	defer l.maintenanceMtx.Unlock()
+	if l.maintenance == nil {
+		return Maintenance{}, false
+	}
+	return *l.maintenance, true
+}
+
+func (l *Listener) inMaintenance() bool {
+	_, ok := l.Maintenance()
+	return ok
+}
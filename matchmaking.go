@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"trollfish-lichess/api"
+	"trollfish-lichess/storage"
+)
+
+const (
+	botRefreshInterval  = 10 * time.Minute // how often the online bots are fetched again
+	matchmakingIdleWait = time.Minute      // wait after every rating band came up empty
+
+	ratingBandStep  = 300 // each fallback band is this much below or above the last
+	ratingBandSteps = 2   // fallback bands on each side
+)
+
+// ratingBand is a range of bullet ratings we challenge bots in.
+type ratingBand struct {
+	Min, Max int
+}
+
+func (b ratingBand) String() string {
+	return fmt.Sprintf("%d-%d", b.Min, b.Max)
+}
+
+func (b ratingBand) contains(rating int) bool {
+	return rating >= b.Min && rating <= b.Max
+}
+
+// ratingBands returns min-max followed by steps bands of step rating points below and above
+// it, nearest first, for when every bot in min-max declines.
+func ratingBands(min, max, step, steps int) []ratingBand {
+	bands := []ratingBand{{min, max}}
+	for i := 1; i <= steps; i++ {
+		if low := min - i*step; low > 0 {
+			bands = append(bands, ratingBand{low, low + step - 1})
+		}
+		high := max + (i-1)*step + 1
+		bands = append(bands, ratingBand{high, high + step - 1})
+	}
+	return bands
+}
+
+// challengeTargets returns the online bots to challenge in band: our sparring partners first,
+// in the configured order and whatever their rating, then the rest in random order. Bots
+// that are banned, cooling down after a decline, provisional or us are left out.
+func (l *Listener) challengeTargets(banned *storage.Bans, band ratingBand) []*api.BotInfo {
+	l.botQueueMtx.Lock()
+	var online []*api.BotInfo
+	if l.botQueue != nil {
+		online = append(online, l.botQueue.Bots...)
+	}
+	l.botQueueMtx.Unlock()
+
+	now := time.Now()
+	byID := make(map[string]*api.BotInfo)
+	var rest []*api.BotInfo
+	for _, bot := range online {
+		id := strings.ToLower(bot.User.ID)
+		if id == botID || banned.IsBanned(id, now) || l.declines.CoolingDown(id, now) {
+			continue
+		}
+		byID[id] = bot
+
+		bullet := bot.User.Perfs["bullet"]
+		if !l.isSparringPartner(id) && band.contains(bullet.Rating) && !bullet.Provisional {
+			rest = append(rest, bot)
+		}
+	}
+
+	var targets []*api.BotInfo
+	for _, id := range l.sparring {
+		if bot, ok := byID[id]; ok {
+			targets = append(targets, bot)
+		}
+	}
+
+	rand.Shuffle(len(rest), func(i, j int) {
+		rest[i], rest[j] = rest[j], rest[i]
+	})
+	return append(targets, rest...)
+}
+
+func (l *Listener) isSparringPartner(id string) bool {
+	for _, partner := range l.sparring {
+		if partner == id {
+			return true
+		}
+	}
+	return false
+}
+
+// refreshBots fetches the online bots every botRefreshInterval, so bots coming online after
+// startup get challenged too.
+func (l *Listener) refreshBots() {
+	ticker := time.NewTicker(botRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		botQueue, err := api.StreamBots(l.ctx)
+		if err != nil {
+			fmt.Printf("%s ERR: refreshing online bots: %v\n", ts(), err)
+			continue
+		}
+
+		l.botQueueMtx.Lock()
+		l.botQueue = botQueue
+		l.botQueueMtx.Unlock()
+		fmt.Printf("%s %d bots online\n", ts(), len(botQueue.Bots))
+	}
+}
+
+// parseSparring reads the -sparring list of bot names.
+func parseSparring(text string) []string {
+	var ids []string
+	for _, name := range strings.Split(text, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			ids = append(ids, name)
+		}
+	}
+	return ids
+}
+
+// challengeBan returns the ban a failed challenge earns the bot, false for none: lichess
+// being down or rate limiting us says nothing about the bot.
+func challengeBan(err error) (storage.BanReason, bool) {
+	if _, ok := api.RetryAfter(err); ok || api.Transient(err) {
+		return "", false
+	}
+	return storage.ClassifyBan(err.Error()), true
+}
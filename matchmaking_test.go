@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"trollfish-lichess/api"
+	"trollfish-lichess/storage"
+)
+
+func TestRatingBands(t *testing.T) {
+	// act
+	got := ratingBands(2500, 4000, 300, 2)
+
+	// assert
+	want := []ratingBand{{2500, 4000}, {2200, 2499}, {4001, 4300}, {1900, 2199}, {4301, 4600}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestListener_ChallengeTargets(t *testing.T) {
+	// arrange
+	bot := func(id string, rating int, provisional bool) *api.BotInfo {
+		return &api.BotInfo{User: api.User{ID: id, Username: id, Perfs: map[string]api.VariantPerf{"bullet": {Rating: rating, Provisional: provisional}}}}
+	}
+	l := &Listener{
+		botQueue: &api.BotQueue{Bots: []*api.BotInfo{
+			bot(botID, 2800, false),
+			bot("strong", 2700, false),
+			bot("weak", 1800, false),
+			bot("new", 2700, true),
+			bot("grumpy", 2900, false),
+			bot("partner", 1500, false),
+		}},
+		sparring: []string{"offline", "partner"},
+	}
+	banned, err := storage.LoadBans(storage.NewMemory(), bannedFilename, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := banned.Add("Grumpy", storage.BanRefused, "later", time.Now(), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	var got []string
+	for _, target := range l.challengeTargets(banned, ratingBand{2500, 4000}) {
+		got = append(got, target.User.ID)
+	}
+
+	// assert
+	if want := []string{"partner", "strong"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestChallengeBan(t *testing.T) {
+	cases := []struct {
+		name    string
+		err     error
+		want    storage.BanReason
+		wantBan bool
+	}{
+		{name: "refused", err: &api.StatusError{StatusCode: 400, Body: `{"error":"nope"}`}, want: storage.BanRefused, wantBan: true},
+		{name: "lichess down", err: &api.StatusError{StatusCode: 502, Endpoint: "challenge"}},
+		{name: "rate limited", err: &api.StatusError{StatusCode: 429, RetryAfter: time.Minute}},
+		{name: "network", err: errors.New("connection reset by peer")},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// act
+			got, ban := challengeBan(c.err)
+
+			// assert
+			if got != c.want || ban != c.wantBan {
+				t.Errorf("got %q %v, want %q %v", got, ban, c.want, c.wantBan)
+			}
+		})
+	}
+}
+
+func TestListener_ChallengeColor(t *testing.T) {
+	cases := []struct {
+		name   string
+		color  ChallengeColor
+		played []string
+		want   string
+	}{
+		{name: "first game", color: ChallengeColor{Color: "random", Alternate: true}, want: "random"},
+		{name: "after white", color: ChallengeColor{Color: "random", Alternate: true}, played: []string{"white"}, want: "black"},
+		{name: "after black", color: ChallengeColor{Color: "white", Alternate: true}, played: []string{"black"}, want: "white"},
+		{name: "random isn't a color played", color: ChallengeColor{Color: "random", Alternate: true}, played: []string{"black", "random"}, want: "white"},
+		{name: "not alternating", color: ChallengeColor{Color: "white"}, played: []string{"white"}, want: "white"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			l := &Listener{color: c.color, lastColor: make(map[string]string)}
+			for _, color := range c.played {
+				l.recordColor("SomeBot", color)
+			}
+
+			// act
+			got := l.challengeColor("somebot")
+
+			// assert
+			if got != c.want {
+				t.Errorf("got %s, want %s", got, c.want)
+			}
+		})
+	}
+}
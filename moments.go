@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+
+	"trollfish-lichess/fen"
+)
+
+// momentSwing is how far our winning chances (-1 to 1, see wdl.WDL.Chances) have to move
+// between two of our searches for the move in between to be a blunder worth a link.
+const momentSwing = 0.5
+
+// evalMoment is our eval before our move at a ply.
+type evalMoment struct {
+	ply     int // moves played before it
+	chances float64
+	mating  bool // we have a forced mate
+}
+
+// notableMoment returns what happened between our evals prev, nil for none, and cur, and the
+// ply of the position to link to, "" when nothing notable did: a mate found for us, or a
+// blunder by either side when our chances swung since our previous move.
+func notableMoment(prev *evalMoment, cur evalMoment) (string, int) {
+	switch {
+	case cur.mating && (prev == nil || !prev.mating):
+		return "mate found", cur.ply
+	case prev == nil || prev.ply != cur.ply-2:
+		return "", 0
+	case cur.chances-prev.chances >= momentSwing:
+		return "opponent blunder", cur.ply
+	case prev.chances-cur.chances >= momentSwing:
+		return "our blunder", cur.ply - 1
+	}
+	return "", 0
+}
+
+// gameURL returns the game's lichess URL at the position after ply.
+func gameURL(gameID string, ply int) string {
+	return fmt.Sprintf("https://lichess.org/%s#%d", gameID, ply)
+}
+
+// checkMoment logs a link to the notable moment, if any, that our eval of board at ply shows.
+func (g *Game) checkMoment(board fen.Board, ply int, eval string) {
+	if eval == "" {
+		return
+	}
+	cur := evalMoment{
+		ply:     ply,
+		chances: evalWDL(g.wdlModel(), eval, g.playerColor, board).Chances(),
+		mating:  evalScore(eval).For(g.playerColor).Mate > 0,
+	}
+	prev := g.lastMoment
+	g.lastMoment = &cur
+	what, linkPly := notableMoment(prev, cur)
+	if what == "" {
+		return
+	}
+	var prevChances float64
+	if prev != nil {
+		prevChances = prev.chances
+	}
+
+	var move string
+	if linkPly > 0 && linkPly <= len(g.moves) {
+		move = " " + g.moves[linkPly-1].MoveSAN
+	}
+	fmt.Printf("%s *** %s%s, our winning chances %.2f -> %.2f, eval %s: %s\n", ts(), what, move, prevChances, cur.chances, eval, gameURL(g.gameID, linkPly))
+	g.moveAudit.Note("%s%s: %s", what, move, gameURL(g.gameID, linkPly))
+}
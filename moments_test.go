@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestNotableMoment(t *testing.T) {
+	cases := []struct {
+		name    string
+		prev    *evalMoment
+		cur     evalMoment
+		want    string
+		wantPly int
+	}{
+		{name: "first search", cur: evalMoment{ply: 4, chances: 0.9}},
+		{name: "mate found", prev: &evalMoment{ply: 20, chances: 0.9}, cur: evalMoment{ply: 22, chances: 1, mating: true}, want: "mate found", wantPly: 22},
+		{name: "still mating", prev: &evalMoment{ply: 20, chances: 1, mating: true}, cur: evalMoment{ply: 22, chances: 1, mating: true}},
+		{name: "opponent blunder", prev: &evalMoment{ply: 20}, cur: evalMoment{ply: 22, chances: 0.6}, want: "opponent blunder", wantPly: 22},
+		{name: "our blunder", prev: &evalMoment{ply: 21, chances: 0.2}, cur: evalMoment{ply: 23, chances: -0.4}, want: "our blunder", wantPly: 22},
+		{name: "small swing", prev: &evalMoment{ply: 20, chances: 0.2}, cur: evalMoment{ply: 22, chances: 0.5}},
+		{name: "not our previous move", prev: &evalMoment{ply: 10}, cur: evalMoment{ply: 22, chances: 0.9}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// act
+			got, ply := notableMoment(c.prev, c.cur)
+
+			// assert
+			if got != c.want || ply != c.wantPly {
+				t.Errorf("got '%s' at ply %d, want '%s' at ply %d", got, ply, c.want, c.wantPly)
+			}
+		})
+	}
+}
+
+func TestGameURL(t *testing.T) {
+	if got, want := gameURL("abcd1234", 22), "https://lichess.org/abcd1234#22"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,52 @@
+// Package nnue writes positions as training data for NNUE nets, in the "plain" text format
+// of Stockfish's data tools. Their convert command turns it into .binpack for the trainer:
+//
+//	stockfish convert training.plain training.binpack
+package nnue
+
+import (
+	"fmt"
+	"io"
+)
+
+// valueMate is Stockfish's mate score; mate in n plies scores valueMate-n.
+const valueMate = 32000
+
+// Entry is a training position. Score and Result are from the side to move's pov.
+type Entry struct {
+	FEN    string // with move counters
+	Move   string // UCI, the best or played move
+	Score  int    // cp, see Score
+	Ply    int
+	Result int // 1 win, 0 draw or unknown, -1 loss
+}
+
+// Score returns an eval in cp or moves to mate as a Stockfish score.
+func Score(cp, mate int) int {
+	switch {
+	case mate > 0:
+		return valueMate - (2*mate - 1)
+	case mate < 0:
+		return -valueMate + 2*-mate
+	default:
+		return cp
+	}
+}
+
+// Ply returns the plies played before a position from its FEN's move counter and side to move.
+func Ply(fullMove int, whiteToMove bool) int {
+	ply := 2 * (fullMove - 1)
+	if !whiteToMove {
+		ply++
+	}
+	if ply < 0 {
+		return 0
+	}
+	return ply
+}
+
+// Write writes e in the plain format, an 'e' line ending each entry.
+func (e Entry) Write(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "fen %s\nmove %s\nscore %d\nply %d\nresult %d\ne\n", e.FEN, e.Move, e.Score, e.Ply, e.Result)
+	return err
+}
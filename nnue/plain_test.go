@@ -0,0 +1,52 @@
+package nnue
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEntry_Write(t *testing.T) {
+	// arrange
+	e := Entry{
+		FEN:    "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq - 0 1",
+		Move:   "c7c5",
+		Score:  Score(-35, 0),
+		Ply:    Ply(1, false),
+		Result: -1,
+	}
+
+	// act
+	var sb strings.Builder
+	err := e.Write(&sb)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "fen rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq - 0 1\nmove c7c5\nscore -35\nply 1\nresult -1\ne\n"
+	if sb.String() != want {
+		t.Errorf("got %q, want %q", sb.String(), want)
+	}
+}
+
+func TestScore(t *testing.T) {
+	cases := []struct {
+		cp, mate int
+		want     int
+	}{
+		{cp: 25, want: 25},
+		{mate: 1, want: 31999},
+		{mate: 3, want: 31995},
+		{mate: -1, want: -31998},
+	}
+
+	for _, c := range cases {
+		// act
+		got := Score(c.cp, c.mate)
+
+		// assert
+		if got != c.want {
+			t.Errorf("Score(%d, %d): got %d, want %d", c.cp, c.mate, got, c.want)
+		}
+	}
+}
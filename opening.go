@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+
+	"trollfish-lichess/api"
+	"trollfish-lichess/eco"
+	"trollfish-lichess/fen"
+)
+
+// announceOpening logs the last move before we left book and the opening's name, e.g.
+// "Out of book after 9...Nbd7 (D47 Semi-Slav Defense: Meran Variation)", and saves the
+// name for the game history. Called on the event loop.
+func (g *Game) announceOpening() {
+	if len(g.moves) == 0 {
+		return
+	}
+
+	last := g.moves[len(g.moves)-1]
+	b := fen.FENtoBoard(last.FEN)
+	msg := fmt.Sprintf("Out of book after %d.%s%s", b.FullMove, iif(b.ActiveColor == fen.WhitePieces, "", ".."), last.MoveSAN)
+
+	if g.initialFEN == "startpos" {
+		sans := make([]string, 0, len(g.moves))
+		for _, move := range g.moves {
+			sans = append(sans, move.MoveSAN)
+		}
+		if opening, ok := eco.Default.Classify(sans); ok {
+			msg += fmt.Sprintf(" (%s)", opening)
+			g.Lock()
+			g.openingName = opening.String()
+			g.Unlock()
+		}
+	}
+
+	fmt.Printf("%s %s\n", ts(), msg)
+	g.moveAudit.Note("%s", msg)
+
+	if g.opts.AnnounceOpening {
+		go func() {
+			if err := api.Chat(g.gameID, "spectator", msg); err != nil {
+				fmt.Printf("%s ERR: api.Chat: %v\n", ts(), err)
+			}
+		}()
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+
+	"trollfish-lichess/fen"
+)
+
+func TestGame_AnnounceOpening(t *testing.T) {
+	// arrange
+	g := &Game{initialFEN: "startpos"}
+	board := fen.FENtoBoard(startPosFEN)
+	for _, uci := range []string{"e2e4", "c7c5", "g1f3", "d7d6", "d2d4", "c5d4", "f3d4", "g8f6", "b1c3", "a7a6"} {
+		g.storeMove(board.FEN(), board.UCItoSAN(uci))
+		board.Moves(uci)
+	}
+
+	// act
+	g.announceOpening()
+
+	// assert
+	if got := g.HistoryRecord().OpeningName; got != "B90 Sicilian Defense: Najdorf Variation" {
+		t.Errorf("got %q", got)
+	}
+}
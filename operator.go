@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"trollfish-lichess/fen"
+)
+
+var errNoActiveGame = errors.New("no game in progress")
+
+// Operator is a human's override of the bot's next move, set through the admin server for
+// exhibition games and debugging. Every intervention is logged and noted in the move audit.
+type Operator struct {
+	Move  string        // SAN or UCI, checked against the position when it's played
+	Think time.Duration // the engine searches at least this long, skipping book
+}
+
+func (o Operator) IsZero() bool {
+	return o.Move == "" && o.Think == 0
+}
+
+// SetOperator replaces the override for our next move. When it's our turn the move is checked
+// straight away, otherwise when the opponent has moved.
+func (g *Game) SetOperator(op Operator) error {
+	g.Lock()
+	defer g.Unlock()
+
+	if op.Move != "" && g.turnFEN != "" {
+		if _, err := parseMove(fen.FENtoBoard(g.turnFEN), op.Move); err != nil {
+			return err
+		}
+	}
+
+	g.operator = op
+	fmt.Printf("%s operator: next move %s, think %v\n", ts(), iif(op.Move != "", op.Move, "the bot's"), op.Think)
+	return nil
+}
+
+// Operator returns the override for our next move and the position if it's our turn.
+func (g *Game) Operator() (Operator, string) {
+	g.Lock()
+	defer g.Unlock()
+
+	return g.operator, g.turnFEN
+}
+
+// takeOperatorThink returns and clears the think override, at the start of our move.
+func (g *Game) takeOperatorThink() time.Duration {
+	g.Lock()
+	defer g.Unlock()
+
+	think := g.operator.Think
+	g.operator.Think = 0
+	return think
+}
+
+// takeOperatorMove returns and clears the move override, at the end of our move.
+func (g *Game) takeOperatorMove() string {
+	g.Lock()
+	defer g.Unlock()
+
+	move := g.operator.Move
+	g.operator.Move = ""
+	return move
+}
+
+func (g *Game) setTurnFEN(fen string) {
+	g.Lock()
+	g.turnFEN = fen
+	g.Unlock()
+}
+
+// operatorMove replaces bestMove with the operator's move, if there is one and it's legal.
+func (g *Game) operatorMove(board fen.Board, bestMove string) string {
+	text := g.takeOperatorMove()
+	if text == "" {
+		return bestMove
+	}
+
+	move, err := parseMove(board, text)
+	if err != nil {
+		fmt.Printf("%s operator: %v, playing %s\n", ts(), err, board.UCItoSAN(bestMove))
+		g.moveAudit.Note("operator move rejected: %v", err)
+		return bestMove
+	}
+
+	fmt.Printf("%s operator: %s instead of %s\n", ts(), board.UCItoSAN(move), board.UCItoSAN(bestMove))
+	g.moveAudit.Note("operator intervention: %s instead of %s (eval %s)", board.UCItoSAN(move), board.UCItoSAN(bestMove), g.humanEval)
+	if g.moveAudit != nil {
+		g.moveAudit.Source = "operator"
+	}
+	if move == bestMove {
+		return bestMove
+	}
+
+	// the ponder search was for the reply to bestMove
+	if g.ponder != "" {
+		g.stopPondering()
+		g.ponder = ""
+		g.totalPonders--
+	}
+	return move
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"trollfish-lichess/fen"
+	"trollfish-lichess/yamlbook"
+)
+
+const (
+	premoveThink   = 150 * time.Millisecond // an opponent move this fast was premoved
+	slowThinkRatio = 2.5                    // times their average think that counts as burning time
+	slowThinkMin   = 3 * time.Second        // and the least think that does
+)
+
+// OpponentClock sets how the opponent's time usage steers our play.
+type OpponentClock struct {
+	// PremoveMoves leaves book after the opponent premoved this many opening moves in a row,
+	// they're likely booked up. 0 = off.
+	PremoveMoves int
+
+	// SteerSlow prefers equal book moves reaching a pawn structure the opponent burned time in.
+	SteerSlow bool
+}
+
+// opponentThink is one of the opponent's moves and the time it took.
+type opponentThink struct {
+	Ply       int
+	Think     time.Duration
+	Structure string // pawn structure they thought in, see pawnStructure
+}
+
+// opponentClock tracks the opponent's time usage during a game, on the event loop.
+type opponentClock struct {
+	lastClock time.Duration // their clock at our previous move, -1 before their clock runs
+	thinks    []opponentThink
+	premoves  int             // opening moves in a row they premoved
+	slow      map[string]bool // structures they burned time in
+	leftBook  bool            // we left book because of premoves
+}
+
+func newOpponentClock() opponentClock {
+	return opponentClock{lastClock: -1, slow: make(map[string]bool)}
+}
+
+// observe records the opponent's move at ply, played from faced, given their clock now. Their
+// first move isn't timed, lichess doesn't start the clock until both sides moved.
+func (c *opponentClock) observe(ply int, faced fen.Board, clock, increment time.Duration, inBook bool) (opponentThink, bool) {
+	last := c.lastClock
+	c.lastClock = clock
+	if last < 0 {
+		return opponentThink{}, false
+	}
+
+	think := last - clock + increment
+	if think < 0 {
+		think = 0
+	}
+	move := opponentThink{Ply: ply, Think: think, Structure: pawnStructure(faced)}
+
+	if inBook && think <= premoveThink {
+		c.premoves++
+	} else {
+		c.premoves = 0
+	}
+
+	if avg := c.average(); len(c.thinks) >= 2 && think >= slowThinkMin && float64(think) >= slowThinkRatio*float64(avg) {
+		c.slow[move.Structure] = true
+	}
+
+	c.thinks = append(c.thinks, move)
+	return move, true
+}
+
+// average is their average think so far.
+func (c *opponentClock) average() time.Duration {
+	if len(c.thinks) == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, move := range c.thinks {
+		total += move.Think
+	}
+	return total / time.Duration(len(c.thinks))
+}
+
+// pawnStructure returns the placement of the pawns, e.g. "8/pp3ppp/2p5/8/3P4/8/PP3PPP/8".
+func pawnStructure(board fen.Board) string {
+	placement, _, _ := strings.Cut(board.FEN(), " ")
+
+	var sb strings.Builder
+	var empty int
+	flush := func() {
+		if empty > 0 {
+			sb.WriteString(fmt.Sprint(empty))
+			empty = 0
+		}
+	}
+	for _, r := range placement {
+		switch {
+		case r == 'p' || r == 'P':
+			flush()
+			sb.WriteRune(r)
+		case r == '/':
+			flush()
+			sb.WriteRune(r)
+		case r >= '1' && r <= '8':
+			empty += int(r - '0')
+		default:
+			empty++
+		}
+	}
+	flush()
+	return sb.String()
+}
+
+// watchOpponentClock times the opponent's last move and reports whether to leave book, given
+// the position they faced.
+func (g *Game) watchOpponentClock(ply int, faced fen.Board, opponentTime time.Duration) bool {
+	policy := g.opts.OpponentClock
+	move, ok := g.opponentClock.observe(ply, faced, opponentTime, g.clock.Increment, !g.leftBook)
+	if !ok {
+		return g.opponentClock.leftBook
+	}
+
+	if policy.SteerSlow && g.opponentClock.slow[move.Structure] {
+		fmt.Printf("%s opponent took %v (average %v) in %s\n", ts(), move.Think.Round(100*time.Millisecond), g.opponentClock.average().Round(100*time.Millisecond), move.Structure)
+	}
+
+	if policy.PremoveMoves > 0 && !g.opponentClock.leftBook && g.opponentClock.premoves >= policy.PremoveMoves {
+		fmt.Printf("%s opponent premoved %d opening moves in a row, leaving book\n", ts(), g.opponentClock.premoves)
+		g.moveAudit.Note("left book, the opponent premoved %d opening moves in a row", g.opponentClock.premoves)
+		g.opponentClock.leftBook = true
+	}
+	return g.opponentClock.leftBook
+}
+
+// slowStructureBias returns a yamlbook.MoveBias against equal book moves that don't reach a
+// pawn structure the opponent burned time in, when another move does. Nil when off.
+func (g *Game) slowStructureBias() yamlbook.MoveBias {
+	if !g.opts.OpponentClock.SteerSlow || len(g.opponentClock.slow) == 0 {
+		return nil
+	}
+
+	slow := g.opponentClock.slow
+	reaches := func(move *yamlbook.Move) bool {
+		board := fen.FENtoBoard(move.FEN())
+		board.Moves(move.UCI())
+		return slow[pawnStructure(board)]
+	}
+
+	anySlow := make(map[string]bool) // by position
+	return func(move *yamlbook.Move) int {
+		if reaches(move) {
+			return 0
+		}
+		fenKey := fen.Key(move.FEN())
+		found, ok := anySlow[fenKey]
+		if !ok {
+			moves, _ := g.book.Get(fenKey)
+			for _, other := range moves {
+				if reaches(other) {
+					found = true
+					break
+				}
+			}
+			anySlow[fenKey] = found
+		}
+		return iif(found, 1, 0)
+	}
+}
+
+// bookBias combines the variety and slow structure biases.
+func (g *Game) bookBias(sans []string) yamlbook.MoveBias {
+	variety, slow := g.varietyBias(sans), g.slowStructureBias()
+	switch {
+	case variety == nil:
+		return slow
+	case slow == nil:
+		return variety
+	}
+	return func(move *yamlbook.Move) int {
+		return variety(move) + slow(move)
+	}
+}
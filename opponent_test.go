@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"trollfish-lichess/fen"
+)
+
+func TestPawnStructure(t *testing.T) {
+	// arrange
+	board := fen.FENtoBoard("r1bqkb1r/pp3ppp/2n1pn2/2pp4/3P4/2PBPN2/PP3PPP/RNBQK2R w KQkq - 0 6")
+
+	// act
+	got := pawnStructure(board)
+
+	// assert
+	if want := "8/pp3ppp/4p3/2pp4/3P4/2P1P3/PP3PPP/8"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestOpponentClock_Observe(t *testing.T) {
+	// arrange
+	c := newOpponentClock()
+	board := fen.FENtoBoard(startPosFEN)
+	slowBoard := fen.FENtoBoard("rnbqkbnr/pp2pppp/8/2pp4/3P4/8/PPP1PPPP/RNBQKBNR w KQkq - 0 3")
+	inc := 2 * time.Second
+
+	// act, assert
+	if _, ok := c.observe(1, board, 3*time.Minute, inc, true); ok {
+		t.Fatal("first move: want untimed")
+	}
+
+	clock := 3 * time.Minute
+	for ply := 3; ply <= 7; ply += 2 {
+		clock += inc - 50*time.Millisecond
+		move, ok := c.observe(ply, board, clock, inc, true)
+		if !ok || move.Think != 50*time.Millisecond {
+			t.Fatalf("ply %d: got %v %v", ply, move.Think, ok)
+		}
+	}
+	if c.premoves != 3 {
+		t.Errorf("premoves: got %d, want 3", c.premoves)
+	}
+
+	clock -= 20 * time.Second
+	move, _ := c.observe(9, slowBoard, clock, inc, true)
+	if move.Think != 22*time.Second {
+		t.Errorf("think: got %v, want 22s", move.Think)
+	}
+	if c.premoves != 0 {
+		t.Errorf("premoves after a long think: got %d, want 0", c.premoves)
+	}
+	if !c.slow[pawnStructure(slowBoard)] || c.slow[pawnStructure(board)] {
+		t.Errorf("slow structures: got %v", c.slow)
+	}
+}
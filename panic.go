@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"trollfish-lichess/fen"
+	"trollfish-lichess/query"
+)
+
+// PanicTime saves the game when the engine hangs: past Fraction of our time left without a
+// bestmove the engine is sent 'stop', and StopWait later a fallback move is played, see
+// panicFallback, instead of waiting until we flag.
+type PanicTime struct {
+	Fraction float64       // of our time left, 0 = off
+	StopWait time.Duration // for the bestmove after 'stop'
+}
+
+// waitSearch waits up to wait for search's bestmove. With PanicTime it sends 'stop' past its
+// deadline and returns fallback's move, true, if the engine still hasn't answered StopWait
+// later. fallback is only called then, nil for none.
+func (g *Game) waitSearch(ctx context.Context, search *Search, wait, ourTime time.Duration, fallback func() string) (BestMove, bool, error) {
+	p := g.opts.Panic
+	deadline := time.Duration(float64(ourTime) * p.Fraction)
+	if p.Fraction <= 0 || fallback == nil || deadline >= wait {
+		result, err := search.Wait(ctx, wait)
+		return result, false, err
+	}
+
+	result, err := search.Wait(ctx, deadline)
+	if !errors.Is(err, errEngineTimeout) {
+		return result, false, err
+	}
+
+	fmt.Printf("%s *** no bestmove after %v, sending stop\n", ts(), deadline)
+	_ = g.engine.Send("stop")
+	result, err = search.Wait(ctx, p.StopWait)
+	if !errors.Is(err, errEngineTimeout) {
+		return result, false, err
+	}
+
+	move := fallback()
+	if move == "" {
+		return result, false, err
+	}
+	fmt.Printf("%s *** no bestmove %v after stop, playing the fallback move %s\n", ts(), p.StopWait, move)
+	return BestMove{SearchID: search.ID, Move: move}, true, nil
+}
+
+// panicFallback returns the move to play if the engine hangs: the book move when it's legal,
+// else our own quick pick, mating at once or keeping the most material after the opponent's
+// best reply. "" when there's no legal move.
+func panicFallback(board fen.Board, book *WeightedMove) string {
+	if book != nil && board.IsLegal(book.UCI) {
+		return book.UCI
+	}
+
+	var (
+		best      string
+		bestScore int
+	)
+	for _, move := range board.AllLegalMoves() {
+		next := board
+		next.Moves(move.UCI)
+		if next.IsMate() {
+			return move.UCI
+		}
+
+		score := panicReplyScore(next, board.ActiveColor)
+		if best == "" || score > bestScore {
+			best, bestScore = move.UCI, score
+		}
+	}
+	return best
+}
+
+// panicReplyScore returns the material for us, color, after the opponent's best reply in
+// board, the position after our move. Getting mated scores lowest, stalemate as even.
+func panicReplyScore(board fen.Board, color fen.Color) int {
+	replies := board.AllLegalMoves()
+	if len(replies) == 0 {
+		return 0
+	}
+
+	score := 1000
+	for _, reply := range replies {
+		next := board
+		next.Moves(reply.UCI)
+		s := query.Balance(next, color)
+		if next.IsMate() {
+			s = -1000
+		}
+		if s < score {
+			score = s
+		}
+	}
+	return score
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"trollfish-lichess/fen"
+)
+
+func TestPanicFallback(t *testing.T) {
+	cases := []struct {
+		name  string
+		fen   string
+		book  *WeightedMove
+		want  string
+		avoid string // when any other move will do
+	}{
+		{name: "book move", fen: startPosFEN, book: &WeightedMove{UCI: "e2e4"}, want: "e2e4"},
+		{name: "illegal book move", fen: "6k1/5ppp/8/8/8/8/8/R6K w - - 0 1", book: &WeightedMove{UCI: "e2e4"}, want: "a1a8"},
+		{name: "mate in one", fen: "6k1/5ppp/8/8/8/8/8/R6K w - - 0 1", want: "a1a8"},
+		{name: "take the queen", fen: "k7/8/8/3q4/8/8/8/3RK3 w - - 0 1", want: "d1d5"},
+		{name: "don't take a defended pawn with the queen", fen: "k7/8/2p5/3p4/8/8/8/3QK3 w - - 0 1", avoid: "d1d5"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// act
+			got := panicFallback(fen.FENtoBoard(c.fen), c.book)
+
+			// assert
+			if c.avoid != "" && (got == c.avoid || got == "") {
+				t.Errorf("got '%s', want any other move", got)
+			}
+			if c.avoid == "" && got != c.want {
+				t.Errorf("got %s, want %s", got, c.want)
+			}
+		})
+	}
+}
+
+func TestGame_WaitSearch_Panic(t *testing.T) {
+	// arrange
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	input := make(chan string, 16)
+	output := make(chan string)
+	g := &Game{engine: NewEngine(ctx, input, output), opts: GameOptions{Panic: PanicTime{Fraction: 0.9, StopWait: 10 * time.Millisecond}}}
+
+	search, err := g.engine.Go("position startpos", "go wtime 100 btime 100")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	got, panicked, err := g.waitSearch(ctx, search, time.Second, 50*time.Millisecond, func() string { return "e2e4" })
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !panicked || got.Move != "e2e4" {
+		t.Errorf("got %+v panicked %v, want the fallback", got, panicked)
+	}
+	var sentStop bool
+	for len(input) > 0 {
+		sentStop = sentStop || <-input == "stop"
+	}
+	if !sentStop {
+		t.Error("stop wasn't sent")
+	}
+}
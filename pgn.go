@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"trollfish-lichess/api"
+	"trollfish-lichess/fen"
+	"trollfish-lichess/history"
+)
+
+// gamesPGNFilename collects our finished games.
+const gamesPGNFilename = "games.pgn"
+
+// GameOptions.GIF values
+const (
+	gifThumbnail = "thumbnail"
+	gifFull      = "full"
+)
+
+// gifsDir has a GIF of each finished game, named by game id.
+const gifsDir = "gifs"
+
+const (
+	gifExportDelay   = 2 * time.Second // lichess needs a moment to finish the game
+	gifExportTimeout = 30 * time.Second
+)
+
+// PGN returns the game in PGN. Games from a setup position get [SetUp] and [FEN] tags.
+// Called on the event loop.
+func (g *Game) PGN() string {
+	g.Lock()
+	ourRating, opponent, result, createdAt, rated := g.ourRating, g.opponent, g.result, g.createdAt, g.rated
+	g.Unlock()
+
+	weAreWhite := g.playerColor == fen.WhitePieces
+	white, black := botID, opponent.Name
+	whiteElo, blackElo := ourRating, opponent.Rating
+	if !weAreWhite {
+		white, black = black, white
+		whiteElo, blackElo = blackElo, whiteElo
+	}
+
+	resultTag := "*"
+	switch result {
+	case history.Win:
+		resultTag = iif(weAreWhite, "1-0", "0-1")
+	case history.Loss:
+		resultTag = iif(weAreWhite, "0-1", "1-0")
+	case history.Draw:
+		resultTag = "1/2-1/2"
+	}
+
+	var sb strings.Builder
+	tag := func(name, value string) {
+		sb.WriteString(fmt.Sprintf("[%s \"%s\"]\n", name, value))
+	}
+
+	tag("Event", iif(rated, "Rated game", "Casual game"))
+	tag("Site", "https://lichess.org/"+g.gameID)
+	tag("Date", time.UnixMilli(createdAt).UTC().Format("2006.01.02"))
+	tag("White", white)
+	tag("Black", black)
+	tag("Result", resultTag)
+	tag("WhiteElo", fmt.Sprintf("%d", whiteElo))
+	tag("BlackElo", fmt.Sprintf("%d", blackElo))
+	if g.initialFEN != "" && g.initialFEN != "startpos" && g.initialFEN != startPosFEN {
+		tag("SetUp", "1")
+		tag("FEN", g.initialFEN)
+	}
+	sb.WriteByte('\n')
+
+	for i, move := range g.moves {
+		b := fen.FENtoBoard(move.FEN)
+		if b.ActiveColor == fen.WhitePieces {
+			sb.WriteString(fmt.Sprintf("%d. ", b.FullMove))
+		} else if i == 0 {
+			sb.WriteString(fmt.Sprintf("%d... ", b.FullMove))
+		}
+		sb.WriteString(move.MoveSAN)
+		sb.WriteByte(' ')
+	}
+	sb.WriteString(resultTag)
+	sb.WriteString("\n\n")
+
+	return sb.String()
+}
+
+// saveToPGN appends the game to gamesPGNFilename, unless it was aborted before any moves.
+func (g *Game) saveToPGN() {
+	if len(g.moves) == 0 {
+		return
+	}
+
+	if err := g.store.AppendFile(gamesPGNFilename, []byte(g.PGN())); err != nil {
+		log.Printf("ERR: write file '%s': %v\n", gamesPGNFilename, err)
+	}
+}
+
+// saveGIF saves lichess' GIF of the game to gifsDir in the background, from our side of
+// the board. Games aborted before any moves have none.
+func (g *Game) saveGIF() {
+	if g.opts.GIF == "" || len(g.moves) == 0 {
+		return
+	}
+
+	gameID := g.gameID
+	opts := api.GIFOptions{
+		Color:     iif(g.playerColor == fen.BlackPieces, "black", "white"),
+		Thumbnail: g.opts.GIF == gifThumbnail,
+	}
+	name := fmt.Sprintf("%s/%s.gif", gifsDir, gameID)
+
+	go func() {
+		time.Sleep(gifExportDelay)
+
+		ctx, cancel := context.WithTimeout(context.Background(), gifExportTimeout)
+		defer cancel()
+
+		b, err := api.ExportGIF(ctx, gameID, opts)
+		if err != nil {
+			fmt.Printf("%s ERR: gif %s: %v\n", ts(), gameID, err)
+			return
+		}
+		if err := g.store.WriteFile(name, b); err != nil {
+			log.Printf("ERR: write file '%s': %v\n", name, err)
+		}
+	}()
+}
@@ -7,14 +7,25 @@ import (
 	"log"
 	"math/rand"
 	"os"
+	"strings"
 
 	"trollfish-lichess/fen"
+	"trollfish-lichess/storage"
 )
 
+// ExtractFilename is where positions looked up in a polyglot book are appended in EPD form.
+const ExtractFilename = "extract.epd"
+
 type Book struct {
 	book map[string][]*BookEntry
 
 	polyglotBook map[uint64][]*BookEntry
+
+	// Extract, if set, receives every position looked up in the polyglot book (see ExtractFilename).
+	Extract storage.Storage
+
+	// Skipped counts the illegal entries Get dropped, the first is logged.
+	Skipped int
 }
 
 type BookEntry struct {
@@ -62,26 +73,37 @@ func (b *Book) Get(fenKey string) ([]*BookEntry, bool) {
 		if ok {
 			delete(b.polyglotBook, key)
 
-			fp, err := os.OpenFile("extract.epd", os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
-			if err != nil {
-				log.Fatal(err)
-			}
-			defer fp.Close()
-
+			// corrupt entries would leave illegal positions, see fen.Board.MovesStrict
+			legal := be[:0]
+			var sb strings.Builder
 			for _, entry := range be {
 				uciMove := toUCIMove(board, entry.polyglotMove)
+				if !board.IsLegal(uciMove) {
+					if b.Skipped == 0 {
+						fmt.Printf("polyglot: skipping illegal entries, the first '%s' in '%s'\n", uciMove, fenKey)
+					}
+					b.Skipped++
+					continue
+				}
 				entry.UCIMove = uciMove
+				legal = append(legal, entry)
 
 				san := board.UCItoSAN(uciMove)
 
-				_, err := fmt.Fprintf(fp, "%s sm %s; weight %d;\n", fenKey, san, entry.Weight)
-				if err != nil {
+				sb.WriteString(fmt.Sprintf("%s sm %s; weight %d;\n", fenKey, san, entry.Weight))
+			}
+
+			if b.Extract != nil {
+				if err := b.Extract.AppendFile(ExtractFilename, []byte(sb.String())); err != nil {
 					log.Fatal(err)
 				}
 			}
 
-			b.book[fenKey] = be
-			return be, true
+			if len(legal) == 0 {
+				return nil, false
+			}
+			b.book[fenKey] = legal
+			return legal, true
 		}
 	}
 
@@ -99,7 +121,9 @@ func (b *Book) Add(fenKey, sanMove string, cp, mate int, sanPonder string) error
 
 	var uciPonder string
 	if sanPonder != "" {
-		board.Moves(uci)
+		if err := board.MovesStrict(uci); err != nil {
+			return err
+		}
 		uciPonder, err = board.SANtoUCI(sanPonder)
 		if err != nil {
 			return err
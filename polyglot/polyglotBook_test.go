@@ -0,0 +1,52 @@
+package polyglot
+
+import (
+	"testing"
+
+	"trollfish-lichess/fen"
+	"trollfish-lichess/internal/benchdata"
+)
+
+func BenchmarkBook_Get(b *testing.B) {
+	// every other position is in the book, the misses are looked up in the polyglot book too
+	boards := benchdata.Boards(benchdata.Positions)
+	book := NewBook()
+	book.polyglotBook = make(map[uint64][]*BookEntry)
+	fens := make([]string, 0, len(boards))
+	for i, board := range boards {
+		if i%2 == 0 {
+			move := board.AllLegalMoves()[0]
+			if err := book.Add(board.FENKey(), board.UCItoSAN(move.UCI), 30, 0, ""); err != nil {
+				b.Fatal(err)
+			}
+		}
+		fens = append(fens, board.FEN())
+	}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, _ = book.Get(fens[i%len(fens)])
+	}
+}
+
+func TestBook_Get_SkipsIllegal(t *testing.T) {
+	// arrange
+	board := fen.FENtoBoard("rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1")
+	const (
+		e2e4 = 4 | 3<<3 | 4<<6 | 1<<9
+		e2e5 = 4 | 4<<3 | 4<<6 | 1<<9
+	)
+	book := NewBook()
+	book.polyglotBook = map[uint64][]*BookEntry{Key(board): {{polyglotMove: e2e5, Weight: 2}, {polyglotMove: e2e4, Weight: 1}, {polyglotMove: e2e5, Weight: 1}}}
+
+	// act
+	entries, ok := book.Get(board.FENKey())
+
+	// assert
+	if !ok || len(entries) != 1 || entries[0].UCIMove != "e2e4" {
+		t.Fatalf("got %v %v, want only e2e4", entries, ok)
+	}
+	if book.Skipped != 2 {
+		t.Errorf("skipped: got %d, want 2", book.Skipped)
+	}
+}
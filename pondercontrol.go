@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"trollfish-lichess/uciproc"
+)
+
+// PonderControl turns pondering off where it doesn't pay for its CPU: when too few of the
+// session's last Window predictions in a time control were played, or when other work on
+// the machine (more bots, engine matches, analysis) already keeps every CPU busy. Predictions
+// are still made and scored while off, so pondering comes back once they're played again.
+//
+// The load average counts our own engine's search and ponder threads too, which would turn
+// pondering off by itself pondering. With ownCPU the engine's share is taken out: its CPU
+// time is averaged over the same minute the kernel's load average is.
+type PonderControl struct {
+	Window  int                // predictions the hit rate is measured over, 0 = always ponder
+	MinRate map[string]float64 // hit rate pondering needs by perf (bullet, blitz, ...), "" for the others
+	MaxLoad float64            // 1-minute load average per CPU above which we don't ponder, 0 = ignore
+
+	load   func() float64       // uciproc.LoadPerCPU, replaced in tests
+	ownCPU func() time.Duration // the CPU time our engine has used, nil to count it in the load
+	cpus   int                  // runtime.NumCPU, replaced in tests
+
+	mu     sync.Mutex
+	recent map[string][]bool // the last Window predictions by perf, true for a hit
+	own    ownLoad
+}
+
+// ownLoad is our engine's running threads, averaged like the 1-minute load average.
+type ownLoad struct {
+	threads float64
+	cpu     time.Duration // ownCPU at sampled
+	sampled time.Time
+}
+
+// ownLoadWindow is the time constant of the kernel's 1-minute load average.
+const ownLoadWindow = time.Minute
+
+// sample adds the CPU time used since the last sample at now and returns the average.
+// An engine restarted since starts again from its new CPU time.
+func (o *ownLoad) sample(cpu time.Duration, now time.Time) float64 {
+	if !o.sampled.IsZero() && now.After(o.sampled) {
+		used := cpu - o.cpu
+		if used < 0 {
+			used = cpu
+		}
+		elapsed := now.Sub(o.sampled)
+		decay := math.Exp(-elapsed.Seconds() / ownLoadWindow.Seconds())
+		o.threads = o.threads*decay + used.Seconds()/elapsed.Seconds()*(1-decay)
+	}
+	o.cpu, o.sampled = cpu, now
+	return o.threads
+}
+
+// NewPonderControl returns a PonderControl with the hit rates of ParsePonderRates.
+func NewPonderControl(window int, rates string, maxLoad float64) (*PonderControl, error) {
+	minRate, err := ParsePonderRates(rates)
+	if err != nil {
+		return nil, err
+	}
+	return &PonderControl{Window: window, MinRate: minRate, MaxLoad: maxLoad, load: uciproc.LoadPerCPU, cpus: runtime.NumCPU()}, nil
+}
+
+// ParsePonderRates reads the hit rate pondering needs, or rates by time control: the rate
+// for every other perf, then perf:rate, e.g. "0.3,bullet:0.4,correspondence:0".
+func ParsePonderRates(s string) (map[string]float64, error) {
+	rates := make(map[string]float64)
+	for i, part := range strings.Split(s, ",") {
+		perf, rateText, hasPerf := strings.Cut(part, ":")
+		if !hasPerf {
+			perf, rateText = "", perf
+		}
+		if hasPerf == (i == 0) {
+			return nil, fmt.Errorf("'%s': want a hit rate then perf:rate, e.g. 0.3,bullet:0.4", s)
+		}
+
+		rate, err := strconv.ParseFloat(strings.TrimSpace(rateText), 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("'%s': want hit rates from 0 to 1", s)
+		}
+		rates[strings.TrimSpace(perf)] = rate
+	}
+	return rates, nil
+}
+
+// minRate returns the hit rate pondering needs in perf.
+func (p *PonderControl) minRate(perf string) float64 {
+	if rate, ok := p.MinRate[perf]; ok {
+		return rate
+	}
+	return p.MinRate[""]
+}
+
+// allows reports whether to ponder in perf, and if not why. A nil PonderControl always
+// ponders, and so does one without a full Window of predictions yet.
+func (p *PonderControl) allows(perf string) (bool, string) {
+	if p == nil {
+		return true, ""
+	}
+	if p.MaxLoad > 0 && p.load != nil {
+		if load := p.otherLoad(time.Now()); load > p.MaxLoad {
+			return false, fmt.Sprintf("load %.2f per CPU > %.2f", load, p.MaxLoad)
+		}
+	}
+	if p.Window <= 0 {
+		return true, ""
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	recent := p.recent[perf]
+	if len(recent) < p.Window {
+		return true, ""
+	}
+	var hits int
+	for _, hit := range recent {
+		if hit {
+			hits++
+		}
+	}
+	rate := float64(hits) / float64(len(recent))
+	if min := p.minRate(perf); rate < min {
+		return false, fmt.Sprintf("%d/%d of the last %s predictions played, %.0f%% < %.0f%%", hits, len(recent), perf, rate*100, min*100)
+	}
+	return true, ""
+}
+
+// otherLoad returns the load average per CPU without our engine's threads, see ownCPU.
+func (p *PonderControl) otherLoad(now time.Time) float64 {
+	load := p.load()
+	if p.ownCPU == nil || p.cpus <= 0 {
+		return load
+	}
+
+	p.mu.Lock()
+	own := p.own.sample(p.ownCPU(), now)
+	p.mu.Unlock()
+
+	return math.Max(load-own/float64(p.cpus), 0)
+}
+
+// record scores a prediction in perf, pondered or not.
+func (p *PonderControl) record(perf string, hit bool) {
+	if p == nil || p.Window <= 0 {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.recent == nil {
+		p.recent = make(map[string][]bool)
+	}
+	recent := append(p.recent[perf], hit)
+	if len(recent) > p.Window {
+		recent = recent[len(recent)-p.Window:]
+	}
+	p.recent[perf] = recent
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParsePonderRates(t *testing.T) {
+	cases := []struct {
+		input   string
+		want    map[string]float64
+		wantErr bool
+	}{
+		{input: "0.3", want: map[string]float64{"": 0.3}},
+		{input: "0.3, bullet:0.4,correspondence:0", want: map[string]float64{"": 0.3, "bullet": 0.4, "correspondence": 0}},
+		{input: "bullet:0.4", wantErr: true},
+		{input: "0.3,0.4", wantErr: true},
+		{input: "1.5", wantErr: true},
+		{input: "often", wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.input, func(t *testing.T) {
+			// act
+			got, err := ParsePonderRates(c.input)
+
+			// assert
+			if (err != nil) != c.wantErr {
+				t.Fatalf("err: got %v, want error %v", err, c.wantErr)
+			}
+			if len(got) != len(c.want) {
+				t.Fatalf("got %v, want %v", got, c.want)
+			}
+			for perf, rate := range c.want {
+				if got[perf] != rate {
+					t.Errorf("%q: got %v, want %v", perf, got[perf], rate)
+				}
+			}
+		})
+	}
+}
+
+func TestPonderControl_Allows(t *testing.T) {
+	rates := map[string]float64{"": 0.5, "bullet": 0.25}
+
+	cases := []struct {
+		name    string
+		maxLoad float64
+		load    float64
+		own     float64 // our engine's threads on 4 CPUs
+		perf    string
+		hits    []bool
+		want    bool
+	}{
+		{name: "no predictions yet", perf: "blitz", want: true},
+		{name: "window not full", perf: "blitz", hits: []bool{false, false, false}, want: true},
+		{name: "hits rare", perf: "blitz", hits: []bool{true, false, false, false}},
+		{name: "hits often", perf: "blitz", hits: []bool{true, true, false, false}, want: true},
+		{name: "rate by perf", perf: "bullet", hits: []bool{true, false, false, false}, want: true},
+		{name: "only the window counts", perf: "blitz", hits: []bool{false, false, false, false, true, true, false, false}, want: true},
+		{name: "busy machine", maxLoad: 1, load: 1.5, perf: "blitz", hits: []bool{true, true, true, true}},
+		{name: "idle machine", maxLoad: 1, load: 0.4, perf: "blitz", want: true},
+		{name: "busy with our engine", maxLoad: 1, load: 1.5, own: 4, perf: "blitz", want: true},
+		{name: "busy with more than our engine", maxLoad: 1, load: 2.5, own: 4, perf: "blitz"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			p := &PonderControl{Window: 4, MinRate: rates, MaxLoad: c.maxLoad, load: func() float64 { return c.load }}
+			if c.own != 0 {
+				p.ownCPU, p.cpus, p.own.threads = func() time.Duration { return 0 }, 4, c.own
+			}
+			for _, hit := range c.hits {
+				p.record(c.perf, hit)
+			}
+			p.record("rapid", false) // other time controls don't count
+
+			// act
+			got, why := p.allows(c.perf)
+
+			// assert
+			if got != c.want {
+				t.Errorf("got %v (%s), want %v", got, why, c.want)
+			}
+			if !got && why == "" {
+				t.Error("no reason given")
+			}
+		})
+	}
+}
+
+func TestOwnLoad_Sample(t *testing.T) {
+	// arrange
+	var o ownLoad
+	start := time.Now()
+
+	// act
+	first := o.sample(time.Minute, start)
+	busy := o.sample(time.Minute+120*time.Second, start.Add(time.Minute)) // 2 threads for a minute
+	for i := 1; i <= 10; i++ {
+		o.sample(time.Minute+120*time.Second+time.Duration(i)*2*time.Minute, start.Add(time.Duration(i+1)*time.Minute))
+	}
+	steady := o.threads
+	restarted := o.sample(time.Second, start.Add(12*time.Minute)) // a new engine, 1s in a minute
+
+	// assert
+	if first != 0 {
+		t.Errorf("first sample: got %.2f, want 0", first)
+	}
+	if busy < 1.2 || busy > 1.3 {
+		t.Errorf("after a busy minute: got %.2f, want 2*(1-1/e) = 1.26", busy)
+	}
+	if steady < 1.99 || steady > 2 {
+		t.Errorf("steady: got %.2f, want 2", steady)
+	}
+	if restarted > steady {
+		t.Errorf("after a restart: got %.2f, want less than %.2f", restarted, steady)
+	}
+}
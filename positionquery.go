@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"trollfish-lichess/epd"
+	"trollfish-lichess/fen"
+	"trollfish-lichess/query"
+	"trollfish-lichess/yamlbook"
+)
+
+// QueryPositions writes the positions of the comma separated files (YAML books, EPD or PGN,
+// by extension) matching text, see package query, to w as EPD. Evals are written in the epd
+// package's convention, white's pov. It returns the number of matches.
+func QueryPositions(files, text string, w io.Writer) (int, error) {
+	q, err := query.Parse(text)
+	if err != nil {
+		return 0, err
+	}
+
+	out := epd.New()
+	seen := make(map[string]bool)
+	emit := func(p query.Position, bestMove string) {
+		fenKey := p.Board.FENKey()
+		if seen[fenKey] || !q.Match(p) {
+			return
+		}
+		seen[fenKey] = true
+		out.Add(fenKey, positionOps(p, bestMove)...)
+	}
+
+	for _, filename := range strings.Split(files, ",") {
+		if filename = strings.TrimSpace(filename); filename == "" {
+			continue
+		}
+		if err := readQueryPositions(filename, emit); err != nil {
+			return 0, err
+		}
+	}
+
+	if _, err := io.WriteString(w, out.String()); err != nil {
+		return 0, err
+	}
+	return len(out.Lines), nil
+}
+
+func readQueryPositions(filename string, emit func(query.Position, string)) error {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yamlbook", ".yaml", ".yml":
+		book, err := yamlbook.Load(filename)
+		if err != nil {
+			return err
+		}
+		for _, pos := range book.Positions {
+			p, bestMove := bookQueryPosition(pos)
+			emit(p, bestMove)
+		}
+	case ".epd":
+		file, err := epd.LoadFile(filename)
+		if err != nil {
+			return err
+		}
+		for _, line := range file.Lines {
+			if line.FEN == "" {
+				continue
+			}
+			board := fen.FENtoBoard(line.FEN)
+			score := fen.Global(line.CE(), line.DM()).For(board.ActiveColor)
+			p := query.Position{
+				Board:   board,
+				HasEval: line.GetString(epd.OpCodeCentipawnEvaluation) != "" || line.GetString(epd.OpCodeDirectMate) != "",
+				CP:      score.CP,
+				Mate:    score.Mate,
+				Depth:   line.ACD(),
+			}
+			emit(p, line.BestMove())
+		}
+	case ".pgn":
+		db, err := fen.LoadPGNDatabase(filename)
+		if err != nil {
+			return err
+		}
+		for _, game := range db.Games {
+			board := fen.FENtoBoard(game.SetupFEN)
+			var prev *fen.PGNMove
+			for i := 0; i <= len(game.Moves); i++ {
+				p := query.Position{Board: board}
+				if prev != nil && prev.HasEval {
+					// the eval after the previous move is white's
+					score := fen.Global(prev.CP, prev.Mate).For(board.ActiveColor)
+					p.HasEval, p.CP, p.Mate = true, score.CP, score.Mate
+				}
+				emit(p, "")
+				if i < len(game.Moves) {
+					prev = &game.Moves[i]
+					board.Moves(prev.UCI)
+				}
+			}
+		}
+	default:
+		return fmt.Errorf("'%s': want a .yamlbook, .epd or .pgn file", filename)
+	}
+	return nil
+}
+
+// bookQueryPosition returns a book position's eval, its best move's, and the best move.
+func bookQueryPosition(pos *yamlbook.Position) (query.Position, string) {
+	p := query.Position{Board: fen.FENtoBoard(pos.FEN)}
+
+	var best *yamlbook.Move
+	for _, move := range pos.Moves {
+		if move.Move == "" {
+			continue
+		}
+		p.BookMoves++
+		if best == nil || move.Score(p.Board.ActiveColor).Order() > best.Score(p.Board.ActiveColor).Order() {
+			best = move
+		}
+	}
+	if best == nil {
+		return p, ""
+	}
+
+	p.HasEval, p.CP, p.Mate = true, best.CP, best.Mate
+	p.Depth = best.GetLastLogLineFor(best.Move).Depth
+	return p, best.Move
+}
+
+func positionOps(p query.Position, bestMove string) []epd.Operation {
+	var ops []epd.Operation
+	if bestMove != "" {
+		ops = append(ops, epd.Operation{OpCode: epd.OpCodeBestMove, Value: bestMove})
+	}
+	if !p.HasEval {
+		return ops
+	}
+
+	score := fen.POV(p.CP, p.Mate, p.Board.ActiveColor).Global()
+	if score.Mate != 0 {
+		ops = append(ops, epd.Operation{OpCode: epd.OpCodeDirectMate, Value: strconv.Itoa(score.Mate)})
+	} else {
+		ops = append(ops, epd.Operation{OpCode: epd.OpCodeCentipawnEvaluation, Value: strconv.Itoa(score.CP)})
+	}
+	if p.Depth > 0 {
+		ops = append(ops, epd.Operation{OpCode: epd.OpCodeAnalysisCountDepth, Value: strconv.Itoa(p.Depth)})
+	}
+	return ops
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"trollfish-lichess/fen"
+)
+
+func TestQueryPositions(t *testing.T) {
+	// arrange
+	dir := t.TempDir()
+	afterE4 := fen.FENtoBoard(startPosFEN)
+	afterE4.Moves("e2e4")
+
+	bookFile := filepath.Join(dir, "book.yamlbook")
+	book := `- fen: ` + fen.Key(startPosFEN) + `
+  moves:
+    - move: e4
+      cp: 30
+    - move: d4
+      cp: 25
+- fen: ` + afterE4.FENKey() + `
+  moves:
+    - move: c5
+      cp: -35
+`
+	if err := os.WriteFile(bookFile, []byte(book), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pgnFile := filepath.Join(dir, "games.pgn")
+	pgn := `[Event "Rated blitz game"]
+[White "a"]
+[Black "b"]
+[Result "*"]
+
+1. e4 { [%eval 0.3] } 1... c5 { [%eval 0.4] } *
+`
+	if err := os.WriteFile(pgnFile, []byte(pgn), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	var sb strings.Builder
+	count, err := QueryPositions(bookFile+","+pgnFile, "eval <= -30 and tomove = black", &sb)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("count: got %d, want 1", count)
+	}
+	want := afterE4.FENKey() + ` bm c5; ce 35;`
+	if got := strings.TrimSpace(sb.String()); got != want {
+		t.Errorf("got '%s', want '%s'", got, want)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"trollfish-lichess/fen"
+)
+
+// premove is our reply prepared for the opponent's predicted move. The bot API has no
+// premoves, so when our reply there is forced it's sent as soon as the game state with
+// their move arrives, without the book lookup, the engine or the send delay.
+type premove struct {
+	predicted string // the opponent's move, UCI
+	fenKey    string // the position after it
+	reply     string // UCI
+	reason    string
+}
+
+// preparePremove returns the premove for the opponent's predicted move in board, nil if our
+// reply to it isn't forced: the only legal move. The book's only move isn't, it would skip
+// the book's filters, repetition check and checkBookMove.
+func preparePremove(board fen.Board, predicted string) *premove {
+	if !board.IsLegal(predicted) {
+		return nil
+	}
+	board.Moves(predicted)
+
+	legal := board.AllLegalMoves()
+	if len(legal) != 1 {
+		return nil
+	}
+	return &premove{predicted: predicted, fenKey: board.FENKey(), reply: legal[0].UCI, reason: "only legal move"}
+}
+
+// takePremove returns the prepared premove if the opponent played its predicted move, which
+// led to board, and its reply is still legal there, nil if not. It's only used once.
+func (g *Game) takePremove(board fen.Board, opponentMoveUCI string) *premove {
+	pm := g.premove
+	g.premove = nil
+	if pm == nil || pm.predicted != opponentMoveUCI || pm.fenKey != board.FENKey() || !board.IsLegal(pm.reply) {
+		return nil
+	}
+	return pm
+}
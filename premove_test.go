@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	"trollfish-lichess/fen"
+)
+
+func TestPreparePremove(t *testing.T) {
+	// arrange
+	afterE4 := fen.FENtoBoard(startPosFEN)
+	afterE4.Moves("e2e4")
+
+	// white's Ra7 leaves black only Kg8
+	boxed := fen.FENtoBoard("7k/8/8/8/8/8/8/R6K w - - 0 1")
+
+	cases := []struct {
+		name      string
+		board     fen.Board
+		predicted string
+		want      string
+	}{
+		{name: "only legal move", board: boxed, predicted: "a1a7", want: "h8g8"},
+		{name: "book move isn't forced", board: afterE4, predicted: "e7e5", want: ""},
+		{name: "not forced", board: afterE4, predicted: "c7c5", want: ""},
+		{name: "illegal prediction", board: afterE4, predicted: "e2e4", want: ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// act
+			pm := preparePremove(c.board, c.predicted)
+
+			// assert
+			var got string
+			if pm != nil {
+				got = pm.reply
+			}
+			if got != c.want {
+				t.Errorf("got '%s', want '%s'", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// startProfile writes a CPU profile to <prefix>.cpu.pprof until the returned stop, which
+// also writes a heap profile to <prefix>.heap.pprof. Read them with 'go tool pprof'.
+func startProfile(prefix string) (func() error, error) {
+	cpuFilename, heapFilename := prefix+".cpu.pprof", prefix+".heap.pprof"
+
+	cpu, err := os.Create(cpuFilename)
+	if err != nil {
+		return nil, fmt.Errorf("'%s': %v", cpuFilename, err)
+	}
+	if err := pprof.StartCPUProfile(cpu); err != nil {
+		_ = cpu.Close()
+		return nil, fmt.Errorf("'%s': %v", cpuFilename, err)
+	}
+
+	stop := func() error {
+		pprof.StopCPUProfile()
+		if err := cpu.Close(); err != nil {
+			return fmt.Errorf("'%s': %v", cpuFilename, err)
+		}
+
+		heap, err := os.Create(heapFilename)
+		if err != nil {
+			return fmt.Errorf("'%s': %v", heapFilename, err)
+		}
+		defer heap.Close()
+
+		runtime.GC() // up to date allocation stats
+		if err := pprof.WriteHeapProfile(heap); err != nil {
+			return fmt.Errorf("'%s': %v", heapFilename, err)
+		}
+		fmt.Printf("profiles written to '%s' and '%s'\n", cpuFilename, heapFilename)
+		return nil
+	}
+	return stop, nil
+}
@@ -0,0 +1,163 @@
+package progress
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Quiet disables progress output for every Progress created after it's set.
+var Quiet bool
+
+// window is the number of recent items used to estimate the time remaining.
+const window = 10
+
+// Progress reports how far a batch command is through its items: done/total, the engine's
+// current depth and an ETA based on a rolling average of recent item times. A nil *Progress
+// is valid and reports nothing.
+type Progress struct {
+	mtx sync.Mutex
+
+	name      string
+	total     int
+	done      int
+	depth     int
+	itemStart time.Time
+	recent    []time.Duration
+}
+
+// New returns a Progress for total items, or nil if Quiet is set.
+func New(name string, total int) *Progress {
+	if Quiet {
+		return nil
+	}
+
+	return &Progress{
+		name:      name,
+		total:     total,
+		itemStart: time.Now(),
+	}
+}
+
+// Start marks the beginning of the next item.
+func (p *Progress) Start() {
+	if p == nil {
+		return
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.itemStart = time.Now()
+	p.depth = 0
+}
+
+// ResetDepth clears the depth when the engine starts on a new position within the same item.
+func (p *Progress) ResetDepth() {
+	if p == nil {
+		return
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.depth = 0
+}
+
+// Depth records the engine's depth on the current item. The progress line is printed each
+// time the depth increases.
+func (p *Progress) Depth(depth int) {
+	if p == nil {
+		return
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if depth <= p.depth {
+		return
+	}
+	p.depth = depth
+
+	fmt.Printf("%s %s\n", ts(), p.line())
+}
+
+// Done marks the current item complete and prints the progress line.
+func (p *Progress) Done() {
+	if p == nil {
+		return
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.done++
+	p.recent = append(p.recent, time.Since(p.itemStart))
+	if len(p.recent) > window {
+		p.recent = p.recent[len(p.recent)-window:]
+	}
+	p.itemStart = time.Now()
+	p.depth = 0
+
+	fmt.Printf("%s %s\n", ts(), p.line())
+}
+
+func (p *Progress) String() string {
+	if p == nil {
+		return ""
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	return p.line()
+}
+
+func (p *Progress) line() string {
+	const barWidth = 20
+
+	var pct float64
+	if p.total > 0 {
+		pct = float64(p.done) / float64(p.total)
+	}
+	filled := int(pct * barWidth)
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("%s [%s%s] %d/%d %5.1f%%", p.name, strings.Repeat("#", filled), strings.Repeat("-", barWidth-filled), p.done, p.total, pct*100))
+
+	if p.depth > 0 {
+		sb.WriteString(fmt.Sprintf(" depth %d", p.depth))
+	}
+
+	if eta, ok := p.eta(); ok {
+		sb.WriteString(fmt.Sprintf(" ETA %v (%s)", eta.Round(time.Second), time.Now().Add(eta).Format("15:04")))
+	}
+
+	return sb.String()
+}
+
+// eta estimates the time remaining from the average of recent items, less the time already
+// spent on the current item.
+func (p *Progress) eta() (time.Duration, bool) {
+	if len(p.recent) == 0 || p.done >= p.total {
+		return 0, false
+	}
+
+	var sum time.Duration
+	for _, d := range p.recent {
+		sum += d
+	}
+	avg := sum / time.Duration(len(p.recent))
+
+	eta := avg*time.Duration(p.total-p.done) - time.Since(p.itemStart)
+	if eta < 0 {
+		eta = 0
+	}
+
+	return eta, true
+}
+
+func ts() string {
+	return fmt.Sprintf("[%s]", time.Now().Format("2006-01-02 15:04:05.000"))
+}
@@ -0,0 +1,42 @@
+package progress
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgress_ETA(t *testing.T) {
+	// arrange
+	p := &Progress{name: "positions", total: 10, done: 4, itemStart: time.Now()}
+	p.recent = []time.Duration{time.Minute, 3 * time.Minute}
+
+	// act
+	eta, ok := p.eta()
+
+	// assert
+	if !ok {
+		t.Fatal("want eta")
+	}
+	if eta < 11*time.Minute+59*time.Second || eta > 12*time.Minute {
+		t.Errorf("want ~12m, got %v", eta)
+	}
+	if line := p.line(); !strings.Contains(line, "4/10") || !strings.Contains(line, "ETA") {
+		t.Errorf("line: %s", line)
+	}
+}
+
+func TestProgress_Nil(t *testing.T) {
+	Quiet = true
+	defer func() { Quiet = false }()
+
+	p := New("games", 3)
+	if p != nil {
+		t.Fatal("want nil when Quiet")
+	}
+
+	// nil is valid
+	p.Start()
+	p.Depth(20)
+	p.Done()
+}
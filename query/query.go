@@ -0,0 +1,223 @@
+// Package query filters positions with a small predicate language, e.g.
+//
+//	eval < -100 and tomove = black and castling ~ k
+//
+// A query is clauses joined by 'and', each a field, an operator (=, !=, <, <=, >, >=, or ~
+// for contains) and a value. The fields are:
+//
+//	eval      cp from the side to move's pov, mates count as ±100000 less the moves to mate
+//	mate      moves to mate from the side to move's pov, 0 for none
+//	depth     of the eval
+//	moves     book moves of the position
+//	tomove    white or black (w, b)
+//	castling  the FEN's castling field, e.g. KQkq or -
+//	material  the syzygy table name, strongest side first, e.g. KRPvKR
+//	pieces    pieces on the board, kings included
+//	balance   material from the side to move's pov in pawns (1, 3, 3, 5, 9)
+//	K..p      how many of a piece there are, e.g. Q = 0
+//	a1..h8    the piece on a square, . for empty, e.g. e4 = P
+//
+// Positions without an eval don't match clauses on eval, mate or depth.
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"trollfish-lichess/fen"
+)
+
+// Position is a position from a book, EPD or PGN database, with its eval if it has one.
+type Position struct {
+	Board     fen.Board
+	HasEval   bool
+	CP        int // the side to move's pov
+	Mate      int
+	Depth     int
+	BookMoves int
+}
+
+// Query is a parsed query, see the package doc.
+type Query struct {
+	clauses []clause
+}
+
+type clause struct {
+	field string
+	op    string
+	value string
+	num   int // value, for numeric fields
+}
+
+var (
+	andRegex    = regexp.MustCompile(`(?i)\s+and\s+`)
+	clauseRegex = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9]*)\s*(<=|>=|!=|=|<|>|~)\s*(\S+)$`)
+	squareRegex = regexp.MustCompile(`^[a-h][1-8]$`)
+)
+
+const pieceLetters = "KQRBNPkqrbnp"
+
+var numericFields = map[string]bool{"eval": true, "mate": true, "depth": true, "moves": true, "pieces": true, "balance": true}
+
+// Parse reads a query, see the package doc.
+func Parse(text string) (*Query, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, fmt.Errorf("empty query")
+	}
+
+	var q Query
+	for _, part := range andRegex.Split(text, -1) {
+		match := clauseRegex.FindStringSubmatch(strings.TrimSpace(part))
+		if match == nil {
+			return nil, fmt.Errorf("'%s': want field, operator and value, e.g. 'eval < -100'", part)
+		}
+
+		c := clause{field: match[1], op: match[2], value: match[3]}
+		if !isPiece(c.field) && !squareRegex.MatchString(c.field) {
+			c.field = strings.ToLower(c.field)
+		}
+		if err := c.check(); err != nil {
+			return nil, fmt.Errorf("'%s': %v", part, err)
+		}
+		q.clauses = append(q.clauses, c)
+	}
+	return &q, nil
+}
+
+func (c *clause) check() error {
+	switch {
+	case numericFields[c.field] || isPiece(c.field):
+		if c.op == "~" {
+			return fmt.Errorf("%s is a number, ~ is for text", c.field)
+		}
+		n, err := strconv.Atoi(c.value)
+		if err != nil {
+			return fmt.Errorf("%s: '%s' is not a number", c.field, c.value)
+		}
+		c.num = n
+	case c.field == "tomove":
+		switch strings.ToLower(c.value) {
+		case "white", "w":
+			c.value = "w"
+		case "black", "b":
+			c.value = "b"
+		default:
+			return fmt.Errorf("tomove: '%s' is not white or black", c.value)
+		}
+		fallthrough
+	case squareRegex.MatchString(c.field):
+		if c.op != "=" && c.op != "!=" {
+			return fmt.Errorf("%s: want = or !=", c.field)
+		}
+		if squareRegex.MatchString(c.field) && c.value != "." && !isPiece(c.value) {
+			return fmt.Errorf("%s: '%s' is not a piece (%s) or . for empty", c.field, c.value, pieceLetters)
+		}
+	case c.field == "castling", c.field == "material":
+		if c.op != "=" && c.op != "!=" && c.op != "~" {
+			return fmt.Errorf("%s: want =, != or ~", c.field)
+		}
+	default:
+		return fmt.Errorf("unknown field '%s'", c.field)
+	}
+	return nil
+}
+
+// Match reports whether p satisfies every clause of q.
+func (q *Query) Match(p Position) bool {
+	for _, c := range q.clauses {
+		if !c.match(p) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c clause) match(p Position) bool {
+	b := p.Board
+	switch {
+	case c.field == "eval":
+		return p.HasEval && compare(fen.POV(p.CP, p.Mate, b.ActiveColor).Order(), c.op, c.num)
+	case c.field == "mate":
+		return p.HasEval && compare(p.Mate, c.op, c.num)
+	case c.field == "depth":
+		return p.HasEval && compare(p.Depth, c.op, c.num)
+	case c.field == "moves":
+		return compare(p.BookMoves, c.op, c.num)
+	case c.field == "pieces":
+		return compare(b.PieceCount(), c.op, c.num)
+	case c.field == "balance":
+		return compare(balance(b), c.op, c.num)
+	case isPiece(c.field):
+		return compare(strings.Count(string(b.Pos[:]), c.field), c.op, c.num)
+	case c.field == "tomove":
+		return (b.ActiveColor.String() == c.value) == (c.op == "=")
+	case squareRegex.MatchString(c.field):
+		piece := b.Pos[(7-int(c.field[1]-'1'))*8+int(c.field[0]-'a')]
+		want := c.value[0]
+		if want == '.' {
+			want = ' '
+		}
+		return (piece == want) == (c.op == "=")
+	case c.field == "castling":
+		return compareText(strings.Fields(b.FENKey())[2], c.op, c.value, false)
+	case c.field == "material":
+		return compareText(b.SyzygyTableName(), c.op, c.value, true)
+	}
+	return false
+}
+
+func compare(a int, op string, b int) bool {
+	switch op {
+	case "=":
+		return a == b
+	case "!=":
+		return a != b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	}
+	return false
+}
+
+func compareText(a, op, b string, foldCase bool) bool {
+	if foldCase {
+		a, b = strings.ToUpper(a), strings.ToUpper(b)
+	}
+	switch op {
+	case "=":
+		return a == b
+	case "!=":
+		return a != b
+	case "~":
+		return strings.Contains(a, b)
+	}
+	return false
+}
+
+var pieceValues = map[byte]int{'Q': 9, 'R': 5, 'B': 3, 'N': 3, 'P': 1, 'q': -9, 'r': -5, 'b': -3, 'n': -3, 'p': -1}
+
+// balance is the material from the side to move's pov, in pawns.
+func balance(b fen.Board) int {
+	return Balance(b, b.ActiveColor)
+}
+
+// Balance is the material from color's pov, in pawns.
+func Balance(b fen.Board, color fen.Color) int {
+	var total int
+	for _, piece := range b.Pos {
+		total += pieceValues[piece]
+	}
+	return total * int(color)
+}
+
+func isPiece(s string) bool {
+	return len(s) == 1 && strings.Contains(pieceLetters, s)
+}
@@ -0,0 +1,86 @@
+package query
+
+import (
+	"testing"
+
+	"trollfish-lichess/fen"
+)
+
+func TestQuery_Match(t *testing.T) {
+	// arrange
+	const (
+		afterE4 = "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq -"
+		krpkr   = "8/8/4k3/8/3PK3/8/r7/7R w - -"
+	)
+	e4 := Position{Board: fen.FENtoBoard(afterE4), HasEval: true, CP: -30, Depth: 30, BookMoves: 4}
+	endgame := Position{Board: fen.FENtoBoard(krpkr), HasEval: true, Mate: 12}
+	noEval := Position{Board: fen.FENtoBoard(afterE4)}
+
+	cases := []struct {
+		query string
+		pos   Position
+		want  bool
+	}{
+		{"eval < -20 and tomove = black", e4, true},
+		{"eval < -20 AND tomove = white", e4, false},
+		{"eval<-20", noEval, false},
+		{"eval > 1000", endgame, true},
+		{"mate > 0 and depth = 0", endgame, true},
+		{"depth >= 30 and moves = 4", e4, true},
+		{"castling = KQkq", e4, true},
+		{"castling ~ k", endgame, false},
+		{"castling = -", endgame, true},
+		{"material = KRPvKR", endgame, true},
+		{"material = krpvkr", endgame, true},
+		{"material ~ KQ", endgame, false},
+		{"pieces = 5 and balance = 1", endgame, true},
+		{"balance = 0", e4, true},
+		{"P = 8 and p = 8 and Q = 1", e4, true},
+		{"e4 = P and e2 = .", e4, true},
+		{"e4 != P", e4, false},
+		{"tomove = w and d4 = P", endgame, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			q, err := Parse(c.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// act
+			got := q.Match(c.pos)
+
+			// assert
+			if got != c.want {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	cases := []string{
+		"",
+		"eval",
+		"eval < high",
+		"colour = white",
+		"tomove = red",
+		"tomove < white",
+		"e4 = X",
+		"eval ~ 10",
+		"castling > K",
+	}
+
+	for _, text := range cases {
+		t.Run(text, func(t *testing.T) {
+			// act
+			_, err := Parse(text)
+
+			// assert
+			if err == nil {
+				t.Errorf("got nil, want an error")
+			}
+		})
+	}
+}
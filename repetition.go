@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"trollfish-lichess/api"
+	"trollfish-lichess/fen"
+)
+
+const (
+	repetitionTolerance = 25  // centipawns we'll give up to avoid repeating a position
+	repetitionLosingCP  = -75 // at or below this eval (our pov) we're happy to repeat
+)
+
+// repetitions holds every position seen in the game so far (as FEN keys), and the
+// current position, to detect moves that would repeat a position (twofold).
+type repetitions struct {
+	board fen.Board
+	seen  map[string]int
+}
+
+func newRepetitions(initialFEN string, moves []string) repetitions {
+	board := fen.FENtoBoard(initialFEN)
+	seen := map[string]int{board.FENKey(): 1}
+	for _, move := range moves {
+		board.Moves(move)
+		seen[board.FENKey()]++
+	}
+
+	return repetitions{board: board, seen: seen}
+}
+
+func (r repetitions) Repeats(moveUCI string) bool {
+	b := r.board
+	b.Moves(moveUCI)
+	return r.seen[b.FENKey()] > 0
+}
+
+// NonRepeating returns the legal moves which reach a position not seen before in this game.
+func (r repetitions) NonRepeating() []string {
+	var moves []string
+	for _, move := range r.board.AllLegalMoves() {
+		if !r.Repeats(move.UCI) {
+			moves = append(moves, move.UCI)
+		}
+	}
+	return moves
+}
+
+// avoidRepetition checks if bestMove repeats a position. If it does and we're not losing, the
+// engine is asked for its best non-repeating move, which is played if the eval is within
+// repetitionTolerance of bestMove.
+func (g *Game) avoidRepetition(ctx context.Context, reps repetitions, state api.State, bestMove string, ourTime time.Duration) string {
+	if bestMove == "" || !reps.Repeats(bestMove) {
+		return bestMove
+	}
+
+	bestMoveSAN := reps.board.UCItoSAN(bestMove)
+	bestScore := evalToScore(g.humanEval, g.playerColor)
+	if bestScore <= repetitionLosingCP {
+		fmt.Printf("%s %s repeats a position, allowing it (eval %s)\n", ts(), bestMoveSAN, g.humanEval)
+		return bestMove
+	}
+
+	alternatives := reps.NonRepeating()
+	if len(alternatives) == 0 {
+		fmt.Printf("%s %s repeats a position, no alternatives\n", ts(), bestMoveSAN)
+		return bestMove
+	}
+
+	fmt.Printf("%s %s repeats a position (eval %s), checking %d alternative(s)...\n", ts(), bestMoveSAN, g.humanEval, len(alternatives))
+
+	prevPonder, prevEval := g.ponder, g.humanEval
+	g.stopPondering()
+	g.ponder = ""
+
+	moveTime := ourTime / 50
+	if moveTime > 2*time.Second {
+		moveTime = 2 * time.Second
+	} else if moveTime < 100*time.Millisecond {
+		moveTime = 100 * time.Millisecond
+	}
+
+	search, err := g.engine.Go(g.positionCommand(state.Moves), fmt.Sprintf("go movetime %d searchmoves %s", moveTime.Milliseconds(), strings.Join(alternatives, " ")))
+	if err != nil {
+		fmt.Printf("%s *** ERR: go: %v\n", ts(), err)
+		return bestMove
+	}
+
+	alt, err := search.Wait(ctx, moveTime+engineMoveGrace)
+	if err != nil {
+		fmt.Printf("%s *** ERR: waiting for bestmove: %v\n", ts(), err)
+		return bestMove
+	}
+	if ctx.Err() != nil || alt.Move == "" {
+		return bestMove
+	}
+	g.moveAudit.AddSearch(search, alt)
+	altMove, altPonder, altEval := alt.Move, alt.Ponder, alt.Eval
+
+	altScore := evalToScore(altEval, g.playerColor)
+	altMoveSAN := reps.board.UCItoSAN(altMove)
+
+	if altScore >= bestScore-repetitionTolerance {
+		fmt.Printf("%s avoiding repetition: %s (eval %s) instead of %s (eval %s)\n", ts(), altMoveSAN, altEval, bestMoveSAN, prevEval)
+		g.moveAudit.Note("avoided repetition: %s (eval %s) instead of %s (eval %s)", altMoveSAN, altEval, bestMoveSAN, prevEval)
+		g.humanEval = altEval
+		g.searched = &alt
+		if altPonder != "" {
+			g.ponderMove(altPonder, state, altMove)
+		} else {
+			g.prediction = "" // made for bestMove
+		}
+		return altMove
+	}
+
+	fmt.Printf("%s best alternative %s (eval %s) is too much worse, repeating with %s\n", ts(), altMoveSAN, altEval, bestMoveSAN)
+	g.humanEval = prevEval
+	if prevPonder != "" {
+		g.totalPonders-- // already counted when the first search finished
+		g.ponderMove(prevPonder, state, bestMove)
+	}
+	return bestMove
+}
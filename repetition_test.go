@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"trollfish-lichess/api"
+	"trollfish-lichess/fen"
+	"trollfish-lichess/storage"
+	"trollfish-lichess/yamlbook"
+)
+
+// knightsBack returns to the start position with white to move, which the game has seen twice.
+const knightsBack = "g1f3 g8f6 f3g1 f6g8"
+
+func TestRepetitions_Repeats(t *testing.T) {
+	cases := []struct {
+		name  string
+		moves string
+		move  string
+		want  bool
+	}{
+		{name: "new position", moves: "g1f3 g8f6", move: "e2e4", want: false},
+		{name: "knight back once", moves: "g1f3 g8f6", move: "f3g1", want: false},
+		{name: "back to the start", moves: "g1f3 g8f6 f3g1", move: "f6g8", want: true},
+		{name: "twofold after both knights went back", moves: knightsBack, move: "g1f3", want: true},
+		{name: "other knight", moves: knightsBack, move: "b1c3", want: false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			reps := newRepetitions(startPosFEN, strings.Fields(c.moves))
+
+			// act
+			got := reps.Repeats(c.move)
+
+			// assert
+			if got != c.want {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestRepetitions_NonRepeating(t *testing.T) {
+	// arrange
+	reps := newRepetitions(startPosFEN, strings.Fields(knightsBack))
+
+	// act
+	got := reps.NonRepeating()
+
+	// assert
+	if len(got) != 19 {
+		t.Errorf("got %d moves, want the 20 legal moves but g1f3", len(got))
+	}
+	for _, move := range got {
+		if move == "g1f3" {
+			t.Errorf("got %v, want g1f3 left out", got)
+		}
+	}
+}
+
+func TestGame_AvoidRepetition(t *testing.T) {
+	position := "position startpos moves " + knightsBack
+	alternatives := " searchmoves " + strings.Join(newRepetitions(startPosFEN, strings.Fields(knightsBack)).NonRepeating(), " ")
+
+	cases := []struct {
+		name           string
+		eval           string
+		bestMove       string
+		alternative    string // the engine's reply to the search of the non-repeating moves
+		want           string
+		wantEval       string
+		wantPonder     string
+		wantPrediction string
+	}{
+		{name: "doesn't repeat", eval: "0.30", bestMove: "e2e4", want: "e2e4", wantEval: "0.30", wantPrediction: "e7e5"},
+		{name: "losing", eval: "-0.75", bestMove: "g1f3", want: "g1f3", wantEval: "-0.75", wantPrediction: "e7e5"},
+		{name: "alternative within tolerance", eval: "0.30", bestMove: "g1f3", alternative: "bestmove b1c3 eval 0.05", want: "b1c3", wantEval: "0.05"},
+		{name: "alternative ponders", eval: "0.30", bestMove: "g1f3", alternative: "bestmove e2e4 ponder e7e5 eval 0.20", want: "e2e4", wantEval: "0.20", wantPonder: "e7e5"},
+		{name: "alternative too much worse", eval: "0.30", bestMove: "g1f3", alternative: "bestmove f2f3 eval -0.50", want: "g1f3", wantEval: "0.30", wantPrediction: "e7e5"},
+		{name: "mate beats any alternative", eval: "M3", bestMove: "g1f3", alternative: "bestmove e2e4 eval 9.00", want: "g1f3", wantEval: "M3", wantPrediction: "e7e5"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			engine := fakeEngine(ctx, map[string]string{position + alternatives: c.alternative})
+			g := NewGame(ctx, "test", storage.NewMemory(), engine, &yamlbook.Book{}, nil, GameOptions{}, nil)
+			defer g.Finish()
+			g.initialFEN = "startpos"
+			g.playerColor = fen.WhitePieces
+			g.humanEval = c.eval
+			g.prediction = "e7e5" // made with bestMove, not pondered
+
+			reps := newRepetitions(startPosFEN, strings.Fields(knightsBack))
+			state := api.State{Moves: knightsBack, WhiteTime: 60000, BlackTime: 60000, MessageReceived: time.Now()}
+
+			// act
+			got := g.avoidRepetition(ctx, reps, state, c.bestMove, time.Minute)
+
+			// assert
+			if got != c.want {
+				t.Errorf("move: got %s, want %s", got, c.want)
+			}
+			if g.humanEval != c.wantEval {
+				t.Errorf("eval: got %s, want %s", g.humanEval, c.wantEval)
+			}
+			if g.ponder != c.wantPonder {
+				t.Errorf("ponder: got %q, want %q", g.ponder, c.wantPonder)
+			}
+			if g.prediction != c.wantPrediction {
+				t.Errorf("prediction: got %q, want %q", g.prediction, c.wantPrediction)
+			}
+		})
+	}
+}
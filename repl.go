@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"trollfish-lichess/analyze"
+	"trollfish-lichess/api"
+	"trollfish-lichess/epd"
+	"trollfish-lichess/fen"
+	"trollfish-lichess/yamlbook"
+)
+
+// replDefaultDepth is the eval depth when none is given.
+const replDefaultDepth = 24
+
+const replHelp = `commands:
+  fen <FEN> | startpos        set the position
+  pgn <file> [game]           load a game, numbered from 1 (default 1)
+  move <SAN or UCI>           play a move, replacing the rest of the line
+  next, prev, goto <ply>      step through the line, ply 0 is the start
+  show                        the position and the line
+  eval [depth] [multipv]      ask the engine (default depth %d, multipv 1)
+  book                        the book's moves (see -repl-book)
+  explorer [masters|lichess]  the opening explorer's moves
+  cloud                       lichess' cloud eval
+  save epd <file> [comment]   append the position with the last eval
+  save book                   add the last eval to the book
+  quit
+`
+
+// REPL is an interactive front-end over the analyzer, book and explorer.
+type REPL struct {
+	out  io.Writer
+	book *yamlbook.Book
+
+	startFEN string
+	line     []string // UCI moves from startFEN
+	ply      int      // moves of line played
+
+	a        *analyze.Analyzer
+	lastEval []analyze.Eval
+	lastFEN  string // the position of lastEval
+	explorer *api.Explorer
+}
+
+func NewREPL(out io.Writer, book *yamlbook.Book) *REPL {
+	explorer := api.NewExplorer()
+	explorer.Cache = true
+
+	return &REPL{
+		out:      out,
+		book:     book,
+		startFEN: startPosFEN,
+		explorer: explorer,
+	}
+}
+
+// Run reads commands from in until it's closed, "quit" or ctx is done.
+func (r *REPL) Run(ctx context.Context, in io.Reader) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	scanner := bufio.NewScanner(in)
+	r.prompt()
+	for scanner.Scan() {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "quit" || line == "exit" {
+			return nil
+		}
+		if line != "" {
+			if err := r.Exec(ctx, line); err != nil {
+				fmt.Fprintf(r.out, "ERR: %v\n", err)
+			}
+		}
+		r.prompt()
+	}
+
+	return scanner.Err()
+}
+
+func (r *REPL) prompt() {
+	fmt.Fprintf(r.out, "%s> ", r.moveLabel(r.ply))
+}
+
+// Exec runs one command.
+func (r *REPL) Exec(ctx context.Context, line string) error {
+	cmd, args, _ := strings.Cut(line, " ")
+	args = strings.TrimSpace(args)
+	fields := strings.Fields(args)
+
+	switch cmd {
+	case "help", "?":
+		fmt.Fprintf(r.out, replHelp, replDefaultDepth)
+	case "startpos":
+		r.setPosition(startPosFEN, nil)
+	case "fen":
+		if err := fen.ValidFEN(args); err != nil {
+			return err
+		}
+		r.setPosition(args, nil)
+	case "pgn":
+		return r.loadPGN(fields)
+	case "move", "m":
+		return r.move(args)
+	case "next", "n":
+		return r.gotoPly(r.ply + 1)
+	case "prev", "p":
+		return r.gotoPly(r.ply - 1)
+	case "goto":
+		ply, err := strconv.Atoi(args)
+		if err != nil {
+			return fmt.Errorf("goto '%s': want a ply number", args)
+		}
+		return r.gotoPly(ply)
+	case "show":
+		r.show()
+	case "eval":
+		return r.eval(ctx, fields)
+	case "book":
+		r.showBook()
+	case "explorer":
+		return r.showExplorer(ctx, fields)
+	case "cloud":
+		return r.showCloudEval(ctx)
+	case "save":
+		return r.save(fields, args)
+	default:
+		return fmt.Errorf("unknown command '%s', try help", cmd)
+	}
+
+	return nil
+}
+
+// Board returns the current position.
+func (r *REPL) Board() fen.Board {
+	board := fen.FENtoBoard(r.startFEN)
+	board.Moves(r.line[:r.ply]...)
+	return board
+}
+
+func (r *REPL) setPosition(startFEN string, line []string) {
+	r.startFEN = startFEN
+	r.line = line
+	r.ply = 0
+	r.show()
+}
+
+func (r *REPL) loadPGN(fields []string) error {
+	if len(fields) == 0 {
+		return fmt.Errorf("pgn needs a file")
+	}
+
+	index := 1
+	if len(fields) > 1 {
+		var err error
+		if index, err = strconv.Atoi(fields[1]); err != nil || index < 1 {
+			return fmt.Errorf("pgn game '%s': want a number from 1", fields[1])
+		}
+	}
+
+	db, err := fen.LoadPGNDatabase(fields[0])
+	if err != nil {
+		return err
+	}
+	games := db.Select(fen.Query{Games: []fen.IndexRange{{From: index, To: index}}})
+	if len(games) == 0 {
+		return fmt.Errorf("'%s' has no game %d", fields[0], index)
+	}
+
+	game := games[0]
+	ucis := make([]string, 0, len(game.Moves))
+	for _, move := range game.Moves {
+		ucis = append(ucis, move.UCI)
+	}
+
+	fmt.Fprintf(r.out, "%s - %s %s, %d plies\n", game.White, game.Black, game.Result, len(ucis))
+	r.setPosition(iif(game.SetupFEN != "", game.SetupFEN, startPosFEN), ucis)
+	return nil
+}
+
+func (r *REPL) move(text string) error {
+	board := r.Board()
+	uci, err := parseMove(board, text)
+	if err != nil {
+		return err
+	}
+
+	if r.ply < len(r.line) && r.line[r.ply] == uci {
+		return r.gotoPly(r.ply + 1)
+	}
+
+	r.line = append(r.line[:r.ply:r.ply], uci)
+	r.ply++
+	r.show()
+	return nil
+}
+
+// parseMove returns the UCI move for text, a legal move in SAN or UCI.
+func parseMove(board fen.Board, text string) (string, error) {
+	text = strings.TrimSpace(text)
+	for _, move := range board.AllLegalMoves() {
+		if move.UCI == text {
+			return move.UCI, nil
+		}
+	}
+
+	uci, err := board.SANtoUCILoose(strings.TrimRight(text, "!?"))
+	if err != nil {
+		return "", fmt.Errorf("'%s' is not a legal move in '%s'", text, board.FEN())
+	}
+	return uci, nil
+}
+
+func (r *REPL) gotoPly(ply int) error {
+	if ply < 0 || ply > len(r.line) {
+		return fmt.Errorf("ply %d: the line has plies 0 to %d", ply, len(r.line))
+	}
+	r.ply = ply
+	r.show()
+	return nil
+}
+
+// moveLabel returns the move played to reach ply, e.g. "12...Nf6", or "start".
+func (r *REPL) moveLabel(ply int) string {
+	if ply == 0 {
+		return "start"
+	}
+	board := fen.FENtoBoard(r.startFEN)
+	board.Moves(r.line[:ply-1]...)
+	return fmt.Sprintf("%d.%s%s", board.FullMove, iif(board.ActiveColor == fen.WhitePieces, "", ".."), board.UCItoSAN(r.line[ply-1]))
+}
+
+func (r *REPL) show() {
+	board := fen.FENtoBoard(r.startFEN)
+	var sb strings.Builder
+	for i, uci := range r.line {
+		if i == r.ply {
+			sb.WriteString("| ")
+		}
+		if board.ActiveColor == fen.WhitePieces || i == 0 {
+			sb.WriteString(fmt.Sprintf("%d.%s", board.FullMove, iif(board.ActiveColor == fen.WhitePieces, "", "..")))
+		}
+		sb.WriteString(board.UCItoSAN(uci) + " ")
+		board.Moves(uci)
+	}
+	if r.ply == len(r.line) {
+		sb.WriteString("|")
+	}
+
+	fmt.Fprintf(r.out, "%s\n", r.Board().FEN())
+	if len(r.line) != 0 {
+		fmt.Fprintf(r.out, "%s\n", strings.TrimSpace(sb.String()))
+	}
+}
+
+func (r *REPL) eval(ctx context.Context, fields []string) error {
+	depth, multiPV := replDefaultDepth, 1
+	for i, dst := range []*int{&depth, &multiPV} {
+		if i >= len(fields) {
+			break
+		}
+		n, err := strconv.Atoi(fields[i])
+		if err != nil || n < 1 {
+			return fmt.Errorf("eval '%s': want depth and multipv numbers", strings.Join(fields, " "))
+		}
+		*dst = n
+	}
+
+	board := r.Board()
+	if board.IsMate() {
+		return fmt.Errorf("the game is over")
+	}
+
+	if r.a == nil {
+		r.a = analyze.New()
+		// started here so it stays up between evals, AnalyzePosition only quits engines it starts
+		if _, err := r.a.StartStockfish(ctx); err != nil {
+			r.a = nil
+			return err
+		}
+	}
+
+	opts := defaultAnalysisOptions
+	opts.MinDepth, opts.MaxDepth = depth, depth
+	opts.MinTime, opts.MinNodes = 0, 0
+	opts.MultiPV = multiPV
+
+	start := time.Now()
+	evals, err := r.a.AnalyzePosition(ctx, opts, board.FEN())
+	if err != nil {
+		return err
+	}
+
+	r.lastEval, r.lastFEN = evals, board.FEN()
+	for _, eval := range evals {
+		pv := board.UCItoSANs(eval.PV...)
+		fmt.Fprintf(r.out, "%7s depth %d %s\n", eval.String(board.ActiveColor), eval.Depth, strings.Join(pv, " "))
+	}
+	fmt.Fprintf(r.out, "%v\n", time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+func (r *REPL) showBook() {
+	if r.book == nil {
+		fmt.Fprintf(r.out, "no book, see -repl-book\n")
+		return
+	}
+
+	board := r.Board()
+	moves, ok := r.book.Get(board.FENKey())
+	if !ok || len(moves) == 0 {
+		fmt.Fprintf(r.out, "not in book\n")
+		return
+	}
+	for _, move := range moves {
+		fmt.Fprintf(r.out, "%-7s cp: %5d mate: %2d weight: %3d %s\n", move.Move, move.CP, move.Mate, move.Weight, strings.Join(move.Tags, ","))
+	}
+}
+
+func (r *REPL) showExplorer(ctx context.Context, fields []string) error {
+	db := api.Lichess
+	if len(fields) != 0 {
+		db = api.LookupDatabase(fields[0])
+		if db != api.Lichess && db != api.Masters {
+			return fmt.Errorf("explorer '%s': want masters or lichess", fields[0])
+		}
+	}
+
+	results, err := r.explorer.Lookup(ctx, db, r.Board().FEN(), api.LookupOptions{})
+	if err != nil {
+		return err
+	}
+
+	if results.Opening != nil {
+		fmt.Fprintf(r.out, "%s %s\n", results.Opening.ECO, results.Opening.Name)
+	}
+	for _, move := range results.Moves {
+		fmt.Fprintf(r.out, "%-7s %7d games %5.1f%% white %5.1f%% draws %5.1f%% black\n",
+			move.SAN, move.TotalGames, move.WhitePercent, move.DrawsPercent, move.BlackPercent)
+	}
+	if len(results.Moves) == 0 {
+		fmt.Fprintf(r.out, "no games\n")
+	}
+	return nil
+}
+
+func (r *REPL) showCloudEval(ctx context.Context) error {
+	board := r.Board()
+	results, err := r.explorer.CloudEval(ctx, board.FEN(), 3)
+	if err != nil {
+		return err
+	}
+
+	for _, pv := range results.PVs {
+		eval := iif(pv.Mate != 0, fmt.Sprintf("#%d", pv.Mate), fmt.Sprintf("%.2f", float64(pv.CP)/100))
+		sans := board.UCItoSANs(strings.Fields(pv.Moves)...)
+		fmt.Fprintf(r.out, "%7s depth %d %s\n", eval, results.Depth, strings.Join(sans, " "))
+	}
+	return nil
+}
+
+func (r *REPL) save(fields []string, args string) error {
+	if len(r.lastEval) == 0 || r.lastFEN != r.Board().FEN() {
+		return fmt.Errorf("save: eval the position first")
+	}
+
+	if len(fields) == 0 {
+		return fmt.Errorf("save needs epd or book")
+	}
+
+	switch fields[0] {
+	case "epd":
+		if len(fields) < 2 {
+			return fmt.Errorf("save epd needs a file")
+		}
+		_, comment, _ := strings.Cut(strings.TrimSpace(strings.TrimPrefix(args, "epd")), " ")
+		return r.saveEPD(fields[1], strings.TrimSpace(comment))
+	case "book":
+		if r.book == nil {
+			return fmt.Errorf("no book, see -repl-book")
+		}
+		if err := r.a.SaveEvalsToBook(r.book, r.lastFEN, r.lastEval); err != nil {
+			return err
+		}
+		fmt.Fprintf(r.out, "saved %d move(s) to the book\n", len(r.lastEval))
+		return nil
+	}
+
+	return fmt.Errorf("save '%s': want epd or book", fields[0])
+}
+
+func (r *REPL) saveEPD(filename, comment string) error {
+	board := r.Board()
+	best := r.lastEval[0]
+
+	ops := []epd.Operation{
+		{OpCode: epd.OpCodeBestMove, Value: board.UCItoSAN(best.UCIMove)},
+		{OpCode: epd.OpCodeAnalysisCountDepth, Value: strconv.Itoa(best.Depth)},
+	}
+	if best.Mate != 0 {
+		ops = append(ops, epd.Operation{OpCode: epd.OpCodeDirectMate, Value: strconv.Itoa(best.Mate)})
+	} else {
+		ops = append(ops, epd.Operation{OpCode: epd.OpCodeCentipawnEvaluation, Value: strconv.Itoa(best.CP)})
+	}
+	if comment != "" {
+		ops = append(ops, epd.Operation{OpCode: "c0", Value: strconv.Quote(comment)})
+	}
+
+	line := epd.New().Add(board.FEN(), ops...).String()
+
+	fp, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("'%s': %v", filename, err)
+	}
+	defer fp.Close()
+
+	if _, err := fp.WriteString(line + "\n"); err != nil {
+		return fmt.Errorf("write file '%s': %v", filename, err)
+	}
+	fmt.Fprintf(r.out, "%s\n", line)
+	return nil
+}
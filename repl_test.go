@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"trollfish-lichess/fen"
+)
+
+func TestREPL_Moves(t *testing.T) {
+	// arrange
+	var out bytes.Buffer
+	r := NewREPL(&out, nil)
+	in := strings.NewReader("move e4\nm e7e5\nNf3\nmove Nf3\nprev\nprev\nmove d5\ngoto 9\nshow\n")
+
+	// act
+	err := r.Run(context.Background(), in)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := r.Board().FEN(), "rnbqkbnr/ppp1pppp/8/3p4/4P3/8/PPPP1PPP/RNBQKBNR w KQkq - 0 2"; got != want {
+		t.Errorf("got '%s', want '%s'", got, want)
+	}
+	for _, want := range []string{"unknown command 'Nf3'", "ply 9: the line has plies 0 to 2", "1.e4 d5 |", "1...d5> "} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("missing '%s' in:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestParseMove(t *testing.T) {
+	cases := []struct {
+		text    string
+		fen     string
+		want    string
+		wantErr bool
+	}{
+		{text: "e2e4", want: "e2e4"},
+		{text: "e4", want: "e2e4"},
+		{text: " Nf3 ", want: "g1f3"},
+		{text: "e4!?", want: "e2e4"},
+		{text: "Nf6", wantErr: true},
+		{text: "Ke2", wantErr: true},
+		{text: "e2e5", wantErr: true},
+		{text: "x", wantErr: true},
+		{text: "Qxf7#", fen: "r1bqkbnr/pppp1ppp/2n5/4p2Q/2B1P3/8/PPPP1PPP/RNB1K1NR w KQkq - 4 4", want: "h5f7"},
+		{text: "Qxf7", fen: "r1bqkbnr/pppp1ppp/2n5/4p2Q/2B1P3/8/PPPP1PPP/RNB1K1NR w KQkq - 4 4", want: "h5f7"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.text, func(t *testing.T) {
+			// arrange
+			board := fen.FENtoBoard(iif(c.fen == "", startPosFEN, c.fen))
+
+			// act
+			got, err := parseMove(board, c.text)
+
+			// assert
+			if (err != nil) != c.wantErr {
+				t.Fatalf("err: %v, want error: %v", err, c.wantErr)
+			}
+			if got != c.want {
+				t.Errorf("got '%s', want '%s'", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"time"
+
+	"trollfish-lichess/fen"
+)
+
+// arenaResignScramble is the opponent's time below which a lost arena game is played on,
+// they might flag.
+const arenaResignScramble = 20 * time.Second
+
+// ArenaResign resigns clearly lost arena games early, to get paired again sooner.
+type ArenaResign struct {
+	Moves int     // our moves in a row at or below Score before resigning, 0 = off
+	Score float64 // our expected score, see wdl.WDL.Score. Draws count as swindle chances
+}
+
+func (r ArenaResign) Enabled() bool {
+	return r.Moves > 0
+}
+
+// arenaLost counts our lost moves in arena games and reports whether it's time to resign.
+// A time scramble the opponent could lose on the clock resets the count. Called on the
+// event loop after the engine's eval of our move.
+func (g *Game) arenaLost(board fen.Board, ourTime, opponentTime time.Duration) bool {
+	policy := g.opts.ArenaResign
+	if !policy.Enabled() || g.tournamentID == "" || g.humanEval == "" {
+		return false
+	}
+
+	scramble := opponentTime < arenaResignScramble || opponentTime < ourTime
+	if scramble || evalWDL(g.wdlModel(), g.humanEval, g.playerColor, board).Score() > policy.Score {
+		g.arenaLostMoves = 0
+		return false
+	}
+
+	g.arenaLostMoves++
+	return g.arenaLostMoves >= policy.Moves
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"trollfish-lichess/fen"
+	"trollfish-lichess/storage"
+	"trollfish-lichess/yamlbook"
+)
+
+func TestGame_ArenaLost(t *testing.T) {
+	// arrange
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	opts := GameOptions{ArenaResign: ArenaResign{Moves: 2, Score: 0.02}}
+	g := NewGame(ctx, "test", storage.NewMemory(), fakeEngine(ctx, nil), &yamlbook.Book{}, nil, opts, nil)
+	defer g.Finish()
+	g.playerColor = fen.WhitePieces
+	board := fen.FENtoBoard(startPosFEN)
+
+	move := func(eval string, ourTime, opponentTime time.Duration) bool {
+		g.humanEval = eval
+		return g.arenaLost(board, ourTime, opponentTime)
+	}
+
+	// act, assert
+	if move("-20.00", time.Minute, time.Minute) || move("-20.00", time.Minute, time.Minute) {
+		t.Fatal("not an arena game: want no resign")
+	}
+
+	g.tournamentID = "arena"
+	g.arenaLostMoves = 0
+	if move("-20.00", time.Minute, time.Minute) {
+		t.Fatal("first lost move: want no resign")
+	}
+	if move("-20.00", time.Minute, 10*time.Second) {
+		t.Fatal("opponent short of time: want no resign")
+	}
+	if move("-20.00", time.Minute, time.Minute) {
+		t.Fatal("scramble resets the count: want no resign")
+	}
+	if !move("M-3", time.Minute, time.Minute) {
+		t.Error("second lost move in a row: want resign")
+	}
+	if move("0.00", time.Minute, time.Minute) {
+		t.Error("drawn: want no resign")
+	}
+}
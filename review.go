@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"trollfish-lichess/fen"
+)
+
+const (
+	evalSwingCP    = 100 // centipawns (our pov) lost after leaving book before the line is queued for review
+	evalSwingMoves = 4   // number of our engine moves after leaving book to watch for a swing
+)
+
+// bookExit is the last yamlbook position we played a move from, and the book's eval for it.
+type bookExit struct {
+	fenKey     string
+	score      int
+	movesSince int
+}
+
+func (g *Game) trackBookExit(fenKey string, hasEval bool) {
+	if !hasEval {
+		g.bookExit = bookExit{}
+		return
+	}
+
+	g.bookExit = bookExit{
+		fenKey: fenKey,
+		score:  evalToScore(g.humanEval, g.playerColor),
+	}
+}
+
+// checkEvalSwing compares the engine's eval after leaving book with the book's eval of the
+// final book position. A bad swing means the book line is costing us, so the final book
+// position is queued for re-analysis.
+func (g *Game) checkEvalSwing(board fen.Board) {
+	exit := &g.bookExit
+	if exit.fenKey == "" || g.humanEval == "" {
+		return
+	}
+
+	exit.movesSince++
+	if exit.movesSince > evalSwingMoves {
+		g.bookExit = bookExit{}
+		return
+	}
+
+	score := evalToScore(g.humanEval, g.playerColor)
+	swing := exit.score - score
+	if swing < evalSwingCP {
+		return
+	}
+
+	fmt.Printf("%s *** EVAL SWING: %d cp %d move(s) after leaving book (book: %d, now: %d) fen: %s book_fen: %s\n",
+		ts(), swing, exit.movesSince, exit.score, score, board.FEN(), exit.fenKey)
+
+	if g.book.MarkForReview(exit.fenKey) {
+		g.reviewQueued++
+		fmt.Printf("%s queued '%s' for book review\n", ts(), exit.fenKey)
+	}
+
+	g.bookExit = bookExit{}
+}
+
+func (g *Game) saveReviewQueue() {
+	if g.reviewQueued == 0 {
+		return
+	}
+
+	if err := g.book.Save(); err != nil {
+		log.Printf("ERR: saving review queue: %v\n", err)
+		return
+	}
+
+	fmt.Printf("%s %d position(s) added to the book review queue\n", ts(), g.reviewQueued)
+}
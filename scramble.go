@@ -0,0 +1,16 @@
+package main
+
+import "time"
+
+// Scramble is the reflex mode for mutual time scrambles: when both clocks are under Below,
+// a ponder hit is played at once and otherwise our move is a search to Depth, without the
+// book, its check or the searches after ours (swindles, repetitions, endgame technique).
+type Scramble struct {
+	Below time.Duration // 0 = off
+	Depth int
+}
+
+// applies reports whether both flags are low enough to scramble.
+func (s Scramble) applies(clock Clock, ourTime, opponentTime time.Duration) bool {
+	return s.Below > 0 && s.Depth > 0 && clock.Type != ClockNone && ourTime < s.Below && opponentTime < s.Below
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"trollfish-lichess/fen"
+	"trollfish-lichess/yamlbook"
+)
+
+// serverEvalEngineID is the engine id of evals imported from lichess' server analysis.
+const serverEvalEngineID = "lichess-server"
+
+// ServerEvalImport counts what ImportServerEvals did.
+type ServerEvalImport struct {
+	Games     int // with at least one eval
+	Added     int // moves added to the book
+	Positions int // new to the book
+	Skipped   int // evals of positions the book already has other evals for
+}
+
+// ImportServerEvals adds the [%eval] comments of lichess' server analysis in games to book,
+// for moves up to maxPly (0 for all), as engine 'lichess-server'. The evals are shallow and
+// have no depth or PV, so they only fill gaps: positions the book already has other evals
+// for are left alone, and the analyzer replaces them as it reaches their positions.
+func ImportServerEvals(book *yamlbook.Book, games []*fen.PGNGame, maxPly int, now time.Time) ServerEvalImport {
+	var result ServerEvalImport
+	for _, game := range games {
+		var hasEval bool
+		board := fen.FENtoBoard(game.SetupFEN)
+		for ply, move := range game.Moves {
+			if maxPly > 0 && ply >= maxPly {
+				break
+			}
+			if move.HasEval {
+				hasEval = true
+				addServerEval(book, board, move, now, &result)
+			}
+			board.Moves(move.UCI)
+		}
+		if hasEval {
+			result.Games++
+		}
+	}
+	return result
+}
+
+func addServerEval(book *yamlbook.Book, board fen.Board, move fen.PGNMove, now time.Time, result *ServerEvalImport) {
+	san := board.UCItoSAN(move.UCI)
+
+	moves, ok := book.Get(move.FENKey)
+	for _, m := range moves {
+		if m.Engine == nil || m.Engine.ID != serverEvalEngineID {
+			result.Skipped++
+			return
+		}
+		if m.Move == san {
+			return
+		}
+	}
+
+	// the eval is white's after the move, book evals are the mover's
+	score := fen.Global(move.CP, move.Mate).For(board.ActiveColor)
+	cp, mate := score.CP, score.Mate
+
+	book.Add(move.FENKey, &yamlbook.Move{
+		Move:   san,
+		CP:     cp,
+		Mate:   mate,
+		TS:     now.Unix(),
+		Source: &yamlbook.Source{Type: yamlbook.SourceServer, AddedBy: "lichess", Date: now.Unix()},
+		Engine: &yamlbook.Engine{
+			ID:     serverEvalEngineID,
+			Output: []*yamlbook.EngineOutput{{Line: yamlbook.LogLine{CP: cp, Mate: mate, PV: san}}},
+		},
+	})
+	result.Added++
+	if !ok {
+		result.Positions++
+	}
+}
+
+// BackfillServerEvals imports the server analysis evals of a PGN file downloaded with
+// -lichess-user-evals into a YAML book, created if it doesn't exist, see ImportServerEvals.
+// The evals are too shallow to play, so the book shouldn't be the bot's until the analyzer
+// has replaced them.
+func BackfillServerEvals(pgnFilename, bookFilename string, maxPly int) error {
+	db, err := fen.LoadPGNDatabase(pgnFilename)
+	if err != nil {
+		return err
+	}
+
+	book := yamlbook.New(bookFilename)
+	if _, err := os.Stat(bookFilename); err == nil {
+		if book, err = yamlbook.Load(bookFilename); err != nil {
+			return err
+		}
+	}
+
+	result := ImportServerEvals(book, db.Games, maxPly, time.Now())
+	fmt.Printf("%d of %d game(s) with server evals: added %d move(s), %d new position(s), skipped %d already evaluated\n",
+		result.Games, len(db.Games), result.Added, result.Positions, result.Skipped)
+
+	if result.Added == 0 {
+		return nil
+	}
+	return book.Save()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"trollfish-lichess/fen"
+	"trollfish-lichess/yamlbook"
+)
+
+func TestImportServerEvals(t *testing.T) {
+	// arrange
+	const pgn = `[Event "Rated blitz game"]
+[White "someone"]
+[Black "trollololfish"]
+[Result "1-0"]
+
+1. e4 { [%eval 0.3] } 1... e5 { [%eval 0.25] } 2. Nf3 { [%eval #4] } 2... Nc6 { [%eval -0.1] } 1-0
+`
+	game, err := fen.ParsePGN(pgn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	afterE4 := fen.FENtoBoard(startPosFEN)
+	afterE4.Moves("e2e4")
+	afterNf3 := fen.FENtoBoard(startPosFEN)
+	afterNf3.Moves("e2e4", "e7e5", "g1f3")
+
+	filename := filepath.Join(t.TempDir(), "book.yamlbook")
+	data := `- fen: ` + afterNf3.FENKey() + `
+  moves:
+    - move: Nf6
+      cp: -20
+      engine:
+        id: stockfish
+`
+	if err := os.WriteFile(filename, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	book, err := yamlbook.Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	result := ImportServerEvals(book, []*fen.PGNGame{game}, 3, time.Now())
+	again := ImportServerEvals(book, []*fen.PGNGame{game}, 0, time.Now())
+
+	// assert
+	if result.Games != 1 || result.Added != 3 || result.Positions != 3 || result.Skipped != 0 {
+		t.Errorf("got %+v, want 3 moves added to 3 new positions", result)
+	}
+	if again.Added != 0 || again.Skipped != 1 {
+		t.Errorf("again: got %+v, want nothing added and Nc6 skipped", again)
+	}
+
+	moves, ok := book.Get(startPosFEN)
+	if !ok || len(moves) != 1 || moves[0].Move != "e4" || moves[0].CP != 30 {
+		t.Fatalf("start: got %v, want e4 at 30 cp", moves)
+	}
+	if moves[0].Engine.ID != serverEvalEngineID || moves[0].Source.Type != yamlbook.SourceServer {
+		t.Errorf("start: got engine %+v source %+v", moves[0].Engine, moves[0].Source)
+	}
+
+	// black's move, the eval is turned to black's point of view
+	if moves, _ := book.Get(afterE4.FENKey()); len(moves) != 1 || moves[0].CP != -25 {
+		t.Errorf("after e4: got %v, want e5 at -25 cp", moves)
+	}
+
+	if moves, _ := book.Get(afterNf3.FENKey()); len(moves) != 1 || moves[0].Move != "Nf6" {
+		t.Errorf("after Nf3: got %v, want only the engine's Nf6", moves)
+	}
+}
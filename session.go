@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"trollfish-lichess/api"
+	"trollfish-lichess/history"
+)
+
+// Session tracks the games played since the bot started so a report can be printed on shutdown.
+type Session struct {
+	mtx     sync.Mutex
+	start   time.Time
+	games   []history.Game
+	pending sync.WaitGroup // games Record is adding, Close waits for them
+
+	db *history.DB
+}
+
+func NewSession(db *history.DB) *Session {
+	return &Session{
+		start: time.Now(),
+		db:    db,
+	}
+}
+
+// Record adds a finished game in the background, see AddGame.
+func (s *Session) Record(game *Game) {
+	s.pending.Add(1)
+	go func() {
+		defer s.pending.Done()
+		s.AddGame(game)
+	}()
+}
+
+// AddGame records a finished game and its summary. For rated games the rating diff is
+// fetched from lichess, which may take a moment to become available after the game ends.
+// Experiment games also get our ACPL, if lichess has analysed the game.
+func (s *Session) AddGame(game *Game) {
+	record := game.HistoryRecord()
+	if record.Result == "" {
+		fmt.Printf("%s game %s has no result (%s), not recorded\n", ts(), record.ID, record.Status)
+		return
+	}
+
+	if record.Rated || record.Experiment != "" {
+		for i := 0; i < 5; i++ {
+			time.Sleep(time.Duration(i+1) * time.Second)
+
+			completed, err := api.GetGame(record.ID)
+			if err != nil {
+				log.Printf("ERR: GetGame: %v\n", err)
+				continue
+			}
+
+			players := completed.Players
+			player := iif(record.Color == "white", players.White, players.Black)
+			if player.Analysis != nil {
+				acpl := player.Analysis.ACPL
+				record.ACPL = &acpl
+			}
+			if record.Rated && player.RatingDiff == 0 && completed.Status == "started" {
+				continue
+			}
+
+			record.RatingAfter = record.RatingBefore + player.RatingDiff
+			break
+		}
+	}
+
+	s.mtx.Lock()
+	s.games = append(s.games, record)
+	s.mtx.Unlock()
+
+	if err := s.db.AddGame(record); err != nil {
+		log.Printf("ERR: history: %v\n", err)
+	}
+	if err := s.db.AddSummary(game.Summary(record)); err != nil {
+		log.Printf("ERR: history: %v\n", err)
+	}
+
+	rating := fmt.Sprintf("%d -> %d (%+d)", record.RatingBefore, record.RatingAfter, record.RatingDiff())
+	if !record.RatingKnown() {
+		rating = fmt.Sprintf("%d -> unknown", record.RatingBefore)
+	}
+	fmt.Printf("%s %s vs %s (%d): %s, rating %s\n", ts(), record.Perf, record.Opponent, record.OpponentRating, record.Result, rating)
+}
+
+func (s *Session) Summary() history.Session {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	games := make([]history.Game, len(s.games))
+	copy(games, s.games)
+
+	return history.Summarize(s.start.Unix(), time.Now().Unix(), games)
+}
+
+// Close waits for the games being recorded, then prints the session report and saves it to
+// the history database.
+func (s *Session) Close() {
+	s.pending.Wait()
+	summary := s.Summary()
+
+	fmt.Print(sessionReport(summary))
+
+	if summary.Games == 0 {
+		return
+	}
+
+	if err := s.db.AddSession(summary); err != nil {
+		log.Printf("ERR: history: %v\n", err)
+	}
+}
+
+func sessionReport(summary history.Session) string {
+	var sb strings.Builder
+
+	duration := time.Duration(summary.End-summary.Start) * time.Second
+	sb.WriteString(fmt.Sprintf("\n%s *** session report (%v)\n", ts(), duration))
+	sb.WriteString(fmt.Sprintf("games: %d  +%d -%d =%d  score: %.1f/%d\n", summary.Games, summary.Wins, summary.Losses, summary.Draws, summary.Score, summary.Games))
+
+	perfs := make([]string, 0, len(summary.Perfs))
+	for perf := range summary.Perfs {
+		perfs = append(perfs, perf)
+	}
+	sort.Strings(perfs)
+
+	for _, perf := range perfs {
+		delta := summary.Perfs[perf]
+		sb.WriteString(fmt.Sprintf("%-14s %3d rated game(s)  %4d -> %4d (%+d)\n", perf+":", delta.Games, delta.Before, delta.After, delta.Diff()))
+	}
+
+	if summary.BestWin != nil {
+		g := summary.BestWin
+		sb.WriteString(fmt.Sprintf("best win:   %s (%d) https://lichess.org/%s\n", g.Opponent, g.OpponentRating, g.ID))
+	}
+	if summary.WorstLoss != nil {
+		g := summary.WorstLoss
+		sb.WriteString(fmt.Sprintf("worst loss: %s (%d) https://lichess.org/%s\n", g.Opponent, g.OpponentRating, g.ID))
+	}
+
+	return sb.String()
+}
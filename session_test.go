@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"trollfish-lichess/history"
+	"trollfish-lichess/storage"
+)
+
+func TestSession_RecordBeforeClose(t *testing.T) {
+	// arrange
+	store := storage.NewMemory()
+	s := NewSession(history.Open(store, history.DefaultFilename))
+	games := []*Game{
+		{gameID: "a", perf: "blitz", result: history.Win, ourRating: 1800},
+		{gameID: "b", perf: "blitz", result: history.Draw, ourRating: 1800},
+	}
+
+	// act
+	for _, g := range games {
+		s.Record(g)
+	}
+	s.Close()
+
+	// assert
+	recorded, err := s.db.Games()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recorded) != len(games) {
+		t.Errorf("got %d game(s) recorded before Close, want %d", len(recorded), len(games))
+	}
+	for _, game := range recorded {
+		if !game.RatingKnown() || game.RatingDiff() != 0 {
+			t.Errorf("%s: unrated games' rating doesn't change, got %d -> %d", game.ID, game.RatingBefore, game.RatingAfter)
+		}
+	}
+}
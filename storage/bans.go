@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BansVersion is the bans file format written by Bans.
+//
+// Version history:
+//
+//	0: {"banned": [{"id", "reason"}]}, the reason being lichess' error text or "soft-ban; timeout"
+//	1: typed reasons, timestamps and expiry
+const BansVersion = 1
+
+// BanReason is why a bot isn't challenged.
+type BanReason string
+
+const (
+	BanRefused BanReason = "refused" // lichess refused to create the challenge, e.g. the bot blocks us
+	BanNotNow  BanReason = "not-now" // the bot isn't accepting challenges for now
+	BanTimeout BanReason = "timeout" // the challenge went unanswered
+)
+
+// Ban is a bot we don't challenge.
+type Ban struct {
+	ID      string    `json:"id"` // lowercase
+	Reason  BanReason `json:"reason"`
+	Message string    `json:"message,omitempty"` // lichess' error, if any
+	TS      int64     `json:"ts"`                // unix time banned
+	Expires int64     `json:"expires,omitempty"` // unix time the ban ends, 0 for never
+}
+
+// Active reports whether the ban is still in force at now.
+func (b Ban) Active(now time.Time) bool {
+	return b.Expires == 0 || now.Unix() < b.Expires
+}
+
+// Bans is the list of bots we don't challenge, kept in a Storage file.
+type Bans struct {
+	mtx   sync.Mutex
+	store Storage
+	name  string
+
+	Version int   `json:"version"`
+	Banned  []Ban `json:"banned"`
+}
+
+// LoadBans reads the bans kept in store's file name, upgrading older versions. Expired bans
+// are dropped.
+func LoadBans(store Storage, name string, now time.Time) (*Bans, error) {
+	bans := Bans{store: store, name: name}
+	b, err := store.ReadFile(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			bans.Version = BansVersion
+			return &bans, nil
+		}
+		return nil, fmt.Errorf("'%s': %v", name, err)
+	}
+	if err := json.Unmarshal(b, &bans); err != nil {
+		return nil, fmt.Errorf("'%s': %v", name, err)
+	}
+	if bans.Version > BansVersion {
+		return nil, fmt.Errorf("'%s': version %d is newer than supported version %d", name, bans.Version, BansVersion)
+	}
+	if bans.Version == 0 {
+		migrateBansV0(&bans, now)
+	}
+	bans.Version = BansVersion
+
+	active := bans.Banned[:0]
+	for _, ban := range bans.Banned {
+		if ban.Active(now) {
+			active = append(active, ban)
+		}
+	}
+	bans.Banned = active
+
+	return &bans, nil
+}
+
+// notNowMessages are lichess' errors for bots that aren't accepting challenges for now.
+var notNowMessages = []string{
+	"I'm not accepting challenges at the moment.",
+	"This is not the right time for me, please ask again later.",
+}
+
+// ClassifyBan returns the reason to ban a bot for lichess' error message.
+func ClassifyBan(message string) BanReason {
+	for _, m := range notNowMessages {
+		if message == m {
+			return BanNotNow
+		}
+	}
+	return BanRefused
+}
+
+// migrateBansV0 types version 0 reasons. Soft bans were lifted each time the bot started, so
+// they're migrated expired.
+func migrateBansV0(bans *Bans, now time.Time) {
+	for i, ban := range bans.Banned {
+		message := string(ban.Reason)
+		ban.ID, ban.TS = strings.ToLower(ban.ID), now.Unix()
+		switch {
+		case strings.Contains(message, "soft-ban"):
+			ban.Reason, ban.Expires = BanTimeout, now.Unix()
+		case ClassifyBan(message) == BanNotNow:
+			ban.Reason, ban.Message, ban.Expires = BanNotNow, message, now.Unix()
+		default:
+			ban.Reason, ban.Message = BanRefused, message
+		}
+		bans.Banned[i] = ban
+	}
+}
+
+// IsBanned reports whether bot has an active ban at now.
+func (b *Bans) IsBanned(bot string, now time.Time) bool {
+	if b == nil {
+		return false
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	for _, ban := range b.Banned {
+		if strings.EqualFold(ban.ID, bot) && ban.Active(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add bans bot until expires, forever if it's zero, and saves the bans.
+func (b *Bans) Add(bot string, reason BanReason, message string, now, expires time.Time) error {
+	ban := Ban{ID: strings.ToLower(bot), Reason: reason, Message: message, TS: now.Unix()}
+	if !expires.IsZero() {
+		ban.Expires = expires.Unix()
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.Banned = append(b.Banned, ban)
+	return b.save()
+}
+
+// Save writes the bans, e.g. after LoadBans migrated or dropped some.
+func (b *Bans) Save() error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	return b.save()
+}
+
+func (b *Bans) save() error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := b.store.WriteFile(b.name, data); err != nil {
+		return fmt.Errorf("write file '%s': %v", b.name, err)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestLoadBans_MigrateV0(t *testing.T) {
+	// arrange
+	now := time.Unix(1000, 0)
+	store := NewMemory()
+	v0 := `{"banned": [
+		{"id": "Grumpy", "reason": "This bot blocks you"},
+		{"id": "sleepy", "reason": "soft-ban; timeout"},
+		{"id": "busy", "reason": "I'm not accepting challenges at the moment."}
+	]}`
+	if err := store.WriteFile("banned.json", []byte(v0)); err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	bans, err := LoadBans(store, "banned.json", now)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Ban{{ID: "grumpy", Reason: BanRefused, Message: "This bot blocks you", TS: 1000}}
+	if len(bans.Banned) != 1 || bans.Banned[0] != want[0] {
+		t.Fatalf("got %+v, want %+v (soft bans lifted)", bans.Banned, want)
+	}
+	if !bans.IsBanned("GRUMPY", now) || bans.IsBanned("sleepy", now) {
+		t.Error("IsBanned: want only grumpy banned")
+	}
+
+	if err := bans.Save(); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := store.ReadFile("banned.json")
+	var saved Bans
+	if err := json.Unmarshal(b, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Version != BansVersion {
+		t.Errorf("saved version: got %d, want %d", saved.Version, BansVersion)
+	}
+}
+
+func TestBans_Add(t *testing.T) {
+	// arrange
+	now := time.Unix(1000, 0)
+	store := NewMemory()
+	bans, err := LoadBans(store, "banned.json", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	if err := bans.Add("Sleepy", BanTimeout, "", now, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := bans.Add("blocker", BanRefused, "blocked", now, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// assert
+	if !bans.IsBanned("sleepy", now) || bans.IsBanned("sleepy", now.Add(time.Hour)) {
+		t.Error("sleepy: want banned for an hour")
+	}
+	later, err := LoadBans(store, "banned.json", now.Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(later.Banned) != 1 || !later.IsBanned("blocker", now.Add(time.Hour*24*365)) {
+		t.Errorf("reloaded: got %+v, want blocker only", later.Banned)
+	}
+}
+
+func TestClassifyBan(t *testing.T) {
+	if got := ClassifyBan("This is not the right time for me, please ask again later."); got != BanNotNow {
+		t.Errorf("got %s, want %s", got, BanNotNow)
+	}
+	if got := ClassifyBan("You cannot challenge this bot"); got != BanRefused {
+		t.Errorf("got %s, want %s", got, BanRefused)
+	}
+}
@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Storage reads and writes the bot's data files by name. Names use forward slashes and are
+// relative to the store's root. A missing file is reported with an error satisfying
+// os.IsNotExist.
+type Storage interface {
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte) error
+	AppendFile(name string, data []byte) error
+	Exists(name string) bool
+}
+
+// Dir stores files in a directory on disk, creating it and any subdirectories as needed.
+type Dir struct {
+	root string
+}
+
+// NewDir returns a Dir rooted at root. An empty root is the working directory.
+func NewDir(root string) *Dir {
+	if root == "" {
+		root = "."
+	}
+	return &Dir{root: root}
+}
+
+// Path returns the path on disk of name, for loaders that need to open it themselves.
+func (d *Dir) Path(name string) string {
+	return filepath.Join(d.root, filepath.FromSlash(name))
+}
+
+func (d *Dir) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(d.Path(name))
+}
+
+// WriteFile replaces name atomically, see WriteFileAtomic.
+func (d *Dir) WriteFile(name string, data []byte) error {
+	filename := d.Path(name)
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	return WriteFileAtomic(filename, data)
+}
+
+// WriteFileAtomic replaces filename: data is written to a temporary file next to it, which
+// is then renamed over it, so a crash leaves either the old file or the new one.
+func WriteFileAtomic(filename string, data []byte) error {
+	fp, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := fp.Name()
+	defer os.Remove(tmp) // after a successful rename there's nothing to remove
+
+	if _, err := fp.Write(data); err != nil {
+		fp.Close()
+		return err
+	}
+	if err := fp.Sync(); err != nil {
+		fp.Close()
+		return err
+	}
+	if err := fp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+func (d *Dir) AppendFile(name string, data []byte) error {
+	filename := d.Path(name)
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+
+	fp, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	if _, err := fp.Write(data); err != nil {
+		return err
+	}
+
+	return fp.Sync()
+}
+
+func (d *Dir) Exists(name string) bool {
+	_, err := os.Stat(d.Path(name))
+	return err == nil || !os.IsNotExist(err)
+}
+
+// Memory keeps files in memory. It's meant for tests.
+type Memory struct {
+	mtx   sync.Mutex
+	files map[string][]byte
+}
+
+func NewMemory() *Memory {
+	return &Memory{files: make(map[string][]byte)}
+}
+
+func (m *Memory) ReadFile(name string) ([]byte, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	b, ok := m.files[clean(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return append([]byte(nil), b...), nil
+}
+
+func (m *Memory) WriteFile(name string, data []byte) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.files[clean(name)] = append([]byte(nil), data...)
+	return nil
+}
+
+func (m *Memory) AppendFile(name string, data []byte) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	name = clean(name)
+	m.files[name] = append(m.files[name], data...)
+	return nil
+}
+
+func (m *Memory) Exists(name string) bool {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	_, ok := m.files[clean(name)]
+	return ok
+}
+
+// Names returns the names of all stored files, sorted.
+func (m *Memory) Names() []string {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	names := make([]string, 0, len(m.files))
+	for name := range m.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func clean(name string) string {
+	return strings.TrimPrefix(filepath.ToSlash(filepath.Clean(name)), "./")
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"os"
+	"testing"
+)
+
+func testStorage(t *testing.T, s Storage) {
+	t.Helper()
+
+	// arrange
+	if s.Exists("a/b.txt") {
+		t.Fatal("exists before write")
+	}
+	if _, err := s.ReadFile("a/b.txt"); !os.IsNotExist(err) {
+		t.Fatalf("read missing: got %v, want not exist", err)
+	}
+
+	// act
+	if err := s.WriteFile("a/b.txt", []byte("one\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AppendFile("a/b.txt", []byte("two\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AppendFile("c.txt", []byte("new\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	// assert
+	if !s.Exists("a/b.txt") {
+		t.Error("not found after write")
+	}
+	b, err := s.ReadFile("a/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "one\ntwo\n" {
+		t.Errorf("a/b.txt: got %q", b)
+	}
+	b, err = s.ReadFile("./c.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "new\n" {
+		t.Errorf("c.txt: got %q", b)
+	}
+}
+
+func TestDir(t *testing.T) {
+	dir := t.TempDir()
+	testStorage(t, NewDir(dir))
+
+	// replaced atomically, without temporary files left behind
+	d := NewDir(dir)
+	if err := d.WriteFile("a/b.txt", []byte("replaced\n")); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := d.ReadFile("a/b.txt"); string(b) != "replaced\n" {
+		t.Errorf("a/b.txt: got %q", b)
+	}
+	entries, err := os.ReadDir(d.Path("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("got %d file(s) in a/, want only b.txt", len(entries))
+	}
+}
+
+func TestMemory(t *testing.T) {
+	testStorage(t, NewMemory())
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+
+	"trollfish-lichess/yamlbook"
+)
+
+// strengthBookMaxLoss is how much worse than the best book move (cp) a deliberately
+// sub-optimal book move may be.
+const strengthBookMaxLoss = 100
+
+// Strength limits how well we play in casual games against humans. The zero value plays
+// at full strength.
+type Strength struct {
+	Elo        int     // UCI_Elo, 0 = don't use UCI_LimitStrength
+	Depth      int     // max search depth, 0 = no limit
+	Nodes      int     // max nodes per search, 0 = no limit
+	BookRandom float64 // chance of playing a book move other than the best, 0-1
+	Announce   bool    // say in chat that we're playing at reduced strength
+}
+
+// Enabled reports whether any limit is set.
+func (s Strength) Enabled() bool {
+	return s.Elo > 0 || s.Depth > 0 || s.Nodes > 0 || s.BookRandom > 0
+}
+
+// Options returns the setoption commands for a game, limited or not. Without an Elo
+// UCI_LimitStrength is left alone.
+func (s Strength) Options(limited bool) []string {
+	if s.Elo <= 0 {
+		return nil
+	}
+	if !limited {
+		return []string{"setoption name UCI_LimitStrength value false"}
+	}
+	return []string{
+		"setoption name UCI_LimitStrength value true",
+		fmt.Sprintf("setoption name UCI_Elo value %d", s.Elo),
+	}
+}
+
+// GoLimits returns the depth and nodes arguments appended to 'go' commands.
+func (s Strength) GoLimits() string {
+	var limits string
+	if s.Depth > 0 {
+		limits += fmt.Sprintf(" depth %d", s.Depth)
+	}
+	if s.Nodes > 0 {
+		limits += fmt.Sprintf(" nodes %d", s.Nodes)
+	}
+	return limits
+}
+
+// String describes the limits for the chat announcement.
+func (s Strength) String() string {
+	switch {
+	case s.Elo > 0:
+		return fmt.Sprintf("about %d Elo", s.Elo)
+	case s.Depth > 0:
+		return fmt.Sprintf("depth %d", s.Depth)
+	case s.Nodes > 0:
+		return fmt.Sprintf("%d nodes per move", s.Nodes)
+	}
+	return "reduced strength"
+}
+
+// WeakerBookMove returns, BookRandom of the time, one of moves other than best whose
+// eval is within strengthBookMaxLoss of it. Otherwise, or if there is none, it returns nil.
+func (s Strength) WeakerBookMove(moves yamlbook.Moves, best *yamlbook.Move, allow yamlbook.MoveFilter, r *rand.Rand) *yamlbook.Move {
+	if best == nil || best.Mate != 0 || s.BookRandom <= 0 || r.Float64() >= s.BookRandom {
+		return nil
+	}
+
+	var candidates yamlbook.Moves
+	for _, move := range moves {
+		if move.Move == best.Move || move.Mate != 0 {
+			continue
+		}
+		if allow != nil && !allow(move) {
+			continue
+		}
+		if best.CP-move.CP > strengthBookMaxLoss {
+			continue
+		}
+		candidates = append(candidates, move)
+	}
+
+	if len(candidates) == 0 {
+		return nil
+	}
+	return candidates[r.Intn(len(candidates))]
+}
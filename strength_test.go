@@ -0,0 +1,55 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+
+	"trollfish-lichess/yamlbook"
+)
+
+func TestStrength_WeakerBookMove(t *testing.T) {
+	// arrange
+	best := &yamlbook.Move{Move: "e4", CP: 40}
+	moves := yamlbook.Moves{
+		best,
+		{Move: "d4", CP: 35},
+		{Move: "a4", CP: -90}, // too much worse
+		{Move: "Nf3", CP: 30, Tags: []string{yamlbook.TagHumanOnly}},
+	}
+	allow := yamlbook.ExcludeTags(yamlbook.TagHumanOnly)
+	s := Strength{BookRandom: 1}
+	r := rand.New(rand.NewSource(1))
+
+	// act
+	got := s.WeakerBookMove(moves, best, allow, r)
+	off := Strength{}.WeakerBookMove(moves, best, allow, r)
+
+	// assert
+	if got == nil || got.Move != "d4" {
+		t.Errorf("got %v, want d4", got)
+	}
+	if off != nil {
+		t.Errorf("BookRandom 0: got %v, want nil", off)
+	}
+}
+
+func TestStrength_Options(t *testing.T) {
+	// arrange
+	s := Strength{Elo: 1500, Depth: 8, Nodes: 20000}
+
+	// act
+	limited := s.Options(true)
+	full := s.Options(false)
+	limits := s.GoLimits()
+
+	// assert
+	if len(limited) != 2 || limited[1] != "setoption name UCI_Elo value 1500" {
+		t.Errorf("limited: got %q", limited)
+	}
+	if len(full) != 1 || full[0] != "setoption name UCI_LimitStrength value false" {
+		t.Errorf("full: got %q", full)
+	}
+	if limits != " depth 8 nodes 20000" {
+		t.Errorf("go limits: got %q", limits)
+	}
+}
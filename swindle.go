@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"trollfish-lichess/analyze"
+	"trollfish-lichess/api"
+	"trollfish-lichess/fen"
+	"trollfish-lichess/wdl"
+)
+
+const (
+	swindleTolerance     = 150              // centipawns we'll give up for a trickier line
+	swindleOnlyMoveGap   = 0.3              // winning chances the opponent loses if they miss an only-move
+	swindleOnlyMoveBonus = 8                // plies of PV an only-move is worth, see swindleLine.complexity
+	swindleReplies       = 2                // opponent replies checked for only-moves in each line
+	swindleMinTime       = 10 * time.Second // our time needed for the extra searches
+)
+
+// Swindle plays the trickiest of the engine's lines instead of its best when we're lost and
+// the opponent is short of time, hoping they go wrong.
+type Swindle struct {
+	Lines        int           // MultiPV lines compared, 0 = off
+	Score        float64       // our expected score (see wdl.WDL.Score) at or below which we're lost
+	OpponentTime time.Duration // opponent's time below which we swindle
+}
+
+func (s Swindle) Enabled() bool {
+	return s.Lines > 1
+}
+
+// swindleLine is one of the engine's MultiPV lines and the only-moves it asks of the opponent.
+type swindleLine struct {
+	eval      analyze.Eval
+	onlyMoves int
+}
+
+// complexity is how much the line asks of the opponent: the longer the fight and the more
+// replies that aren't obvious but have to be found, the better our chances.
+func (l swindleLine) complexity() int {
+	return len(l.eval.PV) + l.onlyMoves*swindleOnlyMoveBonus
+}
+
+// pickSwindleLine returns the index of the most complex line within swindleTolerance of the
+// best, lines are in the engine's order. Ties go to the better line.
+func pickSwindleLine(lines []swindleLine) int {
+	if len(lines) == 0 {
+		return -1
+	}
+
+	best, bestScore := 0, lines[0].eval.Score()
+	for i, line := range lines {
+		if line.eval.Score() < bestScore-swindleTolerance {
+			continue
+		}
+		if line.complexity() > lines[best].complexity() {
+			best = i
+		}
+	}
+	return best
+}
+
+// multiPVLines returns the most recent line of each multipv in a search's info, in order.
+func multiPVLines(info []string) []analyze.Eval {
+	latest := make(map[int]analyze.Eval)
+	for _, line := range info {
+		eval, err := analyze.ParseEval(line)
+		if err != nil || eval.UpperBound || eval.LowerBound || len(eval.PV) == 0 {
+			continue
+		}
+		if eval.MultiPV == 0 {
+			eval.MultiPV = 1
+		}
+		latest[eval.MultiPV] = eval
+	}
+
+	lines := make([]analyze.Eval, 0, len(latest))
+	for _, eval := range latest {
+		lines = append(lines, eval)
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i].MultiPV < lines[j].MultiPV })
+	return lines
+}
+
+// multiPV searches pos for moveTime with lines MultiPV lines, the engine is set back to one after.
+func (g *Game) multiPV(ctx context.Context, pos string, lines int, moveTime time.Duration) (BestMove, []analyze.Eval, error) {
+	if err := g.engine.Send(fmt.Sprintf("setoption name MultiPV value %d", lines)); err != nil {
+		return BestMove{}, nil, err
+	}
+	defer func() { _ = g.engine.Send("setoption name MultiPV value 1") }()
+
+	search, err := g.engine.Go(pos, fmt.Sprintf("go movetime %d", moveTime.Milliseconds()))
+	if err != nil {
+		return BestMove{}, nil, err
+	}
+
+	result, err := search.Wait(ctx, moveTime+engineMoveGrace)
+	if err != nil {
+		return BestMove{}, nil, err
+	}
+	g.moveAudit.AddSearch(search, result)
+	return result, multiPVLines(result.Info), nil
+}
+
+// swindle checks if we're lost with the opponent short of time. If we are, the engine's
+// MultiPV lines are compared and the one asking the most of the opponent is played.
+func (g *Game) swindle(ctx context.Context, state api.State, board fen.Board, bestMove string, ourTime, opponentTime time.Duration) string {
+	policy := g.opts.Swindle
+	if !policy.Enabled() || bestMove == "" || g.humanEval == "" {
+		return bestMove
+	}
+	if opponentTime >= policy.OpponentTime || ourTime < swindleMinTime {
+		return bestMove
+	}
+	if evalWDL(g.wdlModel(), g.humanEval, g.playerColor, board).Score() > policy.Score {
+		return bestMove
+	}
+
+	moveTime := ourTime / 100
+	if moveTime > time.Second {
+		moveTime = time.Second
+	} else if moveTime < 100*time.Millisecond {
+		moveTime = 100 * time.Millisecond
+	}
+
+	fmt.Printf("%s lost at eval %s with the opponent on %v, looking for a swindle...\n", ts(), g.humanEval, opponentTime)
+
+	prevPonder := g.ponder
+	g.stopPondering()
+	g.ponder = ""
+
+	restore := func() string {
+		if prevPonder != "" {
+			g.totalPonders-- // already counted when the first search finished
+			g.ponderMove(prevPonder, state, bestMove)
+		}
+		return bestMove
+	}
+
+	result, evals, err := g.multiPV(ctx, g.positionCommand(state.Moves), policy.Lines, moveTime)
+	if err != nil {
+		fmt.Printf("%s *** ERR: swindle: %v\n", ts(), err)
+		return restore()
+	}
+	if ctx.Err() != nil || len(evals) < 2 {
+		return restore()
+	}
+
+	lines := make([]swindleLine, len(evals))
+	for i, eval := range evals {
+		lines[i] = swindleLine{eval: eval}
+		if eval.Score() >= evals[0].Score()-swindleTolerance {
+			lines[i].onlyMoves = g.onlyMoves(ctx, state, board, eval.PV, moveTime)
+		}
+	}
+
+	pick := lines[pickSwindleLine(lines)]
+	move := pick.eval.PV[0]
+	if move == bestMove {
+		fmt.Printf("%s %s is already the trickiest line\n", ts(), board.UCItoSAN(bestMove))
+		return restore()
+	}
+
+	eval := swindleEval(pick.eval, g.playerColor)
+	fmt.Printf("%s swindling: %s (eval %s, %d plies, %d only-move(s)) instead of %s (eval %s)\n",
+		ts(), board.UCItoSAN(move), eval, len(pick.eval.PV), pick.onlyMoves, board.UCItoSAN(bestMove), g.humanEval)
+	g.moveAudit.Note("swindled with %s (eval %s, %d only-move(s)) instead of %s (eval %s)",
+		board.UCItoSAN(move), eval, pick.onlyMoves, board.UCItoSAN(bestMove), g.humanEval)
+
+	g.humanEval = eval
+	g.searched = &result
+	if len(pick.eval.PV) > 1 {
+		g.ponderMove(pick.eval.PV[1], state, move)
+	}
+	return move
+}
+
+// onlyMoves counts the opponent's replies along pv that are only-moves, where the second
+// best reply loses at least swindleOnlyMoveGap in winning chances, and that aren't obvious,
+// i.e. not a capture or check.
+func (g *Game) onlyMoves(ctx context.Context, state api.State, board fen.Board, pv []string, moveTime time.Duration) int {
+	var count int
+	for reply := 1; reply < len(pv) && reply <= 2*swindleReplies-1; reply += 2 {
+		b := board
+		for _, move := range pv[:reply] {
+			b.Moves(move)
+		}
+
+		_, replies, err := g.multiPV(ctx, g.positionCommand(append([]string{state.Moves}, pv[:reply]...)...), 2, moveTime)
+		if err != nil || ctx.Err() != nil {
+			return count
+		}
+		if len(replies) < 2 {
+			continue // forced, or the engine didn't say
+		}
+
+		san := b.UCItoSAN(replies[0].PV[0])
+		obvious := strings.ContainsAny(san, "x+#")
+		if !obvious && lineChances(replies[0], b)-lineChances(replies[1], b) >= swindleOnlyMoveGap {
+			count++
+		}
+	}
+	return count
+}
+
+// lineChances returns the winning chances of a line for the side to move.
+func lineChances(eval analyze.Eval, board fen.Board) float64 {
+	if eval.Mate != 0 {
+		return wdl.Default.FromMate(eval.Mate).Chances()
+	}
+	return wdl.Default.FromCP(eval.CP, wdl.Material(board)).Chances()
+}
+
+// evalScore converts the engine's human readable eval (white's pov, e.g. "-0.35" or "M5")
+// to a global fen.Score. It's the one parser of evals in that form, see evalToScore and
+// evalWDL.
+func evalScore(eval string) fen.Score {
+	if strings.HasPrefix(eval, "M") {
+		mate, _ := strconv.Atoi(eval[1:])
+		return fen.Global(0, mate)
+	}
+	cp, _ := strconv.ParseFloat(eval, 64)
+	return fen.Global(int(math.Round(cp*100)), 0)
+}
+
+// evalToScore converts the engine's human readable eval (white's pov) to an order of scores
+// from our pov, centipawns with mates beyond them, see fen.Score.Order.
+func evalToScore(eval string, color fen.Color) int {
+	return evalScore(eval).For(color).Order()
+}
+
+// evalWDL converts the engine's human readable eval (white's pov) to WDL from our pov with
+// model, see Game.wdlModel.
+func evalWDL(model wdl.Model, eval string, color fen.Color, board fen.Board) wdl.WDL {
+	score := evalScore(eval).For(color)
+	if strings.HasPrefix(eval, "M") {
+		return model.FromMate(score.Mate)
+	}
+	return model.FromCP(score.CP, wdl.Material(board))
+}
+
+// wdlModel is the WDL model of the opponent's rating band, for draw offers, resigning and
+// swindles, see wdl.ForRating.
+func (g *Game) wdlModel() wdl.Model {
+	return wdl.ForRating(g.opponent.Rating)
+}
+
+// swindleEval formats a line's eval, from our pov, like trollfish's: white's pov, e.g.
+// "-3.50" or "M-4".
+func swindleEval(eval analyze.Eval, color fen.Color) string {
+	return eval.POV(color).Global().String()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"trollfish-lichess/analyze"
+)
+
+func TestMultiPVLines(t *testing.T) {
+	// arrange
+	info := []string{
+		"info depth 10 seldepth 14 multipv 1 score cp -420 nodes 1000 nps 1000 hashfull 1 tbhits 0 time 1 pv d1h5 g7g6",
+		"info depth 10 seldepth 14 multipv 2 score cp -480 nodes 1000 nps 1000 hashfull 1 tbhits 0 time 1 pv b1c3 d7d5 c3d5",
+		"info depth 11 seldepth 15 multipv 1 score cp -410 lowerbound nodes 2000 nps 1000 hashfull 1 tbhits 0 time 2 pv d1h5",
+		"info depth 11 seldepth 15 multipv 1 score cp -430 nodes 3000 nps 1000 hashfull 1 tbhits 0 time 3 pv d1h5 g7g6 h5f3",
+	}
+
+	// act
+	lines := multiPVLines(info)
+
+	// assert
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2", len(lines))
+	}
+	if lines[0].CP != -430 || strings.Join(lines[0].PV, " ") != "d1h5 g7g6 h5f3" {
+		t.Errorf("multipv 1: got %d %v", lines[0].CP, lines[0].PV)
+	}
+	if lines[1].CP != -480 || lines[1].PV[0] != "b1c3" {
+		t.Errorf("multipv 2: got %d %v", lines[1].CP, lines[1].PV)
+	}
+}
+
+func TestPickSwindleLine(t *testing.T) {
+	// arrange
+	line := func(cp int, plies, onlyMoves int) swindleLine {
+		return swindleLine{eval: analyze.Eval{CP: cp, PV: make([]string, plies)}, onlyMoves: onlyMoves}
+	}
+
+	cases := []struct {
+		name  string
+		lines []swindleLine
+		want  int
+	}{
+		{"longer line", []swindleLine{line(-500, 6, 0), line(-550, 14, 0)}, 1},
+		{"only-moves", []swindleLine{line(-500, 12, 0), line(-600, 6, 1)}, 1},
+		{"too much worse", []swindleLine{line(-500, 6, 0), line(-700, 20, 2)}, 0},
+		{"tie goes to the best", []swindleLine{line(-500, 8, 0), line(-520, 8, 0)}, 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// act
+			got := pickSwindleLine(c.lines)
+
+			// assert
+			if got != c.want {
+				t.Errorf("got %d, want %d", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"trollfish-lichess/api"
+)
+
+// teamsCacheTTL is how long a challenger's teams are trusted before asking lichess again.
+const teamsCacheTTL = time.Hour
+
+// TeamFilter restricts the challenges we accept to members of some teams, e.g. to run the bot
+// as a club's training partner. A nil TeamFilter accepts everyone.
+type TeamFilter struct {
+	teams   []string // lowercase ids
+	teamsOf func(ctx context.Context, username string) ([]api.Team, error)
+
+	mtx   sync.Mutex
+	cache map[string]teamMembership
+}
+
+type teamMembership struct {
+	member  bool
+	checked time.Time
+}
+
+// NewTeamFilter returns a filter for the comma separated team ids in text, nil if there are
+// none.
+func NewTeamFilter(text string) *TeamFilter {
+	teams := parseSparring(text)
+	if len(teams) == 0 {
+		return nil
+	}
+	return &TeamFilter{teams: teams, teamsOf: api.UserTeams, cache: make(map[string]teamMembership)}
+}
+
+// Teams returns the lowercase ids of the teams our challengers have to be in.
+func (f *TeamFilter) Teams() []string {
+	if f == nil {
+		return nil
+	}
+	return f.teams
+}
+
+// Allows reports whether username is in one of the filter's teams.
+func (f *TeamFilter) Allows(ctx context.Context, username string, now time.Time) (bool, error) {
+	if f == nil {
+		return true, nil
+	}
+
+	key := strings.ToLower(username)
+	f.mtx.Lock()
+	cached, ok := f.cache[key]
+	f.mtx.Unlock()
+	if ok && now.Sub(cached.checked) < teamsCacheTTL {
+		return cached.member, nil
+	}
+
+	teams, err := f.teamsOf(ctx, username)
+	if err != nil {
+		return false, err
+	}
+
+	membership := teamMembership{checked: now}
+	for _, team := range teams {
+		for _, id := range f.teams {
+			if strings.EqualFold(team.ID, id) {
+				membership.member = true
+			}
+		}
+	}
+
+	f.mtx.Lock()
+	f.cache[key] = membership
+	f.mtx.Unlock()
+
+	return membership.member, nil
+}
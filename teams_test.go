@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"trollfish-lichess/api"
+)
+
+func TestTeamFilter_Allows(t *testing.T) {
+	// arrange
+	filter := NewTeamFilter("Chess-Club, juniors")
+	var lookups int
+	filter.teamsOf = func(_ context.Context, username string) ([]api.Team, error) {
+		lookups++
+		switch username {
+		case "member":
+			return []api.Team{{ID: "other-team"}, {ID: "chess-club"}}, nil
+		case "down":
+			return nil, errors.New("lichess is down")
+		default:
+			return []api.Team{{ID: "other-team"}}, nil
+		}
+	}
+	now := time.Now()
+	ctx := context.Background()
+
+	// act
+	member, memberErr := filter.Allows(ctx, "member", now)
+	outsider, _ := filter.Allows(ctx, "outsider", now)
+	_, downErr := filter.Allows(ctx, "down", now)
+	_, _ = filter.Allows(ctx, "Member", now.Add(time.Minute))
+	beforeExpiry := lookups
+	_, _ = filter.Allows(ctx, "member", now.Add(teamsCacheTTL))
+	anyone, anyoneErr := (*TeamFilter)(nil).Allows(ctx, "outsider", now)
+
+	// assert
+	if !member || memberErr != nil {
+		t.Errorf("member: got %v %v, want allowed", member, memberErr)
+	}
+	if outsider {
+		t.Error("outsider: got allowed")
+	}
+	if downErr == nil {
+		t.Error("down: got nil, want the lookup's error")
+	}
+	if beforeExpiry != 3 || lookups != 4 {
+		t.Errorf("got %d lookups before the cache expired and %d after, want 3 and 4", beforeExpiry, lookups)
+	}
+	if !anyone || anyoneErr != nil {
+		t.Errorf("nil filter: got %v %v, want allowed", anyone, anyoneErr)
+	}
+	if NewTeamFilter(" , ") != nil {
+		t.Error("no teams: got a filter, want nil")
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"trollfish-lichess/fen"
+	"trollfish-lichess/wdl"
+)
+
+// timeReportMistake is the drop in winning chances counted as a mistake, lichess' threshold.
+const timeReportMistake = 0.2
+
+// TimeUsage sums up moves played with [%clk] comments.
+type TimeUsage struct {
+	Moves    int
+	Think    time.Duration // total
+	Evals    int           // moves with an [%eval] before and after
+	Mistakes int
+}
+
+func (u *TimeUsage) add(think time.Duration, drop float64, hasEval bool) {
+	u.Moves++
+	if think > 0 {
+		u.Think += think
+	}
+	if hasEval {
+		u.Evals++
+		if drop >= timeReportMistake {
+			u.Mistakes++
+		}
+	}
+}
+
+func (u TimeUsage) String() string {
+	if u.Moves == 0 {
+		return "no moves"
+	}
+
+	s := fmt.Sprintf("%d moves, %v average think", u.Moves, (u.Think / time.Duration(u.Moves)).Round(100*time.Millisecond))
+	if u.Evals != 0 {
+		s += fmt.Sprintf(", %d mistakes (%.1f%% of %d with evals)", u.Mistakes, float64(u.Mistakes)/float64(u.Evals)*100, u.Evals)
+	}
+	return s
+}
+
+// TimeTroubleMove is a move played with less than the time trouble threshold left.
+type TimeTroubleMove struct {
+	Game    *fen.PGNGame
+	Move    string // e.g. "34...Qxe4"
+	Clock   time.Duration
+	Think   time.Duration
+	Drop    float64 // lost winning chances, see wdl.WDL.Chances
+	HasEval bool
+}
+
+func (m TimeTroubleMove) String() string {
+	s := fmt.Sprintf("game %d %s vs %s: %s %v left", m.Game.Index, m.Game.White, m.Game.Black, m.Move, m.Clock.Round(100*time.Millisecond))
+	if m.Think >= 0 {
+		s += fmt.Sprintf(", %v think", m.Think.Round(100*time.Millisecond))
+	}
+	if m.HasEval {
+		s += fmt.Sprintf(", winning chances %+.2f", -m.Drop)
+		if m.Drop >= timeReportMistake {
+			s += " MISTAKE"
+		}
+	}
+	return s
+}
+
+// TimeReport splits player's moves, or everyone's if player is empty, by whether they were
+// played with less than trouble on the clock, and compares the mistakes made. Evals come
+// from the PGN's [%eval] comments.
+func TimeReport(games []*fen.PGNGame, player string, trouble time.Duration) (normal, inTrouble TimeUsage, troubleMoves []TimeTroubleMove) {
+	for _, game := range games {
+		board := fen.FENtoBoard(game.SetupFEN)
+		for i, move := range game.Moves {
+			color := board.ActiveColor
+			name := iif(color == fen.WhitePieces, game.White, game.Black)
+			before := board
+			san := fmt.Sprintf("%d.%s%s", board.FullMove, iif(color == fen.WhitePieces, "", ".."), board.UCItoSAN(move.UCI))
+			board.Moves(move.UCI)
+
+			if move.Clock < 0 || (player != "" && !strings.EqualFold(name, player)) {
+				continue
+			}
+
+			var drop float64
+			hasEval := i > 0 && game.Moves[i-1].HasEval && move.HasEval
+			if hasEval {
+				drop = moveChances(game.Moves[i-1], color, before) - moveChances(move, color, board)
+			}
+
+			if move.Clock >= trouble {
+				normal.add(move.Think, drop, hasEval)
+				continue
+			}
+
+			inTrouble.add(move.Think, drop, hasEval)
+			troubleMoves = append(troubleMoves, TimeTroubleMove{
+				Game:    game,
+				Move:    san,
+				Clock:   move.Clock,
+				Think:   move.Think,
+				Drop:    drop,
+				HasEval: hasEval,
+			})
+		}
+	}
+
+	return normal, inTrouble, troubleMoves
+}
+
+// moveChances returns the winning chances of color after move's [%eval].
+func moveChances(move fen.PGNMove, color fen.Color, board fen.Board) float64 {
+	score := fen.Global(move.CP, move.Mate).For(color)
+	if score.Mate != 0 {
+		return wdl.Default.FromMate(score.Mate).Chances()
+	}
+	return wdl.Default.FromCP(score.CP, wdl.Material(board)).Chances()
+}
+
+// PrintTimeReport prints the time trouble moves of a PGN file's games and how they compare
+// to the moves played with more time.
+func PrintTimeReport(filename, player string, trouble time.Duration) error {
+	db, err := fen.LoadPGNDatabase(filename)
+	if err != nil {
+		return err
+	}
+
+	normal, inTrouble, troubleMoves := TimeReport(db.Games, player, trouble)
+	if normal.Moves+inTrouble.Moves == 0 {
+		fmt.Printf("no moves with [%%clk] comments in '%s'\n", filename)
+		return nil
+	}
+
+	for _, move := range troubleMoves {
+		fmt.Println(move)
+	}
+
+	fmt.Printf("\nunder %v: %s\n", trouble, inTrouble)
+	fmt.Printf("otherwise: %s\n", normal)
+	return nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"trollfish-lichess/fen"
+)
+
+func TestTimeReport(t *testing.T) {
+	// arrange
+	game, err := fen.ParsePGN(`[White "a"]
+[Black "b"]
+[TimeControl "60+0"]
+
+1. e4 { [%eval 0.2] [%clk 0:00:50] } 1... e5 { [%eval 0.3] [%clk 0:00:30] } 2. Nf3 { [%eval 0.3] [%clk 0:00:20] } 2... Nc6 { [%eval 4.5] [%clk 0:00:05] } *`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	game.Index = 1
+
+	// act
+	normal, inTrouble, moves := TimeReport([]*fen.PGNGame{game}, "B", 10*time.Second)
+
+	// assert
+	if normal.Moves != 1 || normal.Mistakes != 0 {
+		t.Errorf("normal: got %+v, want 1 move without mistakes", normal)
+	}
+	if inTrouble.Moves != 1 || inTrouble.Mistakes != 1 || inTrouble.Think != 25*time.Second {
+		t.Errorf("time trouble: got %+v, want 1 mistake after 25s", inTrouble)
+	}
+	if len(moves) != 1 || moves[0].Move != "2...Nc6" {
+		t.Fatalf("got %v, want 2...Nc6", moves)
+	}
+}
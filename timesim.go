@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"trollfish-lichess/fen"
+	"trollfish-lichess/yamlbook"
+)
+
+// TimeModel is how long a move takes in the time simulation. Searches started with the
+// clock times get the remaining time over MovesToGo plus a share of the increment, a rough
+// model of the engine's time allocation; 'go movetime' searches take their movetime.
+type TimeModel struct {
+	MovesToGo      int
+	IncrementShare float64       // of the increment added to each search
+	Lag            time.Duration // network and lichess latency, each move
+	Overspend      float64       // a move taking more than this share of our time left is overspent
+}
+
+// think returns how long the engine searches for a 'go' command with ourTime left.
+func (m TimeModel) think(goCmd string, ourTime, increment time.Duration) time.Duration {
+	fields := strings.Fields(goCmd)
+	for i := 0; i+1 < len(fields); i++ {
+		if fields[i] == "movetime" {
+			ms, _ := strconv.Atoi(fields[i+1])
+			return time.Duration(ms) * time.Millisecond
+		}
+	}
+
+	think := time.Duration(float64(increment) * m.IncrementShare)
+	if m.MovesToGo > 0 {
+		think += ourTime / time.Duration(m.MovesToGo)
+	}
+	return think
+}
+
+// SimulatedGame is a recorded game replayed with a synthetic clock for our side.
+type SimulatedGame struct {
+	Game      *fen.PGNGame
+	Clock     Clock
+	Moves     int // ours, until we flagged
+	BookMoves int
+	Flagged   string   // the move we lost on time with, e.g. "41.Kg2", empty if we didn't
+	Overspent []string // moves taking more than TimeModel.Overspend of our time
+	MinClock  time.Duration
+}
+
+// TimeSimulation sums up the replayed games.
+type TimeSimulation struct {
+	Games     []SimulatedGame
+	Moves     int
+	BookMoves int
+	Flagged   int
+	Overspent int
+}
+
+// SimulateTime replays player's moves in games through the move policies (see planTurn and
+// planSend) with a synthetic clock, the opponent's clock taken from the [%clk] comments. The
+// engine's thinking comes from model; positions in book, if any, are book moves. Pondering
+// isn't simulated, so the clock runs down faster than in play. Games without a TimeControl
+// tag or with player on neither side are skipped.
+func SimulateTime(games []*fen.PGNGame, player string, book *yamlbook.Book, model TimeModel) TimeSimulation {
+	var sim TimeSimulation
+	for _, game := range games {
+		base, increment, ok := game.Tags.TimeControl()
+		if !ok {
+			continue
+		}
+		var us fen.Color
+		switch {
+		case strings.EqualFold(game.White, player):
+			us = fen.WhitePieces
+		case strings.EqualFold(game.Black, player):
+			us = fen.BlackPieces
+		default:
+			continue
+		}
+
+		clock := Clock{Type: ClockFischer, Initial: base, Increment: increment}
+		if base == 0 && increment > 0 {
+			clock.Type = ClockIncrementOnly
+		}
+
+		result := simulateGame(game, us, clock, book, model)
+		sim.Games = append(sim.Games, result)
+		sim.Moves += result.Moves
+		sim.BookMoves += result.BookMoves
+		sim.Overspent += len(result.Overspent)
+		if result.Flagged != "" {
+			sim.Flagged++
+		}
+	}
+	return sim
+}
+
+func simulateGame(game *fen.PGNGame, us fen.Color, clock Clock, book *yamlbook.Book, model TimeModel) SimulatedGame {
+	result := SimulatedGame{Game: game, Clock: clock, MinClock: clock.Initial}
+	ourTime, opponentTime := clock.Initial, clock.Initial
+
+	board := fen.FENtoBoard(game.SetupFEN)
+	var moves []string
+	for _, move := range game.Moves {
+		if board.ActiveColor != us {
+			if move.Clock >= 0 {
+				opponentTime = move.Clock
+			}
+			board.Moves(move.UCI)
+			moves = append(moves, move.UCI)
+			continue
+		}
+
+		turn := turnInput{
+			Color:     us,
+			WhiteTime: int(iif(us == fen.WhitePieces, ourTime, opponentTime).Milliseconds()),
+			BlackTime: int(iif(us == fen.WhitePieces, opponentTime, ourTime).Milliseconds()),
+			Clock:     clock,
+		}
+		if bookMoves, ok := bookMovesIn(book, board); ok {
+			turn.Book = &WeightedMove{UCI: bookMoves[0].UCI()}
+			turn.BookRepeats = newRepetitions(game.SetupFEN, moves).Repeats(turn.Book.UCI)
+		}
+
+		var think time.Duration
+		plan := planTurn(turn)
+		if plan.Action == actionBook {
+			result.BookMoves++
+		} else {
+			think = model.think(plan.GoCmd, ourTime, clock.Increment)
+		}
+		send := planSend(sendInput{Board: board, OurTime: ourTime, OpponentTime: opponentTime, Clock: clock, Elapsed: think})
+		think += send.Delay + model.Lag
+
+		san := fmt.Sprintf("%d.%s%s", board.FullMove, iif(us == fen.WhitePieces, "", ".."), board.UCItoSAN(move.UCI))
+		result.Moves++
+		if model.Overspend > 0 && float64(think) > model.Overspend*float64(ourTime) {
+			result.Overspent = append(result.Overspent, fmt.Sprintf("%s %v of %v", san, think.Round(100*time.Millisecond), ourTime.Round(100*time.Millisecond)))
+		}
+
+		ourTime -= think
+		if ourTime <= 0 {
+			result.Flagged, result.MinClock = san, 0
+			return result
+		}
+		if ourTime < result.MinClock {
+			result.MinClock = ourTime
+		}
+		ourTime += clock.Increment
+
+		board.Moves(move.UCI)
+		moves = append(moves, move.UCI)
+	}
+	return result
+}
+
+func bookMovesIn(book *yamlbook.Book, board fen.Board) (yamlbook.Moves, bool) {
+	if book == nil {
+		return nil, false
+	}
+	return book.Get(board.FENKey())
+}
+
+// PrintTimeSimulation prints the games of a PGN file replayed by SimulateTime.
+func PrintTimeSimulation(filename, player, bookFilename string, model TimeModel) error {
+	db, err := fen.LoadPGNDatabase(filename)
+	if err != nil {
+		return err
+	}
+
+	var book *yamlbook.Book
+	if bookFilename != "" {
+		if book, err = yamlbook.Load(bookFilename); err != nil {
+			return err
+		}
+	}
+
+	sim := SimulateTime(db.Games, player, book, model)
+	if len(sim.Games) == 0 {
+		fmt.Printf("no games of '%s' with a TimeControl tag in '%s'\n", player, filename)
+		return nil
+	}
+
+	for _, game := range sim.Games {
+		line := fmt.Sprintf("game %d %s vs %s (%s): %d move(s), %d book, min clock %v",
+			game.Game.Index, game.Game.White, game.Game.Black, game.Clock, game.Moves, game.BookMoves, game.MinClock.Round(100*time.Millisecond))
+		if game.Flagged != "" {
+			line += ", FLAGGED at " + game.Flagged
+		}
+		fmt.Println(line)
+		for _, move := range game.Overspent {
+			fmt.Printf("  overspent: %s\n", move)
+		}
+	}
+
+	fmt.Printf("\n%d game(s), %d move(s) (%d book): flagged in %d (%.1f%%), %d move(s) overspent\n",
+		len(sim.Games), sim.Moves, sim.BookMoves, sim.Flagged, float64(sim.Flagged)/float64(len(sim.Games))*100, sim.Overspent)
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"trollfish-lichess/fen"
+	"trollfish-lichess/yamlbook"
+)
+
+func TestSimulateTime(t *testing.T) {
+	// arrange
+	game, err := fen.ParsePGN(`[White "a"]
+[Black "b"]
+[TimeControl "10+0"]
+
+1. e4 { [%clk 0:00:10] } 1... e5 { [%clk 0:00:09] } 2. Nf3 { [%clk 0:00:09] } 2... Nc6 { [%clk 0:00:08] } 3. Bb5 { [%clk 0:00:08] } 3... a6 { [%clk 0:00:07] } *`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	game.Index = 1
+
+	filename := filepath.Join(t.TempDir(), "book.yamlbook")
+	data := "version: 1\npositions:\n  - fen: " + fen.Key(startPosFEN) + "\n    moves:\n      - move: e4\n        cp: 30\n"
+	if err := os.WriteFile(filename, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	book, err := yamlbook.Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model := TimeModel{MovesToGo: 2, Lag: 3 * time.Second, Overspend: 0.5}
+
+	cases := []struct {
+		name      string
+		book      *yamlbook.Book
+		player    string
+		moves     int
+		bookMoves int
+		flagged   string
+		overspent int
+	}{
+		// 10s - 5s - 3s = 2s, 2s - 1s - 3s
+		{name: "flagged", player: "a", moves: 2, flagged: "2.Nf3", overspent: 2},
+		// 10s - 3s = 7s, 7s - 3.5s - 3s = 0.5s, 0.5s - 0.25s - 3s
+		{name: "book move", book: book, player: "A", moves: 3, bookMoves: 1, flagged: "3.Bb5", overspent: 2},
+		{name: "not our game", player: "c"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// act
+			sim := SimulateTime([]*fen.PGNGame{game}, c.player, c.book, model)
+
+			// assert
+			if c.moves == 0 {
+				if len(sim.Games) != 0 {
+					t.Errorf("got %d game(s), want none", len(sim.Games))
+				}
+				return
+			}
+			if len(sim.Games) != 1 {
+				t.Fatalf("got %d game(s), want 1", len(sim.Games))
+			}
+			got := sim.Games[0]
+			if got.Moves != c.moves || got.BookMoves != c.bookMoves || got.Flagged != c.flagged || len(got.Overspent) != c.overspent {
+				t.Errorf("got %d move(s), %d book, flagged '%s', overspent %v", got.Moves, got.BookMoves, got.Flagged, got.Overspent)
+			}
+			if sim.Flagged != 1 || sim.Overspent != c.overspent {
+				t.Errorf("totals: got %+v", sim)
+			}
+		})
+	}
+}
+
+func TestTimeModel_Think(t *testing.T) {
+	model := TimeModel{MovesToGo: 40, IncrementShare: 0.5}
+
+	if got := model.think("go movetime 1500", time.Minute, 0); got != 1500*time.Millisecond {
+		t.Errorf("movetime: got %v, want 1.5s", got)
+	}
+	if got := model.think("go wtime 60000 winc 2000 btime 60000 binc 2000", time.Minute, 2*time.Second); got != 2500*time.Millisecond {
+		t.Errorf("clock: got %v, want 1.5s + 1s", got)
+	}
+}
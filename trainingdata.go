@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"trollfish-lichess/fen"
+	"trollfish-lichess/nnue"
+	"trollfish-lichess/query"
+)
+
+// ExportTrainingData writes the analyzed positions of the comma separated files (YAML books,
+// EPD or PGN, by extension) to w as NNUE training data, see package nnue. The plain format
+// has no unknown result, so positions without one are skipped: PGN games without a result,
+// and book and EPD positions unless unknownAsDraw, for training on the eval alone. Book and
+// EPD positions need a best move and an eval at least minDepth deep. PGN positions need an
+// [%eval] and take the played move and the game's result. Positions are written once, the
+// first file's first. It returns the count.
+func ExportTrainingData(files string, minDepth int, unknownAsDraw bool, w io.Writer) (int, error) {
+	bw := bufio.NewWriter(w)
+	seen := make(map[string]bool)
+	var count int
+	var writeErr error
+
+	write := func(board fen.Board, e nnue.Entry) {
+		fenKey := board.FENKey()
+		if writeErr != nil || seen[fenKey] {
+			return
+		}
+		seen[fenKey] = true
+		e.FEN = board.FEN()
+		e.Ply = nnue.Ply(board.FullMove, board.ActiveColor == fen.WhitePieces)
+		if writeErr = e.Write(bw); writeErr == nil {
+			count++
+		}
+	}
+
+	for _, filename := range strings.Split(files, ",") {
+		if filename = strings.TrimSpace(filename); filename == "" {
+			continue
+		}
+
+		var err error
+		if strings.EqualFold(filepath.Ext(filename), ".pgn") {
+			err = readPGNTrainingData(filename, write)
+		} else if unknownAsDraw {
+			err = readQueryPositions(filename, func(p query.Position, bestMove string) {
+				if !p.HasEval || bestMove == "" || p.Depth < minDepth {
+					return
+				}
+				uci, err := p.Board.SANtoUCI(bestMove)
+				if err != nil {
+					return
+				}
+				write(p.Board, nnue.Entry{Move: uci, Score: nnue.Score(p.CP, p.Mate)})
+			})
+		}
+		if err != nil {
+			return 0, err
+		}
+		if writeErr != nil {
+			return 0, writeErr
+		}
+	}
+
+	return count, bw.Flush()
+}
+
+func readPGNTrainingData(filename string, write func(fen.Board, nnue.Entry)) error {
+	db, err := fen.LoadPGNDatabase(filename)
+	if err != nil {
+		return err
+	}
+
+	for _, game := range db.Games {
+		var whiteResult int
+		switch game.Result {
+		case fen.WhiteWon:
+			whiteResult = 1
+		case fen.BlackWon:
+			whiteResult = -1
+		case fen.Draw:
+		default:
+			continue // unfinished, or the result wasn't recorded
+		}
+
+		board := fen.FENtoBoard(game.SetupFEN)
+		for i, move := range game.Moves {
+			// the eval of this position is the [%eval] after the previous move, white's pov
+			if i > 0 && game.Moves[i-1].HasEval {
+				prev := game.Moves[i-1]
+				score := fen.Global(prev.CP, prev.Mate).For(board.ActiveColor)
+				write(board, nnue.Entry{
+					Move:   move.UCI,
+					Score:  nnue.Score(score.CP, score.Mate),
+					Result: whiteResult * int(board.ActiveColor),
+				})
+			}
+			board.Moves(move.UCI)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"trollfish-lichess/fen"
+)
+
+func TestExportTrainingData(t *testing.T) {
+	const bookEntry = `fen rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq - 0 1
+move c7c5
+score -35
+ply 1
+result 0
+e
+`
+	const pgnFirst = `fen rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq - 0 1
+move c7c5
+score -30
+ply 1
+result 1
+e
+`
+	const pgnEntries = `fen rnbqkbnr/pp1ppppp/8/2p5/4P3/8/PPPP1PPP/RNBQKBNR w KQkq - 0 2
+move g1f3
+score 40
+ply 2
+result -1
+e
+fen rnbqkbnr/pp1ppppp/8/2p5/4P3/5N2/PPPP1PPP/RNBQKB1R b KQkq - 1 2
+move d7d6
+score 31997
+ply 3
+result 1
+e
+`
+
+	// book and EPD positions go first, the game's after 1.e4 is a duplicate with them
+	cases := []struct {
+		name          string
+		unknownAsDraw bool
+		wantCount     int
+		want          string
+	}{
+		{name: "results known", wantCount: 3, want: pgnFirst + pgnEntries},
+		{name: "unknown as draws", unknownAsDraw: true, wantCount: 3, want: bookEntry + pgnEntries},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			dir := t.TempDir()
+			afterE4 := fen.FENtoBoard(startPosFEN)
+			afterE4.Moves("e2e4")
+
+			bookFile := filepath.Join(dir, "book.yamlbook")
+			book := `- fen: ` + afterE4.FENKey() + `
+  moves:
+    - move: c5
+      cp: -35
+`
+			if err := os.WriteFile(bookFile, []byte(book), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			// the unfinished game isn't exported, its positions would be draws
+			pgnFile := filepath.Join(dir, "games.pgn")
+			pgn := `[Event "Rated blitz game"]
+[White "a"]
+[Black "b"]
+[Result "*"]
+
+1. d4 { [%eval 0.2] } 1... d5 { [%eval 0.2] } 2. c4 { [%eval 0.3] } *
+
+[Event "Rated blitz game"]
+[White "a"]
+[Black "b"]
+[Result "0-1"]
+
+1. e4 { [%eval 0.3] } 1... c5 { [%eval 0.4] } 2. Nf3 { [%eval #-2] } 2... d6 0-1
+`
+			if err := os.WriteFile(pgnFile, []byte(pgn), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			// act
+			var sb strings.Builder
+			count, err := ExportTrainingData(bookFile+","+pgnFile, 0, c.unknownAsDraw, &sb)
+
+			// assert
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != c.wantCount {
+				t.Errorf("count: got %d, want %d", count, c.wantCount)
+			}
+			if got := sb.String(); got != c.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"trollfish-lichess/fen"
+)
+
+// The policies behind our moves, kept apart from lichess and the engine so they can be tested
+// on their own: planTurn decides how playMove finds our move, planSend how it's sent.
+
+// turnAction is how our move is found.
+type turnAction int
+
+const (
+	actionBook      turnAction = iota // play the book move
+	actionPonderHit                   // the search pondering the opponent's move becomes ours
+	actionSearch                      // start a search
+	actionPremove                     // send the reply prepared for the opponent's move
+)
+
+func (a turnAction) String() string {
+	switch a {
+	case actionBook:
+		return "book"
+	case actionPonderHit:
+		return "ponderhit"
+	case actionSearch:
+		return "search"
+	case actionPremove:
+		return "premove"
+	default:
+		return fmt.Sprintf("turnAction(%d)", int(a))
+	}
+}
+
+// turnInput is what's known when it's our move.
+type turnInput struct {
+	Color     fen.Color
+	WhiteTime int // ms, as in the game state
+	BlackTime int
+	Clock     Clock
+	GoLimits  string // the strength limits, empty at full strength
+
+	Book         *WeightedMove // nil out of book
+	BookRepeats  bool          // the book move repeats a position
+	BookRejected bool          // the book check found a better move, and stopped pondering
+
+	PonderHit     bool          // the opponent played the move we're pondering
+	OperatorThink time.Duration // asked for through the admin server, 0 for none
+	Premove       *premove      // our forced reply to the opponent's move, nil for none
+	Scramble      int           // the search depth when both flags are low, see Scramble; 0 when they aren't
+}
+
+func (in turnInput) ourTime() time.Duration {
+	return time.Duration(iif(in.Color == fen.WhitePieces, in.WhiteTime, in.BlackTime)) * time.Millisecond
+}
+
+// turnPlan is how to find our move.
+type turnPlan struct {
+	Action turnAction
+	Book   WeightedMove  // for actionBook
+	Move   string        // for actionPremove, UCI
+	GoCmd  string        // for actionSearch
+	Wait   time.Duration // how long to wait for the search's bestmove
+	Notes  []string      // why, for the log and the move audit
+
+	// Scramble is the time scramble reflex: a ponder hit is stopped at once for its best move
+	// and no searches follow ours.
+	Scramble bool
+}
+
+// planTurn decides how to find our move. A forced premove comes first, then in a mutual time
+// scramble the ponder search or a shallow one, then an operator's think time, then the book
+// move unless it repeats a position or the book check rejected it, then the ponder search.
+// Book moves are checked by the caller, see Game.checkBookMove, which plans again with
+// BookRejected when the check fails.
+func planTurn(in turnInput) turnPlan {
+	ourTime := in.ourTime()
+	plan := turnPlan{Action: actionSearch, Wait: ourTime + engineMoveGrace}
+
+	if pm := in.Premove; pm != nil {
+		plan.Action, plan.Move = actionPremove, pm.reply
+		plan.Notes = append(plan.Notes, fmt.Sprintf("premove %s: %s", pm.reply, pm.reason))
+		return plan
+	}
+
+	if in.Scramble > 0 {
+		plan.Scramble = true
+		if in.PonderHit {
+			plan.Action = actionPonderHit
+			plan.Notes = append(plan.Notes, "time scramble: ponder hit, its move at once")
+		} else {
+			plan.GoCmd = fmt.Sprintf("go depth %d", in.Scramble)
+			plan.Notes = append(plan.Notes, fmt.Sprintf("time scramble: depth %d", in.Scramble))
+		}
+		return plan
+	}
+
+	if think := in.OperatorThink; think > 0 {
+		if think > ourTime/2 {
+			think = ourTime / 2
+		}
+		plan.GoCmd = fmt.Sprintf("go movetime %d", think.Milliseconds())
+		plan.Notes = append(plan.Notes, fmt.Sprintf("operator intervention: think %v", think))
+		return plan
+	}
+
+	if in.Book != nil {
+		switch {
+		case in.BookRepeats:
+			plan.Notes = append(plan.Notes, fmt.Sprintf("book move %s repeats a position", in.Book.UCI))
+		case !in.BookRejected:
+			plan.Action, plan.Book = actionBook, *in.Book
+			return plan
+		}
+	}
+
+	if in.PonderHit && !in.BookRejected {
+		plan.Action = actionPonderHit
+		return plan
+	}
+
+	plan.GoCmd = "go " + in.Clock.GoTimes(in.WhiteTime, in.BlackTime, in.Color) + in.GoLimits
+	return plan
+}
+
+// sendInput is what's known once our move is found.
+type sendInput struct {
+	Board         fen.Board
+	OurTime       time.Duration
+	OpponentTime  time.Duration
+	Clock         Clock
+	ZeroEvalMoves int           // our moves in a row with a drawn eval
+	AboutToMate   bool          // see Game.setEval
+	CanGiveTime   bool          // lichess lets us give the opponent time
+	Elapsed       time.Duration // since the game state arrived
+	Premove       bool          // the move is a premove, sent at once
+}
+
+// sendPlan is how to send our move.
+type sendPlan struct {
+	OfferDraw bool
+	Delay     time.Duration // to wait before sending it
+	GiveTime  int           // seconds to give the opponent first, 0 for none
+}
+
+// minMoveTime is the least time our moves take with an increment and time to spare.
+const minMoveTime = 400 * time.Millisecond
+
+// planSend decides how to send our move. A draw is offered in a long equal game with an
+// increment, unless we can flag the opponent. When we're about to mate an opponent who's
+// about to lose on time, they get half of our extra time so the game ends on the board. A
+// premove is sent at once.
+func planSend(in sendInput) sendPlan {
+	var plan sendPlan
+
+	goForDirtyFlag := in.OurTime > in.OpponentTime && in.OpponentTime < 5*time.Second || in.OurTime > in.OpponentTime*3/2
+	gameIsEqual := in.ZeroEvalMoves > 12 && in.Board.FullMove > 40 && in.Board.HalfmoveClock > 4
+	plan.OfferDraw = gameIsEqual && in.Clock.HasIncrement() && !goForDirtyFlag
+
+	if in.Clock.HasIncrement() && in.OurTime >= 30*time.Second && in.Elapsed < minMoveTime && !in.Premove {
+		plan.Delay = minMoveTime - in.Elapsed
+	}
+
+	if in.OurTime > in.OpponentTime && in.OpponentTime < 1*time.Second && in.AboutToMate && in.CanGiveTime {
+		plan.GiveTime = int((in.OurTime - in.OpponentTime) / 2 / time.Second)
+	}
+
+	return plan
+}
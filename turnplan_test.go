@@ -0,0 +1,157 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"trollfish-lichess/fen"
+)
+
+func TestPlanTurn(t *testing.T) {
+	book := &WeightedMove{UCI: "e2e4", CP: 30, HasEval: true}
+	clock := Clock{Type: ClockFischer, Increment: 2 * time.Second}
+
+	cases := []struct {
+		name  string
+		in    turnInput
+		want  turnAction
+		goCmd string
+		notes int
+	}{
+		{name: "book move", in: turnInput{Book: book, PonderHit: true}, want: actionBook},
+		{name: "book move repeats", in: turnInput{Book: book, BookRepeats: true}, want: actionSearch, goCmd: "go wtime 60000 winc 2000 btime 50000 binc 2000", notes: 1},
+		{name: "book move repeats, ponder hit", in: turnInput{Book: book, BookRepeats: true, PonderHit: true}, want: actionPonderHit, notes: 1},
+		{name: "book move rejected stops pondering", in: turnInput{Book: book, BookRejected: true, PonderHit: true}, want: actionSearch, goCmd: "go wtime 60000 winc 2000 btime 50000 binc 2000"},
+		{name: "ponder hit", in: turnInput{PonderHit: true}, want: actionPonderHit},
+		{name: "search with limits", in: turnInput{GoLimits: " depth 8"}, want: actionSearch, goCmd: "go wtime 60000 winc 2000 btime 50000 binc 2000 depth 8"},
+		{name: "operator", in: turnInput{Book: book, PonderHit: true, OperatorThink: 10 * time.Second}, want: actionSearch, goCmd: "go movetime 10000", notes: 1},
+		{name: "premove", in: turnInput{Book: book, PonderHit: true, OperatorThink: time.Second, Premove: &premove{reply: "e7e5", reason: "only legal move"}}, want: actionPremove, notes: 1},
+		{name: "operator, half our time at most", in: turnInput{OperatorThink: time.Hour}, want: actionSearch, goCmd: "go movetime 30000", notes: 1},
+		{name: "scramble", in: turnInput{Book: book, OperatorThink: time.Second, Scramble: 3}, want: actionSearch, goCmd: "go depth 3", notes: 1},
+		{name: "scramble, ponder hit", in: turnInput{PonderHit: true, Scramble: 3}, want: actionPonderHit, notes: 1},
+		{name: "scramble, premove first", in: turnInput{Scramble: 3, Premove: &premove{reply: "e7e5", reason: "only legal move"}}, want: actionPremove, notes: 1},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			in := c.in
+			in.Color, in.WhiteTime, in.BlackTime, in.Clock = fen.WhitePieces, 60000, 50000, clock
+
+			// act
+			plan := planTurn(in)
+
+			// assert
+			if plan.Action != c.want || plan.GoCmd != c.goCmd || len(plan.Notes) != c.notes {
+				t.Errorf("got %v '%s' %v, want %v '%s' and %d note(s)", plan.Action, plan.GoCmd, plan.Notes, c.want, c.goCmd, c.notes)
+			}
+			if plan.Scramble != (c.in.Scramble > 0 && plan.Action != actionPremove) {
+				t.Errorf("scramble: got %v", plan.Scramble)
+			}
+			if plan.Action == actionPremove && plan.Move != "e7e5" {
+				t.Errorf("premove: got %s, want e7e5", plan.Move)
+			}
+			if plan.Action == actionBook && plan.Book.UCI != book.UCI {
+				t.Errorf("book move: got %s, want %s", plan.Book.UCI, book.UCI)
+			}
+			if plan.Wait != time.Minute+engineMoveGrace {
+				t.Errorf("wait: got %v, want our time and the grace", plan.Wait)
+			}
+		})
+	}
+}
+
+func TestScramble_Applies(t *testing.T) {
+	scramble := Scramble{Below: 2 * time.Second, Depth: 3}
+	blitz := Clock{Type: ClockFischer}
+
+	cases := []struct {
+		name         string
+		scramble     Scramble
+		clock        Clock
+		ourTime      time.Duration
+		opponentTime time.Duration
+		want         bool
+	}{
+		{name: "both low", scramble: scramble, clock: blitz, ourTime: 1500 * time.Millisecond, opponentTime: time.Second, want: true},
+		{name: "opponent has time", scramble: scramble, clock: blitz, ourTime: time.Second, opponentTime: 10 * time.Second},
+		{name: "we have time", scramble: scramble, clock: blitz, ourTime: 3 * time.Second, opponentTime: time.Second},
+		{name: "off", clock: blitz, ourTime: time.Second, opponentTime: time.Second},
+		{name: "no clock", scramble: scramble, clock: Clock{Type: ClockNone}, ourTime: time.Second, opponentTime: time.Second},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// act
+			got := c.scramble.applies(c.clock, c.ourTime, c.opponentTime)
+
+			// assert
+			if got != c.want {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestPlanSend(t *testing.T) {
+	equal := fen.FENtoBoard("8/5k2/8/8/8/8/5K2/8 w - - 10 60")
+	increment := Clock{Type: ClockFischer, Increment: 2 * time.Second}
+
+	cases := []struct {
+		name string
+		in   sendInput
+		want sendPlan
+	}{
+		{
+			name: "offer a draw",
+			in:   sendInput{Board: equal, OurTime: time.Minute, OpponentTime: time.Minute, Clock: increment, ZeroEvalMoves: 13, Elapsed: time.Second},
+			want: sendPlan{OfferDraw: true},
+		},
+		{
+			name: "no draw while flagging",
+			in:   sendInput{Board: equal, OurTime: 2 * time.Minute, OpponentTime: time.Minute, Clock: increment, ZeroEvalMoves: 13, Elapsed: time.Second},
+		},
+		{
+			name: "no draw without an increment",
+			in:   sendInput{Board: equal, OurTime: time.Minute, OpponentTime: time.Minute, ZeroEvalMoves: 13},
+		},
+		{
+			name: "not equal long enough",
+			in:   sendInput{Board: equal, OurTime: time.Minute, OpponentTime: time.Minute, Clock: increment, ZeroEvalMoves: 12, Elapsed: time.Second},
+		},
+		{
+			name: "minimum move time",
+			in:   sendInput{OurTime: time.Minute, OpponentTime: time.Minute, Clock: increment, Elapsed: 100 * time.Millisecond},
+			want: sendPlan{Delay: 300 * time.Millisecond},
+		},
+		{
+			name: "no delay when short of time",
+			in:   sendInput{OurTime: 10 * time.Second, OpponentTime: time.Minute, Clock: increment},
+		},
+		{
+			name: "no delay for a premove",
+			in:   sendInput{OurTime: time.Minute, OpponentTime: time.Minute, Clock: increment, Elapsed: time.Millisecond, Premove: true},
+		},
+		{
+			name: "give time before mating",
+			in:   sendInput{OurTime: 21 * time.Second, OpponentTime: 500 * time.Millisecond, AboutToMate: true, CanGiveTime: true},
+			want: sendPlan{GiveTime: 10},
+		},
+		{
+			name: "can't give time",
+			in:   sendInput{OurTime: 21 * time.Second, OpponentTime: 500 * time.Millisecond, AboutToMate: true},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// act
+			got := planSend(c.in)
+
+			// assert
+			if got != c.want {
+				t.Errorf("got %+v, want %+v", got, c.want)
+			}
+		})
+	}
+}
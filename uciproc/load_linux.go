@@ -0,0 +1,57 @@
+package uciproc
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LoadPerCPU returns the 1-minute load average from /proc/loadavg over the CPU count, above
+// 1 when more threads want to run than there are CPUs, or 0 when it can't be read.
+func LoadPerCPU() float64 {
+	b, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0
+	}
+	fields := strings.Fields(string(b)) // 0.52 0.58 0.59 1/467 12345
+	if len(fields) == 0 {
+		return 0
+	}
+	load, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0
+	}
+	return load / float64(runtime.NumCPU())
+}
+
+// userHZ is the clock tick of /proc/<pid>/stat's times, 100 on every Linux.
+const userHZ = 100
+
+// ProcessCPU returns the user and system CPU time process pid has used, all of its threads,
+// or 0 when it can't be read.
+func ProcessCPU(pid int) time.Duration {
+	if pid <= 0 {
+		return 0
+	}
+	b, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return 0
+	}
+	// 12345 (trollfish) S 1 ... utime stime, the name may have spaces and parentheses
+	i := strings.LastIndexByte(string(b), ')')
+	if i < 0 {
+		return 0
+	}
+	fields := strings.Fields(string(b[i+1:]))
+	if len(fields) < 13 {
+		return 0
+	}
+	utime, err1 := strconv.ParseInt(fields[11], 10, 64)
+	stime, err2 := strconv.ParseInt(fields[12], 10, 64)
+	if err1 != nil || err2 != nil {
+		return 0
+	}
+	return time.Duration(utime+stime) * time.Second / userHZ
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package uciproc
+
+import "time"
+
+func LoadPerCPU() float64 {
+	return 0
+}
+
+func ProcessCPU(pid int) time.Duration {
+	return 0
+}
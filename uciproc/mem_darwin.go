@@ -0,0 +1,19 @@
+package uciproc
+
+import (
+	"encoding/binary"
+	"syscall"
+)
+
+// memoryMB returns physical memory from the hw.memsize sysctl.
+func memoryMB() int {
+	s, err := syscall.Sysctl("hw.memsize")
+	if err != nil {
+		return 0
+	}
+
+	// Sysctl returns the raw little-endian uint64 with any trailing zero byte trimmed
+	b := make([]byte, 8)
+	copy(b, s)
+	return int(binary.LittleEndian.Uint64(b) / 1024 / 1024)
+}
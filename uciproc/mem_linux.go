@@ -0,0 +1,36 @@
+package uciproc
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// memoryMB returns MemAvailable from /proc/meminfo, or MemTotal on old kernels.
+func memoryMB() int {
+	fp, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer fp.Close()
+
+	values := make(map[string]int)
+	r := bufio.NewScanner(fp)
+	for r.Scan() {
+		fields := strings.Fields(r.Text()) // MemAvailable:   12345678 kB
+		if len(fields) < 2 {
+			continue
+		}
+		kb, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+		values[strings.TrimSuffix(fields[0], ":")] = kb
+	}
+
+	if kb, ok := values["MemAvailable"]; ok {
+		return kb / 1024
+	}
+	return values["MemTotal"] / 1024
+}
@@ -0,0 +1,7 @@
+//go:build !linux && !darwin && !windows
+
+package uciproc
+
+func memoryMB() int {
+	return 0
+}
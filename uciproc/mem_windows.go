@@ -0,0 +1,31 @@
+package uciproc
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// memoryStatusEx is MEMORYSTATUSEX.
+type memoryStatusEx struct {
+	length               uint32
+	memoryLoad           uint32
+	totalPhys            uint64
+	availPhys            uint64
+	totalPageFile        uint64
+	availPageFile        uint64
+	totalVirtual         uint64
+	availVirtual         uint64
+	availExtendedVirtual uint64
+}
+
+var globalMemoryStatusEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GlobalMemoryStatusEx")
+
+// memoryMB returns available physical memory from GlobalMemoryStatusEx.
+func memoryMB() int {
+	var status memoryStatusEx
+	status.length = uint32(unsafe.Sizeof(status))
+	if ok, _, _ := globalMemoryStatusEx.Call(uintptr(unsafe.Pointer(&status))); ok == 0 {
+		return 0
+	}
+	return int(status.availPhys / 1024 / 1024)
+}
@@ -0,0 +1,97 @@
+package uciproc
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Mode is what an engine is being run for, which decides how much of the machine it gets.
+type Mode int
+
+const (
+	// Bot plays games: it leaves room for pondering, the OS and lichess I/O.
+	Bot Mode = iota
+	// Analysis runs batch jobs and may use most of the machine.
+	Analysis
+)
+
+const (
+	minHashMB = 16
+	maxHashMB = 128 * 1024
+)
+
+// Resources are the engine's Threads and Hash (MB) options.
+type Resources struct {
+	Threads int
+	HashMB  int
+}
+
+// Auto sizes Threads and Hash from the CPU count and physical memory. If memory can't be
+// read on this platform, Hash falls back to 256 MB.
+func Auto(mode Mode) Resources {
+	return autoResources(mode, runtime.NumCPU(), memoryMB())
+}
+
+func autoResources(mode Mode, cpus, memMB int) Resources {
+	var r Resources
+	switch mode {
+	case Analysis:
+		r.Threads = cpus - 1
+		r.HashMB = memMB / 2
+	default:
+		r.Threads = cpus / 2
+		r.HashMB = memMB / 8
+		if r.HashMB > 4096 {
+			r.HashMB = 4096
+		}
+	}
+
+	if r.Threads < 1 {
+		r.Threads = 1
+	}
+	if memMB <= 0 {
+		r.HashMB = 256
+	}
+	r.HashMB = clampHash(r.HashMB)
+
+	return r
+}
+
+// Override replaces Threads and Hash with any values greater than 0.
+func (r Resources) Override(threads, hashMB int) Resources {
+	if threads > 0 {
+		r.Threads = threads
+	}
+	if hashMB > 0 {
+		r.HashMB = hashMB
+	}
+	return r
+}
+
+// Options returns the setoption commands for r.
+func (r Resources) Options() []string {
+	return []string{
+		fmt.Sprintf("setoption name Threads value %d", r.Threads),
+		fmt.Sprintf("setoption name Hash value %d", r.HashMB),
+	}
+}
+
+func (r Resources) String() string {
+	return fmt.Sprintf("threads: %d hash: %d MB", r.Threads, r.HashMB)
+}
+
+// clampHash rounds hash down to a power of two, which is what engines allocate anyway.
+func clampHash(hashMB int) int {
+	if hashMB < minHashMB {
+		return minHashMB
+	}
+	if hashMB > maxHashMB {
+		hashMB = maxHashMB
+	}
+
+	p := minHashMB
+	for p*2 <= hashMB {
+		p *= 2
+	}
+	return p
+}
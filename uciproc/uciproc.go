@@ -0,0 +1,76 @@
+// Package uciproc finds, starts and sizes UCI engine processes on Linux, macOS and Windows.
+package uciproc
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Find returns the path of an engine binary. The first match wins:
+//
+//   - override, usually from a command line flag
+//   - the environment variable env
+//   - names looked up on PATH (".exe" is added on Windows)
+//   - fallbacks, paths tried as-is
+func Find(override, env string, names []string, fallbacks ...string) (string, error) {
+	if override == "" && env != "" {
+		override = os.Getenv(env)
+	}
+
+	if override != "" {
+		path, err := exec.LookPath(override)
+		if err != nil {
+			return "", fmt.Errorf("engine '%s': %v", override, err)
+		}
+		return filepath.Abs(path)
+	}
+
+	for _, name := range names {
+		if path, err := exec.LookPath(name); err == nil {
+			return filepath.Abs(path)
+		}
+	}
+
+	for _, path := range fallbacks {
+		if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+			return path, nil
+		}
+	}
+
+	return "", fmt.Errorf("engine %s not found on PATH; set %s or pass the path on the command line", strings.Join(names, "/"), env)
+}
+
+// Command returns the command for binary, run from the binary's directory so engines that
+// load a network file by relative path find it. The engine is kept out of the console's
+// process group so Ctrl+C reaches the bot first and the engine is stopped through ctx; if
+// the bot dies instead, the engine sees its stdin close and exits.
+func Command(ctx context.Context, binary string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, binary)
+	cmd.Dir = filepath.Dir(binary)
+	detach(cmd)
+	return cmd
+}
+
+// ScanLines calls fn for each line read from r. Line endings may be LF or CRLF.
+func ScanLines(r io.Reader, fn func(line string) bool) error {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for s.Scan() {
+		if !fn(strings.TrimRight(s.Text(), "\r")) {
+			return nil
+		}
+	}
+	return s.Err()
+}
+
+// WriteLine writes a UCI command to w. UCI engines accept LF on every platform.
+func WriteLine(w io.Writer, line string) error {
+	_, err := io.WriteString(w, line+"\n")
+	return err
+}
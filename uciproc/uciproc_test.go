@@ -0,0 +1,128 @@
+package uciproc
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeExecutable(t *testing.T, dir, name string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFind(t *testing.T) {
+	// arrange
+	pathDir := t.TempDir()
+	onPath := writeExecutable(t, pathDir, "fakefish")
+	envDir := t.TempDir()
+	fromEnv := writeExecutable(t, envDir, "envfish")
+	t.Setenv("PATH", pathDir)
+	t.Setenv("FAKEFISH_ENGINE", "")
+
+	// act / assert
+	if got, err := Find("", "FAKEFISH_ENGINE", []string{"fakefish"}); err != nil || got != onPath {
+		t.Errorf("PATH: got %q %v, want %q", got, err, onPath)
+	}
+
+	t.Setenv("FAKEFISH_ENGINE", fromEnv)
+	if got, err := Find("", "FAKEFISH_ENGINE", []string{"fakefish"}); err != nil || got != fromEnv {
+		t.Errorf("env: got %q %v, want %q", got, err, fromEnv)
+	}
+
+	if got, err := Find(onPath, "FAKEFISH_ENGINE", []string{"fakefish"}); err != nil || got != onPath {
+		t.Errorf("override: got %q %v, want %q", got, err, onPath)
+	}
+
+	t.Setenv("FAKEFISH_ENGINE", "")
+	if _, err := Find("", "FAKEFISH_ENGINE", []string{"nofish"}); err == nil {
+		t.Error("missing: want error")
+	}
+	if got, err := Find("", "FAKEFISH_ENGINE", []string{"nofish"}, fromEnv); err != nil || got != fromEnv {
+		t.Errorf("fallback: got %q %v, want %q", got, err, fromEnv)
+	}
+}
+
+func TestScanLines(t *testing.T) {
+	// arrange
+	r := strings.NewReader("uciok\r\nreadyok\nbestmove e2e4 ponder e7e5\r\n")
+
+	// act
+	var lines []string
+	err := ScanLines(r, func(line string) bool {
+		lines = append(lines, line)
+		return true
+	})
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"uciok", "readyok", "bestmove e2e4 ponder e7e5"}
+	if strings.Join(lines, "|") != strings.Join(want, "|") {
+		t.Errorf("got %q, want %q", lines, want)
+	}
+}
+
+func TestAutoResources(t *testing.T) {
+	// arrange
+	cases := []struct {
+		mode  Mode
+		cpus  int
+		memMB int
+		want  Resources
+	}{
+		{Analysis, 32, 128 * 1024, Resources{Threads: 31, HashMB: 64 * 1024}},
+		{Bot, 32, 128 * 1024, Resources{Threads: 16, HashMB: 4096}},
+		{Bot, 4, 6000, Resources{Threads: 2, HashMB: 512}},
+		{Analysis, 1, 0, Resources{Threads: 1, HashMB: 256}},
+		{Bot, 1, 64, Resources{Threads: 1, HashMB: 16}},
+	}
+
+	for _, c := range cases {
+		// act
+		got := autoResources(c.mode, c.cpus, c.memMB)
+
+		// assert
+		if got != c.want {
+			t.Errorf("mode %d cpus %d mem %d: got %v, want %v", c.mode, c.cpus, c.memMB, got, c.want)
+		}
+	}
+
+	if got := (Resources{Threads: 4, HashMB: 256}).Override(0, 1024); got != (Resources{Threads: 4, HashMB: 1024}) {
+		t.Errorf("override: got %v", got)
+	}
+}
+
+func TestProcessCPU(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("ProcessCPU reads /proc")
+	}
+
+	// arrange
+	deadline := time.Now().Add(100 * time.Millisecond)
+	for time.Now().Before(deadline) {
+	}
+
+	// act
+	got := ProcessCPU(os.Getpid())
+	missing := ProcessCPU(0)
+
+	// assert
+	if got <= 0 {
+		t.Errorf("got %v after busy work, want more than 0", got)
+	}
+	if missing != 0 {
+		t.Errorf("pid 0: got %v, want 0", missing)
+	}
+}
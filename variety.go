@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"trollfish-lichess/fen"
+	"trollfish-lichess/storage"
+	"trollfish-lichess/yamlbook"
+)
+
+const varietyFilename = "variety.json"
+
+// Variety remembers the opening plies of our most recent games so the book can steer
+// away from lines an opponent may have prepared against.
+type Variety struct {
+	mtx sync.Mutex
+
+	store    storage.Storage
+	filename string
+	plies    int
+	games    int
+
+	Recent []VarietyLine `json:"recent"`
+}
+
+type VarietyLine struct {
+	GameID string   `json:"id"`
+	Color  string   `json:"color"`
+	Moves  []string `json:"moves"`
+	TS     int64    `json:"ts"`
+}
+
+// LoadVariety loads the recent lines from filename in store. plies is the number of opening plies
+// tracked per game and games is how many games are remembered. If either is 0 the
+// controller is disabled and nil is returned.
+func LoadVariety(store storage.Storage, filename string, plies, games int) (*Variety, error) {
+	if plies <= 0 || games <= 0 {
+		return nil, nil
+	}
+
+	v := Variety{
+		store:    store,
+		filename: filename,
+		plies:    plies,
+		games:    games,
+	}
+
+	b, err := store.ReadFile(filename)
+	if err == nil {
+		if err := json.Unmarshal(b, &v); err != nil {
+			return nil, fmt.Errorf("'%s': %v", filename, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("'%s': %v", filename, err)
+	}
+
+	return &v, nil
+}
+
+// Bias returns a yamlbook.MoveBias for the position reached after sans (the game moves so far,
+// in SAN). Each candidate move is scored by how many of the last K games played as color
+// followed the same line. Outside the first N plies the bias is nil.
+func (v *Variety) Bias(color fen.Color, sans []string) yamlbook.MoveBias {
+	if v == nil || len(sans) >= v.plies {
+		return nil
+	}
+
+	return func(move *yamlbook.Move) int {
+		line := append(append(make([]string, 0, len(sans)+1), sans...), move.Move)
+		return v.count(color, line)
+	}
+}
+
+func (v *Variety) count(color fen.Color, line []string) int {
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+
+	var count int
+	for _, recent := range v.Recent {
+		if recent.Color != color.String() || len(recent.Moves) < len(line) {
+			continue
+		}
+
+		if strings.Join(recent.Moves[:len(line)], " ") == strings.Join(line, " ") {
+			count++
+		}
+	}
+	return count
+}
+
+// Record saves the first N plies of a finished game and forgets games older than the last K.
+func (v *Variety) Record(gameID string, color fen.Color, sans []string) error {
+	if v == nil || len(sans) == 0 {
+		return nil
+	}
+
+	if len(sans) > v.plies {
+		sans = sans[:v.plies]
+	}
+
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+
+	v.Recent = append(v.Recent, VarietyLine{
+		GameID: gameID,
+		Color:  color.String(),
+		Moves:  sans,
+		TS:     time.Now().Unix(),
+	})
+
+	if len(v.Recent) > v.games {
+		v.Recent = v.Recent[len(v.Recent)-v.games:]
+	}
+
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := v.store.WriteFile(v.filename, b); err != nil {
+		return fmt.Errorf("write file '%s': %v", v.filename, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"trollfish-lichess/analyze"
+	"trollfish-lichess/uciproc"
+)
+
+// watchdogInterval is how often the watchdog checks the streams and the engine.
+const watchdogInterval = 10 * time.Second
+
+// Watchdog restarts what stopped talking to us. Lichess sends keep-alive lines on both
+// streams every few seconds, so a silent stream is a dead connection. Zero turns a check off.
+type Watchdog struct {
+	Events time.Duration // the event stream without a line
+	Game   time.Duration // the active game's stream without a line
+	Engine time.Duration // the engine not answering isready
+}
+
+func (w Watchdog) Enabled() bool {
+	return w.Events > 0 || w.Game > 0 || w.Engine > 0
+}
+
+// streamWatch is a stream's connection, see readStreamRetrying. Its methods are nil-safe.
+type streamWatch struct {
+	mtx       sync.Mutex
+	cancel    context.CancelFunc // the current connection, nil between connections
+	isUp      bool
+	lastLine  time.Time
+	restarted int
+}
+
+// streamStatus is a stream's connection for /healthz.
+type streamStatus struct {
+	Connected bool      `json:"connected"`
+	LastLine  time.Time `json:"last_line"`
+	Restarts  int       `json:"restarts"` // by the watchdog
+}
+
+func (w *streamWatch) connecting(cancel context.CancelFunc) {
+	if w == nil {
+		return
+	}
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.cancel = cancel
+}
+
+func (w *streamWatch) connected() {
+	if w == nil {
+		return
+	}
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.isUp, w.lastLine = true, time.Now()
+}
+
+func (w *streamWatch) line() {
+	if w == nil {
+		return
+	}
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.lastLine = time.Now()
+}
+
+func (w *streamWatch) disconnected() {
+	if w == nil {
+		return
+	}
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.isUp, w.cancel = false, nil
+}
+
+// silent returns how long a connected stream has gone without a line, false when it's
+// not connected.
+func (w *streamWatch) silent(now time.Time) (time.Duration, bool) {
+	if w == nil {
+		return 0, false
+	}
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return now.Sub(w.lastLine), w.isUp
+}
+
+// restart drops the connection, readStreamRetrying reconnects.
+func (w *streamWatch) restart() {
+	if w == nil {
+		return
+	}
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.cancel != nil {
+		w.cancel()
+		w.restarted++
+	}
+}
+
+func (w *streamWatch) status() streamStatus {
+	if w == nil {
+		return streamStatus{}
+	}
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return streamStatus{Connected: w.isUp, LastLine: w.lastLine, Restarts: w.restarted}
+}
+
+// engineProcess runs trollfish on the same input and output channels across restarts, so
+// the Engine reading them carries on with the new process.
+type engineProcess struct {
+	ctx    context.Context
+	path   string
+	input  chan string
+	output chan string
+
+	kill context.CancelFunc
+	pid  int64 // read by cpu from other goroutines
+}
+
+func (p *engineProcess) start() error {
+	ctx, kill := context.WithCancel(p.ctx)
+	process, err := startTrollFish(ctx, p.path, p.input, p.output)
+	if err != nil {
+		kill()
+		return err
+	}
+	p.kill = kill
+	atomic.StoreInt64(&p.pid, int64(process.Pid))
+	return nil
+}
+
+// cpu returns the CPU time the engine process has used, see uciproc.ProcessCPU.
+func (p *engineProcess) cpu() time.Duration {
+	return uciproc.ProcessCPU(int(atomic.LoadInt64(&p.pid)))
+}
+
+// restart kills the engine and starts a new one, see Listener.newEngineProcess.
+func (p *engineProcess) restart() error {
+	if p.kill != nil {
+		p.kill()
+	}
+	return p.start()
+}
+
+// initEngine sends the options the engine keeps for the whole session.
+func (l *Listener) initEngine() error {
+	cmds := append([]string{"uci", "setoption name Ponder value true"}, l.resources.Options()...)
+	if analyze.SyzygyPath != "" {
+		cmds = append(cmds, fmt.Sprintf("setoption name SyzygyPath value %s", analyze.SyzygyPath))
+	}
+	return l.engine.Send(cmds...)
+}
+
+// runWatchdog checks the streams and the engine every watchdogInterval until the
+// listener's context is done.
+func (l *Listener) runWatchdog(w Watchdog) {
+	fmt.Printf("%s watchdog: events %v, game %v, engine %v\n", ts(), w.Events, w.Game, w.Engine)
+
+	ticker := time.NewTicker(watchdogInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.ctx.Done():
+			return
+		case <-ticker.C:
+			l.watchdogCheck(w, time.Now())
+		}
+	}
+}
+
+func (l *Listener) watchdogCheck(w Watchdog, now time.Time) {
+	if silent, ok := l.eventStream.silent(now); ok && w.Events > 0 && silent > w.Events {
+		fmt.Printf("%s *** watchdog: no event stream line for %v, reconnecting\n", ts(), silent.Round(time.Second))
+		l.eventStream.restart()
+	}
+
+	if g := l.ActiveGame(); g != nil {
+		if silent, ok := g.stream.silent(now); ok && w.Game > 0 && silent > w.Game {
+			fmt.Printf("%s *** watchdog: no game stream line for %v, reconnecting '%s'\n", ts(), silent.Round(time.Second), g.gameID)
+			g.stream.restart()
+		}
+	}
+
+	if w.Engine <= 0 {
+		return
+	}
+	err := l.engine.WaitReady(l.ctx, w.Engine)
+	if l.ctx.Err() != nil {
+		return
+	}
+	l.healthMtx.Lock()
+	l.engineErr, l.engineChecked = err, time.Now()
+	l.healthMtx.Unlock()
+	if err == nil {
+		return
+	}
+
+	fmt.Printf("%s *** watchdog: engine didn't answer isready within %v, restarting it\n", ts(), w.Engine)
+	l.restartEngine()
+}
+
+func (l *Listener) restartEngine() {
+	if err := l.newEngineProcess(); err != nil {
+		fmt.Printf("%s *** ERR: watchdog: %v\n", ts(), err)
+		return
+	}
+	if g := l.ActiveGame(); g != nil {
+		options := g.EngineOptions()
+		fmt.Printf("%s *** watchdog: engine restarted during '%s', sending the game's %d engine option(s) again\n", ts(), g.gameID, len(options))
+		if err := l.engine.Send(options...); err != nil {
+			fmt.Printf("%s *** ERR: watchdog: %v\n", ts(), err)
+		}
+	}
+
+	l.healthMtx.Lock()
+	l.engineErr = nil
+	l.engineRestarts++
+	l.healthMtx.Unlock()
+}
+
+// newEngineProcess replaces the engine process and sends it the session's options. The
+// Engine forgets what it was waiting for from the old one.
+func (l *Listener) newEngineProcess() error {
+	l.engineRestartMtx.Lock()
+	defer l.engineRestartMtx.Unlock()
+
+	if l.engineRestart == nil {
+		return errors.New("the engine can't be restarted")
+	}
+	if err := l.engineRestart(); err != nil {
+		return fmt.Errorf("restart engine: %v", err)
+	}
+
+	l.engine.Reset()
+	return l.initEngine()
+}
+
+// freshEngine starts a new engine process after every freshEngineGames games, so the next
+// game starts with an empty hash and memory doesn't grow over a long session. The engine
+// is warmed up (options set, hash allocated) before the listener takes the next game.
+// Called on the event stream when a game finishes.
+func (l *Listener) freshEngine() {
+	if l.freshEngineGames <= 0 {
+		return
+	}
+	if l.gamesOnEngine++; l.gamesOnEngine < l.freshEngineGames {
+		return
+	}
+	l.gamesOnEngine = 0
+
+	start := time.Now()
+	if err := l.newEngineProcess(); err != nil {
+		fmt.Printf("%s *** ERR: fresh engine: %v\n", ts(), err)
+		return
+	}
+	if err := l.engine.WaitReady(l.ctx, engineReadyTimeout); err != nil {
+		fmt.Printf("%s *** ERR: fresh engine: %v\n", ts(), err)
+		return
+	}
+	fmt.Printf("%s fresh engine ready in %v\n", ts(), time.Since(start).Round(time.Millisecond))
+}
+
+// healthStatus is the bot's health for /healthz.
+type healthStatus struct {
+	OK            bool         `json:"ok"`
+	Events        streamStatus `json:"events"`
+	DegradedSince *time.Time   `json:"degraded_since,omitempty"`
+	Engine        engineStatus `json:"engine"`
+	Game          *gameHealth  `json:"game,omitempty"`
+}
+
+type engineStatus struct {
+	Alive      bool      `json:"alive"` // answered the watchdog's last isready
+	Checked    time.Time `json:"checked"`
+	LastOutput time.Time `json:"last_output"`
+	Restarts   int       `json:"restarts"`
+	Error      string    `json:"error,omitempty"`
+}
+
+type gameHealth struct {
+	ID     string       `json:"id"`
+	State  string       `json:"state"`
+	Stream streamStatus `json:"stream"`
+}
+
+// Health returns the streams', engine's and active game's state. The bot is OK while the
+// event stream is connected, lichess isn't degraded and the engine is alive.
+func (l *Listener) Health() healthStatus {
+	h := healthStatus{Events: l.eventStream.status()}
+
+	if since, ok := l.Degraded(); ok {
+		h.DegradedSince = &since
+	}
+
+	l.healthMtx.Lock()
+	h.Engine = engineStatus{Alive: l.engineErr == nil, Checked: l.engineChecked, Restarts: l.engineRestarts}
+	if l.engineErr != nil {
+		h.Engine.Error = l.engineErr.Error()
+	}
+	l.healthMtx.Unlock()
+	if l.engine != nil {
+		h.Engine.LastOutput = l.engine.LastOutput()
+	}
+
+	if g := l.ActiveGame(); g != nil {
+		h.Game = &gameHealth{ID: g.gameID, State: g.State().String(), Stream: g.stream.status()}
+	}
+
+	h.OK = h.Events.Connected && h.DegradedSince == nil && h.Engine.Alive
+	return h
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestListener_WatchdogCheck(t *testing.T) {
+	// arrange
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// nothing reads input until the engine is restarted
+	input := make(chan string, 64)
+	output := make(chan string, 64)
+	l := &Listener{ctx: ctx, engine: NewEngine(ctx, input, output)}
+	var restarts int
+	l.engineRestart = func() error {
+		restarts++
+		go func() {
+			for cmd := range input {
+				if cmd == "isready" {
+					output <- "readyok"
+				}
+			}
+		}()
+		return nil
+	}
+
+	var dropped bool
+	l.eventStream.connecting(func() { dropped = true })
+	l.eventStream.connected()
+	w := Watchdog{Events: time.Minute, Engine: 50 * time.Millisecond}
+
+	// act
+	l.watchdogCheck(w, time.Now())
+	quiet := dropped
+	l.watchdogCheck(w, time.Now().Add(2*time.Minute))
+	afterRestart := l.Health()
+
+	// assert
+	if quiet {
+		t.Error("event stream dropped before the window")
+	}
+	if !dropped || afterRestart.Events.Restarts != 1 {
+		t.Errorf("event stream: got dropped %v, %d restarts", dropped, afterRestart.Events.Restarts)
+	}
+	if restarts != 1 || afterRestart.Engine.Restarts != 1 || !afterRestart.Engine.Alive {
+		t.Errorf("engine: got %d restarts, health %+v", restarts, afterRestart.Engine)
+	}
+	if err := l.engine.WaitReady(ctx, time.Second); err != nil {
+		t.Errorf("restarted engine: %v", err)
+	}
+}
+
+func TestListener_RestartEngine_GameOptions(t *testing.T) {
+	// arrange
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	input := make(chan string, 64)
+	l := &Listener{ctx: ctx, engine: NewEngine(ctx, input, make(chan string))}
+	l.engineRestart = func() error { return nil }
+	l.activeGame = &Game{gameID: "abcd1234", engineOptions: []string{"setoption name StartAgro value true"}}
+
+	// act
+	l.restartEngine()
+	close(input)
+
+	// assert
+	var sent []string
+	for cmd := range input {
+		sent = append(sent, cmd)
+	}
+	if len(sent) == 0 || sent[len(sent)-1] != "setoption name StartAgro value true" {
+		t.Errorf("got %q, want the game's options after the session's", sent)
+	}
+}
+
+func TestListener_FreshEngine(t *testing.T) {
+	// arrange
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	l := &Listener{ctx: ctx, engine: fakeEngine(ctx, nil), freshEngineGames: 2}
+	var restarts int
+	l.engineRestart = func() error {
+		restarts++
+		return nil
+	}
+
+	// act
+	var got []int
+	for i := 0; i < 5; i++ {
+		l.freshEngine()
+		got = append(got, restarts)
+	}
+
+	// assert
+	want := []int{0, 1, 1, 2, 2}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("restarts after each game: got %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+// Package wdl converts engine evals to win/draw/loss probabilities.
+package wdl
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"trollfish-lichess/fen"
+)
+
+// Model is an eval to WDL conversion.
+type Model string
+
+const (
+	// Lichess is lichess' winning chances, a logistic on centipawns with no draws. It's
+	// what lichess uses to annotate inaccuracies, mistakes and blunders.
+	Lichess Model = "lichess"
+	// LichessV2 is the flatter logistic of lichess' accuracy, fitted to its games: the same
+	// eval is a little less winning than with Lichess.
+	LichessV2 Model = "lichess-v2"
+	// Centipawns is linear in centipawns, no draws: 1000 cp or more is won. Losing 0.1 is
+	// losing 100 cp, the raw thresholds of older annotators.
+	Centipawns Model = "cp"
+	// Stockfish is Stockfish's win rate model for normalized evals, where 100 cp is a 50%
+	// chance of a win. How fast the chances change with the eval, and so the draw rate,
+	// depends on the material left on the board. It's the WDL Stockfish reports with
+	// UCI_ShowWDL, Parse also takes it as "engine".
+	Stockfish Model = "stockfish"
+)
+
+// Models are the names Parse takes.
+const Models = "lichess, lichess-v2, cp, stockfish (or engine)"
+
+// Default is the model used by the bot and the analyzer when ByRating has no band for
+// the players.
+var Default = Lichess
+
+// ByRating are the models for rating bands, see ParseBands and ForRating.
+var ByRating Bands
+
+// Parse returns the model named s.
+func Parse(s string) (Model, error) {
+	switch m := Model(strings.TrimSpace(s)); m {
+	case Lichess, LichessV2, Centipawns, Stockfish:
+		return m, nil
+	case "engine":
+		return Stockfish, nil
+	}
+	return "", fmt.Errorf("unknown WDL model '%s', want %s", s, Models)
+}
+
+// Band is the model for players rated From or more, up to the next band.
+type Band struct {
+	From  int
+	Model Model
+}
+
+// Bands are models by rating, ordered by From.
+type Bands []Band
+
+// ParseBands reads a model, or models by rating band: the model for unrated players and
+// the lowest ratings, then rating:model for the bands from rating up, e.g.
+// "lichess,2000:lichess-v2,2400:stockfish".
+func ParseBands(s string) (Bands, error) {
+	var bands Bands
+	for i, part := range strings.Split(s, ",") {
+		ratingText, name, hasRating := strings.Cut(part, ":")
+		if !hasRating {
+			name, ratingText = ratingText, "0"
+		}
+		if hasRating == (i == 0) {
+			return nil, fmt.Errorf("'%s': want a model then rating:model bands, e.g. lichess,2000:stockfish", s)
+		}
+
+		rating, err := strconv.Atoi(strings.TrimSpace(ratingText))
+		if err != nil {
+			return nil, fmt.Errorf("'%s': %v", s, err)
+		}
+		model, err := Parse(name)
+		if err != nil {
+			return nil, err
+		}
+		bands = append(bands, Band{From: rating, Model: model})
+	}
+
+	sort.SliceStable(bands, func(i, j int) bool { return bands[i].From < bands[j].From })
+	return bands, nil
+}
+
+// For returns the model of rating's band, the first band's for unrated (0) players.
+func (b Bands) For(rating int) Model {
+	if len(b) == 0 {
+		return Default
+	}
+	model := b[0].Model
+	for _, band := range b[1:] {
+		if rating < band.From {
+			break
+		}
+		model = band.Model
+	}
+	return model
+}
+
+// ForRating returns the model of ByRating for players rated rating, Default without bands.
+func ForRating(rating int) Model {
+	return ByRating.For(rating)
+}
+
+// WDL are the chances of a win, draw and loss, from the eval's point of view. They add up to 1.
+type WDL struct {
+	Win  float64
+	Draw float64
+	Loss float64
+}
+
+// Chances returns the winning chances between -1 (lost) and 1 (won).
+func (w WDL) Chances() float64 {
+	return w.Win - w.Loss
+}
+
+// Score returns the expected score between 0 and 1.
+func (w WDL) Score() float64 {
+	return w.Win + w.Draw/2
+}
+
+func (w WDL) String() string {
+	return fmt.Sprintf("%.0f/%.0f/%.0f", w.Win*1000, w.Draw*1000, w.Loss*1000)
+}
+
+// FromCP converts a centipawn eval. material is the material on the board, see Material.
+func (m Model) FromCP(cp, material int) WDL {
+	capped := math.Min(math.Max(-1000, float64(cp)), 1000)
+	switch m {
+	case Stockfish:
+		return stockfishWDL(float64(cp), material)
+	case LichessV2:
+		return logisticWDL(capped, lichessV2Multiplier)
+	case Centipawns:
+		return chancesWDL(capped / 1000)
+	}
+	return lichessWDL(capped)
+}
+
+// FromMate converts a mate in mate moves, negative when getting mated.
+func (m Model) FromMate(mate int) WDL {
+	if m == Stockfish {
+		if mate < 0 {
+			return WDL{Loss: 1}
+		}
+		return WDL{Win: 1}
+	}
+
+	// lichess scores mates as the most winning centipawn evals, closer mates higher
+	cp := (21 - math.Min(10, math.Abs(float64(mate)))) * 100
+	if mate < 0 {
+		cp *= -1
+	}
+	switch m {
+	case LichessV2:
+		return logisticWDL(cp, lichessV2Multiplier)
+	case Centipawns:
+		return chancesWDL(math.Copysign(1, cp))
+	}
+	return lichessWDL(cp)
+}
+
+// lichessV2Multiplier is the logistic's slope of LichessV2, lichess' is 0.004.
+const lichessV2Multiplier = 0.00368208
+
+func lichessWDL(cp float64) WDL {
+	return logisticWDL(cp, 0.004)
+}
+
+func logisticWDL(cp, multiplier float64) WDL {
+	return chancesWDL(2/(1+math.Exp(-multiplier*cp)) - 1)
+}
+
+// chancesWDL returns winning chances between -1 and 1 as wins and losses, no draws.
+func chancesWDL(chances float64) WDL {
+	return WDL{Win: (1 + chances) / 2, Loss: (1 - chances) / 2}
+}
+
+// Stockfish 16.1's win rate model: the eval with a 50% chance of a win (a) and the spread (b)
+// are polynomials in the material left.
+var (
+	sfAs = [4]float64{-1.06249702, 7.42016937, 0.89425629, 348.60356174}
+	sfBs = [4]float64{-5.33122190, 39.57831533, -90.84473771, 123.40620748}
+)
+
+func stockfishWDL(cp float64, material int) WDL {
+	m := math.Min(math.Max(float64(material), 10), 78) / 58
+
+	a := ((sfAs[0]*m+sfAs[1])*m+sfAs[2])*m + sfAs[3]
+	b := ((sfBs[0]*m+sfBs[1])*m+sfBs[2])*m + sfBs[3]
+
+	// normalized cp back to the engine's internal units
+	x := math.Min(math.Max(cp*a/100, -4000), 4000)
+
+	win := 1 / (1 + math.Exp((a-x)/b))
+	loss := 1 / (1 + math.Exp((a+x)/b))
+	return WDL{Win: win, Draw: 1 - win - loss, Loss: loss}
+}
+
+// Material counts the material on the board the way Stockfish's model does: pawns 1,
+// knights and bishops 3, rooks 5 and queens 9. The start position has 78.
+func Material(board fen.Board) int {
+	material := 0
+	for _, p := range board.Pos {
+		switch p {
+		case 'P', 'p':
+			material++
+		case 'N', 'n', 'B', 'b':
+			material += 3
+		case 'R', 'r':
+			material += 5
+		case 'Q', 'q':
+			material += 9
+		}
+	}
+	return material
+}
@@ -0,0 +1,108 @@
+package wdl
+
+import (
+	"math"
+	"testing"
+
+	"trollfish-lichess/fen"
+)
+
+func TestModel_FromCP(t *testing.T) {
+	// arrange
+	const startMaterial = 78
+	endgame := Material(fen.FENtoBoard("4k3/8/8/8/8/8/4P3/4K2R w K - 0 1"))
+
+	// act
+	lichess := Lichess.FromCP(100, startMaterial)
+	sfEven := Stockfish.FromCP(0, startMaterial)
+	sfPawn := Stockfish.FromCP(100, startMaterial)
+	sfEndgame := Stockfish.FromCP(100, endgame)
+
+	// assert
+	if endgame != 6 {
+		t.Errorf("material: got %d, want 6", endgame)
+	}
+	if got, want := lichess.Chances(), 2/(1+math.Exp(-0.4))-1; math.Abs(got-want) > 1e-9 || lichess.Draw != 0 {
+		t.Errorf("lichess +1: got %v, want chances %v", lichess, want)
+	}
+	if sfEven.Win != sfEven.Loss || sfEven.Draw < 0.9 {
+		t.Errorf("stockfish 0.00: got %v", sfEven)
+	}
+	// normalized evals: +1 is a 50% chance of a win
+	if math.Abs(sfPawn.Win-0.5) > 0.01 {
+		t.Errorf("stockfish +1: got %v, want 50%% wins", sfPawn)
+	}
+	if math.Abs(sfEndgame.Win-0.5) > 0.01 {
+		t.Errorf("stockfish +1 endgame: got %v, want 50%% wins", sfEndgame)
+	}
+	if w := Stockfish.FromCP(-300, startMaterial); w.Loss < 0.99 {
+		t.Errorf("stockfish -3: got %v", w)
+	}
+}
+
+func TestModel_FromMate(t *testing.T) {
+	if w := Stockfish.FromMate(-3); w.Loss != 1 {
+		t.Errorf("stockfish mated: got %v", w)
+	}
+	if Lichess.FromMate(2).Chances() <= Lichess.FromMate(8).Chances() {
+		t.Error("lichess: closer mate should score higher")
+	}
+	if _, err := Parse("nnue"); err == nil {
+		t.Error("parse: want error")
+	}
+}
+
+func TestModel_Alternatives(t *testing.T) {
+	// act
+	lichess := Lichess.FromCP(300, 78).Chances()
+	v2 := LichessV2.FromCP(300, 78).Chances()
+	cp := Centipawns.FromCP(300, 78).Chances()
+	cpCapped := Centipawns.FromCP(-2500, 78).Chances()
+	cpMated := Centipawns.FromMate(-3).Chances()
+
+	// assert
+	if v2 >= lichess || v2 <= 0 {
+		t.Errorf("lichess-v2 +3: got %v, want a little below lichess' %v", v2, lichess)
+	}
+	if math.Abs(cp-0.3) > 1e-9 || cpCapped != -1 || cpMated != -1 {
+		t.Errorf("cp: got %v %v %v, want 0.3 -1 -1", cp, cpCapped, cpMated)
+	}
+	if m, err := Parse("engine"); err != nil || m != Stockfish {
+		t.Errorf("engine: got %v %v, want stockfish", m, err)
+	}
+}
+
+func TestParseBands(t *testing.T) {
+	// arrange
+	bands, err := ParseBands("lichess,2400:stockfish,2000:lichess-v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		rating int
+		want   Model
+	}{
+		{0, Lichess},
+		{1999, Lichess},
+		{2000, LichessV2},
+		{2399, LichessV2},
+		{2800, Stockfish},
+	}
+
+	for _, c := range cases {
+		// act
+		got := bands.For(c.rating)
+
+		// assert
+		if got != c.want {
+			t.Errorf("%d: got %s, want %s", c.rating, got, c.want)
+		}
+	}
+
+	for _, bad := range []string{"2000:lichess", "lichess,stockfish", "lichess,x:stockfish", "lichess,2000:sf"} {
+		if _, err := ParseBands(bad); err == nil {
+			t.Errorf("'%s': got nil, want an error", bad)
+		}
+	}
+}
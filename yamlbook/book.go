@@ -1,26 +1,37 @@
 package yamlbook
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"math/rand"
+	"os"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
-	"gopkg.in/yaml.v3"
-
 	"trollfish-lichess/api"
 	"trollfish-lichess/fen"
+	"trollfish-lichess/storage"
 )
 
+// Book is safe for concurrent use through its methods: a game can look up moves while
+// background analysis adds them. The moves it returns are shared and never changed after,
+// updates replace them, so they're a snapshot of the book when they were looked up. Positions
+// is for the single-goroutine book tools.
 type Book struct {
 	Positions []*Position
 
-	posMap   map[string]*Position
-	filename string
+	mu        sync.RWMutex
+	posMap    map[string]*Position
+	filename  string
+	canonical bool
+}
+
+// New returns an empty book that Save writes to filename.
+func New(filename string) *Book {
+	return &Book{posMap: make(map[string]*Position), filename: filename}
 }
 
 func Load(filename string) (*Book, error) {
@@ -29,30 +40,31 @@ func Load(filename string) (*Book, error) {
 		filename: filename,
 	}
 
-	b, err := ioutil.ReadFile(filename)
+	b, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, fmt.Errorf("'%s': %v", filename, err)
 	}
 
-	if err := yaml.Unmarshal(b, &book.Positions); err != nil {
-		return nil, err
+	file, version, err := decode(b)
+	if err != nil {
+		return nil, fmt.Errorf("'%s': %v", filename, err)
 	}
+	if version != CurrentVersion {
+		fmt.Printf("migrated '%s' from version %d to %d\n", filename, version, CurrentVersion)
+	}
+	book.Positions, book.canonical = file.Positions, file.Canonical
 
-	seen := make(map[string]struct{})
-	for i := 0; i < len(book.Positions); i++ {
-		pos := book.Positions[i]
-
-		_, found := seen[pos.FEN]
-		if !found {
-			seen[pos.FEN] = struct{}{}
-			continue
-		}
-		if len(pos.Moves) > 0 {
-			panic(fmt.Errorf("position '%s' duplicated with moves", pos.FEN))
+	if book.canonical {
+		// positions added by hand or by an older version
+		for _, msg := range flipBlackToMove(book.Positions) {
+			fmt.Println(msg)
 		}
-		fmt.Printf("removed duplicate '%s'\n", pos.FEN)
-		book.Positions = append(book.Positions[:i], book.Positions[i+1:]...)
-		i--
+	}
+
+	var merged []string
+	book.Positions, merged = mergeDuplicates(book.Positions)
+	for _, msg := range merged {
+		fmt.Println(msg)
 	}
 
 	for _, pos := range book.Positions {
@@ -65,8 +77,11 @@ func Load(filename string) (*Book, error) {
 		}
 
 		sort.Stable(pos.Moves)
-		book.posMap[pos.FEN] = pos
 	}
+	if dropped := compact(book.Positions, Retain); dropped != 0 {
+		fmt.Printf("'%s': dropped %d engine output line(s) by retention %s\n", filename, dropped, Retain)
+	}
+	book.index()
 
 	if err := book.Save(); err != nil {
 		return nil, err
@@ -75,20 +90,39 @@ func Load(filename string) (*Book, error) {
 	return &book, nil
 }
 
+// index maps the book's positions by FEN and sets their moves' FENs, so lookups don't have
+// to, after Positions is loaded or rebuilt.
+func (b *Book) index() {
+	b.posMap = make(map[string]*Position, len(b.Positions))
+	for _, pos := range b.Positions {
+		b.posMap[pos.FEN] = pos
+		for _, move := range pos.Moves {
+			if move.fen != pos.FEN {
+				move.fen, move.uci = pos.FEN, ""
+			}
+			move.cacheUCI()
+		}
+	}
+}
+
 func (b *Book) Get(fenKey string) (Moves, bool) {
-	fenKey = fen.Key(fenKey)
+	b.mu.RLock()
+	defer b.mu.RUnlock()
 
-	position, ok := b.posMap[fenKey]
+	return b.get(fenKey)
+}
+
+func (b *Book) get(fenKey string) (Moves, bool) {
+	moves, ok := b.getAll(fenKey)
 	if !ok {
 		return nil, false
 	}
 
-	result := make(Moves, 0, len(position.Moves))
+	result := make(Moves, 0, len(moves))
 
-	for i := 0; i < len(position.Moves); i++ {
-		position.Moves[i].fen = position.FEN
-		if position.Moves[i].Move != "" {
-			result = append(result, position.Moves[i])
+	for i := 0; i < len(moves); i++ {
+		if moves[i].Move != "" {
+			result = append(result, moves[i])
 		}
 	}
 
@@ -100,22 +134,45 @@ func (b *Book) Get(fenKey string) (Moves, bool) {
 }
 
 func (b *Book) GetAll(fenKey string) (Moves, bool) {
-	fenKey = fen.Key(fenKey)
+	b.mu.RLock()
+	defer b.mu.RUnlock()
 
-	position, ok := b.posMap[fenKey]
+	return b.getAll(fenKey)
+}
+
+func (b *Book) getAll(fenKey string) (Moves, bool) {
+	key, flipped := b.key(fenKey)
+
+	position, ok := b.posMap[key]
 	if !ok {
 		return nil, false
 	}
 
-	for i := 0; i < len(position.Moves); i++ {
-		position.Moves[i].fen = position.FEN
+	if flipped {
+		// moves that don't parse can't be played, Fsck reports them
+		moves, _ := flipMoves(position.Moves, key, fen.Key(fenKey))
+		return moves, true
 	}
 
-	return position.Moves, true
+	// a copy, Add changes the position's slice in place
+	moves := make(Moves, len(position.Moves))
+	copy(moves, position.Moves)
+	return moves, true
 }
 
 func (b *Book) Add(fenKey string, moves ...*Move) {
-	fenKey = fen.Key(fenKey)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key, flipped := b.key(fenKey)
+	if flipped {
+		var dropped []string
+		moves, dropped = flipMoves(moves, fen.Key(fenKey), key)
+		for _, msg := range dropped {
+			fmt.Printf("add: %s\n", msg)
+		}
+	}
+	fenKey = key
 
 	position, ok := b.posMap[fenKey]
 	if !ok {
@@ -125,7 +182,10 @@ func (b *Book) Add(fenKey string, moves ...*Move) {
 	}
 
 	for _, move := range moves {
-		move.fen = fenKey
+		if move.fen != fenKey {
+			move.fen, move.uci = fenKey, ""
+		}
+		move.cacheUCI()
 	}
 
 	var anyHaveMove bool
@@ -158,6 +218,23 @@ func (b *Book) Add(fenKey string, moves ...*Move) {
 				continue
 			}
 
+			// tags, bands and weights are set by hand, explorer stats come from lichess and
+			// health from playouts: keep them when the eval is replaced
+			if len(moves[j].Tags) == 0 {
+				moves[j].Tags = position.Moves[i].Tags
+			}
+			if moves[j].Band == nil {
+				moves[j].Band = position.Moves[i].Band
+			}
+			if moves[j].Weight == 0 {
+				moves[j].Weight = position.Moves[i].Weight
+			}
+			if moves[j].Explorer == nil {
+				moves[j].Explorer = position.Moves[i].Explorer
+			}
+			if moves[j].Health == nil {
+				moves[j].Health = position.Moves[i].Health
+			}
 			position.Moves[i] = moves[j]
 			moves = append(moves[:j], moves[j+1:]...)
 			break
@@ -171,28 +248,33 @@ func (b *Book) Add(fenKey string, moves ...*Move) {
 }
 
 func (b *Book) Save() error {
-	// remove blank moves (and any other data they might contain)
+	// encode and write a copy, the game reads the book while it's saved
+	b.mu.RLock()
+	positions := make([]*Position, 0, len(b.Positions))
 	for _, pos := range b.Positions {
-		for i := 0; i < len(pos.Moves); i++ {
-			if pos.Moves[i].Move == "" {
-				pos.Moves = append(pos.Moves[:i], pos.Moves[i+1:]...)
-				i--
-				continue
+		saved := *pos
+		saved.Moves = nil
+
+		// remove blank moves (and any other data they might contain)
+		for _, move := range pos.Moves {
+			if move.Move != "" {
+				saved.Moves = append(saved.Moves, move)
 			}
 		}
 
-		if len(pos.Moves) == 0 {
-			pos.Moves = nil
-		}
+		positions = append(positions, &saved)
 	}
+	file := bookFile{Version: CurrentVersion, Canonical: b.canonical, Positions: positions}
+	b.mu.RUnlock()
+
+	compact(positions, Retain)
 
-	var buf bytes.Buffer
-	enc := yaml.NewEncoder(&buf)
-	if err := enc.Encode(b.Positions); err != nil {
+	data, err := encode(file)
+	if err != nil {
 		return fmt.Errorf("'%s': %v", b.filename, err)
 	}
 
-	if err := ioutil.WriteFile(b.filename, buf.Bytes(), 0644); err != nil {
+	if err := storage.WriteFileAtomic(b.filename, data); err != nil {
 		return fmt.Errorf("write file '%s': %v", b.filename, err)
 	}
 
@@ -202,7 +284,7 @@ func (b *Book) Save() error {
 func (b *Book) CheckOnlineDatabase(ctx context.Context, boardFEN string) error {
 	results, err := api.CloudEval(boardFEN, 5)
 	if err != nil {
-		if err == api.ErrNotFound {
+		if errors.Is(err, api.ErrNotFound) {
 			return nil
 		}
 		return err
@@ -218,21 +300,22 @@ func (b *Book) CheckOnlineDatabase(ctx context.Context, boardFEN string) error {
 	}
 
 	board := fen.FENtoBoard(boardFEN)
-	povMultiplier := iif(board.ActiveColor == fen.WhitePieces, 1, -1)
 
 	for i, pv := range results.PVs {
 		pvUCI := strings.Split(pv.Moves, " ")
 		pvSAN := board.UCItoSANs(pvUCI...)
 
-		cp := pv.CP * povMultiplier
-		mate := pv.Mate * povMultiplier
+		// cloud evals are white's, book evals the side to move's
+		score := fen.Global(pv.CP, pv.Mate).For(board.ActiveColor)
+		cp, mate := score.CP, score.Mate
 		ts := time.Now().Unix()
 
 		move := Move{
-			Move: pvSAN[0],
-			CP:   cp,
-			Mate: mate,
-			TS:   ts,
+			Move:   pvSAN[0],
+			CP:     cp,
+			Mate:   mate,
+			TS:     ts,
+			Source: &Source{Type: SourceCloud, AddedBy: "lichess", Date: ts},
 			Engine: &Engine{
 				ID: "lichess",
 				Output: []*EngineOutput{{
@@ -263,18 +346,105 @@ func (b *Book) CheckOnlineDatabase(ctx context.Context, boardFEN string) error {
 }
 
 func (b *Book) BestMove(fenPos string) (*Move, string) {
-	if b == nil || b.posMap == nil {
+	return b.BestMoveBiased(fenPos, nil, nil)
+}
+
+// MoveBias returns how many times a candidate book move was played recently.
+// Candidates with a higher count are less likely to be picked by BestMoveBiased.
+type MoveBias func(move *Move) int
+
+// MoveFilter returns false for book moves that shouldn't be played, e.g. by tag.
+type MoveFilter func(move *Move) bool
+
+// ExcludeTags returns a MoveFilter which rejects moves with any of tags.
+func ExcludeTags(tags ...string) MoveFilter {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	return func(move *Move) bool {
+		for _, tag := range tags {
+			if move.HasTag(tag) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// ForRating returns a MoveFilter which rejects moves whose Band doesn't contain the opponent's
+// rating. Bands are ignored when the rating isn't known (0).
+func ForRating(rating int) MoveFilter {
+	if rating == 0 {
+		return nil
+	}
+
+	return func(move *Move) bool {
+		return move.Band.Contains(rating)
+	}
+}
+
+// AllOf returns a MoveFilter which allows the moves every one of filters allows; nil
+// filters allow all.
+func AllOf(filters ...MoveFilter) MoveFilter {
+	var all []MoveFilter
+	for _, filter := range filters {
+		if filter != nil {
+			all = append(all, filter)
+		}
+	}
+	switch len(all) {
+	case 0:
+		return nil
+	case 1:
+		return all[0]
+	}
+
+	return func(move *Move) bool {
+		for _, filter := range all {
+			if !filter(move) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func (b *Book) BestMoveBiased(fenPos string, bias MoveBias, allow MoveFilter) (*Move, string) {
+	if b == nil {
+		return nil, ""
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.posMap == nil {
 		return nil, ""
 	}
 	board := fen.FENtoBoard(fenPos)
 	fenKey := board.FENKey()
-	pos, ok := b.posMap[fenKey]
+	key, flipped := b.key(fenKey)
+	pos, ok := b.posMap[key]
 	if !ok {
 		return nil, ""
 	}
 
-	sort.Stable(pos.Moves)
-	moves := pos.Moves
+	// sorted here too, for weights set by hand since Load; a copy, as it's only read locked
+	moves := make(Moves, len(pos.Moves))
+	copy(moves, pos.Moves)
+	sort.Stable(moves)
+	if flipped {
+		moves, _ = flipMoves(moves, key, fenKey) // see getAll
+	}
+
+	if allow != nil {
+		all := moves
+		moves = make(Moves, 0, len(all))
+		for _, move := range all {
+			if allow(move) {
+				moves = append(moves, move)
+			}
+		}
+	}
 
 	if len(moves) == 0 {
 		return nil, ""
@@ -285,6 +455,13 @@ func (b *Book) BestMove(fenPos string) (*Move, string) {
 	// TODO: add variance by weight
 	bestMove = moves[0]
 
+	seen := func(move *Move) int {
+		if bias == nil {
+			return 0
+		}
+		return bias(move)
+	}
+
 	if bestMove.Weight == 0 {
 		i := 1
 		text := bestMove.Move
@@ -295,9 +472,26 @@ func (b *Book) BestMove(fenPos string) (*Move, string) {
 			text += ", " + moves[i].Move
 		}
 		if i > 1 {
-			n := rand.Intn(i)
-			bestMove = moves[n]
+			// prefer the equal moves we've played the least recently
+			var candidates Moves
+			minSeen := -1
+			for j := 0; j < i; j++ {
+				count := seen(moves[j])
+				if minSeen == -1 || count < minSeen {
+					minSeen = count
+					candidates = candidates[:0]
+				}
+				if count == minSeen {
+					candidates = append(candidates, moves[j])
+				}
+			}
+			candidates = practicalBest(candidates, fenKey)
+
+			n := rand.Intn(len(candidates))
+			bestMove = candidates[n]
 			fmt.Printf("moves: '%s' count: %d pick: '%s' eval: %d\n", text, i, bestMove.Move, bestMove.CP)
+		} else if count := seen(bestMove); count > 0 {
+			fmt.Printf("only move: '%s' already played in %d recent game(s)\n", bestMove.Move, count)
 		}
 	} else {
 		type weightedMove struct {
@@ -315,7 +509,13 @@ func (b *Book) BestMove(fenPos string) (*Move, string) {
 
 			start := sum
 
-			sum += moves[i].Weight
+			// moves played in recent games get a proportionally smaller slice of the deck
+			weight := moves[i].Weight / (1 + seen(moves[i]))
+			if weight < 1 {
+				weight = 1
+			}
+
+			sum += weight
 			end := sum - 1
 
 			deck = append(deck, weightedMove{start: start, end: end, index: i})
@@ -333,8 +533,6 @@ func (b *Book) BestMove(fenPos string) (*Move, string) {
 		}
 	}
 
-	bestMove.fen = fenKey
-
 	line := bestMove.GetLastLogLineFor(bestMove.Move)
 	pvSANs := strings.Split(line.PV, " ")
 
@@ -351,11 +549,107 @@ func (b *Book) BestMove(fenPos string) (*Move, string) {
 	return bestMove, ""
 }
 
+// practicalBest returns the eval-equal candidates that score best in the explorer's games,
+// see SetExplorerStats. They're only compared when every candidate has enough games.
+func practicalBest(candidates Moves, fenKey string) Moves {
+	if len(candidates) < 2 {
+		return candidates
+	}
+
+	scores := make([]float64, len(candidates))
+	best := -1.0
+	for i, move := range candidates {
+		score, ok := move.practicalScore(fenKey)
+		if !ok {
+			return candidates
+		}
+		scores[i] = score
+		if score > best {
+			best = score
+		}
+	}
+
+	var result Moves
+	for i, move := range candidates {
+		if scores[i] == best {
+			result = append(result, move)
+		}
+	}
+	return result
+}
+
+// MarkForReview queues an existing position for re-analysis. Returns false if the position
+// isn't in the book or is already queued.
+func (b *Book) MarkForReview(fenKey string) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.posMap == nil {
+		return false
+	}
+
+	key, _ := b.key(fenKey)
+	pos, ok := b.posMap[key]
+	if !ok || pos.Review != 0 {
+		return false
+	}
+
+	pos.Review = time.Now().Unix()
+	return true
+}
+
+// ClearReview removes a position from the review queue. Returns false if it wasn't queued.
+func (b *Book) ClearReview(fenKey string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key, _ := b.key(fenKey)
+	pos, ok := b.posMap[key]
+	if !ok || pos.Review == 0 {
+		return false
+	}
+
+	pos.Review = 0
+	return true
+}
+
+// NeedReview returns the positions queued for re-analysis, oldest first.
+func (b *Book) NeedReview() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	var review []*Position
+	for _, pos := range b.Positions {
+		if pos.Review != 0 {
+			review = append(review, pos)
+		}
+	}
+
+	sort.SliceStable(review, func(i, j int) bool {
+		return review[i].Review < review[j].Review
+	})
+
+	fens := make([]string, 0, len(review))
+	for _, pos := range review {
+		fens = append(fens, pos.FEN)
+	}
+	return fens
+}
+
 func (b *Book) PosCount() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
 	return len(b.posMap)
 }
 
 func (b *Book) NeedMoves() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
 	var fens []string
 
 	for _, pos := range b.Positions {
@@ -0,0 +1,349 @@
+package yamlbook
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"trollfish-lichess/internal/benchdata"
+)
+
+func TestBook_BestMoveBiased(t *testing.T) {
+	// arrange
+	const fenKey = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq -"
+
+	book := Book{posMap: make(map[string]*Position)}
+	book.Add(fenKey,
+		&Move{Move: "e4", CP: 30},
+		&Move{Move: "d4", CP: 30},
+		&Move{Move: "c4", CP: 30},
+	)
+
+	bias := func(move *Move) int {
+		if move.Move == "d4" {
+			return 0
+		}
+		return 1
+	}
+
+	for i := 0; i < 20; i++ {
+		// act
+		got, _ := book.BestMoveBiased(fenKey, bias, nil)
+
+		// assert
+		if got == nil || got.Move != "d4" {
+			t.Fatalf("want: d4 got: %v", got)
+		}
+	}
+}
+
+func TestBook_NeedReview(t *testing.T) {
+	// arrange
+	const (
+		fen1 = "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq -"
+		fen2 = "rnbqkbnr/pppppppp/8/8/3P4/8/PPP1PPPP/RNBQKBNR b KQkq -"
+	)
+
+	book := Book{posMap: make(map[string]*Position)}
+	book.Add(fen1, &Move{Move: "c5", CP: 30})
+	book.Add(fen2, &Move{Move: "d5", CP: 20})
+
+	// act
+	marked := book.MarkForReview(fen2)
+	markedAgain := book.MarkForReview(fen2)
+	missing := book.MarkForReview("8/8/8/8/8/8/8/K6k w - -")
+	got := book.NeedReview()
+
+	// assert
+	if !marked || markedAgain || missing {
+		t.Errorf("marked: %v marked_again: %v missing: %v", marked, markedAgain, missing)
+	}
+	if len(got) != 1 || got[0] != fen2 {
+		t.Fatalf("want: [%s] got: %v", fen2, got)
+	}
+
+	if !book.ClearReview(fen2) || len(book.NeedReview()) != 0 {
+		t.Errorf("review queue not cleared: %v", book.NeedReview())
+	}
+}
+
+func TestLoad_MergeDuplicates(t *testing.T) {
+	// arrange
+	const fenKey = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq -"
+
+	filename := filepath.Join(t.TempDir(), "book.yamlbook")
+	data := `- fen: ` + fenKey + `
+  moves:
+    - move: e4
+      cp: 20
+      ts: 100
+- fen: ` + fenKey + `
+  moves:
+    - move: e4
+      cp: 35
+      ts: 200
+    - move: d4
+      cp: 30
+      ts: 200
+`
+	if err := os.WriteFile(filename, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	book, err := Load(filename)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.PosCount() != 1 || len(book.Positions) != 1 {
+		t.Fatalf("got %d position(s), want 1", len(book.Positions))
+	}
+
+	moves, _ := book.Get(fenKey)
+	if len(moves) != 2 {
+		t.Fatalf("got %d move(s), want 2", len(moves))
+	}
+	if e4 := moves.GetSAN("e4"); e4 == nil || e4.CP != 35 {
+		t.Errorf("e4: want newest eval cp 35, got %+v", e4)
+	}
+}
+
+func TestBook_Fsck(t *testing.T) {
+	// arrange
+	const fenKey = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq -"
+
+	book := Book{
+		Positions: []*Position{
+			{FEN: fenKey + " 0 1", Moves: Moves{{Move: "e4", CP: 30, TS: 100}, {Move: "e5", CP: 10}}},
+			{FEN: fenKey, Moves: Moves{{Move: "e4", CP: 40, TS: 200}, {Move: "d4", CP: 25}}},
+		},
+		posMap: make(map[string]*Position),
+	}
+
+	// act
+	report := book.Fsck()
+
+	// assert
+	if len(report) != 3 {
+		t.Errorf("got %d repair(s), want 3: %v", len(report), report)
+	}
+	if len(book.Positions) != 1 {
+		t.Fatalf("got %d position(s), want 1", len(book.Positions))
+	}
+
+	moves, _ := book.Get(fenKey)
+	if len(moves) != 2 || moves.ContainsSAN("e5") {
+		t.Errorf("got moves %v", moves)
+	}
+	if e4 := moves.GetSAN("e4"); e4 == nil || e4.CP != 40 {
+		t.Errorf("e4: want newest eval cp 40, got %+v", e4)
+	}
+}
+
+func TestLoad_MigrateV0(t *testing.T) {
+	// arrange
+	const fenKey = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq -"
+
+	filename := filepath.Join(t.TempDir(), "book.yamlbook")
+	data := "- fen: " + fenKey + "\n  moves:\n    - move: e4\n      cp: 20\n"
+	if err := os.WriteFile(filename, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	book, err := Load(filename)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := book.Get(fenKey); !ok {
+		t.Fatal("position not found after migration")
+	}
+
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, version, err := decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != CurrentVersion {
+		t.Errorf("saved version: got %d, want %d", version, CurrentVersion)
+	}
+}
+
+func TestBook_BestMoveBiased_ExcludeTags(t *testing.T) {
+	// arrange
+	const fenKey = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq -"
+
+	book := Book{posMap: make(map[string]*Position)}
+	book.Add(fenKey,
+		&Move{Move: "g4", CP: 50, Tags: []string{TagHumanOnly, TagTrap}},
+		&Move{Move: "e4", CP: 30},
+	)
+
+	// act
+	got, _ := book.BestMoveBiased(fenKey, nil, ExcludeTags(TagHumanOnly))
+	unfiltered, _ := book.BestMoveBiased(fenKey, nil, nil)
+
+	// assert
+	if got == nil || got.Move != "e4" {
+		t.Errorf("filtered: want e4, got %+v", got)
+	}
+	if unfiltered == nil || unfiltered.Move != "g4" {
+		t.Errorf("unfiltered: want g4, got %+v", unfiltered)
+	}
+}
+
+func TestBook_BestMoveBiased_ForRating(t *testing.T) {
+	// arrange
+	const fenKey = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq -"
+
+	book := Book{posMap: make(map[string]*Position)}
+	book.Add(fenKey,
+		&Move{Move: "e4", CP: 30, Band: &Band{Min: 2200}},
+		&Move{Move: "g4", CP: -50, Weight: 1, Band: &Band{Max: 2200}, Tags: []string{TagTrap}},
+	)
+
+	cases := []struct {
+		name   string
+		allow  MoveFilter
+		rating int
+		want   string
+	}{
+		{name: "below the band", rating: 1900, want: "g4"},
+		{name: "in the band", rating: 2200, want: "e4"},
+		{name: "unknown rating", rating: 0, want: "g4"}, // weighted
+		{name: "with another filter", allow: ExcludeTags(TagTrap), rating: 1900, want: ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// act
+			got, _ := book.BestMoveBiased(fenKey, nil, AllOf(c.allow, ForRating(c.rating)))
+
+			// assert
+			if c.want == "" && got != nil || c.want != "" && (got == nil || got.Move != c.want) {
+				t.Errorf("got %+v, want '%s'", got, c.want)
+			}
+		})
+	}
+}
+
+func TestBand_Contains(t *testing.T) {
+	cases := []struct {
+		band   *Band
+		rating int
+		want   bool
+	}{
+		{band: nil, rating: 1500, want: true},
+		{band: &Band{Max: 2200}, rating: 2199, want: true},
+		{band: &Band{Max: 2200}, rating: 2200, want: false},
+		{band: &Band{Min: 1800, Max: 2200}, rating: 1800, want: true},
+		{band: &Band{Min: 1800, Max: 2200}, rating: 1799, want: false},
+		{band: &Band{Min: 2200}, rating: 3000, want: true},
+	}
+
+	for _, c := range cases {
+		// act
+		got := c.band.Contains(c.rating)
+
+		// assert
+		if got != c.want {
+			t.Errorf("%+v contains %d: got %v, want %v", c.band, c.rating, got, c.want)
+		}
+	}
+}
+
+func TestBook_Concurrent(t *testing.T) {
+	// arrange
+	const fenKey = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq -"
+
+	book := Book{posMap: make(map[string]*Position), filename: filepath.Join(t.TempDir(), "book.yamlbook")}
+	book.Add(fenKey, &Move{Move: "e4", CP: 30})
+
+	// act
+	const readers = 4
+	var wg sync.WaitGroup
+	wg.Add(2 + readers)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			book.Add(fenKey, &Move{Move: "d4", CP: i % 40}, &Move{Move: "Nf3", CP: 20})
+			book.MarkForReview(fenKey)
+			book.ClearReview(fenKey)
+			if i%25 == 0 {
+				if err := book.Save(); err != nil {
+					t.Error(err)
+				}
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		playout := func(string) (float64, int, error) { return 0.5, 1, nil }
+		for i := 0; i < 20; i++ {
+			if _, err := book.ScoreHealth(fenKey, playout, int64(i)); err != nil {
+				t.Error(err)
+			}
+			if err := book.Save(); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	for r := 0; r < readers; r++ {
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				if move, _ := book.BestMove(fenKey); move == nil {
+					t.Error("no book move")
+				}
+				moves, ok := book.Get(fenKey)
+				if !ok || moves.GetSAN("e4") == nil {
+					t.Errorf("got %v, want e4", moves)
+				}
+				for _, move := range moves {
+					_ = move.UCI()
+				}
+				book.NeedReview()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// assert
+	moves, _ := book.Get(fenKey)
+	if len(moves) != 3 {
+		t.Errorf("got %d moves, want 3", len(moves))
+	}
+	if e4 := moves.GetSAN("e4"); e4 == nil || e4.Health == nil {
+		t.Errorf("got %v, want e4 with its health", e4)
+	}
+}
+
+func BenchmarkBook_BestMove(b *testing.B) {
+	boards := benchdata.Boards(benchdata.Positions)
+	book := Book{posMap: make(map[string]*Position)}
+	fens := make([]string, 0, len(boards))
+	for _, board := range boards {
+		var moves Moves
+		for i, move := range board.AllLegalMoves() {
+			if i == 3 {
+				break
+			}
+			moves = append(moves, &Move{Move: board.UCItoSAN(move.UCI), CP: 30 - 10*i})
+		}
+		book.Add(board.FENKey(), moves...)
+		fens = append(fens, board.FEN())
+	}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, _ = book.BestMove(fens[i%len(fens)])
+	}
+}
@@ -0,0 +1,134 @@
+package yamlbook
+
+import (
+	"fmt"
+	"strings"
+
+	"trollfish-lichess/fen"
+)
+
+// A canonical book keeps every position with white to move: positions with black to move are
+// stored flipped (see fen.Board.Flip), so a reversed opening shares the knowledge of the one
+// it mirrors. Book evals are for the side to move and don't change sign when flipped, but
+// evals in a book's own files, and any tooling reading them, expect the board as played;
+// books opt in with 'canonical: true' in the header, or SetCanonical.
+//
+// Get, GetAll and BestMoveBiased return copies of a flipped position's moves, converted to
+// the board as given, so changes to them have to go through Add.
+
+// Canonical reports whether the book stores positions with black to move flipped.
+func (b *Book) Canonical() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.canonical
+}
+
+// SetCanonical makes the book canonical, flipping its positions with black to move and
+// merging those that were already in the book flipped. It returns a description of each
+// change; call Save to keep them.
+func (b *Book) SetCanonical() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.canonical = true
+
+	report := flipBlackToMove(b.Positions)
+	positions, merged := mergeDuplicates(b.Positions)
+	report = append(report, merged...)
+	b.Positions = positions
+	b.index()
+
+	return report
+}
+
+// key returns the book key of fenKey, true if it's flipped.
+func (b *Book) key(fenKey string) (string, bool) {
+	return bookKey(fenKey, b.canonical)
+}
+
+// bookKey returns the key of fenKey in a book that's canonical or not, true if it's flipped.
+func bookKey(fenKey string, canonical bool) (string, bool) {
+	board := fen.FENtoBoard(fenKey)
+	if !canonical || board.ActiveColor == fen.WhitePieces {
+		return board.FENKey(), false
+	}
+	return board.Flip().FENKey(), true
+}
+
+// flipBlackToMove flips the positions with black to move and returns a description of each.
+func flipBlackToMove(positions []*Position) []string {
+	var report []string
+	for _, pos := range positions {
+		board := fen.FENtoBoard(pos.FEN)
+		if board.ActiveColor == fen.WhitePieces {
+			continue
+		}
+		fenKey := board.Flip().FENKey()
+		report = append(report, fmt.Sprintf("flipped '%s' to '%s'", pos.FEN, fenKey))
+		var dropped []string
+		pos.Moves, dropped = flipMoves(pos.Moves, board.FENKey(), fenKey)
+		report = append(report, dropped...)
+		pos.FEN = fenKey
+	}
+	return report
+}
+
+// flipMoves returns copies of moves in fromKey converted to the flipped board toKey. Moves
+// and engine lines that aren't legal in fromKey are dropped, with a description of each.
+func flipMoves(moves Moves, fromKey, toKey string) (Moves, []string) {
+	from, to := fen.FENtoBoard(fromKey), fen.FENtoBoard(toKey)
+
+	var dropped []string
+	result := make(Moves, 0, len(moves))
+	for _, move := range moves {
+		flipped := *move
+		flipped.uci = ""
+		flipped.fen = toKey
+		if move.Move != "" {
+			san, err := flipSANs(from, to, move.Move)
+			if err != nil {
+				dropped = append(dropped, fmt.Sprintf("'%s': dropped move: %v", fromKey, err))
+				continue
+			}
+			flipped.Move = san
+		}
+		if move.Engine != nil {
+			engine := *move.Engine
+			engine.Output = make([]*EngineOutput, 0, len(move.Engine.Output))
+			for _, output := range move.Engine.Output {
+				line := output.Line
+				pv, err := flipSANs(from, to, line.PV)
+				if err != nil {
+					dropped = append(dropped, fmt.Sprintf("'%s': move '%s': dropped engine line: %v", fromKey, move.Move, err))
+					continue
+				}
+				line.PV = pv
+				engine.Output = append(engine.Output, &EngineOutput{Line: line})
+			}
+			flipped.Engine = &engine
+		}
+		if move.Explorer != nil {
+			stats := *move.Explorer
+			stats.White, stats.Black = stats.Black, stats.White
+			flipped.Explorer = &stats
+		}
+		result = append(result, &flipped)
+	}
+	return result, dropped
+}
+
+// flipSANs converts a space separated line of SAN moves from one board to its flipped board.
+func flipSANs(from, to fen.Board, line string) (string, error) {
+	if line == "" {
+		return "", nil
+	}
+	ucis, err := from.SANtoUCIs(strings.Split(line, " ")...)
+	if err != nil {
+		return "", fmt.Errorf("'%s': %v", line, err)
+	}
+	for i := range ucis {
+		ucis[i] = fen.FlipUCI(ucis[i])
+	}
+	return strings.Join(to.UCItoSANs(ucis...), " "), nil
+}
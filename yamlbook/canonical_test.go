@@ -0,0 +1,115 @@
+package yamlbook
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBook_SetCanonical(t *testing.T) {
+	// arrange
+	const (
+		afterE4 = "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq -"
+		afterE5 = "rnbqkbnr/pppp1ppp/8/4p3/8/8/PPPPPPPP/RNBQKBNR w KQkq -" // afterE4 flipped
+	)
+
+	filename := filepath.Join(t.TempDir(), "book.yamlbook")
+	if err := os.WriteFile(filename, []byte("version: 1\npositions: []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	book, err := Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	book.Add(afterE4, &Move{Move: "c5", CP: 30, Engine: &Engine{Output: []*EngineOutput{{Line: LogLine{Depth: 30, CP: 30, PV: "c5 Nf3 d6"}}}}})
+
+	// act
+	report := book.SetCanonical()
+	book.Add(afterE4, &Move{Move: "e6", CP: 20})
+	if err := book.Save(); err != nil {
+		t.Fatal(err)
+	}
+	book, err = Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// assert
+	if len(report) != 1 {
+		t.Errorf("report: got %v, want 1 flip", report)
+	}
+	if !book.Canonical() || book.PosCount() != 1 || book.Positions[0].FEN != afterE5 {
+		t.Fatalf("got canonical %v, %d position(s), want only '%s'", book.Canonical(), book.PosCount(), afterE5)
+	}
+	if stored := book.Positions[0].Moves; stored.GetSAN("c4") == nil || stored.GetSAN("e3") == nil {
+		t.Errorf("stored moves: got %v, want c4 and e3", stored)
+	}
+
+	moves, ok := book.Get(afterE4)
+	if !ok || len(moves) != 2 {
+		t.Fatalf("get: got %v, want 2 moves", moves)
+	}
+	c5 := moves.GetSAN("c5")
+	if c5 == nil || c5.UCI() != "c7c5" || c5.FEN() != afterE4 {
+		t.Errorf("c5: got %+v", c5)
+	}
+
+	if reversed, ok := book.Get(afterE5); !ok || reversed.GetSAN("c4") == nil {
+		t.Errorf("reversed: got %v, want c4", reversed)
+	}
+
+	bestMove, ponder := book.BestMove(afterE4)
+	if bestMove == nil || bestMove.Move != "c5" || ponder != "g1f3" {
+		t.Errorf("best move: got %+v ponder '%s', want c5 g1f3", bestMove, ponder)
+	}
+}
+
+func TestFlipMoves(t *testing.T) {
+	const (
+		afterE4 = "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq -"
+		afterE5 = "rnbqkbnr/pppp1ppp/8/4p3/8/8/PPPPPPPP/RNBQKBNR w KQkq -"
+	)
+
+	cases := []struct {
+		name        string
+		move        *Move
+		wantMove    string // "" when dropped
+		wantPVs     []string
+		wantDropped int
+	}{
+		{name: "move", move: &Move{Move: "c5"}, wantMove: "c4"},
+		{name: "engine line", move: &Move{Move: "c5", Engine: &Engine{Output: []*EngineOutput{{Line: LogLine{PV: "c5 Nf3 d6"}}}}}, wantMove: "c4", wantPVs: []string{"c4 Nf6 d3"}},
+		{name: "illegal move", move: &Move{Move: "c4"}, wantDropped: 1},
+		{name: "illegal engine line", move: &Move{Move: "c5", Engine: &Engine{Output: []*EngineOutput{{Line: LogLine{PV: "c5 Nf3 d6"}}, {Line: LogLine{PV: "c5 Nf6"}}}}}, wantMove: "c4", wantPVs: []string{"c4 Nf6 d3"}, wantDropped: 1},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// act
+			got, dropped := flipMoves(Moves{c.move}, afterE4, afterE5)
+
+			// assert
+			if len(dropped) != c.wantDropped {
+				t.Errorf("dropped: got %q, want %d", dropped, c.wantDropped)
+			}
+			if c.wantMove == "" {
+				if len(got) != 0 {
+					t.Errorf("got %v, want the move dropped", got)
+				}
+				return
+			}
+			if len(got) != 1 || got[0].Move != c.wantMove {
+				t.Fatalf("got %v, want %s", got, c.wantMove)
+			}
+			var pvs []string
+			if got[0].Engine != nil {
+				for _, output := range got[0].Engine.Output {
+					pvs = append(pvs, output.Line.PV)
+				}
+			}
+			if len(pvs) != len(c.wantPVs) || (len(pvs) != 0 && pvs[0] != c.wantPVs[0]) {
+				t.Errorf("engine lines: got %q, want %q", pvs, c.wantPVs)
+			}
+		})
+	}
+}
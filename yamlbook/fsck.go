@@ -0,0 +1,135 @@
+package yamlbook
+
+import (
+	"fmt"
+	"sort"
+
+	"trollfish-lichess/fen"
+)
+
+// mergeDuplicates merges positions with the same FEN into the first occurrence and returns
+// a description of each merge.
+func mergeDuplicates(positions []*Position) ([]*Position, []string) {
+	var report []string
+
+	seen := make(map[string]*Position)
+	result := make([]*Position, 0, len(positions))
+	for _, pos := range positions {
+		existing, found := seen[pos.FEN]
+		if !found {
+			seen[pos.FEN] = pos
+			result = append(result, pos)
+			continue
+		}
+
+		added, replaced := existing.merge(pos)
+		report = append(report, fmt.Sprintf("merged duplicate '%s': %d move(s) added, %d newer eval(s) kept", pos.FEN, added, replaced))
+	}
+
+	return result, report
+}
+
+// merge unions other's moves into p. When both have the same SAN the move with the newest
+// timestamp wins.
+func (p *Position) merge(other *Position) (added, replaced int) {
+	if other.Review > p.Review {
+		p.Review = other.Review
+	}
+
+	for _, move := range other.Moves {
+		if move.Move == "" {
+			continue
+		}
+
+		existing := p.Moves.GetSAN(move.Move)
+		if existing == nil {
+			p.Moves = append(p.Moves, move)
+			added++
+			continue
+		}
+
+		if move.TS > existing.TS {
+			*existing = *move
+			replaced++
+		}
+	}
+
+	sort.Stable(p.Moves)
+
+	return added, replaced
+}
+
+// Fsck validates the book and repairs what it can: positions whose FEN isn't a normalized key,
+// duplicate positions, duplicate and illegal moves, and move evals that disagree with the
+// engine log. It returns a description of each repair; call Save to keep them.
+// A canonical book's positions with black to move are flipped too.
+func (b *Book) Fsck() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var report []string
+
+	for _, pos := range b.Positions {
+		fenKey := fen.Key(pos.FEN)
+		if fenKey != pos.FEN {
+			report = append(report, fmt.Sprintf("normalized FEN '%s' to '%s'", pos.FEN, fenKey))
+			pos.FEN = fenKey
+		}
+	}
+
+	if b.canonical {
+		report = append(report, flipBlackToMove(b.Positions)...)
+	}
+
+	positions, merged := mergeDuplicates(b.Positions)
+	report = append(report, merged...)
+	b.Positions = positions
+
+	b.posMap = make(map[string]*Position, len(b.Positions))
+	for _, pos := range b.Positions {
+		b.posMap[pos.FEN] = pos
+
+		board := fen.FENtoBoard(pos.FEN)
+		legal := make(map[string]string)
+		for _, move := range board.AllLegalMoves() {
+			legal[board.UCItoSAN(move.UCI)] = move.UCI
+		}
+
+		moves := make(Moves, 0, len(pos.Moves))
+		for _, move := range pos.Moves {
+			if move.Move == "" {
+				continue
+			}
+
+			if _, ok := legal[move.Move]; !ok {
+				report = append(report, fmt.Sprintf("removed illegal move '%s' in '%s'", move.Move, pos.FEN))
+				continue
+			}
+
+			if existing := moves.GetSAN(move.Move); existing != nil {
+				if move.TS > existing.TS {
+					*existing = *move
+				}
+				report = append(report, fmt.Sprintf("merged duplicate move '%s' in '%s'", move.Move, pos.FEN))
+				continue
+			}
+
+			line := move.GetLastLogLineFor(move.Move)
+			var empty LogLine
+			if line != empty && (line.CP != move.CP || line.Mate != move.Mate) {
+				report = append(report, fmt.Sprintf("set '%s' in '%s' to last logged eval cp %d mate %d (was cp %d mate %d)", move.Move, pos.FEN, line.CP, line.Mate, move.CP, move.Mate))
+				move.CP = line.CP
+				move.Mate = line.Mate
+			}
+
+			move.fen = pos.FEN
+			move.uci = legal[move.Move]
+			moves = append(moves, move)
+		}
+
+		sort.Stable(moves)
+		pos.Moves = moves
+	}
+
+	return report
+}
@@ -0,0 +1,198 @@
+package yamlbook
+
+import (
+	"fmt"
+
+	"trollfish-lichess/fen"
+)
+
+// Health is the practical score, 0-1, of the line a book move starts for the side that
+// played it: the explorer scores or engine playout results at the book's leaves below the
+// move, backed up the tree. See Book.ScoreHealth.
+type Health struct {
+	Score    float64 `yaml:"score"`
+	Leaves   int     `yaml:"leaves"`             // leaves scored below the move
+	Playouts int     `yaml:"playouts,omitempty"` // engine games played out from them
+	TS       int64   `yaml:"ts"`
+}
+
+func (h *Health) String() string {
+	return fmt.Sprintf("health: %5.1f%% leaves: %4d playouts: %4d", h.Score*100, h.Leaves, h.Playouts)
+}
+
+// Playout returns the expected score, 0-1, of the side to move in fenKey from engine games
+// played out from it, and how many games it played.
+type Playout func(fenKey string) (float64, int, error)
+
+// ScoreHealth sets the Health of every book move reachable from fenKey and returns how many
+// moves it scored; call Save to keep them. A move leaving the book is a leaf, scored with its
+// explorer stats when it has minPracticalGames, else with playout (nil = explorer stats only,
+// leaving the moves without them unscored). A move into a book position scores 1 minus the
+// position's score for its side to move: the average health of the position's moves weighted
+// by their explorer popularity, as they're played in practice, or evenly without stats.
+func (b *Book) ScoreHealth(fenKey string, playout Playout, ts int64) (int, error) {
+	if b == nil {
+		return 0, nil
+	}
+
+	// score a copy of the tree, the playouts take minutes and the game reads the book
+	b.mu.RLock()
+	if b.posMap == nil {
+		b.mu.RUnlock()
+		return 0, nil
+	}
+	h := healthScorer{
+		positions: make(map[string]Moves, len(b.posMap)),
+		canonical: b.canonical,
+		playout:   playout,
+		ts:        ts,
+		scored:    make(map[string]*Health),
+		onPath:    make(map[string]bool),
+		health:    make(map[string]map[string]*Health),
+	}
+	for key, pos := range b.posMap {
+		h.positions[key] = append(Moves(nil), pos.Moves...)
+	}
+	key, _ := b.key(fen.Key(fenKey))
+	b.mu.RUnlock()
+
+	if _, err := h.position(key); err != nil {
+		return 0, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for key, health := range h.health {
+		pos, ok := b.posMap[key]
+		if !ok {
+			continue
+		}
+		for i, move := range pos.Moves {
+			if score, ok := health[move.Move]; ok {
+				scoredMove := *move
+				scoredMove.Health = score
+				pos.Moves[i] = &scoredMove
+			}
+		}
+	}
+	return h.moves, nil
+}
+
+type healthScorer struct {
+	positions map[string]Moves // a copy of the book's, by position key
+	canonical bool
+	playout   Playout
+	ts        int64
+	scored    map[string]*Health // by position key, for the side to move
+	onPath    map[string]bool
+	health    map[string]map[string]*Health // by position key and SAN, what ScoreHealth sets
+	moves     int
+}
+
+// position returns the health of the side to move in the book position key, nil when none
+// of its moves could be scored.
+func (h *healthScorer) position(key string) (*Health, error) {
+	if health, ok := h.scored[key]; ok {
+		return health, nil
+	}
+	moves, ok := h.positions[key]
+	if !ok {
+		return nil, nil
+	}
+	h.onPath[key] = true
+	defer delete(h.onPath, key)
+
+	health := make(map[string]*Health, len(moves))
+	h.health[key] = health
+	var scored Moves
+	for _, move := range moves {
+		if move.Move == "" {
+			continue
+		}
+		moveHealth, err := h.move(key, move)
+		if err != nil {
+			return nil, err
+		}
+		scoredMove := *move
+		scoredMove.Health = moveHealth
+		health[move.Move] = moveHealth
+		move = &scoredMove
+		if moveHealth != nil {
+			scored = append(scored, move)
+			h.moves++
+		}
+	}
+	if len(scored) == 0 {
+		h.scored[key] = nil
+		return nil, nil
+	}
+
+	var popularity float64
+	for _, move := range scored {
+		if move.Explorer != nil {
+			popularity += move.Explorer.Popularity
+		}
+	}
+
+	result := Health{TS: h.ts}
+	var total float64
+	for _, move := range scored {
+		weight := 1.0
+		if popularity > 0 {
+			weight = 0
+			if move.Explorer != nil {
+				weight = move.Explorer.Popularity
+			}
+		}
+		result.Score += move.Health.Score * weight
+		result.Leaves += move.Health.Leaves
+		result.Playouts += move.Health.Playouts
+		total += weight
+	}
+	result.Score /= total
+
+	h.scored[key] = &result
+	return &result, nil
+}
+
+// move returns the health of move in the book position key, nil if it can't be scored.
+func (h *healthScorer) move(key string, move *Move) (*Health, error) {
+	board := fen.FENtoBoard(key)
+	if err := board.MovesStrict(move.UCI()); err != nil {
+		return nil, err
+	}
+
+	if len(board.AllLegalMoves()) == 0 {
+		score := 0.5
+		if board.IsCheck() {
+			score = 1
+		}
+		return &Health{Score: score, Leaves: 1, TS: h.ts}, nil
+	}
+
+	childKey, _ := bookKey(board.FENKey(), h.canonical)
+	if h.onPath[childKey] {
+		// a repetition
+		return &Health{Score: 0.5, TS: h.ts}, nil
+	}
+	reply, err := h.position(childKey)
+	if err != nil {
+		return nil, err
+	}
+	if reply != nil {
+		return &Health{Score: 1 - reply.Score, Leaves: reply.Leaves, Playouts: reply.Playouts, TS: h.ts}, nil
+	}
+
+	if score, ok := move.practicalScore(key); ok {
+		return &Health{Score: score, Leaves: 1, TS: h.ts}, nil
+	}
+	if h.playout == nil {
+		return nil, nil
+	}
+	score, games, err := h.playout(board.FENKey())
+	if err != nil {
+		return nil, fmt.Errorf("playout '%s' in '%s': %v", move.Move, key, err)
+	}
+	return &Health{Score: 1 - score, Leaves: 1, Playouts: games, TS: h.ts}, nil
+}
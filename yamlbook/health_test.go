@@ -0,0 +1,90 @@
+package yamlbook
+
+import (
+	"math"
+	"testing"
+
+	"trollfish-lichess/fen"
+)
+
+func TestBook_ScoreHealth(t *testing.T) {
+	// arrange
+	const startKey = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq -"
+	afterE4 := fen.FENtoBoard(startKey)
+	afterE4.Moves("e2e4")
+
+	book := Book{posMap: make(map[string]*Position)}
+	book.Add(startKey,
+		&Move{Move: "e4", CP: 30},
+		&Move{Move: "d4", CP: 25},
+	)
+	book.Add(afterE4.FENKey(),
+		&Move{Move: "c5", CP: -35, Explorer: &ExplorerStats{Games: 100, White: 40, Draws: 20, Black: 40, Popularity: 75}},
+		&Move{Move: "e5", CP: -30, Explorer: &ExplorerStats{Games: 100, White: 60, Draws: 20, Black: 20, Popularity: 25}},
+	)
+
+	var playouts []string
+	playout := func(fenKey string) (float64, int, error) {
+		playouts = append(playouts, fenKey)
+		return 0.4, 4, nil // black scores 40% after d4
+	}
+
+	// act
+	scored, err := book.ScoreHealth(startKey, playout, 100)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scored != 4 {
+		t.Errorf("scored %d, want 4", scored)
+	}
+	if len(playouts) != 1 {
+		t.Errorf("playouts: got %v, want d4 only", playouts)
+	}
+
+	moves, _ := book.Get(startKey)
+	replies, _ := book.Get(afterE4.FENKey())
+	cases := []struct {
+		move          *Move
+		score         float64
+		leaves, games int
+	}{
+		{replies.GetSAN("c5"), 0.5, 1, 0},
+		{replies.GetSAN("e5"), 0.3, 1, 0},
+		{moves.GetSAN("e4"), 1 - (0.5*75+0.3*25)/100, 2, 0}, // weighted by popularity
+		{moves.GetSAN("d4"), 0.6, 1, 4},
+	}
+	for _, c := range cases {
+		h := c.move.Health
+		if h == nil || math.Abs(h.Score-c.score) > 1e-9 || h.Leaves != c.leaves || h.Playouts != c.games || h.TS != 100 {
+			t.Errorf("%s: got %+v, want score %.3f leaves %d playouts %d", c.move.Move, h, c.score, c.leaves, c.games)
+		}
+	}
+}
+
+func TestBook_ScoreHealth_ExplorerOnly(t *testing.T) {
+	// arrange
+	const startKey = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq -"
+
+	book := Book{posMap: make(map[string]*Position)}
+	book.Add(startKey,
+		&Move{Move: "e4", CP: 30, Explorer: &ExplorerStats{Games: 100, White: 50, Draws: 10, Black: 40}},
+		&Move{Move: "a4", CP: -20, Explorer: &ExplorerStats{Games: 5, White: 100}},
+	)
+
+	// act
+	scored, err := book.ScoreHealth(startKey, nil, 100)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	moves, _ := book.Get(startKey)
+	if e4 := moves.GetSAN("e4").Health; scored != 1 || e4 == nil || math.Abs(e4.Score-0.55) > 1e-9 {
+		t.Errorf("scored %d, e4 %+v, want 1 and 0.55", scored, e4)
+	}
+	if a4 := moves.GetSAN("a4").Health; a4 != nil {
+		t.Errorf("a4: got %+v, want none with too few games", a4)
+	}
+}
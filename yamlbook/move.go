@@ -8,17 +8,71 @@ import (
 )
 
 type Move struct {
-	Move   string  `yaml:"move,omitempty"`
-	Weight int     `yaml:"weight,omitempty"`
-	CP     int     `yaml:"cp"`
-	Mate   int     `yaml:"mate,omitempty"`
-	TS     int64   `yaml:"ts,omitempty"`
-	Engine *Engine `yaml:"engine,omitempty"`
-
-	uci string
+	Move   string   `yaml:"move,omitempty"`
+	Weight int      `yaml:"weight,omitempty"`
+	CP     int      `yaml:"cp"`
+	Mate   int      `yaml:"mate,omitempty"`
+	TS     int64    `yaml:"ts,omitempty"`
+	Engine *Engine  `yaml:"engine,omitempty"`
+	Tags   []string `yaml:"tags,omitempty,flow"`
+	Band   *Band    `yaml:"band,omitempty,flow"`
+	Source *Source  `yaml:"source,omitempty"`
+
+	Explorer *ExplorerStats `yaml:"explorer,omitempty"`
+	Health   *Health        `yaml:"health,omitempty"`
+
+	uci string // set by the book under its write lock, see cacheUCI
 	fen string
 }
 
+// Tags describe how a book move should be used. Any tag is allowed; these are the ones
+// the bot knows about.
+const (
+	TagTrap      = "trap"
+	TagMainline  = "mainline"
+	TagGambit    = "gambit"
+	TagHumanOnly = "human-only" // don't play against bots
+)
+
+// Band is the opponent ratings a book move is played against, set by hand, e.g. a trap
+// only below 2200 ({max: 2200}) and the solid move from there ({min: 2200}).
+type Band struct {
+	Min int `yaml:"min,omitempty"` // inclusive, 0 = no minimum
+	Max int `yaml:"max,omitempty"` // exclusive, 0 = no maximum
+}
+
+// Contains reports whether rating is in the band. A nil band contains every rating.
+func (b *Band) Contains(rating int) bool {
+	return b == nil || (b.Min == 0 || rating >= b.Min) && (b.Max == 0 || rating < b.Max)
+}
+
+func (b *Band) String() string {
+	switch {
+	case b.Min == 0 && b.Max == 0:
+		return "all"
+	case b.Min == 0:
+		return fmt.Sprintf("<%d", b.Max)
+	case b.Max == 0:
+		return fmt.Sprintf(">=%d", b.Min)
+	}
+	return fmt.Sprintf("%d-%d", b.Min, b.Max)
+}
+
+// Source is the provenance of a book move's eval.
+type Source struct {
+	Type    string `yaml:"type"` // see Source* constants
+	AddedBy string `yaml:"added_by,omitempty"`
+	Date    int64  `yaml:"date,omitempty"`
+}
+
+const (
+	SourceEngine   = "engine"
+	SourceCloud    = "cloud"
+	SourceServer   = "server" // lichess' server analysis of a game
+	SourceExplorer = "explorer"
+	SourceManual   = "manual"
+)
+
 func NewMove(boardFEN string, move Move) *Move {
 	return &Move{
 		Move:   move.Move,
@@ -27,8 +81,28 @@ func NewMove(boardFEN string, move Move) *Move {
 		Mate:   move.Mate,
 		TS:     move.TS,
 		Engine: move.Engine,
-		fen:    boardFEN,
+		Tags:   move.Tags,
+		Band:   move.Band,
+		Source: move.Source,
+
+		Explorer: move.Explorer,
+		Health:   move.Health,
+		fen:      boardFEN,
+	}
+}
+
+// Score returns the move's eval, from the pov of toMove, the side to move in its position.
+func (m *Move) Score(toMove fen.Color) fen.Score {
+	return fen.POV(m.CP, m.Mate, toMove)
+}
+
+func (m *Move) HasTag(tag string) bool {
+	for _, t := range m.Tags {
+		if t == tag {
+			return true
+		}
 	}
+	return false
 }
 
 func (m *Move) UCI() string {
@@ -54,6 +128,17 @@ func (m *Move) UCI() string {
 	return m.uci
 }
 
+// cacheUCI fills the cache UCI reads, so moves the book has published are only read. Moves
+// whose SAN doesn't parse are left for UCI to report.
+func (m *Move) cacheUCI() {
+	if m.uci != "" || m.fen == "" || m.Move == "" {
+		return
+	}
+	if uci, err := fen.FENtoBoard(m.fen).SANtoUCI(m.Move); err == nil {
+		m.uci = uci
+	}
+}
+
 func (m *Move) GetLastLogLineFor(move string) LogLine {
 	if m.Engine == nil {
 		return LogLine{}
@@ -1,6 +1,7 @@
 package yamlbook
 
 type Position struct {
-	FEN   string `yaml:"fen"`
-	Moves Moves  `yaml:"moves,omitempty"`
+	FEN    string `yaml:"fen"`
+	Review int64  `yaml:"review,omitempty"` // unix time the position was queued for re-analysis
+	Moves  Moves  `yaml:"moves,omitempty"`
 }
@@ -0,0 +1,66 @@
+package yamlbook
+
+import (
+	"fmt"
+	"sort"
+
+	"trollfish-lichess/fen"
+)
+
+// pruneKeepMoves is how many of a position's best moves PruneDominated always keeps; the game
+// analyzer re-analyzes positions with fewer than 3 moves.
+const pruneKeepMoves = 3
+
+// PruneDominated removes the moves scoring more than marginCP below the best move of their
+// position, keeping each position's pruneKeepMoves best, any move with a weight or tags,
+// which are set by hand, and any move whose line is at least as healthy as the best move's
+// (see ScoreHealth). It returns a description of each removal; call Save to keep them.
+func (b *Book) PruneDominated(marginCP int) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var report []string
+	for _, pos := range b.Positions {
+		if len(pos.Moves) <= pruneKeepMoves {
+			continue
+		}
+
+		sorted := make(Moves, len(pos.Moves))
+		copy(sorted, pos.Moves)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return moveScore(sorted[i]) > moveScore(sorted[j])
+		})
+
+		best := moveScore(sorted[0])
+		dominated := make(map[*Move]bool)
+		for _, move := range sorted[pruneKeepMoves:] {
+			if move.Weight != 0 || len(move.Tags) != 0 || best-moveScore(move) <= marginCP || healthier(move, sorted[0]) {
+				continue
+			}
+			dominated[move] = true
+			report = append(report, fmt.Sprintf("pruned '%s' in '%s': cp %d mate %d, %d cp below the best", move.Move, pos.FEN, move.CP, move.Mate, best-moveScore(move)))
+		}
+		if len(dominated) == 0 {
+			continue
+		}
+
+		moves := pos.Moves[:0]
+		for _, move := range pos.Moves {
+			if !dominated[move] {
+				moves = append(moves, move)
+			}
+		}
+		pos.Moves = moves
+	}
+	return report
+}
+
+// moveScore orders book moves by eval from the side to move's pov, mates first.
+func moveScore(m *Move) int {
+	return fen.Score{CP: m.CP, Mate: m.Mate}.Order()
+}
+
+// healthier reports whether move's line scores at least as well in practice as best's.
+func healthier(move, best *Move) bool {
+	return move.Health != nil && best.Health != nil && move.Health.Score >= best.Health.Score
+}
@@ -0,0 +1,42 @@
+package yamlbook
+
+import (
+	"testing"
+)
+
+func TestBook_PruneDominated(t *testing.T) {
+	// arrange
+	const fenKey = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq -"
+
+	book := Book{posMap: make(map[string]*Position)}
+	book.Add(fenKey,
+		&Move{Move: "e4", CP: 30, Health: &Health{Score: 0.55}},
+		&Move{Move: "d4", CP: 25},
+		&Move{Move: "c4", CP: -300},
+		&Move{Move: "Nf3", CP: 20},
+		&Move{Move: "g4", CP: -150},
+		&Move{Move: "f3", CP: -120},
+		&Move{Move: "b4", CP: -200, Tags: []string{TagTrap}},
+		&Move{Move: "a4", CP: -250, Weight: 1},
+		&Move{Move: "h4", CP: -300, Health: &Health{Score: 0.6}},
+	)
+
+	// act
+	report := book.PruneDominated(160)
+
+	// assert
+	moves, _ := book.Get(fenKey)
+	var got []string
+	for _, move := range moves {
+		got = append(got, move.Move)
+	}
+	want := []string{"a4", "e4", "d4", "Nf3", "f3", "b4", "h4"} // weighted first
+	if len(report) != 2 || len(got) != len(want) {
+		t.Fatalf("got %v, report %v, want %v", got, report, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("move %d: got %v, want %v", i+1, got, want)
+		}
+	}
+}
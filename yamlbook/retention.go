@@ -0,0 +1,146 @@
+package yamlbook
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Retention is how much engine output Save keeps on each book move; every search's lines
+// are kept forever without it, which is most of a book's size.
+type Retention struct {
+	Deepest bool // only the deepest line per first move of the PV
+	Lines   int  // only the latest lines, which the analyzer writes first, 0 = all
+}
+
+// Retain is the Retention of every book Save writes, see ParseRetention.
+var Retain Retention
+
+// ParseRetention returns the Retention of "all", "deepest", a number of lines or both, e.g.
+// "deepest,3".
+func ParseRetention(s string) (Retention, error) {
+	var r Retention
+	for _, part := range strings.Split(s, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		switch part {
+		case "", "all":
+			continue
+		case "deepest":
+			r.Deepest = true
+			continue
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return Retention{}, fmt.Errorf("'%s': want all, deepest or a number of lines", s)
+		}
+		r.Lines = n
+	}
+	return r, nil
+}
+
+func (r Retention) String() string {
+	var parts []string
+	if r.Deepest {
+		parts = append(parts, "deepest")
+	}
+	if r.Lines != 0 {
+		parts = append(parts, strconv.Itoa(r.Lines))
+	}
+	if len(parts) == 0 {
+		return "all"
+	}
+	return strings.Join(parts, ",")
+}
+
+// apply returns the engine output r keeps, in its order. The first of equally deep lines
+// for a move is kept, which is the one GetLastLogLineFor returns.
+func (r Retention) apply(output []*EngineOutput) []*EngineOutput {
+	if r.Deepest {
+		deepest := make(map[string]*EngineOutput)
+		for _, o := range output {
+			first := strings.SplitN(o.Line.PV, " ", 2)[0]
+			if d, ok := deepest[first]; !ok || o.Line.Depth > d.Line.Depth {
+				deepest[first] = o
+			}
+		}
+		kept := make([]*EngineOutput, 0, len(deepest))
+		for _, o := range output {
+			if deepest[strings.SplitN(o.Line.PV, " ", 2)[0]] == o {
+				kept = append(kept, o)
+			}
+		}
+		output = kept
+	}
+	if r.Lines != 0 && len(output) > r.Lines {
+		output = output[:r.Lines]
+	}
+	return output
+}
+
+// RetentionSizes returns the encoded size of the book file filename with all of its engine
+// output and with only what r keeps. The file isn't changed.
+func RetentionSizes(filename string, r Retention) (all, kept int, err error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return 0, 0, fmt.Errorf("'%s': %v", filename, err)
+	}
+	file, _, err := decode(b)
+	if err != nil {
+		return 0, 0, fmt.Errorf("'%s': %v", filename, err)
+	}
+
+	data, err := encode(file)
+	if err != nil {
+		return 0, 0, fmt.Errorf("'%s': %v", filename, err)
+	}
+	all = len(data)
+
+	// the decoded file is ours to change
+	for _, pos := range file.Positions {
+		for _, move := range pos.Moves {
+			if move.Engine != nil {
+				move.Engine.Output = r.apply(move.Engine.Output)
+			}
+		}
+	}
+	if data, err = encode(file); err != nil {
+		return 0, 0, fmt.Errorf("'%s': %v", filename, err)
+	}
+	return all, len(data), nil
+}
+
+// Compact drops the engine output of the book's moves that r doesn't keep and returns how
+// many lines it dropped. Load compacts the book by Retain, Save the file it writes.
+func (b *Book) Compact(r Retention) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return compact(b.Positions, r)
+}
+
+func compact(positions []*Position, r Retention) int {
+	if r == (Retention{}) {
+		return 0
+	}
+	var dropped int
+	for _, pos := range positions {
+		for i, move := range pos.Moves {
+			if move.Engine == nil {
+				continue
+			}
+			kept := r.apply(move.Engine.Output)
+			if len(kept) == len(move.Engine.Output) {
+				continue
+			}
+			dropped += len(move.Engine.Output) - len(kept)
+
+			engine := *move.Engine
+			engine.Output = kept
+			compacted := *move
+			compacted.Engine = &engine
+			pos.Moves[i] = &compacted
+		}
+	}
+	return dropped
+}
@@ -0,0 +1,128 @@
+package yamlbook
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestParseRetention(t *testing.T) {
+	cases := []struct {
+		input   string
+		want    Retention
+		wantErr bool
+	}{
+		{input: "all", want: Retention{}},
+		{input: "", want: Retention{}},
+		{input: "deepest", want: Retention{Deepest: true}},
+		{input: "3", want: Retention{Lines: 3}},
+		{input: "deepest, 2", want: Retention{Deepest: true, Lines: 2}},
+		{input: "most", wantErr: true},
+		{input: "-1", wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.input, func(t *testing.T) {
+			// act
+			got, err := ParseRetention(c.input)
+
+			// assert
+			if (err != nil) != c.wantErr {
+				t.Fatalf("err: got %v, want error %v", err, c.wantErr)
+			}
+			if got != c.want {
+				t.Errorf("got %+v, want %+v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestBook_Compact(t *testing.T) {
+	const fenKey = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq -"
+
+	lines := []LogLine{
+		{Depth: 30, CP: 35, PV: "e4 e5 Nf3"},
+		{Depth: 30, CP: 30, PV: "d4 d5"},
+		{Depth: 29, CP: 33, PV: "e4 c5"},
+		{Depth: 31, CP: 28, PV: "d4 Nf6 c4"},
+	}
+
+	cases := []struct {
+		name      string
+		retention Retention
+		wantCPs   []int
+	}{
+		{name: "all", retention: Retention{}, wantCPs: []int{35, 30, 33, 28}},
+		{name: "deepest", retention: Retention{Deepest: true}, wantCPs: []int{35, 28}},
+		{name: "latest", retention: Retention{Lines: 3}, wantCPs: []int{35, 30, 33}},
+		{name: "both", retention: Retention{Deepest: true, Lines: 1}, wantCPs: []int{35}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			engine := &Engine{ID: "sf15"}
+			for _, line := range lines {
+				engine.Log(line)
+			}
+			book := Book{posMap: make(map[string]*Position)}
+			book.Add(fenKey, &Move{Move: "e4", CP: 35, Engine: engine})
+
+			// act
+			dropped := book.Compact(c.retention)
+
+			// assert
+			if dropped != len(lines)-len(c.wantCPs) {
+				t.Errorf("dropped: got %d, want %d", dropped, len(lines)-len(c.wantCPs))
+			}
+			moves, _ := book.Get(fenKey)
+			output := moves[0].Engine.Output
+			if len(output) != len(c.wantCPs) {
+				t.Fatalf("got %d line(s), want %v", len(output), c.wantCPs)
+			}
+			for i, cp := range c.wantCPs {
+				if output[i].Line.CP != cp {
+					t.Errorf("line %d: got cp %d, want %d", i+1, output[i].Line.CP, cp)
+				}
+			}
+		})
+	}
+}
+
+func TestLoad_Retain(t *testing.T) {
+	// arrange
+	const fenKey = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq -"
+
+	engine := &Engine{ID: "sf15"}
+	engine.Log(LogLine{Depth: 30, CP: 35, PV: "e4 e5"})
+	engine.Log(LogLine{Depth: 31, CP: 33, PV: "e4 c5"})
+	engine.Log(LogLine{Depth: 29, CP: 30, PV: "d4 d5"})
+
+	filename := filepath.Join(t.TempDir(), "book.yamlbook")
+	book := Book{posMap: make(map[string]*Position), filename: filename}
+	book.Add(fenKey, &Move{Move: "e4", CP: 35, Engine: engine})
+	if err := book.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(saved Retention) { Retain = saved }(Retain)
+	Retain = Retention{Lines: 1}
+
+	// act
+	all, kept, err := RetentionSizes(filename, Retain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// assert
+	if kept >= all {
+		t.Errorf("sizes: got %d -> %d bytes, want fewer kept", all, kept)
+	}
+	moves, _ := loaded.Get(fenKey)
+	if n := len(moves[0].Engine.Output); n != 1 {
+		t.Errorf("got %d line(s) after Load, want 1", n)
+	}
+}
@@ -0,0 +1,123 @@
+package yamlbook
+
+import (
+	"bytes"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CurrentVersion is the yamlbook format version written by Save.
+//
+// Version history:
+//
+//	0: a bare list of positions (no header)
+//	1: a mapping with 'version' and 'positions'
+const CurrentVersion = 1
+
+// bookFile is the on-disk layout of a yamlbook file.
+type bookFile struct {
+	Version   int         `yaml:"version"`
+	Canonical bool        `yaml:"canonical,omitempty"` // positions with black to move are stored flipped, see Book.SetCanonical
+	Positions []*Position `yaml:"positions"`
+}
+
+// migration upgrades a document from version N to N+1. doc is the root node of the document,
+// always a mapping with 'version' and 'positions' keys from version 1 on.
+type migration func(doc *yaml.Node) (*yaml.Node, error)
+
+// migrations[N] upgrades version N to N+1. To change the format, bump CurrentVersion and add
+// a migration here; Load upgrades older files and the next Save writes the new version.
+var migrations = []migration{
+	0: migrateV0toV1,
+}
+
+func init() {
+	if len(migrations) != CurrentVersion {
+		panic(fmt.Errorf("yamlbook: %d migration(s) registered for version %d", len(migrations), CurrentVersion))
+	}
+}
+
+// decode parses a yamlbook file of any known version and returns it and the version read.
+func decode(b []byte) (bookFile, int, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return bookFile{}, 0, err
+	}
+
+	if len(doc.Content) == 0 {
+		return bookFile{Version: CurrentVersion}, CurrentVersion, nil
+	}
+
+	root := doc.Content[0]
+	version, err := documentVersion(root)
+	if err != nil {
+		return bookFile{}, 0, err
+	}
+
+	if version > CurrentVersion {
+		return bookFile{}, 0, fmt.Errorf("yamlbook version %d is newer than supported version %d", version, CurrentVersion)
+	}
+
+	for v := version; v < CurrentVersion; v++ {
+		if root, err = migrations[v](root); err != nil {
+			return bookFile{}, 0, fmt.Errorf("migrate version %d to %d: %v", v, v+1, err)
+		}
+	}
+
+	var file bookFile
+	if err := root.Decode(&file); err != nil {
+		return bookFile{}, 0, err
+	}
+
+	return file, version, nil
+}
+
+func documentVersion(root *yaml.Node) (int, error) {
+	switch root.Kind {
+	case yaml.SequenceNode:
+		return 0, nil
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(root.Content); i += 2 {
+			if root.Content[i].Value == "version" {
+				var version int
+				if err := root.Content[i+1].Decode(&version); err != nil {
+					return 0, fmt.Errorf("version: %v", err)
+				}
+				return version, nil
+			}
+		}
+		return 0, fmt.Errorf("missing version")
+	default:
+		return 0, fmt.Errorf("unexpected yaml node kind %d", root.Kind)
+	}
+}
+
+func migrateV0toV1(doc *yaml.Node) (*yaml.Node, error) {
+	return &yaml.Node{
+		Kind: yaml.MappingNode,
+		Content: []*yaml.Node{
+			{Kind: yaml.ScalarNode, Value: "version"},
+			{Kind: yaml.ScalarNode, Value: "1", Tag: "!!int"},
+			{Kind: yaml.ScalarNode, Value: "positions"},
+			doc,
+		},
+	}, nil
+}
+
+// Encode returns positions in the current yamlbook format.
+func Encode(positions []*Position) ([]byte, error) {
+	return encode(bookFile{Version: CurrentVersion, Positions: positions})
+}
+
+func encode(file bookFile) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	if err := enc.Encode(file); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
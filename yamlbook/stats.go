@@ -0,0 +1,133 @@
+package yamlbook
+
+import (
+	"fmt"
+	"math"
+
+	"trollfish-lichess/api"
+	"trollfish-lichess/fen"
+)
+
+// minPracticalGames is how many explorer games a move needs for its practical score to
+// count, see Book.BestMoveBiased.
+const minPracticalGames = 20
+
+// ExplorerStats are the lichess explorer's games with a book move, in a rating band.
+type ExplorerStats struct {
+	Games      int     `yaml:"games"`
+	White      float64 `yaml:"white"` // percent of the move's games
+	Draws      float64 `yaml:"draws"`
+	Black      float64 `yaml:"black"`
+	Popularity float64 `yaml:"popularity"` // percent of the position's games
+	Ratings    []int   `yaml:"ratings,omitempty,flow"`
+	TS         int64   `yaml:"ts"`
+}
+
+// Score is the expected score, 0-1, of the side that played the move.
+func (s *ExplorerStats) Score(white bool) float64 {
+	if white {
+		return (s.White + s.Draws/2) / 100
+	}
+	return (s.Black + s.Draws/2) / 100
+}
+
+func (s *ExplorerStats) String() string {
+	return fmt.Sprintf("games: %6d W/D/B: %4.1f/%4.1f/%4.1f%% popularity: %4.1f%%", s.Games, s.White, s.Draws, s.Black, s.Popularity)
+}
+
+// practicalScore returns the explorer score of the side to move in fenKey after move, false
+// when the move has too few games for it to mean much.
+func (m *Move) practicalScore(fenKey string) (float64, bool) {
+	if m.Explorer == nil || m.Explorer.Games < minPracticalGames {
+		return 0, false
+	}
+	return m.Explorer.Score(fen.FENtoBoard(fenKey).ActiveColor == fen.WhitePieces), true
+}
+
+// SetExplorerStats stores the explorer's results for a position on its book moves. Moves the
+// explorer has no games with get empty stats, so they aren't fetched again before they're
+// stale. It returns how many moves had games.
+func (b *Book) SetExplorerStats(fenKey string, results api.PositionResults, ratings []int, ts int64) int {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.posMap == nil {
+		return 0
+	}
+	key, flipped := b.key(fenKey)
+	pos, ok := b.posMap[key]
+	if !ok {
+		return 0
+	}
+	if flipped {
+		results.Moves = flipExplorerMoves(results.Moves, fen.Key(fenKey), key)
+	}
+
+	round := func(pct float64) float64 { return math.Round(pct*10) / 10 }
+
+	var found int
+	for i, move := range pos.Moves {
+		if move.Move == "" {
+			continue
+		}
+		stats := &ExplorerStats{Ratings: ratings, TS: ts}
+		for _, explorerMove := range results.Moves {
+			if explorerMove.SAN != move.Move {
+				continue
+			}
+			stats.Games = explorerMove.TotalGames
+			stats.White = round(explorerMove.WhitePercent)
+			stats.Draws = round(explorerMove.DrawsPercent)
+			stats.Black = round(explorerMove.BlackPercent)
+			stats.Popularity = round(explorerMove.PopularityPercent)
+			found++
+			break
+		}
+		updated := *move
+		updated.Explorer = stats
+		pos.Moves[i] = &updated
+	}
+	return found
+}
+
+// ExplorerStatsAge returns the oldest explorer stats fetch of a position's moves, 0 if any
+// move has none.
+func (b *Book) ExplorerStatsAge(fenKey string) int64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	moves, ok := b.get(fenKey)
+	if !ok {
+		return 0
+	}
+	var oldest int64
+	for _, move := range moves {
+		if move.Explorer == nil {
+			return 0
+		}
+		if oldest == 0 || move.Explorer.TS < oldest {
+			oldest = move.Explorer.TS
+		}
+	}
+	return oldest
+}
+
+// flipExplorerMoves converts the explorer's moves in fromKey to the flipped board toKey.
+func flipExplorerMoves(moves []api.Move, fromKey, toKey string) []api.Move {
+	from, to := fen.FENtoBoard(fromKey), fen.FENtoBoard(toKey)
+
+	result := make([]api.Move, 0, len(moves))
+	for _, move := range moves {
+		san, err := flipSANs(from, to, move.SAN)
+		if err != nil {
+			continue // not a move from fromKey, there's nothing to match it with
+		}
+		move.SAN = san
+		move.WhitePercent, move.BlackPercent = move.BlackPercent, move.WhitePercent
+		result = append(result, move)
+	}
+	return result
+}
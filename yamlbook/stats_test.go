@@ -0,0 +1,105 @@
+package yamlbook
+
+import (
+	"testing"
+
+	"trollfish-lichess/api"
+)
+
+func TestBook_SetExplorerStats(t *testing.T) {
+	// arrange
+	const fenKey = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq -"
+
+	book := Book{posMap: make(map[string]*Position)}
+	book.Add(fenKey,
+		&Move{Move: "e4", CP: 30},
+		&Move{Move: "h4", CP: -40},
+	)
+	results := api.PositionResults{TotalGames: 100, Moves: []api.Move{
+		{SAN: "e4", UCI: "e2e4", TotalGames: 60, WhitePercent: 51.66, DrawsPercent: 5, BlackPercent: 43.34, PopularityPercent: 60},
+		{SAN: "d4", UCI: "d2d4", TotalGames: 40, PopularityPercent: 40},
+	}}
+
+	// act
+	found := book.SetExplorerStats(fenKey, results, []int{2200, 2500}, 100)
+
+	// assert
+	if found != 1 {
+		t.Errorf("found %d, want 1", found)
+	}
+	moves, _ := book.Get(fenKey)
+	e4, h4 := moves.GetSAN("e4").Explorer, moves.GetSAN("h4").Explorer
+	if e4 == nil || e4.Games != 60 || e4.White != 51.7 || e4.Popularity != 60 || e4.TS != 100 {
+		t.Errorf("e4: got %+v", e4)
+	}
+	if h4 == nil || h4.Games != 0 || h4.TS != 100 {
+		t.Errorf("h4: got %+v, want empty stats", h4)
+	}
+	if got := book.ExplorerStatsAge(fenKey); got != 100 {
+		t.Errorf("age: got %d, want 100", got)
+	}
+}
+
+func TestBook_BestMoveBiased_ExplorerStats(t *testing.T) {
+	// arrange
+	const fenKey = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq -"
+
+	cases := []struct {
+		name  string
+		moves []*Move
+		want  []string
+	}{
+		{
+			name: "practically stronger",
+			moves: []*Move{
+				{Move: "e4", CP: 30, Explorer: &ExplorerStats{Games: 500, White: 45, Draws: 10, Black: 45}},
+				{Move: "d4", CP: 30, Explorer: &ExplorerStats{Games: 300, White: 55, Draws: 10, Black: 35}},
+				{Move: "c4", CP: 20, Explorer: &ExplorerStats{Games: 300, White: 70, Draws: 10, Black: 20}},
+			},
+			want: []string{"d4"},
+		},
+		{
+			name: "too few games",
+			moves: []*Move{
+				{Move: "e4", CP: 30, Explorer: &ExplorerStats{Games: 500, White: 45, Draws: 10, Black: 45}},
+				{Move: "d4", CP: 30, Explorer: &ExplorerStats{Games: 3, White: 100}},
+			},
+			want: []string{"e4", "d4"},
+		},
+		{
+			name: "no stats",
+			moves: []*Move{
+				{Move: "e4", CP: 30, Explorer: &ExplorerStats{Games: 500, White: 45, Draws: 10, Black: 45}},
+				{Move: "d4", CP: 30},
+			},
+			want: []string{"e4", "d4"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			book := Book{posMap: make(map[string]*Position)}
+			book.Add(fenKey, c.moves...)
+
+			picked := make(map[string]bool)
+			for i := 0; i < 50; i++ {
+				// act
+				got, _ := book.BestMoveBiased(fenKey, nil, nil)
+
+				// assert
+				if got == nil {
+					t.Fatal("no move")
+				}
+				picked[got.Move] = true
+			}
+			if len(picked) != len(c.want) {
+				t.Errorf("picked %v, want %v", picked, c.want)
+			}
+			for _, move := range c.want {
+				if !picked[move] {
+					t.Errorf("picked %v, want %v", picked, c.want)
+				}
+			}
+		})
+	}
+}
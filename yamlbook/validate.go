@@ -0,0 +1,150 @@
+package yamlbook
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"trollfish-lichess/fen"
+	"trollfish-lichess/storage"
+)
+
+// validatePOVCP is how far from 0, in cp, a move's eval and the best eval of the position it
+// leads to both have to be for Validate to call them inconsistent when they have the same
+// sign: a book eval is the side to move's, so they should be about opposite.
+const validatePOVCP = 300
+
+// Validate checks the book file filename without loading it, as Load repairs and saves. It
+// returns a description of each invalid entry: positions whose FEN isn't a legal position
+// (see fen.ValidFEN), moves that aren't legal SAN in their position, and move evals from
+// the wrong side's pov, e.g. a mating move without mate 1.
+//
+// With quarantine the invalid positions and moves are moved to <name>.quarantine.yamlbook,
+// the rest of their positions staying in the book, which is backed up first.
+func Validate(filename string, quarantine bool) ([]string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("'%s': %v", filename, err)
+	}
+	file, _, err := decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("'%s': %v", filename, err)
+	}
+
+	var report []string
+	valid := make([]*Position, 0, len(file.Positions))
+	var bad []*Position
+
+	posMap := make(map[string]*Position, len(file.Positions))
+	for _, pos := range file.Positions {
+		if fen.ValidFEN(pos.FEN) == nil {
+			posMap[fen.Key(pos.FEN)] = pos
+		}
+	}
+	book := Book{posMap: posMap, canonical: file.Canonical}
+
+	for _, pos := range file.Positions {
+		if err := fen.ValidFEN(pos.FEN); err != nil {
+			report = append(report, err.Error())
+			bad = append(bad, pos)
+			continue
+		}
+
+		var badMoves Moves
+		moves := make(Moves, 0, len(pos.Moves))
+		for _, move := range pos.Moves {
+			if problem := book.validateMove(pos.FEN, move); problem != "" {
+				report = append(report, fmt.Sprintf("'%s' in '%s': %s", move.Move, pos.FEN, problem))
+				badMoves = append(badMoves, move)
+				continue
+			}
+			moves = append(moves, move)
+		}
+		if len(badMoves) != 0 {
+			bad = append(bad, &Position{FEN: pos.FEN, Review: pos.Review, Moves: badMoves})
+			pos.Moves = moves
+		}
+		valid = append(valid, pos)
+	}
+
+	if !quarantine || len(bad) == 0 {
+		return report, nil
+	}
+
+	quarantined, err := encode(bookFile{Version: CurrentVersion, Canonical: file.Canonical, Positions: bad})
+	if err != nil {
+		return nil, err
+	}
+	quarantineFilename := strings.TrimSuffix(filename, filepath.Ext(filename)) + ".quarantine.yamlbook"
+	if err := storage.WriteFileAtomic(quarantineFilename, quarantined); err != nil {
+		return nil, fmt.Errorf("write file '%s': %v", quarantineFilename, err)
+	}
+
+	kept, err := encode(bookFile{Version: CurrentVersion, Canonical: file.Canonical, Positions: valid})
+	if err != nil {
+		return nil, err
+	}
+	ext := filepath.Ext(filename)
+	backupFilename := fmt.Sprintf("%s-%d%s.backup", strings.TrimSuffix(filename, ext), time.Now().UnixMilli(), ext)
+	if err := os.WriteFile(backupFilename, data, 0644); err != nil {
+		return nil, fmt.Errorf("error creating backup file '%s': %v", backupFilename, err)
+	}
+	if err := storage.WriteFileAtomic(filename, kept); err != nil {
+		return nil, fmt.Errorf("write file '%s': %v", filename, err)
+	}
+
+	return report, nil
+}
+
+// validateMove returns what's wrong with move in the position fenKey, or "" if nothing is.
+func (b *Book) validateMove(fenKey string, move *Move) string {
+	if move.Move == "" {
+		return ""
+	}
+
+	board := fen.FENtoBoard(fenKey)
+	uci, err := board.SANtoUCI(move.Move)
+	if err != nil {
+		return err.Error()
+	}
+	if band := move.Band; band != nil && (band.Min < 0 || band.Max < 0 || band.Max != 0 && band.Min >= band.Max) {
+		return fmt.Sprintf("band min %d max %d is empty", band.Min, band.Max)
+	}
+
+	if err := board.MovesStrict(uci); err != nil {
+		return err.Error()
+	}
+	mates := board.IsMate()
+	switch {
+	case mates && move.Mate != 1 && (move.CP != 0 || move.Mate != 0): // 0 0 is no eval
+		return fmt.Sprintf("mates, but the eval is cp %d mate %d, want mate 1", move.CP, move.Mate)
+	case !mates && move.Mate == 1:
+		return "mate 1, but it doesn't mate"
+	case mates:
+		return ""
+	}
+
+	key, _ := b.key(board.FENKey())
+	next, ok := b.posMap[key]
+	if !ok {
+		return ""
+	}
+	var best *Move
+	for _, m := range next.Moves {
+		if m.Move != "" && (best == nil || moveScore(m) > moveScore(best)) {
+			best = m
+		}
+	}
+	if best == nil {
+		return ""
+	}
+
+	score, reply := moveScore(move), moveScore(best)
+	if (score >= validatePOVCP && reply >= validatePOVCP) || (score <= -validatePOVCP && reply <= -validatePOVCP) {
+		return fmt.Sprintf("cp %d mate %d, but the best reply %s has cp %d mate %d for the other side; one of them is from the wrong pov",
+			move.CP, move.Mate, best.Move, best.CP, best.Mate)
+	}
+	return ""
+}
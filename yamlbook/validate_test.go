@@ -0,0 +1,75 @@
+package yamlbook
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	// arrange
+	const (
+		start   = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq -"
+		afterE4 = "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq -"
+		mateIn1 = "6k1/5ppp/8/8/8/8/8/R5K1 w - -"
+	)
+	data := `version: 1
+positions:
+  - fen: ` + start + `
+    moves:
+      - move: e4
+        cp: 400
+      - move: Ke2
+        cp: 0
+      - move: d4
+        cp: 30
+  - fen: ` + afterE4 + `
+    moves:
+      - move: c5
+        cp: 350
+  - fen: ` + mateIn1 + `
+    moves:
+      - move: Ra8#
+        cp: 900
+  - fen: 8/8/8/8/8/8/8/8 w - -
+`
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "book.yamlbook")
+	if err := os.WriteFile(filename, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	report, err := Validate(filename, true)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report) != 4 {
+		t.Fatalf("got %d problems, want 4 (e4 pov, Ke2, Ra8#, no kings):\n%s", len(report), strings.Join(report, "\n"))
+	}
+	if all := strings.Join(report, "\n"); !strings.Contains(all, "'Ke2' is not a valid move") {
+		t.Errorf("got:\n%s\nwant Ke2 reported as not a valid move", all)
+	}
+
+	book, err := Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moves, _ := book.Get(start); len(moves) != 1 || moves[0].Move != "d4" {
+		t.Errorf("start: got %v, want d4 kept", moves)
+	}
+	if book.PosCount() != 3 {
+		t.Errorf("got %d positions, want 3", book.PosCount())
+	}
+
+	quarantined, err := Load(filepath.Join(dir, "book.quarantine.yamlbook"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quarantined.PosCount() != 3 {
+		t.Errorf("quarantine: got %d positions, want 3", quarantined.PosCount())
+	}
+}